@@ -18,6 +18,8 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"flag"
 	"fmt"
@@ -25,10 +27,12 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/patrickglass/dsql/server"
+	"github.com/patrickglass/dsql/sql/engine"
 
 	"github.com/kelseyhightower/envconfig"
 	"github.com/mattn/go-isatty"
@@ -46,6 +50,12 @@ type Specification struct {
 	PrivateKeyFile  string `default:"./server.key"`
 	Port            int    `default:"5432"`
 	MetricsPort     int    `default:"5480"`
+	AuthMethod      string `default:"scram-sha-256"`
+	UsersFile       string
+	Users           string
+	HBAFile         string
+	IdentFile       string
+	ClientCAFile    string
 }
 
 func init() {
@@ -67,13 +77,133 @@ func configureGlobalLogger() {
 	}
 }
 
+// tlsOptions loads the key pair from the files in the specification. TLS
+// is required unless running in development mode, where it is optional and
+// skipped entirely when the files do not exist.
+func tlsOptions(s Specification) ([]server.Option, error) {
+	if s.DevelopmentMode {
+		_, errPub := os.Stat(s.PublicKeyFile)
+		_, errPriv := os.Stat(s.PrivateKeyFile)
+		if errors.Is(errPub, os.ErrNotExist) && errors.Is(errPriv, os.ErrNotExist) {
+			log.Warn().Msg("development mode: tls is disabled as no key pair was found")
+			return []server.Option{server.WithTLSMode(server.TLSDisable)}, nil
+		}
+	} else if s.PublicKeyFile == "" || s.PrivateKeyFile == "" {
+		return nil, errors.New("DSQL_PUBLICKEYFILE and DSQL_PRIVATEKEYFILE must be set unless in development mode")
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.PublicKeyFile, s.PrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load key pair from %s and %s: %w", s.PublicKeyFile, s.PrivateKeyFile, err)
+	}
+	log.Info().Str("cert", s.PublicKeyFile).Str("key", s.PrivateKeyFile).Msg("loaded tls key pair")
+
+	mode := server.TLSRequire
+	if s.DevelopmentMode {
+		mode = server.TLSAllow
+	}
+	opts := []server.Option{server.WithTLSCert(cert), server.WithTLSMode(mode)}
+
+	if s.ClientCAFile != "" {
+		pem, err := os.ReadFile(s.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read client CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA bundle %s", s.ClientCAFile)
+		}
+		// without access rules every client must present a certificate when
+		// it is the only auth method
+		required := s.HBAFile == "" && s.AuthMethod == string(server.AuthCert)
+		opts = append(opts, server.WithClientCAs(pool, required))
+		log.Info().Str("file", s.ClientCAFile).Bool("required", required).Msg("client certificate verification enabled")
+	}
+	return opts, nil
+}
+
+// authOptions configures authentication from the access rules file when
+// DSQL_HBAFILE is set, otherwise every client uses DSQL_AUTHMETHOD.
+func authOptions(s Specification) ([]server.Option, error) {
+	if s.HBAFile == "" {
+		auth, err := authenticator(s)
+		if err != nil {
+			return nil, err
+		}
+		return []server.Option{server.WithAuthenticator(auth)}, nil
+	}
+
+	hba, err := server.LoadHBAFile(s.HBAFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load access rules: %w", err)
+	}
+	users, err := loadUsers(s)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Int("rules", len(hba.Rules)).Int("users", len(users)).Msg("access rules loaded")
+	opts := []server.Option{server.WithHBA(hba), server.WithUsers(users)}
+
+	if s.IdentFile != "" {
+		maps, err := server.LoadIdentFile(s.IdentFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load ident maps: %w", err)
+		}
+		opts = append(opts, server.WithIdentMaps(maps))
+	}
+	return opts, nil
+}
+
+// loadUsers reads the users from DSQL_USERSFILE and DSQL_USERS, the latter
+// holding comma separated name:secret pairs.
+func loadUsers(s Specification) (server.StaticUsers, error) {
+	users := server.StaticUsers{}
+	if s.UsersFile != "" {
+		fileUsers, err := server.LoadUsersFile(s.UsersFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load users: %w", err)
+		}
+		users = fileUsers
+	}
+	envUsers, err := server.ParseUsers(strings.NewReader(strings.ReplaceAll(s.Users, ",", "\n")))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse DSQL_USERS: %w", err)
+	}
+	for name, secret := range envUsers {
+		users[name] = secret
+	}
+	return users, nil
+}
+
+// authenticator uses DSQL_AUTHMETHOD for every client. Without any users
+// every client is trusted, which is only allowed in development mode.
+func authenticator(s Specification) (server.Authenticator, error) {
+	if server.AuthMethod(s.AuthMethod) == server.AuthCert {
+		return server.NewAuthenticator(server.AuthCert, nil)
+	}
+
+	users, err := loadUsers(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		if !s.DevelopmentMode {
+			return nil, errors.New("DSQL_USERSFILE or DSQL_USERS must be set unless in development mode")
+		}
+		log.Warn().Msg("development mode: no users configured, all clients are trusted")
+		return server.NewAuthenticator(server.AuthTrust, nil)
+	}
+	log.Info().Int("users", len(users)).Str("method", s.AuthMethod).Msg("password authentication enabled")
+	return server.NewAuthenticator(server.AuthMethod(s.AuthMethod), users)
+}
+
 func StartServer(s Specification) error {
 	httpServerExitDone := &sync.WaitGroup{}
 
-	if s.DevelopmentMode && s.PrivateKeyFile == "" && s.PublicKeyFile == "" {
-		msg := "DSQL_PRIVATEKEY and DSQL_PUBLICKEY must be set unless in Development mode"
-		log.Fatal().Msg(msg)
-		return errors.New(msg)
+	tlsOpts, err := tlsOptions(s)
+	if err != nil {
+		log.Error().Err(err).Msg("could not configure tls")
+		return err
 	}
 
 	// Start the prometheus server
@@ -92,20 +222,16 @@ func StartServer(s Specification) error {
 	}()
 	log.Info().Int("port", s.MetricsPort).Msg("prometheus metrics started")
 
-	// cert, err := tls.LoadX509KeyPair(s.PublicKeyFile, s.PrivateKeyFile)
-	// if err != nil {
-	// 	log.Fatal().Err(err).Msg("could not parse certificates as PEM files")
-	// }
-	// cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
-
-	// listener, err := tls.Listen("tcp", s.Port, cfg)
-	// if err != nil {
-	// 	log.Fatal().Err(err).Msg("could not start listener")
-	// }
+	authOpts, err := authOptions(s)
+	if err != nil {
+		log.Error().Err(err).Msg("could not configure authentication")
+		return err
+	}
 
-	sqlServer, err := server.New(
+	sqlServer, err := server.New(append(append(tlsOpts, authOpts...),
 		server.WithPort(s.Port),
-	)
+		server.WithHandler(engine.New()),
+	)...)
 	if err != nil {
 		log.Error().Err(err).Msg("could not initialize server")
 		return err
@@ -117,7 +243,7 @@ func StartServer(s Specification) error {
 	go func() {
 		defer httpServerExitDone.Done()
 		err = sqlServer.Serve()
-		if err != nil {
+		if err != nil && err != server.ErrServerClosed {
 			log.Fatal().Err(err).Msg("could not start server")
 		}
 	}()
@@ -170,14 +296,86 @@ func cmdServer() error {
 		Str("PrivateKeyFile", s.PrivateKeyFile).
 		Int("Port", s.Port).
 		Int("MetricsPort", s.MetricsPort).
+		Str("AuthMethod", s.AuthMethod).
+		Str("UsersFile", s.UsersFile).
+		Str("HBAFile", s.HBAFile).
+		Str("IdentFile", s.IdentFile).
+		Str("ClientCAFile", s.ClientCAFile).
 		Msg("dsql configuration")
 
 	return StartServer(s)
 }
 
-func cmdCertGen() error {
-	pub, priv := server.GenKey()
-	_, _ = pub, priv
+func cmdCertGen(args []string) error {
+	var s Specification
+
+	fs := flag.NewFlagSet("gencert", flag.ContinueOnError)
+	hosts := fs.String("hosts", "localhost,127.0.0.1,::1", "comma separated hostnames and IPs the certificate is valid for")
+	validFor := fs.Duration("valid-for", 365*24*time.Hour, "duration the certificate is valid for")
+	algorithm := fs.String("algorithm", string(server.KeyEd25519), "key algorithm, one of ed25519, ecdsa-p256 or rsa")
+	withCA := fs.Bool("ca", false, "issue a certificate authority and sign the server certificate with it")
+	caCertFile := fs.String("ca-cert", "./ca.pem", "path the CA certificate is written to when -ca is set")
+	caKeyFile := fs.String("ca-key", "./ca.key", "path the CA private key is written to when -ca is set")
+	force := fs.Bool("force", false, "overwrite existing files")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	err := envconfig.Process("dsql", &s)
+	if err != nil {
+		return fmt.Errorf("could not process environment variables: %w", err)
+	}
+
+	opts := server.CertOptions{
+		Hosts:     strings.Split(*hosts, ","),
+		ValidFor:  *validFor,
+		Algorithm: server.KeyAlgorithm(*algorithm),
+	}
+	files := []string{s.PublicKeyFile, s.PrivateKeyFile}
+	if *withCA {
+		files = append(files, *caCertFile, *caKeyFile)
+	}
+	if !*force {
+		for _, f := range files {
+			if _, err := os.Stat(f); err == nil {
+				return fmt.Errorf("%s already exists, use -force to replace it", f)
+			}
+		}
+	}
+
+	// everything is generated before any file is written, -force only
+	// replaces the files written by this run
+	var ca *server.Certificate
+	if *withCA {
+		ca, err = server.GenerateCA(opts)
+		if err != nil {
+			return err
+		}
+	}
+	cert, err := server.GenerateCert(opts, ca)
+	if err != nil {
+		return err
+	}
+
+	write := (*server.Certificate).WriteFiles
+	if *force {
+		write = (*server.Certificate).ReplaceFiles
+	}
+	if ca != nil {
+		if err := write(ca, *caCertFile, *caKeyFile); err != nil {
+			return err
+		}
+		log.Info().Str("cert", *caCertFile).Str("key", *caKeyFile).Msg("wrote certificate authority")
+	}
+	if err := write(cert, s.PublicKeyFile, s.PrivateKeyFile); err != nil {
+		return err
+	}
+	log.Info().
+		Str("cert", s.PublicKeyFile).
+		Str("key", s.PrivateKeyFile).
+		Strs("hosts", opts.Hosts).
+		Time("not_after", cert.Cert.NotAfter).
+		Msg("wrote server certificate")
 	return nil
 }
 
@@ -186,7 +384,7 @@ func main() {
 	configureGlobalLogger()
 
 	flag.Parse()
-	if flag.NArg() != 1 {
+	if flag.NArg() < 1 {
 		log.Fatal().Msg("must specify a command as the first argument")
 		flag.Usage()
 		os.Exit(1)
@@ -200,7 +398,7 @@ func main() {
 			os.Exit(1)
 		}
 	case "gencert":
-		err := cmdCertGen()
+		err := cmdCertGen(flag.Args()[1:])
 		if err != nil {
 			log.Error().Err(err).Msgf("certificate generation failed")
 			os.Exit(1)
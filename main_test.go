@@ -15,3 +15,117 @@
 // specific language governing permissions and limitations
 // under the License.
 package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/patrickglass/dsql/server"
+	"github.com/patrickglass/dsql/sql/engine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngineSupportsCopy(t *testing.T) {
+	var h server.Handler = engine.New()
+	_, ok := h.(server.CopyHandler)
+	assert.True(t, ok)
+}
+
+// writeKeyPair generates a certificate into dir and returns the file names
+func writeKeyPair(t *testing.T, dir, name string) (string, string) {
+	c, err := server.GenerateCert(server.CertOptions{Hosts: []string{"localhost"}}, nil)
+	require.NoError(t, err)
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, c.WriteFiles(certFile, keyFile))
+	return certFile, keyFile
+}
+
+func TestTLSOptions(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir, "server")
+	_, otherKeyFile := writeKeyPair(t, dir, "other")
+	missing := filepath.Join(dir, "missing")
+
+	opts, err := tlsOptions(Specification{PublicKeyFile: certFile, PrivateKeyFile: keyFile})
+	assert.NoError(t, err)
+	assert.Len(t, opts, 2)
+
+	_, err = tlsOptions(Specification{PublicKeyFile: missing, PrivateKeyFile: missing})
+	assert.Error(t, err, "tls is required outside of development mode")
+
+	_, err = tlsOptions(Specification{PublicKeyFile: certFile, PrivateKeyFile: otherKeyFile})
+	assert.Error(t, err, "mismatched key pair")
+
+	opts, err = tlsOptions(Specification{DevelopmentMode: true, PublicKeyFile: missing, PrivateKeyFile: missing})
+	assert.NoError(t, err)
+	assert.Len(t, opts, 1)
+
+	_, err = tlsOptions(Specification{DevelopmentMode: true, PublicKeyFile: certFile, PrivateKeyFile: otherKeyFile})
+	assert.Error(t, err, "existing files must still be valid in development mode")
+}
+
+func TestAuthenticator(t *testing.T) {
+	auth, err := authenticator(Specification{AuthMethod: "md5", Users: "alice:secret,bob:other"})
+	require.NoError(t, err)
+	assert.Equal(t, server.AuthMD5, auth.Method())
+
+	_, err = authenticator(Specification{AuthMethod: "bogus", Users: "alice:secret"})
+	assert.Error(t, err)
+
+	_, err = authenticator(Specification{AuthMethod: "md5"})
+	assert.Error(t, err, "users are required outside of development mode")
+
+	auth, err = authenticator(Specification{DevelopmentMode: true})
+	require.NoError(t, err)
+	assert.Equal(t, server.AuthTrust, auth.Method())
+}
+
+func TestAuthOptions(t *testing.T) {
+	opts, err := authOptions(Specification{DevelopmentMode: true})
+	require.NoError(t, err)
+	assert.Len(t, opts, 1)
+
+	hbaFile := filepath.Join(t.TempDir(), "hba.conf")
+	require.NoError(t, os.WriteFile(hbaFile, []byte("host all all all md5\n"), 0600))
+	opts, err = authOptions(Specification{HBAFile: hbaFile, Users: "alice:secret"})
+	require.NoError(t, err)
+	assert.Len(t, opts, 2)
+
+	_, err = authOptions(Specification{HBAFile: filepath.Join(t.TempDir(), "missing")})
+	assert.Error(t, err)
+}
+
+func TestCertGenForce(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir, "server")
+	caCertFile, caKeyFile := writeKeyPair(t, dir, "ca")
+	t.Setenv("DSQL_PUBLICKEYFILE", certFile)
+	t.Setenv("DSQL_PRIVATEKEYFILE", keyFile)
+	read := func(name string) []byte {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		return data
+	}
+	cert, caCert := read(certFile), read(caCertFile)
+
+	assert.Error(t, cmdCertGen(nil), "existing files are kept without -force")
+	assert.Equal(t, cert, read(certFile))
+
+	// a failure leaves the existing files in place
+	assert.Error(t, cmdCertGen([]string{"-force", "-algorithm", "dsa"}))
+	assert.Equal(t, cert, read(certFile))
+
+	// only the server certificate is replaced when -ca is not set
+	caArgs := []string{"-ca-cert", caCertFile, "-ca-key", caKeyFile}
+	require.NoError(t, cmdCertGen(append([]string{"-force"}, caArgs...)))
+	assert.NotEqual(t, cert, read(certFile))
+	assert.Equal(t, caCert, read(caCertFile))
+	_, err := tlsOptions(Specification{PublicKeyFile: certFile, PrivateKeyFile: keyFile})
+	assert.NoError(t, err)
+
+	require.NoError(t, cmdCertGen(append([]string{"-force", "-ca"}, caArgs...)))
+	assert.NotEqual(t, caCert, read(caCertFile))
+}
@@ -0,0 +1,334 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/pgerror"
+)
+
+// AuthMethod names the way a client proves its identity, the names match
+// the ones used in pg_hba.conf.
+type AuthMethod string
+
+const (
+	AuthTrust       AuthMethod = "trust"
+	AuthReject      AuthMethod = "reject"
+	AuthPassword    AuthMethod = "password"
+	AuthMD5         AuthMethod = "md5"
+	AuthSCRAMSHA256 AuthMethod = "scram-sha-256"
+)
+
+// AuthConn is the view of a client connection given to an Authenticator
+type AuthConn interface {
+	// User is the role named in the StartupMessage
+	User() string
+	// Database is the database named in the StartupMessage
+	Database() string
+	// RemoteAddr is the address of the client
+	RemoteAddr() net.Addr
+	// TLSState returns nil when the connection is not encrypted
+	TLSState() *tls.ConnectionState
+	// ServerEndPoint returns the tls-server-end-point channel binding data
+	// or nil when the connection is not encrypted
+	ServerEndPoint() []byte
+	// Send writes an authentication request to the client
+	Send(msg pgproto3.BackendMessage) error
+	// Receive reads the response to an authentication request of authType
+	Receive(authType uint32) (pgproto3.FrontendMessage, error)
+}
+
+// Authenticator verifies the identity of a client during startup, before
+// AuthenticationOk is sent.
+type Authenticator interface {
+	// Method is reported in logs and metrics
+	Method() AuthMethod
+	// Authenticate exchanges messages with the client and returns an error
+	// when its identity could not be verified. An *Error is reported to the
+	// client as is, any other error as a failed password authentication.
+	Authenticate(conn AuthConn) error
+}
+
+// NewAuthenticator returns the authenticator for one of the built in
+// methods. The password based methods look up the secrets in users.
+func NewAuthenticator(method AuthMethod, users UserStore) (Authenticator, error) {
+	switch method {
+	case AuthTrust:
+		return trustAuth{}, nil
+	case AuthReject:
+		return rejectAuth{}, nil
+	case AuthPassword, AuthMD5, AuthSCRAMSHA256:
+		if users == nil {
+			return nil, fmt.Errorf("auth method %s requires a user store", method)
+		}
+		return &passwordAuth{method: method, users: users}, nil
+	case AuthCert:
+		return NewCertAuthenticator(nil, "")
+	default:
+		return nil, fmt.Errorf("unsupported auth method: %q", method)
+	}
+}
+
+type trustAuth struct{}
+
+func (trustAuth) Method() AuthMethod               { return AuthTrust }
+func (trustAuth) Authenticate(conn AuthConn) error { return nil }
+
+type rejectAuth struct{}
+
+func (rejectAuth) Method() AuthMethod { return AuthReject }
+func (rejectAuth) Authenticate(conn AuthConn) error {
+	return pgerror.NewError(pgerror.CodeInvalidAuthorizationSpecification, "connection rejected for user \"%s\"", conn.User())
+}
+
+// errPasswordFailed is returned when the client sent the wrong password
+var errPasswordFailed = errors.New("password authentication failed")
+
+// passwordAuth implements the cleartext, md5 and scram-sha-256 methods
+type passwordAuth struct {
+	method AuthMethod
+	users  UserStore
+}
+
+func (a *passwordAuth) Method() AuthMethod { return a.method }
+
+func (a *passwordAuth) Authenticate(conn AuthConn) error {
+	// unknown users still go through the exchange so that clients cannot
+	// tell them apart from a wrong password, an empty secret means the user
+	// has no password and cannot log in with one
+	secret, ok := a.users.Secret(conn.User())
+	ok = ok && secret != ""
+
+	method := a.method
+	// like postgres the md5 method switches to scram when the stored secret
+	// can only be verified with scram
+	if method == AuthMD5 && strings.HasPrefix(secret, scramPrefix) {
+		method = AuthSCRAMSHA256
+	}
+
+	var err error
+	switch method {
+	case AuthPassword:
+		err = authCleartext(conn, secret)
+	case AuthMD5:
+		err = authMD5(conn, secret)
+	case AuthSCRAMSHA256:
+		err = authSCRAM(conn, secret)
+	}
+	if err == nil && !ok {
+		err = errPasswordFailed
+	}
+	return err
+}
+
+func authCleartext(conn AuthConn, secret string) error {
+	if err := conn.Send(&pgproto3.AuthenticationCleartextPassword{}); err != nil {
+		return err
+	}
+	msg, err := conn.Receive(pgproto3.AuthTypeCleartextPassword)
+	if err != nil {
+		return err
+	}
+	pw, ok := msg.(*pgproto3.PasswordMessage)
+	if !ok {
+		return pgerror.NewError(pgerror.CodeProtocolViolation, "expected password response, got %T", msg)
+	}
+	if !verifyPassword(conn.User(), pw.Password, secret) {
+		return errPasswordFailed
+	}
+	return nil
+}
+
+func authMD5(conn AuthConn, secret string) error {
+	var salt [4]byte
+	if _, err := rand.Read(salt[:]); err != nil {
+		return err
+	}
+	if err := conn.Send(&pgproto3.AuthenticationMD5Password{Salt: salt}); err != nil {
+		return err
+	}
+	msg, err := conn.Receive(pgproto3.AuthTypeMD5Password)
+	if err != nil {
+		return err
+	}
+	pw, ok := msg.(*pgproto3.PasswordMessage)
+	if !ok {
+		return pgerror.NewError(pgerror.CodeProtocolViolation, "expected password response, got %T", msg)
+	}
+
+	hash := secret
+	if !strings.HasPrefix(secret, md5Prefix) {
+		hash = MD5Secret(conn.User(), secret)
+	}
+	expected := md5Prefix + md5Hex(hash[len(md5Prefix):]+string(salt[:]))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(pw.Password)) != 1 {
+		return errPasswordFailed
+	}
+	return nil
+}
+
+// verifyPassword checks a cleartext password against a stored secret in
+// any of the supported formats.
+func verifyPassword(user, password, secret string) bool {
+	var expected, actual string
+	switch {
+	case secret == "":
+		return false
+	case strings.HasPrefix(secret, md5Prefix):
+		expected, actual = secret, MD5Secret(user, password)
+	case strings.HasPrefix(secret, scramPrefix):
+		s, err := parseSCRAMSecret(secret)
+		if err != nil {
+			return false
+		}
+		stored, _ := scramKeys(password, s.salt, s.iterations)
+		expected, actual = string(s.storedKey), string(stored)
+	default:
+		expected, actual = secret, password
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) == 1
+}
+
+const md5Prefix = "md5"
+
+// MD5Secret returns the md5 hashed password in the format used by postgres
+func MD5Secret(user, password string) string {
+	return md5Prefix + md5Hex(password+user)
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// UserStore holds the secrets the password based auth methods verify
+// against. A secret is either the cleartext password, an md5 hash as
+// returned by MD5Secret or a SCRAM-SHA-256 verifier as returned by
+// SCRAMSecret.
+type UserStore interface {
+	Secret(user string) (secret string, ok bool)
+}
+
+// StaticUsers is a UserStore mapping user names to their secret
+type StaticUsers map[string]string
+
+func (u StaticUsers) Secret(user string) (string, bool) {
+	s, ok := u[user]
+	return s, ok
+}
+
+// ParseUsers reads users in the format "name:secret", one per line. Blank
+// lines and lines starting with # are ignored, the secret must not be
+// empty.
+func ParseUsers(r io.Reader) (StaticUsers, error) {
+	users := StaticUsers{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, secret, ok := cut(text, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("line %d: expected name:secret", line)
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("line %d: empty secret for user %q", line, name)
+		}
+		users[name] = secret
+	}
+	return users, scanner.Err()
+}
+
+// LoadUsersFile reads the users from a file, see ParseUsers for the format
+func LoadUsersFile(path string) (StaticUsers, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	users, err := ParseUsers(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return users, nil
+}
+
+// cut slices s around the first instance of sep
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// authConn exposes the backend to authenticators
+type authConn struct {
+	b *DataQueryBackend
+}
+
+func (c authConn) User() string         { return c.b.user }
+func (c authConn) Database() string     { return c.b.database }
+func (c authConn) RemoteAddr() net.Addr { return c.b.conn.RemoteAddr() }
+
+func (c authConn) TLSState() *tls.ConnectionState {
+	if conn, ok := c.b.conn.(*tls.Conn); ok {
+		state := conn.ConnectionState()
+		return &state
+	}
+	return nil
+}
+
+func (c authConn) ServerEndPoint() []byte {
+	// the first certificate is the one served, the server does not select
+	// certificates by SNI
+	if !c.b.isTLS() || len(c.b.tlsConfig.Certificates) == 0 {
+		return nil
+	}
+	chain := c.b.tlsConfig.Certificates[0].Certificate
+	if len(chain) == 0 {
+		return nil
+	}
+	return serverEndPoint(chain[0])
+}
+
+func (c authConn) Send(msg pgproto3.BackendMessage) error {
+	return c.b.sendNow(msg)
+}
+
+func (c authConn) Receive(authType uint32) (pgproto3.FrontendMessage, error) {
+	if err := c.b.backend.SetAuthType(authType); err != nil {
+		return nil, err
+	}
+	return c.b.backend.Receive()
+}
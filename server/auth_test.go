@@ -0,0 +1,253 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sendStartup sends a StartupMessage for the user and returns the frontend
+func sendStartup(t *testing.T, conn net.Conn, user string) *pgproto3.Frontend {
+	f := pgproto3.NewFrontend(pgproto3.NewChunkReader(conn), conn)
+	require.NoError(t, f.Send(&pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters:      map[string]string{"user": user},
+	}))
+	return f
+}
+
+// expectAuthResult reads the outcome of authentication, nil when the
+// client was accepted
+func expectAuthResult(t *testing.T, f *pgproto3.Frontend) *pgproto3.ErrorResponse {
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	switch msg := msg.(type) {
+	case *pgproto3.AuthenticationOk:
+		return nil
+	case *pgproto3.ErrorResponse:
+		return msg
+	default:
+		t.Fatalf("unexpected message %T", msg)
+		return nil
+	}
+}
+
+func withAuth(t *testing.T, method AuthMethod, users StaticUsers) func(*DataQueryBackend) {
+	auth, err := NewAuthenticator(method, users)
+	require.NoError(t, err)
+	return func(b *DataQueryBackend) { b.auth = auth }
+}
+
+func TestParseUsers(t *testing.T) {
+	users, err := ParseUsers(strings.NewReader("# comment\n\nalice:secret\nbob:SCRAM-SHA-256$4096:c2FsdA==$a:b\n"))
+	require.NoError(t, err)
+	assert.Equal(t, StaticUsers{"alice": "secret", "bob": "SCRAM-SHA-256$4096:c2FsdA==$a:b"}, users)
+
+	_, err = ParseUsers(strings.NewReader("nosecret\n"))
+	assert.Error(t, err)
+	_, err = ParseUsers(strings.NewReader("alice:\n"))
+	assert.Error(t, err)
+}
+
+func TestVerifyPassword(t *testing.T) {
+	scram, err := SCRAMSecret("secret")
+	require.NoError(t, err)
+	for _, secret := range []string{"secret", MD5Secret("alice", "secret"), scram} {
+		assert.True(t, verifyPassword("alice", "secret", secret), secret)
+		assert.False(t, verifyPassword("alice", "wrong", secret), secret)
+	}
+	assert.False(t, verifyPassword("alice", "", ""))
+}
+
+func TestAuth_Cleartext(t *testing.T) {
+	conn := dialBackend(t, echoHandler{}, withAuth(t, AuthPassword, StaticUsers{"alice": "secret"}))
+	f := sendStartup(t, conn, "alice")
+
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.AuthenticationCleartextPassword{}, msg)
+	require.NoError(t, f.Send(&pgproto3.PasswordMessage{Password: "secret"}))
+	assert.Nil(t, expectAuthResult(t, f))
+}
+
+func TestAuth_MD5(t *testing.T) {
+	conn := dialBackend(t, echoHandler{}, withAuth(t, AuthMD5, StaticUsers{"alice": MD5Secret("alice", "secret")}))
+	f := sendStartup(t, conn, "alice")
+
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.AuthenticationMD5Password{}, msg)
+	salt := msg.(*pgproto3.AuthenticationMD5Password).Salt
+	password := "md5" + md5Hex(md5Hex("secretalice")+string(salt[:]))
+	require.NoError(t, f.Send(&pgproto3.PasswordMessage{Password: password}))
+	assert.Nil(t, expectAuthResult(t, f))
+}
+
+func TestAuth_WrongPassword(t *testing.T) {
+	before := testutil.ToFloat64(authFailures.WithLabelValues(string(AuthPassword)))
+
+	for _, user := range []string{"alice", "mallory"} {
+		conn := dialBackend(t, echoHandler{}, withAuth(t, AuthPassword, StaticUsers{"alice": "secret"}))
+		f := sendStartup(t, conn, user)
+
+		msg, err := f.Receive()
+		require.NoError(t, err)
+		require.IsType(t, &pgproto3.AuthenticationCleartextPassword{}, msg)
+		require.NoError(t, f.Send(&pgproto3.PasswordMessage{Password: "wrong"}))
+
+		errMsg := expectAuthResult(t, f)
+		require.NotNil(t, errMsg)
+		assert.Equal(t, pgerror.SeverityFatal, errMsg.Severity)
+		assert.Equal(t, pgerror.CodeInvalidPassword, errMsg.Code)
+		assert.Equal(t, fmt.Sprintf("password authentication failed for user \"%s\"", user), errMsg.Message)
+	}
+
+	after := testutil.ToFloat64(authFailures.WithLabelValues(string(AuthPassword)))
+	assert.Equal(t, before+2, after)
+}
+
+func TestAuth_EmptySecret(t *testing.T) {
+	for _, method := range []AuthMethod{AuthPassword, AuthMD5} {
+		conn := dialBackend(t, echoHandler{}, withAuth(t, method, StaticUsers{"alice": ""}))
+		f := sendStartup(t, conn, "alice")
+
+		msg, err := f.Receive()
+		require.NoError(t, err)
+		password := ""
+		if md5, ok := msg.(*pgproto3.AuthenticationMD5Password); ok {
+			password = "md5" + md5Hex(md5Hex("alice")+string(md5.Salt[:]))
+		}
+		require.NoError(t, f.Send(&pgproto3.PasswordMessage{Password: password}))
+
+		errMsg := expectAuthResult(t, f)
+		require.NotNil(t, errMsg, method)
+		assert.Equal(t, pgerror.CodeInvalidPassword, errMsg.Code, method)
+	}
+}
+
+// scramClient performs the client side of the SCRAM exchange and returns
+// the outcome of the authentication.
+func scramClient(t *testing.T, f *pgproto3.Frontend, password string, cbind []byte) *pgproto3.ErrorResponse {
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.AuthenticationSASL{}, msg)
+	mechanisms := msg.(*pgproto3.AuthenticationSASL).AuthMechanisms
+
+	mechanism, gs2Header := scramMechanism, "n,,"
+	if cbind != nil {
+		require.Contains(t, mechanisms, scramPlusMechanism)
+		mechanism, gs2Header = scramPlusMechanism, "p=tls-server-end-point,,"
+	}
+	clientFirstBare := "n=,r=clientnonce"
+	require.NoError(t, f.Send(&pgproto3.SASLInitialResponse{
+		AuthMechanism: mechanism,
+		Data:          []byte(gs2Header + clientFirstBare),
+	}))
+
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	if errMsg, ok := msg.(*pgproto3.ErrorResponse); ok {
+		return errMsg
+	}
+	require.IsType(t, &pgproto3.AuthenticationSASLContinue{}, msg)
+	serverFirst := string(msg.(*pgproto3.AuthenticationSASLContinue).Data)
+	salt, err := base64.StdEncoding.DecodeString(scramAttribute(serverFirst, 's'))
+	require.NoError(t, err)
+
+	clientFinalWithoutProof := "c=" + base64.StdEncoding.EncodeToString(append([]byte(gs2Header), cbind...)) +
+		",r=" + scramAttribute(serverFirst, 'r')
+	authMessage := []byte(clientFirstBare + "," + serverFirst + "," + clientFinalWithoutProof)
+	salted := pbkdf2SHA256([]byte(password), salt, scramIterations)
+	clientKey := hmacSHA256(salted, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	signature := hmacSHA256(storedKey[:], authMessage)
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ signature[i]
+	}
+	require.NoError(t, f.Send(&pgproto3.SASLResponse{
+		Data: []byte(clientFinalWithoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)),
+	}))
+
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	if errMsg, ok := msg.(*pgproto3.ErrorResponse); ok {
+		return errMsg
+	}
+	require.IsType(t, &pgproto3.AuthenticationSASLFinal{}, msg)
+	serverKey := hmacSHA256(salted, []byte("Server Key"))
+	expected := "v=" + base64.StdEncoding.EncodeToString(hmacSHA256(serverKey, authMessage))
+	assert.True(t, hmac.Equal([]byte(expected), msg.(*pgproto3.AuthenticationSASLFinal).Data))
+	return expectAuthResult(t, f)
+}
+
+func TestAuth_SCRAM(t *testing.T) {
+	scram, err := SCRAMSecret("secret")
+	require.NoError(t, err)
+
+	for _, secret := range []string{scram, "secret"} {
+		conn := dialBackend(t, echoHandler{}, withAuth(t, AuthSCRAMSHA256, StaticUsers{"alice": secret}))
+		assert.Nil(t, scramClient(t, sendStartup(t, conn, "alice"), "secret", nil))
+	}
+
+	conn := dialBackend(t, echoHandler{}, withAuth(t, AuthSCRAMSHA256, StaticUsers{"alice": scram}))
+	errMsg := scramClient(t, sendStartup(t, conn, "alice"), "wrong", nil)
+	require.NotNil(t, errMsg)
+	assert.Equal(t, pgerror.CodeInvalidPassword, errMsg.Code)
+
+	// md5 falls back to scram when only a scram secret is stored
+	conn = dialBackend(t, echoHandler{}, withAuth(t, AuthMD5, StaticUsers{"alice": scram}))
+	assert.Nil(t, scramClient(t, sendStartup(t, conn, "alice"), "secret", nil))
+}
+
+func TestAuth_SCRAMChannelBinding(t *testing.T) {
+	for _, alg := range []KeyAlgorithm{KeyECDSAP256, KeyRSA} {
+		t.Run(string(alg), func(t *testing.T) {
+			tlsConn := dialSCRAMTLS(t, alg)
+			cbind := serverEndPoint(tlsConn.ConnectionState().PeerCertificates[0].Raw)
+			require.NotNil(t, cbind)
+			assert.Nil(t, scramClient(t, sendStartup(t, tlsConn, "alice"), "secret", cbind))
+		})
+	}
+}
+
+func TestAuth_SCRAMWithoutChannelBinding(t *testing.T) {
+	// tls-server-end-point is not defined for ed25519 certificates so only
+	// SCRAM-SHA-256 is offered
+	tlsConn := dialSCRAMTLS(t, KeyEd25519)
+	assert.Nil(t, serverEndPoint(tlsConn.ConnectionState().PeerCertificates[0].Raw))
+
+	f := sendStartup(t, tlsConn, "alice")
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.AuthenticationSASL{}, msg)
+	assert.Equal(t, []string{scramMechanism}, msg.(*pgproto3.AuthenticationSASL).AuthMechanisms)
+}
+
+// dialSCRAMTLS connects over TLS to a backend using SCRAM authentication
+// and a certificate with a key of the algorithm
+func dialSCRAMTLS(t *testing.T, alg KeyAlgorithm) *tls.Conn {
+	c, err := GenerateCert(CertOptions{Hosts: []string{"localhost"}, Algorithm: alg}, nil)
+	require.NoError(t, err)
+	cfg := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{c.Cert.Raw}, PrivateKey: c.Key}}}
+	configureAuth := withAuth(t, AuthSCRAMSHA256, StaticUsers{"alice": "secret"})
+	conn := dialBackend(t, echoHandler{}, func(b *DataQueryBackend) {
+		b.tlsConfig = cfg
+		configureAuth(b)
+	})
+	require.Equal(t, byte('S'), sslRequest(t, conn))
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, tlsConn.Handshake())
+	return tlsConn
+}
@@ -0,0 +1,123 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"sync"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// cancelRegistry maps the process IDs handed out in BackendKeyData to the
+// sessions so a CancelRequest arriving on another connection can find the
+// session it is meant for.
+type cancelRegistry struct {
+	mu       sync.Mutex
+	lastPID  uint32
+	sessions map[uint32]*DataQueryBackend
+}
+
+func newCancelRegistry() *cancelRegistry {
+	return &cancelRegistry{sessions: make(map[uint32]*DataQueryBackend)}
+}
+
+// register assigns the session a process ID and secret key
+func (r *cancelRegistry) register(b *DataQueryBackend) error {
+	var key [4]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for {
+		r.lastPID++
+		if _, taken := r.sessions[r.lastPID]; !taken && r.lastPID != 0 {
+			break
+		}
+	}
+	b.pid = r.lastPID
+	b.secretKey = binary.BigEndian.Uint32(key[:])
+	r.sessions[b.pid] = b
+	return nil
+}
+
+func (r *cancelRegistry) unregister(b *DataQueryBackend) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sessions[b.pid] == b {
+		delete(r.sessions, b.pid)
+	}
+}
+
+// cancel cancels the running query of the session when the secret key
+// matches. Like postgres nothing is reported back to the requester.
+func (r *cancelRegistry) cancel(req *pgproto3.CancelRequest) {
+	r.mu.Lock()
+	b, ok := r.sessions[req.ProcessID]
+	r.mu.Unlock()
+	if !ok || subtle.ConstantTimeEq(int32(b.secretKey), int32(req.SecretKey)) != 1 {
+		log.Warn().Uint32("pid", req.ProcessID).Msg("cancel request did not match any session")
+		return
+	}
+	log.Debug().Uint32("pid", req.ProcessID).Msg("canceling query")
+	b.cancelQuery()
+}
+
+// startQuery returns the context passed to the handler for one query, it
+// is canceled by a CancelRequest. done must be called once the query has
+// completed.
+func (b *DataQueryBackend) startQuery() (ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancel(b.ctx)
+	b.mu.Lock()
+	b.cancelFunc = cancel
+	b.mu.Unlock()
+	return ctx, func() {
+		b.mu.Lock()
+		b.cancelFunc = nil
+		b.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancelAlso makes a CancelRequest for the running query also call cancel,
+// it is used for portals whose rows are read with their own context
+func (b *DataQueryBackend) cancelAlso(cancel context.CancelFunc) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if prev := b.cancelFunc; prev != nil {
+		b.cancelFunc = func() {
+			prev()
+			cancel()
+		}
+	}
+}
+
+// cancelQuery cancels the query currently running, if any
+func (b *DataQueryBackend) cancelQuery() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cancelFunc != nil {
+		b.cancelFunc()
+	}
+}
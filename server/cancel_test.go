@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startupKeyData completes the startup and returns the BackendKeyData
+func startupKeyData(t *testing.T, addr string) (*pgproto3.Frontend, pgproto3.BackendKeyData) {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	f := pgproto3.NewFrontend(pgproto3.NewChunkReader(conn), conn)
+	require.NoError(t, f.Send(&pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters:      map[string]string{"user": "test"},
+	}))
+
+	var key pgproto3.BackendKeyData
+	for {
+		msg, err := f.Receive()
+		require.NoError(t, err)
+		switch msg := msg.(type) {
+		case *pgproto3.BackendKeyData:
+			key = *msg
+		case *pgproto3.ReadyForQuery:
+			return f, key
+		case *pgproto3.ErrorResponse:
+			t.Fatalf("startup failed: %s", msg.Message)
+		}
+	}
+}
+
+// sendCancel sends a CancelRequest on a new connection, the server closes
+// it without a response.
+func sendCancel(t *testing.T, addr string, pid, key uint32) {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	req := &pgproto3.CancelRequest{ProcessID: pid, SecretKey: key}
+	_, err = conn.Write(req.Encode(nil))
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestCancelRequest(t *testing.T) {
+	started := make(chan context.Context, 1)
+	s, err := New(WithHandler(funcHandler(func(ctx context.Context, query string, args []interface{}) (*session.Result, error) {
+		if query != "sleep" {
+			return &session.Result{CommandTag: "OK"}, nil
+		}
+		started <- ctx
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})))
+	require.NoError(t, err)
+	addr, _ := serveTest(t, s)
+	defer s.Shutdown(context.Background())
+
+	f, key := startupKeyData(t, addr)
+	other, otherKey := startupKeyData(t, addr)
+	require.NotZero(t, key.ProcessID)
+	require.NotEqual(t, key.ProcessID, otherKey.ProcessID)
+
+	require.NoError(t, f.Send(&pgproto3.Query{String: "sleep"}))
+	ctx := <-started
+
+	// a wrong secret key is ignored
+	sendCancel(t, addr, key.ProcessID, key.SecretKey+1)
+	sendCancel(t, addr, key.ProcessID, otherKey.SecretKey)
+	sendCancel(t, addr, 0, key.SecretKey)
+	assert.NoError(t, ctx.Err())
+
+	sendCancel(t, addr, key.ProcessID, key.SecretKey)
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.ErrorResponse{}, msg)
+	assert.Equal(t, pgerror.CodeQueryCanceled, msg.(*pgproto3.ErrorResponse).Code)
+	assert.Equal(t, "canceling statement due to user request", msg.(*pgproto3.ErrorResponse).Message)
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.ReadyForQuery{}, msg)
+
+	// the session and the other session remain usable
+	for _, fe := range []*pgproto3.Frontend{f, other} {
+		require.NoError(t, fe.Send(&pgproto3.Query{String: "select"}))
+		msg, err = fe.Receive()
+		require.NoError(t, err)
+		assert.Equal(t, &pgproto3.CommandComplete{CommandTag: []byte("OK")}, msg)
+		msg, err = fe.Receive()
+		require.NoError(t, err)
+		assert.IsType(t, &pgproto3.ReadyForQuery{}, msg)
+	}
+}
+
+func TestCancelRegistry(t *testing.T) {
+	r := newCancelRegistry()
+	a, b := &DataQueryBackend{}, &DataQueryBackend{}
+	require.NoError(t, r.register(a))
+	require.NoError(t, r.register(b))
+	assert.NotEqual(t, a.pid, b.pid)
+	assert.Len(t, r.sessions, 2)
+
+	r.unregister(a)
+	assert.Len(t, r.sessions, 1)
+	assert.Same(t, b, r.sessions[b.pid])
+}
+
+// ctxRows generates the integers from 1 to n until the context of the
+// statement is canceled. Before row stop is returned it signals reached
+// and waits for resume.
+type ctxRows struct {
+	ctx             context.Context
+	n, read, stop   int
+	reached, resume chan struct{}
+	err             error
+}
+
+func (r *ctxRows) Next() bool {
+	if r.err = r.ctx.Err(); r.err != nil || r.read >= r.n {
+		return false
+	}
+	r.read++
+	if r.read == r.stop {
+		r.reached <- struct{}{}
+		<-r.resume
+	}
+	return true
+}
+
+func (r *ctxRows) Values() []interface{} { return []interface{}{r.read} }
+func (r *ctxRows) Err() error            { return r.err }
+func (r *ctxRows) Close() error          { return nil }
+
+func TestCancelRequestFetch(t *testing.T) {
+	reached, resume := make(chan struct{}), make(chan struct{})
+	s, err := New(WithHandler(funcHandler(func(ctx context.Context, query string, args []interface{}) (*session.Result, error) {
+		rows := &ctxRows{ctx: ctx, n: 5, stop: 2, reached: reached, resume: resume}
+		return &session.Result{Columns: []pgtype.Column{pgtype.NewColumn("n", pgtype.Int4OID)}, Rows: rows}, nil
+	})))
+	require.NoError(t, err)
+	addr, _ := serveTest(t, s)
+	defer s.Shutdown(context.Background())
+
+	f, key := startupKeyData(t, addr)
+	simpleQuery(t, f, "DECLARE c CURSOR FOR SELECT n")
+
+	require.NoError(t, f.Send(&pgproto3.Query{String: "FETCH ALL c"}))
+	<-reached
+	sendCancel(t, addr, key.ProcessID, key.SecretKey)
+	close(resume)
+	msgs := receiveUntilReady(t, f)
+	assert.Equal(t, []string{"1"}, dataRows(msgs))
+	require.IsType(t, &pgproto3.ErrorResponse{}, msgs[len(msgs)-1])
+	assert.Equal(t, pgerror.CodeQueryCanceled, msgs[len(msgs)-1].(*pgproto3.ErrorResponse).Code)
+
+	// only the FETCH was canceled, the cursor continues after the row it
+	// was stopped at
+	msgs = simpleQuery(t, f, "FETCH ALL c")
+	assert.Equal(t, []string{"3", "4", "5"}, dataRows(msgs))
+	assert.Equal(t, &pgproto3.CommandComplete{CommandTag: []byte("FETCH 3")}, msgs[len(msgs)-1])
+}
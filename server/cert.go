@@ -0,0 +1,220 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bufio"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+)
+
+// AuthCert authenticates clients with the certificate they presented
+// during the TLS handshake.
+const AuthCert AuthMethod = "cert"
+
+// certAuth accepts clients whose verified certificate names the user in
+// its common name or one of its subject alternative names, optionally
+// translated by an ident map.
+type certAuth struct {
+	maps    *IdentMaps
+	mapName string
+}
+
+// NewCertAuthenticator returns an authenticator for the cert method. When
+// mapName is set the names in the certificate are translated to database
+// users with the ident map of that name.
+func NewCertAuthenticator(maps *IdentMaps, mapName string) (Authenticator, error) {
+	if mapName != "" && !maps.has(mapName) {
+		return nil, fmt.Errorf("ident map %q is not defined", mapName)
+	}
+	return &certAuth{maps: maps, mapName: mapName}, nil
+}
+
+func (a *certAuth) Method() AuthMethod { return AuthCert }
+
+func (a *certAuth) Authenticate(conn AuthConn) error {
+	cert := verifiedClientCert(conn)
+	if cert == nil {
+		return pgerror.NewError(pgerror.CodeInvalidAuthorizationSpecification,
+			"connection requires a valid client certificate")
+	}
+	for _, name := range certNames(cert) {
+		if a.mapName == "" && name == conn.User() {
+			return nil
+		}
+		if a.mapName != "" && a.maps.Match(a.mapName, name, conn.User()) {
+			return nil
+		}
+	}
+	return pgerror.NewError(pgerror.CodeInvalidAuthorizationSpecification,
+		"certificate authentication failed for user \"%s\"", conn.User())
+}
+
+// verifiedClientCert returns the client certificate when it was verified
+// against the configured certificate authorities.
+func verifiedClientCert(conn AuthConn) *x509.Certificate {
+	state := conn.TLSState()
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return state.PeerCertificates[0]
+}
+
+// certNames returns the common name followed by the subject alternative
+// names of the certificate.
+func certNames(cert *x509.Certificate) []string {
+	var names []string
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+// checkClientCert enforces the clientcert option of an access rule.
+// verify-ca requires a verified certificate, verify-full additionally
+// requires it to name the user.
+func checkClientCert(conn AuthConn, rule *HBARule, maps *IdentMaps) error {
+	switch rule.Options["clientcert"] {
+	case "":
+		return nil
+	case "verify-ca":
+		if verifiedClientCert(conn) == nil {
+			return pgerror.NewError(pgerror.CodeInvalidAuthorizationSpecification,
+				"connection requires a valid client certificate")
+		}
+		return nil
+	case "verify-full":
+		auth, err := NewCertAuthenticator(maps, rule.Options["map"])
+		if err != nil {
+			return err
+		}
+		return auth.Authenticate(conn)
+	default:
+		return fmt.Errorf("invalid value for clientcert: %q", rule.Options["clientcert"])
+	}
+}
+
+// identMapping is a single line of an ident map file
+type identMapping struct {
+	system *regexp.Regexp // set when the system name is a regular expression
+	name   string
+	user   string
+}
+
+// IdentMaps translate names found in client certificates to database users
+// in the style of pg_ident.conf.
+// https://www.postgresql.org/docs/14/auth-username-maps.html
+type IdentMaps struct {
+	maps map[string][]identMapping
+}
+
+// ParseIdentMaps reads mappings, one per line, in the format
+//
+//	MAPNAME SYSTEM-USERNAME DATABASE-USERNAME
+//
+// A system name starting with a slash is a regular expression, \1 in the
+// database name is replaced with its first capture group.
+func ParseIdentMaps(r io.Reader) (*IdentMaps, error) {
+	maps := &IdentMaps{maps: map[string][]identMapping{}}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected MAPNAME SYSTEM-USERNAME DATABASE-USERNAME", line)
+		}
+		m := identMapping{name: fields[1], user: fields[2]}
+		if strings.HasPrefix(m.name, "/") {
+			re, err := regexp.Compile(m.name[1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			m.system = re
+		}
+		maps.maps[fields[0]] = append(maps.maps[fields[0]], m)
+	}
+	return maps, scanner.Err()
+}
+
+// LoadIdentFile reads ident maps from a file, see ParseIdentMaps
+func LoadIdentFile(path string) (*IdentMaps, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	maps, err := ParseIdentMaps(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return maps, nil
+}
+
+func (m *IdentMaps) has(mapName string) bool {
+	if m == nil {
+		return false
+	}
+	_, ok := m.maps[mapName]
+	return ok
+}
+
+// Match reports whether the map allows the system name to connect as user
+func (m *IdentMaps) Match(mapName, system, user string) bool {
+	if m == nil {
+		return false
+	}
+	for _, mapping := range m.maps[mapName] {
+		if mapping.system == nil {
+			if mapping.name == system && (mapping.user == "all" || mapping.user == user) {
+				return true
+			}
+			continue
+		}
+		match := mapping.system.FindStringSubmatch(system)
+		if match == nil {
+			continue
+		}
+		expected := mapping.user
+		if len(match) > 1 {
+			expected = strings.Replace(expected, `\1`, match[1], 1)
+		}
+		if expected == "all" || expected == user {
+			return true
+		}
+	}
+	return false
+}
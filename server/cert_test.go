@@ -0,0 +1,101 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"strings"
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentMaps(t *testing.T) {
+	maps, err := ParseIdentMaps(strings.NewReader(`
+# MAPNAME  SYSTEM-USERNAME               DATABASE-USERNAME
+svc        billing.prod.svc              billing
+svc        /^(.*)\.dev\.svc$             \1
+admins     root                          all
+`))
+	require.NoError(t, err)
+
+	assert.True(t, maps.Match("svc", "billing.prod.svc", "billing"))
+	assert.False(t, maps.Match("svc", "billing.prod.svc", "admin"))
+	assert.True(t, maps.Match("svc", "reports.dev.svc", "reports"))
+	assert.False(t, maps.Match("svc", "reports.dev.svc", "billing"))
+	assert.True(t, maps.Match("admins", "root", "anyone"))
+	assert.False(t, maps.Match("missing", "root", "anyone"))
+
+	_, err = ParseIdentMaps(strings.NewReader("svc only-two"))
+	assert.Error(t, err)
+	_, err = NewCertAuthenticator(maps, "missing")
+	assert.Error(t, err)
+}
+
+// clientCertTLS returns a server config verifying client certificates
+// issued by a new CA, and a client config presenting a certificate for cn.
+func clientCertTLS(t *testing.T, cn string) (*tls.Config, *tls.Config) {
+	ca, err := GenerateCA(CertOptions{Algorithm: KeyECDSAP256})
+	require.NoError(t, err)
+	client, err := GenerateCert(CertOptions{CommonName: cn, Hosts: []string{"svc.example.com"}, ClientAuth: true}, ca)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	serverCfg := testTLSConfig(t)
+	serverCfg.ClientCAs = pool
+	serverCfg.ClientAuth = tls.VerifyClientCertIfGiven
+
+	clientCfg := &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{{Certificate: [][]byte{client.Cert.Raw}, PrivateKey: client.Key}},
+	}
+	return serverCfg, clientCfg
+}
+
+func TestCertAuth_Startup(t *testing.T) {
+	hba, err := ParseHBA(strings.NewReader(`
+hostssl all alice   all cert
+hostssl all billing all cert map=svc
+`))
+	require.NoError(t, err)
+	maps, err := ParseIdentMaps(strings.NewReader("svc svc.example.com billing"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		cn       string
+		user     string
+		accepted bool
+	}{
+		{"alice", "alice", true},
+		{"mallory", "alice", false},
+		{"other", "billing", true}, // matched by the SAN through the map
+	}
+	for _, tt := range tests {
+		serverCfg, clientCfg := clientCertTLS(t, tt.cn)
+		conn := dialBackend(t, echoHandler{}, func(b *DataQueryBackend) {
+			b.tlsConfig = serverCfg
+			b.hba = hba
+			b.identMaps = maps
+		})
+		require.Equal(t, byte('S'), sslRequest(t, conn))
+		tlsConn := tls.Client(conn, clientCfg)
+		require.NoError(t, tlsConn.Handshake())
+
+		errMsg := expectAuthResult(t, sendStartup(t, tlsConn, tt.user))
+		if tt.accepted {
+			assert.Nil(t, errMsg, "%s as %s", tt.cn, tt.user)
+		} else if assert.NotNil(t, errMsg, "%s as %s", tt.cn, tt.user) {
+			assert.Equal(t, pgerror.CodeInvalidAuthorizationSpecification, errMsg.Code)
+		}
+	}
+}
+
+func TestServer_WithClientCAs(t *testing.T) {
+	pool := x509.NewCertPool()
+	s, err := New(WithClientCAs(pool, true), WithTLSConfig(&tls.Config{}))
+	require.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, s.tlsConfig.ClientAuth)
+	assert.Same(t, pool, s.tlsConfig.ClientCAs)
+}
@@ -0,0 +1,763 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/session"
+	"github.com/rs/zerolog/log"
+)
+
+// copyFormat is the data format of a COPY statement
+type copyFormat string
+
+const (
+	copyText   copyFormat = "text"
+	copyCSV    copyFormat = "csv"
+	copyBinary copyFormat = "binary"
+)
+
+// copyOptions are the options of a COPY statement with the defaults of the
+// format filled in
+type copyOptions struct {
+	format copyFormat
+	// delimiter separates the columns of a row, text and csv only
+	delimiter byte
+	// null is the string representing a NULL value, text and csv only
+	null string
+	// header is set when the first line holds the column names, csv only
+	header bool
+	// quote and escape are used to quote values, csv only
+	quote  byte
+	escape byte
+
+	// nullSet is set when the NULL option was given, it may be empty
+	nullSet bool
+}
+
+// CopyHandler is implemented by handlers supporting the COPY statement.
+// The rows are streamed in both directions so neither side has to hold the
+// whole data set in memory.
+type CopyHandler interface {
+	// CopyFrom stores the rows sent by the client and returns how many were
+	// stored. The values of a row are strings in the text and csv formats
+	// and []byte in the binary format, NULL is nil. rows.Err must be checked
+	// once Next returns false.
+	CopyFrom(ctx context.Context, stmt *parser.CopyStmt, rows session.Rows) (int64, error)
+	// CopyTo returns the rows sent to the client, the column names are
+	// used for the csv header.
+	CopyTo(ctx context.Context, stmt *parser.CopyStmt) (*session.Result, error)
+}
+
+// binaryCopySignature starts every file in the binary COPY format
+var binaryCopySignature = []byte("PGCOPY\n\377\r\n\000")
+
+// newCopyOptions returns the options of a COPY statement exchanging data
+// with the client, files on the server cannot be copied from or to.
+func newCopyOptions(stmt *parser.CopyStmt) (copyOptions, error) {
+	var opts copyOptions
+	switch {
+	case stmt.File != "" && stmt.From:
+		return opts, pgerror.NewError(pgerror.CodeFeatureNotSupported, "COPY FROM is only supported with STDIN")
+	case stmt.File != "":
+		return opts, pgerror.NewError(pgerror.CodeFeatureNotSupported, "COPY TO is only supported with STDOUT")
+	case stmt.Query != nil && stmt.From:
+		return opts, pgerror.NewError(pgerror.CodeFeatureNotSupported, "COPY (query) FROM is not supported")
+	}
+	for _, opt := range stmt.Options {
+		if err := setCopyOption(&opts, opt.Name, opt.Value); err != nil {
+			return opts, err
+		}
+	}
+	return opts, validateCopyOptions(&opts)
+}
+
+func setCopyOption(opts *copyOptions, name, value string) error {
+	switch name {
+	case "format":
+		switch f := copyFormat(strings.ToLower(value)); f {
+		case copyText, copyCSV, copyBinary:
+			opts.format = f
+		default:
+			return pgerror.NewError(pgerror.CodeInvalidParameterValue, "COPY format \"%s\" not recognized", value)
+		}
+	case "delimiter", "quote", "escape":
+		if len(value) != 1 {
+			return pgerror.NewError(pgerror.CodeFeatureNotSupported, "COPY %s must be a single one-byte character", name)
+		}
+		switch name {
+		case "delimiter":
+			opts.delimiter = value[0]
+		case "quote":
+			opts.quote = value[0]
+		default:
+			opts.escape = value[0]
+		}
+	case "null":
+		opts.null = value
+		opts.nullSet = true
+	case "header":
+		switch strings.ToLower(value) {
+		case "", "true", "on", "1":
+			opts.header = true
+		case "false", "off", "0":
+			opts.header = false
+		default:
+			return pgerror.NewError(pgerror.CodeInvalidParameterValue, "header requires a Boolean value")
+		}
+	default:
+		return pgerror.NewError(pgerror.CodeSyntaxError, "option \"%s\" not recognized", name)
+	}
+	return nil
+}
+
+// validateCopyOptions fills in the defaults of the format and rejects
+// options which do not apply to it.
+func validateCopyOptions(opts *copyOptions) error {
+	if opts.format == "" {
+		opts.format = copyText
+	}
+
+	switch opts.format {
+	case copyBinary:
+		if opts.delimiter != 0 || opts.nullSet || opts.header || opts.quote != 0 || opts.escape != 0 {
+			return pgerror.NewError(pgerror.CodeSyntaxError, "cannot specify DELIMITER, NULL, HEADER, QUOTE or ESCAPE in BINARY mode")
+		}
+		return nil
+	case copyText:
+		if opts.header {
+			return pgerror.NewError(pgerror.CodeFeatureNotSupported, "COPY HEADER available only in CSV mode")
+		}
+		if opts.quote != 0 || opts.escape != 0 {
+			return pgerror.NewError(pgerror.CodeFeatureNotSupported, "COPY quote and escape available only in CSV mode")
+		}
+		if opts.delimiter == 0 {
+			opts.delimiter = '\t'
+		}
+		if !opts.nullSet {
+			opts.null = `\N`
+		}
+	case copyCSV:
+		if opts.delimiter == 0 {
+			opts.delimiter = ','
+		}
+		if opts.quote == 0 {
+			opts.quote = '"'
+		}
+		if opts.escape == 0 {
+			opts.escape = opts.quote
+		}
+		if opts.delimiter == opts.quote {
+			return pgerror.NewError(pgerror.CodeInvalidParameterValue, "COPY delimiter and quote must be different")
+		}
+	}
+
+	switch opts.delimiter {
+	case '\r', '\n', '\\':
+		return pgerror.NewError(pgerror.CodeInvalidParameterValue, "COPY delimiter cannot be newline, carriage return or backslash")
+	}
+	if strings.IndexByte(opts.null, opts.delimiter) >= 0 {
+		return pgerror.NewError(pgerror.CodeInvalidParameterValue, "COPY delimiter must not appear in the NULL specification")
+	}
+	return nil
+}
+
+// executeCopy runs a COPY statement with the handler
+func (b *DataQueryBackend) executeCopy(ctx context.Context, stmt *parser.CopyStmt) error {
+	opts, err := newCopyOptions(stmt)
+	if err != nil {
+		return err
+	}
+	h, ok := b.handler.(CopyHandler)
+	if !ok {
+		return pgerror.NewError(pgerror.CodeFeatureNotSupported, "COPY is not supported")
+	}
+	if stmt.From {
+		return b.copyIn(ctx, h, stmt, opts)
+	}
+	return b.copyOut(ctx, h, stmt, opts)
+}
+
+// copyFormatCodes returns the overall and per column format of the
+// CopyInResponse and CopyOutResponse messages.
+func copyFormatCodes(format copyFormat, columns int) (byte, []uint16) {
+	var code uint16
+	if format == copyBinary {
+		code = 1
+	}
+	codes := make([]uint16, columns)
+	for i := range codes {
+		codes[i] = code
+	}
+	return byte(code), codes
+}
+
+func (b *DataQueryBackend) copyIn(ctx context.Context, h CopyHandler, stmt *parser.CopyStmt, opts copyOptions) error {
+	overall, codes := copyFormatCodes(opts.format, len(stmt.Columns))
+	err := b.sendNow(&pgproto3.CopyInResponse{OverallFormat: overall, ColumnFormatCodes: codes})
+	if err != nil {
+		return err
+	}
+
+	in := &copyInReader{b: b}
+	rows := newCopyDecoder(opts, len(stmt.Columns), bufio.NewReader(in))
+	n, err := h.CopyFrom(ctx, stmt, rows)
+	if err == nil {
+		err = rows.Err()
+	}
+	if err == nil {
+		// the data the handler did not read still has to be received
+		_, err = io.Copy(io.Discard, in)
+	}
+	if in.connErr != nil {
+		return in.connErr
+	}
+	if err != nil {
+		// the remaining copy messages are dropped by Run
+		return toError(err)
+	}
+	log.Debug().Str("table", stmt.Table.String()).Int64("rows", n).Msg("copy from stdin")
+	return b.send(&pgproto3.CommandComplete{CommandTag: []byte(fmt.Sprintf("COPY %d", n))})
+}
+
+// copyInReader reads the payload of the CopyData messages sent by the
+// client until CopyDone.
+type copyInReader struct {
+	b       *DataQueryBackend
+	buf     []byte
+	done    bool
+	err     error
+	connErr error
+}
+
+func (r *copyInReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		msg, err := r.b.backend.Receive()
+		if err != nil {
+			r.connErr = err
+			r.err = err
+			continue
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.CopyData:
+			// the message is only valid until the next Receive which is not
+			// called before the data has been consumed
+			r.buf = msg.Data
+		case *pgproto3.CopyDone:
+			r.done = true
+		case *pgproto3.CopyFail:
+			r.err = pgerror.NewError(pgerror.CodeQueryCanceled, "COPY from stdin failed: %s", msg.Message)
+		case *pgproto3.Flush, *pgproto3.Sync:
+			// ignored during COPY like postgres does
+		default:
+			r.err = pgerror.NewError(pgerror.CodeProtocolViolation, "unexpected message type %T during COPY from stdin", msg)
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// copyDecoder implements Rows over the data sent by the client
+type copyDecoder struct {
+	r       *bufio.Reader
+	opts    copyOptions
+	columns int
+	values  []interface{}
+	line    int
+	started bool
+	done    bool
+	err     error
+}
+
+func newCopyDecoder(opts copyOptions, columns int, r *bufio.Reader) *copyDecoder {
+	return &copyDecoder{r: r, opts: opts, columns: columns}
+}
+
+func (d *copyDecoder) Next() bool {
+	if d.done || d.err != nil {
+		return false
+	}
+	var err error
+	if !d.started {
+		d.started = true
+		err = d.start()
+	}
+	if err == nil {
+		switch d.opts.format {
+		case copyBinary:
+			d.values, err = d.binaryRow()
+		case copyCSV:
+			d.values, err = d.csvRow()
+		default:
+			d.values, err = d.textRow()
+		}
+	}
+	if err == io.EOF {
+		d.done = true
+		return false
+	}
+	if err == nil && d.columns > 0 && len(d.values) != d.columns {
+		err = d.columnCountError(len(d.values))
+	}
+	if err != nil {
+		d.err = err
+		return false
+	}
+	return true
+}
+
+func (d *copyDecoder) Values() []interface{} { return d.values }
+func (d *copyDecoder) Err() error            { return d.err }
+func (d *copyDecoder) Close() error          { return nil }
+
+func (d *copyDecoder) columnCountError(n int) error {
+	if n > d.columns {
+		return d.formatError("extra data after last expected column")
+	}
+	return d.formatError("missing data for column")
+}
+
+func (d *copyDecoder) formatError(format string, args ...interface{}) error {
+	err := pgerror.NewError(pgerror.CodeBadCopyFileFormat, format, args...)
+	err.Where = fmt.Sprintf("COPY, line %d", d.line)
+	return err
+}
+
+// start reads the binary header or skips the csv header line
+func (d *copyDecoder) start() error {
+	switch {
+	case d.opts.format == copyBinary:
+		d.line++
+		header := make([]byte, len(binaryCopySignature)+8)
+		if _, err := io.ReadFull(d.r, header); err != nil {
+			return d.formatError("COPY file signature not recognized")
+		}
+		if !bytes.Equal(header[:len(binaryCopySignature)], binaryCopySignature) {
+			return d.formatError("COPY file signature not recognized")
+		}
+		ext := binary.BigEndian.Uint32(header[len(binaryCopySignature)+4:])
+		if _, err := io.CopyN(io.Discard, d.r, int64(ext)); err != nil {
+			return d.formatError("invalid COPY file header (wrong length)")
+		}
+	case d.opts.header:
+		if _, err := d.csvRow(); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLine returns the next line without its line ending, io.EOF is
+// returned at the end of the data or at the \. end marker.
+func (d *copyDecoder) readLine() (string, error) {
+	line, err := d.r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	d.line++
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	if line == `\.` {
+		return "", io.EOF
+	}
+	return line, nil
+}
+
+func (d *copyDecoder) textRow() ([]interface{}, error) {
+	line, err := d.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	// split on the delimiters which are not escaped, the NULL string is
+	// matched before the escapes are removed
+	var raw []string
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case d.opts.delimiter:
+			raw = append(raw, line[start:i])
+			start = i + 1
+		}
+	}
+	raw = append(raw, line[start:])
+
+	values := make([]interface{}, len(raw))
+	for i, field := range raw {
+		if field == d.opts.null {
+			continue
+		}
+		values[i], err = unescapeCopyText(field)
+		if err != nil {
+			return nil, d.formatError("%s", err.Error())
+		}
+	}
+	return values, nil
+}
+
+// unescapeCopyText removes the backslash escapes of the text format
+func unescapeCopyText(s string) (string, error) {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s, nil
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", errors.New("unterminated escape sequence")
+		}
+		switch c = s[i]; c {
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'v':
+			sb.WriteByte('\v')
+		case 'x':
+			j := i + 1
+			for j < len(s) && j < i+3 && isHexDigit(s[j]) {
+				j++
+			}
+			if j == i+1 {
+				sb.WriteByte('x')
+				continue
+			}
+			v, _ := strconv.ParseUint(s[i+1:j], 16, 8)
+			sb.WriteByte(byte(v))
+			i = j - 1
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			j := i
+			for j < len(s) && j < i+3 && '0' <= s[j] && s[j] <= '7' {
+				j++
+			}
+			v, _ := strconv.ParseUint(s[i:j], 8, 16)
+			sb.WriteByte(byte(v))
+			i = j - 1
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), nil
+}
+
+func isHexDigit(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// csvRow reads one record, quoted values may span several lines. An
+// unquoted value matching the NULL string is NULL.
+func (d *copyDecoder) csvRow() ([]interface{}, error) {
+	line, err := d.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	var values []interface{}
+	var field strings.Builder
+	// a quoted value is never NULL
+	quoted, wasQuoted := false, false
+	appendValue := func() {
+		if !wasQuoted && field.String() == d.opts.null {
+			values = append(values, nil)
+		} else {
+			values = append(values, field.String())
+		}
+		field.Reset()
+		wasQuoted = false
+	}
+	for i := 0; ; i++ {
+		if i == len(line) {
+			if !quoted {
+				break
+			}
+			// the newline is part of the quoted value
+			next, err := d.r.ReadString('\n')
+			if err == io.EOF && next != "" {
+				err = nil
+			}
+			if err != nil {
+				return nil, d.formatError("unterminated CSV quoted field")
+			}
+			d.line++
+			field.WriteByte('\n')
+			line, i = strings.TrimSuffix(next, "\n"), -1
+			continue
+		}
+
+		c := line[i]
+		switch {
+		case quoted && c == d.opts.escape && i+1 < len(line) &&
+			(line[i+1] == d.opts.quote || line[i+1] == d.opts.escape):
+			field.WriteByte(line[i+1])
+			i++
+		case quoted && c == d.opts.quote:
+			quoted = false
+		case quoted:
+			field.WriteByte(c)
+		case c == d.opts.quote:
+			quoted, wasQuoted = true, true
+		case c == d.opts.delimiter:
+			appendValue()
+		default:
+			field.WriteByte(c)
+		}
+	}
+	appendValue()
+	return values, nil
+}
+
+func (d *copyDecoder) binaryRow() ([]interface{}, error) {
+	d.line++
+	var buf [4]byte
+	if _, err := io.ReadFull(d.r, buf[:2]); err != nil {
+		if err == io.EOF {
+			return nil, d.formatError("unexpected EOF in COPY data")
+		}
+		return nil, err
+	}
+	count := int16(binary.BigEndian.Uint16(buf[:2]))
+	if count == -1 {
+		// the trailer, anything after it is ignored
+		return nil, io.EOF
+	}
+	if count < 0 {
+		return nil, d.formatError("row field count is %d, expected %d", count, d.columns)
+	}
+
+	values := make([]interface{}, count)
+	for i := range values {
+		if _, err := io.ReadFull(d.r, buf[:]); err != nil {
+			return nil, d.formatError("unexpected EOF in COPY data")
+		}
+		size := int32(binary.BigEndian.Uint32(buf[:]))
+		if size == -1 {
+			continue
+		}
+		if size < 0 {
+			return nil, d.formatError("invalid field size")
+		}
+		value := make([]byte, size)
+		if _, err := io.ReadFull(d.r, value); err != nil {
+			return nil, d.formatError("unexpected EOF in COPY data")
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func (b *DataQueryBackend) copyOut(ctx context.Context, h CopyHandler, stmt *parser.CopyStmt, opts copyOptions) error {
+	res, err := h.CopyTo(ctx, stmt)
+	if err != nil {
+		return toError(err)
+	}
+	if res.Rows != nil {
+		defer res.Rows.Close()
+	}
+
+	overall, codes := copyFormatCodes(opts.format, len(res.Columns))
+	err = b.send(&pgproto3.CopyOutResponse{OverallFormat: overall, ColumnFormatCodes: codes})
+	if err != nil {
+		return err
+	}
+
+	var buf []byte
+	switch {
+	case opts.format == copyBinary:
+		buf = append(buf, binaryCopySignature...)
+		buf = append(buf, 0, 0, 0, 0, 0, 0, 0, 0)
+	case opts.header:
+		for i, c := range res.Columns {
+			if i > 0 {
+				buf = append(buf, opts.delimiter)
+			}
+			buf = appendCSVValue(buf, []byte(c.Name), opts)
+		}
+		buf = append(buf, '\n')
+	}
+	if len(buf) > 0 {
+		if err := b.send(&pgproto3.CopyData{Data: buf}); err != nil {
+			return err
+		}
+	}
+
+	var rows int64
+	if res.Rows != nil {
+		for res.Rows.Next() {
+			if err := ctx.Err(); err != nil {
+				return toError(err)
+			}
+			buf, err = b.appendCopyRow(buf[:0], res.Columns, res.Rows.Values(), opts)
+			if err != nil {
+				return toError(err)
+			}
+			if err := b.send(&pgproto3.CopyData{Data: buf}); err != nil {
+				return err
+			}
+			rows++
+		}
+		if err := res.Rows.Err(); err != nil {
+			return toError(err)
+		}
+	}
+
+	msgs := []pgproto3.BackendMessage{}
+	if opts.format == copyBinary {
+		msgs = append(msgs, &pgproto3.CopyData{Data: []byte{0xff, 0xff}})
+	}
+	msgs = append(msgs,
+		&pgproto3.CopyDone{},
+		&pgproto3.CommandComplete{CommandTag: []byte(fmt.Sprintf("COPY %d", rows))},
+	)
+	return b.send(msgs...)
+}
+
+// appendCopyRow encodes a row in the format of the options, the values
+// are encoded according to the type of their column.
+func (b *DataQueryBackend) appendCopyRow(buf []byte, columns []pgtype.Column, values []interface{}, opts copyOptions) ([]byte, error) {
+	if opts.format == copyBinary {
+		buf = append(buf, 0, 0)
+		binary.BigEndian.PutUint16(buf[len(buf)-2:], uint16(len(values)))
+	}
+	for i, v := range values {
+		var format int16
+		if opts.format == copyBinary {
+			format = 1
+		}
+		var oid uint32
+		if i < len(columns) {
+			oid = columns[i].TypeOID
+		}
+		value, err := b.types.Encode(oid, v, format)
+		if err != nil {
+			return nil, err
+		}
+
+		switch opts.format {
+		case copyBinary:
+			size := int32(len(value))
+			if v == nil {
+				size = -1
+			}
+			buf = append(buf, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(buf[len(buf)-4:], uint32(size))
+			buf = append(buf, value...)
+			continue
+		}
+
+		if i > 0 {
+			buf = append(buf, opts.delimiter)
+		}
+		switch {
+		case v == nil:
+			buf = append(buf, opts.null...)
+		case opts.format == copyCSV:
+			buf = appendCSVValue(buf, value, opts)
+		default:
+			buf = appendCopyText(buf, value, opts.delimiter)
+		}
+	}
+	if opts.format != copyBinary {
+		buf = append(buf, '\n')
+	}
+	return buf, nil
+}
+
+// appendCopyText escapes the value for the text format
+func appendCopyText(buf, value []byte, delimiter byte) []byte {
+	for _, c := range value {
+		switch c {
+		case '\\':
+			buf = append(buf, '\\', '\\')
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		case '\t':
+			buf = append(buf, '\\', 't')
+		case '\b':
+			buf = append(buf, '\\', 'b')
+		case '\f':
+			buf = append(buf, '\\', 'f')
+		case '\v':
+			buf = append(buf, '\\', 'v')
+		default:
+			if c == delimiter {
+				buf = append(buf, '\\')
+			}
+			buf = append(buf, c)
+		}
+	}
+	return buf
+}
+
+// appendCSVValue quotes the value when it contains special characters or
+// could be mistaken for NULL or the end marker.
+func appendCSVValue(buf, value []byte, opts copyOptions) []byte {
+	needsQuote := string(value) == opts.null || string(value) == `\.` ||
+		bytes.IndexByte(value, opts.delimiter) >= 0 ||
+		bytes.IndexByte(value, opts.quote) >= 0 ||
+		bytes.IndexByte(value, opts.escape) >= 0 ||
+		bytes.ContainsAny(value, "\r\n")
+	if !needsQuote {
+		return append(buf, value...)
+	}
+	buf = append(buf, opts.quote)
+	for _, c := range value {
+		if c == opts.quote || c == opts.escape {
+			buf = append(buf, opts.escape)
+		}
+		buf = append(buf, c)
+	}
+	return append(buf, opts.quote)
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCopyOptions(t *testing.T) {
+	text := copyOptions{format: copyText, delimiter: '\t', null: `\N`}
+	csv := copyOptions{format: copyCSV, delimiter: ',', quote: '"', escape: '"'}
+	tests := []struct {
+		query string
+		opts  copyOptions
+	}{
+		{"COPY items FROM STDIN", text},
+		{"copy Items (ID, \"Name\") from stdin;", text},
+		{"COPY items TO STDOUT WITH (FORMAT csv, HEADER)", func() copyOptions {
+			o := csv
+			o.header = true
+			return o
+		}()},
+		{"COPY items TO STDOUT (FORMAT csv, DELIMITER ';', NULL 'NULL', QUOTE '''')", copyOptions{
+			format: copyCSV, delimiter: ';', null: "NULL", quote: '\'', escape: '\'', nullSet: true,
+		}},
+		{"COPY items FROM STDIN WITH CSV HEADER DELIMITER AS '|'", copyOptions{
+			format: copyCSV, delimiter: '|', header: true, quote: '"', escape: '"',
+		}},
+		{"COPY items FROM STDIN (FORMAT binary)", copyOptions{format: copyBinary}},
+		{"COPY (SELECT a, ')' FROM t WHERE b > 1) TO STDOUT", text},
+	}
+	for _, tt := range tests {
+		stmt, ok := parseCommand(tt.query).(*parser.CopyStmt)
+		require.True(t, ok, tt.query)
+		opts, err := newCopyOptions(stmt)
+		require.NoError(t, err, tt.query)
+		assert.Equal(t, tt.opts, opts, tt.query)
+	}
+
+	for query, code := range map[string]string{
+		"COPY items FROM '/etc/passwd'":                     pgerror.CodeFeatureNotSupported,
+		"COPY items TO STDOUT (FORMAT xml)":                 pgerror.CodeInvalidParameterValue,
+		"COPY items TO STDOUT (FORMAT binary, HEADER)":      pgerror.CodeSyntaxError,
+		"COPY items TO STDOUT (HEADER)":                     pgerror.CodeFeatureNotSupported,
+		"COPY items TO STDOUT (DELIMITER ',,')":             pgerror.CodeFeatureNotSupported,
+		"COPY items TO STDOUT (FORMAT csv, DELIMITER '\"')": pgerror.CodeInvalidParameterValue,
+		"COPY items TO STDOUT (FORCE_QUOTE a)":              pgerror.CodeSyntaxError,
+		"COPY (SELECT 1) FROM STDIN":                        pgerror.CodeFeatureNotSupported,
+	} {
+		stmt, ok := parseCommand(query).(*parser.CopyStmt)
+		require.True(t, ok, query)
+		_, err := newCopyOptions(stmt)
+		require.Error(t, err, query)
+		assert.Equal(t, code, toError(err).Code, query)
+	}
+
+	// statements which do not parse are left to the handler
+	for _, query := range []string{"COPYRIGHT", "COPY items (a, b FROM STDIN", "COPY items FROM STDIN WITH CSV HEADER DELIMITER '|' x"} {
+		assert.Nil(t, parseCommand(query), query)
+	}
+}
+
+// copyHandler stores the rows copied in and copies out its rows
+type copyHandler struct {
+	echoHandler
+	stmt    *parser.CopyStmt
+	rows    [][]interface{}
+	columns []pgtype.Column
+	out     [][]interface{}
+}
+
+func (h *copyHandler) CopyFrom(ctx context.Context, stmt *parser.CopyStmt, rows session.Rows) (int64, error) {
+	h.stmt = stmt
+	for rows.Next() {
+		h.rows = append(h.rows, rows.Values())
+	}
+	return int64(len(h.rows)), rows.Err()
+}
+
+func (h *copyHandler) CopyTo(ctx context.Context, stmt *parser.CopyStmt) (*session.Result, error) {
+	h.stmt = stmt
+	return session.NewResult(h.columns, h.out), nil
+}
+
+// copyIn runs a COPY FROM STDIN sending each chunk as a CopyData message
+// and returns the messages received after CopyDone.
+func copyIn(t *testing.T, f *pgproto3.Frontend, query string, chunks ...string) []pgproto3.BackendMessage {
+	require.NoError(t, f.Send(&pgproto3.Query{String: query}))
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.CopyInResponse{}, msg)
+	for _, chunk := range chunks {
+		require.NoError(t, f.Send(&pgproto3.CopyData{Data: []byte(chunk)}))
+	}
+	require.NoError(t, f.Send(&pgproto3.CopyDone{}))
+
+	var msgs []pgproto3.BackendMessage
+	for {
+		msg, err := f.Receive()
+		require.NoError(t, err)
+		if _, ok := msg.(*pgproto3.ReadyForQuery); ok {
+			return msgs
+		}
+		msgs = append(msgs, copyMessage(t, msg))
+	}
+}
+
+func TestCopyFromText(t *testing.T) {
+	h := &copyHandler{}
+	f := connectBackend(t, h)
+
+	msgs := copyIn(t, f, "COPY items (id, name) FROM STDIN",
+		"1\tapple\n2\t", "tab\\there\\\\\n3\t\\N\r\n", "4\t\\x41\\101\\n\n\\.\n", "ignored after the end marker\n")
+	assert.Equal(t, []pgproto3.BackendMessage{&pgproto3.CommandComplete{CommandTag: []byte("COPY 4")}}, msgs)
+	assert.Equal(t, [][]interface{}{
+		{"1", "apple"},
+		{"2", "tab\there\\"},
+		{"3", nil},
+		{"4", "AA\n"},
+	}, h.rows)
+	assert.Equal(t, []string{"id", "name"}, h.stmt.Columns)
+}
+
+func TestCopyFromCSV(t *testing.T) {
+	h := &copyHandler{}
+	f := connectBackend(t, h)
+
+	msgs := copyIn(t, f, "COPY items FROM STDIN WITH (FORMAT csv, HEADER, DELIMITER ';', NULL 'NULL')",
+		"id;name;note\n", "1;\"multi\nline\";NULL\n", "2;\"NULL\";\"say \"\"hi\"\"\"\n3;;x")
+	assert.Equal(t, []pgproto3.BackendMessage{&pgproto3.CommandComplete{CommandTag: []byte("COPY 3")}}, msgs)
+	assert.Equal(t, [][]interface{}{
+		{"1", "multi\nline", nil},
+		{"2", "NULL", `say "hi"`},
+		{"3", "", "x"},
+	}, h.rows)
+}
+
+func TestCopyFromBinary(t *testing.T) {
+	h := &copyHandler{}
+	f := connectBackend(t, h)
+
+	data := append([]byte{}, binaryCopySignature...)
+	data = append(data, 0, 0, 0, 0, 0, 0, 0, 0)
+	data = append(data, 0, 2, 0, 0, 0, 2, 'h', 'i', 0xff, 0xff, 0xff, 0xff)
+	data = append(data, 0xff, 0xff)
+
+	msgs := copyIn(t, f, "COPY items FROM STDIN (FORMAT binary)", string(data[:7]), string(data[7:]))
+	assert.Equal(t, []pgproto3.BackendMessage{&pgproto3.CommandComplete{CommandTag: []byte("COPY 1")}}, msgs)
+	assert.Equal(t, [][]interface{}{{[]byte("hi"), nil}}, h.rows)
+}
+
+func TestCopyFromErrors(t *testing.T) {
+	h := &copyHandler{}
+	f := connectBackend(t, h)
+
+	// the client aborts the copy
+	require.NoError(t, f.Send(&pgproto3.Query{String: "COPY items FROM STDIN"}))
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.CopyInResponse{}, msg)
+	require.NoError(t, f.Send(&pgproto3.CopyData{Data: []byte("1\n")}))
+	require.NoError(t, f.Send(&pgproto3.CopyFail{Message: "interrupted"}))
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.ErrorResponse{}, msg)
+	assert.Equal(t, pgerror.CodeQueryCanceled, msg.(*pgproto3.ErrorResponse).Code)
+	assert.Equal(t, "COPY from stdin failed: interrupted", msg.(*pgproto3.ErrorResponse).Message)
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.ReadyForQuery{}, msg)
+
+	// the data after a bad row is dropped
+	msgs := copyIn(t, f, "COPY items (a, b) FROM STDIN", "1\t2\n", "1\t2\t3\n", "4\t5\n")
+	require.Len(t, msgs, 1)
+	assert.Equal(t, pgerror.CodeBadCopyFileFormat, msgs[0].(*pgproto3.ErrorResponse).Code)
+	assert.Equal(t, "COPY, line 2", msgs[0].(*pgproto3.ErrorResponse).Where)
+
+	msgs = simpleQuery(t, f, "SELECT 1")
+	assert.Equal(t, &pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}, msgs[len(msgs)-1])
+
+	// handlers which do not implement CopyHandler
+	f = connectBackend(t, echoHandler{})
+	msgs = simpleQuery(t, f, "COPY items FROM STDIN")
+	require.Len(t, msgs, 1)
+	assert.Equal(t, pgerror.CodeFeatureNotSupported, msgs[0].(*pgproto3.ErrorResponse).Code)
+}
+
+// copyOut runs a COPY TO STDOUT and returns the data received
+func copyOut(t *testing.T, f *pgproto3.Frontend, query string) ([]byte, string) {
+	require.NoError(t, f.Send(&pgproto3.Query{String: query}))
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.CopyOutResponse{}, msg)
+
+	var data bytes.Buffer
+	var tag string
+	for {
+		msg, err := f.Receive()
+		require.NoError(t, err)
+		switch msg := msg.(type) {
+		case *pgproto3.CopyData:
+			data.Write(msg.Data)
+		case *pgproto3.CopyDone:
+		case *pgproto3.CommandComplete:
+			tag = string(msg.CommandTag)
+		case *pgproto3.ReadyForQuery:
+			return data.Bytes(), tag
+		default:
+			t.Fatalf("unexpected message %#v", msg)
+		}
+	}
+}
+
+func TestCopyTo(t *testing.T) {
+	h := &copyHandler{
+		columns: []pgtype.Column{TextColumn("id"), TextColumn("name")},
+		out: [][]interface{}{
+			{1, "plain"},
+			{2, "a\tb,c\n\"d\"\\"},
+			{3, nil},
+			{4, ""},
+		},
+	}
+	f := connectBackend(t, h)
+
+	data, tag := copyOut(t, f, "COPY items TO STDOUT")
+	assert.Equal(t, "COPY 4", tag)
+	assert.Equal(t, "1\tplain\n2\ta\\tb,c\\n\"d\"\\\\\n3\t\\N\n4\t\n", string(data))
+
+	data, _ = copyOut(t, f, "COPY (SELECT * FROM items) TO STDOUT WITH (FORMAT csv, HEADER)")
+	assert.Equal(t, "SELECT * FROM items", h.stmt.Query.String())
+	assert.Equal(t, "id,name\n1,plain\n2,\"a\tb,c\n\"\"d\"\"\\\"\n3,\n4,\"\"\n", string(data))
+
+	// the output can be read back
+	h.out = [][]interface{}{{"x", nil}, {"y", "z"}}
+	data, _ = copyOut(t, f, "COPY items TO STDOUT (FORMAT binary)")
+	assert.True(t, bytes.HasPrefix(data, binaryCopySignature))
+	assert.True(t, bytes.HasSuffix(data, []byte{0xff, 0xff}))
+	h.rows = nil
+	msgs := copyIn(t, f, "COPY items FROM STDIN (FORMAT binary)", string(data))
+	assert.Equal(t, []pgproto3.BackendMessage{&pgproto3.CommandComplete{CommandTag: []byte("COPY 2")}}, msgs)
+	assert.Equal(t, [][]interface{}{{[]byte("x"), nil}, {[]byte("y"), []byte("z")}}, h.rows)
+
+	data, _ = copyOut(t, f, "COPY items TO STDOUT (FORMAT csv)")
+	h.rows = nil
+	copyIn(t, f, "COPY items FROM STDIN (FORMAT csv)", strings.ReplaceAll(string(data), "\n", "\r\n"))
+	assert.Equal(t, [][]interface{}{{"x", nil}, {"y", "z"}}, h.rows)
+}
@@ -0,0 +1,286 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"context"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/session"
+)
+
+// newPortal returns a portal which executes the statement once it is first
+// executed or fetched from
+func (b *DataQueryBackend) newPortal(name string, stmt *preparedStatement, args []interface{}, resultFormats []int16) *portal {
+	p := &portal{name: name, stmt: stmt, args: args, resultFormats: resultFormats}
+	p.ctx, p.cancel = context.WithCancel(b.ctx)
+	return p
+}
+
+// openPortal executes the statement of the portal unless it already has been.
+// The rows are read over several executions, so the handler is passed a
+// context derived from the one of the portal which a CancelRequest only
+// cancels while the statement is being executed. Later executions are
+// canceled with their own context, the portal remains usable.
+func (b *DataQueryBackend) openPortal(p *portal) error {
+	if p.res != nil || p.done {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(p.ctx)
+	b.cancelAlso(cancel)
+	res, err := b.execute(ctx, p.stmt.query, p.args)
+	if err != nil {
+		cancel()
+		return err
+	}
+	if res.Rows == nil {
+		res.Rows = session.NewRows(nil)
+	}
+	p.res, p.columns = res, res.Columns
+	return nil
+}
+
+// finish releases the rows of the portal once they have all been read,
+// executing it again returns no rows.
+func (p *portal) finish() {
+	if p.res != nil {
+		_ = p.res.Rows.Close()
+		p.res = nil
+	}
+	p.done = true
+}
+
+// close releases the rows of the portal and cancels its context
+func (p *portal) close() {
+	p.finish()
+	p.cancel()
+}
+
+// closePortal closes and removes the portal, it is not an error when it
+// does not exist
+func (b *DataQueryBackend) closePortal(name string) {
+	if p, ok := b.portals[name]; ok {
+		p.close()
+		delete(b.portals, name)
+	}
+}
+
+// sendPortalRows sends up to limit rows of the portal, all when limit is
+// 0. PortalSuspended is sent in place of CommandComplete when the limit is
+// reached, the next execution continues where this one stopped.
+func (b *DataQueryBackend) sendPortalRows(ctx context.Context, p *portal, limit int64) error {
+	if p.done {
+		return b.send(&pgproto3.CommandComplete{CommandTag: []byte(commandTag(p.stmt.query, 0))})
+	}
+	res := p.res
+	if res.Columns == nil {
+		p.finish()
+		return b.send(&pgproto3.CommandComplete{CommandTag: []byte(resultTag(p.stmt.query, res, 0))})
+	}
+
+	count := limit
+	if count == 0 {
+		count = -1
+	}
+	n, err := b.sendRows(ctx, &session.Result{Columns: res.Columns, Rows: &portalRows{p: p, count: count}}, p.resultFormats)
+	if err != nil {
+		p.finish()
+		return err
+	}
+	if !p.done {
+		return b.send(&pgproto3.PortalSuspended{})
+	}
+	return b.send(&pgproto3.CommandComplete{CommandTag: []byte(resultTag(p.stmt.query, res, n))})
+}
+
+// portalRows reads up to count rows of the portal, all when count is -1.
+// The portal is finished once its rows are exhausted.
+type portalRows struct {
+	p     *portal
+	count int64
+	err   error
+}
+
+func (r *portalRows) Next() bool {
+	if r.count == 0 || r.p.res == nil {
+		return false
+	}
+	rows := r.p.res.Rows
+	if !rows.Next() {
+		r.err = rows.Err()
+		r.p.finish()
+		return false
+	}
+	if r.count > 0 {
+		r.count--
+	}
+	return true
+}
+
+func (r *portalRows) Values() []interface{} { return r.p.res.Rows.Values() }
+func (r *portalRows) Err() error            { return r.err }
+func (r *portalRows) Close() error          { return nil }
+
+// cursorCommand is a DECLARE, FETCH, MOVE or CLOSE statement
+type cursorCommand struct {
+	tag  string
+	name string
+	// query and binary are set for DECLARE
+	query  string
+	binary bool
+	// count is the number of rows to FETCH or MOVE, -1 for all
+	count int64
+}
+
+// newCursorCommand returns the command of a DECLARE, FETCH, MOVE or CLOSE
+// statement. Only the forward directions of FETCH and MOVE are supported.
+// https://www.postgresql.org/docs/14/sql-declare.html
+func newCursorCommand(stmt parser.Statement) (*cursorCommand, error) {
+	switch stmt := stmt.(type) {
+	case *parser.DeclareCursorStmt:
+		if stmt.Scroll {
+			return nil, pgerror.NewError(pgerror.CodeFeatureNotSupported, "scrollable cursors are not supported")
+		}
+		return &cursorCommand{tag: "DECLARE", name: stmt.Name, query: stmt.Query.String(), binary: stmt.Binary}, nil
+	case *parser.FetchStmt:
+		if stmt.Direction != parser.FetchForward || stmt.Count < 0 {
+			return nil, pgerror.NewError(pgerror.CodeFeatureNotSupported, "cursor can only scan forward")
+		}
+		cmd := &cursorCommand{tag: "FETCH", name: stmt.Name, count: stmt.Count}
+		if stmt.Move {
+			cmd.tag = "MOVE"
+		}
+		if stmt.All {
+			cmd.count = -1
+		}
+		return cmd, nil
+	default:
+		return &cursorCommand{tag: "CLOSE", name: stmt.(*parser.CloseStmt).Name}, nil
+	}
+}
+
+// cursor returns the named portal
+func (b *DataQueryBackend) cursor(name string) (*portal, error) {
+	p, ok := b.portals[name]
+	if !ok || name == "" {
+		return nil, pgerror.NewError(pgerror.CodeInvalidCursorName, "cursor \"%s\" does not exist", name)
+	}
+	return p, nil
+}
+
+// cursorColumns returns the columns of the rows returned by FETCH
+func (b *DataQueryBackend) cursorColumns(cmd *cursorCommand) ([]pgtype.Column, error) {
+	if cmd.tag != "FETCH" {
+		return nil, nil
+	}
+	p, err := b.cursor(cmd.name)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.openPortal(p); err != nil {
+		return nil, err
+	}
+	return fetchColumns(p), nil
+}
+
+// fetchColumns applies the format of a BINARY cursor to its columns
+func fetchColumns(p *portal) []pgtype.Column {
+	columns := append([]pgtype.Column(nil), p.columns...)
+	for i := range columns {
+		columns[i].Format = formatCode(p.resultFormats, i, columns[i].Format)
+	}
+	return columns
+}
+
+// execCursorCommand runs a DECLARE, FETCH, MOVE or CLOSE statement, ctx
+// is the context of the execution
+func (b *DataQueryBackend) execCursorCommand(ctx context.Context, cmd *cursorCommand, args []interface{}) (*session.Result, error) {
+	switch cmd.tag {
+	case "DECLARE":
+		if cmd.name == "" {
+			return nil, pgerror.NewError(pgerror.CodeInvalidCursorName, "invalid cursor name: must not be empty")
+		}
+		if _, ok := b.portals[cmd.name]; ok {
+			return nil, pgerror.NewError(pgerror.CodeDuplicateCursor, "cursor \"%s\" already exists", cmd.name)
+		}
+		var formats []int16
+		if cmd.binary {
+			formats = []int16{1}
+		}
+		stmt := &preparedStatement{query: cmd.query}
+		p := b.newPortal(cmd.name, stmt, args, formats)
+		if err := b.openPortal(p); err != nil {
+			p.close()
+			return nil, err
+		}
+		if p.columns == nil {
+			p.close()
+			return nil, pgerror.NewError(pgerror.CodeInvalidCursorDefinition, "cannot open %s query as cursor", firstKeyword(cmd.query))
+		}
+		b.portals[cmd.name] = p
+		return &session.Result{CommandTag: "DECLARE CURSOR"}, nil
+
+	case "FETCH", "MOVE":
+		p, err := b.cursor(cmd.name)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.openPortal(p); err != nil {
+			return nil, err
+		}
+		columns := fetchColumns(p)
+		rows := &portalRows{p: p, count: cmd.count}
+		if cmd.tag == "FETCH" {
+			return &session.Result{Columns: columns, Rows: rows}, nil
+		}
+		var n int64
+		for rows.Next() {
+			if err := ctx.Err(); err != nil {
+				return nil, toError(err)
+			}
+			n++
+		}
+		return &session.Result{RowsAffected: n}, rows.Err()
+
+	default:
+		if cmd.name == "" {
+			for name := range b.portals {
+				if name != "" {
+					b.closePortal(name)
+				}
+			}
+			return &session.Result{CommandTag: "CLOSE CURSOR ALL"}, nil
+		}
+		if _, err := b.cursor(cmd.name); err != nil {
+			return nil, err
+		}
+		b.closePortal(cmd.name)
+		return &session.Result{CommandTag: "CLOSE CURSOR"}, nil
+	}
+}
+
+// firstKeyword returns the upper case first word of the query
+func firstKeyword(query string) string {
+	if words := leadingKeywords(query, 1); len(words) > 0 {
+		return words[0]
+	}
+	return ""
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countRows generates the integers from 1 to n, read counts the rows
+// which have been read from it
+type countRows struct {
+	mu      sync.Mutex
+	n, read int
+	closed  bool
+}
+
+func (r *countRows) Next() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.read >= r.n {
+		return false
+	}
+	r.read++
+	return true
+}
+
+func (r *countRows) Values() []interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return []interface{}{r.read}
+}
+
+func (r *countRows) Err() error { return nil }
+
+func (r *countRows) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+func (r *countRows) state() (read int, closed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.read, r.closed
+}
+
+// countHandler answers every query with the rows of a new countRows
+type countHandler struct {
+	rows chan *countRows
+	n    int
+}
+
+func newCountHandler(n int) *countHandler {
+	return &countHandler{rows: make(chan *countRows, 10), n: n}
+}
+
+func (h *countHandler) Describe(ctx context.Context, query string) ([]pgtype.Column, error) {
+	return []pgtype.Column{pgtype.NewColumn("n", pgtype.Int4OID)}, nil
+}
+
+func (h *countHandler) Execute(ctx context.Context, query string, args []interface{}) (*session.Result, error) {
+	rows := &countRows{n: h.n}
+	h.rows <- rows
+	return &session.Result{Columns: []pgtype.Column{pgtype.NewColumn("n", pgtype.Int4OID)}, Rows: rows}, nil
+}
+
+func dataRows(msgs []pgproto3.BackendMessage) []string {
+	var values []string
+	for _, msg := range msgs {
+		if row, ok := msg.(*pgproto3.DataRow); ok {
+			values = append(values, string(row.Values[0]))
+		}
+	}
+	return values
+}
+
+func TestNewCursorCommand(t *testing.T) {
+	tests := []struct {
+		query string
+		cmd   *cursorCommand
+	}{
+		{"DECLARE c CURSOR FOR SELECT 1", &cursorCommand{tag: "DECLARE", name: "c", query: "SELECT 1"}},
+		{`declare "My Cursor" binary no scroll cursor with hold for select * from t;`,
+			&cursorCommand{tag: "DECLARE", name: "My Cursor", binary: true, query: "SELECT * FROM t"}},
+		{"FETCH c", &cursorCommand{tag: "FETCH", name: "c", count: 1}},
+		{"FETCH NEXT FROM c", &cursorCommand{tag: "FETCH", name: "c", count: 1}},
+		{"fetch 10 in c", &cursorCommand{tag: "FETCH", name: "c", count: 10}},
+		{"FETCH FORWARD 5 FROM C", &cursorCommand{tag: "FETCH", name: "c", count: 5}},
+		{"FETCH ALL c", &cursorCommand{tag: "FETCH", name: "c", count: -1}},
+		{"FETCH FORWARD ALL FROM c", &cursorCommand{tag: "FETCH", name: "c", count: -1}},
+		{"MOVE 3 c", &cursorCommand{tag: "MOVE", name: "c", count: 3}},
+		{"CLOSE c", &cursorCommand{tag: "CLOSE", name: "c"}},
+		{"CLOSE ALL", &cursorCommand{tag: "CLOSE"}},
+	}
+	for _, tt := range tests {
+		stmt := parseCommand(tt.query)
+		require.NotNil(t, stmt, tt.query)
+		cmd, err := newCursorCommand(stmt)
+		require.NoError(t, err, tt.query)
+		assert.Equal(t, tt.cmd, cmd, tt.query)
+	}
+
+	// statements which do not parse are left to the handler
+	for _, query := range []string{"DECLARE c CURSOR", "DECLARE c FOR SELECT 1", "FETCH 1 2 c", "CLOSE"} {
+		assert.Nil(t, parseCommand(query), query)
+	}
+
+	for _, query := range []string{"DECLARE c SCROLL CURSOR FOR SELECT 1", "FETCH PRIOR FROM c", "FETCH -1 FROM c", "MOVE BACKWARD ALL c"} {
+		stmt := parseCommand(query)
+		require.NotNil(t, stmt, query)
+		_, err := newCursorCommand(stmt)
+		var pgErr *pgerror.Error
+		require.ErrorAs(t, err, &pgErr, query)
+		assert.Equal(t, pgerror.CodeFeatureNotSupported, pgErr.Code, query)
+	}
+}
+
+func TestPortalSuspended(t *testing.T) {
+	h := newCountHandler(5)
+	f := connectBackend(t, h)
+
+	require.NoError(t, f.Send(&pgproto3.Parse{Query: "select n"}))
+	require.NoError(t, f.Send(&pgproto3.Bind{}))
+	require.NoError(t, f.Send(&pgproto3.Execute{MaxRows: 2}))
+	require.NoError(t, f.Send(&pgproto3.Sync{}))
+	msgs := receiveUntilReady(t, f)
+	assert.Equal(t, []string{"1", "2"}, dataRows(msgs))
+	assert.IsType(t, &pgproto3.PortalSuspended{}, msgs[len(msgs)-1])
+
+	// only the rows sent have been read from the handler
+	rows := <-h.rows
+	read, _ := rows.state()
+	assert.Equal(t, 2, read)
+
+	require.NoError(t, f.Send(&pgproto3.Execute{MaxRows: 2}))
+	require.NoError(t, f.Send(&pgproto3.Execute{MaxRows: 2}))
+	require.NoError(t, f.Send(&pgproto3.Execute{MaxRows: 2}))
+	require.NoError(t, f.Send(&pgproto3.Sync{}))
+	msgs = receiveUntilReady(t, f)
+	assert.Equal(t, []string{"3", "4", "5"}, dataRows(msgs))
+	assert.Equal(t, []pgproto3.BackendMessage{
+		&pgproto3.PortalSuspended{},
+		&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")},
+		&pgproto3.CommandComplete{CommandTag: []byte("SELECT 0")},
+	}, []pgproto3.BackendMessage{msgs[2], msgs[4], msgs[5]})
+	_, closed := rows.state()
+	assert.True(t, closed)
+
+	// binding the unnamed portal again closes the suspended one
+	require.NoError(t, f.Send(&pgproto3.Bind{}))
+	require.NoError(t, f.Send(&pgproto3.Execute{MaxRows: 1}))
+	require.NoError(t, f.Send(&pgproto3.Bind{}))
+	require.NoError(t, f.Send(&pgproto3.Execute{}))
+	require.NoError(t, f.Send(&pgproto3.Sync{}))
+	msgs = receiveUntilReady(t, f)
+	assert.Equal(t, []string{"1", "1", "2", "3", "4", "5"}, dataRows(msgs))
+	_, closed = (<-h.rows).state()
+	assert.True(t, closed)
+	assert.Equal(t, &pgproto3.CommandComplete{CommandTag: []byte("SELECT 5")}, msgs[len(msgs)-1])
+}
+
+func TestCursor(t *testing.T) {
+	h := newCountHandler(5)
+	f := connectBackend(t, h)
+
+	msgs := simpleQuery(t, f, "DECLARE c CURSOR FOR SELECT n")
+	assert.Equal(t, []pgproto3.BackendMessage{&pgproto3.CommandComplete{CommandTag: []byte("DECLARE CURSOR")}}, msgs)
+	rows := <-h.rows
+	read, _ := rows.state()
+	assert.Equal(t, 0, read)
+
+	msgs = simpleQuery(t, f, "FETCH 2 FROM c")
+	require.Len(t, msgs, 4)
+	assert.Equal(t, "n", string(msgs[0].(*pgproto3.RowDescription).Fields[0].Name))
+	assert.Equal(t, []string{"1", "2"}, dataRows(msgs))
+	assert.Equal(t, &pgproto3.CommandComplete{CommandTag: []byte("FETCH 2")}, msgs[3])
+
+	msgs = simpleQuery(t, f, "MOVE 1 c")
+	assert.Equal(t, []pgproto3.BackendMessage{&pgproto3.CommandComplete{CommandTag: []byte("MOVE 1")}}, msgs)
+
+	msgs = simpleQuery(t, f, "FETCH ALL c")
+	assert.Equal(t, []string{"4", "5"}, dataRows(msgs))
+	assert.Equal(t, &pgproto3.CommandComplete{CommandTag: []byte("FETCH 2")}, msgs[len(msgs)-1])
+	_, closed := rows.state()
+	assert.True(t, closed)
+
+	msgs = simpleQuery(t, f, "FETCH c")
+	require.Len(t, msgs, 2)
+	assert.Equal(t, &pgproto3.CommandComplete{CommandTag: []byte("FETCH 0")}, msgs[1])
+
+	msgs = simpleQuery(t, f, "CLOSE c")
+	assert.Equal(t, []pgproto3.BackendMessage{&pgproto3.CommandComplete{CommandTag: []byte("CLOSE CURSOR")}}, msgs)
+
+	msgs = simpleQuery(t, f, "FETCH c")
+	require.IsType(t, &pgproto3.ErrorResponse{}, msgs[0])
+	assert.Equal(t, pgerror.CodeInvalidCursorName, msgs[0].(*pgproto3.ErrorResponse).Code)
+}
+
+func TestCursorBinaryAndExtended(t *testing.T) {
+	h := newCountHandler(3)
+	f := connectBackend(t, h)
+
+	msgs := simpleQuery(t, f, "DECLARE c BINARY CURSOR FOR SELECT n; DECLARE c CURSOR FOR SELECT n")
+	require.Len(t, msgs, 2)
+	require.IsType(t, &pgproto3.ErrorResponse{}, msgs[1])
+	assert.Equal(t, pgerror.CodeDuplicateCursor, msgs[1].(*pgproto3.ErrorResponse).Code)
+	rows := <-h.rows
+
+	msgs = simpleQuery(t, f, "FETCH c")
+	require.Len(t, msgs, 3)
+	assert.Equal(t, int16(1), msgs[0].(*pgproto3.RowDescription).Fields[0].Format)
+	assert.Equal(t, [][]byte{{0, 0, 0, 1}}, msgs[1].(*pgproto3.DataRow).Values)
+
+	// a cursor is a portal which can be executed with the extended protocol
+	require.NoError(t, f.Send(&pgproto3.Execute{Portal: "c", MaxRows: 1}))
+	require.NoError(t, f.Send(&pgproto3.Sync{}))
+	msgs = receiveUntilReady(t, f)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, [][]byte{{0, 0, 0, 2}}, msgs[0].(*pgproto3.DataRow).Values)
+	assert.IsType(t, &pgproto3.PortalSuspended{}, msgs[1])
+
+	msgs = simpleQuery(t, f, "CLOSE ALL")
+	assert.Equal(t, []pgproto3.BackendMessage{&pgproto3.CommandComplete{CommandTag: []byte("CLOSE CURSOR ALL")}}, msgs)
+	_, closed := rows.state()
+	assert.True(t, closed)
+}
+
+func TestCursorRequiresRows(t *testing.T) {
+	f := connectBackend(t, funcHandler(func(ctx context.Context, query string, args []interface{}) (*session.Result, error) {
+		return &session.Result{RowsAffected: 1}, nil
+	}))
+	msgs := simpleQuery(t, f, "DECLARE c CURSOR FOR SELECT f()")
+	require.IsType(t, &pgproto3.ErrorResponse{}, msgs[0])
+	assert.Equal(t, pgerror.CodeInvalidCursorDefinition, msgs[0].(*pgproto3.ErrorResponse).Code)
+	assert.Equal(t, "cannot open SELECT query as cursor", msgs[0].(*pgproto3.ErrorResponse).Message)
+}
@@ -0,0 +1,122 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"unicode/utf8"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+)
+
+// toError converts any error into a *pgerror.Error, errors which are not
+// already one are reported as internal errors except for syntax errors of
+// the SQL parser.
+func toError(err error) *pgerror.Error {
+	var pgErr *pgerror.Error
+	if errors.As(err, &pgErr) {
+		return pgErr
+	}
+	var syntaxErr *parser.Error
+	if errors.As(err, &syntaxErr) {
+		return &pgerror.Error{
+			Severity: pgerror.SeverityError,
+			Code:     pgerror.CodeSyntaxError,
+			Message:  syntaxErr.Message,
+			Position: int32(syntaxErr.Position),
+		}
+	}
+	if errors.Is(err, context.Canceled) {
+		return pgerror.NewError(pgerror.CodeQueryCanceled, "canceling statement due to user request")
+	}
+	return pgerror.NewError(pgerror.CodeInternalError, "%s", err.Error())
+}
+
+// shiftError returns the error with its position moved past the characters
+// of prefix, positions of a statement then become positions in the query
+// string holding it.
+func shiftError(e *pgerror.Error, prefix string) *pgerror.Error {
+	if e.Position <= 0 || prefix == "" {
+		return e
+	}
+	shifted := *e
+	shifted.Position += int32(utf8.RuneCountInString(prefix))
+	return &shifted
+}
+
+func errorFields(e *pgerror.Error) pgproto3.ErrorResponse {
+	severity := e.Severity
+	if severity == "" {
+		severity = pgerror.SeverityError
+	}
+	code := e.Code
+	if code == "" {
+		code = pgerror.CodeInternalError
+	}
+	return pgproto3.ErrorResponse{
+		Severity:            severity,
+		SeverityUnlocalized: severity,
+		Code:                code,
+		Message:             e.Message,
+		Detail:              e.Detail,
+		Hint:                e.Hint,
+		Position:            e.Position,
+		InternalPosition:    e.InternalPosition,
+		InternalQuery:       e.InternalQuery,
+		Where:               e.Where,
+		SchemaName:          e.SchemaName,
+		TableName:           e.TableName,
+		ColumnName:          e.ColumnName,
+		DataTypeName:        e.DataTypeName,
+		ConstraintName:      e.ConstraintName,
+		File:                e.File,
+		Line:                e.Line,
+		Routine:             e.Routine,
+	}
+}
+
+// errorResponse encodes the error as the wire protocol ErrorResponse message
+func errorResponse(e *pgerror.Error) *pgproto3.ErrorResponse {
+	msg := errorFields(e)
+	return &msg
+}
+
+// noticeResponse encodes the error as the wire protocol NoticeResponse message
+func noticeResponse(e *pgerror.Error) *pgproto3.NoticeResponse {
+	msg := pgproto3.NoticeResponse(errorFields(e))
+	return &msg
+}
+
+// SendNotice sends a NoticeResponse to the client right away, it
+// implements session.Session. Notices without a severity are sent as
+// NOTICE and without a code as 00000.
+func (b *DataQueryBackend) SendNotice(notice *pgerror.Error) error {
+	n := *notice
+	if n.Severity == "" {
+		n.Severity = pgerror.SeverityNotice
+	}
+	if n.Code == "" {
+		n.Code = pgerror.CodeSuccessfulCompletion
+	}
+	// like postgres notices are flushed right away so the client sees them
+	// while the query is still running
+	return b.sendNow(noticeResponse(&n))
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// funcHandler executes every query with the function, Describe reports
+// that no rows are returned.
+type funcHandler func(ctx context.Context, query string, args []interface{}) (*session.Result, error)
+
+func (f funcHandler) Describe(ctx context.Context, query string) ([]pgtype.Column, error) {
+	return nil, nil
+}
+
+func (f funcHandler) Execute(ctx context.Context, query string, args []interface{}) (*session.Result, error) {
+	return f(ctx, query, args)
+}
+
+func TestToError(t *testing.T) {
+	pgErr := pgerror.NewError(pgerror.CodeSyntaxError, "syntax error at or near %q", "x")
+	assert.Same(t, pgErr, toError(pgErr))
+	assert.Equal(t, `ERROR: syntax error at or near "x" (SQLSTATE 42601)`, pgErr.Error())
+
+	err := toError(errors.New("boom"))
+	assert.Equal(t, pgerror.CodeInternalError, err.Code)
+	assert.Equal(t, "boom", err.Message)
+
+	err = toError(fmt.Errorf("reading rows: %w", context.Canceled))
+	assert.Equal(t, pgerror.CodeQueryCanceled, err.Code)
+
+	_, parseErr := parser.Parse("SELECT 1 +")
+	err = toError(parseErr)
+	assert.Equal(t, pgerror.CodeSyntaxError, err.Code)
+	assert.Equal(t, "syntax error at end of input", err.Message)
+	assert.Equal(t, int32(11), err.Position)
+	assert.Equal(t, int32(21), shiftError(err, "SELECT 2; ").Position)
+}
+
+func TestError_SimpleQuery(t *testing.T) {
+	f := connectBackend(t, funcHandler(func(ctx context.Context, query string, args []interface{}) (*session.Result, error) {
+		if query == "fail" {
+			return nil, &pgerror.Error{Code: pgerror.CodeSyntaxError, Message: "bad query", Hint: "try again", Position: 3}
+		}
+		return &session.Result{CommandTag: "OK"}, nil
+	}))
+
+	require.NoError(t, f.Send(&pgproto3.Query{String: "fail"}))
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.ErrorResponse{}, msg)
+	errMsg := msg.(*pgproto3.ErrorResponse)
+	assert.Equal(t, pgerror.SeverityError, errMsg.Severity)
+	assert.Equal(t, pgerror.CodeSyntaxError, errMsg.Code)
+	assert.Equal(t, "try again", errMsg.Hint)
+	assert.Equal(t, int32(3), errMsg.Position)
+
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.ReadyForQuery{}, msg)
+
+	// the session is still usable
+	require.NoError(t, f.Send(&pgproto3.Query{String: "ok"}))
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	assert.Equal(t, "OK", string(msg.(*pgproto3.CommandComplete).CommandTag))
+}
+
+func TestError_ExtendedSkipsUntilSync(t *testing.T) {
+	f := connectBackend(t, echoHandler{})
+
+	require.NoError(t, f.Send(&pgproto3.Bind{PreparedStatement: "missing"}))
+	require.NoError(t, f.Send(&pgproto3.Execute{}))
+	require.NoError(t, f.Send(&pgproto3.Sync{}))
+
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.ErrorResponse{}, msg)
+	assert.Equal(t, pgerror.CodeInvalidSQLStatementName, msg.(*pgproto3.ErrorResponse).Code)
+
+	// the Execute is skipped rather than reporting a second error
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.ReadyForQuery{}, msg)
+}
+
+func TestSendNotice(t *testing.T) {
+	assert.Error(t, session.SendNotice(context.Background(), &pgerror.Error{Message: "lost"}))
+
+	f := connectBackend(t, funcHandler(func(ctx context.Context, query string, args []interface{}) (*session.Result, error) {
+		err := session.SendNotice(ctx, &pgerror.Error{Severity: pgerror.SeverityWarning, Message: "careful"})
+		return &session.Result{CommandTag: "OK"}, err
+	}))
+
+	require.NoError(t, f.Send(&pgproto3.Query{String: "warn"}))
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.NoticeResponse{}, msg)
+	notice := msg.(*pgproto3.NoticeResponse)
+	assert.Equal(t, pgerror.SeverityWarning, notice.Severity)
+	assert.Equal(t, pgerror.CodeSuccessfulCompletion, notice.Code)
+	assert.Equal(t, "careful", notice.Message)
+
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.CommandComplete{}, msg)
+}
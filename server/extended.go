@@ -0,0 +1,244 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/session"
+	"github.com/rs/zerolog/log"
+)
+
+// preparedStatement is the result of a Parse message
+type preparedStatement struct {
+	name      string
+	query     string
+	paramOIDs []uint32
+}
+
+// portal is a prepared statement bound to its parameters via Bind
+type portal struct {
+	name          string
+	stmt          *preparedStatement
+	args          []interface{}
+	resultFormats []int16
+
+	// ctx is passed to the handler when the portal is first executed, it
+	// is canceled once the portal is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+	// res holds the rows not yet sent of a suspended portal, done is set
+	// once they have all been sent
+	res     *session.Result
+	columns []pgtype.Column
+	done    bool
+}
+
+func (b *DataQueryBackend) handleParse(msg *pgproto3.Parse) error {
+	if msg.Name != "" {
+		if _, ok := b.statements[msg.Name]; ok {
+			return pgerror.NewError(pgerror.CodeDuplicatePreparedStatement, "prepared statement \"%s\" already exists", msg.Name)
+		}
+	}
+	log.Debug().Str("statement", msg.Name).Str("query", msg.Query).Msg("parse")
+	if len(splitStatements(msg.Query)) > 1 {
+		return pgerror.NewError(pgerror.CodeSyntaxError, "cannot insert multiple commands into a prepared statement")
+	}
+
+	// the client may declare fewer parameter types than the query uses or
+	// leave them unspecified (0), fill in the gaps with text
+	n := countParams(msg.Query)
+	if len(msg.ParameterOIDs) > n {
+		n = len(msg.ParameterOIDs)
+	}
+	oids := make([]uint32, n)
+	for i := range oids {
+		oids[i] = pgtype.TextOID
+		if i < len(msg.ParameterOIDs) && msg.ParameterOIDs[i] != 0 {
+			oids[i] = msg.ParameterOIDs[i]
+		}
+	}
+
+	b.statements[msg.Name] = &preparedStatement{
+		name:      msg.Name,
+		query:     msg.Query,
+		paramOIDs: oids,
+	}
+	return b.send(&pgproto3.ParseComplete{})
+}
+
+func (b *DataQueryBackend) handleBind(msg *pgproto3.Bind) error {
+	stmt, ok := b.statements[msg.PreparedStatement]
+	if !ok {
+		return pgerror.NewError(pgerror.CodeInvalidSQLStatementName, "prepared statement \"%s\" does not exist", msg.PreparedStatement)
+	}
+	if msg.DestinationPortal != "" {
+		if _, ok := b.portals[msg.DestinationPortal]; ok {
+			return pgerror.NewError(pgerror.CodeDuplicateCursor, "portal \"%s\" already exists", msg.DestinationPortal)
+		}
+	}
+	if len(msg.Parameters) != len(stmt.paramOIDs) {
+		return pgerror.NewError(pgerror.CodeProtocolViolation, "bind message supplies %d parameters, but prepared statement \"%s\" requires %d",
+			len(msg.Parameters), stmt.name, len(stmt.paramOIDs))
+	}
+
+	if n := len(msg.ParameterFormatCodes); n > 1 && n != len(msg.Parameters) {
+		return pgerror.NewError(pgerror.CodeProtocolViolation, "bind message has %d parameter formats but %d parameters", n, len(msg.Parameters))
+	}
+	if err := checkFormatCodes(msg.ParameterFormatCodes); err != nil {
+		return err
+	}
+	if err := checkFormatCodes(msg.ResultFormatCodes); err != nil {
+		return err
+	}
+
+	// the parameters are decoded right away as the message is only valid
+	// until the next one is received
+	args := make([]interface{}, len(msg.Parameters))
+	for i, param := range msg.Parameters {
+		format := formatCode(msg.ParameterFormatCodes, i, 0)
+		arg, err := b.types.Decode(stmt.paramOIDs[i], param, format)
+		if err != nil {
+			var pgErr *pgerror.Error
+			if format == 1 && errors.As(err, &pgErr) && pgErr.Code == pgerror.CodeInvalidBinaryRepresentation {
+				return pgerror.NewError(pgerror.CodeInvalidBinaryRepresentation, "incorrect binary data format in bind parameter %d", i+1)
+			}
+			return err
+		}
+		args[i] = arg
+	}
+
+	// binding the unnamed portal replaces the previous one
+	b.closePortal(msg.DestinationPortal)
+	b.portals[msg.DestinationPortal] = b.newPortal(msg.DestinationPortal, stmt, args,
+		append([]int16(nil), msg.ResultFormatCodes...))
+	return b.send(&pgproto3.BindComplete{})
+}
+
+// checkFormatCodes rejects codes other than text (0) and binary (1)
+func checkFormatCodes(formats []int16) error {
+	for _, f := range formats {
+		if f != 0 && f != 1 {
+			return pgerror.NewError(pgerror.CodeProtocolViolation, "unsupported format code: %d", f)
+		}
+	}
+	return nil
+}
+
+func (b *DataQueryBackend) handleDescribe(msg *pgproto3.Describe) error {
+	switch msg.ObjectType {
+	case 'S':
+		stmt, ok := b.statements[msg.Name]
+		if !ok {
+			return pgerror.NewError(pgerror.CodeInvalidSQLStatementName, "prepared statement \"%s\" does not exist", msg.Name)
+		}
+		err := b.send(&pgproto3.ParameterDescription{ParameterOIDs: stmt.paramOIDs})
+		if err != nil {
+			return err
+		}
+		return b.sendRowDescription(stmt.query, nil)
+	case 'P':
+		p, ok := b.portals[msg.Name]
+		if !ok {
+			return pgerror.NewError(pgerror.CodeInvalidCursorName, "portal \"%s\" does not exist", msg.Name)
+		}
+		return b.sendRowDescription(p.stmt.query, p.resultFormats)
+	default:
+		return pgerror.NewError(pgerror.CodeProtocolViolation, "invalid DESCRIBE message subtype %d", msg.ObjectType)
+	}
+}
+
+// sendRowDescription describes the rows returned by the query, or NoData
+// when it does not return any.
+func (b *DataQueryBackend) sendRowDescription(query string, formats []int16) error {
+	ctx, done := b.startQuery()
+	defer done()
+	columns, err := b.describe(ctx, query)
+	if err != nil {
+		return toError(err)
+	}
+	if columns == nil {
+		return b.send(&pgproto3.NoData{})
+	}
+	return b.send(&pgproto3.RowDescription{Fields: fieldDescriptions(columns, formats)})
+}
+
+func (b *DataQueryBackend) handleExecute(msg *pgproto3.Execute) error {
+	p, ok := b.portals[msg.Portal]
+	if !ok {
+		return pgerror.NewError(pgerror.CodeInvalidCursorName, "portal \"%s\" does not exist", msg.Portal)
+	}
+	log.Info().Str("portal", p.name).Str("query", p.stmt.query).Msg("sql execute")
+	if isEmptyQuery(p.stmt.query) {
+		return b.send(&pgproto3.EmptyQueryResponse{})
+	}
+
+	ctx, done := b.startQuery()
+	defer done()
+	if stmt, ok := parseCommand(p.stmt.query).(*parser.CopyStmt); ok {
+		return b.executeCopy(ctx, stmt)
+	}
+
+	if err := b.openPortal(p); err != nil {
+		return toError(err)
+	}
+	return b.sendPortalRows(ctx, p, int64(msg.MaxRows))
+}
+
+func (b *DataQueryBackend) handleClose(msg *pgproto3.Close) error {
+	switch msg.ObjectType {
+	case 'S':
+		// closing a statement which does not exist is not an error
+		delete(b.statements, msg.Name)
+	case 'P':
+		b.closePortal(msg.Name)
+	default:
+		return pgerror.NewError(pgerror.CodeProtocolViolation, "invalid CLOSE message subtype %d", msg.ObjectType)
+	}
+	return b.send(&pgproto3.CloseComplete{})
+}
+
+// countParams returns the highest $n placeholder referenced in the query,
+// ignoring anything inside quoted strings, identifiers or comments.
+func countParams(query string) int {
+	max := 0
+	for i := 0; i < len(query); i++ {
+		if end := skipLiteral(query, i); end != i {
+			i = end
+			continue
+		}
+		if query[i] != '$' {
+			continue
+		}
+		n := 0
+		j := i + 1
+		for ; j < len(query) && query[j] >= '0' && query[j] <= '9'; j++ {
+			n = n*10 + int(query[j]-'0')
+		}
+		if n > max {
+			max = n
+		}
+		i = j - 1
+	}
+	return max
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dialBackend runs a DataQueryBackend, adjusted by configure when not nil,
+// on one end of a loopback tcp connection and returns the other end.
+func dialBackend(t *testing.T, handler Handler, configure func(*DataQueryBackend)) net.Conn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		b := NewDataQueryBackend(conn, handler)
+		if configure != nil {
+			configure(b)
+		}
+		_ = b.Run()
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// startup sends a StartupMessage and waits for the backend to be ready
+func startup(t *testing.T, conn net.Conn, params map[string]string) *pgproto3.Frontend {
+	f := pgproto3.NewFrontend(pgproto3.NewChunkReader(conn), conn)
+	require.NoError(t, f.Send(&pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters:      params,
+	}))
+	for {
+		msg, err := f.Receive()
+		require.NoError(t, err)
+		switch msg := msg.(type) {
+		case *pgproto3.ReadyForQuery:
+			return f
+		case *pgproto3.ErrorResponse:
+			t.Fatalf("startup failed: %s", msg.Message)
+		}
+	}
+}
+
+// connectBackend returns a frontend connected to a DataQueryBackend which
+// has completed the startup.
+func connectBackend(t *testing.T, handler Handler) *pgproto3.Frontend {
+	conn := dialBackend(t, handler, nil)
+	return startup(t, conn, map[string]string{"user": "test"})
+}
+
+// echoHandler returns the query followed by its arguments as a single row
+type echoHandler struct{}
+
+func (echoHandler) Describe(ctx context.Context, query string) ([]pgtype.Column, error) {
+	return []pgtype.Column{TextColumn("query")}, nil
+}
+
+func (echoHandler) Execute(ctx context.Context, query string, args []interface{}) (*session.Result, error) {
+	row := append([]interface{}{query}, args...)
+	return session.NewResult([]pgtype.Column{TextColumn("query")}, [][]interface{}{row}), nil
+}
+
+func TestCountParams(t *testing.T) {
+	assert.Equal(t, 0, countParams("select 1"))
+	assert.Equal(t, 2, countParams("select $1, $2"))
+	assert.Equal(t, 3, countParams("select $3, $1"))
+	assert.Equal(t, 1, countParams("select '$2', \"$4\", $1 -- $5\n /* $6 */"))
+}
+
+func TestExtendedQuery(t *testing.T) {
+	f := connectBackend(t, echoHandler{})
+
+	require.NoError(t, f.Send(&pgproto3.Parse{Name: "s1", Query: "select $1"}))
+	require.NoError(t, f.Send(&pgproto3.Describe{ObjectType: 'S', Name: "s1"}))
+	require.NoError(t, f.Send(&pgproto3.Bind{PreparedStatement: "s1", Parameters: [][]byte{[]byte("x")}}))
+	require.NoError(t, f.Send(&pgproto3.Describe{ObjectType: 'P'}))
+	require.NoError(t, f.Send(&pgproto3.Execute{}))
+	require.NoError(t, f.Send(&pgproto3.Close{ObjectType: 'S', Name: "s1"}))
+	require.NoError(t, f.Send(&pgproto3.Sync{}))
+
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.ParseComplete{}, msg)
+
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.ParameterDescription{}, msg)
+	assert.Equal(t, []uint32{pgtype.TextOID}, msg.(*pgproto3.ParameterDescription).ParameterOIDs)
+
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.RowDescription{}, msg)
+
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.BindComplete{}, msg)
+
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.RowDescription{}, msg)
+
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.DataRow{}, msg)
+	assert.Equal(t, "select $1", string(msg.(*pgproto3.DataRow).Values[0]))
+	assert.Equal(t, "x", string(msg.(*pgproto3.DataRow).Values[1]))
+
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.CommandComplete{}, msg)
+
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.CloseComplete{}, msg)
+
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.ReadyForQuery{}, msg)
+}
+
+func TestPipelinedBatch(t *testing.T) {
+	f := connectBackend(t, echoHandler{})
+
+	// a batch sent in one go, the failing Bind skips the messages up to
+	// the first Sync
+	var buf []byte
+	for _, msg := range []pgproto3.FrontendMessage{
+		&pgproto3.Parse{Name: "s1", Query: "select $1"},
+		&pgproto3.Bind{PreparedStatement: "s1", Parameters: [][]byte{[]byte("a")}},
+		&pgproto3.Execute{},
+		&pgproto3.Bind{PreparedStatement: "missing"},
+		&pgproto3.Execute{},
+		&pgproto3.Sync{},
+		&pgproto3.Bind{PreparedStatement: "s1", Parameters: [][]byte{[]byte("b")}},
+		&pgproto3.Execute{},
+		&pgproto3.Sync{},
+	} {
+		buf = msg.Encode(buf)
+	}
+	require.NoError(t, f.Send(rawMessages(buf)))
+
+	msgs := receiveUntilReady(t, f)
+	require.Len(t, msgs, 5)
+	assert.IsType(t, &pgproto3.ParseComplete{}, msgs[0])
+	assert.IsType(t, &pgproto3.BindComplete{}, msgs[1])
+	assert.Equal(t, "a", string(msgs[2].(*pgproto3.DataRow).Values[1]))
+	assert.IsType(t, &pgproto3.CommandComplete{}, msgs[3])
+	assert.Equal(t, pgerror.CodeInvalidSQLStatementName, msgs[4].(*pgproto3.ErrorResponse).Code)
+
+	msgs = receiveUntilReady(t, f)
+	require.Len(t, msgs, 3)
+	assert.Equal(t, "b", string(msgs[1].(*pgproto3.DataRow).Values[1]))
+}
+
+func TestResponsesBufferedUntilFlush(t *testing.T) {
+	conn := dialBackend(t, echoHandler{}, nil)
+	f := startup(t, conn, map[string]string{"user": "test"})
+
+	require.NoError(t, f.Send(&pgproto3.Parse{Query: "select 1"}))
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, err := f.Receive()
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+
+	require.NoError(t, conn.SetReadDeadline(time.Time{}))
+	f = pgproto3.NewFrontend(pgproto3.NewChunkReader(conn), conn)
+	require.NoError(t, f.Send(&pgproto3.Flush{}))
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.ParseComplete{}, msg)
+}
+
+// rawMessages sends already encoded messages
+type rawMessages []byte
+
+func (rawMessages) Frontend()                  {}
+func (rawMessages) Decode(data []byte) error   { return nil }
+func (m rawMessages) Encode(dst []byte) []byte { return append(dst, m...) }
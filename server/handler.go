@@ -0,0 +1,103 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"context"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/cowsay"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/session"
+)
+
+// Handler executes the statements received by the server. A single handler
+// is shared by every connection so implementations must be safe for
+// concurrent use.
+type Handler interface {
+	// Describe returns the columns the query would produce without
+	// executing it. Statements which do not return rows return nil.
+	Describe(ctx context.Context, query string) ([]pgtype.Column, error)
+
+	// Execute runs the query with the arguments bound by the client. The
+	// arguments are decoded according to the parameter types of the
+	// statement, see pgtype.TypeRegistry.Decode, parameters of unspecified
+	// type are passed as string and NULL as nil.
+	Execute(ctx context.Context, query string, args []interface{}) (*session.Result, error)
+}
+
+// TextColumn returns the description of a text column with the given name
+func TextColumn(name string) pgtype.Column {
+	return pgtype.NewColumn(name, pgtype.TextOID)
+}
+
+// fieldDescriptions converts the columns into their wire representation,
+// applying the result formats requested in Bind.
+func fieldDescriptions(columns []pgtype.Column, formats []int16) []pgproto3.FieldDescription {
+	fields := make([]pgproto3.FieldDescription, len(columns))
+	for i, c := range columns {
+		fields[i] = pgproto3.FieldDescription{
+			Name:                 []byte(c.Name),
+			TableOID:             c.TableOID,
+			TableAttributeNumber: c.AttributeNumber,
+			DataTypeOID:          c.TypeOID,
+			DataTypeSize:         c.TypeSize,
+			TypeModifier:         c.TypeModifier,
+			Format:               formatCode(formats, i, c.Format),
+		}
+	}
+	return fields
+}
+
+// formatCode returns the format for column i. No codes means the default,
+// a single code applies to every column, otherwise there is one per column.
+func formatCode(formats []int16, i int, def int16) int16 {
+	switch len(formats) {
+	case 0:
+		return def
+	case 1:
+		return formats[0]
+	default:
+		if i < len(formats) {
+			return formats[i]
+		}
+		return def
+	}
+}
+
+// cowsayHandler is the default handler which answers every query with a
+// cow which did not understand it. Queries with syntax errors are rejected.
+type cowsayHandler struct{}
+
+var fortuneColumns = []pgtype.Column{TextColumn("fortune")}
+
+func (cowsayHandler) Describe(ctx context.Context, query string) ([]pgtype.Column, error) {
+	if _, err := parser.Parse(query); err != nil {
+		return nil, err
+	}
+	return fortuneColumns, nil
+}
+
+func (cowsayHandler) Execute(ctx context.Context, query string, args []interface{}) (*session.Result, error) {
+	if _, err := parser.Parse(query); err != nil {
+		return nil, err
+	}
+	say := cowsay.Say("Mooooo, I had a hard time understanding \n\"" + query + "\"")
+	return session.NewResult(fortuneColumns, [][]interface{}{{say}}), nil
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tableHandler returns a fixed two column table for every query
+type tableHandler struct{}
+
+var tableColumns = []pgtype.Column{
+	{Name: "id", TypeOID: 20, TypeSize: 8, TypeModifier: -1},
+	TextColumn("name"),
+}
+
+func (tableHandler) Describe(ctx context.Context, query string) ([]pgtype.Column, error) {
+	return tableColumns, nil
+}
+
+func (tableHandler) Execute(ctx context.Context, query string, args []interface{}) (*session.Result, error) {
+	return session.NewResult(tableColumns, [][]interface{}{
+		{int64(1), "one"},
+		{int64(2), nil},
+	}), nil
+}
+
+func TestFormatCode(t *testing.T) {
+	assert.Equal(t, int16(0), formatCode(nil, 3, 0))
+	assert.Equal(t, int16(1), formatCode([]int16{1}, 3, 0))
+	assert.Equal(t, int16(1), formatCode([]int16{0, 1}, 1, 0))
+}
+
+func TestHandler_SimpleQuery(t *testing.T) {
+	f := connectBackend(t, tableHandler{})
+	require.NoError(t, f.Send(&pgproto3.Query{String: "select * from t"}))
+
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.RowDescription{}, msg)
+	fields := msg.(*pgproto3.RowDescription).Fields
+	require.Len(t, fields, 2)
+	assert.Equal(t, "id", string(fields[0].Name))
+	assert.Equal(t, uint32(20), fields[0].DataTypeOID)
+
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("1"), []byte("one")}, msg.(*pgproto3.DataRow).Values)
+
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("2"), nil}, msg.(*pgproto3.DataRow).Values)
+
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT 2", string(msg.(*pgproto3.CommandComplete).CommandTag))
+
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.ReadyForQuery{}, msg)
+}
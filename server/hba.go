@@ -0,0 +1,254 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// Connection types of an access rule
+const (
+	ConnLocal     = "local"
+	ConnHost      = "host"
+	ConnHostSSL   = "hostssl"
+	ConnHostNoSSL = "hostnossl"
+)
+
+// HBARule is a single line of the access rules file
+type HBARule struct {
+	// Line is the line number in the file the rule was read from
+	Line int
+	// Type is one of local, host, hostssl or hostnossl
+	Type string
+	// Databases lists the database names, "all" or "sameuser"
+	Databases []string
+	// Users lists the user names or "all"
+	Users []string
+	// Network the client address must be in, nil matches any address
+	Network *net.IPNet
+	// Method is used to authenticate clients matching the rule
+	Method AuthMethod
+	// Options are the name=value pairs following the method
+	Options map[string]string
+}
+
+// HBA is a list of host based access rules in the style of pg_hba.conf. The
+// first rule matching a connection decides how it is authenticated, a
+// connection matching no rule is rejected.
+// https://www.postgresql.org/docs/14/auth-pg-hba-conf.html
+type HBA struct {
+	Rules []HBARule
+}
+
+// ParseHBA reads access rules, one per line, in the format
+//
+//	local      DATABASE USER METHOD [OPTIONS]
+//	host       DATABASE USER ADDRESS METHOD [OPTIONS]
+//	hostssl    DATABASE USER ADDRESS METHOD [OPTIONS]
+//	hostnossl  DATABASE USER ADDRESS METHOD [OPTIONS]
+//
+// ADDRESS is a CIDR, a single IP address or "all". DATABASE and USER are
+// comma separated lists. Everything after a # is a comment.
+func ParseHBA(r io.Reader) (*HBA, error) {
+	hba := &HBA{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		rule, err := parseHBARule(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rule.Line = line
+		hba.Rules = append(hba.Rules, *rule)
+	}
+	return hba, scanner.Err()
+}
+
+// LoadHBAFile reads the access rules from a file, see ParseHBA for the format
+func LoadHBAFile(path string) (*HBA, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hba, err := ParseHBA(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return hba, nil
+}
+
+func parseHBARule(fields []string) (*HBARule, error) {
+	rule := &HBARule{Type: fields[0], Options: map[string]string{}}
+	switch rule.Type {
+	case ConnLocal:
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("expected: local DATABASE USER METHOD")
+		}
+	case ConnHost, ConnHostSSL, ConnHostNoSSL:
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("expected: %s DATABASE USER ADDRESS METHOD", rule.Type)
+		}
+	default:
+		return nil, fmt.Errorf("invalid connection type %q", rule.Type)
+	}
+	rule.Databases = splitList(fields[1])
+	rule.Users = splitList(fields[2])
+	rest := fields[3:]
+
+	if rule.Type != ConnLocal {
+		network, err := parseAddress(rest[0])
+		if err != nil {
+			return nil, err
+		}
+		rule.Network = network
+		rest = rest[1:]
+	}
+
+	rule.Method = AuthMethod(rest[0])
+	if !validHBAMethod(rule.Method) {
+		return nil, fmt.Errorf("invalid authentication method %q", rest[0])
+	}
+	if rule.Method == AuthCert && rule.Type != ConnHostSSL {
+		return nil, fmt.Errorf("cert authentication is only supported on hostssl connections")
+	}
+	for _, opt := range rest[1:] {
+		name, value, ok := cut(opt, "=")
+		if !ok {
+			return nil, fmt.Errorf("authentication option not in name=value format: %s", opt)
+		}
+		rule.Options[name] = value
+	}
+	return rule, nil
+}
+
+// validHBAMethod reports whether the method may be used in an access rule
+func validHBAMethod(method AuthMethod) bool {
+	switch method {
+	case AuthTrust, AuthReject, AuthPassword, AuthMD5, AuthSCRAMSHA256, AuthCert:
+		return true
+	}
+	return false
+}
+
+func parseAddress(addr string) (*net.IPNet, error) {
+	if addr == "all" {
+		return nil, nil
+	}
+	if strings.Contains(addr, "/") {
+		_, network, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address %q: %w", addr, err)
+		}
+		return network, nil
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", addr)
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.Trim(item, `"`); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Match returns the first rule matching the connection. addr is the remote
+// address of the client, a unix socket address only matches local rules.
+func (h *HBA) Match(addr net.Addr, ssl bool, database, user string) (*HBARule, bool) {
+	var ip net.IP
+	local := false
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UnixAddr:
+		local = true
+	}
+
+	for i := range h.Rules {
+		rule := &h.Rules[i]
+		switch rule.Type {
+		case ConnLocal:
+			if !local {
+				continue
+			}
+		case ConnHost:
+			if local {
+				continue
+			}
+		case ConnHostSSL:
+			if local || !ssl {
+				continue
+			}
+		case ConnHostNoSSL:
+			if local || ssl {
+				continue
+			}
+		}
+		if rule.Network != nil && (ip == nil || !rule.Network.Contains(ip)) {
+			continue
+		}
+		if !matchDatabase(rule.Databases, database, user) || !matchName(rule.Users, user) {
+			continue
+		}
+		return rule, true
+	}
+	return nil, false
+}
+
+func matchDatabase(databases []string, database, user string) bool {
+	for _, d := range databases {
+		if d == "all" || d == database || (d == "sameuser" && database == user) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchName(names []string, name string) bool {
+	for _, n := range names {
+		if n == "all" || n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testHBA = `
+# TYPE  DATABASE  USER        ADDRESS       METHOD
+local   all       all                       trust
+hostssl all       admin       10.0.0.0/8    scram-sha-256
+host    sameuser  all         10.1.2.3      md5       # single host
+host    sales,hr  alice,bob   192.168.0.0/16 password
+host    all       all         all           reject
+`
+
+func TestParseHBA(t *testing.T) {
+	hba, err := ParseHBA(strings.NewReader(testHBA))
+	require.NoError(t, err)
+	require.Len(t, hba.Rules, 5)
+
+	assert.Equal(t, ConnLocal, hba.Rules[0].Type)
+	assert.Nil(t, hba.Rules[0].Network)
+	assert.Equal(t, "10.0.0.0/8", hba.Rules[1].Network.String())
+	assert.Equal(t, "10.1.2.3/32", hba.Rules[2].Network.String())
+	assert.Equal(t, 5, hba.Rules[2].Line)
+	assert.Equal(t, []string{"sales", "hr"}, hba.Rules[3].Databases)
+	assert.Equal(t, AuthPassword, hba.Rules[3].Method)
+
+	for _, bad := range []string{
+		"hostx all all all trust",
+		"host all all trust",
+		"host all all 10.0.0.0/33 trust",
+		"host all all all ident",
+		"local all all trust clientcert",
+	} {
+		_, err := ParseHBA(strings.NewReader(bad))
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestHBA_Match(t *testing.T) {
+	hba, err := ParseHBA(strings.NewReader(testHBA))
+	require.NoError(t, err)
+	tcp := func(ip string) net.Addr { return &net.TCPAddr{IP: net.ParseIP(ip), Port: 5432} }
+
+	tests := []struct {
+		addr     net.Addr
+		ssl      bool
+		database string
+		user     string
+		line     int
+	}{
+		{&net.UnixAddr{Name: "/tmp/.s.PGSQL.5432"}, false, "db", "anyone", 3},
+		{tcp("10.9.9.9"), true, "db", "admin", 4},
+		{tcp("10.9.9.9"), false, "db", "admin", 7},
+		{tcp("10.1.2.3"), false, "carol", "carol", 5},
+		{tcp("10.1.2.3"), false, "other", "carol", 7},
+		{tcp("192.168.1.1"), false, "hr", "bob", 6},
+		{tcp("192.168.1.1"), false, "hr", "carol", 7},
+	}
+	for _, tt := range tests {
+		rule, ok := hba.Match(tt.addr, tt.ssl, tt.database, tt.user)
+		require.True(t, ok)
+		assert.Equal(t, tt.line, rule.Line, "%s %s@%s", tt.addr, tt.user, tt.database)
+	}
+
+	hba, err = ParseHBA(strings.NewReader("host all alice 127.0.0.1 trust"))
+	require.NoError(t, err)
+	_, ok := hba.Match(tcp("127.0.0.1"), false, "db", "bob")
+	assert.False(t, ok)
+}
+
+func TestHBA_Startup(t *testing.T) {
+	hba, err := ParseHBA(strings.NewReader(`
+host all alice 127.0.0.1/32 password
+host all bob   127.0.0.1/32 reject
+`))
+	require.NoError(t, err)
+	configure := func(b *DataQueryBackend) {
+		b.hba = hba
+		b.users = StaticUsers{"alice": "secret"}
+	}
+
+	conn := dialBackend(t, echoHandler{}, configure)
+	f := sendStartup(t, conn, "alice")
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.AuthenticationCleartextPassword{}, msg)
+	require.NoError(t, f.Send(&pgproto3.PasswordMessage{Password: "secret"}))
+	assert.Nil(t, expectAuthResult(t, f))
+
+	for _, user := range []string{"bob", "carol"} {
+		conn = dialBackend(t, echoHandler{}, configure)
+		errMsg := expectAuthResult(t, sendStartup(t, conn, user))
+		require.NotNil(t, errMsg)
+		assert.Equal(t, pgerror.CodeInvalidAuthorizationSpecification, errMsg.Code, user)
+	}
+}
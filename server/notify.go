@@ -0,0 +1,345 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/session"
+	"github.com/rs/zerolog/log"
+)
+
+// maxNotifyPayload is the longest payload accepted by NOTIFY, like postgres
+const maxNotifyPayload = 7999
+
+// voidOID is the type of the result of pg_notify
+const voidOID = 2278
+
+// notificationHub tracks the channels the sessions listen on and delivers
+// the notifications sent with NOTIFY to them.
+type notificationHub struct {
+	mu        sync.Mutex
+	listeners map[string]map[*DataQueryBackend]struct{}
+}
+
+func newNotificationHub() *notificationHub {
+	return &notificationHub{listeners: make(map[string]map[*DataQueryBackend]struct{})}
+}
+
+func (h *notificationHub) listen(b *DataQueryBackend, channel string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sessions, ok := h.listeners[channel]
+	if !ok {
+		sessions = make(map[*DataQueryBackend]struct{})
+		h.listeners[channel] = sessions
+	}
+	sessions[b] = struct{}{}
+}
+
+func (h *notificationHub) unlisten(b *DataQueryBackend, channel string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.listeners[channel], b)
+	if len(h.listeners[channel]) == 0 {
+		delete(h.listeners, channel)
+	}
+}
+
+func (h *notificationHub) unlistenAll(b *DataQueryBackend) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for channel, sessions := range h.listeners {
+		delete(sessions, b)
+		if len(sessions) == 0 {
+			delete(h.listeners, channel)
+		}
+	}
+}
+
+// notify queues the notification for every session listening on the
+// channel, it does not wait for the notification to be delivered.
+func (h *notificationHub) notify(n *pgproto3.NotificationResponse) {
+	h.mu.Lock()
+	sessions := make([]*DataQueryBackend, 0, len(h.listeners[n.Channel]))
+	for b := range h.listeners[n.Channel] {
+		sessions = append(sessions, b)
+	}
+	h.mu.Unlock()
+
+	for _, b := range sessions {
+		b.queueNotification(n)
+	}
+}
+
+// maxPendingNotifications bounds the notifications queued for a session,
+// a session falling further behind is disconnected so that the senders are
+// never held up by it
+const maxPendingNotifications = 10000
+
+// notifyWriteTimeout bounds how long the notifications of an idle session
+// may take to be written, a listener which stops reading is disconnected
+var notifyWriteTimeout = 10 * time.Second
+
+// queueNotification delivers the notification right away when the session
+// is idle, otherwise once it has sent its next ReadyForQuery. It never
+// waits for the client.
+func (b *DataQueryBackend) queueNotification(n *pgproto3.NotificationResponse) {
+	b.mu.Lock()
+	if b.terminating {
+		b.mu.Unlock()
+		return
+	}
+	if len(b.notifications) >= maxPendingNotifications {
+		b.notifications = nil
+		b.mu.Unlock()
+		log.Warn().Uint32("pid", b.pid).Msg("closing connection which does not keep up with notifications")
+		b.conn.Close()
+		return
+	}
+	b.notifications = append(b.notifications, n)
+	deliver := b.state == stateIdle && b.ready && !b.delivering
+	if deliver {
+		b.delivering = true
+	}
+	b.mu.Unlock()
+	if deliver {
+		// the client may be slow to read so the sender is not blocked
+		go func() {
+			if err := b.flushNotifications(); err != nil {
+				log.Debug().Err(err).Msg("could not deliver notifications")
+				b.conn.Close()
+			}
+		}()
+	}
+}
+
+// flushNotifications sends the queued notifications while the session is
+// idle, the caller must have set b.delivering which is cleared once the
+// queue is empty. It is a no-op when they are sent by the session itself.
+func (b *DataQueryBackend) flushNotifications() error {
+	for {
+		// writeMu is taken first so the session cannot start responding
+		// to its next message in the middle of the notifications
+		b.writeMu.Lock()
+		b.mu.Lock()
+		if b.state != stateIdle || !b.ready || b.terminating || len(b.notifications) == 0 {
+			b.delivering = false
+			b.mu.Unlock()
+			b.writeMu.Unlock()
+			return nil
+		}
+		pending := b.notifications
+		b.notifications = nil
+		b.mu.Unlock()
+		err := b.writeNotifications(pending)
+		b.writeMu.Unlock()
+		if err != nil {
+			b.mu.Lock()
+			b.delivering = false
+			b.mu.Unlock()
+			return err
+		}
+	}
+}
+
+// writeNotifications writes and flushes the notifications within
+// notifyWriteTimeout, b.writeMu must be held.
+func (b *DataQueryBackend) writeNotifications(pending []*pgproto3.NotificationResponse) error {
+	if err := b.conn.SetWriteDeadline(time.Now().Add(notifyWriteTimeout)); err != nil {
+		return err
+	}
+	defer b.conn.SetWriteDeadline(time.Time{})
+	buf := b.encodeBuf[:0]
+	for _, n := range pending {
+		buf = n.Encode(buf)
+	}
+	if cap(buf) <= writeBufferSize {
+		b.encodeBuf = buf
+	}
+	if _, err := b.writer.Write(buf); err != nil {
+		return err
+	}
+	return b.writer.Flush()
+}
+
+// notifyCommand is a LISTEN, UNLISTEN or NOTIFY statement or a call to
+// pg_notify which is executed by the server instead of the handler.
+type notifyCommand struct {
+	// tag is LISTEN, UNLISTEN, NOTIFY or SELECT for pg_notify
+	tag string
+	// channel is empty for UNLISTEN *
+	channel string
+	payload string
+	// channelParam and payloadParam are the numbers of the $n parameters
+	// passed to pg_notify instead of string literals, 0 for a literal
+	channelParam int
+	payloadParam int
+}
+
+// notifyCall returns the call of pg_notify when the query is nothing but
+// a call with string constants or $n parameters, like
+// SELECT pg_notify('channel', $1), and nil otherwise.
+func notifyCall(stmt *parser.SelectStmt) *parser.FuncCall {
+	if stmt.With != nil || stmt.Distinct || stmt.DistinctOn != nil || len(stmt.Targets) != 1 ||
+		stmt.From != nil || stmt.Where != nil || stmt.GroupBy != nil || stmt.Having != nil ||
+		stmt.Values != nil || stmt.Op != parser.SetOpNone || stmt.OrderBy != nil ||
+		stmt.Limit != nil || stmt.Offset != nil || stmt.Targets[0].Alias != "" {
+		return nil
+	}
+	call, ok := stmt.Targets[0].Expr.(*parser.FuncCall)
+	if !ok || call.Name != "pg_notify" || len(call.Args) != 2 || call.Star || call.Distinct ||
+		call.OrderBy != nil || call.Filter != nil || call.WithinGroup != nil {
+		return nil
+	}
+	for _, arg := range call.Args {
+		switch arg := arg.(type) {
+		case *parser.Literal:
+			if arg.Kind != parser.LiteralString {
+				return nil
+			}
+		case *parser.Param:
+		default:
+			return nil
+		}
+	}
+	return call
+}
+
+// newNotifyCommand returns the command of a LISTEN, UNLISTEN or NOTIFY
+// statement or of a query recognized by notifyCall. The parameters of
+// pg_notify are resolved with bind.
+func newNotifyCommand(stmt parser.Statement) (*notifyCommand, error) {
+	switch stmt := stmt.(type) {
+	case *parser.ListenStmt:
+		return &notifyCommand{tag: "LISTEN", channel: stmt.Channel}, nil
+	case *parser.UnlistenStmt:
+		return &notifyCommand{tag: "UNLISTEN", channel: stmt.Channel}, nil
+	case *parser.NotifyStmt:
+		cmd := &notifyCommand{tag: "NOTIFY", channel: stmt.Channel, payload: stmt.Payload}
+		return cmd, validateNotify(cmd)
+	}
+	call := notifyCall(stmt.(*parser.SelectStmt))
+	cmd := &notifyCommand{tag: "SELECT"}
+	cmd.channel, cmd.channelParam = notifyArgument(call.Args[0])
+	cmd.payload, cmd.payloadParam = notifyArgument(call.Args[1])
+	if cmd.channelParam != 0 || cmd.payloadParam != 0 {
+		return cmd, nil
+	}
+	return cmd, validateNotify(cmd)
+}
+
+// notifyArgument returns the string constant or the number of the $n
+// parameter passed to pg_notify
+func notifyArgument(arg parser.Expr) (value string, param int) {
+	if p, ok := arg.(*parser.Param); ok {
+		return "", p.Number
+	}
+	return arg.(*parser.Literal).Value, 0
+}
+
+// bind returns the command with the parameters of pg_notify replaced by
+// their values in args
+func (cmd *notifyCommand) bind(args []interface{}) (*notifyCommand, error) {
+	if cmd.channelParam == 0 && cmd.payloadParam == 0 {
+		return cmd, nil
+	}
+	bound := *cmd
+	var err error
+	if bound.channel, err = notifyParameter(cmd.channelParam, cmd.channel, args); err != nil {
+		return nil, err
+	}
+	if bound.payload, err = notifyParameter(cmd.payloadParam, cmd.payload, args); err != nil {
+		return nil, err
+	}
+	bound.channelParam, bound.payloadParam = 0, 0
+	return &bound, validateNotify(&bound)
+}
+
+// notifyParameter returns the value of the $n parameter, or the literal
+// when n is 0. A NULL is an empty string like in pg_notify.
+func notifyParameter(n int, literal string, args []interface{}) (string, error) {
+	if n == 0 {
+		return literal, nil
+	}
+	if n > len(args) {
+		return "", pgerror.NewError(pgerror.CodeUndefinedParameter, "there is no parameter $%d", n)
+	}
+	switch v := args[n-1].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		return "", pgerror.NewError(pgerror.CodeDatatypeMismatch, "argument $%d of pg_notify must be of type text", n)
+	}
+}
+
+func validateNotify(cmd *notifyCommand) error {
+	if cmd.channel == "" {
+		return pgerror.NewError(pgerror.CodeInvalidParameterValue, "channel name cannot be empty")
+	}
+	if len(cmd.payload) > maxNotifyPayload {
+		return pgerror.NewError(pgerror.CodeInvalidParameterValue, "payload string too long")
+	}
+	return nil
+}
+
+// notifyColumns describes the result of a notify command
+func notifyColumns(cmd *notifyCommand) []pgtype.Column {
+	if cmd.tag != "SELECT" {
+		return nil
+	}
+	return []pgtype.Column{{Name: "pg_notify", TypeOID: voidOID, TypeSize: 4, TypeModifier: -1}}
+}
+
+// execNotifyCommand runs a LISTEN, UNLISTEN, NOTIFY or pg_notify statement
+func (b *DataQueryBackend) execNotifyCommand(cmd *notifyCommand) (*session.Result, error) {
+	if b.hub == nil {
+		return nil, pgerror.NewError(pgerror.CodeFeatureNotSupported, "%s is not supported", cmd.tag)
+	}
+	switch cmd.tag {
+	case "LISTEN":
+		b.hub.listen(b, cmd.channel)
+	case "UNLISTEN":
+		if cmd.channel == "" {
+			b.hub.unlistenAll(b)
+		} else {
+			b.hub.unlisten(b, cmd.channel)
+		}
+	default:
+		b.hub.notify(&pgproto3.NotificationResponse{PID: b.pid, Channel: cmd.channel, Payload: cmd.payload})
+	}
+	if cmd.tag == "SELECT" {
+		res := session.NewResult(notifyColumns(cmd), [][]interface{}{{""}})
+		return res, nil
+	}
+	return &session.Result{CommandTag: cmd.tag}, nil
+}
+
+// Notify sends a notification to the sessions listening on the channel,
+// like pg_notify. It implements session.Session.
+func (b *DataQueryBackend) Notify(channel, payload string) error {
+	_, err := b.execNotifyCommand(&notifyCommand{tag: "NOTIFY", channel: channel, payload: payload})
+	return err
+}
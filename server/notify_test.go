@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewNotifyCommand(t *testing.T) {
+	tests := []struct {
+		query string
+		cmd   *notifyCommand
+	}{
+		{"LISTEN cache", &notifyCommand{tag: "LISTEN", channel: "cache"}},
+		{"listen \"Cache\";", &notifyCommand{tag: "LISTEN", channel: "Cache"}},
+		{"UNLISTEN Cache", &notifyCommand{tag: "UNLISTEN", channel: "cache"}},
+		{"UNLISTEN *", &notifyCommand{tag: "UNLISTEN"}},
+		{"NOTIFY cache", &notifyCommand{tag: "NOTIFY", channel: "cache"}},
+		{"NOTIFY cache, 'it''s stale'", &notifyCommand{tag: "NOTIFY", channel: "cache", payload: "it's stale"}},
+		{"SELECT pg_notify('Cache', 'x')", &notifyCommand{tag: "SELECT", channel: "Cache", payload: "x"}},
+		{"SELECT pg_notify($1, $2)", &notifyCommand{tag: "SELECT", channelParam: 1, payloadParam: 2}},
+		{"SELECT pg_notify('cache', $1)", &notifyCommand{tag: "SELECT", channel: "cache", payloadParam: 1}},
+	}
+	for _, tt := range tests {
+		stmt := parseCommand(tt.query)
+		require.NotNil(t, stmt, tt.query)
+		cmd, err := newNotifyCommand(stmt)
+		require.NoError(t, err, tt.query)
+		assert.Equal(t, tt.cmd, cmd, tt.query)
+	}
+
+	// other statements and statements which do not parse are left to the
+	// handler
+	for _, query := range []string{"SELECT 1", "SELECT pg_notify(a, 'x')", "SELECT pg_notify($0, 'x')", "LISTENER",
+		"select * from pg_notify('a', 'b') x", "SELECT pg_notify('a', 'b') AS n", "LISTEN", "NOTIFY a b", "NOTIFY a, b"} {
+		assert.Nil(t, parseCommand(query), query)
+	}
+
+	stmt := parseCommand("SELECT pg_notify('', 'x')")
+	require.NotNil(t, stmt)
+	_, err := newNotifyCommand(stmt)
+	assert.Error(t, err)
+}
+
+// receiveNotification waits for the next message and requires it to be a
+// notification.
+func receiveNotification(t *testing.T, f *pgproto3.Frontend) pgproto3.NotificationResponse {
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.NotificationResponse{}, msg)
+	return *msg.(*pgproto3.NotificationResponse)
+}
+
+func TestNotify(t *testing.T) {
+	s, err := New(WithHandler(echoHandler{}))
+	require.NoError(t, err)
+	addr, _ := serveTest(t, s)
+	defer s.Shutdown(context.Background())
+
+	listener, _ := startupKeyData(t, addr)
+	sender, senderKey := startupKeyData(t, addr)
+
+	assert.Equal(t, []pgproto3.BackendMessage{&pgproto3.CommandComplete{CommandTag: []byte("LISTEN")}},
+		simpleQuery(t, listener, "LISTEN cache"))
+
+	// the idle listener receives the notification right away
+	assert.Equal(t, []pgproto3.BackendMessage{&pgproto3.CommandComplete{CommandTag: []byte("NOTIFY")}},
+		simpleQuery(t, sender, "NOTIFY cache, 'users'"))
+	assert.Equal(t, pgproto3.NotificationResponse{PID: senderKey.ProcessID, Channel: "cache", Payload: "users"},
+		receiveNotification(t, listener))
+
+	msgs := simpleQuery(t, sender, "SELECT pg_notify('cache', 'groups')")
+	require.Len(t, msgs, 3)
+	assert.Equal(t, "pg_notify", string(msgs[0].(*pgproto3.RowDescription).Fields[0].Name))
+	assert.Equal(t, &pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}, msgs[2])
+	assert.Equal(t, "groups", receiveNotification(t, listener).Payload)
+
+	// a session notified by itself receives it after ReadyForQuery
+	simpleQuery(t, sender, "LISTEN cache")
+	simpleQuery(t, sender, "NOTIFY cache, 'self'")
+	assert.Equal(t, "self", receiveNotification(t, sender).Payload)
+	assert.Equal(t, "self", receiveNotification(t, listener).Payload)
+
+	// other channels and unlistened channels are not delivered
+	simpleQuery(t, listener, "UNLISTEN *")
+	simpleQuery(t, sender, "NOTIFY other")
+	simpleQuery(t, sender, "NOTIFY cache, 'last'")
+	assert.Equal(t, "last", receiveNotification(t, sender).Payload)
+	msgs = simpleQuery(t, listener, "SELECT 1")
+	assert.IsType(t, &pgproto3.RowDescription{}, msgs[0])
+}
+
+func TestNotifyExtendedQuery(t *testing.T) {
+	s, err := New(WithHandler(echoHandler{}))
+	require.NoError(t, err)
+	addr, _ := serveTest(t, s)
+	defer s.Shutdown(context.Background())
+
+	listener := dialServer(t, addr)
+	sender, senderKey := startupKeyData(t, addr)
+	simpleQuery(t, listener, "LISTEN cache")
+
+	// drivers send the arguments of pg_notify as parameters
+	require.NoError(t, sender.Send(&pgproto3.Parse{Query: "SELECT pg_notify($1, $2)"}))
+	require.NoError(t, sender.Send(&pgproto3.Bind{Parameters: [][]byte{[]byte("cache"), []byte("users")}}))
+	require.NoError(t, sender.Send(&pgproto3.Describe{ObjectType: 'P'}))
+	require.NoError(t, sender.Send(&pgproto3.Execute{}))
+	require.NoError(t, sender.Send(&pgproto3.Bind{Parameters: [][]byte{nil, []byte("x")}}))
+	require.NoError(t, sender.Send(&pgproto3.Execute{}))
+	require.NoError(t, sender.Send(&pgproto3.Sync{}))
+
+	var msgs []pgproto3.BackendMessage
+	for {
+		msg, err := sender.Receive()
+		require.NoError(t, err)
+		if _, ok := msg.(*pgproto3.ReadyForQuery); ok {
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+	require.Len(t, msgs, 7)
+	assert.IsType(t, &pgproto3.ParseComplete{}, msgs[0])
+	assert.IsType(t, &pgproto3.BindComplete{}, msgs[1])
+	assert.Equal(t, "pg_notify", string(msgs[2].(*pgproto3.RowDescription).Fields[0].Name))
+	assert.IsType(t, &pgproto3.DataRow{}, msgs[3])
+	assert.Equal(t, &pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}, msgs[4])
+	assert.IsType(t, &pgproto3.BindComplete{}, msgs[5])
+	require.IsType(t, &pgproto3.ErrorResponse{}, msgs[6])
+	assert.Equal(t, "channel name cannot be empty", msgs[6].(*pgproto3.ErrorResponse).Message)
+
+	assert.Equal(t, pgproto3.NotificationResponse{PID: senderKey.ProcessID, Channel: "cache", Payload: "users"},
+		receiveNotification(t, listener))
+
+	msgs = simpleQuery(t, sender, "SELECT pg_notify($1, 'x')")
+	require.Len(t, msgs, 1)
+	assert.Equal(t, pgerror.CodeUndefinedParameter, msgs[0].(*pgproto3.ErrorResponse).Code)
+}
+
+func TestNotifyBusySession(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	s, err := New(WithHandler(funcHandler(func(ctx context.Context, query string, args []interface{}) (*session.Result, error) {
+		if query == "slow" {
+			close(started)
+			<-release
+		}
+		return &session.Result{CommandTag: "OK"}, nil
+	})))
+	require.NoError(t, err)
+	addr, _ := serveTest(t, s)
+	defer s.Shutdown(context.Background())
+
+	listener := dialServer(t, addr)
+	sender := dialServer(t, addr)
+	simpleQuery(t, listener, "LISTEN jobs")
+
+	require.NoError(t, listener.Send(&pgproto3.Query{String: "slow"}))
+	<-started
+	simpleQuery(t, sender, "NOTIFY jobs, 'queued'")
+	close(release)
+
+	msg, err := listener.Receive()
+	require.NoError(t, err)
+	assert.Equal(t, &pgproto3.CommandComplete{CommandTag: []byte("OK")}, msg)
+	msg, err = listener.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.ReadyForQuery{}, msg)
+	assert.Equal(t, "queued", receiveNotification(t, listener).Payload)
+}
+
+func TestNotifyWithoutHub(t *testing.T) {
+	f := connectBackend(t, echoHandler{})
+	msgs := simpleQuery(t, f, "LISTEN cache")
+	require.Len(t, msgs, 1)
+	assert.Equal(t, pgerror.CodeFeatureNotSupported, msgs[0].(*pgproto3.ErrorResponse).Code)
+}
+
+func TestNotifySlowListener(t *testing.T) {
+	timeout := notifyWriteTimeout
+	notifyWriteTimeout = 100 * time.Millisecond
+	defer func() { notifyWriteTimeout = timeout }()
+
+	s, err := New(WithHandler(echoHandler{}))
+	require.NoError(t, err)
+	addr, _ := serveTest(t, s)
+	defer s.Shutdown(context.Background())
+
+	// the listener never reads so its socket buffers fill up
+	listener := dialServer(t, addr)
+	simpleQuery(t, listener, "LISTEN cache")
+	sender := dialServer(t, addr)
+
+	payload := strings.Repeat("x", maxNotifyPayload)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3000; i++ {
+			simpleQuery(t, sender, "NOTIFY cache, '"+payload+"'")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("NOTIFY blocked on a listener which does not read")
+	}
+
+	other := dialServer(t, addr)
+	msgs := simpleQuery(t, other, "SELECT 1")
+	assert.IsType(t, &pgproto3.RowDescription{}, msgs[0])
+
+	// the listener was disconnected after the notifications it got
+	for {
+		if _, err := listener.Receive(); err != nil {
+			break
+		}
+	}
+}
@@ -0,0 +1,282 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// isWordByte reports whether c may be part of a word, such as a keyword,
+// a name or a number
+func isWordByte(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || c == '+' || c == '$' || c == '/' || c == ':' ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || c >= 0x80
+}
+
+// skipLiteral returns the index of the last byte of the quoted string,
+// quoted identifier, dollar quoted string or comment starting at i, or i
+// when none starts there. An unterminated literal extends to the end.
+func skipLiteral(query string, i int) int {
+	c := query[i]
+	switch {
+	case c == '\'' || c == '"':
+		// escape string constants may escape the quote with a backslash
+		escapes := c == '\'' && i > 0 && (query[i-1] == 'E' || query[i-1] == 'e') &&
+			(i == 1 || !isWordByte(query[i-2]))
+		for j := i + 1; j < len(query); j++ {
+			switch {
+			case escapes && query[j] == '\\':
+				j++
+			case query[j] == c:
+				return j
+			}
+		}
+		return len(query) - 1
+	case c == '-' && i+1 < len(query) && query[i+1] == '-':
+		if end := strings.IndexByte(query[i:], '\n'); end >= 0 {
+			return i + end
+		}
+		return len(query) - 1
+	case c == '/' && i+1 < len(query) && query[i+1] == '*':
+		// block comments nest
+		depth := 0
+		for j := i; j+1 < len(query); j++ {
+			switch {
+			case query[j] == '/' && query[j+1] == '*':
+				depth++
+				j++
+			case query[j] == '*' && query[j+1] == '/':
+				depth--
+				j++
+				if depth == 0 {
+					return j
+				}
+			}
+		}
+		return len(query) - 1
+	case c == '$':
+		// $tag$ ... $tag$, $1 is a parameter
+		end := strings.IndexByte(query[i+1:], '$')
+		if end < 0 {
+			return i
+		}
+		tag := query[i : i+end+2]
+		for _, r := range tag[1 : len(tag)-1] {
+			if !(r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') || r >= 0x80) {
+				return i
+			}
+		}
+		if len(tag) > 2 && '0' <= tag[1] && tag[1] <= '9' {
+			return i
+		}
+		if i > 0 && isWordByte(query[i-1]) && query[i-1] != '$' {
+			// part of an identifier such as a$b
+			return i
+		}
+		closing := strings.Index(query[i+len(tag):], tag)
+		if closing < 0 {
+			return len(query) - 1
+		}
+		return i + len(tag) + closing + len(tag) - 1
+	}
+	return i
+}
+
+// statement is a statement of a simple query and the byte offset at which
+// it starts in the query
+type statement struct {
+	query  string
+	offset int
+}
+
+// newStatement trims the white space around query[start:end]
+func newStatement(query string, start, end int) statement {
+	s := query[start:end]
+	trimmed := strings.TrimLeftFunc(s, unicode.IsSpace)
+	return statement{
+		query:  strings.TrimRightFunc(trimmed, unicode.IsSpace),
+		offset: start + len(s) - len(trimmed),
+	}
+}
+
+// splitStatements splits a simple query into its statements on the
+// semicolons outside of literals and comments. Statements which are blank
+// or only hold comments are dropped.
+func splitStatements(query string) []string {
+	var statements []string
+	for _, stmt := range scanStatements(query) {
+		statements = append(statements, stmt.query)
+	}
+	return statements
+}
+
+// scanStatements splits the query like splitStatements and keeps the
+// position of the statements
+func scanStatements(query string) []statement {
+	var statements []statement
+	start := 0
+	blank := true
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == ';':
+			if !blank {
+				statements = append(statements, newStatement(query, start, i))
+			}
+			start, blank = i+1, true
+			continue
+		case c == '-' && i+1 < len(query) && query[i+1] == '-',
+			c == '/' && i+1 < len(query) && query[i+1] == '*':
+			i = skipLiteral(query, i)
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			continue
+		}
+		blank = false
+		i = skipLiteral(query, i)
+	}
+	if !blank {
+		statements = append(statements, newStatement(query, start, len(query)))
+	}
+	return statements
+}
+
+// isEmptyQuery reports whether the query holds no statement
+func isEmptyQuery(query string) bool {
+	return len(splitStatements(query)) == 0
+}
+
+// leadingKeywords returns the first n words of the statement in upper
+// case, skipping comments and opening parentheses.
+func leadingKeywords(query string, n int) []string {
+	var words []string
+	for i := 0; i < len(query) && len(words) < n; i++ {
+		c := query[i]
+		switch {
+		case c == '-' || c == '/':
+			if end := skipLiteral(query, i); end != i {
+				i = end
+				continue
+			}
+			return words
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '(':
+			continue
+		case ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c == '_':
+			start := i
+			for i < len(query) && (isWordByte(query[i]) && query[i] != '.') {
+				i++
+			}
+			words = append(words, strings.ToUpper(query[start:i]))
+			i--
+		default:
+			return words
+		}
+	}
+	return words
+}
+
+// commandTag returns the tag of the CommandComplete message for a
+// statement which returned or affected rows, like postgres does.
+func commandTag(query string, rows int64) string {
+	words := leadingKeywords(query, 4)
+	if len(words) == 0 {
+		return ""
+	}
+	if words[0] == "WITH" {
+		if main := leadingKeywords(skipWith(query), 1); len(main) > 0 {
+			words = main
+		}
+	}
+	switch words[0] {
+	case "SELECT", "VALUES", "TABLE", "WITH":
+		return fmt.Sprintf("SELECT %d", rows)
+	case "INSERT":
+		return fmt.Sprintf("INSERT 0 %d", rows)
+	case "UPDATE", "DELETE", "MERGE", "FETCH", "MOVE", "COPY":
+		return fmt.Sprintf("%s %d", words[0], rows)
+	case "START":
+		return "START TRANSACTION"
+	case "END":
+		return "COMMIT"
+	case "ABORT":
+		return "ROLLBACK"
+	case "CREATE", "DROP", "ALTER":
+		// the tag names the type of object, the modifiers are left out
+		for _, w := range words[1:] {
+			switch w {
+			case "OR", "REPLACE", "UNIQUE", "TEMP", "TEMPORARY", "UNLOGGED", "GLOBAL", "LOCAL":
+				continue
+			case "MATERIALIZED", "FOREIGN":
+				return strings.TrimSpace(words[0] + " " + w + " " + nextWord(words, w))
+			}
+			return words[0] + " " + w
+		}
+	}
+	return words[0]
+}
+
+// skipWith returns the statement following the common table expressions
+// of a WITH clause, or the query when it is not found.
+func skipWith(query string) string {
+	depth := 0
+	// closed is set after the parenthesis closing a query of the clause
+	closed := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			closed = depth == 0
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+		case depth == 0 && (('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c == '_'):
+			start := i
+			for i < len(query) && isWordByte(query[i]) {
+				i++
+			}
+			if closed {
+				switch strings.ToUpper(query[start:i]) {
+				case "SELECT", "VALUES", "TABLE", "INSERT", "UPDATE", "DELETE", "MERGE":
+					return query[start:]
+				}
+			}
+			closed = false
+			i--
+		default:
+			if end := skipLiteral(query, i); end != i {
+				i = end
+				continue
+			}
+			closed = false
+		}
+	}
+	return query
+}
+
+// nextWord returns the word following w
+func nextWord(words []string, w string) string {
+	for i := range words[:len(words)-1] {
+		if words[i] == w {
+			return words[i+1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := map[string][]string{
+		"":                                 {},
+		" ; ;\n":                           {},
+		"-- only a comment":                {},
+		"/* a; /* nested; */ b; */":        {},
+		"SELECT 1":                         {"SELECT 1"},
+		"BEGIN; SELECT 1; COMMIT;":         {"BEGIN", "SELECT 1", "COMMIT"},
+		"SELECT ';' ; SELECT \"a;b\"":      {"SELECT ';'", `SELECT "a;b"`},
+		"SELECT 'it''s;'; SELECT 2":        {"SELECT 'it''s;'", "SELECT 2"},
+		`SELECT E'\';'; SELECT 2`:          {`SELECT E'\';'`, "SELECT 2"},
+		"SELECT $$a;b$$; SELECT $f$;$f$":   {"SELECT $$a;b$$", "SELECT $f$;$f$"},
+		"SELECT $1; SELECT $2":             {"SELECT $1", "SELECT $2"},
+		"SELECT 1 -- one; two\n; SELECT 2": {"SELECT 1 -- one; two", "SELECT 2"},
+		"-- comment\nSELECT 1":             {"-- comment\nSELECT 1"},
+	}
+	for query, expected := range tests {
+		statements := splitStatements(query)
+		if len(expected) == 0 {
+			assert.Empty(t, statements, query)
+			continue
+		}
+		assert.Equal(t, expected, statements, query)
+	}
+}
+
+func TestScanStatementOffsets(t *testing.T) {
+	query := "SELECT 1;\n  SELECT 2 ; ;SELECT 3"
+	statements := scanStatements(query)
+	require.Len(t, statements, 3)
+	for _, stmt := range statements {
+		assert.Equal(t, stmt.query, query[stmt.offset:stmt.offset+len(stmt.query)])
+	}
+	assert.Equal(t, []int{0, 12, 24}, []int{statements[0].offset, statements[1].offset, statements[2].offset})
+}
+
+func TestSyntaxErrorPosition(t *testing.T) {
+	f := connectBackend(t, cowsayHandler{})
+
+	msgs := simpleQuery(t, f, "SELECT 1; SELECT * FORM t")
+	require.Len(t, msgs, 4)
+	assert.IsType(t, &pgproto3.DataRow{}, msgs[1])
+	pgErr := msgs[3].(*pgproto3.ErrorResponse)
+	assert.Equal(t, pgerror.CodeSyntaxError, pgErr.Code)
+	assert.Equal(t, `syntax error at or near "FORM"`, pgErr.Message)
+	assert.Equal(t, int32(20), pgErr.Position)
+
+	require.NoError(t, f.Send(&pgproto3.Parse{Query: "SELECT 'é' +"}))
+	require.NoError(t, f.Send(&pgproto3.Describe{ObjectType: 'S'}))
+	require.NoError(t, f.Send(&pgproto3.Sync{}))
+	msgs = receiveUntilReady(t, f)
+	require.NotEmpty(t, msgs)
+	pgErr = msgs[len(msgs)-1].(*pgproto3.ErrorResponse)
+	assert.Equal(t, "syntax error at end of input", pgErr.Message)
+	assert.Equal(t, int32(13), pgErr.Position)
+}
+
+func TestCommandTag(t *testing.T) {
+	tests := []struct {
+		query string
+		rows  int64
+		tag   string
+	}{
+		{"SELECT 1", 1, "SELECT 1"},
+		{"(select 1) union (select 2)", 2, "SELECT 2"},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", 1, "SELECT 1"},
+		{"WITH x AS (SELECT 1) INSERT INTO t SELECT * FROM x", 1, "INSERT 0 1"},
+		{"with recursive x (n) as (select 1 union select n + 1 from x), y as not materialized (select ')') update t set a = 1", 2, "UPDATE 2"},
+		{"WITH x AS (DELETE FROM t RETURNING *) /* c */ DELETE FROM u", 3, "DELETE 3"},
+		{"WITH x AS (DELETE FROM t RETURNING *) SELECT * FROM x", 4, "SELECT 4"},
+		{"WITH x AS (SELECT 1) (SELECT * FROM x)", 1, "SELECT 1"},
+		{"VALUES (1), (2)", 2, "SELECT 2"},
+		{"insert into t values (1)", 3, "INSERT 0 3"},
+		{"UPDATE t SET a = 1", 4, "UPDATE 4"},
+		{"/* c */ DELETE FROM t", 0, "DELETE 0"},
+		{"BEGIN", 0, "BEGIN"},
+		{"start transaction", 0, "START TRANSACTION"},
+		{"END", 0, "COMMIT"},
+		{"ABORT", 0, "ROLLBACK"},
+		{"CREATE TABLE t (a int)", 0, "CREATE TABLE"},
+		{"CREATE UNIQUE INDEX i ON t (a)", 0, "CREATE INDEX"},
+		{"CREATE OR REPLACE VIEW v AS SELECT 1", 0, "CREATE VIEW"},
+		{"CREATE TEMP TABLE t (a int)", 0, "CREATE TABLE"},
+		{"DROP MATERIALIZED VIEW v", 0, "DROP MATERIALIZED VIEW"},
+		{"TRUNCATE t", 0, "TRUNCATE"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.tag, commandTag(tt.query, tt.rows), tt.query)
+	}
+}
+
+func TestMultiStatementQuery(t *testing.T) {
+	var executed []string
+	f := connectBackend(t, funcHandler(func(ctx context.Context, query string, args []interface{}) (*session.Result, error) {
+		executed = append(executed, query)
+		switch query {
+		case "SELECT 1":
+			return session.NewResult([]pgtype.Column{TextColumn("?column?")}, [][]interface{}{{"1"}}), nil
+		case "INSERT INTO t VALUES (1), (2)":
+			return &session.Result{RowsAffected: 2}, nil
+		case "fail":
+			return nil, pgerror.NewError(pgerror.CodeSyntaxError, "syntax error at or near \"fail\"")
+		}
+		return &session.Result{}, nil
+	}))
+
+	msgs := simpleQuery(t, f, "BEGIN; SELECT 1; INSERT INTO t VALUES (1), (2); COMMIT;")
+	assert.Equal(t, []string{"BEGIN", "SELECT 1", "INSERT INTO t VALUES (1), (2)", "COMMIT"}, executed)
+	require.Len(t, msgs, 6)
+	assert.Equal(t, &pgproto3.CommandComplete{CommandTag: []byte("BEGIN")}, msgs[0])
+	assert.IsType(t, &pgproto3.RowDescription{}, msgs[1])
+	assert.Equal(t, &pgproto3.DataRow{Values: [][]byte{[]byte("1")}}, msgs[2])
+	assert.Equal(t, &pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}, msgs[3])
+	assert.Equal(t, &pgproto3.CommandComplete{CommandTag: []byte("INSERT 0 2")}, msgs[4])
+	assert.Equal(t, &pgproto3.CommandComplete{CommandTag: []byte("COMMIT")}, msgs[5])
+
+	// an error skips the remaining statements
+	executed = nil
+	msgs = simpleQuery(t, f, "BEGIN; fail; COMMIT")
+	assert.Equal(t, []string{"BEGIN", "fail"}, executed)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, pgerror.CodeSyntaxError, msgs[1].(*pgproto3.ErrorResponse).Code)
+
+	executed = nil
+	for _, query := range []string{"", "  ;  ", "-- nothing"} {
+		msgs = simpleQuery(t, f, query)
+		assert.Equal(t, []pgproto3.BackendMessage{&pgproto3.EmptyQueryResponse{}}, msgs, query)
+	}
+	assert.Empty(t, executed)
+}
+
+func TestExtendedEmptyAndMultiStatement(t *testing.T) {
+	f := connectBackend(t, echoHandler{})
+
+	require.NoError(t, f.Send(&pgproto3.Parse{Query: ""}))
+	require.NoError(t, f.Send(&pgproto3.Bind{}))
+	require.NoError(t, f.Send(&pgproto3.Describe{ObjectType: 'P'}))
+	require.NoError(t, f.Send(&pgproto3.Execute{}))
+	require.NoError(t, f.Send(&pgproto3.Parse{Name: "multi", Query: "SELECT 1; SELECT 2"}))
+	require.NoError(t, f.Send(&pgproto3.Sync{}))
+
+	expected := []pgproto3.BackendMessage{
+		&pgproto3.ParseComplete{},
+		&pgproto3.BindComplete{},
+		&pgproto3.NoData{},
+		&pgproto3.EmptyQueryResponse{},
+	}
+	for _, e := range expected {
+		msg, err := f.Receive()
+		require.NoError(t, err)
+		assert.Equal(t, e, msg)
+	}
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.ErrorResponse{}, msg)
+	assert.Equal(t, "cannot insert multiple commands into a prepared statement", msg.(*pgproto3.ErrorResponse).Message)
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.ReadyForQuery{}, msg)
+}
@@ -0,0 +1,327 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/pgerror"
+)
+
+// SCRAM-SHA-256 as described in RFC 5802 and RFC 7677
+// https://www.postgresql.org/docs/14/sasl-authentication.html
+
+const (
+	scramMechanism      = "SCRAM-SHA-256"
+	scramPlusMechanism  = "SCRAM-SHA-256-PLUS"
+	scramPrefix         = "SCRAM-SHA-256$"
+	scramIterations     = 4096
+	scramSaltLen        = 16
+	scramNonceLen       = 18
+	channelBindingType  = "tls-server-end-point"
+	channelBindingGS2   = "p=" + channelBindingType
+	channelBindingNoneN = "n"
+	channelBindingNoneY = "y"
+)
+
+// scramSecret is a parsed SCRAM-SHA-256 verifier
+type scramSecret struct {
+	iterations int
+	salt       []byte
+	storedKey  []byte
+	serverKey  []byte
+}
+
+// SCRAMSecret returns the SCRAM-SHA-256 verifier for the password in the
+// format stored by postgres: SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>
+func SCRAMSecret(password string) (string, error) {
+	salt := make([]byte, scramSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	stored, server := scramKeys(password, salt, scramIterations)
+	b64 := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("%s%d:%s$%s:%s", scramPrefix, scramIterations, b64(salt), b64(stored), b64(server)), nil
+}
+
+func parseSCRAMSecret(secret string) (*scramSecret, error) {
+	invalid := fmt.Errorf("invalid SCRAM-SHA-256 secret")
+	rest := strings.TrimPrefix(secret, scramPrefix)
+	params, keys, ok := cut(rest, "$")
+	if !ok {
+		return nil, invalid
+	}
+	iter, salt, ok := cut(params, ":")
+	if !ok {
+		return nil, invalid
+	}
+	stored, server, ok := cut(keys, ":")
+	if !ok {
+		return nil, invalid
+	}
+
+	var s scramSecret
+	var err error
+	if s.iterations, err = strconv.Atoi(iter); err != nil || s.iterations < 1 {
+		return nil, invalid
+	}
+	if s.salt, err = base64.StdEncoding.DecodeString(salt); err != nil {
+		return nil, invalid
+	}
+	if s.storedKey, err = base64.StdEncoding.DecodeString(stored); err != nil {
+		return nil, invalid
+	}
+	if s.serverKey, err = base64.StdEncoding.DecodeString(server); err != nil {
+		return nil, invalid
+	}
+	return &s, nil
+}
+
+// scramKeys derives the StoredKey and ServerKey from the password
+func scramKeys(password string, salt []byte, iterations int) (storedKey, serverKey []byte) {
+	salted := pbkdf2SHA256([]byte(password), salt, iterations)
+	clientKey := hmacSHA256(salted, []byte("Client Key"))
+	stored := sha256.Sum256(clientKey)
+	return stored[:], hmacSHA256(salted, []byte("Server Key"))
+}
+
+// pbkdf2SHA256 is the Hi() function of RFC 5802, PBKDF2 with HMAC-SHA-256
+// producing a single block of output.
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	result := make([]byte, len(u))
+	copy(result, u)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// authSCRAM performs the SCRAM-SHA-256 exchange. SCRAM-SHA-256-PLUS with
+// tls-server-end-point channel binding is offered on TLS connections whose
+// certificate supports it.
+func authSCRAM(conn AuthConn, secret string) error {
+	s, verifiable := scramVerifier(secret)
+
+	mechanisms := []string{scramMechanism}
+	cbindData := conn.ServerEndPoint()
+	if cbindData != nil {
+		mechanisms = []string{scramPlusMechanism, scramMechanism}
+	}
+	if err := conn.Send(&pgproto3.AuthenticationSASL{AuthMechanisms: mechanisms}); err != nil {
+		return err
+	}
+
+	msg, err := conn.Receive(pgproto3.AuthTypeSASL)
+	if err != nil {
+		return err
+	}
+	initial, ok := msg.(*pgproto3.SASLInitialResponse)
+	if !ok {
+		return pgerror.NewError(pgerror.CodeProtocolViolation, "expected SASL response, got %T", msg)
+	}
+
+	gs2Header, clientFirstBare, err := parseClientFirst(string(initial.Data))
+	if err != nil {
+		return err
+	}
+	switch initial.AuthMechanism {
+	case scramPlusMechanism:
+		if cbindData == nil {
+			return pgerror.NewError(pgerror.CodeProtocolViolation, "channel binding is not supported on this connection")
+		}
+		if !strings.HasPrefix(gs2Header, channelBindingGS2+",") {
+			return pgerror.NewError(pgerror.CodeProtocolViolation, "SCRAM channel binding negotiation error")
+		}
+	case scramMechanism:
+		switch {
+		case strings.HasPrefix(gs2Header, channelBindingNoneY+","):
+			// the client supports channel binding but thinks the server
+			// does not, on a TLS connection this indicates a downgrade
+			if cbindData != nil {
+				return pgerror.NewError(pgerror.CodeProtocolViolation, "SCRAM channel binding negotiation error")
+			}
+		case strings.HasPrefix(gs2Header, channelBindingNoneN+","):
+		default:
+			return pgerror.NewError(pgerror.CodeProtocolViolation, "SCRAM channel binding negotiation error")
+		}
+		cbindData = nil
+	default:
+		return pgerror.NewError(pgerror.CodeProtocolViolation, "client selected an invalid SASL authentication mechanism")
+	}
+
+	clientNonce := scramAttribute(clientFirstBare, 'r')
+	if clientNonce == "" {
+		return pgerror.NewError(pgerror.CodeProtocolViolation, "malformed SCRAM message: missing nonce")
+	}
+	serverNonce := make([]byte, scramNonceLen)
+	if _, err := rand.Read(serverNonce); err != nil {
+		return err
+	}
+	nonce := clientNonce + base64.StdEncoding.EncodeToString(serverNonce)
+	serverFirst := fmt.Sprintf("r=%s,s=%s,i=%d", nonce, base64.StdEncoding.EncodeToString(s.salt), s.iterations)
+	if err := conn.Send(&pgproto3.AuthenticationSASLContinue{Data: []byte(serverFirst)}); err != nil {
+		return err
+	}
+
+	msg, err = conn.Receive(pgproto3.AuthTypeSASLContinue)
+	if err != nil {
+		return err
+	}
+	resp, ok := msg.(*pgproto3.SASLResponse)
+	if !ok {
+		return pgerror.NewError(pgerror.CodeProtocolViolation, "expected SASL response, got %T", msg)
+	}
+	clientFinal := string(resp.Data)
+	i := strings.LastIndex(clientFinal, ",p=")
+	if i < 0 {
+		return pgerror.NewError(pgerror.CodeProtocolViolation, "malformed SCRAM message: missing proof")
+	}
+	clientFinalWithoutProof := clientFinal[:i]
+
+	expectedBinding := base64.StdEncoding.EncodeToString(append([]byte(gs2Header), cbindData...))
+	if scramAttribute(clientFinalWithoutProof, 'c') != expectedBinding {
+		return pgerror.NewError(pgerror.CodeProtocolViolation, "SCRAM channel binding check failed")
+	}
+	if scramAttribute(clientFinalWithoutProof, 'r') != nonce {
+		return pgerror.NewError(pgerror.CodeProtocolViolation, "SCRAM nonce mismatch")
+	}
+	proof, err := base64.StdEncoding.DecodeString(clientFinal[i+3:])
+	if err != nil || len(proof) != sha256.Size {
+		return pgerror.NewError(pgerror.CodeProtocolViolation, "malformed SCRAM message: invalid proof")
+	}
+
+	authMessage := []byte(clientFirstBare + "," + serverFirst + "," + clientFinalWithoutProof)
+	clientSignature := hmacSHA256(s.storedKey, authMessage)
+	clientKey := make([]byte, len(proof))
+	for i := range proof {
+		clientKey[i] = proof[i] ^ clientSignature[i]
+	}
+	computed := sha256.Sum256(clientKey)
+	if !verifiable || subtle.ConstantTimeCompare(computed[:], s.storedKey) != 1 {
+		return errPasswordFailed
+	}
+
+	serverSignature := hmacSHA256(s.serverKey, authMessage)
+	final := "v=" + base64.StdEncoding.EncodeToString(serverSignature)
+	return conn.Send(&pgproto3.AuthenticationSASLFinal{Data: []byte(final)})
+}
+
+// scramVerifier returns the keys to verify the client against. Secrets
+// which cannot be used with SCRAM, such as md5 hashes or unknown users, get
+// random keys so that the exchange completes and then fails.
+func scramVerifier(secret string) (*scramSecret, bool) {
+	if strings.HasPrefix(secret, scramPrefix) {
+		if s, err := parseSCRAMSecret(secret); err == nil {
+			return s, true
+		}
+	} else if secret != "" && !strings.HasPrefix(secret, md5Prefix) {
+		salt := make([]byte, scramSaltLen)
+		if _, err := rand.Read(salt); err == nil {
+			stored, server := scramKeys(secret, salt, scramIterations)
+			return &scramSecret{iterations: scramIterations, salt: salt, storedKey: stored, serverKey: server}, true
+		}
+	}
+	s := &scramSecret{
+		iterations: scramIterations,
+		salt:       make([]byte, scramSaltLen),
+		storedKey:  make([]byte, sha256.Size),
+		serverKey:  make([]byte, sha256.Size),
+	}
+	_, _ = rand.Read(s.salt)
+	_, _ = rand.Read(s.storedKey)
+	_, _ = rand.Read(s.serverKey)
+	return s, false
+}
+
+// parseClientFirst splits the client-first-message into the gs2 header and
+// the client-first-message-bare
+func parseClientFirst(msg string) (gs2Header, bare string, err error) {
+	parts := strings.SplitN(msg, ",", 3)
+	if len(parts) != 3 {
+		return "", "", pgerror.NewError(pgerror.CodeProtocolViolation, "malformed SCRAM message")
+	}
+	if parts[1] != "" {
+		return "", "", pgerror.NewError(pgerror.CodeFeatureNotSupported, "client uses authorization identity, but it is not supported")
+	}
+	return parts[0] + "," + parts[1] + ",", parts[2], nil
+}
+
+// scramAttribute returns the value of the attribute with the given name
+// in a comma separated SCRAM message.
+func scramAttribute(msg string, name byte) string {
+	for _, attr := range strings.Split(msg, ",") {
+		if len(attr) >= 2 && attr[0] == name && attr[1] == '=' {
+			return attr[2:]
+		}
+	}
+	return ""
+}
+
+// serverEndPoint returns the tls-server-end-point channel binding data,
+// the hash of the server certificate as defined in RFC 5929. It is nil for
+// certificates whose signature does not use a hash, like ed25519, as the
+// binding is not defined for them.
+func serverEndPoint(raw []byte) []byte {
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return nil
+	}
+
+	// the certificate signature hash is used, md5 and sha-1 are replaced
+	// with sha-256
+	var h hash.Hash
+	switch cert.SignatureAlgorithm {
+	case x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1,
+		x509.SHA256WithRSA, x509.DSAWithSHA256, x509.ECDSAWithSHA256, x509.SHA256WithRSAPSS:
+		h = sha256.New()
+	case x509.SHA384WithRSA, x509.ECDSAWithSHA384, x509.SHA384WithRSAPSS:
+		h = sha512.New384()
+	case x509.SHA512WithRSA, x509.ECDSAWithSHA512, x509.SHA512WithRSAPSS:
+		h = sha512.New()
+	default:
+		return nil
+	}
+	h.Write(cert.Raw)
+	return h.Sum(nil)
+}
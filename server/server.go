@@ -18,14 +18,21 @@
 package server
 
 import (
+	"bufio"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/jackc/pgproto3/v2"
-	"github.com/patrickglass/dsql/cowsay"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/session"
 	"github.com/rs/zerolog/log"
 )
 
@@ -34,19 +41,57 @@ type Option func(*Server)
 type Server struct {
 	listener  net.Listener
 	tlsConfig *tls.Config
+	tlsMode   TLSMode
 	address   string
+	handler   Handler
+	auth      Authenticator
+	hba       *HBA
+	users     UserStore
+	identMaps *IdentMaps
+	types     *pgtype.TypeRegistry
 	quit      chan interface{}
-	wg        sync.WaitGroup
+	// authTimeout bounds the startup and authentication of each client
+	authTimeout time.Duration
+
+	// mu guards the listener and the sessions, closed is set once Shutdown
+	// is called after which no new connections are tracked
+	mu       sync.Mutex
+	closed   bool
+	sessions map[*DataQueryBackend]struct{}
+
+	// cancels routes CancelRequests to the session they are meant for
+	cancels *cancelRegistry
+	// hub delivers notifications between the sessions
+	hub *notificationHub
+
+	// client certificates are verified against clientCAs when set
+	clientCAs          *x509.CertPool
+	clientCertRequired bool
+	wg                 sync.WaitGroup
 }
 
 func New(opts ...Option) (*Server, error) {
 	s := Server{
-		address: ":5432",
-		quit:    make(chan interface{}),
+		address:     ":5432",
+		handler:     cowsayHandler{},
+		types:       pgtype.DefaultTypes,
+		authTimeout: DefaultAuthenticationTimeout,
+		quit:        make(chan interface{}),
+		sessions:    make(map[*DataQueryBackend]struct{}),
+		cancels:     newCancelRegistry(),
+		hub:         newNotificationHub(),
 	}
 	for _, opt := range opts {
 		opt(&s)
 	}
+	if s.tlsConfig != nil && s.clientCAs != nil {
+		s.tlsConfig = s.tlsConfig.Clone()
+		s.tlsConfig.ClientCAs = s.clientCAs
+		s.tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if s.clientCertRequired {
+			s.tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
 	return &s, nil
 }
 
@@ -64,29 +109,120 @@ func WithPort(port int) Option {
 	}
 }
 
-func WithTLSCert(s *Server, cert tls.Certificate) Option {
+// WithHandler sets the handler which executes the queries sent by clients
+func WithHandler(handler Handler) Option {
+	return func(s *Server) {
+		s.handler = handler
+	}
+}
+
+// WithAuthenticationTimeout sets the time a client has to complete the
+// startup and authentication before the connection is closed
+func WithAuthenticationTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.authTimeout = timeout
+	}
+}
+
+// WithAuthenticator sets how clients are authenticated, by default every
+// client is trusted
+func WithAuthenticator(auth Authenticator) Option {
+	return func(s *Server) {
+		s.auth = auth
+	}
+}
+
+// WithHBA sets the access rules deciding which clients may connect and how
+// they authenticate, it takes precedence over WithAuthenticator. The
+// password based methods look up secrets in the store set with WithUsers.
+func WithHBA(hba *HBA) Option {
+	return func(s *Server) {
+		s.hba = hba
+	}
+}
+
+// WithUsers sets the user store used by the access rules
+func WithUsers(users UserStore) Option {
+	return func(s *Server) {
+		s.users = users
+	}
+}
+
+// WithIdentMaps sets the maps referenced by the map option of access rules
+// using the cert method
+func WithIdentMaps(maps *IdentMaps) Option {
+	return func(s *Server) {
+		s.identMaps = maps
+	}
+}
+
+// WithTypes sets the registry used to encode results and decode parameters,
+// it defaults to pgtype.DefaultTypes
+func WithTypes(types *pgtype.TypeRegistry) Option {
+	return func(s *Server) {
+		s.types = types
+	}
+}
+
+// WithClientCAs verifies client certificates against the pool during the
+// TLS handshake. When required is false clients without a certificate may
+// still connect and authenticate with another method.
+func WithClientCAs(pool *x509.CertPool, required bool) Option {
+	return func(s *Server) {
+		s.clientCAs = pool
+		s.clientCertRequired = required
+	}
+}
+
+// WithTLSCert enables TLS using the certificate
+func WithTLSCert(cert tls.Certificate) Option {
 	return func(s *Server) {
 		s.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
 	}
 }
 
-func (s *Server) Serve() error {
-	var err error
-	var ln net.Listener
+// WithTLSConfig sets the configuration used when a client upgrades its
+// connection to TLS
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = cfg
+	}
+}
 
-	if s.tlsConfig != nil {
-		ln, err = tls.Listen("tcp", s.address, s.tlsConfig)
-	} else {
-		ln, err = net.Listen("tcp", s.address)
+// WithTLSMode sets whether clients may, or must, upgrade to TLS
+func WithTLSMode(mode TLSMode) Option {
+	return func(s *Server) {
+		s.tlsMode = mode
 	}
+}
+
+// ErrServerClosed is returned by Serve after Shutdown has been called
+var ErrServerClosed = errors.New("server closed")
+
+func (s *Server) Serve() error {
+	// TLS is negotiated in-protocol after an SSLRequest, or detected from
+	// the ClientHello of direct TLS clients, so the listener always accepts
+	// plaintext connections
+	ln, err := net.Listen("tcp", s.address)
 	if err != nil {
 		return err
 	}
+	return s.serve(ln)
+}
+
+func (s *Server) serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
 	s.listener = ln
+	s.mu.Unlock()
 
 listenerLoop:
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := ln.Accept()
 		if err != nil {
 			select {
 			case <-s.quit:
@@ -97,55 +233,219 @@ listenerLoop:
 				continue
 			}
 		}
-		s.wg.Add(1)
+
+		b := s.newBackend(conn)
+		if !s.track(b) {
+			conn.Close()
+			break
+		}
 		go func() {
-			handleConnection(conn)
-			s.wg.Done()
+			defer s.untrack(b)
+			s.handleConnection(b)
 		}()
 	}
 	return nil
 }
 
+// shutdownWriteTimeout bounds writing the shutdown error to an idle session
+// when the context of Shutdown has no deadline
+var shutdownWriteTimeout = 5 * time.Second
+
+// Shutdown stops accepting connections and terminates the sessions. Idle
+// sessions are sent an admin_shutdown error and closed, sessions executing
+// a query are closed once the query completes. When ctx expires first the
+// remaining connections are closed forcefully and ctx.Err() is returned.
 func (s *Server) Shutdown(ctx context.Context) error {
-	close(s.quit)
-	s.listener.Close()
-	s.wg.Wait()
-	return nil
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.quit)
+		if s.listener != nil {
+			s.listener.Close()
+		}
+	}
+	sessions := make([]*DataQueryBackend, 0, len(s.sessions))
+	for b := range s.sessions {
+		sessions = append(sessions, b)
+	}
+	s.mu.Unlock()
+
+	// a client which does not read must not hold up the other sessions,
+	// the writes are bounded by the deadline of ctx
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(shutdownWriteTimeout)
+	}
+	for _, b := range sessions {
+		go b.terminate(deadline)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		sessions = sessions[:0]
+		for b := range s.sessions {
+			sessions = append(sessions, b)
+		}
+		s.mu.Unlock()
+		log.Warn().Int("sessions", len(sessions)).Msg("closing remaining connections")
+		for _, b := range sessions {
+			b.forceClose()
+		}
+		return ctx.Err()
+	}
 }
 
-func handleConnection(conn net.Conn) {
-	remoteAddr := conn.RemoteAddr().String()
-	log.Debug().Str("address", remoteAddr).Msg("accepted connection")
+func (s *Server) newBackend(conn net.Conn) *DataQueryBackend {
+	b := NewDataQueryBackend(conn, s.handler)
+	b.tlsConfig = s.tlsConfig
+	b.tlsMode = s.tlsMode
+	b.auth = s.auth
+	b.hba = s.hba
+	b.users = s.users
+	b.identMaps = s.identMaps
+	b.types = s.types
+	b.cancels = s.cancels
+	b.hub = s.hub
+	b.authTimeout = s.authTimeout
+	return b
+}
 
-	b := NewDataQueryBackend(conn, func(query *pgproto3.Query) ([]byte, error) {
+// track registers the session so Shutdown can wait for it, it returns false
+// when the server is shutting down.
+func (s *Server) track(b *DataQueryBackend) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.wg.Add(1)
+	s.sessions[b] = struct{}{}
+	return true
+}
 
-		say := cowsay.Say("Mooooo, I had a hard time understanding \n\"" + query.String + "\"")
+func (s *Server) untrack(b *DataQueryBackend) {
+	s.mu.Lock()
+	delete(s.sessions, b)
+	s.mu.Unlock()
+	s.wg.Done()
+}
 
-		return []byte(say), nil
-	})
+func (s *Server) handleConnection(b *DataQueryBackend) {
+	remoteAddr := b.conn.RemoteAddr().String()
+	log.Debug().Str("address", remoteAddr).Msg("accepted connection")
 
 	err := b.Run()
 	if err != nil {
-		log.Error().Err(err)
+		log.Error().Err(err).Str("address", remoteAddr).Msg("connection error")
 	}
 	log.Debug().Str("address", remoteAddr).Msgf("connection closed")
 }
 
 // DataQueryBackend
 type DataQueryBackend struct {
-	backend   *pgproto3.Backend
-	conn      net.Conn
-	responder func(*pgproto3.Query) ([]byte, error)
+	backend *pgproto3.Backend
+	conn    net.Conn
+	handler Handler
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	tlsConfig *tls.Config
+	tlsMode   TLSMode
+	auth      Authenticator
+	hba       *HBA
+	users     UserStore
+	identMaps *IdentMaps
+	types     *pgtype.TypeRegistry
+	cancels   *cancelRegistry
+	hub       *notificationHub
+	// authTimeout bounds the startup and authentication of the client
+	authTimeout time.Duration
+
+	// pid and secretKey are sent in BackendKeyData and identify the session
+	// in a CancelRequest
+	pid       uint32
+	secretKey uint32
+
+	// user and database requested in the StartupMessage
+	user     string
+	database string
+
+	// settings are the current values of the session parameters keyed by
+	// their lower case name, sessionDefaults the values after startup
+	settings        map[string]string
+	sessionDefaults map[string]string
+
+	// prepared statements and portals live for the duration of the
+	// connection, the empty name refers to the unnamed statement/portal
+	statements map[string]*preparedStatement
+	portals    map[string]*portal
+
+	// ignoreTillSync is set after an error in the extended query protocol,
+	// all messages are discarded until the next Sync
+	ignoreTillSync bool
+
+	// mu guards state and terminating which are used by Shutdown to decide
+	// whether the session can be closed right away
+	mu          sync.Mutex
+	state       sessionState
+	terminating bool
+	// cancelFunc cancels the context of the running query
+	cancelFunc context.CancelFunc
+	// ready is set once ReadyForQuery was sent until the next message is
+	// received, notifications are only delivered to sessions which are ready
+	ready bool
+	// notifications are queued until the session is ready, delivering is
+	// set while they are being written
+	notifications []*pgproto3.NotificationResponse
+	delivering    bool
+
+	// writeMu guards writer which collects the outgoing messages until they
+	// are flushed, encodeBuf is reused to encode them
+	writeMu   sync.Mutex
+	writer    *bufio.Writer
+	encodeBuf []byte
 }
 
-func NewDataQueryBackend(conn net.Conn, responder func(*pgproto3.Query) ([]byte, error)) *DataQueryBackend {
+// writeBufferSize is the amount of outgoing data which is buffered before
+// it is written without a flush, like PQ_SEND_BUFFER_SIZE of postgres
+const writeBufferSize = 8192
+
+// sessionState tracks what the backend is doing for a graceful shutdown
+type sessionState int
+
+const (
+	// stateStartup is set until the client is authenticated
+	stateStartup sessionState = iota
+	// stateIdle is set while waiting for the next message from the client
+	stateIdle
+	// stateBusy is set while a message is being processed
+	stateBusy
+)
+
+func NewDataQueryBackend(conn net.Conn, handler Handler) *DataQueryBackend {
 	backend := pgproto3.NewBackend(pgproto3.NewChunkReader(conn), conn)
 
 	connHandler := &DataQueryBackend{
-		backend:   backend,
-		conn:      conn,
-		responder: responder,
+		backend:     backend,
+		conn:        conn,
+		handler:     handler,
+		writer:      bufio.NewWriterSize(conn, writeBufferSize),
+		types:       pgtype.DefaultTypes,
+		authTimeout: DefaultAuthenticationTimeout,
+		statements:  make(map[string]*preparedStatement),
+		portals:     make(map[string]*portal),
 	}
+	ctx := session.NewContext(context.Background(), connHandler)
+	connHandler.ctx, connHandler.cancel = context.WithCancel(ctx)
 
 	return connHandler
 }
@@ -154,86 +454,415 @@ func (b *DataQueryBackend) Run() error {
 	defer b.Close()
 
 	err := b.handleStartup()
+	if errors.Is(err, errCancelRequest) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
 	for {
-		msg, err := b.backend.Receive()
+		msg, err := b.receive()
+		if errors.Is(err, errTerminated) {
+			return nil
+		}
 		if err != nil {
 			return fmt.Errorf("error receiving message: %w", err)
 		}
 
-		switch msg := msg.(type) {
-		case *pgproto3.Query:
-			log.Info().Str("query", msg.String).Msg("sql query")
-
-			// Build response
-			response, err := b.responder(msg)
-			if err != nil {
-				log.Error().Err(err).Str("query", msg.String).Msg("response error")
-				return fmt.Errorf("error generating query response: %w", err)
+		if b.ignoreTillSync {
+			switch msg.(type) {
+			case *pgproto3.Sync, *pgproto3.Terminate:
+				b.ignoreTillSync = false
+			default:
+				continue
 			}
+		}
 
-			buf := (&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{
-				{
-					Name:                 []byte("fortune"),
-					TableOID:             0,
-					TableAttributeNumber: 0,
-					DataTypeOID:          25,
-					DataTypeSize:         -1,
-					TypeModifier:         -1,
-					Format:               0,
-				},
-			}}).Encode(nil)
-			buf = (&pgproto3.DataRow{Values: [][]byte{response}}).Encode(buf)
-			// Comand Tag should be the command which is executed for non selects
-			// Insert 0 1
-			// Update 1
-			// Delete 1
-			buf = (&pgproto3.CommandComplete{CommandTag: []byte("")}).Encode(buf)
-			buf = (&pgproto3.ReadyForQuery{TxStatus: 'I'}).Encode(buf)
-			_, err = b.conn.Write(buf)
-			if err != nil {
-				return fmt.Errorf("error writing query response: %w", err)
-			}
+		switch msg := msg.(type) {
+		case *pgproto3.Query:
+			err = b.handleQuery(msg)
+		case *pgproto3.Parse:
+			err = b.handleParse(msg)
+		case *pgproto3.Bind:
+			err = b.handleBind(msg)
+		case *pgproto3.Describe:
+			err = b.handleDescribe(msg)
+		case *pgproto3.Execute:
+			err = b.handleExecute(msg)
+		case *pgproto3.Close:
+			err = b.handleClose(msg)
+		case *pgproto3.Sync:
+			err = b.sendReadyForQuery()
+		case *pgproto3.Flush:
+			err = b.flush()
+		case *pgproto3.CopyData, *pgproto3.CopyDone, *pgproto3.CopyFail:
+			// the rest of a COPY FROM STDIN which failed is dropped
 		case *pgproto3.Terminate:
 			return nil
 		default:
-			return fmt.Errorf("received message other than Query from client: %#v", msg)
+			return b.fatal(pgerror.NewError(pgerror.CodeProtocolViolation, "unexpected message type %T", msg))
+		}
+
+		// errors reported to the client leave the session usable, any
+		// other error means the connection is broken
+		var pgErr *pgerror.Error
+		if errors.As(err, &pgErr) {
+			log.Debug().Err(err).Msg("sending error response")
+			b.ignoreTillSync = true
+			err = b.send(errorResponse(pgErr))
+		}
+		if err != nil {
+			return err
 		}
 	}
 }
 
-func (p *DataQueryBackend) handleStartup() error {
-	startupMessage, err := p.backend.ReceiveStartupMessage()
-	if err != nil {
-		return fmt.Errorf("error receiving startup message: %w", err)
+// handleQuery executes a query sent with the simple query protocol. Errors
+// are reported to the client and are followed by ReadyForQuery like any
+// other response.
+func (b *DataQueryBackend) handleQuery(msg *pgproto3.Query) error {
+	log.Info().Str("query", msg.String).Msg("sql query")
+
+	statements := scanStatements(msg.String)
+	if len(statements) == 0 {
+		if err := b.send(&pgproto3.EmptyQueryResponse{}); err != nil {
+			return fmt.Errorf("error writing query response: %w", err)
+		}
+		return b.sendReadyForQuery()
 	}
 
-	switch startupMessage.(type) {
-	case *pgproto3.StartupMessage:
-		// Do not require auth
-		buf := (&pgproto3.AuthenticationOk{}).Encode(nil)
-		// Indicate backend is Idle and able to accept queries
-		buf = (&pgproto3.ReadyForQuery{TxStatus: 'I'}).Encode(buf)
-		_, err = p.conn.Write(buf)
+	// the statements run one after the other, an error skips the rest
+	for _, stmt := range statements {
+		err := b.executeQuery(stmt.query)
+		var pgErr *pgerror.Error
+		if errors.As(err, &pgErr) {
+			log.Debug().Err(err).Str("query", stmt.query).Msg("query error")
+			// the client expects positions in the query string it sent
+			pgErr = shiftError(pgErr, msg.String[:stmt.offset])
+			err = b.send(errorResponse(pgErr))
+			if err == nil {
+				break
+			}
+		}
 		if err != nil {
-			return fmt.Errorf("error sending ready for query: %w", err)
+			return fmt.Errorf("error writing query response: %w", err)
 		}
-	case *pgproto3.SSLRequest:
-		_, err = p.conn.Write([]byte("N"))
+	}
+	return b.sendReadyForQuery()
+}
+
+// sendReadyForQuery sends the messages followed by ReadyForQuery, the
+// session then accepts notifications.
+func (b *DataQueryBackend) sendReadyForQuery(msgs ...pgproto3.BackendMessage) error {
+	msgs = append(msgs, &pgproto3.ReadyForQuery{TxStatus: 'I'})
+	if err := b.send(msgs...); err != nil {
+		return err
+	}
+	if err := b.flush(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.ready = true
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *DataQueryBackend) executeQuery(query string) error {
+	ctx, done := b.startQuery()
+	defer done()
+	if stmt, ok := parseCommand(query).(*parser.CopyStmt); ok {
+		return b.executeCopy(ctx, stmt)
+	}
+
+	res, err := b.execute(ctx, query, nil)
+	if err != nil {
+		return toError(err)
+	}
+	if res.Columns != nil {
+		err = b.send(&pgproto3.RowDescription{Fields: fieldDescriptions(res.Columns, nil)})
 		if err != nil {
-			return fmt.Errorf("error sending deny SSL request: %w", err)
+			return err
 		}
-		return p.handleStartup()
-	default:
-		return fmt.Errorf("unknown startup message: %#v", startupMessage)
 	}
+	return b.sendResult(ctx, query, res, nil)
+}
 
+// parseCommand returns the statement if it is one the server executes
+// itself. Anything else, including statements which do not parse, is left
+// to the handler.
+func parseCommand(query string) parser.Statement {
+	stmt, err := parser.ParseOne(query)
+	if err != nil {
+		return nil
+	}
+	switch stmt := stmt.(type) {
+	case *parser.SetStmt, *parser.ResetStmt, *parser.ShowStmt, *parser.CopyStmt,
+		*parser.ListenStmt, *parser.UnlistenStmt, *parser.NotifyStmt,
+		*parser.DeclareCursorStmt, *parser.FetchStmt, *parser.CloseStmt:
+		return stmt
+	case *parser.SelectStmt:
+		// only a plain call of pg_notify is handled by the server
+		if notifyCall(stmt) != nil {
+			return stmt
+		}
+	}
 	return nil
 }
 
+// describe returns the columns of the result of the query
+func (b *DataQueryBackend) describe(ctx context.Context, query string) ([]pgtype.Column, error) {
+	if isEmptyQuery(query) {
+		return nil, nil
+	}
+	switch stmt := parseCommand(query).(type) {
+	case *parser.SetStmt, *parser.ResetStmt:
+		return nil, nil
+	case *parser.ShowStmt:
+		return showColumns(stmt), nil
+	case *parser.CopyStmt:
+		_, err := newCopyOptions(stmt)
+		return nil, err
+	case *parser.ListenStmt, *parser.UnlistenStmt, *parser.NotifyStmt, *parser.SelectStmt:
+		notify, err := newNotifyCommand(stmt)
+		if err != nil {
+			return nil, err
+		}
+		return notifyColumns(notify), nil
+	case *parser.DeclareCursorStmt, *parser.FetchStmt, *parser.CloseStmt:
+		cursor, err := newCursorCommand(stmt)
+		if err != nil {
+			return nil, err
+		}
+		return b.cursorColumns(cursor)
+	}
+	return b.handler.Describe(ctx, query)
+}
+
+// execute runs the query, the statements changing the session are handled
+// by the server and everything else is passed to the handler.
+func (b *DataQueryBackend) execute(ctx context.Context, query string, args []interface{}) (*session.Result, error) {
+	switch stmt := parseCommand(query).(type) {
+	case *parser.SetStmt:
+		return b.execSet(stmt)
+	case *parser.ResetStmt:
+		return b.execReset(stmt)
+	case *parser.ShowStmt:
+		return b.execShow(stmt)
+	case *parser.ListenStmt, *parser.UnlistenStmt, *parser.NotifyStmt, *parser.SelectStmt:
+		notify, err := newNotifyCommand(stmt)
+		if err != nil {
+			return nil, err
+		}
+		if notify, err = notify.bind(args); err != nil {
+			return nil, err
+		}
+		return b.execNotifyCommand(notify)
+	case *parser.DeclareCursorStmt, *parser.FetchStmt, *parser.CloseStmt:
+		cursor, err := newCursorCommand(stmt)
+		if err != nil {
+			return nil, err
+		}
+		return b.execCursorCommand(ctx, cursor, args)
+	}
+	return b.handler.Execute(ctx, query, args)
+}
+
+// send buffers the messages, they are written to the client once the
+// buffer is full or on the next flush. Responses to pipelined messages are
+// thereby written together.
+func (b *DataQueryBackend) send(msgs ...pgproto3.BackendMessage) error {
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+	buf := b.encodeBuf[:0]
+	for _, msg := range msgs {
+		buf = msg.Encode(buf)
+	}
+	if cap(buf) <= writeBufferSize {
+		b.encodeBuf = buf
+	}
+	_, err := b.writer.Write(buf)
+	return err
+}
+
+// flush writes the buffered messages to the client. It is called for Sync
+// and Flush, with ReadyForQuery and whenever the client waits for a
+// response before sending its next message.
+func (b *DataQueryBackend) flush() error {
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+	return b.writer.Flush()
+}
+
+// sendNow sends the messages and flushes them right away
+func (b *DataQueryBackend) sendNow(msgs ...pgproto3.BackendMessage) error {
+	if err := b.send(msgs...); err != nil {
+		return err
+	}
+	return b.flush()
+}
+
+// sendResult streams the rows of the result as DataRow messages followed
+// by CommandComplete. The RowDescription, if any, must already be sent.
+// Sending stops with an error when ctx is canceled.
+func (b *DataQueryBackend) sendResult(ctx context.Context, query string, res *session.Result, formats []int16) error {
+	if res.Rows != nil {
+		defer res.Rows.Close()
+	}
+	rows, err := b.sendRows(ctx, res, formats)
+	if err != nil {
+		return err
+	}
+	return b.send(&pgproto3.CommandComplete{CommandTag: []byte(resultTag(query, res, rows))})
+}
+
+// sendRows sends the rows of the result as DataRow messages and returns
+// how many were sent
+func (b *DataQueryBackend) sendRows(ctx context.Context, res *session.Result, formats []int16) (int64, error) {
+	var rows int64
+	if res.Rows != nil {
+		for res.Rows.Next() {
+			if err := ctx.Err(); err != nil {
+				return rows, toError(err)
+			}
+			values := res.Rows.Values()
+			row := make([][]byte, len(values))
+			for i, v := range values {
+				var format int16
+				var oid uint32
+				if i < len(res.Columns) {
+					format = formatCode(formats, i, res.Columns[i].Format)
+					oid = res.Columns[i].TypeOID
+				}
+				buf, err := b.types.Encode(oid, v, format)
+				if err != nil {
+					return rows, toError(err)
+				}
+				row[i] = buf
+			}
+			if err := b.send(&pgproto3.DataRow{Values: row}); err != nil {
+				return rows, err
+			}
+			rows++
+		}
+		if err := res.Rows.Err(); err != nil {
+			return rows, toError(err)
+		}
+	}
+
+	return rows, nil
+}
+
+// resultTag returns the CommandTag of the result, or the one derived from
+// the statement and the number of rows sent or affected
+func resultTag(query string, res *session.Result, rows int64) string {
+	if res.CommandTag != "" {
+		return res.CommandTag
+	}
+	n := res.RowsAffected
+	if res.Columns != nil {
+		n = rows
+	}
+	return commandTag(query, n)
+}
+
+// errTerminated is returned by receive when the server is shutting down
+var errTerminated = errors.New("terminating connection due to administrator command")
+
+// receive waits for the next message from the client. The session is idle
+// while waiting so that Shutdown may terminate it.
+func (b *DataQueryBackend) receive() (pgproto3.FrontendMessage, error) {
+	b.mu.Lock()
+	if b.terminating {
+		b.mu.Unlock()
+		_ = b.fatal(pgerror.NewError(pgerror.CodeAdminShutdown, "%s", errTerminated.Error()))
+		return nil, errTerminated
+	}
+	b.state = stateIdle
+	deliver := b.ready && !b.delivering && len(b.notifications) > 0
+	if deliver {
+		b.delivering = true
+	}
+	b.mu.Unlock()
+	if deliver {
+		if err := b.flushNotifications(); err != nil {
+			return nil, err
+		}
+	}
+
+	msg, err := b.backend.Receive()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = stateBusy
+	b.ready = false
+	if b.terminating {
+		// Shutdown closed the connection while waiting
+		return nil, errTerminated
+	}
+	return msg, err
+}
+
+// terminate ends the session for a graceful shutdown. An idle session is
+// told about the shutdown and closed, a busy one when it next becomes idle
+// and one still starting up is closed right away. The message to an idle
+// session is written before the deadline.
+func (b *DataQueryBackend) terminate(deadline time.Time) {
+	b.mu.Lock()
+	if b.terminating {
+		b.mu.Unlock()
+		return
+	}
+	if b.state == stateStartup {
+		b.terminating = true
+		conn := b.conn
+		b.mu.Unlock()
+		conn.Close()
+		return
+	}
+	b.mu.Unlock()
+
+	// the state must not change until the message is written, otherwise it
+	// could end up in the middle of the response to the next query
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.terminating {
+		return
+	}
+	b.terminating = true
+	if b.state != stateIdle {
+		return
+	}
+	pgErr := pgerror.NewError(pgerror.CodeAdminShutdown, "%s", errTerminated.Error())
+	pgErr.Severity = pgerror.SeverityFatal
+	if err := b.conn.SetWriteDeadline(deadline); err == nil {
+		if _, err := b.writer.Write(errorResponse(pgErr).Encode(nil)); err == nil {
+			_ = b.writer.Flush()
+		}
+	}
+	b.conn.Close()
+}
+
+// forceClose cancels the running query and closes the connection
+func (b *DataQueryBackend) forceClose() {
+	b.cancel()
+	b.mu.Lock()
+	b.conn.Close()
+	b.mu.Unlock()
+}
+
 func (p *DataQueryBackend) Close() error {
+	if p.cancels != nil && p.pid != 0 {
+		p.cancels.unregister(p)
+	}
+	if p.hub != nil {
+		p.hub.unlistenAll(p)
+	}
+	for name := range p.portals {
+		p.closePortal(name)
+	}
+	p.cancel()
 	return p.conn.Close()
 }
@@ -1,9 +1,17 @@
 package server
 
 import (
+	"context"
+	"net"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/session"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestServer_Init(t *testing.T) {
@@ -23,3 +31,197 @@ func TestServer_WithPort(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, ":1986", s.address)
 }
+
+func TestServer_WithHandler(t *testing.T) {
+	s, err := New()
+	assert.NoError(t, err)
+	assert.Equal(t, cowsayHandler{}, s.handler)
+
+	s, err = New(WithHandler(echoHandler{}))
+	assert.NoError(t, err)
+	assert.Equal(t, echoHandler{}, s.handler)
+}
+
+func TestServer_WithTLSMode(t *testing.T) {
+	s, err := New()
+	assert.NoError(t, err)
+	assert.Equal(t, TLSAllow, s.tlsMode)
+
+	s, err = New(WithTLSMode(TLSRequire))
+	assert.NoError(t, err)
+	assert.Equal(t, TLSRequire, s.tlsMode)
+}
+
+func TestServer_WithAuthenticationTimeout(t *testing.T) {
+	s, err := New()
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultAuthenticationTimeout, s.authTimeout)
+
+	s, err = New(WithAuthenticationTimeout(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, s.authTimeout)
+}
+
+// serveTest runs the server on a loopback listener and returns its address
+// and a channel receiving the result of Serve.
+func serveTest(t *testing.T, s *Server) (string, <-chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	done := make(chan error, 1)
+	go func() { done <- s.serve(ln) }()
+	return ln.Addr().String(), done
+}
+
+func dialServer(t *testing.T, addr string) *pgproto3.Frontend {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return startup(t, conn, map[string]string{"user": "test"})
+}
+
+// expectShutdown reads until the admin shutdown error and the close
+func expectShutdown(t *testing.T, f *pgproto3.Frontend) {
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.ErrorResponse{}, msg)
+	assert.Equal(t, pgerror.SeverityFatal, msg.(*pgproto3.ErrorResponse).Severity)
+	assert.Equal(t, pgerror.CodeAdminShutdown, msg.(*pgproto3.ErrorResponse).Code)
+	_, err = f.Receive()
+	assert.Error(t, err)
+}
+
+func TestServer_ShutdownWithoutServe(t *testing.T) {
+	s, err := New()
+	require.NoError(t, err)
+	assert.NoError(t, s.Shutdown(context.Background()))
+	assert.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, ErrServerClosed, s.Serve())
+}
+
+func TestServer_ShutdownIdle(t *testing.T) {
+	s, err := New()
+	require.NoError(t, err)
+	addr, done := serveTest(t, s)
+	f := dialServer(t, addr)
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	expectShutdown(t, f)
+	assert.NoError(t, <-done)
+
+	_, err = net.Dial("tcp", addr)
+	assert.Error(t, err)
+}
+
+func TestServer_ShutdownWaitsForQuery(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	s, err := New(WithHandler(funcHandler(func(ctx context.Context, query string, args []interface{}) (*session.Result, error) {
+		close(started)
+		<-release
+		return &session.Result{CommandTag: "DONE"}, nil
+	})))
+	require.NoError(t, err)
+	addr, _ := serveTest(t, s)
+	f := dialServer(t, addr)
+
+	require.NoError(t, f.Send(&pgproto3.Query{String: "slow"}))
+	<-started
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown returned while a query was running: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	assert.Equal(t, &pgproto3.CommandComplete{CommandTag: []byte("DONE")}, msg)
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.ReadyForQuery{}, msg)
+	expectShutdown(t, f)
+	assert.NoError(t, <-shutdown)
+}
+
+func TestServer_ShutdownDeadline(t *testing.T) {
+	started, canceled := make(chan struct{}), make(chan struct{})
+	s, err := New(WithHandler(funcHandler(func(ctx context.Context, query string, args []interface{}) (*session.Result, error) {
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return nil, ctx.Err()
+	})))
+	require.NoError(t, err)
+	addr, _ := serveTest(t, s)
+	f := dialServer(t, addr)
+
+	require.NoError(t, f.Send(&pgproto3.Query{String: "forever"}))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Shutdown(ctx))
+	<-canceled
+
+	for {
+		if _, err := f.Receive(); err != nil {
+			break
+		}
+	}
+}
+
+func TestServer_ShutdownStuckClient(t *testing.T) {
+	s, err := New(WithHandler(echoHandler{}))
+	require.NoError(t, err)
+	addr, _ := serveTest(t, s)
+
+	// the listener never reads so writing to it blocks
+	listener := dialServer(t, addr)
+	simpleQuery(t, listener, "LISTEN cache")
+	sender := dialServer(t, addr)
+	payload := strings.Repeat("x", maxNotifyPayload)
+	for i := 0; i < 3000; i++ {
+		simpleQuery(t, sender, "NOTIFY cache, '"+payload+"'")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(ctx) }()
+	select {
+	case err := <-shutdown:
+		assert.Equal(t, context.DeadlineExceeded, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown blocked on a client which does not read")
+	}
+}
+
+func TestServer_ShutdownWithoutDeadline(t *testing.T) {
+	timeout := shutdownWriteTimeout
+	shutdownWriteTimeout = 50 * time.Millisecond
+	defer func() { shutdownWriteTimeout = timeout }()
+
+	s, err := New()
+	require.NoError(t, err)
+	// writes to a pipe block until the client reads
+	client, conn := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	b := s.newBackend(conn)
+	require.True(t, s.track(b))
+	go func() {
+		defer s.untrack(b)
+		s.handleConnection(b)
+	}()
+	startup(t, client, map[string]string{"user": "test"})
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	select {
+	case err := <-shutdown:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown blocked on an idle client which does not read")
+	}
+}
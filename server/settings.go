@@ -0,0 +1,374 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/session"
+	"github.com/patrickglass/dsql/version"
+)
+
+// compatVersion is the postgres version the server presents itself as,
+// drivers parse the leading number of server_version to decide which
+// features they can use.
+const compatVersion = "14.0"
+
+// serverVersion is reported to clients in the server_version parameter
+func serverVersion() string {
+	return compatVersion + " (dsql " + version.Version + ")"
+}
+
+// reportedParameters are sent to the client in ParameterStatus messages
+// after startup and whenever they change. Setting names are case
+// insensitive, the values are the names as reported to clients.
+var reportedParameters = map[string]string{
+	"application_name":            "application_name",
+	"client_encoding":             "client_encoding",
+	"datestyle":                   "DateStyle",
+	"integer_datetimes":           "integer_datetimes",
+	"intervalstyle":               "IntervalStyle",
+	"is_superuser":                "is_superuser",
+	"server_encoding":             "server_encoding",
+	"server_version":              "server_version",
+	"session_authorization":       "session_authorization",
+	"standard_conforming_strings": "standard_conforming_strings",
+	"timezone":                    "TimeZone",
+}
+
+// readOnlyParameters cannot be changed by clients
+var readOnlyParameters = map[string]bool{
+	"integer_datetimes": true,
+	"is_superuser":      true,
+	"server_encoding":   true,
+	"server_version":    true,
+}
+
+// startupOnlyParameters are part of the StartupMessage but are not settings
+var startupOnlyParameters = map[string]bool{
+	"user":        true,
+	"database":    true,
+	"options":     true,
+	"replication": true,
+}
+
+// defaultSettings returns the settings of a new session
+func defaultSettings(user string) map[string]string {
+	return map[string]string{
+		"application_name":            "",
+		"client_encoding":             "UTF8",
+		"datestyle":                   "ISO, MDY",
+		"extra_float_digits":          "1",
+		"integer_datetimes":           "on",
+		"intervalstyle":               "postgres",
+		"is_superuser":                "off",
+		"search_path":                 `"$user", public`,
+		"server_encoding":             "UTF8",
+		"server_version":              serverVersion(),
+		"session_authorization":       user,
+		"standard_conforming_strings": "on",
+		"statement_timeout":           "0",
+		"timezone":                    "UTC",
+	}
+}
+
+// parameterName returns the name of the setting as shown to clients
+func parameterName(name string) string {
+	if reported, ok := reportedParameters[name]; ok {
+		return reported
+	}
+	return name
+}
+
+// initSettings applies the parameters of the StartupMessage on top of the
+// defaults, they become the values RESET returns to.
+func (b *DataQueryBackend) initSettings(params map[string]string) error {
+	b.settings = defaultSettings(b.user)
+	for name, value := range params {
+		if startupOnlyParameters[name] {
+			continue
+		}
+		name = strings.ToLower(name)
+		value, err := checkSetting(name, value, b.settings[name])
+		if err != nil {
+			return err
+		}
+		b.settings[name] = value
+	}
+	b.sessionDefaults = make(map[string]string, len(b.settings))
+	for name, value := range b.settings {
+		b.sessionDefaults[name] = value
+	}
+	return nil
+}
+
+// parameterStatus returns the ParameterStatus messages of all reported
+// settings sorted by name.
+func (b *DataQueryBackend) parameterStatus() []pgproto3.BackendMessage {
+	names := make([]string, 0, len(reportedParameters))
+	for name := range reportedParameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msgs := make([]pgproto3.BackendMessage, len(names))
+	for i, name := range names {
+		msgs[i] = &pgproto3.ParameterStatus{Name: reportedParameters[name], Value: b.settings[name]}
+	}
+	return msgs
+}
+
+// setParameter changes a setting of the session, the client is sent a
+// ParameterStatus when a reported setting changed.
+func (b *DataQueryBackend) setParameter(name, value string) error {
+	name = strings.ToLower(name)
+	if readOnlyParameters[name] {
+		return pgerror.NewError(pgerror.CodeCantChangeRuntimeParam, "parameter \"%s\" cannot be changed", name)
+	}
+	old, ok := b.settings[name]
+	value, err := checkSetting(name, value, old)
+	if err != nil {
+		return err
+	}
+	b.settings[name] = value
+	if _, reported := reportedParameters[name]; reported && (!ok || old != value) {
+		return b.send(&pgproto3.ParameterStatus{Name: reportedParameters[name], Value: value})
+	}
+	return nil
+}
+
+// resetParameter returns a setting to the value it had after startup
+func (b *DataQueryBackend) resetParameter(name string) error {
+	name = strings.ToLower(name)
+	if readOnlyParameters[name] {
+		return pgerror.NewError(pgerror.CodeCantChangeRuntimeParam, "parameter \"%s\" cannot be changed", name)
+	}
+	if !knownSetting(name) {
+		return errUnknownSetting(name)
+	}
+	value, ok := b.sessionDefaults[name]
+	if !ok {
+		// custom settings only exist after they have been set
+		delete(b.settings, name)
+		return nil
+	}
+	return b.setParameter(name, value)
+}
+
+// knownSetting reports whether the setting exists, names with a dot are
+// custom settings which may be set to anything like in postgres.
+func knownSetting(name string) bool {
+	if strings.Contains(name, ".") {
+		return true
+	}
+	_, ok := defaultSettings("")[name]
+	return ok
+}
+
+func errUnknownSetting(name string) error {
+	return pgerror.NewError(pgerror.CodeUndefinedObject, "unrecognized configuration parameter \"%s\"", name)
+}
+
+// checkSetting rejects unknown settings and values the server cannot honor,
+// it returns the value in the form reported to clients. current is the
+// value the setting has now.
+func checkSetting(name, value, current string) (string, error) {
+	if !knownSetting(name) {
+		return "", errUnknownSetting(name)
+	}
+	switch name {
+	case "client_encoding":
+		switch strings.ToUpper(strings.ReplaceAll(value, "-", "")) {
+		case "UTF8", "UNICODE":
+		default:
+			return "", errInvalidSetting(name, value)
+		}
+	case "standard_conforming_strings", "integer_datetimes":
+		switch strings.ToLower(value) {
+		case "on", "true", "yes", "1":
+		default:
+			return "", pgerror.NewError(pgerror.CodeInvalidParameterValue, "parameter \"%s\" only supports on", name)
+		}
+	case "datestyle":
+		return checkDateStyle(value, current)
+	case "timezone":
+		return checkTimeZone(value)
+	}
+	return value, nil
+}
+
+func errInvalidSetting(name, value string) *pgerror.Error {
+	return pgerror.NewError(pgerror.CodeInvalidParameterValue, "invalid value for parameter \"%s\": \"%s\"", parameterName(name), value)
+}
+
+// checkDateStyle normalizes a DateStyle such as "iso, dmy" to "ISO, DMY".
+// Dates are always sent in the ISO format so it is the only output style
+// accepted, drivers such as JDBC refuse any other. The field order keeps
+// its current value when only the style is given.
+func checkDateStyle(value, current string) (string, error) {
+	order := "MDY"
+	if i := strings.LastIndexByte(current, ' '); i >= 0 {
+		order = current[i+1:]
+	}
+	for _, part := range strings.Split(value, ",") {
+		switch strings.ToUpper(strings.TrimSpace(part)) {
+		case "ISO", "DEFAULT":
+		case "DMY", "EURO", "EUROPEAN":
+			order = "DMY"
+		case "MDY", "US", "NONEURO", "NONEUROPEAN":
+			order = "MDY"
+		case "YMD":
+			order = "YMD"
+		case "POSTGRES", "SQL", "GERMAN":
+			err := errInvalidSetting("datestyle", value)
+			err.Detail = "Only the ISO output style is supported."
+			return "", err
+		default:
+			return "", errInvalidSetting("datestyle", value)
+		}
+	}
+	return "ISO, " + order, nil
+}
+
+// checkTimeZone accepts the names of the time zone database and UTC
+// offsets in hours such as "+2" or "-5:30".
+func checkTimeZone(value string) (string, error) {
+	switch strings.ToUpper(value) {
+	case "UTC", "GMT", "Z":
+		return strings.ToUpper(value), nil
+	}
+	if isUTCOffset(value) {
+		return value, nil
+	}
+	if value != "" && !strings.HasPrefix(value, "/") && !strings.Contains(value, "..") {
+		if _, err := time.LoadLocation(value); err == nil {
+			return value, nil
+		}
+	}
+	return "", errInvalidSetting("timezone", value)
+}
+
+// isUTCOffset reports whether the value is an offset such as "-5:30"
+func isUTCOffset(value string) bool {
+	value = strings.TrimLeft(value, "+-")
+	hours, minutes := value, "0"
+	if i := strings.IndexByte(value, ':'); i >= 0 {
+		hours, minutes = value[:i], value[i+1:]
+	}
+	h, err := strconv.Atoi(hours)
+	if err != nil || h > 15 {
+		return false
+	}
+	m, err := strconv.Atoi(minutes)
+	return err == nil && m < 60 && len(minutes) <= 2
+}
+
+// SetParameter changes a setting like the SET command, reported settings
+// are sent to the client. It implements session.Session.
+func (b *DataQueryBackend) SetParameter(name, value string) error {
+	return b.setParameter(name, value)
+}
+
+// Parameter returns the current value of a setting, it implements
+// session.Session.
+func (b *DataQueryBackend) Parameter(name string) (string, bool) {
+	value, ok := b.settings[strings.ToLower(name)]
+	return value, ok
+}
+
+// settingValue returns the value of a SET statement, a list of values is
+// joined with commas like postgres does
+func settingValue(stmt *parser.SetStmt) string {
+	values := make([]string, len(stmt.Values))
+	for i, v := range stmt.Values {
+		if lit, ok := v.(*parser.Literal); ok {
+			values[i] = lit.Value
+		} else {
+			values[i] = v.String()
+		}
+	}
+	return strings.Join(values, ", ")
+}
+
+// execSet runs a SET statement, setting the default resets the setting
+func (b *DataQueryBackend) execSet(stmt *parser.SetStmt) (*session.Result, error) {
+	var err error
+	if len(stmt.Values) == 0 {
+		err = b.resetParameter(stmt.Name)
+	} else {
+		err = b.setParameter(stmt.Name, settingValue(stmt))
+	}
+	return &session.Result{CommandTag: "SET"}, err
+}
+
+// execReset runs a RESET statement
+func (b *DataQueryBackend) execReset(stmt *parser.ResetStmt) (*session.Result, error) {
+	if stmt.Name != "all" {
+		return &session.Result{CommandTag: "RESET"}, b.resetParameter(stmt.Name)
+	}
+	for name := range b.settings {
+		if readOnlyParameters[name] {
+			continue
+		}
+		if err := b.resetParameter(name); err != nil {
+			return nil, err
+		}
+	}
+	return &session.Result{CommandTag: "RESET"}, nil
+}
+
+// execShow runs a SHOW statement
+func (b *DataQueryBackend) execShow(stmt *parser.ShowStmt) (*session.Result, error) {
+	columns := showColumns(stmt)
+	if stmt.Name == "all" {
+		names := make([]string, 0, len(b.settings))
+		for name := range b.settings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		rows := make([][]interface{}, len(names))
+		for i, name := range names {
+			rows[i] = []interface{}{parameterName(name), b.settings[name]}
+		}
+		res := session.NewResult(columns, rows)
+		res.CommandTag = "SHOW"
+		return res, nil
+	}
+	value, ok := b.settings[stmt.Name]
+	if !ok {
+		return nil, errUnknownSetting(stmt.Name)
+	}
+	res := session.NewResult(columns, [][]interface{}{{value}})
+	res.CommandTag = "SHOW"
+	return res, nil
+}
+
+// showColumns describes the result of a SHOW statement
+func showColumns(stmt *parser.ShowStmt) []pgtype.Column {
+	if stmt.Name == "all" {
+		return []pgtype.Column{TextColumn("name"), TextColumn("setting")}
+	}
+	return []pgtype.Column{TextColumn(parameterName(stmt.Name))}
+}
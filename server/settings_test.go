@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettingValue(t *testing.T) {
+	tests := map[string]string{
+		"SET application_name = 'psql'":                "psql",
+		"set DateStyle to ISO, DMY;":                   "iso, dmy",
+		"SET SESSION search_path TO \"$user\", public": "$user, public",
+		"SET LOCAL statement_timeout = 0":              "0",
+		"SET application_name = 'it''s'":               "it's",
+		"SET TIME ZONE 'Europe/Berlin'":                "Europe/Berlin",
+		"SET my.offset = -5":                           "-5",
+		"SET TIME ZONE LOCAL":                          "",
+		"SET timezone TO DEFAULT":                      "",
+	}
+	for query, value := range tests {
+		stmt, ok := parseCommand(query).(*parser.SetStmt)
+		require.True(t, ok, query)
+		assert.Equal(t, value, settingValue(stmt), query)
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	for _, query := range []string{"SET a = 1", "RESET ALL", "SHOW TIME ZONE"} {
+		assert.NotNil(t, parseCommand(query), query)
+	}
+	// statements which are not session commands or do not parse are left
+	// to the handler
+	for _, query := range []string{"SELECT 1", "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE", "SET ROLE admin", "SET a = 1; SELECT 2", "SET a = 1 2", "SHOW a b", ""} {
+		assert.Nil(t, parseCommand(query), query)
+	}
+}
+
+// startupStatus completes the startup and returns the reported parameters
+func startupStatus(t *testing.T, f *pgproto3.Frontend, params map[string]string) map[string]string {
+	require.NoError(t, f.Send(&pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters:      params,
+	}))
+	status := map[string]string{}
+	for {
+		msg, err := f.Receive()
+		require.NoError(t, err)
+		switch msg := msg.(type) {
+		case *pgproto3.ParameterStatus:
+			status[msg.Name] = msg.Value
+		case *pgproto3.ReadyForQuery:
+			return status
+		case *pgproto3.ErrorResponse:
+			t.Fatalf("startup failed: %s", msg.Message)
+		}
+	}
+}
+
+func TestStartupParameterStatus(t *testing.T) {
+	conn := dialBackend(t, echoHandler{}, nil)
+	f := pgproto3.NewFrontend(pgproto3.NewChunkReader(conn), conn)
+	status := startupStatus(t, f, map[string]string{
+		"user":             "alice",
+		"application_name": "pgx",
+		"DateStyle":        "ISO, DMY",
+	})
+
+	assert.True(t, strings.HasPrefix(status["server_version"], compatVersion+" (dsql "), status["server_version"])
+	assert.Equal(t, "UTF8", status["client_encoding"])
+	assert.Equal(t, "UTF8", status["server_encoding"])
+	assert.Equal(t, "ISO, DMY", status["DateStyle"])
+	assert.Equal(t, "on", status["integer_datetimes"])
+	assert.Equal(t, "on", status["standard_conforming_strings"])
+	assert.Equal(t, "UTC", status["TimeZone"])
+	assert.Equal(t, "pgx", status["application_name"])
+	assert.Equal(t, "alice", status["session_authorization"])
+	assert.Len(t, status, len(reportedParameters))
+}
+
+func TestStartupInvalidSetting(t *testing.T) {
+	conn := dialBackend(t, echoHandler{}, nil)
+	f := pgproto3.NewFrontend(pgproto3.NewChunkReader(conn), conn)
+	require.NoError(t, f.Send(&pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters:      map[string]string{"user": "alice", "client_encoding": "LATIN1"},
+	}))
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.ErrorResponse{}, msg)
+	assert.Equal(t, pgerror.SeverityFatal, msg.(*pgproto3.ErrorResponse).Severity)
+	assert.Equal(t, pgerror.CodeInvalidParameterValue, msg.(*pgproto3.ErrorResponse).Code)
+
+	conn = dialBackend(t, echoHandler{}, nil)
+	f = pgproto3.NewFrontend(pgproto3.NewChunkReader(conn), conn)
+	require.NoError(t, f.Send(&pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters:      map[string]string{"user": "alice", "no_such_setting": "1"},
+	}))
+	msg, err = f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.ErrorResponse{}, msg)
+	assert.Equal(t, pgerror.CodeUndefinedObject, msg.(*pgproto3.ErrorResponse).Code)
+}
+
+func TestCheckSetting(t *testing.T) {
+	tests := []struct {
+		name, value, current, want string
+	}{
+		{"datestyle", "iso, dmy", "ISO, MDY", "ISO, DMY"},
+		{"datestyle", "ISO", "ISO, YMD", "ISO, YMD"},
+		{"datestyle", "US", "ISO, DMY", "ISO, MDY"},
+		{"timezone", "utc", "UTC", "UTC"},
+		{"timezone", "Europe/Paris", "UTC", "Europe/Paris"},
+		{"timezone", "-5:30", "UTC", "-5:30"},
+		{"search_path", "public", "", "public"},
+	}
+	for _, tt := range tests {
+		got, err := checkSetting(tt.name, tt.value, tt.current)
+		require.NoError(t, err, tt.value)
+		assert.Equal(t, tt.want, got, tt.value)
+	}
+
+	for _, value := range []string{"", "../etc/passwd", "/etc/localtime", "+16", "1:75", "Nowhere/City"} {
+		_, err := checkSetting("timezone", value, "UTC")
+		assert.Error(t, err, value)
+	}
+}
+
+// simpleQuery sends a query and returns the responses up to ReadyForQuery
+func simpleQuery(t *testing.T, f *pgproto3.Frontend, query string) []pgproto3.BackendMessage {
+	require.NoError(t, f.Send(&pgproto3.Query{String: query}))
+	return receiveUntilReady(t, f)
+}
+
+// receiveUntilReady returns the responses up to ReadyForQuery
+func receiveUntilReady(t *testing.T, f *pgproto3.Frontend) []pgproto3.BackendMessage {
+	var msgs []pgproto3.BackendMessage
+	for {
+		msg, err := f.Receive()
+		require.NoError(t, err)
+		if _, ok := msg.(*pgproto3.ReadyForQuery); ok {
+			return msgs
+		}
+		msgs = append(msgs, copyMessage(t, msg))
+	}
+}
+
+// copyMessage decodes a copy of the message, received messages are only
+// valid until the next Receive.
+func copyMessage(t *testing.T, msg pgproto3.BackendMessage) pgproto3.BackendMessage {
+	buf := msg.Encode(nil)
+	var c pgproto3.BackendMessage
+	switch msg.(type) {
+	case *pgproto3.ParameterStatus:
+		c = &pgproto3.ParameterStatus{}
+	case *pgproto3.CommandComplete:
+		c = &pgproto3.CommandComplete{}
+	case *pgproto3.RowDescription:
+		c = &pgproto3.RowDescription{}
+	case *pgproto3.DataRow:
+		c = &pgproto3.DataRow{}
+	case *pgproto3.ErrorResponse:
+		c = &pgproto3.ErrorResponse{}
+	default:
+		return msg
+	}
+	require.NoError(t, c.Decode(buf[5:]))
+	return c
+}
+
+func TestSetParameter(t *testing.T) {
+	f := connectBackend(t, echoHandler{})
+
+	msgs := simpleQuery(t, f, "SET application_name = 'reports'")
+	assert.Equal(t, []pgproto3.BackendMessage{
+		&pgproto3.ParameterStatus{Name: "application_name", Value: "reports"},
+		&pgproto3.CommandComplete{CommandTag: []byte("SET")},
+	}, msgs)
+
+	// unchanged and unreported settings are not sent
+	msgs = simpleQuery(t, f, "SET application_name TO reports")
+	assert.Equal(t, []pgproto3.BackendMessage{&pgproto3.CommandComplete{CommandTag: []byte("SET")}}, msgs)
+	msgs = simpleQuery(t, f, "SET search_path TO public")
+	assert.Equal(t, []pgproto3.BackendMessage{&pgproto3.CommandComplete{CommandTag: []byte("SET")}}, msgs)
+
+	msgs = simpleQuery(t, f, "SHOW application_name")
+	require.Len(t, msgs, 3)
+	assert.Equal(t, "application_name", string(msgs[0].(*pgproto3.RowDescription).Fields[0].Name))
+	assert.Equal(t, [][]byte{[]byte("reports")}, msgs[1].(*pgproto3.DataRow).Values)
+	assert.Equal(t, &pgproto3.CommandComplete{CommandTag: []byte("SHOW")}, msgs[2])
+
+	msgs = simpleQuery(t, f, "RESET application_name")
+	assert.Equal(t, []pgproto3.BackendMessage{
+		&pgproto3.ParameterStatus{Name: "application_name", Value: ""},
+		&pgproto3.CommandComplete{CommandTag: []byte("RESET")},
+	}, msgs)
+
+	msgs = simpleQuery(t, f, "SET datestyle = euro")
+	assert.Equal(t, []pgproto3.BackendMessage{
+		&pgproto3.ParameterStatus{Name: "DateStyle", Value: "ISO, DMY"},
+		&pgproto3.CommandComplete{CommandTag: []byte("SET")},
+	}, msgs)
+	msgs = simpleQuery(t, f, "SET my.setting = 1")
+	assert.Equal(t, []pgproto3.BackendMessage{&pgproto3.CommandComplete{CommandTag: []byte("SET")}}, msgs)
+
+	for query, code := range map[string]string{
+		"SET server_version = '9.6'":   pgerror.CodeCantChangeRuntimeParam,
+		"SET client_encoding = LATIN1": pgerror.CodeInvalidParameterValue,
+		"SET DateStyle = 'garbage'":    pgerror.CodeInvalidParameterValue,
+		"SET DateStyle = 'SQL, DMY'":   pgerror.CodeInvalidParameterValue,
+		"SET TIME ZONE 'Mars/Olympus'": pgerror.CodeInvalidParameterValue,
+		"SET no_such_setting = 1":      pgerror.CodeUndefinedObject,
+		"RESET no_such_setting":        pgerror.CodeUndefinedObject,
+		"SHOW no_such_setting":         pgerror.CodeUndefinedObject,
+	} {
+		msgs = simpleQuery(t, f, query)
+		require.Len(t, msgs, 1, query)
+		assert.Equal(t, code, msgs[0].(*pgproto3.ErrorResponse).Code, query)
+	}
+
+	// SET commands which are not settings are executed by the handler
+	msgs = simpleQuery(t, f, "SET ROLE admin")
+	assert.Equal(t, []string{"SET ROLE admin"}, dataRows(msgs))
+}
+
+func TestSetParameterFromHandler(t *testing.T) {
+	f := connectBackend(t, funcHandler(func(ctx context.Context, query string, args []interface{}) (*session.Result, error) {
+		if err := session.SetParameter(ctx, "TimeZone", query); err != nil {
+			return nil, err
+		}
+		value, _ := session.Parameter(ctx, "timezone")
+		return &session.Result{CommandTag: value}, nil
+	}))
+
+	msgs := simpleQuery(t, f, "Europe/Paris")
+	assert.Equal(t, []pgproto3.BackendMessage{
+		&pgproto3.ParameterStatus{Name: "TimeZone", Value: "Europe/Paris"},
+		&pgproto3.CommandComplete{CommandTag: []byte("Europe/Paris")},
+	}, msgs)
+
+	assert.Error(t, session.SetParameter(context.Background(), "TimeZone", "UTC"))
+}
@@ -0,0 +1,469 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/rs/zerolog/log"
+)
+
+// TLSMode controls whether clients may, or must, encrypt their connection
+type TLSMode int
+
+const (
+	// TLSAllow upgrades the connection when the client sends an SSLRequest
+	// and a TLS configuration is present, plaintext clients are accepted.
+	TLSAllow TLSMode = iota
+	// TLSDisable answers every SSLRequest with 'N'
+	TLSDisable
+	// TLSRequire rejects clients which send a StartupMessage without first
+	// upgrading the connection to TLS.
+	TLSRequire
+)
+
+// Request codes sent in place of the protocol version by the messages
+// which may precede the StartupMessage.
+const (
+	cancelRequestCode = 80877102
+	sslRequestCode    = 80877103
+	gssEncRequestCode = 80877104
+)
+
+// The protocol versions understood by the server, clients requesting a
+// newer minor version are sent NegotiateProtocolVersion.
+const (
+	protocolMajor = 3
+	protocolMinor = 0
+)
+
+// protocolOptionPrefix starts the names of protocol options sent in the
+// StartupMessage, none are supported
+const protocolOptionPrefix = "_pq_."
+
+const (
+	minStartupPacketLen = 4     // a single 32-bit int version or code
+	maxStartupPacketLen = 10000 // MAX_STARTUP_PACKET_LENGTH from PG source
+)
+
+// errCancelRequest ends the connection which carried a CancelRequest
+var errCancelRequest = errors.New("cancel request received")
+
+// DefaultAuthenticationTimeout bounds the time a client has to complete
+// the startup including the authentication, like authentication_timeout of
+// postgres
+const DefaultAuthenticationTimeout = time.Minute
+
+func (b *DataQueryBackend) handleStartup() error {
+	// the deadline is cleared once the session is ready for queries, an
+	// upgraded TLS connection shares it with the underlying one
+	if err := b.conn.SetDeadline(time.Now().Add(b.authTimeout)); err != nil {
+		return err
+	}
+	defer b.conn.SetDeadline(time.Time{})
+
+	prefix, err := b.handleDirectTLS()
+	if err != nil {
+		return err
+	}
+	for {
+		msg, err := b.receiveStartupMessage(prefix)
+		prefix = nil
+		if err != nil {
+			return fmt.Errorf("error receiving startup message: %w", err)
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto3.StartupMessage:
+			return b.handleStartupMessage(msg)
+		case *pgproto3.SSLRequest:
+			err = b.handleSSLRequest()
+			if err != nil {
+				return err
+			}
+		case *pgproto3.GSSEncRequest:
+			// GSSAPI encryption is not supported, the client may continue
+			// with an SSLRequest or in plaintext
+			if _, err := b.conn.Write([]byte("N")); err != nil {
+				return fmt.Errorf("error sending deny GSSENC request: %w", err)
+			}
+		case *pgproto3.CancelRequest:
+			if b.cancels != nil {
+				b.cancels.cancel(msg)
+			}
+			return errCancelRequest
+		default:
+			return fmt.Errorf("unknown startup message: %#v", msg)
+		}
+	}
+}
+
+// receiveStartupMessage reads exactly one startup packet from the
+// connection, starting with the bytes of prefix which have already been
+// read. Unlike pgproto3.Backend it never reads ahead so no plaintext data
+// sent after an SSLRequest can end up being treated as encrypted.
+func (b *DataQueryBackend) receiveStartupMessage(prefix []byte) (pgproto3.FrontendMessage, error) {
+	header := make([]byte, 4)
+	n := copy(header, prefix)
+	if _, err := io.ReadFull(b.conn, header[n:]); err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint32(header)) - 4
+	if size < minStartupPacketLen || size > maxStartupPacketLen {
+		return nil, fmt.Errorf("invalid length of startup packet: %d", size)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(b.conn, body); err != nil {
+		return nil, err
+	}
+
+	var msg pgproto3.FrontendMessage
+	switch code := binary.BigEndian.Uint32(body); code {
+	case sslRequestCode:
+		msg = &pgproto3.SSLRequest{}
+	case cancelRequestCode:
+		msg = &pgproto3.CancelRequest{}
+	case gssEncRequestCode:
+		msg = &pgproto3.GSSEncRequest{}
+	default:
+		return b.decodeStartupMessage(code, body)
+	}
+	return msg, msg.Decode(body)
+}
+
+// decodeStartupMessage decodes a StartupMessage of any minor version of
+// protocol 3, the version requested by the client is kept in the message.
+// Other major versions are rejected.
+func (b *DataQueryBackend) decodeStartupMessage(version uint32, body []byte) (pgproto3.FrontendMessage, error) {
+	major, minor := version>>16, version&0xffff
+	if major != protocolMajor {
+		return nil, b.fatal(pgerror.NewError(pgerror.CodeFeatureNotSupported,
+			"unsupported frontend protocol %d.%d: server supports %d.0 to %d.%d",
+			major, minor, protocolMajor, protocolMajor, protocolMinor))
+	}
+
+	// pgproto3 only decodes version 3.0
+	binary.BigEndian.PutUint32(body, pgproto3.ProtocolVersionNumber)
+	msg := &pgproto3.StartupMessage{}
+	if err := msg.Decode(body); err != nil {
+		return nil, err
+	}
+	msg.ProtocolVersion = version
+	return msg, nil
+}
+
+// negotiateProtocol removes the protocol options from the parameters and
+// tells the client which version and options the session uses when it
+// asked for more than the server supports.
+func (b *DataQueryBackend) negotiateProtocol(msg *pgproto3.StartupMessage) error {
+	var options []string
+	for name := range msg.Parameters {
+		if strings.HasPrefix(name, protocolOptionPrefix) {
+			options = append(options, name)
+			delete(msg.Parameters, name)
+		}
+	}
+	if msg.ProtocolVersion&0xffff <= protocolMinor && len(options) == 0 {
+		return nil
+	}
+	sort.Strings(options)
+	return b.send(&negotiateProtocolVersion{
+		NewestMinorProtocol: protocolMinor,
+		UnrecognizedOptions: options,
+	})
+}
+
+// negotiateProtocolVersion is the NegotiateProtocolVersion message which
+// pgproto3 does not provide
+type negotiateProtocolVersion struct {
+	NewestMinorProtocol uint32
+	UnrecognizedOptions []string
+}
+
+func (*negotiateProtocolVersion) Backend() {}
+
+func (dst *negotiateProtocolVersion) Decode(src []byte) error {
+	if len(src) < 8 {
+		return errors.New("negotiate protocol version message too short")
+	}
+	dst.NewestMinorProtocol = binary.BigEndian.Uint32(src)
+	n := int(binary.BigEndian.Uint32(src[4:]))
+	options := strings.Split(string(src[8:]), "\x00")
+	if len(options) != n+1 || options[n] != "" {
+		return errors.New("invalid negotiate protocol version message")
+	}
+	dst.UnrecognizedOptions = options[:n]
+	return nil
+}
+
+func (src *negotiateProtocolVersion) Encode(dst []byte) []byte {
+	dst = append(dst, 'v')
+	sp := len(dst)
+	dst = append(dst, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(dst[sp+4:], src.NewestMinorProtocol)
+	binary.BigEndian.PutUint32(dst[sp+8:], uint32(len(src.UnrecognizedOptions)))
+	for _, option := range src.UnrecognizedOptions {
+		dst = append(dst, option...)
+		dst = append(dst, 0)
+	}
+	binary.BigEndian.PutUint32(dst[sp:], uint32(len(dst)-sp))
+	return dst
+}
+
+func (b *DataQueryBackend) handleStartupMessage(msg *pgproto3.StartupMessage) error {
+	if err := b.negotiateProtocol(msg); err != nil {
+		return fmt.Errorf("error sending negotiate protocol version: %w", err)
+	}
+	if b.tlsMode == TLSRequire && !b.isTLS() {
+		return b.fatal(pgerror.NewError(pgerror.CodeInvalidAuthorizationSpecification,
+			"SSL connection is required for user \"%s\"", msg.Parameters["user"]))
+	}
+
+	b.user = msg.Parameters["user"]
+	b.database = msg.Parameters["database"]
+	if b.database == "" {
+		b.database = b.user
+	}
+	if b.user == "" {
+		return b.fatal(pgerror.NewError(pgerror.CodeInvalidAuthorizationSpecification, "no PostgreSQL user name specified in startup packet"))
+	}
+
+	if err := b.authenticate(); err != nil {
+		return err
+	}
+	if err := b.initSettings(msg.Parameters); err != nil {
+		return b.fatal(toError(err))
+	}
+
+	msgs := []pgproto3.BackendMessage{&pgproto3.AuthenticationOk{}}
+	msgs = append(msgs, b.parameterStatus()...)
+	if b.cancels != nil {
+		if err := b.cancels.register(b); err != nil {
+			return b.fatal(toError(err))
+		}
+		msgs = append(msgs, &pgproto3.BackendKeyData{ProcessID: b.pid, SecretKey: b.secretKey})
+	}
+	// Indicate backend is Idle and able to accept queries
+	err := b.sendReadyForQuery(msgs...)
+	if err != nil {
+		return fmt.Errorf("error sending ready for query: %w", err)
+	}
+	return nil
+}
+
+// authenticate verifies the client with the authenticator chosen by the
+// access rules, or the configured authenticator when there are none. The
+// client is trusted when neither is set.
+func (b *DataQueryBackend) authenticate() error {
+	auth := b.auth
+	if b.hba != nil {
+		rule, ok := b.hba.Match(b.conn.RemoteAddr(), b.isTLS(), b.database, b.user)
+		if !ok {
+			authFailures.WithLabelValues("hba").Inc()
+			ssl := "SSL off"
+			if b.isTLS() {
+				ssl = "SSL on"
+			}
+			return b.fatal(pgerror.NewError(pgerror.CodeInvalidAuthorizationSpecification,
+				"no access rule for host \"%s\", user \"%s\", database \"%s\", %s",
+				remoteHost(b.conn.RemoteAddr()), b.user, b.database, ssl))
+		}
+		log.Debug().Int("line", rule.Line).Str("user", b.user).Msg("matched access rule")
+
+		var err error
+		auth, err = b.ruleAuthenticator(rule)
+		if err != nil {
+			return b.fatal(pgerror.NewError(pgerror.CodeInternalError, "%s", err.Error()))
+		}
+		if err := checkClientCert(authConn{b}, rule, b.identMaps); err != nil {
+			authFailures.WithLabelValues(string(AuthCert)).Inc()
+			return b.fatal(toError(err))
+		}
+	}
+	if auth == nil {
+		return nil
+	}
+
+	method := auth.Method()
+	err := auth.Authenticate(authConn{b})
+	if err == nil {
+		log.Debug().Str("user", b.user).Str("method", string(method)).Msg("client authenticated")
+		return nil
+	}
+
+	authFailures.WithLabelValues(string(method)).Inc()
+	log.Warn().Err(err).
+		Str("address", b.conn.RemoteAddr().String()).
+		Str("user", b.user).
+		Str("method", string(method)).
+		Msg("authentication failed")
+
+	var pgErr *pgerror.Error
+	if !errors.As(err, &pgErr) {
+		pgErr = pgerror.NewError(pgerror.CodeInvalidPassword, "password authentication failed for user \"%s\"", b.user)
+	}
+	return b.fatal(pgErr)
+}
+
+// ruleAuthenticator returns the authenticator for the method of the rule
+func (b *DataQueryBackend) ruleAuthenticator(rule *HBARule) (Authenticator, error) {
+	if rule.Method == AuthCert {
+		return NewCertAuthenticator(b.identMaps, rule.Options["map"])
+	}
+	return NewAuthenticator(rule.Method, b.users)
+}
+
+// remoteHost returns the address without the port
+func remoteHost(addr net.Addr) string {
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
+
+// handleSSLRequest accepts the request and performs the TLS handshake on
+// the existing connection when TLS is enabled, otherwise it is declined and
+// the client may continue in plaintext.
+func (b *DataQueryBackend) handleSSLRequest() error {
+	if b.isTLS() {
+		return b.fatal(pgerror.NewError(pgerror.CodeProtocolViolation, "SSLRequest received on an encrypted connection"))
+	}
+	if b.tlsConfig == nil || b.tlsMode == TLSDisable {
+		_, err := b.conn.Write([]byte("N"))
+		if err != nil {
+			return fmt.Errorf("error sending deny SSL request: %w", err)
+		}
+		return nil
+	}
+
+	_, err := b.conn.Write([]byte("S"))
+	if err != nil {
+		return fmt.Errorf("error sending accept SSL request: %w", err)
+	}
+	conn := tls.Server(b.conn, alpnConfig(b.tlsConfig))
+	if err := conn.Handshake(); err != nil {
+		return fmt.Errorf("tls handshake failed: %w", err)
+	}
+	b.upgradeTLS(conn)
+	return nil
+}
+
+// tlsHandshakeRecord is the first byte of a TLS ClientHello
+const tlsHandshakeRecord = 0x16
+
+// alpnProtocol is the ALPN protocol name of postgres
+const alpnProtocol = "postgresql"
+
+// handleDirectTLS performs the TLS handshake when the client starts it
+// right away instead of sending an SSLRequest, as postgres 17 clients do
+// with sslnegotiation=direct. These clients must negotiate the postgresql
+// ALPN protocol. The byte read to detect the handshake is returned when
+// the connection does not start with one. Reading it and the handshake are
+// bounded by the authentication timeout set by handleStartup.
+func (b *DataQueryBackend) handleDirectTLS() ([]byte, error) {
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(b.conn, first); err != nil {
+		return nil, fmt.Errorf("error receiving startup message: %w", err)
+	}
+	if first[0] != tlsHandshakeRecord {
+		return first, nil
+	}
+	if b.tlsConfig == nil || b.tlsMode == TLSDisable {
+		return nil, errors.New("direct tls connection received but tls is not enabled")
+	}
+
+	conn := tls.Server(&prefixConn{Conn: b.conn, prefix: first}, alpnConfig(b.tlsConfig))
+	if err := conn.Handshake(); err != nil {
+		return nil, fmt.Errorf("tls handshake failed: %w", err)
+	}
+	if conn.ConnectionState().NegotiatedProtocol != alpnProtocol {
+		conn.Close()
+		return nil, errors.New("direct tls connection without ALPN protocol negotiation")
+	}
+	b.upgradeTLS(conn)
+	return nil, nil
+}
+
+// alpnConfig returns the configuration offering the postgresql ALPN
+// protocol, clients which ask for another protocol are rejected
+func alpnConfig(cfg *tls.Config) *tls.Config {
+	for _, proto := range cfg.NextProtos {
+		if proto == alpnProtocol {
+			return cfg
+		}
+	}
+	cfg = cfg.Clone()
+	cfg.NextProtos = append(cfg.NextProtos, alpnProtocol)
+	return cfg
+}
+
+// prefixConn returns the bytes of prefix before reading from the conn
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixConn) Read(p []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(p, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// upgradeTLS replaces the connection with the encrypted one once the
+// handshake completed
+func (b *DataQueryBackend) upgradeTLS(conn *tls.Conn) {
+	log.Debug().Str("address", conn.RemoteAddr().String()).
+		Uint16("version", conn.ConnectionState().Version).
+		Msg("connection upgraded to tls")
+
+	b.mu.Lock()
+	b.conn = conn
+	b.mu.Unlock()
+	b.writeMu.Lock()
+	b.writer = bufio.NewWriterSize(conn, writeBufferSize)
+	b.writeMu.Unlock()
+	b.backend = pgproto3.NewBackend(pgproto3.NewChunkReader(conn), conn)
+}
+
+func (b *DataQueryBackend) isTLS() bool {
+	_, ok := b.conn.(*tls.Conn)
+	return ok
+}
+
+// fatal reports the error to the client with the FATAL severity, the
+// connection is expected to be closed afterwards.
+func (b *DataQueryBackend) fatal(pgErr *pgerror.Error) error {
+	pgErr.Severity = pgerror.SeverityFatal
+	_ = b.sendNow(errorResponse(pgErr))
+	return pgErr
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testTLSConfig(t *testing.T) *tls.Config {
+	c, err := GenerateCert(CertOptions{Hosts: []string{"localhost"}}, nil)
+	require.NoError(t, err)
+	cert := tls.Certificate{Certificate: [][]byte{c.Cert.Raw}, PrivateKey: c.Key}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}
+}
+
+// sslRequest sends an SSLRequest and returns the single byte response
+func sslRequest(t *testing.T, conn net.Conn) byte {
+	_, err := conn.Write((&pgproto3.SSLRequest{}).Encode(nil))
+	require.NoError(t, err)
+	resp := make([]byte, 1)
+	_, err = conn.Read(resp)
+	require.NoError(t, err)
+	return resp[0]
+}
+
+func TestStartup_SSLRequestUpgrade(t *testing.T) {
+	conn := dialBackend(t, echoHandler{}, func(b *DataQueryBackend) {
+		b.tlsConfig = testTLSConfig(t)
+		b.tlsMode = TLSRequire
+	})
+	require.Equal(t, byte('S'), sslRequest(t, conn))
+
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, tlsConn.Handshake())
+
+	f := startup(t, tlsConn, map[string]string{"user": "test"})
+	require.NoError(t, f.Send(&pgproto3.Query{String: "select 1"}))
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.RowDescription{}, msg)
+}
+
+func TestStartup_SSLRequestDeclined(t *testing.T) {
+	conn := dialBackend(t, echoHandler{}, func(b *DataQueryBackend) {
+		b.tlsConfig = testTLSConfig(t)
+		b.tlsMode = TLSDisable
+	})
+	require.Equal(t, byte('N'), sslRequest(t, conn))
+	startup(t, conn, map[string]string{"user": "test"})
+}
+
+func TestStartup_TLSRequired(t *testing.T) {
+	conn := dialBackend(t, echoHandler{}, func(b *DataQueryBackend) {
+		b.tlsConfig = testTLSConfig(t)
+		b.tlsMode = TLSRequire
+	})
+	f := pgproto3.NewFrontend(pgproto3.NewChunkReader(conn), conn)
+	require.NoError(t, f.Send(&pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters:      map[string]string{"user": "test"},
+	}))
+
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.ErrorResponse{}, msg)
+	assert.Equal(t, pgerror.SeverityFatal, msg.(*pgproto3.ErrorResponse).Severity)
+	assert.Equal(t, pgerror.CodeInvalidAuthorizationSpecification, msg.(*pgproto3.ErrorResponse).Code)
+}
+
+func TestStartup_GSSEncRequestDeclined(t *testing.T) {
+	conn := dialBackend(t, echoHandler{}, nil)
+	_, err := conn.Write((&pgproto3.GSSEncRequest{}).Encode(nil))
+	require.NoError(t, err)
+	resp := make([]byte, 1)
+	_, err = io.ReadFull(conn, resp)
+	require.NoError(t, err)
+	assert.Equal(t, byte('N'), resp[0])
+
+	startup(t, conn, map[string]string{"user": "test"})
+}
+
+// readNegotiateProtocolVersion reads the message which pgproto3 cannot
+// decode from the connection
+func readNegotiateProtocolVersion(t *testing.T, conn net.Conn) *negotiateProtocolVersion {
+	header := make([]byte, 5)
+	_, err := io.ReadFull(conn, header)
+	require.NoError(t, err)
+	require.Equal(t, byte('v'), header[0])
+	body := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+	_, err = io.ReadFull(conn, body)
+	require.NoError(t, err)
+	msg := &negotiateProtocolVersion{}
+	require.NoError(t, msg.Decode(body))
+	return msg
+}
+
+func TestStartup_NegotiateProtocolVersion(t *testing.T) {
+	conn := dialBackend(t, echoHandler{}, nil)
+	f := pgproto3.NewFrontend(pgproto3.NewChunkReader(conn), conn)
+	require.NoError(t, f.Send(&pgproto3.StartupMessage{
+		ProtocolVersion: 3<<16 | 2,
+		Parameters: map[string]string{
+			"user":               "test",
+			"_pq_.report_params": "x",
+			"_pq_.compression":   "on",
+		},
+	}))
+
+	msg := readNegotiateProtocolVersion(t, conn)
+	assert.Equal(t, &negotiateProtocolVersion{
+		NewestMinorProtocol: 0,
+		UnrecognizedOptions: []string{"_pq_.compression", "_pq_.report_params"},
+	}, msg)
+
+	// the session continues with protocol 3.0
+	resp, err := f.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.AuthenticationOk{}, resp)
+}
+
+func TestStartup_NoNegotiationForVersion30(t *testing.T) {
+	conn := dialBackend(t, echoHandler{}, nil)
+	// pgproto3 fails to receive an unexpected NegotiateProtocolVersion
+	f := startup(t, conn, map[string]string{"user": "test"})
+	require.NoError(t, f.Send(&pgproto3.Query{String: "select 1"}))
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.RowDescription{}, msg)
+}
+
+func TestStartup_UnsupportedMajorVersion(t *testing.T) {
+	conn := dialBackend(t, echoHandler{}, nil)
+	f := pgproto3.NewFrontend(pgproto3.NewChunkReader(conn), conn)
+	require.NoError(t, f.Send(&pgproto3.StartupMessage{
+		ProtocolVersion: 4 << 16,
+		Parameters:      map[string]string{"user": "test"},
+	}))
+
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	require.IsType(t, &pgproto3.ErrorResponse{}, msg)
+	assert.Equal(t, pgerror.SeverityFatal, msg.(*pgproto3.ErrorResponse).Severity)
+	assert.Equal(t, pgerror.CodeFeatureNotSupported, msg.(*pgproto3.ErrorResponse).Code)
+	assert.Equal(t, "unsupported frontend protocol 4.0: server supports 3.0 to 3.0", msg.(*pgproto3.ErrorResponse).Message)
+}
+
+func TestStartup_DirectTLS(t *testing.T) {
+	s, err := New(WithTLSConfig(testTLSConfig(t)), WithHandler(echoHandler{}))
+	require.NoError(t, err)
+	addr, _ := serveTest(t, s)
+	t.Cleanup(func() { _ = s.Shutdown(context.Background()) })
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"postgresql"}})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	assert.Equal(t, "postgresql", conn.ConnectionState().NegotiatedProtocol)
+
+	f := startup(t, conn, map[string]string{"user": "test"})
+	require.NoError(t, f.Send(&pgproto3.Query{String: "select 1"}))
+	msg, err := f.Receive()
+	require.NoError(t, err)
+	assert.IsType(t, &pgproto3.RowDescription{}, msg)
+
+	// plaintext clients are still served on the same port
+	dialServer(t, addr)
+}
+
+func TestStartup_DirectTLSRequiresALPN(t *testing.T) {
+	for name, protos := range map[string][]string{
+		"none":  nil,
+		"other": {"http/1.1"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			conn := dialBackend(t, echoHandler{}, func(b *DataQueryBackend) {
+				b.tlsConfig = testTLSConfig(t)
+			})
+			tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, NextProtos: protos})
+			err := tlsConn.Handshake()
+			if err == nil {
+				// the server closes the connection after the handshake
+				_, err = tlsConn.Read(make([]byte, 1))
+			}
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestStartup_DirectTLSDisabled(t *testing.T) {
+	conn := dialBackend(t, echoHandler{}, nil)
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"postgresql"}})
+	assert.Error(t, tlsConn.Handshake())
+}
+
+// expectClosed waits for the server to close the connection
+func expectClosed(t *testing.T, conn net.Conn) {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err := io.ReadAll(conn)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		t.Fatal("the connection was not closed")
+	}
+}
+
+func TestStartup_AuthenticationTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	configure := func(b *DataQueryBackend) { b.authTimeout = timeout }
+
+	t.Run("silent", func(t *testing.T) {
+		conn := dialBackend(t, echoHandler{}, configure)
+		expectClosed(t, conn)
+	})
+
+	t.Run("partial packet", func(t *testing.T) {
+		conn := dialBackend(t, echoHandler{}, configure)
+		startup := (&pgproto3.StartupMessage{
+			ProtocolVersion: pgproto3.ProtocolVersionNumber,
+			Parameters:      map[string]string{"user": "test"},
+		}).Encode(nil)
+		_, err := conn.Write(startup[:6])
+		require.NoError(t, err)
+		expectClosed(t, conn)
+	})
+
+	t.Run("direct tls handshake", func(t *testing.T) {
+		conn := dialBackend(t, echoHandler{}, func(b *DataQueryBackend) {
+			b.tlsConfig = testTLSConfig(t)
+			b.authTimeout = timeout
+		})
+		// the start of a ClientHello which is never completed
+		_, err := conn.Write([]byte{0x16, 0x03, 0x01})
+		require.NoError(t, err)
+		expectClosed(t, conn)
+	})
+
+	t.Run("ssl request handshake", func(t *testing.T) {
+		conn := dialBackend(t, echoHandler{}, func(b *DataQueryBackend) {
+			b.tlsConfig = testTLSConfig(t)
+			b.authTimeout = timeout
+		})
+		require.Equal(t, byte('S'), sslRequest(t, conn))
+		expectClosed(t, conn)
+	})
+
+	t.Run("cleared when ready", func(t *testing.T) {
+		conn := dialBackend(t, echoHandler{}, configure)
+		f := startup(t, conn, map[string]string{"user": "test"})
+		time.Sleep(2 * timeout)
+		require.NoError(t, f.Send(&pgproto3.Query{String: "select 1"}))
+		msg, err := f.Receive()
+		require.NoError(t, err)
+		assert.IsType(t, &pgproto3.RowDescription{}, msg)
+	})
+}
@@ -1,51 +1,237 @@
 package server
 
 import (
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"math/big"
 	"net"
+	"os"
+	"path/filepath"
 	"time"
+)
+
+// KeyAlgorithm selects the type of private key generated for a certificate
+type KeyAlgorithm string
 
-	"github.com/rs/zerolog/log"
+const (
+	KeyEd25519   KeyAlgorithm = "ed25519"
+	KeyECDSAP256 KeyAlgorithm = "ecdsa-p256"
+	KeyRSA       KeyAlgorithm = "rsa"
 )
 
-func GenKey() (ed25519.PublicKey, ed25519.PrivateKey) {
-	_, priv, err := ed25519.GenerateKey(rand.Reader)
+// rsaKeyBits is the size of generated RSA keys
+const rsaKeyBits = 2048
+
+// CertOptions control the certificates created by GenerateCA and GenerateCert
+type CertOptions struct {
+	// Hosts are the DNS names and IP addresses the certificate is valid for
+	Hosts []string
+	// ValidFor is the duration the certificate is valid for from now
+	ValidFor time.Duration
+	// Algorithm is the type of key generated, defaults to ed25519
+	Algorithm KeyAlgorithm
+	// Organization is set as the subject organization
+	Organization string
+	// CommonName is set as the subject common name, defaults to the first host
+	CommonName string
+	// ClientAuth makes the certificate valid for client authentication, the
+	// common name and SANs are matched against the user by the cert method
+	ClientAuth bool
+}
+
+// Certificate is a generated certificate along with its private key
+type Certificate struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// GenerateKey creates a new private key of the given algorithm
+func GenerateKey(alg KeyAlgorithm) (crypto.Signer, error) {
+	switch alg {
+	case KeyEd25519, "":
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	case KeyECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyRSA:
+		return rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		return nil, fmt.Errorf("unsupported key algorithm: %q", alg)
+	}
+}
+
+// GenerateCA creates a self-signed certificate authority which can be
+// passed to GenerateCert to issue server certificates.
+func GenerateCA(opts CertOptions) (*Certificate, error) {
+	template, err := newTemplate(opts)
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to generate private key")
+		return nil, err
 	}
-	keyUsage := x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign
-	notBefore := time.Now()
-	validFor := 365 * 24 * time.Hour
-	notAfter := notBefore.Add(validFor)
+	template.Subject.CommonName = "dsql ca"
+	template.IsCA = true
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	template.MaxPathLenZero = true
+	return createCertificate(template, opts.Algorithm, nil)
+}
+
+// GenerateCert creates a server certificate for the hosts in opts. It is
+// signed by ca, or self-signed when ca is nil in which case it can also be
+// used by clients as their root certificate.
+func GenerateCert(opts CertOptions, ca *Certificate) (*Certificate, error) {
+	if len(opts.Hosts) == 0 && opts.CommonName == "" {
+		return nil, errors.New("at least one host or a common name is required")
+	}
+	template, err := newTemplate(opts)
+	if err != nil {
+		return nil, err
+	}
+	template.Subject.CommonName = opts.CommonName
+	if template.Subject.CommonName == "" {
+		template.Subject.CommonName = opts.Hosts[0]
+	}
+	for _, h := range opts.Hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	if opts.Algorithm == KeyRSA {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	if opts.ClientAuth {
+		template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+	}
+	if ca == nil {
+		template.IsCA = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+	return createCertificate(template, opts.Algorithm, ca)
+}
+
+func newTemplate(opts CertOptions) (*x509.Certificate, error) {
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
 	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to generate serial number")
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	validFor := opts.ValidFor
+	if validFor <= 0 {
+		validFor = 365 * 24 * time.Hour
+	}
+	organization := opts.Organization
+	if organization == "" {
+		organization = "Development"
+	}
+	notBefore := time.Now()
+	return &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{Organization: []string{organization}},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(validFor),
+		BasicConstraintsValid: true,
+	}, nil
+}
+
+func createCertificate(template *x509.Certificate, alg KeyAlgorithm, ca *Certificate) (*Certificate, error) {
+	key, err := GenerateKey(alg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+	parent, signer := template, key
+	if ca != nil {
+		parent, signer = ca.Cert, ca.Key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
 	}
-	template := x509.Certificate{
-		SerialNumber: serialNumber,
-		Subject: pkix.Name{
-			Organization: []string{"Development"},
-		},
-		NotBefore: notBefore,
-		NotAfter:  notAfter,
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	return &Certificate{Cert: cert, Key: key}, nil
+}
 
-		IsCA: true,
+// CertPEM returns the PEM encoded certificate
+func (c *Certificate) CertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Cert.Raw})
+}
 
-		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
-		DNSNames:    []string{"localhost"},
+// KeyPEM returns the PEM encoded PKCS #8 private key
+func (c *Certificate) KeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(c.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
 
-		KeyUsage:              keyUsage,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		BasicConstraintsValid: true,
+// WriteFiles writes the PEM encoded certificate and key to disk, the key is
+// only readable by the owner. Existing files are not overwritten.
+func (c *Certificate) WriteFiles(certFile, keyFile string) error {
+	return c.writeFiles(certFile, keyFile, writeNewFile)
+}
+
+// ReplaceFiles writes the files like WriteFiles but replaces existing ones.
+// Each file is written to a temporary file which is renamed over the
+// original, so a failure leaves the previous file in place.
+func (c *Certificate) ReplaceFiles(certFile, keyFile string) error {
+	return c.writeFiles(certFile, keyFile, replaceFile)
+}
+
+func (c *Certificate) writeFiles(certFile, keyFile string, write func(string, []byte, os.FileMode) error) error {
+	keyPEM, err := c.KeyPEM()
+	if err != nil {
+		return err
+	}
+	if err := write(certFile, c.CertPEM(), 0644); err != nil {
+		return err
+	}
+	return write(keyFile, keyPEM, 0600)
+}
+
+func writeNewFile(name string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func replaceFile(name string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	err = f.Chmod(perm)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
 	}
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to create certificate")
+		os.Remove(tmp)
 	}
-	return ed25519.PublicKey(derBytes), priv
+	return err
 }
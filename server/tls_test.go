@@ -0,0 +1,82 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateCert_Algorithms(t *testing.T) {
+	for _, alg := range []KeyAlgorithm{KeyEd25519, KeyECDSAP256, KeyRSA} {
+		t.Run(string(alg), func(t *testing.T) {
+			c, err := GenerateCert(CertOptions{
+				Hosts:     []string{"db.example.com", "10.0.0.1"},
+				ValidFor:  time.Hour,
+				Algorithm: alg,
+			}, nil)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"db.example.com"}, c.Cert.DNSNames)
+			assert.Len(t, c.Cert.IPAddresses, 1)
+			assert.WithinDuration(t, time.Now().Add(time.Hour), c.Cert.NotAfter, time.Minute)
+
+			keyPEM, err := c.KeyPEM()
+			require.NoError(t, err)
+			_, err = tls.X509KeyPair(c.CertPEM(), keyPEM)
+			assert.NoError(t, err)
+		})
+	}
+
+	_, err := GenerateKey("dsa")
+	assert.Error(t, err)
+}
+
+func TestGenerateCert_SignedByCA(t *testing.T) {
+	opts := CertOptions{Hosts: []string{"localhost"}, Algorithm: KeyECDSAP256}
+	ca, err := GenerateCA(opts)
+	require.NoError(t, err)
+	c, err := GenerateCert(opts, ca)
+	require.NoError(t, err)
+	assert.False(t, c.Cert.IsCA)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	_, err = c.Cert.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: roots})
+	assert.NoError(t, err)
+}
+
+func TestCertificate_WriteFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server.key")
+
+	c, err := GenerateCert(CertOptions{Hosts: []string{"localhost"}}, nil)
+	require.NoError(t, err)
+	require.NoError(t, c.WriteFiles(certFile, keyFile))
+
+	_, err = tls.LoadX509KeyPair(certFile, keyFile)
+	assert.NoError(t, err)
+
+	// existing files are never overwritten
+	assert.Error(t, c.WriteFiles(certFile, keyFile))
+
+	other, err := GenerateCert(CertOptions{Hosts: []string{"localhost"}}, nil)
+	require.NoError(t, err)
+	require.NoError(t, other.ReplaceFiles(certFile, keyFile))
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, other.Cert.Raw, pair.Certificate[0])
+	info, err := os.Stat(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtendedQuery_BinaryFormats(t *testing.T) {
+	var args []interface{}
+	handler := funcHandler(func(ctx context.Context, query string, a []interface{}) (*session.Result, error) {
+		args = a
+		columns := []pgtype.Column{pgtype.NewColumn("n", pgtype.Int8OID), pgtype.NewColumn("t", pgtype.TextOID), pgtype.NewColumn("b", pgtype.BoolOID)}
+		return session.NewResult(columns, [][]interface{}{{int64(a[0].(int32)) * 2, a[1], true}}), nil
+	})
+	f := connectBackend(t, handler)
+
+	param := make([]byte, 4)
+	binary.BigEndian.PutUint32(param, 21)
+	require.NoError(t, f.Send(&pgproto3.Parse{Query: "select $1, $2", ParameterOIDs: []uint32{pgtype.Int4OID}}))
+	require.NoError(t, f.Send(&pgproto3.Bind{
+		ParameterFormatCodes: []int16{1, 0},
+		Parameters:           [][]byte{param, []byte("x")},
+		ResultFormatCodes:    []int16{1, 0, 1},
+	}))
+	require.NoError(t, f.Send(&pgproto3.Execute{}))
+	require.NoError(t, f.Send(&pgproto3.Sync{}))
+
+	msgs := receiveUntilReady(t, f)
+	require.Len(t, msgs, 4)
+	require.IsType(t, &pgproto3.DataRow{}, msgs[2])
+	row := msgs[2].(*pgproto3.DataRow).Values
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 42}, row[0])
+	assert.Equal(t, []byte("x"), row[1])
+	assert.Equal(t, []byte{1}, row[2])
+	assert.Equal(t, []interface{}{int32(21), "x"}, args)
+}
+
+func TestExtendedQuery_InvalidParameters(t *testing.T) {
+	f := connectBackend(t, echoHandler{})
+
+	require.NoError(t, f.Send(&pgproto3.Parse{Query: "select $1", ParameterOIDs: []uint32{pgtype.Int4OID}}))
+	require.NoError(t, f.Send(&pgproto3.Bind{ParameterFormatCodes: []int16{1}, Parameters: [][]byte{{1}}}))
+	require.NoError(t, f.Send(&pgproto3.Sync{}))
+	msgs := receiveUntilReady(t, f)
+	require.IsType(t, &pgproto3.ErrorResponse{}, msgs[1])
+	assert.Equal(t, pgerror.CodeInvalidBinaryRepresentation, msgs[1].(*pgproto3.ErrorResponse).Code)
+	assert.Equal(t, "incorrect binary data format in bind parameter 1", msgs[1].(*pgproto3.ErrorResponse).Message)
+
+	require.NoError(t, f.Send(&pgproto3.Bind{Parameters: [][]byte{[]byte("one")}}))
+	require.NoError(t, f.Send(&pgproto3.Sync{}))
+	msgs = receiveUntilReady(t, f)
+	require.IsType(t, &pgproto3.ErrorResponse{}, msgs[0])
+	assert.Equal(t, pgerror.CodeInvalidTextRepresentation, msgs[0].(*pgproto3.ErrorResponse).Code)
+
+	require.NoError(t, f.Send(&pgproto3.Bind{Parameters: [][]byte{[]byte("1")}, ResultFormatCodes: []int16{2}}))
+	require.NoError(t, f.Send(&pgproto3.Sync{}))
+	msgs = receiveUntilReady(t, f)
+	require.IsType(t, &pgproto3.ErrorResponse{}, msgs[0])
+	assert.Equal(t, pgerror.CodeProtocolViolation, msgs[0].(*pgproto3.ErrorResponse).Code)
+}
@@ -0,0 +1,189 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package catalog holds the schemas and tables of the engine and stores
+// their rows in memory.
+package catalog
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+)
+
+// DefaultSchema is created with every catalog, it is the schema of tables
+// created without naming one
+const DefaultSchema = "public"
+
+// firstOID is the first OID assigned to objects, lower ones are reserved
+// for built in objects like in postgres
+const firstOID = 16384
+
+// Catalog holds the schemas and their tables. It is safe for concurrent
+// use.
+type Catalog struct {
+	// mu guards the schemas and their tables, the rows of a table are
+	// guarded by the table itself
+	mu      sync.RWMutex
+	schemas map[string]*Schema
+	nextOID uint32
+}
+
+// Schema is a namespace of tables
+type Schema struct {
+	Name   string
+	OID    uint32
+	tables map[string]*Table
+}
+
+// New returns a catalog holding the default schema
+func New() *Catalog {
+	c := &Catalog{schemas: make(map[string]*Schema), nextOID: firstOID}
+	_ = c.CreateSchema(DefaultSchema)
+	return c
+}
+
+// oid returns the next object identifier, c.mu must be held
+func (c *Catalog) oid() uint32 {
+	oid := c.nextOID
+	c.nextOID++
+	return oid
+}
+
+// Schema returns the schema with the name
+func (c *Catalog) Schema(name string) (*Schema, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s, ok := c.schemas[name]
+	return s, ok
+}
+
+// CreateSchema adds an empty schema
+func (c *Catalog) CreateSchema(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.schemas[name]; ok {
+		return pgerror.NewError(pgerror.CodeDuplicateSchema, "schema \"%s\" already exists", name)
+	}
+	c.schemas[name] = &Schema{Name: name, OID: c.oid(), tables: make(map[string]*Table)}
+	return nil
+}
+
+// DropSchema removes the schema, a schema holding tables is only removed
+// with cascade which removes the tables too.
+func (c *Catalog) DropSchema(name string, cascade bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.schemas[name]
+	if !ok {
+		return errUndefinedSchema(name)
+	}
+	if len(s.tables) > 0 && !cascade {
+		err := pgerror.NewError(pgerror.CodeDependentObjectsStillExist, "cannot drop schema %s because other objects depend on it", name)
+		for i, t := range s.sortedTables() {
+			if i > 0 {
+				err.Detail += "\n"
+			}
+			err.Detail += "table " + t.QualifiedName() + " depends on schema " + name
+		}
+		err.Hint = "Use DROP ... CASCADE to drop the dependent objects too."
+		return err
+	}
+	delete(c.schemas, name)
+	return nil
+}
+
+// sortedTables returns the tables of the schema ordered by name
+func (s *Schema) sortedTables() []*Table {
+	tables := make([]*Table, 0, len(s.tables))
+	for _, t := range s.tables {
+		tables = append(tables, t)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	return tables
+}
+
+// Tables returns the tables of the schema ordered by name
+func (c *Catalog) Tables(schema string) []*Table {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s, ok := c.schemas[schema]
+	if !ok {
+		return nil
+	}
+	return s.sortedTables()
+}
+
+// Table returns the table of the schema with the name
+func (c *Catalog) Table(schema, name string) (*Table, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s, ok := c.schemas[schema]
+	if !ok {
+		return nil, false
+	}
+	t, ok := s.tables[name]
+	return t, ok
+}
+
+// CreateTable adds an empty table to the schema. The constraints refer to
+// the columns by their position, constraints without a name are named
+// after the table and their columns like in postgres.
+func (c *Catalog) CreateTable(schema, name string, columns []*Column, constraints []*Constraint) (*Table, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.schemas[schema]
+	if !ok {
+		return nil, errUndefinedSchema(schema)
+	}
+	if _, ok := s.tables[name]; ok {
+		return nil, pgerror.NewError(pgerror.CodeDuplicateTable, "relation \"%s\" already exists", name)
+	}
+	t, err := newTable(schema, name, columns, constraints)
+	if err != nil {
+		return nil, err
+	}
+	t.OID = c.oid()
+	s.tables[name] = t
+	return t, nil
+}
+
+// DropTable removes the table and its rows
+func (c *Catalog) DropTable(schema, name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.schemas[schema]
+	if !ok {
+		return errUndefinedSchema(schema)
+	}
+	if _, ok := s.tables[name]; !ok {
+		return ErrUndefinedTable(name)
+	}
+	delete(s.tables, name)
+	return nil
+}
+
+// errUndefinedSchema is returned for a schema which does not exist
+func errUndefinedSchema(name string) error {
+	return pgerror.NewError(pgerror.CodeInvalidSchemaName, "schema \"%s\" does not exist", name)
+}
+
+// ErrUndefinedTable is returned for a table which does not exist
+func ErrUndefinedTable(name string) *pgerror.Error {
+	return pgerror.NewError(pgerror.CodeUndefinedTable, "table \"%s\" does not exist", name)
+}
@@ -0,0 +1,80 @@
+package catalog
+
+import (
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemas(t *testing.T) {
+	c := New()
+	_, ok := c.Schema(DefaultSchema)
+	assert.True(t, ok)
+
+	require.NoError(t, c.CreateSchema("s"))
+	assert.EqualError(t, c.CreateSchema("s"), `ERROR: schema "s" already exists (SQLSTATE 42P06)`)
+
+	_, err := c.CreateTable("s", "b", []*Column{{Name: "a", Type: types.Int4}}, nil)
+	require.NoError(t, err)
+	_, err = c.CreateTable("s", "a", []*Column{{Name: "a", Type: types.Int4}}, nil)
+	require.NoError(t, err)
+
+	err = c.DropSchema("s", false)
+	assert.Equal(t, &pgerror.Error{
+		Severity: pgerror.SeverityError,
+		Code:     pgerror.CodeDependentObjectsStillExist,
+		Message:  "cannot drop schema s because other objects depend on it",
+		Detail:   "table s.a depends on schema s\ntable s.b depends on schema s",
+		Hint:     "Use DROP ... CASCADE to drop the dependent objects too.",
+	}, err)
+
+	require.NoError(t, c.DropSchema("s", true))
+	_, ok = c.Table("s", "a")
+	assert.False(t, ok)
+	assert.EqualError(t, c.DropSchema("s", false), `ERROR: schema "s" does not exist (SQLSTATE 3F000)`)
+}
+
+func TestTables(t *testing.T) {
+	c := New()
+	columns := []*Column{{Name: "a", Type: types.Int4}, {Name: "b", Type: types.Text}}
+	table, err := c.CreateTable(DefaultSchema, "t", columns, []*Constraint{
+		{Type: PrimaryKey, Columns: []int{0}},
+		{Type: Unique, Columns: []int{1}},
+		{Type: Unique, Columns: []int{0, 1}},
+		{Type: Unique, Columns: []int{1}},
+	})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, table.OID, uint32(firstOID))
+	assert.Equal(t, "public.t", table.QualifiedName())
+	assert.True(t, columns[0].NotNull, "primary key columns are not null")
+
+	var names []string
+	for _, c := range table.Constraints {
+		names = append(names, c.Name)
+	}
+	assert.Equal(t, []string{"t_pkey", "t_b_key", "t_a_b_key", "t_b_key1"}, names)
+	assert.Same(t, table.Constraints[0], table.PrimaryKey())
+
+	found, ok := c.Table(DefaultSchema, "t")
+	assert.True(t, ok)
+	assert.Same(t, table, found)
+	i, ok := table.Column("b")
+	assert.True(t, ok)
+	assert.Equal(t, 1, i)
+	assert.Equal(t, []*Table{table}, c.Tables(DefaultSchema))
+
+	_, err = c.CreateTable(DefaultSchema, "t", columns, nil)
+	assert.EqualError(t, err, `ERROR: relation "t" already exists (SQLSTATE 42P07)`)
+	_, err = c.CreateTable("missing", "t", columns, nil)
+	assert.EqualError(t, err, `ERROR: schema "missing" does not exist (SQLSTATE 3F000)`)
+	_, err = c.CreateTable(DefaultSchema, "u", []*Column{{Name: "a"}, {Name: "a"}}, nil)
+	assert.EqualError(t, err, `ERROR: column "a" specified more than once (SQLSTATE 42701)`)
+	_, err = c.CreateTable(DefaultSchema, "u", columns, []*Constraint{{Type: PrimaryKey, Columns: []int{0}}, {Type: PrimaryKey, Columns: []int{1}}})
+	assert.EqualError(t, err, `ERROR: multiple primary keys for table "u" are not allowed (SQLSTATE 42P16)`)
+
+	require.NoError(t, c.DropTable(DefaultSchema, "t"))
+	assert.EqualError(t, c.DropTable(DefaultSchema, "t"), `ERROR: table "t" does not exist (SQLSTATE 42P01)`)
+}
@@ -0,0 +1,409 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package catalog
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// Row holds one value per column of a table, NULL is nil
+type Row []interface{}
+
+// Column is a column of a table
+type Column struct {
+	Name    string
+	Type    types.Type
+	NotNull bool
+	// Default is the expression of the DEFAULT clause, nil when the
+	// column defaults to NULL
+	Default parser.Expr
+	// Sequence generates the default values of serial columns
+	Sequence *Sequence
+}
+
+// ConstraintType is the kind of a table constraint
+type ConstraintType int
+
+const (
+	PrimaryKey ConstraintType = iota
+	Unique
+)
+
+// Constraint is a primary key or unique constraint of a table
+type Constraint struct {
+	Name string
+	Type ConstraintType
+	// Columns are the positions of the key columns in the table
+	Columns []int
+}
+
+// Sequence generates the values of a serial column
+type Sequence struct {
+	Name string
+	mu   sync.Mutex
+	last int64
+	max  int64
+}
+
+// Next returns the next value of the sequence starting at 1
+func (s *Sequence) Next() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last >= s.max {
+		return 0, pgerror.NewError(pgerror.CodeSequenceGeneratorLimitExceeded, "nextval: reached maximum value of sequence \"%s\" (%d)", s.Name, s.max)
+	}
+	s.last++
+	return s.last, nil
+}
+
+// Table is a table whose rows are held in memory. Readers get the rows
+// as of the last committed change, changes are made in a Tx.
+type Table struct {
+	OID         uint32
+	Schema      string
+	Name        string
+	Columns     []*Column
+	Constraints []*Constraint
+
+	// mu is held by the open Tx, changes to the table are serialized
+	mu sync.Mutex
+	// rows holds the committed []Row, the slice is never modified in place
+	// so readers can keep using the rows they got while it is changed
+	rows atomic.Value
+	// keys holds the keys of the rows for each constraint, they are only
+	// used with mu held
+	keys []map[string]struct{}
+}
+
+func newTable(schema, name string, columns []*Column, constraints []*Constraint) (*Table, error) {
+	t := &Table{Schema: schema, Name: name, Columns: columns}
+	t.rows.Store([]Row(nil))
+	seen := make(map[string]bool)
+	for _, col := range columns {
+		if seen[col.Name] {
+			return nil, pgerror.NewError(pgerror.CodeDuplicateColumn, "column \"%s\" specified more than once", col.Name)
+		}
+		seen[col.Name] = true
+		if col.Sequence != nil {
+			col.Sequence.Name = uniqueName(seen, name, []string{col.Name}, "seq")
+			col.Sequence.max = maxInt(col.Type)
+		}
+	}
+
+	for _, c := range constraints {
+		if c.Type == PrimaryKey {
+			if t.PrimaryKey() != nil {
+				return nil, pgerror.NewError(pgerror.CodeInvalidTableDefinition, "multiple primary keys for table \"%s\" are not allowed", name)
+			}
+			for _, i := range c.Columns {
+				columns[i].NotNull = true
+			}
+		}
+		if c.Name == "" {
+			names := make([]string, len(c.Columns))
+			for i, col := range c.Columns {
+				names[i] = columns[col].Name
+			}
+			if c.Type == PrimaryKey {
+				c.Name = uniqueName(seen, name, nil, "pkey")
+			} else {
+				c.Name = uniqueName(seen, name, names, "key")
+			}
+		} else if seen[c.Name] {
+			return nil, pgerror.NewError(pgerror.CodeDuplicateTable, "relation \"%s\" already exists", c.Name)
+		}
+		seen[c.Name] = true
+		t.Constraints = append(t.Constraints, c)
+		t.keys = append(t.keys, make(map[string]struct{}))
+	}
+	return t, nil
+}
+
+// uniqueName returns the name of an object belonging to the table such as
+// t_a_key, a number is appended when the name is taken
+func uniqueName(taken map[string]bool, table string, columns []string, suffix string) string {
+	base := strings.Join(append(append([]string{table}, columns...), suffix), "_")
+	name := base
+	for i := 1; taken[name]; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	taken[name] = true
+	return name
+}
+
+// maxInt returns the largest value of an integer type
+func maxInt(t types.Type) int64 {
+	switch t.OID {
+	case pgtype.Int2OID:
+		return math.MaxInt16
+	case pgtype.Int4OID:
+		return math.MaxInt32
+	}
+	return math.MaxInt64
+}
+
+// QualifiedName returns the name of the table prefixed with its schema
+func (t *Table) QualifiedName() string {
+	return t.Schema + "." + t.Name
+}
+
+// Column returns the position of the column with the name
+func (t *Table) Column(name string) (int, bool) {
+	for i, c := range t.Columns {
+		if c.Name == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// PrimaryKey returns the primary key constraint, nil when there is none
+func (t *Table) PrimaryKey() *Constraint {
+	for _, c := range t.Constraints {
+		if c.Type == PrimaryKey {
+			return c
+		}
+	}
+	return nil
+}
+
+// Rows returns the committed rows, the slice must not be modified
+func (t *Table) Rows() []Row {
+	return t.rows.Load().([]Row)
+}
+
+// key returns the key of the row for the constraint, ok is false when a
+// key column is NULL as such rows never conflict
+func (t *Table) key(c *Constraint, row Row) (string, bool) {
+	var buf []byte
+	for _, i := range c.Columns {
+		if row[i] == nil {
+			return "", false
+		}
+		buf = types.AppendKey(buf, t.Columns[i].Type, row[i])
+	}
+	return string(buf), true
+}
+
+// errUniqueViolation reports the key of the row which already exists
+func (t *Table) errUniqueViolation(c *Constraint, row Row) error {
+	names := make([]string, len(c.Columns))
+	values := make([]string, len(c.Columns))
+	for i, col := range c.Columns {
+		names[i] = t.Columns[col].Name
+		values[i] = t.text(col, row[col])
+	}
+	err := pgerror.NewError(pgerror.CodeUniqueViolation, "duplicate key value violates unique constraint \"%s\"", c.Name)
+	err.Detail = fmt.Sprintf("Key (%s)=(%s) already exists.", strings.Join(names, ", "), strings.Join(values, ", "))
+	err.SchemaName = t.Schema
+	err.TableName = t.Name
+	err.ConstraintName = c.Name
+	return err
+}
+
+// checkNotNull returns an error for a NULL in a NOT NULL column
+func (t *Table) checkNotNull(row Row) error {
+	for i, col := range t.Columns {
+		if row[i] != nil || !col.NotNull {
+			continue
+		}
+		values := make([]string, len(row))
+		for j := range row {
+			values[j] = t.text(j, row[j])
+		}
+		err := pgerror.NewError(pgerror.CodeNotNullViolation, "null value in column \"%s\" of relation \"%s\" violates not-null constraint", col.Name, t.Name)
+		err.Detail = fmt.Sprintf("Failing row contains (%s).", strings.Join(values, ", "))
+		err.SchemaName = t.Schema
+		err.TableName = t.Name
+		err.ColumnName = col.Name
+		return err
+	}
+	return nil
+}
+
+// text returns the value of column i as shown in error details
+func (t *Table) text(i int, v interface{}) string {
+	if v == nil {
+		return "null"
+	}
+	s, err := types.Cast(v, t.Columns[i].Type, types.Text)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return s.(string)
+}
+
+// Tx collects the changes a statement makes to a table, they become
+// visible to readers when it is committed. Only one Tx of a table is open
+// at a time.
+type Tx struct {
+	table *Table
+	done  bool
+	// rows are the committed rows when the Tx began
+	rows    []Row
+	inserts []Row
+	updates map[int]Row
+	deletes map[int]bool
+	// added holds the keys of the inserted rows for each constraint
+	added []map[string]struct{}
+}
+
+// Begin starts changing the table, it waits for the open Tx to finish.
+// The Tx must be committed or rolled back.
+func (t *Table) Begin() *Tx {
+	t.mu.Lock()
+	tx := &Tx{
+		table:   t,
+		rows:    t.Rows(),
+		updates: make(map[int]Row),
+		deletes: make(map[int]bool),
+		added:   make([]map[string]struct{}, len(t.Constraints)),
+	}
+	for i := range tx.added {
+		tx.added[i] = make(map[string]struct{})
+	}
+	return tx
+}
+
+// Rows returns the committed rows when the Tx began, the positions in the
+// slice identify the rows to Update and Delete.
+func (tx *Tx) Rows() []Row {
+	return tx.rows
+}
+
+// Conflict returns the constraint the row would violate when inserted,
+// nil when there is none
+func (tx *Tx) Conflict(row Row) *Constraint {
+	t := tx.table
+	for i, c := range t.Constraints {
+		key, ok := t.key(c, row)
+		if !ok {
+			continue
+		}
+		if _, ok := t.keys[i][key]; ok {
+			return c
+		}
+		if _, ok := tx.added[i][key]; ok {
+			return c
+		}
+	}
+	return nil
+}
+
+// Insert adds a row, it fails when the row violates a constraint
+func (tx *Tx) Insert(row Row) error {
+	t := tx.table
+	if err := t.checkNotNull(row); err != nil {
+		return err
+	}
+	if c := tx.Conflict(row); c != nil {
+		return t.errUniqueViolation(c, row)
+	}
+	for i, c := range t.Constraints {
+		if key, ok := t.key(c, row); ok {
+			tx.added[i][key] = struct{}{}
+		}
+	}
+	tx.inserts = append(tx.inserts, row)
+	return nil
+}
+
+// Update replaces the row at position i of Rows. Unique constraints are
+// checked on Commit so that keys can be swapped between rows.
+func (tx *Tx) Update(i int, row Row) error {
+	if err := tx.table.checkNotNull(row); err != nil {
+		return err
+	}
+	tx.updates[i] = row
+	return nil
+}
+
+// Delete removes the row at position i of Rows
+func (tx *Tx) Delete(i int) {
+	tx.deletes[i] = true
+}
+
+// Commit makes the changes visible, when they violate a constraint the
+// table is left unchanged.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return nil
+	}
+	defer tx.Rollback()
+	t := tx.table
+
+	if len(tx.updates) == 0 && len(tx.deletes) == 0 {
+		// the keys of the inserted rows were checked already, appending
+		// to the committed slice is safe as readers stop at its length
+		for i, keys := range tx.added {
+			for key := range keys {
+				t.keys[i][key] = struct{}{}
+			}
+		}
+		t.rows.Store(append(tx.rows, tx.inserts...))
+		return nil
+	}
+
+	rows := make([]Row, 0, len(tx.rows)-len(tx.deletes)+len(tx.inserts))
+	for i, row := range tx.rows {
+		if tx.deletes[i] {
+			continue
+		}
+		if updated, ok := tx.updates[i]; ok {
+			row = updated
+		}
+		rows = append(rows, row)
+	}
+	rows = append(rows, tx.inserts...)
+
+	keys := make([]map[string]struct{}, len(t.Constraints))
+	for i, c := range t.Constraints {
+		keys[i] = make(map[string]struct{}, len(rows))
+		for _, row := range rows {
+			key, ok := t.key(c, row)
+			if !ok {
+				continue
+			}
+			if _, ok := keys[i][key]; ok {
+				return t.errUniqueViolation(c, row)
+			}
+			keys[i][key] = struct{}{}
+		}
+	}
+	t.keys = keys
+	t.rows.Store(rows)
+	return nil
+}
+
+// Rollback discards the changes, it does nothing once the Tx is committed
+func (tx *Tx) Rollback() {
+	if tx.done {
+		return
+	}
+	tx.done = true
+	tx.table.mu.Unlock()
+}
@@ -0,0 +1,121 @@
+package catalog
+
+import (
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestTable returns a table with an integer primary key and a unique
+// text column
+func newTestTable(t *testing.T) *Table {
+	columns := []*Column{{Name: "id", Type: types.Int4}, {Name: "name", Type: types.Text}}
+	table, err := New().CreateTable(DefaultSchema, "t", columns, []*Constraint{
+		{Type: PrimaryKey, Columns: []int{0}},
+		{Type: Unique, Columns: []int{1}},
+	})
+	require.NoError(t, err)
+	return table
+}
+
+func TestTxInsert(t *testing.T) {
+	table := newTestTable(t)
+
+	tx := table.Begin()
+	require.NoError(t, tx.Insert(Row{int64(1), "a"}))
+	require.NoError(t, tx.Insert(Row{int64(2), nil}))
+	require.NoError(t, tx.Insert(Row{int64(3), nil}), "NULLs do not conflict")
+	assert.Empty(t, table.Rows(), "changes are not visible before commit")
+	require.NoError(t, tx.Commit())
+	assert.Len(t, table.Rows(), 3)
+
+	snapshot := table.Rows()
+	tx = table.Begin()
+	err := tx.Insert(Row{int64(4), "a"})
+	assert.Equal(t, &pgerror.Error{
+		Severity:       pgerror.SeverityError,
+		Code:           pgerror.CodeUniqueViolation,
+		Message:        `duplicate key value violates unique constraint "t_name_key"`,
+		Detail:         "Key (name)=(a) already exists.",
+		SchemaName:     "public",
+		TableName:      "t",
+		ConstraintName: "t_name_key",
+	}, err)
+	assert.Same(t, table.Constraints[0], tx.Conflict(Row{int64(1), "b"}))
+	assert.Nil(t, tx.Conflict(Row{int64(5), "b"}))
+
+	err = tx.Insert(Row{nil, "b"})
+	assert.Equal(t, &pgerror.Error{
+		Severity:   pgerror.SeverityError,
+		Code:       pgerror.CodeNotNullViolation,
+		Message:    `null value in column "id" of relation "t" violates not-null constraint`,
+		Detail:     "Failing row contains (null, b).",
+		SchemaName: "public",
+		TableName:  "t",
+		ColumnName: "id",
+	}, err)
+
+	require.NoError(t, tx.Insert(Row{int64(5), "b"}))
+	assert.Error(t, tx.Insert(Row{int64(5), "c"}), "keys of the same Tx conflict")
+	tx.Rollback()
+	assert.Equal(t, snapshot, table.Rows())
+
+	tx = table.Begin()
+	assert.Nil(t, tx.Conflict(Row{int64(5), "b"}), "keys of a rolled back Tx are discarded")
+	require.NoError(t, tx.Insert(Row{int64(5), "b"}))
+	require.NoError(t, tx.Commit())
+	tx.Rollback()
+	assert.Len(t, table.Rows(), 4)
+	assert.Len(t, snapshot, 3, "readers keep the rows they got")
+}
+
+func TestTxUpdateDelete(t *testing.T) {
+	table := newTestTable(t)
+	tx := table.Begin()
+	for i, name := range []string{"a", "b", "c"} {
+		require.NoError(t, tx.Insert(Row{int64(i + 1), name}))
+	}
+	require.NoError(t, tx.Commit())
+	snapshot := table.Rows()
+
+	// swapping keys between rows is fine as uniqueness is checked on commit
+	tx = table.Begin()
+	require.NoError(t, tx.Update(0, Row{int64(1), "b"}))
+	require.NoError(t, tx.Update(1, Row{int64(2), "a"}))
+	tx.Delete(2)
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, []Row{{int64(1), "b"}, {int64(2), "a"}}, table.Rows())
+	assert.Equal(t, Row{int64(1), "a"}, snapshot[0], "rows are replaced rather than modified")
+
+	tx = table.Begin()
+	require.NoError(t, tx.Update(1, Row{int64(1), "c"}))
+	err := tx.Commit()
+	assert.EqualError(t, err, `ERROR: duplicate key value violates unique constraint "t_pkey" (SQLSTATE 23505)`)
+	assert.Equal(t, []Row{{int64(1), "b"}, {int64(2), "a"}}, table.Rows())
+
+	tx = table.Begin()
+	assert.Error(t, tx.Update(0, Row{nil, "b"}))
+	require.NoError(t, tx.Insert(Row{int64(3), "c"}), "the keys of the failed commit are gone")
+	require.NoError(t, tx.Commit())
+}
+
+func TestSequence(t *testing.T) {
+	columns := []*Column{{Name: "id", Type: types.Int2, Sequence: &Sequence{}}}
+	table, err := New().CreateTable(DefaultSchema, "t", columns, nil)
+	require.NoError(t, err)
+	seq := table.Columns[0].Sequence
+	assert.Equal(t, "t_id_seq", seq.Name)
+
+	n, err := seq.Next()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	seq.last = 32766
+	n, err = seq.Next()
+	require.NoError(t, err)
+	assert.Equal(t, int64(32767), n)
+	_, err = seq.Next()
+	assert.EqualError(t, err, `ERROR: nextval: reached maximum value of sequence "t_id_seq" (32767) (SQLSTATE 2200H)`)
+}
@@ -0,0 +1,178 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package engine
+
+import (
+	"context"
+	"errors"
+
+	"github.com/patrickglass/dsql/sql/catalog"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/session"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// CopyFrom inserts the rows of COPY ... FROM STDIN into the table, the
+// columns which are not listed get their default. The rows are inserted
+// in a single Tx so that none are stored when one of them fails.
+func (e *Engine) CopyFrom(ctx context.Context, stmt *parser.CopyStmt, rows session.Rows) (int64, error) {
+	x := e.newExecution(ctx, "", []interface{}{})
+	table, err := x.Table(stmt.Table)
+	if err != nil {
+		return 0, withoutPosition(err)
+	}
+	targets, err := copyColumns(table, stmt.Columns)
+	if err != nil {
+		return 0, err
+	}
+	d, err := x.newDML(table, "", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	tx := table.Begin()
+	defer tx.Rollback()
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		row, err := copyRow(x, &d, targets, rows.Values())
+		if err != nil {
+			return 0, err
+		}
+		if err := tx.Insert(row); err != nil {
+			return 0, err
+		}
+		d.count++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return d.count, nil
+}
+
+// copyRow converts the values of a row read by COPY to the types of the
+// target columns and fills in the defaults of the others. The values are
+// strings in the text and csv formats and []byte in the binary format.
+func copyRow(x *execution, d *dml, targets []int, values []interface{}) (catalog.Row, error) {
+	columns := d.table.Columns
+	if len(values) > len(targets) {
+		return nil, pgerror.NewError(pgerror.CodeBadCopyFileFormat, "extra data after last expected column")
+	}
+	if len(values) < len(targets) {
+		return nil, pgerror.NewError(pgerror.CodeBadCopyFileFormat, "missing data for column \"%s\"", columns[targets[len(values)]].Name)
+	}
+	row := make(catalog.Row, len(columns))
+	set := make([]bool, len(row))
+	for i, v := range values {
+		col := columns[targets[i]]
+		var err error
+		switch v := v.(type) {
+		case string:
+			row[targets[i]], err = types.Assign(v, types.Text, col.Type)
+		case []byte:
+			var decoded interface{}
+			if decoded, err = pgtype.DefaultTypes.Decode(col.Type.OID, v, 1); err == nil {
+				row[targets[i]], err = types.Assign(types.Normalize(decoded), col.Type, col.Type)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		set[targets[i]] = true
+	}
+	for i := range row {
+		if set[i] {
+			continue
+		}
+		v, err := d.defaultValue(x.env, i)
+		if err != nil {
+			return nil, err
+		}
+		row[i] = v
+	}
+	return row, nil
+}
+
+// CopyTo returns the rows of COPY ... TO STDOUT, the rows of a table are
+// read like SELECT does
+func (e *Engine) CopyTo(ctx context.Context, stmt *parser.CopyStmt) (*session.Result, error) {
+	x := e.newExecution(ctx, "", []interface{}{})
+	query := stmt.Query
+	if query == nil {
+		table, err := x.Table(stmt.Table)
+		if err != nil {
+			return nil, withoutPosition(err)
+		}
+		if _, err := copyColumns(table, stmt.Columns); err != nil {
+			return nil, err
+		}
+		query = &parser.SelectStmt{From: []parser.TableExpr{&parser.TableRef{Name: stmt.Table}}}
+		for _, name := range stmt.Columns {
+			query.Targets = append(query.Targets, &parser.ResultTarget{Expr: &parser.ColumnRef{Name: name}})
+		}
+		if len(stmt.Columns) == 0 {
+			query.Targets = []*parser.ResultTarget{{Expr: &parser.Star{}}}
+		}
+	}
+	res, err := x.query(query)
+	return res, withoutPosition(err)
+}
+
+// copyColumns returns the positions of the columns listed by COPY, all
+// columns when none are listed
+func copyColumns(table *catalog.Table, names []string) ([]int, error) {
+	if len(names) == 0 {
+		positions := make([]int, len(table.Columns))
+		for i := range positions {
+			positions[i] = i
+		}
+		return positions, nil
+	}
+	seen := make(map[int]bool)
+	var positions []int
+	for _, name := range names {
+		i, ok := table.Column(name)
+		if !ok {
+			return nil, pgerror.NewError(pgerror.CodeUndefinedColumn, "column \"%s\" of relation \"%s\" does not exist", name, table.Name)
+		}
+		if seen[i] {
+			return nil, pgerror.NewError(pgerror.CodeDuplicateColumn, "column \"%s\" specified more than once", name)
+		}
+		seen[i] = true
+		positions = append(positions, i)
+	}
+	return positions, nil
+}
+
+// withoutPosition removes the position of an error, the text of a COPY
+// statement is not passed to the engine so the position would be wrong
+func withoutPosition(err error) error {
+	var pgErr *pgerror.Error
+	if errors.As(err, &pgErr) && pgErr.Position != 0 {
+		clean := *pgErr
+		clean.Position = 0
+		return &clean
+	}
+	return err
+}
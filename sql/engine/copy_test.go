@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// copyStmt parses a COPY statement
+func copyStmt(t *testing.T, query string) *parser.CopyStmt {
+	stmt, err := parser.ParseOne(query)
+	require.NoError(t, err, query)
+	return stmt.(*parser.CopyStmt)
+}
+
+func TestCopyFrom(t *testing.T) {
+	e := New()
+	execute(t, e, "create table t (id serial primary key, name varchar(3) not null, n int default 7)")
+
+	n, err := e.CopyFrom(context.Background(), copyStmt(t, "copy t (name, n) from stdin"), session.NewRows([][]interface{}{
+		{"abc", "1"},
+		{"de", nil},
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	// binary values are decoded with the type of their column
+	n, err = e.CopyFrom(context.Background(), copyStmt(t, "copy t from stdin (format binary)"), session.NewRows([][]interface{}{
+		{[]byte{0, 0, 0, 9}, []byte("f"), []byte{0, 0, 0, 3}},
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	assert.Equal(t, [][]interface{}{
+		{int64(1), "abc", int64(1)},
+		{int64(2), "de", nil},
+		{int64(9), "f", int64(3)},
+	}, rows(t, execute(t, e, "select * from t order by id")))
+}
+
+func TestCopyFromErrors(t *testing.T) {
+	e := New()
+	execute(t, e, "create table t (a int, b varchar(2))")
+
+	for query, tt := range map[string]struct {
+		rows [][]interface{}
+		code string
+	}{
+		"copy t from stdin":        {[][]interface{}{{"1", "x"}, {"2", "x", "y"}}, pgerror.CodeBadCopyFileFormat},
+		"copy t (b, a) from stdin": {[][]interface{}{{"x"}}, pgerror.CodeBadCopyFileFormat},
+		"copy t (a, b) from stdin": {[][]interface{}{{"1", "xyz"}}, pgerror.CodeStringDataRightTruncation},
+		"copy t (a) from stdin":    {[][]interface{}{{"one"}}, pgerror.CodeInvalidTextRepresentation},
+		"copy t (c) from stdin":    {nil, pgerror.CodeUndefinedColumn},
+		"copy t (a, a) from stdin": {nil, pgerror.CodeDuplicateColumn},
+		"copy u from stdin":        {nil, pgerror.CodeUndefinedTable},
+	} {
+		_, err := e.CopyFrom(context.Background(), copyStmt(t, query), session.NewRows(tt.rows))
+		var pgErr *pgerror.Error
+		require.ErrorAs(t, err, &pgErr, query)
+		assert.Equal(t, tt.code, pgErr.Code, query)
+		assert.Zero(t, pgErr.Position, query)
+	}
+	// a failing row leaves the table unchanged
+	assert.Empty(t, rows(t, execute(t, e, "select * from t")))
+}
+
+func TestCopyTo(t *testing.T) {
+	e := New()
+	execute(t, e, "create table t (a int, b text)", "insert into t values (1, 'x'), (2, null)")
+
+	res, err := e.CopyTo(context.Background(), copyStmt(t, "copy t to stdout"))
+	require.NoError(t, err)
+	require.Len(t, res.Columns, 2)
+	assert.Equal(t, "b", res.Columns[1].Name)
+	assert.Equal(t, [][]interface{}{{int64(1), "x"}, {int64(2), nil}}, rows(t, res))
+
+	res, err = e.CopyTo(context.Background(), copyStmt(t, "copy t (b) to stdout"))
+	require.NoError(t, err)
+	assert.Equal(t, [][]interface{}{{"x"}, {nil}}, rows(t, res))
+
+	res, err = e.CopyTo(context.Background(), copyStmt(t, "copy (select a * 10 as n from t where b is not null) to stdout"))
+	require.NoError(t, err)
+	assert.Equal(t, "n", res.Columns[0].Name)
+	assert.Equal(t, [][]interface{}{{int64(10)}}, rows(t, res))
+
+	_, err = e.CopyTo(context.Background(), copyStmt(t, "copy (select c from t) to stdout"))
+	var pgErr *pgerror.Error
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, pgerror.CodeUndefinedColumn, pgErr.Code)
+	assert.Zero(t, pgErr.Position)
+}
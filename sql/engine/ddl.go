@@ -0,0 +1,201 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package engine
+
+import (
+	"github.com/patrickglass/dsql/sql/catalog"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/planner"
+	"github.com/patrickglass/dsql/sql/session"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+func (x *execution) createTable(stmt *parser.CreateTableStmt) (*session.Result, error) {
+	schema, err := x.creationSchema(stmt.Table)
+	if err != nil {
+		return nil, err
+	}
+	result := &session.Result{CommandTag: "CREATE TABLE"}
+	if _, ok := x.catalog.Table(schema, stmt.Table.Name); ok && stmt.IfNotExists {
+		x.notice(pgerror.CodeDuplicateTable, "relation \"%s\" already exists, skipping", stmt.Table.Name)
+		return result, nil
+	}
+
+	columns := make([]*catalog.Column, len(stmt.Columns))
+	var constraints []*catalog.Constraint
+	for i, def := range stmt.Columns {
+		col, err := x.column(def)
+		if err != nil {
+			return nil, err
+		}
+		columns[i] = col
+		for _, c := range def.Constraints {
+			switch c.Type {
+			case parser.ConstraintPrimaryKey:
+				constraints = append(constraints, &catalog.Constraint{Name: c.Name, Type: catalog.PrimaryKey, Columns: []int{i}})
+			case parser.ConstraintUnique:
+				constraints = append(constraints, &catalog.Constraint{Name: c.Name, Type: catalog.Unique, Columns: []int{i}})
+			}
+		}
+	}
+	for _, c := range stmt.Constraints {
+		constraint, err := x.constraint(c, columns)
+		if err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, constraint)
+	}
+
+	if _, err := x.catalog.CreateTable(schema, stmt.Table.Name, columns, constraints); err != nil {
+		if stmt.IfNotExists && errorCode(err) == pgerror.CodeDuplicateTable {
+			x.notice(pgerror.CodeDuplicateTable, "relation \"%s\" already exists, skipping", stmt.Table.Name)
+			return result, nil
+		}
+		return nil, x.compiler.Positioned(stmt.Table, err)
+	}
+	return result, nil
+}
+
+// column returns the column of a column definition
+func (x *execution) column(def *parser.ColumnDef) (*catalog.Column, error) {
+	t, err := types.FromName(def.Type)
+	if err != nil {
+		return nil, x.compiler.Positioned(def.Type, err)
+	}
+	col := &catalog.Column{Name: def.Name, Type: t}
+	if isSerial(def.Type) {
+		col.Sequence = &catalog.Sequence{}
+		col.NotNull = true
+	}
+	for _, c := range def.Constraints {
+		switch c.Type {
+		case parser.ConstraintNotNull:
+			col.NotNull = true
+		case parser.ConstraintDefault:
+			if col.Sequence != nil || col.Default != nil {
+				return nil, x.errorAt(c, pgerror.CodeSyntaxError, "multiple default values specified for column \"%s\" of table", def.Name)
+			}
+			// the default is compiled when rows are inserted, compiling it
+			// now reports errors early
+			if _, err := x.compiler.Assignment(c.Expr, &planner.Scope{}, def.Name, t); err != nil {
+				return nil, err
+			}
+			col.Default = c.Expr
+		case parser.ConstraintCheck:
+			return nil, x.errorAt(c, pgerror.CodeFeatureNotSupported, "CHECK constraints are not supported")
+		case parser.ConstraintForeignKey:
+			return nil, x.errorAt(c, pgerror.CodeFeatureNotSupported, "FOREIGN KEY constraints are not supported")
+		}
+	}
+	return col, nil
+}
+
+// constraint returns the table constraint of a constraint definition
+func (x *execution) constraint(c *parser.Constraint, columns []*catalog.Column) (*catalog.Constraint, error) {
+	constraint := &catalog.Constraint{Name: c.Name}
+	switch c.Type {
+	case parser.ConstraintPrimaryKey:
+		constraint.Type = catalog.PrimaryKey
+	case parser.ConstraintUnique:
+		constraint.Type = catalog.Unique
+	case parser.ConstraintCheck:
+		return nil, x.errorAt(c, pgerror.CodeFeatureNotSupported, "CHECK constraints are not supported")
+	default:
+		return nil, x.errorAt(c, pgerror.CodeFeatureNotSupported, "FOREIGN KEY constraints are not supported")
+	}
+next:
+	for _, name := range c.Columns {
+		for i, col := range columns {
+			if col.Name == name {
+				constraint.Columns = append(constraint.Columns, i)
+				continue next
+			}
+		}
+		return nil, x.errorAt(c, pgerror.CodeUndefinedColumn, "column \"%s\" named in key does not exist", name)
+	}
+	return constraint, nil
+}
+
+// isSerial reports whether the type is one of the serial pseudo types
+func isSerial(t *parser.TypeName) bool {
+	switch t.Name {
+	case "serial", "serial4", "bigserial", "serial8", "smallserial", "serial2":
+		return !t.Array
+	}
+	return false
+}
+
+func (x *execution) createSchema(stmt *parser.CreateSchemaStmt) (*session.Result, error) {
+	result := &session.Result{CommandTag: "CREATE SCHEMA"}
+	if err := x.catalog.CreateSchema(stmt.Name); err != nil {
+		if stmt.IfNotExists && errorCode(err) == pgerror.CodeDuplicateSchema {
+			x.notice(pgerror.CodeDuplicateSchema, "schema \"%s\" already exists, skipping", stmt.Name)
+			return result, nil
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+func (x *execution) drop(stmt *parser.DropStmt) (*session.Result, error) {
+	if stmt.Type == parser.ObjectSchema {
+		return x.dropSchemas(stmt)
+	}
+	// like postgres nothing is dropped when one of the tables is missing
+	var tables []*catalog.Table
+	for _, name := range stmt.Names {
+		t := x.lookup(name)
+		if t == nil {
+			if !stmt.IfExists {
+				err := catalog.ErrUndefinedTable(qualifiedName(name))
+				err.Position = x.compiler.Position(name)
+				return nil, err
+			}
+			x.notice(pgerror.CodeSuccessfulCompletion, "table \"%s\" does not exist, skipping", qualifiedName(name))
+			continue
+		}
+		tables = append(tables, t)
+	}
+	for _, t := range tables {
+		if err := x.catalog.DropTable(t.Schema, t.Name); err != nil {
+			return nil, err
+		}
+	}
+	return &session.Result{CommandTag: "DROP TABLE"}, nil
+}
+
+func (x *execution) dropSchemas(stmt *parser.DropStmt) (*session.Result, error) {
+	var schemas []string
+	for _, name := range stmt.Names {
+		if _, ok := x.catalog.Schema(name.Name); !ok {
+			if !stmt.IfExists {
+				return nil, x.errorAt(name, pgerror.CodeInvalidSchemaName, "schema \"%s\" does not exist", name.Name)
+			}
+			x.notice(pgerror.CodeSuccessfulCompletion, "schema \"%s\" does not exist, skipping", name.Name)
+			continue
+		}
+		schemas = append(schemas, name.Name)
+	}
+	for _, name := range schemas {
+		if err := x.catalog.DropSchema(name, stmt.Cascade); err != nil {
+			return nil, err
+		}
+	}
+	return &session.Result{CommandTag: "DROP SCHEMA"}, nil
+}
@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/catalog"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateTable(t *testing.T) {
+	e := New()
+	result := execute(t, e, `create table t (
+		id bigserial primary key,
+		code char(3) not null unique,
+		price numeric(10,2) default 0,
+		tags text,
+		unique (price, tags)
+	)`)
+	assert.Equal(t, "CREATE TABLE", result.CommandTag)
+
+	table, ok := e.Catalog().Table("public", "t")
+	require.True(t, ok)
+	require.Len(t, table.Columns, 4)
+	id := table.Columns[0]
+	assert.Equal(t, types.Int8, id.Type)
+	assert.True(t, id.NotNull)
+	require.NotNil(t, id.Sequence)
+	assert.Equal(t, "t_id_seq", id.Sequence.Name)
+	assert.Equal(t, "character(3)", table.Columns[1].Type.String())
+	assert.True(t, table.Columns[1].NotNull)
+	assert.NotNil(t, table.Columns[2].Default)
+	assert.False(t, table.Columns[3].NotNull)
+	assert.Equal(t, []*catalog.Constraint{
+		{Name: "t_pkey", Type: catalog.PrimaryKey, Columns: []int{0}},
+		{Name: "t_code_key", Type: catalog.Unique, Columns: []int{1}},
+		{Name: "t_price_tags_key", Type: catalog.Unique, Columns: []int{2, 3}},
+	}, table.Constraints)
+}
+
+func TestCreateTableIfNotExists(t *testing.T) {
+	e := New()
+	execute(t, e, "create table t (a int)")
+	result := execute(t, e, "create table if not exists t (b text)")
+	assert.Equal(t, "CREATE TABLE", result.CommandTag)
+	table, _ := e.Catalog().Table("public", "t")
+	assert.Equal(t, "a", table.Columns[0].Name, "the existing table is kept")
+
+	err := executeError(t, e, "create table t (b text)")
+	assert.Equal(t, pgerror.CodeDuplicateTable, err.Code)
+	assert.Equal(t, `relation "t" already exists`, err.Message)
+	assert.Equal(t, int32(14), err.Position)
+}
+
+func TestCreateTableErrors(t *testing.T) {
+	tests := []struct {
+		query    string
+		code     string
+		message  string
+		position int32
+	}{
+		{"create table t (a int, a text)", pgerror.CodeDuplicateColumn, `column "a" specified more than once`, 14},
+		{"create table t (a int primary key, b int primary key)", pgerror.CodeInvalidTableDefinition, `multiple primary keys for table "t" are not allowed`, 14},
+		{"create table t (a int, primary key (b))", pgerror.CodeUndefinedColumn, `column "b" named in key does not exist`, 24},
+		{"create table t (a varchar(0))", pgerror.CodeInvalidParameterValue, `length for type varchar must be at least 1`, 19},
+		{"create table t (a int default 'x')", pgerror.CodeInvalidTextRepresentation, `invalid input syntax for type integer: "x"`, 31},
+		{"create table t (a int default true)", pgerror.CodeDatatypeMismatch, `column "a" is of type integer but expression is of type boolean`, 31},
+		{"create table t (a int check (a > 0))", pgerror.CodeFeatureNotSupported, `CHECK constraints are not supported`, 23},
+		{"create table t (a int references u (a))", pgerror.CodeFeatureNotSupported, `FOREIGN KEY constraints are not supported`, 23},
+		{"create table s.t (a int)", pgerror.CodeInvalidSchemaName, `schema "s" does not exist`, 14},
+	}
+	for _, test := range tests {
+		err := executeError(t, New(), test.query)
+		assert.Equal(t, test.code, err.Code, test.query)
+		assert.Equal(t, test.message, err.Message, test.query)
+		assert.Equal(t, test.position, err.Position, test.query)
+	}
+}
+
+func TestDropTable(t *testing.T) {
+	e := New()
+	execute(t, e, "create table a (x int)", "create table b (x int)")
+
+	err := executeError(t, e, "drop table a, c")
+	assert.Equal(t, pgerror.CodeUndefinedTable, err.Code)
+	assert.Equal(t, `table "c" does not exist`, err.Message)
+	_, ok := e.Catalog().Table("public", "a")
+	assert.True(t, ok, "nothing is dropped when a table is missing")
+
+	result := execute(t, e, "drop table if exists a, c, public.b")
+	assert.Equal(t, "DROP TABLE", result.CommandTag)
+	assert.Empty(t, e.Catalog().Tables("public"))
+}
+
+func TestSchemas(t *testing.T) {
+	e := New()
+	assert.Equal(t, "CREATE SCHEMA", execute(t, e, "create schema s").CommandTag)
+	execute(t, e, "create schema if not exists s", "create table s.t (a int)")
+	_, ok := e.Catalog().Table("s", "t")
+	assert.True(t, ok)
+
+	err := executeError(t, e, "create schema s")
+	assert.Equal(t, pgerror.CodeDuplicateSchema, err.Code)
+
+	err = executeError(t, e, "insert into t values (1)")
+	assert.Equal(t, `relation "t" does not exist`, err.Message, "s is not in the search path")
+	assert.Equal(t, int32(13), err.Position)
+	assert.Equal(t, "INSERT 0 1", execute(t, e, "insert into s.t values (1)").CommandTag)
+
+	err = executeError(t, e, "drop schema s")
+	assert.Equal(t, pgerror.CodeDependentObjectsStillExist, err.Code)
+	assert.Equal(t, "table s.t depends on schema s", err.Detail)
+
+	assert.Equal(t, "DROP SCHEMA", execute(t, e, "drop schema s cascade").CommandTag)
+	execute(t, e, "drop schema if exists s")
+	err = executeError(t, e, "drop schema s")
+	assert.Equal(t, pgerror.CodeInvalidSchemaName, err.Code)
+	assert.Equal(t, `schema "s" does not exist`, err.Message)
+}
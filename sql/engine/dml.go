@@ -0,0 +1,495 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package engine
+
+import (
+	"fmt"
+
+	"github.com/patrickglass/dsql/sql/catalog"
+	"github.com/patrickglass/dsql/sql/executor"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/planner"
+	"github.com/patrickglass/dsql/sql/session"
+)
+
+// dml is what the plans of INSERT, UPDATE and DELETE have in common
+type dml struct {
+	table *catalog.Table
+	// defaults are the compiled DEFAULT expressions of the columns, nil
+	// for columns without one
+	defaults []executor.Expr
+	// returning is nil without a RETURNING clause
+	returning *planner.Projection
+	rows      [][]interface{}
+	count     int64
+}
+
+func (x *execution) newDML(table *catalog.Table, alias string, returning []*parser.ResultTarget) (dml, error) {
+	d := dml{table: table, defaults: make([]executor.Expr, len(table.Columns))}
+	for i, col := range table.Columns {
+		if col.Default == nil {
+			continue
+		}
+		e, err := x.compiler.Assignment(col.Default, &planner.Scope{}, col.Name, col.Type)
+		if err != nil {
+			return d, err
+		}
+		d.defaults[i] = e
+	}
+	if len(returning) > 0 {
+		p, err := x.compiler.Projection(returning, planner.TableScope(table, alias))
+		if err != nil {
+			return d, err
+		}
+		d.returning = p
+	}
+	return d, nil
+}
+
+// columns returns the columns of the rows returned by the statement
+func (d *dml) columns() []pgtype.Column {
+	if d.returning == nil {
+		return nil
+	}
+	return d.returning.Columns
+}
+
+// defaultValue returns the default value of column i
+func (d *dml) defaultValue(env *executor.Env, i int) (interface{}, error) {
+	if seq := d.table.Columns[i].Sequence; seq != nil {
+		return seq.Next()
+	}
+	if d.defaults[i] == nil {
+		return nil, nil
+	}
+	return d.defaults[i].Eval(env, nil)
+}
+
+// changed counts a row inserted, updated or deleted and evaluates the
+// RETURNING clause for it
+func (d *dml) changed(env *executor.Env, row catalog.Row) error {
+	d.count++
+	if d.returning == nil {
+		return nil
+	}
+	values, err := d.returning.Eval(env, executor.Row(row))
+	if err != nil {
+		return err
+	}
+	d.rows = append(d.rows, values)
+	return nil
+}
+
+func (d *dml) result(tag string) *session.Result {
+	if d.returning == nil {
+		return &session.Result{CommandTag: tag, RowsAffected: d.count}
+	}
+	result := session.NewResult(d.returning.Columns, d.rows)
+	result.CommandTag = tag
+	return result
+}
+
+// targetColumn returns the position of a column assigned by INSERT or
+// UPDATE
+func (x *execution) targetColumn(node parser.Node, table *catalog.Table, name string) (int, error) {
+	i, ok := table.Column(name)
+	if !ok {
+		return -1, x.errorAt(node, pgerror.CodeUndefinedColumn, "column \"%s\" of relation \"%s\" does not exist", name, table.Name)
+	}
+	return i, nil
+}
+
+type insertPlan struct {
+	dml
+	// targets are the positions of the columns the values are assigned to
+	targets []int
+	// values holds the rows of VALUES, a nil expression is DEFAULT. With
+	// a query it holds a single row evaluated for each row of the query.
+	values [][]executor.Expr
+	query  executor.Operator
+	// skipConflicts is set by ON CONFLICT DO NOTHING, conflicts with
+	// conflict or with any constraint when it is nil skip the row
+	skipConflicts bool
+	conflict      *catalog.Constraint
+}
+
+func (x *execution) planInsert(stmt *parser.InsertStmt) (*insertPlan, error) {
+	table, err := x.Table(stmt.Table)
+	if err != nil {
+		return nil, err
+	}
+	d, err := x.newDML(table, stmt.Alias, stmt.Returning)
+	if err != nil {
+		return nil, err
+	}
+	p := &insertPlan{dml: d}
+
+	if len(stmt.Columns) == 0 {
+		for i := range table.Columns {
+			p.targets = append(p.targets, i)
+		}
+	}
+	seen := make(map[int]bool)
+	for _, name := range stmt.Columns {
+		i, err := x.targetColumn(stmt.Table, table, name)
+		if err != nil {
+			return nil, err
+		}
+		if seen[i] {
+			return nil, x.errorAt(stmt.Table, pgerror.CodeDuplicateColumn, "column \"%s\" specified more than once", name)
+		}
+		seen[i] = true
+		p.targets = append(p.targets, i)
+	}
+
+	if stmt.DefaultValues {
+		p.values = [][]executor.Expr{nil}
+	} else if q := stmt.Query; q.Values == nil || q.With != nil || q.OrderBy != nil || q.Limit != nil || q.Offset != nil {
+		if err := p.planQuery(x, q); err != nil {
+			return nil, err
+		}
+	} else if err := p.compileValues(x, stmt); err != nil {
+		return nil, err
+	}
+
+	if c := stmt.OnConflict; c != nil {
+		if c.Update != nil {
+			return nil, x.errorAt(c, pgerror.CodeFeatureNotSupported, "ON CONFLICT DO UPDATE is not supported")
+		}
+		p.skipConflicts = true
+		if len(c.Columns) > 0 {
+			if p.conflict, err = x.conflictConstraint(c, table); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return p, nil
+}
+
+// planQuery plans the query of INSERT ... SELECT and the conversion of its
+// columns to the target columns
+func (p *insertPlan) planQuery(x *execution, q *parser.SelectStmt) error {
+	plan, err := x.compiler.Select(q, nil)
+	if err != nil {
+		return err
+	}
+	if len(plan.Columns) > len(p.targets) {
+		return x.errorAt(q, pgerror.CodeSyntaxError, "INSERT has more expressions than target columns")
+	}
+	if len(plan.Columns) < len(p.targets) {
+		return x.errorAt(q, pgerror.CodeSyntaxError, "INSERT has more target columns than expressions")
+	}
+	exprs := make([]executor.Expr, len(plan.Columns))
+	for i, t := range plan.Types {
+		var node parser.Node = q
+		if len(q.Targets) == len(exprs) {
+			node = q.Targets[i].Expr
+		}
+		col := p.table.Columns[p.targets[i]]
+		e, err := x.compiler.Assign(node, executor.NewColumn(i, plan.Columns[i].Name, t), col.Name, col.Type)
+		if err != nil {
+			return err
+		}
+		exprs[i] = e
+	}
+	p.values, p.query = [][]executor.Expr{exprs}, plan.Root
+	return nil
+}
+
+func (p *insertPlan) compileValues(x *execution, stmt *parser.InsertStmt) error {
+	for _, values := range stmt.Query.Values {
+		if len(values) > len(p.targets) {
+			return x.errorAt(values[len(p.targets)], pgerror.CodeSyntaxError, "INSERT has more expressions than target columns")
+		}
+		if len(values) < len(p.targets) && len(stmt.Columns) > 0 {
+			return x.errorAt(values[0], pgerror.CodeSyntaxError, "INSERT has more target columns than expressions")
+		}
+		exprs := make([]executor.Expr, len(values))
+		for i, v := range values {
+			if _, ok := v.(*parser.DefaultExpr); ok {
+				continue
+			}
+			col := p.table.Columns[p.targets[i]]
+			e, err := x.compiler.Assignment(v, &planner.Scope{}, col.Name, col.Type)
+			if err != nil {
+				return err
+			}
+			exprs[i] = e
+		}
+		p.values = append(p.values, exprs)
+	}
+	return nil
+}
+
+// conflictConstraint returns the constraint on the columns of ON CONFLICT
+func (x *execution) conflictConstraint(c *parser.OnConflict, table *catalog.Table) (*catalog.Constraint, error) {
+	columns := make(map[int]bool)
+	for _, name := range c.Columns {
+		i, ok := table.Column(name)
+		if !ok {
+			return nil, x.errorAt(c, pgerror.CodeUndefinedColumn, "column \"%s\" does not exist", name)
+		}
+		columns[i] = true
+	}
+next:
+	for _, constraint := range table.Constraints {
+		if len(constraint.Columns) != len(columns) {
+			continue
+		}
+		for _, i := range constraint.Columns {
+			if !columns[i] {
+				continue next
+			}
+		}
+		return constraint, nil
+	}
+	return nil, x.errorAt(c, pgerror.CodeInvalidColumnReference, "there is no unique or exclusion constraint matching the ON CONFLICT specification")
+}
+
+func (p *insertPlan) run(x *execution) (*session.Result, error) {
+	tx := p.table.Begin()
+	defer tx.Rollback()
+	if p.query != nil {
+		if err := p.insertQuery(x, tx); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, exprs := range p.values {
+			if err := p.insert(x, tx, exprs, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return p.result(fmt.Sprintf("INSERT 0 %d", p.count)), nil
+}
+
+// insertQuery inserts the rows of the query
+func (p *insertPlan) insertQuery(x *execution, tx *catalog.Tx) (err error) {
+	if err := p.query.Open(x.env); err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := p.query.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	for {
+		input, err := p.query.Next()
+		if err != nil || input == nil {
+			return err
+		}
+		if err := p.insert(x, tx, p.values[0], input); err != nil {
+			return err
+		}
+	}
+}
+
+// insert inserts a row of the values of exprs evaluated for the input row
+func (p *insertPlan) insert(x *execution, tx *catalog.Tx, exprs []executor.Expr, input executor.Row) error {
+	row := make(catalog.Row, len(p.table.Columns))
+	set := make([]bool, len(row))
+	for i, e := range exprs {
+		if e == nil {
+			continue
+		}
+		v, err := e.Eval(x.env, input)
+		if err != nil {
+			return err
+		}
+		row[p.targets[i]] = v
+		set[p.targets[i]] = true
+	}
+	for i := range row {
+		if set[i] {
+			continue
+		}
+		v, err := p.defaultValue(x.env, i)
+		if err != nil {
+			return err
+		}
+		row[i] = v
+	}
+	if p.skipConflicts {
+		if c := tx.Conflict(row); c != nil && (p.conflict == nil || c == p.conflict) {
+			return nil
+		}
+	}
+	if err := tx.Insert(row); err != nil {
+		return err
+	}
+	return p.changed(x.env, row)
+}
+
+// assignment is a SET clause of UPDATE, a nil expression is DEFAULT
+type assignment struct {
+	column int
+	expr   executor.Expr
+}
+
+type updatePlan struct {
+	dml
+	where executor.Expr
+	sets  []assignment
+}
+
+func (x *execution) planUpdate(stmt *parser.UpdateStmt) (*updatePlan, error) {
+	if len(stmt.From) > 0 {
+		return nil, x.errorAt(stmt.From[0], pgerror.CodeFeatureNotSupported, "UPDATE ... FROM is not supported")
+	}
+	table, err := x.Table(stmt.Table)
+	if err != nil {
+		return nil, err
+	}
+	d, err := x.newDML(table, stmt.Alias, stmt.Returning)
+	if err != nil {
+		return nil, err
+	}
+	p := &updatePlan{dml: d}
+	scope := planner.TableScope(table, stmt.Alias)
+	if p.where, err = x.where(stmt.Where, scope); err != nil {
+		return nil, err
+	}
+	seen := make(map[int]bool)
+	for _, set := range stmt.Set {
+		i, err := x.targetColumn(set, table, set.Column)
+		if err != nil {
+			return nil, err
+		}
+		if seen[i] {
+			return nil, x.errorAt(set, pgerror.CodeSyntaxError, "multiple assignments to same column \"%s\"", set.Column)
+		}
+		seen[i] = true
+		a := assignment{column: i}
+		if _, ok := set.Value.(*parser.DefaultExpr); !ok {
+			col := table.Columns[i]
+			if a.expr, err = x.compiler.Assignment(set.Value, scope, col.Name, col.Type); err != nil {
+				return nil, err
+			}
+		}
+		p.sets = append(p.sets, a)
+	}
+	return p, nil
+}
+
+// where compiles the condition of a WHERE clause, nil without one
+func (x *execution) where(e parser.Expr, scope *planner.Scope) (executor.Expr, error) {
+	if e == nil {
+		return nil, nil
+	}
+	return x.compiler.Condition(e, scope, "WHERE")
+}
+
+// matches reports whether the row satisfies the WHERE condition
+func (x *execution) matches(where executor.Expr, row catalog.Row) (bool, error) {
+	if err := x.ctx.Err(); err != nil {
+		return false, err
+	}
+	if where == nil {
+		return true, nil
+	}
+	v, err := where.Eval(x.env, executor.Row(row))
+	return v == true, err
+}
+
+func (p *updatePlan) run(x *execution) (*session.Result, error) {
+	tx := p.table.Begin()
+	defer tx.Rollback()
+	for i, row := range tx.Rows() {
+		ok, err := x.matches(p.where, row)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		updated := append(catalog.Row(nil), row...)
+		for _, set := range p.sets {
+			var v interface{}
+			if set.expr == nil {
+				v, err = p.defaultValue(x.env, set.column)
+			} else {
+				v, err = set.expr.Eval(x.env, executor.Row(row))
+			}
+			if err != nil {
+				return nil, err
+			}
+			updated[set.column] = v
+		}
+		if err := tx.Update(i, updated); err != nil {
+			return nil, err
+		}
+		if err := p.changed(x.env, updated); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return p.result(fmt.Sprintf("UPDATE %d", p.count)), nil
+}
+
+type deletePlan struct {
+	dml
+	where executor.Expr
+}
+
+func (x *execution) planDelete(stmt *parser.DeleteStmt) (*deletePlan, error) {
+	if len(stmt.Using) > 0 {
+		return nil, x.errorAt(stmt.Using[0], pgerror.CodeFeatureNotSupported, "DELETE ... USING is not supported")
+	}
+	table, err := x.Table(stmt.Table)
+	if err != nil {
+		return nil, err
+	}
+	d, err := x.newDML(table, stmt.Alias, stmt.Returning)
+	if err != nil {
+		return nil, err
+	}
+	p := &deletePlan{dml: d}
+	if p.where, err = x.where(stmt.Where, planner.TableScope(table, stmt.Alias)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *deletePlan) run(x *execution) (*session.Result, error) {
+	tx := p.table.Begin()
+	defer tx.Rollback()
+	for i, row := range tx.Rows() {
+		ok, err := x.matches(p.where, row)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		tx.Delete(i)
+		if err := p.changed(x.env, row); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return p.result(fmt.Sprintf("DELETE %d", p.count)), nil
+}
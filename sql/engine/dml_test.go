@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/catalog"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newItems returns an engine with the table items
+func newItems(t *testing.T) *Engine {
+	e := New()
+	execute(t, e, `create table items (
+		id serial primary key,
+		name varchar(5) not null unique,
+		price numeric(6,2) default 1.5,
+		stock int
+	)`)
+	return e
+}
+
+// tableRows returns the committed rows of the table
+func tableRows(t *testing.T, e *Engine, name string) []catalog.Row {
+	table, ok := e.Catalog().Table("public", name)
+	require.True(t, ok)
+	return table.Rows()
+}
+
+func TestInsert(t *testing.T) {
+	e := newItems(t)
+	result := execute(t, e, "insert into items (name, stock) values ('a', 1), ('b', DEFAULT)")
+	assert.Equal(t, "INSERT 0 2", result.CommandTag)
+	assert.Equal(t, int64(2), result.RowsAffected)
+	assert.Nil(t, result.Columns)
+
+	execute(t, e,
+		"insert into items values (DEFAULT, 'c', 2.345, '7')",
+		"insert into items values (10, 'd')",
+	)
+	assert.Equal(t, []catalog.Row{
+		{int64(1), "a", "1.50", int64(1)},
+		{int64(2), "b", "1.50", nil},
+		{int64(3), "c", "2.35", int64(7)},
+		{int64(10), "d", "1.50", nil},
+	}, tableRows(t, e, "items"))
+}
+
+func TestInsertDefaultValues(t *testing.T) {
+	e := New()
+	execute(t, e, "create table t (id serial, a text default 'x' || 'y', b int)", "insert into t default values")
+	assert.Equal(t, []catalog.Row{{int64(1), "xy", nil}}, tableRows(t, e, "t"))
+}
+
+func TestInsertParameters(t *testing.T) {
+	e := newItems(t)
+	_, err := e.Execute(context.Background(), "insert into items (name, price) values ($1, $2)", []interface{}{"a", "3"})
+	require.NoError(t, err)
+	assert.Equal(t, []catalog.Row{{int64(1), "a", "3.00", nil}}, tableRows(t, e, "items"))
+}
+
+func TestInsertReturning(t *testing.T) {
+	e := newItems(t)
+	result := execute(t, e, "insert into items as i (name) values ('a'), ('b') returning i.id, *, price * 2 as double")
+	assert.Equal(t, "INSERT 0 2", result.CommandTag)
+	var names []string
+	for _, col := range result.Columns {
+		names = append(names, col.Name)
+	}
+	assert.Equal(t, []string{"id", "id", "name", "price", "stock", "double"}, names)
+	assert.Equal(t, [][]interface{}{
+		{int64(1), int64(1), "a", "1.50", nil, "3.00"},
+		{int64(2), int64(2), "b", "1.50", nil, "3.00"},
+	}, rows(t, result))
+}
+
+func TestInsertOnConflictDoNothing(t *testing.T) {
+	e := newItems(t)
+	execute(t, e, "insert into items (id, name) values (1, 'a')")
+
+	result := execute(t, e, "insert into items (id, name) values (1, 'b'), (2, 'a'), (3, 'c'), (3, 'd') on conflict do nothing")
+	assert.Equal(t, "INSERT 0 1", result.CommandTag)
+
+	result = execute(t, e, "insert into items (id, name) values (4, 'e') on conflict (id) do nothing")
+	assert.Equal(t, "INSERT 0 1", result.CommandTag)
+
+	err := executeError(t, e, "insert into items (id, name) values (5, 'a') on conflict (id) do nothing")
+	assert.Equal(t, pgerror.CodeUniqueViolation, err.Code, "only conflicts on the id are skipped")
+	assert.Equal(t, `duplicate key value violates unique constraint "items_name_key"`, err.Message)
+
+	err = executeError(t, e, "insert into items (id, name) values (5, 'f') on conflict (stock) do nothing")
+	assert.Equal(t, pgerror.CodeInvalidColumnReference, err.Code)
+	err = executeError(t, e, "insert into items (id, name) values (5, 'f') on conflict (id) do update set name = 'g'")
+	assert.Equal(t, pgerror.CodeFeatureNotSupported, err.Code)
+	assert.Len(t, tableRows(t, e, "items"), 3)
+}
+
+func TestInsertErrors(t *testing.T) {
+	tests := []struct {
+		query    string
+		code     string
+		message  string
+		position int32
+	}{
+		{"insert into items values (1, 'a', 1, 1, 1)", pgerror.CodeSyntaxError, "INSERT has more expressions than target columns", 41},
+		{"insert into items (id, name) values (1)", pgerror.CodeSyntaxError, "INSERT has more target columns than expressions", 38},
+		{"insert into items (nope) values (1)", pgerror.CodeUndefinedColumn, `column "nope" of relation "items" does not exist`, 13},
+		{"insert into items (id, id) values (1, 2)", pgerror.CodeDuplicateColumn, `column "id" specified more than once`, 13},
+		{"insert into items (name) values ('toolong')", pgerror.CodeStringDataRightTruncation, "value too long for type character varying(5)", 34},
+		{"insert into items (stock) values ('a' || 'b')", pgerror.CodeDatatypeMismatch, `column "stock" is of type integer but expression is of type text`, 39},
+		{"insert into items (name, price) values ('a', 10000)", pgerror.CodeNumericValueOutOfRange, "numeric field overflow", 46},
+		{"insert into items (stock) values (1)", pgerror.CodeNotNullViolation, `null value in column "name" of relation "items" violates not-null constraint`, 0},
+		{"insert into items (name) select 'a', 1", pgerror.CodeSyntaxError, "INSERT has more expressions than target columns", 26},
+		{"insert into items (name, stock) select 'a'", pgerror.CodeSyntaxError, "INSERT has more target columns than expressions", 33},
+		{"insert into items (stock) select 'a' || 'b'", pgerror.CodeDatatypeMismatch, `column "stock" is of type integer but expression is of type text`, 38},
+		{"insert into missing values (1)", pgerror.CodeUndefinedTable, `relation "missing" does not exist`, 13},
+	}
+	for _, test := range tests {
+		err := executeError(t, newItems(t), test.query)
+		assert.Equal(t, test.code, err.Code, test.query)
+		assert.Equal(t, test.message, err.Message, test.query)
+		assert.Equal(t, test.position, err.Position, test.query)
+	}
+}
+
+func TestInsertIsAtomic(t *testing.T) {
+	e := newItems(t)
+	err := executeError(t, e, "insert into items (name) values ('a'), ('b'), ('a')")
+	assert.Equal(t, pgerror.CodeUniqueViolation, err.Code)
+	assert.Equal(t, "Key (name)=(a) already exists.", err.Detail)
+	assert.Empty(t, tableRows(t, e, "items"))
+}
+
+func TestUpdate(t *testing.T) {
+	e := newItems(t)
+	execute(t, e, "insert into items (name, stock) values ('a', 1), ('b', 2), ('c', NULL)")
+
+	result := execute(t, e, "update items set stock = stock + 10, price = DEFAULT where stock >= 2 or name = 'a'")
+	assert.Equal(t, "UPDATE 2", result.CommandTag)
+	assert.Equal(t, int64(2), result.RowsAffected)
+
+	result = execute(t, e, "update items i set name = i.name || '!' where stock is null returning id, name")
+	assert.Equal(t, "UPDATE 1", result.CommandTag)
+	assert.Equal(t, [][]interface{}{{int64(3), "c!"}}, rows(t, result))
+
+	assert.Equal(t, []catalog.Row{
+		{int64(1), "a", "1.50", int64(11)},
+		{int64(2), "b", "1.50", int64(12)},
+		{int64(3), "c!", "1.50", nil},
+	}, tableRows(t, e, "items"))
+
+	assert.Equal(t, "UPDATE 0", execute(t, e, "update items set stock = 0 where false").CommandTag)
+}
+
+func TestUpdateSwapsKeys(t *testing.T) {
+	e := newItems(t)
+	execute(t, e, "insert into items (id, name) values (1, 'a'), (2, 'b')")
+	execute(t, e, "update items set id = 3 - id")
+	assert.Equal(t, []catalog.Row{
+		{int64(2), "a", "1.50", nil},
+		{int64(1), "b", "1.50", nil},
+	}, tableRows(t, e, "items"), "keys are unique once all rows are updated")
+
+	err := executeError(t, e, "update items set name = 'x'")
+	assert.Equal(t, pgerror.CodeUniqueViolation, err.Code)
+	assert.Equal(t, "a", tableRows(t, e, "items")[0][1])
+}
+
+func TestUpdateErrors(t *testing.T) {
+	tests := []struct {
+		query    string
+		code     string
+		message  string
+		position int32
+	}{
+		{"update items set nope = 1", pgerror.CodeUndefinedColumn, `column "nope" of relation "items" does not exist`, 18},
+		{"update items set stock = 1, stock = 2", pgerror.CodeSyntaxError, `multiple assignments to same column "stock"`, 29},
+		{"update items set stock = 1 where stock", pgerror.CodeDatatypeMismatch, "argument of WHERE must be type boolean, not type integer", 34},
+		{"update items set name = NULL", pgerror.CodeNotNullViolation, `null value in column "name" of relation "items" violates not-null constraint`, 0},
+		{"update items set stock = stock / 0", pgerror.CodeDivisionByZero, "division by zero", 0},
+		{"update items set stock = 1 from items", pgerror.CodeFeatureNotSupported, "UPDATE ... FROM is not supported", 33},
+	}
+	for _, test := range tests {
+		e := newItems(t)
+		execute(t, e, "insert into items (name, stock) values ('a', 1)")
+		err := executeError(t, e, test.query)
+		assert.Equal(t, test.code, err.Code, test.query)
+		assert.Equal(t, test.message, err.Message, test.query)
+		assert.Equal(t, test.position, err.Position, test.query)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	e := newItems(t)
+	execute(t, e, "insert into items (name, stock) values ('a', 1), ('b', 2), ('c', 3)")
+
+	result := execute(t, e, "delete from items where stock <> 2 returning name")
+	assert.Equal(t, "DELETE 2", result.CommandTag)
+	assert.Equal(t, [][]interface{}{{"a"}, {"c"}}, rows(t, result))
+	assert.Equal(t, []catalog.Row{{int64(2), "b", "1.50", int64(2)}}, tableRows(t, e, "items"))
+
+	result = execute(t, e, "delete from items")
+	assert.Equal(t, "DELETE 1", result.CommandTag)
+	assert.Equal(t, int64(1), result.RowsAffected)
+	assert.Empty(t, tableRows(t, e, "items"))
+
+	execute(t, e, "insert into items (name) values ('b')")
+	assert.Len(t, tableRows(t, e, "items"), 1, "deleted keys can be reused")
+}
@@ -0,0 +1,268 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package engine executes SQL statements against tables held in memory,
+// it implements the Handler of the SQL server.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/patrickglass/dsql/sql/catalog"
+	"github.com/patrickglass/dsql/sql/executor"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/planner"
+	"github.com/patrickglass/dsql/sql/session"
+)
+
+// Engine is a Handler of the SQL server storing its tables in memory. Every
+// statement runs in its own transaction, BEGIN is rejected as statements
+// cannot be grouped in one. COMMIT and ROLLBACK are accepted as there is
+// never a transaction to end.
+type Engine struct {
+	catalog *catalog.Catalog
+}
+
+// New returns an engine with an empty public schema
+func New() *Engine {
+	return &Engine{catalog: catalog.New()}
+}
+
+// Catalog returns the catalog holding the tables of the engine
+func (e *Engine) Catalog() *catalog.Catalog {
+	return e.catalog
+}
+
+// Describe returns the columns of the rows the query returns
+func (e *Engine) Describe(ctx context.Context, query string) ([]pgtype.Column, error) {
+	stmt, err := parser.ParseOne(query)
+	if err != nil || stmt == nil {
+		return nil, err
+	}
+	x := e.newExecution(ctx, query, nil)
+	switch stmt := stmt.(type) {
+	case *parser.InsertStmt:
+		p, err := x.planInsert(stmt)
+		if err != nil {
+			return nil, err
+		}
+		return p.columns(), nil
+	case *parser.UpdateStmt:
+		p, err := x.planUpdate(stmt)
+		if err != nil {
+			return nil, err
+		}
+		return p.columns(), nil
+	case *parser.DeleteStmt:
+		p, err := x.planDelete(stmt)
+		if err != nil {
+			return nil, err
+		}
+		return p.columns(), nil
+	case *parser.SelectStmt:
+		plan, err := x.compiler.Select(stmt, nil)
+		if err != nil {
+			return nil, err
+		}
+		return plan.Columns, nil
+	case *parser.ExplainStmt:
+		if _, err := x.explainPlan(stmt); err != nil {
+			return nil, err
+		}
+		return explainColumns, nil
+	case *parser.AlterTableStmt:
+		return nil, errUnsupported(stmt)
+	}
+	return nil, nil
+}
+
+// Execute runs the query
+func (e *Engine) Execute(ctx context.Context, query string, args []interface{}) (*session.Result, error) {
+	stmt, err := parser.ParseOne(query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return &session.Result{}, nil
+	}
+	if args == nil {
+		// nil arguments would compile the parameters for Describe
+		args = []interface{}{}
+	}
+	x := e.newExecution(ctx, query, args)
+	switch stmt := stmt.(type) {
+	case *parser.SelectStmt:
+		return x.query(stmt)
+	case *parser.ExplainStmt:
+		return x.explain(stmt)
+	case *parser.CreateTableStmt:
+		return x.createTable(stmt)
+	case *parser.CreateSchemaStmt:
+		return x.createSchema(stmt)
+	case *parser.DropStmt:
+		return x.drop(stmt)
+	case *parser.InsertStmt:
+		p, err := x.planInsert(stmt)
+		if err != nil {
+			return nil, err
+		}
+		return p.run(x)
+	case *parser.UpdateStmt:
+		p, err := x.planUpdate(stmt)
+		if err != nil {
+			return nil, err
+		}
+		return p.run(x)
+	case *parser.DeleteStmt:
+		p, err := x.planDelete(stmt)
+		if err != nil {
+			return nil, err
+		}
+		return p.run(x)
+	case *parser.TransactionStmt:
+		if stmt.Kind == parser.TransactionBegin {
+			return nil, errUnsupported(stmt)
+		}
+		return &session.Result{CommandTag: stmt.Kind.String()}, nil
+	}
+	return nil, errUnsupported(stmt)
+}
+
+// errUnsupported returns the error of statements the engine does not run
+func errUnsupported(stmt parser.Statement) error {
+	name := "statement"
+	switch stmt.(type) {
+	case *parser.AlterTableStmt:
+		name = "ALTER TABLE"
+	case *parser.TransactionStmt:
+		name = "transaction"
+	}
+	return pgerror.NewError(pgerror.CodeFeatureNotSupported, "%s is not supported", name)
+}
+
+// execution is the state of a statement being described or executed
+type execution struct {
+	*Engine
+	ctx      context.Context
+	compiler *planner.Compiler
+	env      *executor.Env
+}
+
+func (e *Engine) newExecution(ctx context.Context, query string, args []interface{}) *execution {
+	x := &execution{
+		Engine: e,
+		ctx:    ctx,
+		env:    executor.NewEnv(ctx),
+	}
+	x.env.Parameter = func(name string) (string, bool) {
+		return session.Parameter(ctx, name)
+	}
+	x.compiler = &planner.Compiler{Query: query, Args: args, Tables: x}
+	return x
+}
+
+// errorAt returns an error positioned at the node
+func (x *execution) errorAt(node parser.Node, code string, format string, args ...interface{}) *pgerror.Error {
+	return x.compiler.ErrorAt(node, code, format, args...)
+}
+
+// notice sends a notice to the client, it is dropped outside of a client
+// connection
+func (x *execution) notice(code string, format string, args ...interface{}) {
+	_ = session.SendNotice(x.ctx, &pgerror.Error{Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// searchPath returns the schemas unqualified names are looked up in
+func (x *execution) searchPath() []string {
+	value, ok := session.Parameter(x.ctx, "search_path")
+	if !ok {
+		return []string{catalog.DefaultSchema}
+	}
+	var schemas []string
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+			s = strings.ReplaceAll(s[1:len(s)-1], `""`, `"`)
+		}
+		if s == "" || s == "$user" {
+			continue
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas
+}
+
+// lookup returns the table with the name, nil when there is none
+func (x *execution) lookup(name *parser.ObjectName) *catalog.Table {
+	if name.Schema != "" {
+		t, _ := x.catalog.Table(name.Schema, name.Name)
+		return t
+	}
+	for _, schema := range x.searchPath() {
+		if t, ok := x.catalog.Table(schema, name.Name); ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// Table returns the table with the name or an undefined table error
+func (x *execution) Table(name *parser.ObjectName) (*catalog.Table, error) {
+	if t := x.lookup(name); t != nil {
+		return t, nil
+	}
+	return nil, x.errorAt(name, pgerror.CodeUndefinedTable, "relation \"%s\" does not exist", qualifiedName(name))
+}
+
+// creationSchema returns the schema new objects with the name are
+// created in
+func (x *execution) creationSchema(name *parser.ObjectName) (string, error) {
+	if name.Schema != "" {
+		if _, ok := x.catalog.Schema(name.Schema); !ok {
+			return "", x.errorAt(name, pgerror.CodeInvalidSchemaName, "schema \"%s\" does not exist", name.Schema)
+		}
+		return name.Schema, nil
+	}
+	for _, schema := range x.searchPath() {
+		if _, ok := x.catalog.Schema(schema); ok {
+			return schema, nil
+		}
+	}
+	return "", x.errorAt(name, pgerror.CodeInvalidSchemaName, "no schema has been selected to create in")
+}
+
+// qualifiedName returns the name as written without quotes
+func qualifiedName(name *parser.ObjectName) string {
+	if name.Schema != "" {
+		return name.Schema + "." + name.Name
+	}
+	return name.Name
+}
+
+// errorCode returns the SQLSTATE code of the error
+func errorCode(err error) string {
+	var pgErr *pgerror.Error
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// execute runs the statements one after the other and returns the result
+// of the last, failing the test on errors
+func execute(t *testing.T, e *Engine, queries ...string) *session.Result {
+	var result *session.Result
+	for _, query := range queries {
+		var err error
+		result, err = e.Execute(context.Background(), query, nil)
+		require.NoError(t, err, query)
+	}
+	return result
+}
+
+// executeError runs the statement and returns the error it fails with
+func executeError(t *testing.T, e *Engine, query string, args ...interface{}) *pgerror.Error {
+	_, err := e.Execute(context.Background(), query, args)
+	require.Error(t, err, query)
+	pgErr, ok := err.(*pgerror.Error)
+	require.True(t, ok, "%s: %v", query, err)
+	return pgErr
+}
+
+// rows returns the rows of a result
+func rows(t *testing.T, result *session.Result) [][]interface{} {
+	var values [][]interface{}
+	for result.Rows.Next() {
+		values = append(values, result.Rows.Values())
+	}
+	require.NoError(t, result.Rows.Err())
+	require.NoError(t, result.Rows.Close())
+	return values
+}
+
+func TestExecuteTransactionStatements(t *testing.T) {
+	e := New()
+	for query, tag := range map[string]string{
+		"commit":   "COMMIT",
+		"end":      "COMMIT",
+		"rollback": "ROLLBACK",
+	} {
+		assert.Equal(t, tag, execute(t, e, query).CommandTag, query)
+	}
+
+	// statements cannot be grouped in a transaction
+	for _, query := range []string{"begin", "start transaction isolation level serializable"} {
+		err := executeError(t, e, query)
+		assert.Equal(t, pgerror.CodeFeatureNotSupported, err.Code, query)
+		assert.Equal(t, "transaction is not supported", err.Message, query)
+	}
+}
+
+func TestExecuteEmpty(t *testing.T) {
+	result := execute(t, New(), " ; ")
+	assert.Nil(t, result.Columns)
+}
+
+func TestExecuteUnsupported(t *testing.T) {
+	e := New()
+	execute(t, e, "create table t (a int)")
+	err := executeError(t, e, "alter table t add column b int")
+	assert.Equal(t, pgerror.CodeFeatureNotSupported, err.Code)
+	assert.Equal(t, "ALTER TABLE is not supported", err.Message)
+
+	_, err2 := e.Execute(context.Background(), "selec 1", nil)
+	assert.EqualError(t, err2, `syntax error at or near "selec" at character 1`)
+}
+
+func TestDescribe(t *testing.T) {
+	e := New()
+	execute(t, e, "create table t (id serial primary key, name varchar(10))")
+	table, _ := e.Catalog().Table("public", "t")
+
+	columns, err := e.Describe(context.Background(), "insert into t (name) values ($1) returning id, name as n")
+	require.NoError(t, err)
+	assert.Equal(t, []pgtype.Column{
+		{Name: "id", TypeOID: pgtype.Int4OID, TypeSize: 4, TypeModifier: -1, TableOID: table.OID, AttributeNumber: 1},
+		{Name: "n", TypeOID: pgtype.VarcharOID, TypeSize: -1, TypeModifier: 14, TableOID: table.OID, AttributeNumber: 2},
+	}, columns)
+
+	columns, err = e.Describe(context.Background(), "update t set name = $1 where id = $2")
+	require.NoError(t, err)
+	assert.Nil(t, columns)
+
+	columns, err = e.Describe(context.Background(), "create table u (a int)")
+	require.NoError(t, err)
+	assert.Nil(t, columns)
+	_, ok := e.Catalog().Table("public", "u")
+	assert.False(t, ok, "describe does not execute")
+
+	_, err = e.Describe(context.Background(), "delete from missing")
+	assert.EqualError(t, err, `ERROR: relation "missing" does not exist (SQLSTATE 42P01)`)
+}
@@ -0,0 +1,65 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package engine
+
+import (
+	"strings"
+
+	"github.com/patrickglass/dsql/sql/executor"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/planner"
+	"github.com/patrickglass/dsql/sql/session"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// explainColumns describe the rows of EXPLAIN
+var explainColumns = []pgtype.Column{types.Text.Column("QUERY PLAN")}
+
+// explain returns the plan of a query, a row for each line
+func (x *execution) explain(stmt *parser.ExplainStmt) (*session.Result, error) {
+	plan, err := x.explainPlan(stmt)
+	if err != nil {
+		return nil, err
+	}
+	lines := executor.Explain(plan.Root)
+	rows := make([][]interface{}, len(lines))
+	for i, line := range lines {
+		rows[i] = []interface{}{line}
+	}
+	result := session.NewResult(explainColumns, rows)
+	result.CommandTag = "EXPLAIN"
+	return result, nil
+}
+
+// explainPlan plans the statement of EXPLAIN without running it, only
+// the text format of queries is supported
+func (x *execution) explainPlan(stmt *parser.ExplainStmt) (*planner.Plan, error) {
+	switch {
+	case stmt.Analyze:
+		return nil, x.compiler.ErrorAt(stmt, pgerror.CodeFeatureNotSupported, "EXPLAIN ANALYZE is not supported")
+	case stmt.Format != "text":
+		return nil, x.compiler.ErrorAt(stmt, pgerror.CodeFeatureNotSupported, "EXPLAIN format %s is not supported", strings.ToUpper(stmt.Format))
+	}
+	query, ok := stmt.Statement.(*parser.SelectStmt)
+	if !ok {
+		return nil, x.compiler.ErrorAt(stmt.Statement, pgerror.CodeFeatureNotSupported, "EXPLAIN is only supported for queries")
+	}
+	return x.compiler.Select(query, nil)
+}
@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplain(t *testing.T) {
+	e := newOrders(t)
+	result := execute(t, e, "explain select i.name, o.qty from items i join orders o on o.item_id = i.id where o.qty > 1 order by i.name")
+	assert.Equal(t, "EXPLAIN", result.CommandTag)
+	assert.Equal(t, [][]interface{}{
+		{"Sort"},
+		{"  Sort Key: i.name"},
+		{"  ->  Hash Join"},
+		{"        Hash Cond: (i.id = o.item_id)"},
+		{"        ->  Seq Scan on items i"},
+		{"        ->  Hash"},
+		{"              ->  Seq Scan on orders o"},
+		{"                    Filter: (o.qty > 1)"},
+	}, rows(t, result))
+
+	assert.Equal(t, [][]interface{}{
+		{"Nested Loop Left Join"},
+		{"  Join Filter: (i.stock > o.qty)"},
+		{"  ->  Seq Scan on items i"},
+		{"  ->  Seq Scan on orders o"},
+	}, rows(t, execute(t, e, "explain (costs off) select * from items i left join orders o on i.stock > o.qty")))
+
+	columns, err := e.Describe(context.Background(), "explain select 1")
+	require.NoError(t, err)
+	assert.Equal(t, "QUERY PLAN", columns[0].Name)
+	assert.EqualValues(t, pgtype.TextOID, columns[0].TypeOID)
+}
+
+func TestExplainErrors(t *testing.T) {
+	tests := []struct {
+		query    string
+		message  string
+		position int32
+	}{
+		{"explain analyze select 1", "EXPLAIN ANALYZE is not supported", 1},
+		{"explain (format json) select 1", "EXPLAIN format JSON is not supported", 1},
+		{"explain insert into items (name) values ('x')", "EXPLAIN is only supported for queries", 9},
+	}
+	e := newItems(t)
+	for _, test := range tests {
+		err := executeError(t, e, test.query)
+		assert.Equal(t, pgerror.CodeFeatureNotSupported, err.Code, test.query)
+		assert.Equal(t, test.message, err.Message, test.query)
+		assert.Equal(t, test.position, err.Position, test.query)
+	}
+	err := executeError(t, e, "explain select nope from items")
+	assert.Equal(t, `column "nope" does not exist`, err.Message)
+}
@@ -0,0 +1,40 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package engine
+
+import (
+	"github.com/patrickglass/dsql/sql/executor"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/session"
+)
+
+// query runs a SELECT or VALUES query. The plan is opened before the rows
+// are returned, so that errors such as a negative LIMIT are reported
+// before the server describes the rows. The rows stream to the client as
+// the server reads them.
+func (x *execution) query(stmt *parser.SelectStmt) (*session.Result, error) {
+	plan, err := x.compiler.Select(stmt, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := plan.Root.Open(x.env); err != nil {
+		_ = plan.Root.Close()
+		return nil, err
+	}
+	return &session.Result{Columns: plan.Columns, Rows: executor.NewRows(plan.Root)}, nil
+}
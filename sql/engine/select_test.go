@@ -0,0 +1,245 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newStock returns an engine with items in stock
+func newStock(t *testing.T) *Engine {
+	e := newItems(t)
+	execute(t, e, `insert into items (name, price, stock) values
+		('apple', 1.25, 10), ('pear', 2, null), ('fig', 0.5, 3), ('kiwi', null, 7)`)
+	return e
+}
+
+func TestSelectExpressions(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []interface{}
+	}{
+		{"select 1 + 2 * 3, 'a' || 'b', 7 / 2, 2 ^ 3", []interface{}{int64(7), "ab", int64(3), 8.0}},
+		{"select null = 1, true or null, false and null, not null::boolean", []interface{}{nil, true, false, nil}},
+		{"select case when 1 > 2 then 'x' when 2 > 1 then 'y' end, case 3 when 1 then 'one' else 'other' end, case when false then 1 end", []interface{}{"y", "other", nil}},
+		{"select coalesce(null, 2, 3), nullif(1, 1), greatest(1, 5.5, 3), least('b', 'a')", []interface{}{int64(2), nil, "5.5", "a"}},
+		{"select cast('42' as int) + 1, '3.5'::numeric * 2, 1::text || 'x'", []interface{}{int64(43), "7.0", "1x"}},
+		{`select 'Hello' like 'H%', 'Hello' ilike 'h_llo', 'a%' like 'a\%', 'x' not like 'y', null like 'a'`, []interface{}{true, true, true, true, nil}},
+		{"select 1 in (1, 2), 3 in (1, 2), 3 in (1, null), 3 not in (1, 2)", []interface{}{true, false, nil, true}},
+		{"select 2 between 1 and 3, 5 between symmetric 6 and 4, 5 not between 1 and 3", []interface{}{true, true, true}},
+		{"select 1 is distinct from null, null is not distinct from null, null is unknown, true is not false", []interface{}{true, true, true, true}},
+		{"select 2 = any (array[1, 2]), 2 > all (array[1, 3]), 1 = any ('{1,2}')", []interface{}{true, false, true}},
+		{"select upper('abc'), length('héllo'), substring('hello' from 2 for 3), position('l' in 'hello'), trim(both 'x' from 'xxaxx')", []interface{}{"ABC", int64(5), "ell", int64(3), "a"}},
+		{"select round(2.567, 2), abs(-3), sqrt(16), mod(7, 3), pg_catalog.pi() > 3", []interface{}{"2.57", int64(3), 4.0, int64(1), true}},
+		{"select extract(year from timestamp '2024-05-06 07:08:09'), concat('a', 1, null, true), concat_ws('-', 'a', 'b')", []interface{}{2024.0, "a1true", "a-b"}},
+		{"select array[1, 2], array[]::integer[], (select 1), exists (select 1 where false)", []interface{}{[]interface{}{int64(1), int64(2)}, []interface{}{}, int64(1), false}},
+	}
+	e := New()
+	for _, test := range tests {
+		assert.Equal(t, [][]interface{}{test.want}, rows(t, execute(t, e, test.query)), test.query)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		query string
+		want  [][]interface{}
+	}{
+		{"select name, price * stock from items where stock > 2 order by name", [][]interface{}{{"apple", "12.50"}, {"fig", "1.50"}, {"kiwi", nil}}},
+		{"select name from items order by price desc nulls last, name limit 2 offset 1", [][]interface{}{{"apple"}, {"fig"}}},
+		{"select name from items order by price", [][]interface{}{{"fig"}, {"apple"}, {"pear"}, {"kiwi"}}},
+		{"select name as n from items order by n desc limit 1", [][]interface{}{{"pear"}}},
+		{"select name from items order by stock nulls first, 1 offset 3", [][]interface{}{{"apple"}}},
+		{"select i.name from items as i where i.id = 2", [][]interface{}{{"pear"}}},
+		{"select n from items as i (id, n) where id = 3", [][]interface{}{{"fig"}}},
+		{"select distinct stock is null from items order by 1", [][]interface{}{{false}, {true}}},
+		{"select distinct on (stock is null) name from items order by stock is null, name", [][]interface{}{{"apple"}, {"pear"}}},
+		{"select name from items where name like '%i%' order by 1 limit null", [][]interface{}{{"fig"}, {"kiwi"}}},
+		{"select count from (select stock as count from items) as s where count < 5", [][]interface{}{{int64(3)}}},
+		{"values (1, 'a'), (2.5, null) order by 1 desc", [][]interface{}{{"2.5", nil}, {"1", "a"}}},
+		{"select * from (values (1, 'a'), (2, 'b')) as v (n, s) where n > 1", [][]interface{}{{int64(2), "b"}}},
+		{"select 1 where false", nil},
+	}
+	e := newStock(t)
+	for _, test := range tests {
+		assert.Equal(t, test.want, rows(t, execute(t, e, test.query)), test.query)
+	}
+}
+
+func TestSelectSubqueries(t *testing.T) {
+	tests := []struct {
+		query string
+		want  [][]interface{}
+	}{
+		{"select name from items i where exists (select 1 from items j where j.price > i.price) order by name", [][]interface{}{{"apple"}, {"fig"}}},
+		{"select name from items where id in (select id from items where stock is null)", [][]interface{}{{"pear"}}},
+		{"select name from items where id not in (select id from items where stock is not null)", [][]interface{}{{"pear"}}},
+		{"select name from items where price > all (select price from items where name <> 'pear' and price is not null)", [][]interface{}{{"pear"}}},
+		{"select name, (select max_stock from (select i.stock * 2 as max_stock) s) from items i where id = 1", [][]interface{}{{"apple", int64(20)}}},
+		{"select name from items where stock = (select stock from items where name = 'fig')", [][]interface{}{{"fig"}}},
+		{"select (select name from items where stock > 100)", [][]interface{}{{nil}}},
+	}
+	e := newStock(t)
+	for _, test := range tests {
+		assert.Equal(t, test.want, rows(t, execute(t, e, test.query)), test.query)
+	}
+}
+
+// newOrders returns an engine with items in stock and orders of them
+func newOrders(t *testing.T) *Engine {
+	e := newStock(t)
+	execute(t, e,
+		"create table orders (id serial primary key, item_id int, qty int)",
+		"insert into orders (item_id, qty) values (1, 2), (1, 3), (3, 1), (9, 5)",
+	)
+	return e
+}
+
+func TestSelectJoins(t *testing.T) {
+	tests := []struct {
+		query string
+		want  [][]interface{}
+	}{
+		{"select i.name, o.qty from items i join orders o on o.item_id = i.id order by 1, 2", [][]interface{}{{"apple", int64(2)}, {"apple", int64(3)}, {"fig", int64(1)}}},
+		{"select i.name, o.qty from items i left join orders o on o.item_id = i.id where i.stock is not null order by 1, 2", [][]interface{}{{"apple", int64(2)}, {"apple", int64(3)}, {"fig", int64(1)}, {"kiwi", nil}}},
+		{"select i.name, o.id from items i right join orders o on o.item_id = i.id order by 2", [][]interface{}{{"apple", int64(1)}, {"apple", int64(2)}, {"fig", int64(3)}, {nil, int64(4)}}},
+		{"select i.name, o.id from items i full join orders o on o.item_id = i.id where i.name is null or o.id is null order by 1, 2", [][]interface{}{{"kiwi", nil}, {"pear", nil}, {nil, int64(4)}}},
+		{"select i.name, o.qty, p.name from items i, orders o, items p where o.item_id = i.id and p.stock = o.qty * 10 / 3 order by 1, 2", [][]interface{}{{"apple", int64(3), "apple"}, {"fig", int64(1), "fig"}}},
+		{"select name from items where exists (select from orders where item_id = items.id and qty > 2)", [][]interface{}{{"apple"}}},
+		{"select name from items i where not exists (select from orders o where o.item_id = i.id) order by 1", [][]interface{}{{"kiwi"}, {"pear"}}},
+		{"select name from items i where exists (select from orders o join items p on p.id = o.item_id and p.id = i.id, items z where z.id = 1) order by 1", [][]interface{}{{"apple"}, {"fig"}}},
+		{"select i.name, l.total from items i cross join lateral (select i.stock * i.price as total) l where l.total > 2 order by 1", [][]interface{}{{"apple", "12.50"}}},
+	}
+	e := newOrders(t)
+	for _, test := range tests {
+		assert.Equal(t, test.want, rows(t, execute(t, e, test.query)), test.query)
+	}
+}
+
+func TestSelectAggregates(t *testing.T) {
+	tests := []struct {
+		query string
+		want  [][]interface{}
+	}{
+		{"select i.name, sum(o.qty), count(*) from orders o join items i on i.id = o.item_id group by i.name order by 1", [][]interface{}{{"apple", int64(5), int64(2)}, {"fig", int64(1), int64(1)}}},
+		{"select item_id from orders group by item_id having sum(qty) > 2 order by 1", [][]interface{}{{int64(1)}, {int64(9)}}},
+		{"select avg(price), min(price), max(name), percentile_cont(0.5) within group (order by stock), percentile_disc(0.5) within group (order by stock) from items", [][]interface{}{{"1.25000000000000000000", "0.50", "pear", 7.0, int64(7)}}},
+		{"select string_agg(name, ', ' order by name desc), bool_and(stock > 2), bool_or(price > 1) from items", [][]interface{}{{"pear, kiwi, fig, apple", true, true}}},
+		{"select array_agg(distinct item_id order by item_id), json_agg(qty order by id) filter (where qty > 1) from orders", [][]interface{}{{[]interface{}{int64(1), int64(3), int64(9)}, "[2, 3, 5]"}}},
+		{"select i.name, o.qty > 1 as bulk, sum(o.qty) from orders o join items i on i.id = o.item_id group by rollup (i.name, bulk) order by 1, 2", [][]interface{}{
+			{"apple", true, int64(5)}, {"apple", nil, int64(5)}, {"fig", false, int64(1)}, {"fig", nil, int64(1)}, {nil, nil, int64(6)},
+		}},
+		{"select item_id, count(*), sum(qty), grouping(item_id) from orders group by cube (item_id) order by 1", [][]interface{}{
+			{int64(1), int64(2), int64(5), int64(0)}, {int64(3), int64(1), int64(1), int64(0)}, {int64(9), int64(1), int64(5), int64(0)}, {nil, int64(4), int64(11), int64(1)},
+		}},
+		{"select item_id, qty, count(*) from orders where item_id = 1 group by grouping sets ((item_id), (qty)) order by 1, 2", [][]interface{}{
+			{int64(1), nil, int64(2)}, {nil, int64(2), int64(1)}, {nil, int64(3), int64(1)},
+		}},
+	}
+	e := newOrders(t)
+	for _, test := range tests {
+		assert.Equal(t, test.want, rows(t, execute(t, e, test.query)), test.query)
+	}
+}
+
+func TestSelectErrors(t *testing.T) {
+	tests := []struct {
+		query    string
+		code     string
+		message  string
+		position int32
+	}{
+		{"select nope from items", pgerror.CodeUndefinedColumn, `column "nope" does not exist`, 8},
+		{"select name, count(*) from items", pgerror.CodeGroupingError, `column "items.name" must appear in the GROUP BY clause or be used in an aggregate function`, 8},
+		{"select * from missing", pgerror.CodeUndefinedTable, `relation "missing" does not exist`, 15},
+		{"select name from items order by 2", pgerror.CodeInvalidColumnReference, "ORDER BY position 2 is not in select list", 33},
+		{"select distinct name from items order by price", pgerror.CodeInvalidColumnReference, "for SELECT DISTINCT, ORDER BY expressions must appear in select list", 42},
+		{"select distinct on (name) name from items order by price", pgerror.CodeInvalidColumnReference, "SELECT DISTINCT ON expressions must match initial ORDER BY expressions", 52},
+		{"select 1 limit -1", pgerror.CodeInvalidRowCountInLimitClause, "LIMIT must not be negative", 0},
+		{"select 1 offset -1", pgerror.CodeInvalidRowCountInResultOffset, "OFFSET must not be negative", 0},
+		{"select 1 limit 'a'", pgerror.CodeInvalidTextRepresentation, `invalid input syntax for type bigint: "a"`, 16},
+		{"select (select id, name from items)", pgerror.CodeSyntaxError, "subquery must return only one column", 8},
+		{"select 1 in (select id, name from items)", pgerror.CodeSyntaxError, "subquery has too many columns", 10},
+		{"select case when true then 1 else 'a' || 'b' end", pgerror.CodeDatatypeMismatch, "CASE types integer and text cannot be matched", 39},
+		{"select 1 like 'a'", pgerror.CodeUndefinedFunction, "operator does not exist: integer ~~ unknown", 10},
+		{"select lower(1)", pgerror.CodeUndefinedFunction, "function lower(integer) does not exist", 8},
+		{"select lower(distinct 'a')", pgerror.CodeWrongObjectType, "DISTINCT specified, but lower is not an aggregate function", 8},
+		{"select array[]", pgerror.CodeIndeterminateDatatype, "cannot determine type of empty array", 8},
+		{"select 1 where 1", pgerror.CodeDatatypeMismatch, "argument of WHERE must be type boolean, not type integer", 16},
+	}
+	e := newStock(t)
+	for _, test := range tests {
+		err := executeError(t, e, test.query)
+		assert.Equal(t, test.code, err.Code, test.query)
+		assert.Equal(t, test.message, err.Message, test.query)
+		assert.Equal(t, test.position, err.Position, test.query)
+	}
+}
+
+func TestSelectStreamsRows(t *testing.T) {
+	e := newStock(t)
+	result := execute(t, e, "select name, 10 / (stock - 3) from items")
+	require.True(t, result.Rows.Next())
+	assert.Equal(t, []interface{}{"apple", int64(1)}, result.Rows.Values())
+	// pear has no stock
+	require.True(t, result.Rows.Next())
+	assert.Equal(t, []interface{}{"pear", nil}, result.Rows.Values())
+	// the error of the row of fig ends the rows
+	assert.False(t, result.Rows.Next())
+	assert.Equal(t, pgerror.CodeDivisionByZero, errorCode(result.Rows.Err()))
+	assert.NoError(t, result.Rows.Close())
+	assert.NoError(t, result.Rows.Close())
+
+	result = execute(t, e, "select (select id from items)")
+	assert.False(t, result.Rows.Next())
+	assert.EqualError(t, result.Rows.Err(), "ERROR: more than one row returned by a subquery used as an expression (SQLSTATE 21000)")
+	assert.NoError(t, result.Rows.Close())
+}
+
+func TestSelectCanceled(t *testing.T) {
+	e := newStock(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	result, err := e.Execute(ctx, "select name from items", nil)
+	require.NoError(t, err)
+	require.True(t, result.Rows.Next())
+	cancel()
+	assert.False(t, result.Rows.Next())
+	assert.Equal(t, context.Canceled, result.Rows.Err())
+	assert.NoError(t, result.Rows.Close())
+}
+
+func TestSelectDescribe(t *testing.T) {
+	e := newItems(t)
+	table, _ := e.Catalog().Table("public", "items")
+	columns, err := e.Describe(context.Background(), "select id, name || $1 as label, price * 2 from items where id = $2")
+	require.NoError(t, err)
+	assert.Equal(t, []pgtype.Column{
+		{Name: "id", TypeOID: pgtype.Int4OID, TypeSize: 4, TypeModifier: -1, TableOID: table.OID, AttributeNumber: 1},
+		{Name: "label", TypeOID: pgtype.TextOID, TypeSize: -1, TypeModifier: -1},
+		{Name: "?column?", TypeOID: pgtype.NumericOID, TypeSize: -1, TypeModifier: -1},
+	}, columns)
+
+	columns, err = e.Describe(context.Background(), "select count(*), sum(stock), avg(stock) from items")
+	require.NoError(t, err)
+	assert.Equal(t, []pgtype.Column{
+		{Name: "count", TypeOID: pgtype.Int8OID, TypeSize: 8, TypeModifier: -1},
+		{Name: "sum", TypeOID: pgtype.Int8OID, TypeSize: 8, TypeModifier: -1},
+		{Name: "avg", TypeOID: pgtype.NumericOID, TypeSize: -1, TypeModifier: -1},
+	}, columns)
+}
+
+func TestInsertSelect(t *testing.T) {
+	e := newStock(t)
+	result := execute(t, e, "insert into items (name, stock) select upper(name), stock * 2 from items where stock > 5 returning name, price, stock")
+	assert.Equal(t, "INSERT 0 2", result.CommandTag)
+	assert.Equal(t, [][]interface{}{{"APPLE", "1.50", int64(20)}, {"KIWI", "1.50", int64(14)}}, rows(t, result))
+	assert.Equal(t, [][]interface{}{{"APPLE"}, {"KIWI"}}, rows(t, execute(t, e, "select name from items where id > 4 order by id")))
+
+	err := executeError(t, e, "insert into items (name) select name from items")
+	assert.Equal(t, pgerror.CodeUniqueViolation, err.Code)
+	assert.Len(t, tableRows(t, e, "items"), 6)
+}
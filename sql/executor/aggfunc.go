@@ -0,0 +1,559 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package executor
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// AggregateFunc is an overload of an aggregate function, computing a value
+// from the arguments of the rows of a group. Parameters of type unknown
+// take arguments of any type.
+type AggregateFunc struct {
+	Function
+	// OrderedSet aggregates such as percentile_cont take the values of
+	// the expression of WITHIN GROUP as their last argument, sorted
+	OrderedSet bool
+	newState   func(args []types.Type) aggState
+}
+
+// aggState is the state of an aggregate for a group
+type aggState interface {
+	// add adds the arguments of a row
+	add(args []interface{}) error
+	// result returns the value of the aggregate for the rows added
+	result() (interface{}, error)
+}
+
+// aggregates holds the overloads of the aggregate functions by name, the
+// preferred overload first
+var aggregates = make(map[string][]*AggregateFunc)
+
+// registerAggregate adds aggregate functions to the library
+func registerAggregate(fns ...*AggregateFunc) {
+	for _, f := range fns {
+		aggregates[f.Name] = append(aggregates[f.Name], f)
+	}
+}
+
+// Aggregates returns the overloads of the aggregate function with the
+// name, nil when there is no such aggregate
+func Aggregates(name string) []*AggregateFunc {
+	return aggregates[name]
+}
+
+// agg returns an aggregate function taking the parameters, the rows whose
+// arguments are NULL are skipped
+func agg(name string, params []types.Type, result types.Type, newState func(args []types.Type) aggState) *AggregateFunc {
+	return &AggregateFunc{Function: Function{Name: name, Params: params, Result: result}, newState: newState}
+}
+
+// withNulls makes the aggregate add the rows whose arguments are NULL
+func withNulls(f *AggregateFunc) *AggregateFunc {
+	f.NullArgs = true
+	return f
+}
+
+// orderedSet makes the aggregate take its last argument from WITHIN GROUP
+func orderedSet(f *AggregateFunc) *AggregateFunc {
+	f.OrderedSet = true
+	return f
+}
+
+// anyArgs makes the aggregate take arguments of any type for its
+// parameters of type unknown
+func anyArgs(f *AggregateFunc) *AggregateFunc {
+	f.AnyArgs = true
+	return f
+}
+
+// sortableTypes are the types of the arguments of min, max and
+// percentile_disc, the type of unknown arguments first
+var sortableTypes = []types.Type{
+	types.Text, types.BPChar, types.Int2, types.Int4, types.Int8, types.Numeric, types.Float4, types.Float8,
+	types.Date, types.Timestamp, types.Timestamptz, types.Interval, types.UUID,
+}
+
+// elementTypes are the types array_agg collects into arrays
+var elementTypes = []types.Type{
+	types.Text, types.Bool, types.Bytea, types.Name, types.Int2, types.Int4, types.Int8, types.Numeric,
+	types.Float4, types.Float8, types.BPChar, types.Varchar, types.Date, types.Timestamp, types.Timestamptz,
+	types.Interval, types.UUID, types.JSON, types.JSONB,
+}
+
+func init() {
+	registerAggregate(
+		agg("count", nil, types.Int8, newCount),
+		anyArgs(agg("count", params(types.Unknown), types.Int8, newCount)),
+		agg("sum", params(types.Numeric), types.Numeric, newNumericSum(false)),
+		agg("sum", params(types.Int2), types.Int8, newIntSum),
+		agg("sum", params(types.Int4), types.Int8, newIntSum),
+		agg("sum", params(types.Int8), types.Numeric, newBigSum(false)),
+		agg("sum", params(types.Float4), types.Float4, newFloatSum(types.Float4, false)),
+		agg("sum", params(types.Float8), types.Float8, newFloatSum(types.Float8, false)),
+		agg("sum", params(types.Interval), types.Interval, newIntervalSum(false)),
+		agg("avg", params(types.Numeric), types.Numeric, newNumericSum(true)),
+		agg("avg", params(types.Int2), types.Numeric, newBigSum(true)),
+		agg("avg", params(types.Int4), types.Numeric, newBigSum(true)),
+		agg("avg", params(types.Int8), types.Numeric, newBigSum(true)),
+		agg("avg", params(types.Float4), types.Float8, newFloatSum(types.Float8, true)),
+		agg("avg", params(types.Float8), types.Float8, newFloatSum(types.Float8, true)),
+		agg("avg", params(types.Interval), types.Interval, newIntervalSum(true)),
+		agg("bool_and", params(types.Bool), types.Bool, newBoolAgg(true)),
+		agg("every", params(types.Bool), types.Bool, newBoolAgg(true)),
+		agg("bool_or", params(types.Bool), types.Bool, newBoolAgg(false)),
+		withNulls(agg("string_agg", params(types.Text, types.Text), types.Text, newStringAgg)),
+		withNulls(agg("string_agg", params(types.Bytea, types.Bytea), types.Bytea, newStringAgg)),
+		anyArgs(withNulls(agg("json_agg", params(types.Unknown), types.JSON, newJSONAgg))),
+	)
+	for _, t := range sortableTypes {
+		registerAggregate(
+			agg("min", params(t), t, newExtremum(false)),
+			agg("max", params(t), t, newExtremum(true)),
+			orderedSet(agg("percentile_disc", params(types.Float8, t), t, newPercentile(false))),
+		)
+	}
+	for _, t := range elementTypes {
+		array, _ := types.ArrayOf(t)
+		registerAggregate(withNulls(agg("array_agg", params(t), array, newArrayAgg)))
+	}
+	registerAggregate(orderedSet(agg("percentile_cont", params(types.Float8, types.Float8), types.Float8, newPercentile(true))))
+}
+
+// countState counts the rows added
+type countState struct {
+	n int64
+}
+
+func newCount([]types.Type) aggState { return &countState{} }
+
+func (s *countState) add([]interface{}) error {
+	s.n++
+	return nil
+}
+
+func (s *countState) result() (interface{}, error) { return s.n, nil }
+
+// intSum sums smallints and integers in a bigint
+type intSum struct {
+	sum int64
+	n   int64
+}
+
+func newIntSum([]types.Type) aggState { return &intSum{} }
+
+func (s *intSum) add(args []interface{}) error {
+	v := args[0].(int64)
+	sum := s.sum + v
+	if (v > 0 && sum < s.sum) || (v < 0 && sum > s.sum) {
+		return errOutOfRange(types.Int8)
+	}
+	s.sum, s.n = sum, s.n+1
+	return nil
+}
+
+func (s *intSum) result() (interface{}, error) {
+	if s.n == 0 {
+		return nil, nil
+	}
+	return s.sum, nil
+}
+
+// bigSum sums integers exactly into a numeric, avg divides the sum by the
+// number of values
+type bigSum struct {
+	sum big.Int
+	n   int64
+	avg bool
+}
+
+func newBigSum(avg bool) func([]types.Type) aggState {
+	return func([]types.Type) aggState { return &bigSum{avg: avg} }
+}
+
+func (s *bigSum) add(args []interface{}) error {
+	s.sum.Add(&s.sum, big.NewInt(args[0].(int64)))
+	s.n++
+	return nil
+}
+
+func (s *bigSum) result() (interface{}, error) {
+	if s.n == 0 {
+		return nil, nil
+	}
+	if s.avg {
+		return arithNumeric("/", s.sum.String(), strconv.FormatInt(s.n, 10))
+	}
+	return s.sum.String(), nil
+}
+
+// numericSum sums numerics, avg divides the sum by the number of values
+type numericSum struct {
+	sum string
+	n   int64
+	avg bool
+}
+
+func newNumericSum(avg bool) func([]types.Type) aggState {
+	return func([]types.Type) aggState { return &numericSum{sum: "0", avg: avg} }
+}
+
+func (s *numericSum) add(args []interface{}) error {
+	sum, err := arithNumeric("+", s.sum, args[0].(string))
+	if err != nil {
+		return err
+	}
+	s.sum, s.n = sum.(string), s.n+1
+	return nil
+}
+
+func (s *numericSum) result() (interface{}, error) {
+	if s.n == 0 {
+		return nil, nil
+	}
+	if s.avg {
+		return arithNumeric("/", s.sum, strconv.FormatInt(s.n, 10))
+	}
+	return s.sum, nil
+}
+
+// floatSum sums floating point numbers, avg divides the sum by the number
+// of values
+type floatSum struct {
+	typ types.Type
+	sum float64
+	n   int64
+	avg bool
+}
+
+func newFloatSum(t types.Type, avg bool) func([]types.Type) aggState {
+	return func([]types.Type) aggState { return &floatSum{typ: t, avg: avg} }
+}
+
+func (s *floatSum) add(args []interface{}) error {
+	s.sum += args[0].(float64)
+	s.n++
+	return nil
+}
+
+func (s *floatSum) result() (interface{}, error) {
+	switch {
+	case s.n == 0:
+		return nil, nil
+	case s.avg:
+		return s.sum / float64(s.n), nil
+	case s.typ == types.Float4:
+		return float64(float32(s.sum)), nil
+	}
+	return s.sum, nil
+}
+
+// intervalSum sums intervals field by field, avg divides the sum by the
+// number of values
+type intervalSum struct {
+	sum pgtype.Interval
+	n   int64
+	avg bool
+}
+
+func newIntervalSum(avg bool) func([]types.Type) aggState {
+	return func([]types.Type) aggState { return &intervalSum{avg: avg} }
+}
+
+func (s *intervalSum) add(args []interface{}) error {
+	v := args[0].(pgtype.Interval)
+	s.sum.Months += v.Months
+	s.sum.Days += v.Days
+	s.sum.Microseconds += v.Microseconds
+	s.n++
+	return nil
+}
+
+func (s *intervalSum) result() (interface{}, error) {
+	if s.n == 0 {
+		return nil, nil
+	}
+	if !s.avg {
+		return s.sum, nil
+	}
+	// fractions of months become days of 30 days and fractions of days
+	// become time like postgres
+	n := float64(s.n)
+	months := float64(s.sum.Months) / n
+	days := float64(s.sum.Days)/n + (months-math.Trunc(months))*30
+	micros := float64(s.sum.Microseconds)/n + (days-math.Trunc(days))*float64(24*time.Hour/time.Microsecond)
+	return pgtype.Interval{Months: int32(months), Days: int32(days), Microseconds: int64(math.Round(micros))}, nil
+}
+
+// extremum keeps the least or the greatest value
+type extremum struct {
+	typ   types.Type
+	max   bool
+	value interface{}
+}
+
+func newExtremum(max bool) func([]types.Type) aggState {
+	return func(args []types.Type) aggState { return &extremum{typ: args[0], max: max} }
+}
+
+func (s *extremum) add(args []interface{}) error {
+	if s.value == nil {
+		s.value = args[0]
+		return nil
+	}
+	c := types.Compare(s.typ, args[0], s.value)
+	if s.max && c > 0 || !s.max && c < 0 {
+		s.value = args[0]
+	}
+	return nil
+}
+
+func (s *extremum) result() (interface{}, error) { return s.value, nil }
+
+// boolAgg is true when all the values are true for bool_and and when any
+// is true for bool_or
+type boolAgg struct {
+	and   bool
+	value interface{}
+}
+
+func newBoolAgg(and bool) func([]types.Type) aggState {
+	return func([]types.Type) aggState { return &boolAgg{and: and} }
+}
+
+func (s *boolAgg) add(args []interface{}) error {
+	v := args[0].(bool)
+	if s.value == nil {
+		s.value = v
+	} else if s.and {
+		s.value = s.value.(bool) && v
+	} else {
+		s.value = s.value.(bool) || v
+	}
+	return nil
+}
+
+func (s *boolAgg) result() (interface{}, error) { return s.value, nil }
+
+// stringAgg concatenates the values which are not NULL separated by the
+// delimiter of each value but the first
+type stringAgg struct {
+	buf   []byte
+	bytes bool
+	any   bool
+}
+
+func newStringAgg(args []types.Type) aggState {
+	return &stringAgg{bytes: args[0].OID == pgtype.ByteaOID}
+}
+
+func (s *stringAgg) add(args []interface{}) error {
+	if args[0] == nil {
+		return nil
+	}
+	if s.any && args[1] != nil {
+		s.buf = appendBytes(s.buf, args[1])
+	}
+	s.buf, s.any = appendBytes(s.buf, args[0]), true
+	return nil
+}
+
+// appendBytes appends a text or bytea value
+func appendBytes(buf []byte, v interface{}) []byte {
+	if b, ok := v.([]byte); ok {
+		return append(buf, b...)
+	}
+	return append(buf, v.(string)...)
+}
+
+func (s *stringAgg) result() (interface{}, error) {
+	switch {
+	case !s.any:
+		return nil, nil
+	case s.bytes:
+		return s.buf, nil
+	}
+	return string(s.buf), nil
+}
+
+// arrayAgg collects the values into an array, including NULLs
+type arrayAgg struct {
+	elems []interface{}
+}
+
+func newArrayAgg([]types.Type) aggState { return &arrayAgg{} }
+
+func (s *arrayAgg) add(args []interface{}) error {
+	s.elems = append(s.elems, args[0])
+	return nil
+}
+
+func (s *arrayAgg) result() (interface{}, error) {
+	if s.elems == nil {
+		return nil, nil
+	}
+	return s.elems, nil
+}
+
+// jsonAgg collects the values into a JSON array, including NULLs
+type jsonAgg struct {
+	typ types.Type
+	buf []byte
+}
+
+func newJSONAgg(args []types.Type) aggState { return &jsonAgg{typ: args[0]} }
+
+func (s *jsonAgg) add(args []interface{}) error {
+	if s.buf == nil {
+		s.buf = append(s.buf, '[')
+	} else {
+		s.buf = append(s.buf, ", "...)
+	}
+	var err error
+	s.buf, err = appendJSON(s.buf, s.typ, args[0])
+	return err
+}
+
+func (s *jsonAgg) result() (interface{}, error) {
+	if s.buf == nil {
+		return nil, nil
+	}
+	return string(append(s.buf, ']')), nil
+}
+
+// appendJSON appends the JSON representation of a value like postgres'
+// to_json
+func appendJSON(buf []byte, t types.Type, v interface{}) ([]byte, error) {
+	if v == nil {
+		return append(buf, "null"...), nil
+	}
+	if t.IsArray() {
+		buf = append(buf, '[')
+		for i, elem := range v.([]interface{}) {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			var err error
+			if buf, err = appendJSON(buf, t.Elem(), elem); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, ']'), nil
+	}
+	switch v := v.(type) {
+	case time.Time:
+		switch t.OID {
+		case pgtype.DateOID:
+			return appendJSONString(buf, v.Format("2006-01-02")), nil
+		case pgtype.TimestampOID:
+			return appendJSONString(buf, v.Format("2006-01-02T15:04:05.999999")), nil
+		}
+		return appendJSONString(buf, v.Format("2006-01-02T15:04:05.999999-07:00")), nil
+	}
+	text, err := types.Cast(v, t, types.Text)
+	if err != nil {
+		return nil, err
+	}
+	s := text.(string)
+	switch t.Category() {
+	case types.CategoryBool, types.CategoryJSON:
+		return append(buf, s...), nil
+	case types.CategoryNumeric:
+		if _, ok := types.ParseNumeric(s); ok {
+			return append(buf, s...), nil
+		}
+		// NaN and the infinities are strings
+	}
+	return appendJSONString(buf, s), nil
+}
+
+// appendJSONString appends a JSON string literal
+func appendJSONString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '"' || r == '\\':
+			buf = append(buf, '\\', byte(r))
+		case r == '\n':
+			buf = append(buf, `\n`...)
+		case r == '\r':
+			buf = append(buf, `\r`...)
+		case r == '\t':
+			buf = append(buf, `\t`...)
+		case r == '\b':
+			buf = append(buf, `\b`...)
+		case r == '\f':
+			buf = append(buf, `\f`...)
+		case r < 0x20:
+			buf = append(buf, `\u00`...)
+			buf = append(buf, "0123456789abcdef"[r>>4], "0123456789abcdef"[r&0xf])
+		default:
+			buf = append(buf, s[i:i+size]...)
+		}
+		i += size
+	}
+	return append(buf, '"')
+}
+
+// percentile computes the value at a fraction of the sorted values,
+// interpolating between adjacent values for percentile_cont
+type percentile struct {
+	cont     bool
+	fraction float64
+	values   []interface{}
+}
+
+func newPercentile(cont bool) func([]types.Type) aggState {
+	return func([]types.Type) aggState { return &percentile{cont: cont} }
+}
+
+func (s *percentile) add(args []interface{}) error {
+	s.fraction = args[0].(float64)
+	s.values = append(s.values, args[1])
+	return nil
+}
+
+func (s *percentile) result() (interface{}, error) {
+	if len(s.values) == 0 {
+		return nil, nil
+	}
+	if s.fraction < 0 || s.fraction > 1 || math.IsNaN(s.fraction) {
+		return nil, pgerror.NewError(pgerror.CodeNumericValueOutOfRange, "percentile value %s is not between 0 and 1", strconv.FormatFloat(s.fraction, 'g', -1, 64))
+	}
+	n := float64(len(s.values))
+	if !s.cont {
+		i := int(math.Ceil(s.fraction*n)) - 1
+		if i < 0 {
+			i = 0
+		}
+		return s.values[i], nil
+	}
+	pos := s.fraction * (n - 1)
+	lo, hi := math.Floor(pos), math.Ceil(pos)
+	first, second := s.values[int(lo)].(float64), s.values[int(hi)].(float64)
+	return first + (pos-lo)*(second-first), nil
+}
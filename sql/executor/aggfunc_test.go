@@ -0,0 +1,151 @@
+package executor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// aggregateFunc returns the overload of the aggregate taking arguments
+// of the types
+func aggregateFunc(t *testing.T, name string, argTypes ...types.Type) *AggregateFunc {
+	for _, f := range Aggregates(name) {
+		if len(f.Params) != len(argTypes) {
+			continue
+		}
+		match := true
+		for i, p := range f.Params {
+			match = match && (p.OID == argTypes[i].OID || p.OID == types.UnknownOID)
+		}
+		if match {
+			return f
+		}
+	}
+	require.Fail(t, "no such aggregate", name)
+	return nil
+}
+
+// aggregateRows computes the aggregate over the rows of its arguments
+func aggregateRows(t *testing.T, name string, argTypes []types.Type, rows [][]interface{}) (interface{}, error) {
+	f := aggregateFunc(t, name, argTypes...)
+	exprs := make([][]Expr, len(rows))
+	args := make([]Expr, len(argTypes))
+	for i, row := range rows {
+		for j, v := range row {
+			exprs[i] = append(exprs[i], NewConst(v, argTypes[j]))
+		}
+	}
+	for i, at := range argTypes {
+		args[i] = NewColumn(i, "x", at)
+	}
+	call := &AggregateCall{Func: f, Args: args}
+	if f.OrderedSet {
+		call.OrderBy = []SortKey{{Expr: args[len(args)-1]}}
+	}
+	op := NewHashAggregate(NewValues(exprs), nil, [][]int{{}}, []*AggregateCall{call})
+	if err := op.Open(NewEnv(context.Background())); err != nil {
+		return nil, err
+	}
+	row, err := op.Next()
+	if err != nil {
+		return nil, err
+	}
+	return row[0], op.Close()
+}
+
+// aggregateOf computes the aggregate of a single argument over the values
+func aggregateOf(t *testing.T, name string, argType types.Type, values ...interface{}) interface{} {
+	rows := make([][]interface{}, len(values))
+	for i, v := range values {
+		rows[i] = []interface{}{v}
+	}
+	v, err := aggregateRows(t, name, []types.Type{argType}, rows)
+	require.NoError(t, err, name)
+	return v
+}
+
+func TestAggregateFunctions(t *testing.T) {
+	assert.Equal(t, int64(2), aggregateOf(t, "count", types.Text, "a", nil, "b"))
+	assert.Equal(t, int64(0), aggregateOf(t, "count", types.Text))
+	assert.Equal(t, int64(3), aggregateOf(t, "sum", types.Int4, int64(1), nil, int64(2)))
+	assert.Nil(t, aggregateOf(t, "sum", types.Int4, nil))
+	assert.Equal(t, "18446744073709551614", aggregateOf(t, "sum", types.Int8, int64(1<<63-1), int64(1<<63-1)))
+	assert.Equal(t, "3.75", aggregateOf(t, "sum", types.Numeric, "1.25", "2.5"))
+	assert.Equal(t, 0.75, aggregateOf(t, "sum", types.Float8, 0.5, 0.25))
+	assert.Equal(t, "1.5000000000000000", aggregateOf(t, "avg", types.Int4, int64(1), int64(2)))
+	assert.Equal(t, "2.0000000000000000", aggregateOf(t, "avg", types.Numeric, "1.5", "2.5"))
+	assert.Equal(t, 1.5, aggregateOf(t, "avg", types.Float8, 1.0, 2.0))
+	assert.Equal(t, pgtype.Interval{Months: 1, Days: 15}, aggregateOf(t, "avg", types.Interval, pgtype.Interval{Months: 1}, pgtype.Interval{Months: 2}))
+	assert.Equal(t, pgtype.Interval{Days: 1, Microseconds: 5}, aggregateOf(t, "sum", types.Interval, pgtype.Interval{Days: 1}, pgtype.Interval{Microseconds: 5}))
+	assert.Equal(t, "a", aggregateOf(t, "min", types.Text, "b", nil, "a"))
+	assert.Equal(t, int64(7), aggregateOf(t, "max", types.Int4, int64(7), int64(-1)))
+	assert.Equal(t, false, aggregateOf(t, "bool_and", types.Bool, true, false, nil))
+	assert.Equal(t, true, aggregateOf(t, "bool_or", types.Bool, true, false))
+	assert.Nil(t, aggregateOf(t, "bool_or", types.Bool))
+	assert.Equal(t, []interface{}{int64(1), nil}, aggregateOf(t, "array_agg", types.Int4, int64(1), nil))
+	assert.Nil(t, aggregateOf(t, "array_agg", types.Int4))
+	assert.Equal(t, "[1, null, 2]", aggregateOf(t, "json_agg", types.Int4, int64(1), nil, int64(2)))
+	assert.Equal(t, `["a\"b", "c\n"]`, aggregateOf(t, "json_agg", types.Text, `a"b`, "c\n"))
+	assert.Nil(t, aggregateOf(t, "json_agg", types.Text))
+
+	sum := newIntSum(nil)
+	require.NoError(t, sum.add([]interface{}{int64(1<<63 - 1)}))
+	assert.Error(t, sum.add([]interface{}{int64(1)}))
+}
+
+func TestStringAgg(t *testing.T) {
+	text := []types.Type{types.Text, types.Text}
+	v, err := aggregateRows(t, "string_agg", text, [][]interface{}{{"a", ", "}, {nil, ";"}, {"b", nil}, {"c", "-"}})
+	require.NoError(t, err)
+	assert.Equal(t, "ab-c", v)
+	v, err = aggregateRows(t, "string_agg", []types.Type{types.Bytea, types.Bytea}, [][]interface{}{{[]byte{1}, []byte{0}}, {[]byte{2}, []byte{0}}})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 0, 2}, v)
+	v, err = aggregateRows(t, "string_agg", text, nil)
+	require.NoError(t, err)
+	assert.Nil(t, v)
+}
+
+func TestPercentile(t *testing.T) {
+	rows := [][]interface{}{{0.5, 4.0}, {0.5, 1.0}, {0.5, nil}, {0.5, 2.0}, {0.5, 3.0}}
+	v, err := aggregateRows(t, "percentile_cont", []types.Type{types.Float8, types.Float8}, rows)
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, v)
+	v, err = aggregateRows(t, "percentile_disc", []types.Type{types.Float8, types.Float8}, rows)
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, v)
+	_, err = aggregateRows(t, "percentile_cont", []types.Type{types.Float8, types.Float8}, [][]interface{}{{1.5, 1.0}})
+	require.Error(t, err)
+	assert.Equal(t, "percentile value 1.5 is not between 0 and 1", err.(*pgerror.Error).Message)
+}
+
+func TestAppendJSON(t *testing.T) {
+	array, _ := types.ArrayOf(types.Int4)
+	tests := []struct {
+		typ   types.Type
+		value interface{}
+		want  string
+	}{
+		{types.Bool, true, "true"},
+		{types.Numeric, "1.50", "1.50"},
+		{types.Numeric, "NaN", `"NaN"`},
+		{types.Float8, 0.5, "0.5"},
+		{types.JSON, `{"a": 1}`, `{"a": 1}`},
+		{types.Text, "tab\tand\x01", `"tab\tand\u0001"`},
+		{types.Date, time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC), `"2021-01-02"`},
+		{types.Timestamp, time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC), `"2021-01-02T03:04:05"`},
+		{types.Timestamptz, time.Date(2021, 1, 2, 3, 4, 5, 6000, time.UTC), `"2021-01-02T03:04:05.000006+00:00"`},
+		{array, []interface{}{int64(1), nil}, "[1,null]"},
+	}
+	for _, test := range tests {
+		got, err := appendJSON(nil, test.typ, test.value)
+		require.NoError(t, err)
+		assert.Equal(t, test.want, string(got))
+	}
+}
@@ -0,0 +1,414 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package executor
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// AggregateCall is a call of an aggregate function in a query, its
+// arguments are evaluated for the rows of each group
+type AggregateCall struct {
+	Func *AggregateFunc
+	Args []Expr
+	// Distinct calls add each distinct list of arguments once
+	Distinct bool
+	// Filter skips the rows for which it is not true unless it is nil
+	Filter Expr
+	// OrderBy sorts the rows before they are added, it holds the keys of
+	// ORDER BY in the arguments or of WITHIN GROUP
+	OrderBy []SortKey
+}
+
+func (a *AggregateCall) String() string {
+	args := a.Args
+	if a.Func.OrderedSet {
+		args = args[:len(args)-1]
+	}
+	var b strings.Builder
+	b.WriteString(a.Func.Name + "(")
+	if a.Distinct {
+		b.WriteString("DISTINCT ")
+	}
+	for i, arg := range args {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(arg.String())
+	}
+	if len(args) == 0 && !a.Func.OrderedSet {
+		b.WriteString("*")
+	}
+	if len(a.OrderBy) > 0 && !a.Func.OrderedSet {
+		b.WriteString(" ORDER BY " + sortKeysString(a.OrderBy))
+	}
+	b.WriteString(")")
+	if a.Func.OrderedSet {
+		b.WriteString(" WITHIN GROUP (ORDER BY " + sortKeysString(a.OrderBy) + ")")
+	}
+	if a.Filter != nil {
+		b.WriteString(" FILTER (WHERE " + a.Filter.String() + ")")
+	}
+	return b.String()
+}
+
+// accumulator adds the rows of a group to the state of an aggregate call,
+// it holds them back until the result is computed when they are sorted
+type accumulator struct {
+	call  *AggregateCall
+	state aggState
+	rows  []sortedRow
+	seen  map[string]struct{}
+}
+
+func newAccumulator(call *AggregateCall) *accumulator {
+	argTypes := make([]types.Type, len(call.Args))
+	for i, arg := range call.Args {
+		argTypes[i] = arg.Type()
+	}
+	a := &accumulator{call: call, state: call.Func.newState(argTypes)}
+	if call.Distinct {
+		a.seen = make(map[string]struct{})
+	}
+	return a
+}
+
+func (a *accumulator) add(env *Env, row Row) error {
+	if a.call.Filter != nil {
+		v, err := a.call.Filter.Eval(env, row)
+		if err != nil || v != true {
+			return err
+		}
+	}
+	args, err := evalAll(env, a.call.Args, row)
+	if err != nil {
+		return err
+	}
+	if !a.call.Func.NullArgs {
+		for _, v := range args {
+			if v == nil {
+				return nil
+			}
+		}
+	}
+	if a.seen != nil {
+		var key []byte
+		for i, v := range args {
+			key = types.AppendKey(key, a.call.Args[i].Type(), v)
+		}
+		if _, ok := a.seen[string(key)]; ok {
+			return nil
+		}
+		a.seen[string(key)] = struct{}{}
+	}
+	if len(a.call.OrderBy) == 0 {
+		return a.state.add(args)
+	}
+	keys, err := evalKeys(env, a.call.OrderBy, row)
+	if err != nil {
+		return err
+	}
+	a.rows = append(a.rows, sortedRow{row: args, keys: keys})
+	return nil
+}
+
+func (a *accumulator) result() (interface{}, error) {
+	sort.SliceStable(a.rows, func(i, j int) bool {
+		return compareKeys(a.call.OrderBy, a.rows[i].keys, a.rows[j].keys) < 0
+	})
+	for _, r := range a.rows {
+		if err := a.state.add(r.row); err != nil {
+			return nil, err
+		}
+	}
+	a.rows = nil
+	return a.state.result()
+}
+
+// group is a group of rows and the state of its aggregate calls
+type group struct {
+	// keys holds the values of the grouping keys, NULL for the keys not
+	// in the grouping set of the group
+	keys Row
+	set  int
+	accs []*accumulator
+}
+
+// aggregator computes aggregate calls for groups of rows. A row of a
+// group holds the values of the keys, those of the calls and a bigint
+// mask whose bit i is set when key i is not in the grouping set.
+type aggregator struct {
+	input Operator
+	keys  []Expr
+	sets  [][]int
+	calls []*AggregateCall
+	masks []int64
+	env   *Env
+}
+
+func newAggregator(input Operator, keys []Expr, sets [][]int, calls []*AggregateCall) aggregator {
+	masks := make([]int64, len(sets))
+	for i, set := range sets {
+		masks[i] = 1<<uint(len(keys)) - 1
+		for _, k := range set {
+			masks[i] &^= 1 << uint(k)
+		}
+	}
+	return aggregator{input: input, keys: keys, sets: sets, calls: calls, masks: masks}
+}
+
+// newGroup returns a group of the grouping set with the values of the keys
+func (a *aggregator) newGroup(set int, keys Row) *group {
+	g := &group{keys: make(Row, len(a.keys)), set: set, accs: make([]*accumulator, len(a.calls))}
+	for _, k := range a.sets[set] {
+		g.keys[k] = keys[k]
+	}
+	for i, call := range a.calls {
+		g.accs[i] = newAccumulator(call)
+	}
+	return g
+}
+
+func (a *aggregator) add(g *group, row Row) error {
+	for _, acc := range g.accs {
+		if err := acc.add(a.env, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// output returns the row of a group
+func (a *aggregator) output(g *group) (Row, error) {
+	row := make(Row, 0, len(a.keys)+len(a.calls)+1)
+	row = append(row, g.keys...)
+	for _, acc := range g.accs {
+		v, err := acc.result()
+		if err != nil {
+			return nil, err
+		}
+		row = append(row, v)
+	}
+	return append(row, a.masks[g.set]), nil
+}
+
+// hashAggregate finds the group of each row in hash tables
+type hashAggregate struct {
+	aggregator
+	groups []*group
+	pos    int
+}
+
+// NewHashAggregate returns an operator grouping the rows of its input by
+// the keys of each grouping set, which lists positions of the keys. NULLs
+// are equal to each other. It reads all rows when it is opened and
+// returns the groups of each set in the order they were first seen, a set
+// without keys has a group even without rows.
+func NewHashAggregate(input Operator, keys []Expr, sets [][]int, calls []*AggregateCall) Operator {
+	return &hashAggregate{aggregator: newAggregator(input, keys, sets, calls)}
+}
+
+func (a *hashAggregate) Open(env *Env) error {
+	if err := a.input.Open(env); err != nil {
+		return err
+	}
+	a.env, a.groups, a.pos = env, nil, 0
+	groups := make([][]*group, len(a.sets))
+	index := make(map[string]*group)
+	var buf []byte
+	for {
+		row, err := a.input.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		keys, err := evalAll(env, a.keys, row)
+		if err != nil {
+			return err
+		}
+		for i, set := range a.sets {
+			buf = append(strconv.AppendInt(buf[:0], int64(i), 10), ':')
+			for _, k := range set {
+				buf = types.AppendKey(buf, a.keys[k].Type(), keys[k])
+			}
+			g, ok := index[string(buf)]
+			if !ok {
+				g = a.newGroup(i, keys)
+				index[string(buf)] = g
+				groups[i] = append(groups[i], g)
+			}
+			if err := a.add(g, row); err != nil {
+				return err
+			}
+		}
+	}
+	for i, set := range a.sets {
+		if len(set) == 0 && len(groups[i]) == 0 {
+			groups[i] = append(groups[i], a.newGroup(i, nil))
+		}
+		a.groups = append(a.groups, groups[i]...)
+	}
+	return nil
+}
+
+func (a *hashAggregate) Next() (Row, error) {
+	if a.pos >= len(a.groups) {
+		return nil, nil
+	}
+	g := a.groups[a.pos]
+	a.groups[a.pos] = nil
+	a.pos++
+	return a.output(g)
+}
+
+func (a *hashAggregate) Close() error {
+	a.groups = nil
+	return a.input.Close()
+}
+
+// sortAggregate finds groups as consecutive rows of its sorted input
+type sortAggregate struct {
+	aggregator
+	// current holds the group of each grouping set the last row belongs
+	// to and done the groups which ended
+	current []*group
+	done    []*group
+	eof     bool
+}
+
+// NewSortAggregate returns an operator grouping the rows of its input,
+// which are sorted by the keys, by the keys of each grouping set. The
+// grouping sets are prefixes of the keys from the longest to the
+// shortest. Groups are returned once the input moves past them, a set
+// without keys has a group even without rows.
+func NewSortAggregate(input Operator, keys []Expr, sets [][]int, calls []*AggregateCall) Operator {
+	return &sortAggregate{aggregator: newAggregator(input, keys, sets, calls)}
+}
+
+func (a *sortAggregate) Open(env *Env) error {
+	a.env, a.done, a.eof = env, nil, false
+	a.current = make([]*group, len(a.sets))
+	for i, set := range a.sets {
+		if len(set) == 0 {
+			a.current[i] = a.newGroup(i, nil)
+		}
+	}
+	return a.input.Open(env)
+}
+
+func (a *sortAggregate) Next() (Row, error) {
+	for len(a.done) == 0 {
+		if a.eof {
+			return nil, nil
+		}
+		row, err := a.input.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			a.eof = true
+			for i, g := range a.current {
+				if g != nil {
+					a.done = append(a.done, g)
+					a.current[i] = nil
+				}
+			}
+			continue
+		}
+		keys, err := evalAll(a.env, a.keys, row)
+		if err != nil {
+			return nil, err
+		}
+		for i := range a.sets {
+			g := a.current[i]
+			if g != nil && !a.sameGroup(g, keys) {
+				a.done = append(a.done, g)
+				g = nil
+			}
+			if g == nil {
+				g = a.newGroup(i, keys)
+				a.current[i] = g
+			}
+			if err := a.add(g, row); err != nil {
+				return nil, err
+			}
+		}
+	}
+	g := a.done[0]
+	a.done = a.done[1:]
+	return a.output(g)
+}
+
+// sameGroup reports whether the values of the keys belong to the group
+func (a *sortAggregate) sameGroup(g *group, keys Row) bool {
+	for _, k := range a.sets[g.set] {
+		x, y := g.keys[k], keys[k]
+		if x == nil || y == nil {
+			if x != y {
+				return false
+			}
+			continue
+		}
+		if types.Compare(a.keys[k].Type(), x, y) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *sortAggregate) Close() error {
+	a.current, a.done = nil, nil
+	return a.input.Close()
+}
+
+// groupingExpr is GROUPING(args), the bits of the result are set for the
+// arguments which are not in the grouping set of the row, the last
+// argument is the lowest bit
+type groupingExpr struct {
+	mask Expr
+	args []Expr
+	keys []int
+}
+
+// NewGrouping returns GROUPING of the arguments, which are the grouping
+// keys at the positions keys. mask is the grouping set mask of the rows of
+// an aggregate.
+func NewGrouping(mask Expr, args []Expr, keys []int) Expr {
+	return &groupingExpr{mask: mask, args: args, keys: keys}
+}
+
+func (e *groupingExpr) Type() types.Type { return types.Int4 }
+func (e *groupingExpr) String() string   { return callString("GROUPING", e.args) }
+
+func (e *groupingExpr) Eval(env *Env, row Row) (interface{}, error) {
+	v, err := e.mask.Eval(env, row)
+	if err != nil {
+		return nil, err
+	}
+	mask, result := v.(int64), int64(0)
+	for _, k := range e.keys {
+		result = result<<1 | mask>>uint(k)&1
+	}
+	return result, nil
+}
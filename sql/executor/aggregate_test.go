@@ -0,0 +1,79 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// aggregateInput returns rows of two keys and a value sorted by the keys
+func aggregateInput() Operator {
+	return valuesOf(
+		[]interface{}{int64(1), int64(1), int64(10)},
+		[]interface{}{int64(1), int64(1), int64(10)},
+		[]interface{}{int64(1), int64(2), int64(20)},
+		[]interface{}{int64(2), nil, int64(30)},
+		[]interface{}{int64(2), nil, nil},
+	)
+}
+
+// aggregateCalls returns the calls count(*) and sum(DISTINCT value)
+// FILTER (WHERE value > 10)
+func aggregateCalls(t *testing.T) []*AggregateCall {
+	value := NewColumn(2, "v", types.Int4)
+	return []*AggregateCall{
+		{Func: aggregateFunc(t, "count")},
+		{Func: aggregateFunc(t, "sum", types.Int4), Args: []Expr{value}, Distinct: true, Filter: NewCompare(">", value, int4(10))},
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	keys := []Expr{NewColumn(0, "a", types.Int4), NewColumn(1, "b", types.Int4)}
+	calls := aggregateCalls(t)
+	assert.Equal(t, "count(*)", calls[0].String())
+	assert.Equal(t, "sum(DISTINCT v) FILTER (WHERE (v > 10))", calls[1].String())
+
+	rollup := [][]int{{0, 1}, {0}, {}}
+	want := []Row{
+		{int64(1), int64(1), int64(2), nil, int64(0)},
+		{int64(1), int64(2), int64(1), int64(20), int64(0)},
+		{int64(2), nil, int64(2), int64(30), int64(0)},
+		{int64(1), nil, int64(3), int64(20), int64(2)},
+		{int64(2), nil, int64(2), int64(30), int64(2)},
+		{nil, nil, int64(5), int64(50), int64(3)},
+	}
+	assert.Equal(t, want, collect(t, NewHashAggregate(aggregateInput(), keys, rollup, calls)))
+	sorted := collect(t, NewSortAggregate(aggregateInput(), keys, rollup, calls))
+	assert.Equal(t, []Row{want[0], want[1], want[3], want[2], want[4], want[5]}, sorted)
+
+	empty := valuesOf()
+	assert.Equal(t, []Row{{int64(0), nil, int64(0)}}, collect(t, NewHashAggregate(empty, nil, [][]int{{}}, calls)))
+	assert.Equal(t, []Row{{int64(0), nil, int64(0)}}, collect(t, NewSortAggregate(empty, nil, [][]int{{}}, calls)))
+	assert.Nil(t, collect(t, NewSortAggregate(empty, keys[:1], [][]int{{0}}, calls)))
+}
+
+func TestAggregateOrder(t *testing.T) {
+	value := NewColumn(2, "v", types.Int4)
+	call := &AggregateCall{
+		Func:    aggregateFunc(t, "array_agg", types.Int4),
+		Args:    []Expr{value},
+		OrderBy: []SortKey{{Expr: value, Desc: true}},
+	}
+	assert.Equal(t, "array_agg(v ORDER BY v DESC NULLS LAST)", call.String())
+	rows := collect(t, NewHashAggregate(aggregateInput(), nil, [][]int{{}}, []*AggregateCall{call}))
+	assert.Equal(t, []Row{{[]interface{}{int64(30), int64(20), int64(10), int64(10), nil}, int64(0)}}, rows)
+}
+
+func TestGrouping(t *testing.T) {
+	a, b := NewColumn(0, "a", types.Int4), NewColumn(1, "b", types.Int4)
+	mask := NewColumn(2, "grouping", types.Int8)
+	g := NewGrouping(mask, []Expr{b, a}, []int{1, 0})
+	assert.Equal(t, "GROUPING(b, a)", g.String())
+	v, err := g.Eval(nil, Row{nil, int64(1), int64(1)})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), v)
+	v, err = g.Eval(nil, Row{nil, nil, int64(2)})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), v)
+}
@@ -0,0 +1,320 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package executor
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// arithExpr is an arithmetic operator on two numbers of the same type
+type arithExpr struct {
+	op          string
+	left, right Expr
+	typ         types.Type
+}
+
+// NewArith returns the arithmetic operation +, -, *, / or % of two
+// expressions of the numeric type t
+func NewArith(op string, left, right Expr, t types.Type) Expr {
+	return &arithExpr{op: op, left: left, right: right, typ: t}
+}
+
+func (e *arithExpr) Type() types.Type { return e.typ }
+
+func (e *arithExpr) String() string {
+	return fmt.Sprintf("(%s %s %s)", e.left, e.op, e.right)
+}
+
+func (e *arithExpr) Eval(env *Env, row Row) (interface{}, error) {
+	left, err := e.left.Eval(env, row)
+	if err != nil || left == nil {
+		return nil, err
+	}
+	right, err := e.right.Eval(env, row)
+	if err != nil || right == nil {
+		return nil, err
+	}
+	switch left := left.(type) {
+	case int64:
+		return arithInt(e.op, left, right.(int64), e.typ)
+	case float64:
+		return arithFloat(e.op, left, right.(float64), e.typ)
+	case string:
+		return arithNumeric(e.op, left, right.(string))
+	}
+	return nil, fmt.Errorf("unexpected operand %T of %s", left, e.op)
+}
+
+// errDivisionByZero is returned for a division or modulo by zero
+var errDivisionByZero = pgerror.NewError(pgerror.CodeDivisionByZero, "division by zero")
+
+// errOutOfRange is returned when the result does not fit the type
+func errOutOfRange(t types.Type) error {
+	return pgerror.NewError(pgerror.CodeNumericValueOutOfRange, "%s out of range", t)
+}
+
+func arithInt(op string, a, b int64, t types.Type) (interface{}, error) {
+	var r int64
+	switch op {
+	case "+":
+		r = a + b
+		if (r > a) != (b > 0) {
+			return nil, errOutOfRange(t)
+		}
+	case "-":
+		r = a - b
+		if (r < a) != (b > 0) {
+			return nil, errOutOfRange(t)
+		}
+	case "*":
+		r = a * b
+		if a != 0 && (r/a != b || (a == -1 && b == math.MinInt64)) {
+			return nil, errOutOfRange(t)
+		}
+	case "/":
+		if b == 0 {
+			return nil, errDivisionByZero
+		}
+		if a == math.MinInt64 && b == -1 {
+			return nil, errOutOfRange(t)
+		}
+		r = a / b
+	case "%":
+		if b == 0 {
+			return nil, errDivisionByZero
+		}
+		if b == -1 {
+			return int64(0), nil
+		}
+		r = a % b
+	}
+	// narrower types are computed in 64 bits and checked afterwards
+	if v, err := types.Cast(r, types.Int8, t); err == nil {
+		return v, nil
+	}
+	return nil, errOutOfRange(t)
+}
+
+func arithFloat(op string, a, b float64, t types.Type) (interface{}, error) {
+	var r float64
+	switch op {
+	case "+":
+		r = a + b
+	case "-":
+		r = a - b
+	case "*":
+		r = a * b
+	case "/":
+		if b == 0 {
+			return nil, errDivisionByZero
+		}
+		r = a / b
+	case "%":
+		if b == 0 {
+			return nil, errDivisionByZero
+		}
+		r = math.Mod(a, b)
+	}
+	if math.IsInf(r, 0) && !math.IsInf(a, 0) && !math.IsInf(b, 0) {
+		return nil, pgerror.NewError(pgerror.CodeNumericValueOutOfRange, "value out of range: overflow")
+	}
+	if t.OID == pgtype.Float4OID {
+		return types.Cast(r, types.Float8, t)
+	}
+	return r, nil
+}
+
+// numeric limits of postgres' select_div_scale
+const (
+	numericMinSigDigits = 16
+	numericMaxScale     = 1000
+)
+
+func arithNumeric(op string, a, b string) (interface{}, error) {
+	x, okx := types.ParseNumeric(a)
+	y, oky := types.ParseNumeric(b)
+	if !okx || !oky {
+		return arithSpecial(op, a, b)
+	}
+	sa, sb := types.NumericScale(a), types.NumericScale(b)
+	r := new(big.Rat)
+	scale := sa
+	if sb > scale {
+		scale = sb
+	}
+	switch op {
+	case "+":
+		r.Add(x, y)
+	case "-":
+		r.Sub(x, y)
+	case "*":
+		r.Mul(x, y)
+		scale = sa + sb
+	case "/":
+		if y.Sign() == 0 {
+			return nil, errDivisionByZero
+		}
+		r.Quo(x, y)
+		if s := divScale(x, y); s > scale {
+			scale = s
+		}
+		if scale > numericMaxScale {
+			scale = numericMaxScale
+		}
+	case "%":
+		if y.Sign() == 0 {
+			return nil, errDivisionByZero
+		}
+		q := new(big.Int).Quo(new(big.Int).Mul(x.Num(), y.Denom()), new(big.Int).Mul(x.Denom(), y.Num()))
+		r.Sub(x, new(big.Rat).Mul(y, new(big.Rat).SetInt(q)))
+	}
+	return types.FormatNumeric(r, scale), nil
+}
+
+// divScale returns the scale of a quotient like postgres, which gives at
+// least 16 significant digits counted in groups of four digits
+func divScale(x, y *big.Rat) int {
+	wx, fx := numericWeight(x)
+	wy, fy := numericWeight(y)
+	qweight := wx - wy
+	if fx <= fy {
+		qweight--
+	}
+	return numericMinSigDigits - qweight*4
+}
+
+// numericWeight returns the position of the first non zero base 10000
+// digit of x and the digit itself
+func numericWeight(x *big.Rat) (int, int64) {
+	s := strings.TrimPrefix(x.FloatString(numericMaxScale), "-")
+	intPart, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, frac = s[:i], s[i+1:]
+	}
+	intPart = strings.TrimLeft(intPart, "0")
+	if intPart != "" {
+		// pad to whole groups of four digits
+		intPart = strings.Repeat("0", (4-len(intPart)%4)%4) + intPart
+		return len(intPart)/4 - 1, parseGroup(intPart[:4])
+	}
+	zeros := len(frac) - len(strings.TrimLeft(frac, "0"))
+	if zeros == len(frac) {
+		return 0, 0
+	}
+	group := zeros / 4
+	frac += "000"
+	return -group - 1, parseGroup(frac[group*4 : group*4+4])
+}
+
+func parseGroup(s string) int64 {
+	var n int64
+	for _, c := range s {
+		n = n*10 + int64(c-'0')
+	}
+	return n
+}
+
+// arithSpecial computes the operations involving NaN or infinity
+func arithSpecial(op string, a, b string) (interface{}, error) {
+	fa, err := types.Cast(a, types.Numeric, types.Float8)
+	if err != nil {
+		return nil, err
+	}
+	fb, err := types.Cast(b, types.Numeric, types.Float8)
+	if err != nil {
+		return nil, err
+	}
+	r, err := arithFloat(op, fa.(float64), fb.(float64), types.Float8)
+	if err != nil {
+		return nil, err
+	}
+	return types.Cast(r, types.Float8, types.Numeric)
+}
+
+// negExpr is the unary minus of a number
+type negExpr struct {
+	expr Expr
+}
+
+// NewNeg returns the negation of the numeric expression e
+func NewNeg(e Expr) Expr {
+	return &negExpr{expr: e}
+}
+
+func (e *negExpr) Type() types.Type { return e.expr.Type() }
+func (e *negExpr) String() string   { return fmt.Sprintf("(- %s)", e.expr) }
+
+func (e *negExpr) Eval(env *Env, row Row) (interface{}, error) {
+	v, err := e.expr.Eval(env, row)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case int64:
+		return arithInt("-", 0, v, e.Type())
+	case float64:
+		return -v, nil
+	case string:
+		if r, ok := types.ParseNumeric(v); ok {
+			return types.FormatNumeric(r.Neg(r), types.NumericScale(v)), nil
+		}
+		switch v {
+		case "Infinity":
+			return "-Infinity", nil
+		case "-Infinity":
+			return "Infinity", nil
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("unexpected operand %T of -", v)
+}
+
+// concatExpr concatenates two strings
+type concatExpr struct {
+	left, right Expr
+}
+
+// NewConcat returns the concatenation of two text expressions
+func NewConcat(left, right Expr) Expr {
+	return &concatExpr{left: left, right: right}
+}
+
+func (e *concatExpr) Type() types.Type { return types.Text }
+
+func (e *concatExpr) String() string {
+	return fmt.Sprintf("(%s || %s)", e.left, e.right)
+}
+
+func (e *concatExpr) Eval(env *Env, row Row) (interface{}, error) {
+	left, err := e.left.Eval(env, row)
+	if err != nil || left == nil {
+		return nil, err
+	}
+	right, err := e.right.Eval(env, row)
+	if err != nil || right == nil {
+		return nil, err
+	}
+	return left.(string) + right.(string), nil
+}
@@ -0,0 +1,86 @@
+package executor
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArith(t *testing.T) {
+	tests := []struct {
+		op          string
+		left, right interface{}
+		t           types.Type
+		want        interface{}
+	}{
+		{"+", int64(1), int64(2), types.Int4, int64(3)},
+		{"-", int64(1), int64(2), types.Int8, int64(-1)},
+		{"*", int64(6), int64(7), types.Int2, int64(42)},
+		{"/", int64(7), int64(2), types.Int4, int64(3)},
+		{"/", int64(-7), int64(2), types.Int4, int64(-3)},
+		{"%", int64(-7), int64(2), types.Int4, int64(-1)},
+		{"%", int64(math.MinInt64), int64(-1), types.Int8, int64(0)},
+		{"+", 0.5, 0.25, types.Float8, 0.75},
+		{"/", 1.0, 4.0, types.Float8, 0.25},
+		{"+", "1.5", "2.25", types.Numeric, "3.75"},
+		{"*", "1.5", "2.25", types.Numeric, "3.375"},
+		{"/", "1", "3", types.Numeric, "0.33333333333333333333"},
+		{"/", "10.0", "4", types.Numeric, "2.5000000000000000"},
+		{"%", "7.5", "2", types.Numeric, "1.5"},
+		{"+", "NaN", "1", types.Numeric, "NaN"},
+		{"+", nil, int64(1), types.Int4, nil},
+	}
+	for _, test := range tests {
+		e := NewArith(test.op, NewConst(test.left, test.t), NewConst(test.right, test.t), test.t)
+		assert.Equal(t, test.want, eval(t, e, nil), e.String())
+	}
+}
+
+func TestArithErrors(t *testing.T) {
+	tests := []struct {
+		op          string
+		left, right interface{}
+		t           types.Type
+		code        string
+		message     string
+	}{
+		{"+", int64(math.MaxInt32), int64(1), types.Int4, pgerror.CodeNumericValueOutOfRange, "integer out of range"},
+		{"*", int64(math.MaxInt64), int64(2), types.Int8, pgerror.CodeNumericValueOutOfRange, "bigint out of range"},
+		{"-", int64(math.MinInt16), int64(1), types.Int2, pgerror.CodeNumericValueOutOfRange, "smallint out of range"},
+		{"/", int64(math.MinInt64), int64(-1), types.Int8, pgerror.CodeNumericValueOutOfRange, "bigint out of range"},
+		{"/", int64(1), int64(0), types.Int4, pgerror.CodeDivisionByZero, "division by zero"},
+		{"%", int64(1), int64(0), types.Int4, pgerror.CodeDivisionByZero, "division by zero"},
+		{"/", "1", "0", types.Numeric, pgerror.CodeDivisionByZero, "division by zero"},
+	}
+	for _, test := range tests {
+		e := NewArith(test.op, NewConst(test.left, test.t), NewConst(test.right, test.t), test.t)
+		_, err := e.Eval(NewEnv(context.Background()), nil)
+		require.Error(t, err, e.String())
+		assert.Equal(t, test.code, err.(*pgerror.Error).Code, e.String())
+		assert.Equal(t, test.message, err.(*pgerror.Error).Message, e.String())
+	}
+}
+
+func TestNeg(t *testing.T) {
+	assert.Equal(t, int64(-1), eval(t, NewNeg(NewConst(int64(1), types.Int4)), nil))
+	assert.Equal(t, "-1.50", eval(t, NewNeg(NewConst("1.50", types.Numeric)), nil))
+	assert.Equal(t, "0", eval(t, NewNeg(NewConst("0", types.Numeric)), nil))
+	assert.Equal(t, "-Infinity", eval(t, NewNeg(NewConst("Infinity", types.Numeric)), nil))
+	assert.Equal(t, -0.5, eval(t, NewNeg(NewConst(0.5, types.Float8)), nil))
+	assert.Nil(t, eval(t, NewNeg(NewConst(nil, types.Int4)), nil))
+
+	_, err := NewNeg(NewConst(int64(math.MinInt32), types.Int4)).Eval(NewEnv(context.Background()), nil)
+	assert.EqualError(t, err, "ERROR: integer out of range (SQLSTATE 22003)")
+}
+
+func TestConcat(t *testing.T) {
+	e := NewConcat(NewConst("a", types.Text), NewConst("b", types.Text))
+	assert.Equal(t, "ab", eval(t, e, nil))
+	assert.Equal(t, "('a'::text || 'b'::text)", e.String())
+	assert.Nil(t, eval(t, NewConcat(NewConst("a", types.Text), NewConst(nil, types.Text)), nil))
+}
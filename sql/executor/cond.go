@@ -0,0 +1,299 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package executor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// When is a WHEN cond THEN result branch of CASE
+type When struct {
+	Cond, Result Expr
+}
+
+// caseExpr returns the result of the first branch whose condition is true
+type caseExpr struct {
+	whens []When
+	els   Expr
+	typ   types.Type
+}
+
+// NewCase returns a searched CASE of type t, else is the result when no
+// condition is true
+func NewCase(whens []When, els Expr, t types.Type) Expr {
+	return &caseExpr{whens: whens, els: els, typ: t}
+}
+
+func (e *caseExpr) Type() types.Type { return e.typ }
+
+func (e *caseExpr) String() string {
+	var b strings.Builder
+	b.WriteString("CASE")
+	for _, w := range e.whens {
+		fmt.Fprintf(&b, " WHEN %s THEN %s", w.Cond, w.Result)
+	}
+	fmt.Fprintf(&b, " ELSE %s END", e.els)
+	return b.String()
+}
+
+func (e *caseExpr) Eval(env *Env, row Row) (interface{}, error) {
+	for _, w := range e.whens {
+		v, err := w.Cond.Eval(env, row)
+		if err != nil {
+			return nil, err
+		}
+		if v == true {
+			return w.Result.Eval(env, row)
+		}
+	}
+	return e.els.Eval(env, row)
+}
+
+// coalesceExpr returns its first argument which is not NULL
+type coalesceExpr struct {
+	args []Expr
+	typ  types.Type
+}
+
+// NewCoalesce returns COALESCE of arguments of type t
+func NewCoalesce(args []Expr, t types.Type) Expr {
+	return &coalesceExpr{args: args, typ: t}
+}
+
+func (e *coalesceExpr) Type() types.Type { return e.typ }
+func (e *coalesceExpr) String() string   { return callString("COALESCE", e.args) }
+
+func (e *coalesceExpr) Eval(env *Env, row Row) (interface{}, error) {
+	for _, arg := range e.args {
+		v, err := arg.Eval(env, row)
+		if err != nil || v != nil {
+			return v, err
+		}
+	}
+	return nil, nil
+}
+
+// callString formats a function call
+func callString(name string, args []Expr) string {
+	s := make([]string, len(args))
+	for i, arg := range args {
+		s[i] = arg.String()
+	}
+	return name + "(" + strings.Join(s, ", ") + ")"
+}
+
+// nullIfExpr is NULL when both arguments are equal, else the first
+type nullIfExpr struct {
+	left, right Expr
+}
+
+// NewNullIf returns NULLIF of two arguments of the same type
+func NewNullIf(left, right Expr) Expr {
+	return &nullIfExpr{left: left, right: right}
+}
+
+func (e *nullIfExpr) Type() types.Type { return e.left.Type() }
+func (e *nullIfExpr) String() string   { return callString("NULLIF", []Expr{e.left, e.right}) }
+
+func (e *nullIfExpr) Eval(env *Env, row Row) (interface{}, error) {
+	left, err := e.left.Eval(env, row)
+	if err != nil || left == nil {
+		return nil, err
+	}
+	right, err := e.right.Eval(env, row)
+	if err != nil {
+		return nil, err
+	}
+	if right != nil && types.Compare(e.left.Type(), left, right) == 0 {
+		return nil, nil
+	}
+	return left, nil
+}
+
+// extremumExpr is GREATEST or LEAST, NULL arguments are ignored
+type extremumExpr struct {
+	args  []Expr
+	typ   types.Type
+	least bool
+}
+
+// NewGreatest returns GREATEST of arguments of type t
+func NewGreatest(args []Expr, t types.Type) Expr {
+	return &extremumExpr{args: args, typ: t}
+}
+
+// NewLeast returns LEAST of arguments of type t
+func NewLeast(args []Expr, t types.Type) Expr {
+	return &extremumExpr{args: args, typ: t, least: true}
+}
+
+func (e *extremumExpr) Type() types.Type { return e.typ }
+
+func (e *extremumExpr) String() string {
+	if e.least {
+		return callString("LEAST", e.args)
+	}
+	return callString("GREATEST", e.args)
+}
+
+func (e *extremumExpr) Eval(env *Env, row Row) (interface{}, error) {
+	var result interface{}
+	for _, arg := range e.args {
+		v, err := arg.Eval(env, row)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			continue
+		}
+		if result == nil {
+			result = v
+			continue
+		}
+		if c := types.Compare(e.typ, v, result); c < 0 && e.least || c > 0 && !e.least {
+			result = v
+		}
+	}
+	return result, nil
+}
+
+// inExpr tests whether a value equals one of a list of values
+type inExpr struct {
+	expr Expr
+	list []Expr
+	not  bool
+}
+
+// NewIn returns expr IN (list), or NOT IN when not is set. The values are
+// of the same type. Like a chain of OR the result is NULL when no value
+// is equal and one of them is NULL.
+func NewIn(expr Expr, list []Expr, not bool) Expr {
+	return &inExpr{expr: expr, list: list, not: not}
+}
+
+func (e *inExpr) Type() types.Type { return types.Bool }
+
+func (e *inExpr) String() string {
+	op := "IN"
+	if e.not {
+		op = "NOT IN"
+	}
+	return fmt.Sprintf("(%s %s %s)", e.expr, op, callString("", e.list))
+}
+
+func (e *inExpr) Eval(env *Env, row Row) (interface{}, error) {
+	v, err := e.expr.Eval(env, row)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	null := false
+	for _, item := range e.list {
+		w, err := item.Eval(env, row)
+		if err != nil {
+			return nil, err
+		}
+		if w == nil {
+			null = true
+			continue
+		}
+		if types.Compare(e.expr.Type(), v, w) == 0 {
+			return !e.not, nil
+		}
+	}
+	if null {
+		return nil, nil
+	}
+	return e.not, nil
+}
+
+// isDistinctExpr compares two values treating NULL like a value
+type isDistinctExpr struct {
+	left, right Expr
+	not         bool
+}
+
+// NewIsDistinct returns left IS DISTINCT FROM right, or IS NOT DISTINCT
+// FROM when not is set, for values of the same type
+func NewIsDistinct(left, right Expr, not bool) Expr {
+	return &isDistinctExpr{left: left, right: right, not: not}
+}
+
+func (e *isDistinctExpr) Type() types.Type { return types.Bool }
+
+func (e *isDistinctExpr) String() string {
+	if e.not {
+		return fmt.Sprintf("(%s IS NOT DISTINCT FROM %s)", e.left, e.right)
+	}
+	return fmt.Sprintf("(%s IS DISTINCT FROM %s)", e.left, e.right)
+}
+
+func (e *isDistinctExpr) Eval(env *Env, row Row) (interface{}, error) {
+	left, err := e.left.Eval(env, row)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.right.Eval(env, row)
+	if err != nil {
+		return nil, err
+	}
+	var distinct bool
+	if left == nil || right == nil {
+		distinct = (left == nil) != (right == nil)
+	} else {
+		distinct = types.Compare(e.left.Type(), left, right) != 0
+	}
+	return distinct != e.not, nil
+}
+
+// isBoolExpr tests whether a boolean is TRUE, FALSE or UNKNOWN
+type isBoolExpr struct {
+	expr Expr
+	// want is true, false or nil for UNKNOWN
+	want interface{}
+	not  bool
+}
+
+// NewIsBool returns expr IS TRUE, IS FALSE or IS UNKNOWN when want is
+// true, false or nil, negated when not is set
+func NewIsBool(expr Expr, want interface{}, not bool) Expr {
+	return &isBoolExpr{expr: expr, want: want, not: not}
+}
+
+func (e *isBoolExpr) Type() types.Type { return types.Bool }
+
+func (e *isBoolExpr) String() string {
+	test := "UNKNOWN"
+	if e.want != nil {
+		test = strings.ToUpper(fmt.Sprint(e.want))
+	}
+	if e.not {
+		return fmt.Sprintf("(%s IS NOT %s)", e.expr, test)
+	}
+	return fmt.Sprintf("(%s IS %s)", e.expr, test)
+}
+
+func (e *isBoolExpr) Eval(env *Env, row Row) (interface{}, error) {
+	v, err := e.expr.Eval(env, row)
+	if err != nil {
+		return nil, err
+	}
+	return (v == e.want) != e.not, nil
+}
@@ -0,0 +1,73 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCase(t *testing.T) {
+	a := NewColumn(0, "a", types.Int4)
+	e := NewCase([]When{
+		{Cond: NewCompare("<", a, int4(0)), Result: NewConst("negative", types.Text)},
+		{Cond: NewCompare("=", a, int4(0)), Result: NewConst("zero", types.Text)},
+	}, NewConst("positive", types.Text), types.Text)
+	assert.Equal(t, "negative", eval(t, e, Row{int64(-1)}))
+	assert.Equal(t, "zero", eval(t, e, Row{int64(0)}))
+	assert.Equal(t, "positive", eval(t, e, Row{int64(1)}))
+	// NULL conditions are not true
+	assert.Equal(t, "positive", eval(t, e, Row{nil}))
+	assert.Equal(t, "CASE WHEN (a < 0) THEN 'negative'::text WHEN (a = 0) THEN 'zero'::text ELSE 'positive'::text END", e.String())
+}
+
+func TestCoalesceNullIf(t *testing.T) {
+	null := NewConst(nil, types.Int4)
+	assert.Equal(t, int64(2), eval(t, NewCoalesce([]Expr{null, int4(2), int4(3)}, types.Int4), nil))
+	assert.Nil(t, eval(t, NewCoalesce([]Expr{null, null}, types.Int4), nil))
+	assert.Equal(t, "COALESCE(NULL, 2)", NewCoalesce([]Expr{NewConst(nil, types.Unknown), int4(2)}, types.Int4).String())
+
+	assert.Nil(t, eval(t, NewNullIf(int4(1), int4(1)), nil))
+	assert.Equal(t, int64(1), eval(t, NewNullIf(int4(1), int4(2)), nil))
+	assert.Equal(t, int64(1), eval(t, NewNullIf(int4(1), null), nil))
+}
+
+func TestGreatestLeast(t *testing.T) {
+	args := []Expr{int4(2), NewConst(nil, types.Int4), int4(5), int4(1)}
+	assert.Equal(t, int64(5), eval(t, NewGreatest(args, types.Int4), nil))
+	assert.Equal(t, int64(1), eval(t, NewLeast(args, types.Int4), nil))
+	assert.Nil(t, eval(t, NewLeast([]Expr{NewConst(nil, types.Int4)}, types.Int4), nil))
+}
+
+func TestIn(t *testing.T) {
+	list := []Expr{int4(1), int4(2)}
+	withNull := []Expr{int4(1), NewConst(nil, types.Int4)}
+	assert.Equal(t, true, eval(t, NewIn(int4(2), list, false), nil))
+	assert.Equal(t, false, eval(t, NewIn(int4(3), list, false), nil))
+	assert.Equal(t, true, eval(t, NewIn(int4(3), list, true), nil))
+	assert.Equal(t, true, eval(t, NewIn(int4(1), withNull, false), nil))
+	assert.Nil(t, eval(t, NewIn(int4(3), withNull, false), nil))
+	assert.Nil(t, eval(t, NewIn(int4(3), withNull, true), nil))
+	assert.Nil(t, eval(t, NewIn(NewConst(nil, types.Int4), list, false), nil))
+}
+
+func TestIsDistinct(t *testing.T) {
+	null := NewConst(nil, types.Int4)
+	assert.Equal(t, false, eval(t, NewIsDistinct(null, null, false), nil))
+	assert.Equal(t, true, eval(t, NewIsDistinct(int4(1), null, false), nil))
+	assert.Equal(t, false, eval(t, NewIsDistinct(int4(1), int4(1), false), nil))
+	assert.Equal(t, true, eval(t, NewIsDistinct(null, null, true), nil))
+}
+
+func TestIsBool(t *testing.T) {
+	values := []interface{}{true, false, nil}
+	for _, want := range values {
+		for _, v := range values {
+			e := NewIsBool(NewConst(v, types.Bool), want, false)
+			assert.Equal(t, v == want, eval(t, e, nil), "%v IS %v", v, want)
+			e = NewIsBool(NewConst(v, types.Bool), want, true)
+			assert.Equal(t, v != want, eval(t, e, nil), "%v IS NOT %v", v, want)
+		}
+	}
+	assert.Equal(t, "(NULL IS NOT UNKNOWN)", NewIsBool(NewConst(nil, types.Bool), nil, true).String())
+}
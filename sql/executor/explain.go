@@ -0,0 +1,208 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package executor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// planNode is a node of the plan EXPLAIN shows
+type planNode struct {
+	name    string
+	details []string
+	inputs  []*planNode
+}
+
+// explainer is implemented by the operators EXPLAIN describes
+type explainer interface {
+	explain() *planNode
+}
+
+// Explain returns the lines describing the plan of the operator
+func Explain(op Operator) []string {
+	var lines []string
+	explainNode(op).write(&lines, 0, false)
+	return lines
+}
+
+// explainNode returns the node describing an operator
+func explainNode(op Operator) *planNode {
+	if e, ok := op.(explainer); ok {
+		return e.explain()
+	}
+	return &planNode{name: fmt.Sprintf("%T", op)}
+}
+
+// write appends the lines of the node and its inputs, the details and
+// inputs of a node are indented under its name
+func (n *planNode) write(lines *[]string, indent int, child bool) {
+	prefix, detailIndent := strings.Repeat(" ", indent), indent+2
+	if child {
+		prefix += "->  "
+		detailIndent += 4
+	}
+	*lines = append(*lines, prefix+n.name)
+	for _, d := range n.details {
+		*lines = append(*lines, strings.Repeat(" ", detailIndent)+d)
+	}
+	for _, in := range n.inputs {
+		in.write(lines, detailIndent, true)
+	}
+}
+
+func (s *scan) explain() *planNode {
+	name := "Seq Scan on " + s.table.Name
+	if s.alias != "" && s.alias != s.table.Name {
+		name += " " + s.alias
+	}
+	return &planNode{name: name}
+}
+
+func (v *values) explain() *planNode {
+	if len(v.rows) == 1 {
+		return &planNode{name: "Result"}
+	}
+	return &planNode{name: `Values Scan on "*VALUES*"`}
+}
+
+func (f *filter) explain() *planNode {
+	n := explainNode(f.input)
+	n.details = append(n.details, "Filter: "+f.cond.String())
+	return n
+}
+
+// projections are part of the node computing their input
+func (p *project) explain() *planNode { return explainNode(p.input) }
+
+func (l *limit) explain() *planNode {
+	return &planNode{name: "Limit", inputs: []*planNode{explainNode(l.input)}}
+}
+
+func (d *distinct) explain() *planNode {
+	return &planNode{name: "Unique", inputs: []*planNode{explainNode(d.input)}}
+}
+
+func (s *sortOp) explain() *planNode {
+	return &planNode{
+		name:    "Sort",
+		details: []string{"Sort Key: " + sortKeysString(s.keys)},
+		inputs:  []*planNode{explainNode(s.input)},
+	}
+}
+
+// sortKeysString returns the keys like ORDER BY
+func sortKeysString(sortKeys []SortKey) string {
+	keys := make([]string, len(sortKeys))
+	for i, k := range sortKeys {
+		keys[i] = k.Expr.String()
+		switch {
+		case k.Desc && !k.NullsFirst:
+			keys[i] += " DESC NULLS LAST"
+		case k.Desc:
+			keys[i] += " DESC"
+		case k.NullsFirst:
+			keys[i] += " NULLS FIRST"
+		}
+	}
+	return strings.Join(keys, ", ")
+}
+
+// joinNode returns the node of a join using the algorithm, cond describes
+// its keys
+func (j *joiner) joinNode(algorithm, cond string, left, right *planNode) *planNode {
+	n := &planNode{name: algorithm + " Join", inputs: []*planNode{left, right}}
+	if j.typ != InnerJoin {
+		n.name = algorithm + " " + j.typ.String() + " Join"
+	}
+	if cond != "" {
+		n.details = append(n.details, cond)
+	}
+	if j.cond != nil {
+		n.details = append(n.details, "Join Filter: "+j.cond.String())
+	}
+	return n
+}
+
+// keysString returns the condition equating the keys
+func keysString(keys []JoinKey) string {
+	conds := make([]string, len(keys))
+	for i, k := range keys {
+		conds[i] = fmt.Sprintf("(%s = %s)", k.Left, k.Right)
+	}
+	if len(conds) == 1 {
+		return conds[0]
+	}
+	return "(" + strings.Join(conds, " AND ") + ")"
+}
+
+func (n *nestedLoop) explain() *planNode {
+	node := n.joinNode("Nested Loop", "", explainNode(n.left), explainNode(n.right))
+	if n.typ == InnerJoin {
+		node.name = "Nested Loop"
+	}
+	return node
+}
+
+func (h *hashJoin) explain() *planNode {
+	hash := &planNode{name: "Hash", inputs: []*planNode{explainNode(h.right)}}
+	return h.joinNode("Hash", "Hash Cond: "+keysString(h.keys), explainNode(h.left), hash)
+}
+
+func (m *mergeJoin) explain() *planNode {
+	return m.joinNode("Merge", "Merge Cond: "+keysString(m.keys), explainNode(m.left), explainNode(m.right))
+}
+
+// aggregateNode returns the node of an aggregate listing the keys of each
+// grouping set, a single set without keys is a plain aggregate
+func (a *aggregator) aggregateNode(name, keyLabel string) *planNode {
+	n := &planNode{name: name, inputs: []*planNode{explainNode(a.input)}}
+	if len(a.sets) == 1 && len(a.sets[0]) == 0 {
+		n.name = "Aggregate"
+		return n
+	}
+	if len(a.sets) == 1 {
+		keyLabel = "Group Key"
+	}
+	for _, set := range a.sets {
+		if len(set) == 0 {
+			n.details = append(n.details, "Group Key: ()")
+			continue
+		}
+		keys := make([]string, len(set))
+		for i, k := range set {
+			keys[i] = a.keys[k].String()
+		}
+		n.details = append(n.details, keyLabel+": "+strings.Join(keys, ", "))
+	}
+	return n
+}
+
+func (a *hashAggregate) explain() *planNode {
+	for _, set := range a.sets {
+		if len(set) == 0 && len(a.sets) > 1 {
+			// the empty grouping set is computed without hashing
+			return a.aggregateNode("MixedAggregate", "Hash Key")
+		}
+	}
+	return a.aggregateNode("HashAggregate", "Hash Key")
+}
+
+func (a *sortAggregate) explain() *planNode {
+	return a.aggregateNode("GroupAggregate", "Group Key")
+}
@@ -0,0 +1,42 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/catalog"
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplain(t *testing.T) {
+	table, err := catalog.New().CreateTable(catalog.DefaultSchema, "t", []*catalog.Column{{Name: "a", Type: types.Int4}}, nil)
+	require.NoError(t, err)
+	a := NewColumn(0, "x.a", types.Int4)
+	left := NewFilter(NewScan(table, "x"), NewCompare(">", a, int4(1)))
+	right := NewSort(valuesOf([]interface{}{int64(1)}, []interface{}{int64(2)}), []SortKey{
+		{Expr: NewColumn(0, "column1", types.Int4), Desc: true, NullsFirst: true},
+	})
+	join := NewHashJoin(LeftJoin, left, right, 1, 1, []JoinKey{{Left: a, Right: NewColumn(0, "column1", types.Int4)}}, nil)
+	assert.Equal(t, []string{
+		"Limit",
+		"  ->  Hash Left Join",
+		"        Hash Cond: (x.a = column1)",
+		"        ->  Seq Scan on t x",
+		"              Filter: (x.a > 1)",
+		"        ->  Hash",
+		"              ->  Sort",
+		"                    Sort Key: column1 DESC",
+		`                    ->  Values Scan on "*VALUES*"`,
+	}, Explain(NewLimit(NewProject(join, []Expr{a}), nil, nil)))
+
+	loop := NewNestedLoop(SemiJoin, NewValues([][]Expr{{}}), NewScan(table, "t"), 0, 1, NewCompare("=", a, int4(1)), false)
+	assert.Equal(t, []string{
+		"Nested Loop Semi Join",
+		"  Join Filter: (x.a = 1)",
+		"  ->  Result",
+		"  ->  Seq Scan on t",
+	}, Explain(loop))
+	assert.Equal(t, []string{"Merge Join", "  Merge Cond: (x.a = x.a)", "  ->  Seq Scan on t", "  ->  Seq Scan on t"},
+		Explain(NewMergeJoin(InnerJoin, NewScan(table, ""), NewScan(table, ""), 1, 1, []JoinKey{{Left: a, Right: a}}, nil)))
+}
@@ -0,0 +1,324 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package executor evaluates the expressions compiled by the planner
+// against the rows of a statement and runs query plans, trees of
+// operators pulling rows from their inputs one at a time.
+package executor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// Row holds one value per column, NULL is nil
+type Row []interface{}
+
+// Env is the state shared by the expressions of a statement
+type Env struct {
+	Context context.Context
+	// Now is the time the statement started
+	Now time.Time
+	// Parameter returns the value of a session parameter such as
+	// server_version, it is nil outside of a client session
+	Parameter func(name string) (string, bool)
+	// outer holds the rows of the enclosing queries while a subquery runs,
+	// the innermost last
+	outer []Row
+}
+
+// NewEnv returns the environment of a statement starting now
+func NewEnv(ctx context.Context) *Env {
+	return &Env{Context: ctx, Now: time.Now().UTC()}
+}
+
+// parameter returns the value of the session parameter, empty when it is
+// not known
+func (env *Env) parameter(name string) string {
+	if env.Parameter == nil {
+		return ""
+	}
+	value, _ := env.Parameter(name)
+	return value
+}
+
+// Expr is a compiled expression whose operands are of the types the
+// operation expects, the planner inserts the casts.
+type Expr interface {
+	// Type returns the type of the values of the expression
+	Type() types.Type
+	// Eval returns the value of the expression for the row, NULL is nil
+	Eval(env *Env, row Row) (interface{}, error)
+	String() string
+}
+
+// constExpr is a constant value
+type constExpr struct {
+	value interface{}
+	typ   types.Type
+}
+
+// NewConst returns an expression with the value v of type t
+func NewConst(v interface{}, t types.Type) Expr {
+	return &constExpr{value: v, typ: t}
+}
+
+func (e *constExpr) Type() types.Type                    { return e.typ }
+func (e *constExpr) Eval(*Env, Row) (interface{}, error) { return e.value, nil }
+func (e *constExpr) String() string                      { return formatConst(e.value, e.typ) }
+
+// formatConst returns the constant as a SQL literal
+func formatConst(v interface{}, t types.Type) string {
+	if v == nil {
+		return "NULL"
+	}
+	text, err := types.Cast(v, t, types.Text)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	s := text.(string)
+	switch t.OID {
+	case pgtype.Int4OID, pgtype.Int8OID, pgtype.NumericOID, pgtype.BoolOID:
+		return s
+	case types.UnknownOID:
+		return quoteLiteral(s)
+	}
+	return quoteLiteral(s) + "::" + t.String()
+}
+
+// quoteLiteral quotes s as a string literal
+func quoteLiteral(s string) string {
+	quoted := []byte{'\''}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\'' {
+			quoted = append(quoted, '\'')
+		}
+		quoted = append(quoted, s[i])
+	}
+	return string(append(quoted, '\''))
+}
+
+// IsConst reports whether the expression is a constant and returns its value
+func IsConst(e Expr) (interface{}, bool) {
+	c, ok := e.(*constExpr)
+	if !ok {
+		return nil, false
+	}
+	return c.value, true
+}
+
+// columnExpr returns the value of a column of the row
+type columnExpr struct {
+	index int
+	name  string
+	typ   types.Type
+}
+
+// NewColumn returns an expression reading the value at position index of
+// the row, name is used by String
+func NewColumn(index int, name string, t types.Type) Expr {
+	return &columnExpr{index: index, name: name, typ: t}
+}
+
+func (e *columnExpr) Type() types.Type { return e.typ }
+func (e *columnExpr) String() string   { return e.name }
+
+func (e *columnExpr) Eval(env *Env, row Row) (interface{}, error) {
+	return row[e.index], nil
+}
+
+// castExpr converts the value of an expression to another type
+type castExpr struct {
+	expr     Expr
+	typ      types.Type
+	explicit bool
+}
+
+// NewCast returns an expression converting the value of e to type t,
+// explicit casts truncate strings which are too long for the type.
+func NewCast(e Expr, t types.Type, explicit bool) Expr {
+	return &castExpr{expr: e, typ: t, explicit: explicit}
+}
+
+func (e *castExpr) Type() types.Type { return e.typ }
+
+func (e *castExpr) String() string {
+	return fmt.Sprintf("(%s)::%s", e.expr, e.typ)
+}
+
+func (e *castExpr) Eval(env *Env, row Row) (interface{}, error) {
+	v, err := e.expr.Eval(env, row)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	if e.explicit {
+		return types.Cast(v, e.expr.Type(), e.typ)
+	}
+	return types.Assign(v, e.expr.Type(), e.typ)
+}
+
+// notExpr negates a boolean, NOT NULL is NULL
+type notExpr struct {
+	expr Expr
+}
+
+// NewNot returns the negation of the boolean expression e
+func NewNot(e Expr) Expr {
+	return &notExpr{expr: e}
+}
+
+func (e *notExpr) Type() types.Type { return types.Bool }
+func (e *notExpr) String() string   { return fmt.Sprintf("(NOT %s)", e.expr) }
+
+func (e *notExpr) Eval(env *Env, row Row) (interface{}, error) {
+	v, err := e.expr.Eval(env, row)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	return !v.(bool), nil
+}
+
+// logicExpr is AND or OR of booleans with three valued logic, the result
+// is NULL when the known operands do not decide it.
+type logicExpr struct {
+	and         bool
+	left, right Expr
+}
+
+// NewAnd returns the conjunction of boolean expressions
+func NewAnd(left, right Expr) Expr {
+	return &logicExpr{and: true, left: left, right: right}
+}
+
+// NewOr returns the disjunction of boolean expressions
+func NewOr(left, right Expr) Expr {
+	return &logicExpr{left: left, right: right}
+}
+
+func (e *logicExpr) Type() types.Type { return types.Bool }
+
+func (e *logicExpr) String() string {
+	op := "OR"
+	if e.and {
+		op = "AND"
+	}
+	return fmt.Sprintf("(%s %s %s)", e.left, op, e.right)
+}
+
+func (e *logicExpr) Eval(env *Env, row Row) (interface{}, error) {
+	left, err := e.left.Eval(env, row)
+	if err != nil {
+		return nil, err
+	}
+	// FALSE AND x is FALSE and TRUE OR x is TRUE whatever x is
+	if left != nil && left.(bool) != e.and {
+		return left, nil
+	}
+	right, err := e.right.Eval(env, row)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case right != nil && right.(bool) != e.and:
+		return right, nil
+	case left == nil || right == nil:
+		return nil, nil
+	}
+	return e.and, nil
+}
+
+// isNullExpr tests whether a value is NULL
+type isNullExpr struct {
+	expr Expr
+	not  bool
+}
+
+// NewIsNull returns an expression testing whether e is NULL, or not NULL
+// when not is set
+func NewIsNull(e Expr, not bool) Expr {
+	return &isNullExpr{expr: e, not: not}
+}
+
+func (e *isNullExpr) Type() types.Type { return types.Bool }
+
+func (e *isNullExpr) String() string {
+	if e.not {
+		return fmt.Sprintf("(%s IS NOT NULL)", e.expr)
+	}
+	return fmt.Sprintf("(%s IS NULL)", e.expr)
+}
+
+func (e *isNullExpr) Eval(env *Env, row Row) (interface{}, error) {
+	v, err := e.expr.Eval(env, row)
+	if err != nil {
+		return nil, err
+	}
+	return (v == nil) != e.not, nil
+}
+
+// compareExpr compares two values of the same type, the result is NULL
+// when either is NULL
+type compareExpr struct {
+	op          string
+	left, right Expr
+}
+
+// NewCompare returns the comparison of two expressions of the same type
+// with one of the operators =, <>, <, <=, > and >=
+func NewCompare(op string, left, right Expr) Expr {
+	return &compareExpr{op: op, left: left, right: right}
+}
+
+func (e *compareExpr) Type() types.Type { return types.Bool }
+
+func (e *compareExpr) String() string {
+	return fmt.Sprintf("(%s %s %s)", e.left, e.op, e.right)
+}
+
+func (e *compareExpr) Eval(env *Env, row Row) (interface{}, error) {
+	left, err := e.left.Eval(env, row)
+	if err != nil || left == nil {
+		return nil, err
+	}
+	right, err := e.right.Eval(env, row)
+	if err != nil || right == nil {
+		return nil, err
+	}
+	return compareOp(e.op, types.Compare(e.left.Type(), left, right)), nil
+}
+
+// compareOp returns the outcome of the comparison operator given the
+// result of types.Compare
+func compareOp(op string, c int) bool {
+	switch op {
+	case "=":
+		return c == 0
+	case "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
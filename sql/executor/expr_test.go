@@ -0,0 +1,113 @@
+package executor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// eval evaluates the expression against the row
+func eval(t *testing.T, e Expr, row Row) interface{} {
+	v, err := e.Eval(NewEnv(context.Background()), row)
+	require.NoError(t, err, e.String())
+	return v
+}
+
+func TestConst(t *testing.T) {
+	e := NewConst("it's", types.Text)
+	assert.Equal(t, "it's", eval(t, e, nil))
+	assert.Equal(t, "'it''s'::text", e.String())
+	v, ok := IsConst(e)
+	assert.True(t, ok)
+	assert.Equal(t, "it's", v)
+
+	_, ok = IsConst(NewColumn(0, "a", types.Int4))
+	assert.False(t, ok)
+	assert.Equal(t, "NULL", NewConst(nil, types.Unknown).String())
+}
+
+func TestColumn(t *testing.T) {
+	e := NewColumn(1, "t.b", types.Text)
+	assert.Equal(t, "b", eval(t, e, Row{int64(1), "b"}))
+	assert.Equal(t, "t.b", e.String())
+	assert.Equal(t, types.Text, e.Type())
+}
+
+func TestLogic(t *testing.T) {
+	values := []interface{}{true, false, nil}
+	and := [][]interface{}{
+		{true, false, nil},
+		{false, false, false},
+		{nil, false, nil},
+	}
+	or := [][]interface{}{
+		{true, true, true},
+		{true, false, nil},
+		{true, nil, nil},
+	}
+	for i, a := range values {
+		for j, b := range values {
+			left, right := NewConst(a, types.Bool), NewConst(b, types.Bool)
+			assert.Equal(t, and[i][j], eval(t, NewAnd(left, right), nil), "%v AND %v", a, b)
+			assert.Equal(t, or[i][j], eval(t, NewOr(left, right), nil), "%v OR %v", a, b)
+		}
+	}
+	assert.Equal(t, false, eval(t, NewNot(NewConst(true, types.Bool)), nil))
+	assert.Nil(t, eval(t, NewNot(NewConst(nil, types.Bool)), nil))
+}
+
+func TestLogicShortCircuit(t *testing.T) {
+	failing := NewArith("/", NewConst(int64(1), types.Int4), NewConst(int64(0), types.Int4), types.Int4)
+	assert.Equal(t, false, eval(t, NewAnd(NewConst(false, types.Bool), failing), nil))
+	assert.Equal(t, true, eval(t, NewOr(NewConst(true, types.Bool), failing), nil))
+}
+
+func TestIsNull(t *testing.T) {
+	null, one := NewConst(nil, types.Int4), NewConst(int64(1), types.Int4)
+	assert.Equal(t, true, eval(t, NewIsNull(null, false), nil))
+	assert.Equal(t, false, eval(t, NewIsNull(one, false), nil))
+	assert.Equal(t, true, eval(t, NewIsNull(one, true), nil))
+	assert.Equal(t, "(NULL IS NOT NULL)", NewIsNull(NewConst(nil, types.Unknown), true).String())
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		op          string
+		left, right interface{}
+		t           types.Type
+		want        interface{}
+	}{
+		{"=", int64(1), int64(1), types.Int4, true},
+		{"<>", int64(1), int64(1), types.Int4, false},
+		{"<", "a", "b", types.Text, true},
+		{">=", "a", "b", types.Text, false},
+		{"=", "a ", "a", types.BPChar, true},
+		{"<=", 1.5, 1.5, types.Float8, true},
+		{">", "10.5", "9.75", types.Numeric, true},
+		{"=", nil, int64(1), types.Int4, nil},
+		{"<>", int64(1), nil, types.Int4, nil},
+	}
+	for _, test := range tests {
+		e := NewCompare(test.op, NewConst(test.left, test.t), NewConst(test.right, test.t))
+		assert.Equal(t, test.want, eval(t, e, nil), e.String())
+	}
+}
+
+func TestCast(t *testing.T) {
+	varchar2 := types.Type{OID: pgtype.VarcharOID, Modifier: 2 + 4}
+	abc := NewConst("abc", types.Text)
+	assert.Equal(t, "ab", eval(t, NewCast(abc, varchar2, true), nil), "explicit casts truncate")
+
+	_, err := NewCast(abc, varchar2, false).Eval(NewEnv(context.Background()), nil)
+	require.Error(t, err)
+	assert.Equal(t, pgerror.CodeStringDataRightTruncation, err.(*pgerror.Error).Code)
+
+	assert.Equal(t, int64(42), eval(t, NewCast(NewConst("42", types.Unknown), types.Int4, true), nil))
+	assert.Nil(t, eval(t, NewCast(NewConst(nil, types.Unknown), types.Int4, true), nil))
+	assert.Equal(t, "('42')::integer", NewCast(NewConst("42", types.Unknown), types.Int4, true).String())
+}
@@ -0,0 +1,159 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package executor
+
+import (
+	"crypto/rand"
+	"math"
+	mathrand "math/rand"
+	"time"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// Function is an overload of a scalar function
+type Function struct {
+	Name   string
+	Params []types.Type
+	Result types.Type
+	// Variadic functions take any number of arguments of the type of
+	// their last parameter
+	Variadic bool
+	// AnyArgs functions take arguments of any type, they are cast to the
+	// parameter types explicitly
+	AnyArgs bool
+	// NullArgs functions are called with NULL arguments, the others are
+	// NULL when an argument is NULL
+	NullArgs bool
+	impl     func(env *Env, args []interface{}) (interface{}, error)
+}
+
+// functions holds the overloads of the functions by name, the preferred
+// overload first
+var functions = make(map[string][]*Function)
+
+// register adds functions to the library
+func register(fns ...*Function) {
+	for _, f := range fns {
+		functions[f.Name] = append(functions[f.Name], f)
+	}
+}
+
+// Functions returns the overloads of the function with the name, nil when
+// there is no such function
+func Functions(name string) []*Function {
+	return functions[name]
+}
+
+// fn returns a function taking the parameters
+func fn(name string, params []types.Type, result types.Type, impl func(env *Env, args []interface{}) (interface{}, error)) *Function {
+	return &Function{Name: name, Params: params, Result: result, impl: impl}
+}
+
+// params returns the list of parameter types
+func params(t ...types.Type) []types.Type { return t }
+
+func init() {
+	register(
+		fn("now", nil, types.Timestamptz, now),
+		fn("transaction_timestamp", nil, types.Timestamptz, now),
+		fn("statement_timestamp", nil, types.Timestamptz, now),
+		fn("current_timestamp", nil, types.Timestamptz, now),
+		fn("current_timestamp", params(types.Int4), types.Timestamptz, func(env *Env, args []interface{}) (interface{}, error) {
+			return types.Cast(env.Now, types.Timestamptz, types.Type{OID: pgtype.TimestamptzOID, Modifier: int32(args[0].(int64))})
+		}),
+		fn("localtimestamp", nil, types.Timestamp, now),
+		fn("localtimestamp", params(types.Int4), types.Timestamp, func(env *Env, args []interface{}) (interface{}, error) {
+			return types.Cast(env.Now, types.Timestamp, types.Type{OID: pgtype.TimestampOID, Modifier: int32(args[0].(int64))})
+		}),
+		fn("clock_timestamp", nil, types.Timestamptz, func(*Env, []interface{}) (interface{}, error) {
+			return time.Now().UTC(), nil
+		}),
+		fn("current_date", nil, types.Date, func(env *Env, _ []interface{}) (interface{}, error) {
+			return env.Now.Truncate(24 * time.Hour), nil
+		}),
+		fn("current_user", nil, types.Name, sessionUser),
+		fn("current_role", nil, types.Name, sessionUser),
+		fn("session_user", nil, types.Name, sessionUser),
+		fn("user", nil, types.Name, sessionUser),
+		fn("version", nil, types.Text, func(env *Env, _ []interface{}) (interface{}, error) {
+			return "PostgreSQL " + env.parameter("server_version"), nil
+		}),
+		fn("random", nil, types.Float8, func(*Env, []interface{}) (interface{}, error) {
+			return mathrand.Float64(), nil
+		}),
+		fn("pi", nil, types.Float8, func(*Env, []interface{}) (interface{}, error) {
+			return math.Pi, nil
+		}),
+		fn("gen_random_uuid", nil, types.UUID, func(*Env, []interface{}) (interface{}, error) {
+			var u pgtype.UUID
+			if _, err := rand.Read(u[:]); err != nil {
+				return nil, err
+			}
+			// version 4, variant 1
+			u[6] = u[6]&0x0f | 0x40
+			u[8] = u[8]&0x3f | 0x80
+			return u, nil
+		}),
+	)
+}
+
+func now(env *Env, _ []interface{}) (interface{}, error) {
+	return env.Now, nil
+}
+
+func sessionUser(env *Env, _ []interface{}) (interface{}, error) {
+	return env.parameter("session_authorization"), nil
+}
+
+// funcExpr calls a function of the library
+type funcExpr struct {
+	fn   *Function
+	args []Expr
+}
+
+// NewFunc returns a call of the function, the arguments are of its
+// parameter types
+func NewFunc(f *Function, args []Expr) Expr {
+	return &funcExpr{fn: f, args: args}
+}
+
+func (e *funcExpr) Type() types.Type { return e.fn.Result }
+func (e *funcExpr) String() string   { return callString(e.fn.Name, e.args) }
+
+func (e *funcExpr) Eval(env *Env, row Row) (interface{}, error) {
+	args := make([]interface{}, len(e.args))
+	for i, arg := range e.args {
+		v, err := arg.Eval(env, row)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil && !e.fn.NullArgs {
+			return nil, nil
+		}
+		args[i] = v
+	}
+	return e.fn.impl(env, args)
+}
+
+// errInvalidParameter returns an invalid parameter value error
+func errInvalidParameter(format string, args ...interface{}) error {
+	return pgerror.NewError(pgerror.CodeInvalidParameterValue, format, args...)
+}
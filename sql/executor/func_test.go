@@ -0,0 +1,86 @@
+package executor
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// callFunc calls the overload of the function with the parameter types,
+// the arguments of variadic functions beyond them are of the type of the
+// last parameter
+func callFunc(t *testing.T, name string, params []types.Type, args ...interface{}) (interface{}, error) {
+	for _, f := range Functions(name) {
+		if !reflect.DeepEqual(f.Params, params) {
+			continue
+		}
+		exprs := make([]Expr, len(args))
+		for i, v := range args {
+			p := params[len(params)-1]
+			if i < len(params) {
+				p = params[i]
+			}
+			exprs[i] = NewConst(v, p)
+		}
+		return NewFunc(f, exprs).Eval(NewEnv(context.Background()), nil)
+	}
+	require.Failf(t, "no such function", "%s%v", name, params)
+	return nil, nil
+}
+
+func TestFunctions(t *testing.T) {
+	assert.Nil(t, Functions("nope"))
+	for name, fns := range functions {
+		for _, f := range fns {
+			assert.Equal(t, name, f.Name)
+			assert.NotNil(t, f.impl, name)
+		}
+	}
+}
+
+func TestFuncNullArgs(t *testing.T) {
+	v, err := callFunc(t, "lower", params(types.Text), nil)
+	require.NoError(t, err)
+	assert.Nil(t, v)
+	e := NewFunc(Functions("lower")[0], []Expr{NewConst("A", types.Text)})
+	assert.Equal(t, "lower('A'::text)", e.String())
+	assert.Equal(t, types.Text, e.Type())
+}
+
+func TestTimeFunctions(t *testing.T) {
+	env := NewEnv(context.Background())
+	for _, name := range []string{"now", "current_timestamp", "transaction_timestamp", "statement_timestamp"} {
+		v, err := NewFunc(Functions(name)[0], nil).Eval(env, nil)
+		require.NoError(t, err)
+		assert.Equal(t, env.Now, v, name)
+	}
+	v, err := NewFunc(Functions("current_date")[0], nil).Eval(env, nil)
+	require.NoError(t, err)
+	assert.Equal(t, env.Now.Truncate(24*time.Hour), v)
+}
+
+func TestSessionFunctions(t *testing.T) {
+	v, err := callFunc(t, "pi", nil)
+	require.NoError(t, err)
+	assert.InDelta(t, 3.14159, v, 0.0001)
+	v, err = callFunc(t, "random", nil)
+	require.NoError(t, err)
+	assert.True(t, v.(float64) >= 0 && v.(float64) < 1)
+
+	v, err = callFunc(t, "gen_random_uuid", nil)
+	require.NoError(t, err)
+	u := v.(pgtype.UUID)
+	assert.Equal(t, byte(0x40), u[6]&0xf0)
+	assert.Equal(t, byte(0x80), u[8]&0xc0)
+
+	// outside a connection there are no session settings
+	v, err = callFunc(t, "current_user", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "", v)
+}
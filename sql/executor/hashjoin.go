@@ -0,0 +1,140 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package executor
+
+import (
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// JoinKey is a pair of expressions of the same type evaluated for the
+// left and the right rows of an equi-join, rows join when they are equal
+type JoinKey struct {
+	Left, Right Expr
+}
+
+// hashJoin joins the left rows to the right rows with the same keys,
+// found in a hash table built from the right rows
+type hashJoin struct {
+	joiner
+	left, right Operator
+	keys        []JoinKey
+	rows        []Row
+	table       map[string][]int
+	bucket      []int
+	buf         []byte
+}
+
+// NewHashJoin returns an operator joining the rows of left and right with
+// equal keys which satisfy cond, it may be nil. cond is evaluated for the
+// left columns followed by the right ones. Rows with NULL keys match no
+// row.
+func NewHashJoin(typ JoinType, left, right Operator, leftWidth, rightWidth int, keys []JoinKey, cond Expr) Operator {
+	return &hashJoin{
+		joiner: joiner{typ: typ, cond: cond, leftWidth: leftWidth, rightWidth: rightWidth},
+		left:   left,
+		right:  right,
+		keys:   keys,
+	}
+}
+
+func (h *hashJoin) Open(env *Env) error {
+	h.env, h.leftRow, h.unmatched = env, nil, 0
+	if err := h.left.Open(env); err != nil {
+		return err
+	}
+	rows, err := readAll(env, h.right, nil)
+	if err != nil {
+		return err
+	}
+	h.rows, h.matched = rows, make([]bool, len(rows))
+	h.table = make(map[string][]int)
+	for i, row := range rows {
+		key, ok, err := h.key(row, false)
+		if err != nil {
+			return err
+		}
+		if ok {
+			h.table[key] = append(h.table[key], i)
+		}
+	}
+	return nil
+}
+
+// key returns the hash key of the left or the right row, false when one
+// of the keys is NULL
+func (h *hashJoin) key(row Row, left bool) (string, bool, error) {
+	h.buf = h.buf[:0]
+	for _, k := range h.keys {
+		e := k.Right
+		if left {
+			e = k.Left
+		}
+		v, err := e.Eval(h.env, row)
+		if err != nil || v == nil {
+			return "", false, err
+		}
+		h.buf = types.AppendKey(h.buf, e.Type(), v)
+	}
+	return string(h.buf), true, nil
+}
+
+func (h *hashJoin) Next() (Row, error) {
+	for {
+		if h.leftRow == nil {
+			row, err := h.left.Next()
+			if err != nil {
+				return nil, err
+			}
+			if row == nil {
+				return h.nextUnmatched(h.rows), nil
+			}
+			key, ok, err := h.key(row, true)
+			if err != nil {
+				return nil, err
+			}
+			h.leftRow, h.leftMatched, h.bucket = row, false, nil
+			if ok {
+				h.bucket = h.table[key]
+			}
+		}
+		for h.leftRow != nil && len(h.bucket) > 0 {
+			i := h.bucket[0]
+			h.bucket = h.bucket[1:]
+			row, ok, err := h.match(h.leftRow, h.rows[i])
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				h.matched[i] = true
+			}
+			if row != nil {
+				return row, nil
+			}
+		}
+		if h.leftRow != nil {
+			if row := h.finishLeft(); row != nil {
+				return row, nil
+			}
+		}
+	}
+}
+
+func (h *hashJoin) Close() error {
+	h.rows, h.matched, h.table, h.bucket = nil, nil, nil, nil
+	return h.left.Close()
+}
@@ -0,0 +1,233 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package executor
+
+// JoinType is the kind of a join
+type JoinType int
+
+// Join types. Semi joins return the left rows with a matching right row,
+// anti joins the others, both without the right columns.
+const (
+	InnerJoin JoinType = iota
+	LeftJoin
+	RightJoin
+	FullJoin
+	SemiJoin
+	AntiJoin
+)
+
+var joinTypeNames = [...]string{"", "Left", "Right", "Full", "Semi", "Anti"}
+
+// String returns the name EXPLAIN gives to the join type, empty for inner
+// joins
+func (t JoinType) String() string { return joinTypeNames[t] }
+
+// joiner holds what the join algorithms have in common, the rows they
+// return are the left columns followed by the right ones
+type joiner struct {
+	typ         JoinType
+	leftWidth   int
+	rightWidth  int
+	cond        Expr
+	env         *Env
+	leftRow     Row
+	leftMatched bool
+	// matched records the right rows joined to a left row for right and
+	// full joins
+	matched []bool
+	// unmatched is the position of the next right row returned without a
+	// left row once the left rows are exhausted
+	unmatched int
+}
+
+// combine returns the row joining two rows, nil for either side is a row
+// of NULLs
+func (j *joiner) combine(left, right Row) Row {
+	row := make(Row, j.leftWidth+j.rightWidth)
+	copy(row, left)
+	copy(row[j.leftWidth:], right)
+	return row
+}
+
+// match tests the rows against the join condition, the row returned
+// when they match may be nil for semi joins which continue with the next
+// left row
+func (j *joiner) match(left, right Row) (Row, bool, error) {
+	row := j.combine(left, right)
+	if j.cond != nil {
+		v, err := j.cond.Eval(j.env, row)
+		if err != nil || v != true {
+			return nil, false, err
+		}
+	}
+	j.leftMatched = true
+	switch j.typ {
+	case SemiJoin:
+		j.leftRow = nil
+		return left, true, nil
+	case AntiJoin:
+		j.leftRow = nil
+		return nil, true, nil
+	}
+	return row, true, nil
+}
+
+// finishLeft returns the row for the current left row once all its right
+// rows were tried, nil when there is none
+func (j *joiner) finishLeft() Row {
+	left := j.leftRow
+	j.leftRow = nil
+	if j.leftMatched {
+		return nil
+	}
+	switch j.typ {
+	case LeftJoin, FullJoin:
+		return j.combine(left, nil)
+	case AntiJoin:
+		return left
+	}
+	return nil
+}
+
+// nextUnmatched returns the next right row without a matching left row
+// for right and full joins
+func (j *joiner) nextUnmatched(rows []Row) Row {
+	if j.typ != RightJoin && j.typ != FullJoin {
+		return nil
+	}
+	for j.unmatched < len(rows) {
+		i := j.unmatched
+		j.unmatched++
+		if !j.matched[i] {
+			return j.combine(nil, rows[i])
+		}
+	}
+	return nil
+}
+
+// nestedLoop joins each left row to every right row
+type nestedLoop struct {
+	joiner
+	left, right Operator
+	lateral     bool
+	rows        []Row
+	pos         int
+	opened      bool
+}
+
+// NewNestedLoop returns an operator joining the rows of left with those
+// of right satisfying cond, which is evaluated for the left columns
+// followed by the right ones and may be nil. The right rows are read once
+// unless lateral is set, then right runs again for each left row which
+// it sees as the row of its enclosing query. Lateral joins must be inner,
+// left, semi or anti joins.
+func NewNestedLoop(typ JoinType, left, right Operator, leftWidth, rightWidth int, cond Expr, lateral bool) Operator {
+	return &nestedLoop{
+		joiner:  joiner{typ: typ, cond: cond, leftWidth: leftWidth, rightWidth: rightWidth},
+		left:    left,
+		right:   right,
+		lateral: lateral,
+	}
+}
+
+func (n *nestedLoop) Open(env *Env) error {
+	n.env, n.leftRow, n.unmatched = env, nil, 0
+	if err := n.left.Open(env); err != nil {
+		return err
+	}
+	if n.lateral {
+		return nil
+	}
+	rows, err := readAll(env, n.right, nil)
+	if err != nil {
+		return err
+	}
+	n.rows, n.matched = rows, make([]bool, len(rows))
+	return nil
+}
+
+// readAll returns the rows of an operator, outer is visible to it as the
+// row of its enclosing query unless it is nil
+func readAll(env *Env, op Operator, outer Row) (rows []Row, err error) {
+	if outer != nil {
+		env.outer = append(env.outer, outer)
+		defer func() { env.outer = env.outer[:len(env.outer)-1] }()
+	}
+	defer func() {
+		if closeErr := op.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	if err := op.Open(env); err != nil {
+		return nil, err
+	}
+	for {
+		row, err := op.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			return rows, nil
+		}
+		rows = append(rows, row)
+	}
+}
+
+func (n *nestedLoop) Next() (Row, error) {
+	for {
+		if n.leftRow == nil {
+			row, err := n.left.Next()
+			if err != nil {
+				return nil, err
+			}
+			if row == nil {
+				return n.nextUnmatched(n.rows), nil
+			}
+			if n.lateral {
+				if n.rows, err = readAll(n.env, n.right, row); err != nil {
+					return nil, err
+				}
+			}
+			n.leftRow, n.leftMatched, n.pos = row, false, 0
+		}
+		for n.leftRow != nil && n.pos < len(n.rows) {
+			i := n.pos
+			n.pos++
+			row, ok, err := n.match(n.leftRow, n.rows[i])
+			if err != nil {
+				return nil, err
+			}
+			if ok && n.matched != nil && !n.lateral {
+				n.matched[i] = true
+			}
+			if row != nil {
+				return row, nil
+			}
+		}
+		if n.leftRow != nil {
+			if row := n.finishLeft(); row != nil {
+				return row, nil
+			}
+		}
+	}
+}
+
+func (n *nestedLoop) Close() error {
+	n.rows, n.matched = nil, nil
+	return n.left.Close()
+}
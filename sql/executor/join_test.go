@@ -0,0 +1,113 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// joinInputs returns the inputs of the join tests sorted by their first
+// column with NULLs last
+func joinInputs() (left, right Operator) {
+	left = valuesOf(
+		[]interface{}{int64(1), int64(10)},
+		[]interface{}{int64(2), int64(20)},
+		[]interface{}{int64(2), int64(21)},
+		[]interface{}{int64(4), int64(40)},
+		[]interface{}{nil, int64(30)},
+	)
+	right = valuesOf(
+		[]interface{}{int64(2), int64(200)},
+		[]interface{}{int64(2), int64(201)},
+		[]interface{}{int64(3), int64(300)},
+		[]interface{}{nil, int64(400)},
+	)
+	return left, right
+}
+
+// joinResults returns the rows the join tests expect for each join type
+func joinResults() map[JoinType][]Row {
+	inner := []Row{
+		{int64(2), int64(20), int64(2), int64(200)},
+		{int64(2), int64(20), int64(2), int64(201)},
+		{int64(2), int64(21), int64(2), int64(200)},
+		{int64(2), int64(21), int64(2), int64(201)},
+	}
+	leftOnly := []Row{
+		{int64(1), int64(10), nil, nil},
+		{int64(4), int64(40), nil, nil},
+		{nil, int64(30), nil, nil},
+	}
+	rightOnly := []Row{
+		{nil, nil, int64(3), int64(300)},
+		{nil, nil, nil, int64(400)},
+	}
+	concat := func(rows ...[]Row) []Row {
+		var all []Row
+		for _, r := range rows {
+			all = append(all, r...)
+		}
+		return all
+	}
+	return map[JoinType][]Row{
+		InnerJoin: inner,
+		LeftJoin:  concat(inner, leftOnly),
+		RightJoin: concat(inner, rightOnly),
+		FullJoin:  concat(inner, leftOnly, rightOnly),
+		SemiJoin:  {{int64(2), int64(20)}, {int64(2), int64(21)}},
+		AntiJoin:  {{int64(1), int64(10)}, {int64(4), int64(40)}, {nil, int64(30)}},
+	}
+}
+
+var joinKeys = []JoinKey{{Left: NewColumn(0, "l.k", types.Int4), Right: NewColumn(0, "r.k", types.Int4)}}
+
+func TestNestedLoop(t *testing.T) {
+	cond := NewCompare("=", NewColumn(0, "l.k", types.Int4), NewColumn(2, "r.k", types.Int4))
+	for typ, want := range joinResults() {
+		left, right := joinInputs()
+		assert.ElementsMatch(t, want, collect(t, NewNestedLoop(typ, left, right, 2, 2, cond, false)), typ.String())
+	}
+
+	left, right := joinInputs()
+	assert.Len(t, collect(t, NewNestedLoop(InnerJoin, left, right, 2, 2, nil, false)), 20)
+}
+
+func TestLateralNestedLoop(t *testing.T) {
+	// each left row k joins the row k * 100 when k is even
+	k := NewOuterColumn(1, 0, "l.k", types.Int4)
+	right := NewFilter(
+		NewValues([][]Expr{{NewArith("*", k, int4(100), types.Int4)}}),
+		NewCompare("=", NewArith("%", k, int4(2), types.Int4), int4(0)),
+	)
+	left, _ := joinInputs()
+	assert.Equal(t, []Row{
+		{int64(1), int64(10), nil},
+		{int64(2), int64(20), int64(200)},
+		{int64(2), int64(21), int64(200)},
+		{int64(4), int64(40), int64(400)},
+		{nil, int64(30), nil},
+	}, collect(t, NewNestedLoop(LeftJoin, left, right, 2, 1, nil, true)))
+
+	left, _ = joinInputs()
+	assert.Equal(t, []Row{{int64(1), int64(10)}, {nil, int64(30)}},
+		collect(t, NewNestedLoop(AntiJoin, left, right, 2, 1, nil, true)))
+}
+
+func TestHashJoin(t *testing.T) {
+	for typ, want := range joinResults() {
+		left, right := joinInputs()
+		assert.ElementsMatch(t, want, collect(t, NewHashJoin(typ, left, right, 2, 2, joinKeys, nil)), typ.String())
+	}
+
+	// rows whose matches fail the condition are unmatched
+	cond := NewCompare(">", NewColumn(3, "r.v", types.Int4), int4(200))
+	left, right := joinInputs()
+	assert.ElementsMatch(t, []Row{
+		{int64(1), int64(10), nil, nil},
+		{int64(2), int64(20), int64(2), int64(201)},
+		{int64(2), int64(21), int64(2), int64(201)},
+		{int64(4), int64(40), nil, nil},
+		{nil, int64(30), nil, nil},
+	}, collect(t, NewHashJoin(LeftJoin, left, right, 2, 2, joinKeys, cond)))
+}
@@ -0,0 +1,173 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package executor
+
+import (
+	"fmt"
+	"unicode"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// likeToken is a character of a LIKE pattern, _ matches any character and
+// % any sequence of characters unless escaped
+type likeToken struct {
+	r   rune
+	any bool
+	seq bool
+}
+
+// likePattern is a compiled LIKE pattern
+type likePattern []likeToken
+
+// compileLike compiles the pattern, esc is the escape character or -1
+func compileLike(pattern string, esc rune, fold bool) (likePattern, error) {
+	var p likePattern
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			escaped = false
+		case r == esc:
+			escaped = true
+			continue
+		case r == '_':
+			p = append(p, likeToken{any: true})
+			continue
+		case r == '%':
+			p = append(p, likeToken{seq: true})
+			continue
+		}
+		if fold {
+			r = unicode.ToLower(r)
+		}
+		p = append(p, likeToken{r: r})
+	}
+	if escaped {
+		return nil, pgerror.NewError(pgerror.CodeInvalidEscapeSequence, "LIKE pattern must not end with escape character")
+	}
+	return p, nil
+}
+
+// match reports whether the pattern matches all of s, backtracking to the
+// last % when a character does not match
+func (p likePattern) match(s []rune) bool {
+	si, pi := 0, 0
+	seqP, seqS := -1, 0
+	for si < len(s) {
+		switch {
+		case pi < len(p) && p[pi].seq:
+			seqP, seqS = pi, si
+			pi++
+		case pi < len(p) && (p[pi].any || p[pi].r == s[si]):
+			si++
+			pi++
+		case seqP >= 0:
+			// let the last % match one more character
+			seqS++
+			pi, si = seqP+1, seqS
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi].seq {
+		pi++
+	}
+	return pi == len(p)
+}
+
+// likeExpr matches a string against a LIKE or ILIKE pattern
+type likeExpr struct {
+	expr, pattern Expr
+	// escape is nil for the default escape character \
+	escape Expr
+	fold   bool
+	not    bool
+
+	// the last compiled pattern, patterns are usually constant
+	lastPattern string
+	lastEscape  rune
+	compiled    likePattern
+}
+
+// NewLike returns expr LIKE pattern ESCAPE escape of text values, ILIKE
+// when fold is set and NOT LIKE when not is set. escape is nil without an
+// ESCAPE clause.
+func NewLike(expr, pattern, escape Expr, fold, not bool) Expr {
+	return &likeExpr{expr: expr, pattern: pattern, escape: escape, fold: fold, not: not}
+}
+
+func (e *likeExpr) Type() types.Type { return types.Bool }
+
+func (e *likeExpr) String() string {
+	op := "~~"
+	if e.fold {
+		op += "*"
+	}
+	if e.not {
+		op = "!" + op
+	}
+	if e.escape != nil {
+		return fmt.Sprintf("(%s %s %s ESCAPE %s)", e.expr, op, e.pattern, e.escape)
+	}
+	return fmt.Sprintf("(%s %s %s)", e.expr, op, e.pattern)
+}
+
+func (e *likeExpr) Eval(env *Env, row Row) (interface{}, error) {
+	v, err := e.expr.Eval(env, row)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	pattern, err := e.pattern.Eval(env, row)
+	if err != nil || pattern == nil {
+		return nil, err
+	}
+	esc := '\\'
+	if e.escape != nil {
+		s, err := e.escape.Eval(env, row)
+		if err != nil || s == nil {
+			return nil, err
+		}
+		runes := []rune(s.(string))
+		switch len(runes) {
+		case 0:
+			esc = -1
+		case 1:
+			esc = runes[0]
+		default:
+			err := pgerror.NewError(pgerror.CodeInvalidEscapeSequence, "invalid escape string")
+			err.Hint = "Escape string must be empty or one character."
+			return nil, err
+		}
+	}
+	if e.compiled == nil || pattern != e.lastPattern || esc != e.lastEscape {
+		compiled, err := compileLike(pattern.(string), esc, e.fold)
+		if err != nil {
+			return nil, err
+		}
+		e.compiled, e.lastPattern, e.lastEscape = compiled, pattern.(string), esc
+	}
+	s := []rune(v.(string))
+	if e.fold {
+		for i, r := range s {
+			s[i] = unicode.ToLower(r)
+		}
+	}
+	return e.compiled.match(s) != e.not, nil
+}
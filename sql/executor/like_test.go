@@ -0,0 +1,61 @@
+package executor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLike(t *testing.T) {
+	tests := []struct {
+		s, pattern string
+		escape     interface{}
+		fold       bool
+		want       interface{}
+	}{
+		{"hello", "hello", nil, false, true},
+		{"hello", "h%", nil, false, true},
+		{"hello", "%l_o", nil, false, true},
+		{"hello", "h_o", nil, false, false},
+		{"hello", "%", nil, false, true},
+		{"", "%", nil, false, true},
+		{"", "_", nil, false, false},
+		{"HeLLo", "hello", nil, true, true},
+		{"HeLLo", "hello", nil, false, false},
+		{"a%b", `a\%b`, nil, false, true},
+		{"axb", `a\%b`, nil, false, false},
+		{"a%b", "a#%b", "#", false, true},
+		{`a\b`, `a\b`, "", false, true},
+		{"ab", "a%%%b", nil, false, true},
+		{"héllo", "h_llo", nil, false, true},
+		{"ÉTÉ", "été", nil, true, true},
+		{"multi\nline", "multi%", nil, false, true},
+	}
+	for _, test := range tests {
+		var escape Expr
+		if test.escape != nil {
+			escape = NewConst(test.escape, types.Text)
+		}
+		e := NewLike(NewConst(test.s, types.Text), NewConst(test.pattern, types.Text), escape, test.fold, false)
+		assert.Equal(t, test.want, eval(t, e, nil), "%q LIKE %q", test.s, test.pattern)
+		e = NewLike(NewConst(test.s, types.Text), NewConst(test.pattern, types.Text), escape, test.fold, true)
+		assert.Equal(t, test.want != true, eval(t, e, nil), "%q NOT LIKE %q", test.s, test.pattern)
+	}
+}
+
+func TestLikeNull(t *testing.T) {
+	null := NewConst(nil, types.Text)
+	assert.Nil(t, eval(t, NewLike(null, NewConst("%", types.Text), nil, false, false), nil))
+	assert.Nil(t, eval(t, NewLike(NewConst("a", types.Text), null, nil, false, false), nil))
+	assert.Equal(t, "('a'::text !~~* NULL)", NewLike(NewConst("a", types.Text), NewConst(nil, types.Unknown), nil, true, true).String())
+}
+
+func TestLikeErrors(t *testing.T) {
+	env := NewEnv(context.Background())
+	_, err := NewLike(NewConst("a", types.Text), NewConst(`a\`, types.Text), nil, false, false).Eval(env, nil)
+	assert.EqualError(t, err, "ERROR: LIKE pattern must not end with escape character (SQLSTATE 22025)")
+	_, err = NewLike(NewConst("a", types.Text), NewConst("a", types.Text), NewConst("ab", types.Text), false, false).Eval(env, nil)
+	assert.EqualError(t, err, "ERROR: invalid escape string (SQLSTATE 22025)")
+}
@@ -0,0 +1,220 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package executor
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// floatFn returns a function of one double precision argument
+func floatFn(name string, f func(x float64) (float64, error)) *Function {
+	return fn(name, params(types.Float8), types.Float8, func(_ *Env, args []interface{}) (interface{}, error) {
+		return f(args[0].(float64))
+	})
+}
+
+// numericFn returns a function of one numeric argument, NaN and infinity
+// are returned unchanged
+func numericFn(name string, f func(x *big.Rat, scale int) string) *Function {
+	return fn(name, params(types.Numeric), types.Numeric, func(_ *Env, args []interface{}) (interface{}, error) {
+		s := args[0].(string)
+		x, ok := types.ParseNumeric(s)
+		if !ok {
+			return s, nil
+		}
+		return f(x, types.NumericScale(s)), nil
+	})
+}
+
+// numericScaleFn returns a function of a numeric and a scale
+func numericScaleFn(name string, f func(x *big.Rat, scale int) string) *Function {
+	return fn(name, params(types.Numeric, types.Int4), types.Numeric, func(_ *Env, args []interface{}) (interface{}, error) {
+		s := args[0].(string)
+		x, ok := types.ParseNumeric(s)
+		if !ok {
+			return s, nil
+		}
+		scale := args[1].(int64)
+		if scale > 1000 {
+			scale = 1000
+		} else if scale < -1000 {
+			scale = -1000
+		}
+		return f(x, int(scale)), nil
+	})
+}
+
+func init() {
+	register(
+		fn("abs", params(types.Int4), types.Int4, absInt(types.Int4)),
+		fn("abs", params(types.Int8), types.Int8, absInt(types.Int8)),
+		numericFn("abs", func(x *big.Rat, scale int) string { return types.FormatNumeric(x.Abs(x), scale) }),
+		floatFn("abs", func(x float64) (float64, error) { return math.Abs(x), nil }),
+		numericFn("sign", func(x *big.Rat, _ int) string { return big.NewRat(int64(x.Sign()), 1).FloatString(0) }),
+		floatFn("sign", func(x float64) (float64, error) {
+			switch {
+			case x > 0:
+				return 1, nil
+			case x < 0:
+				return -1, nil
+			}
+			return 0, nil
+		}),
+		numericFn("ceil", func(x *big.Rat, _ int) string { return ceilRat(x) }),
+		numericFn("ceiling", func(x *big.Rat, _ int) string { return ceilRat(x) }),
+		floatFn("ceil", func(x float64) (float64, error) { return math.Ceil(x), nil }),
+		floatFn("ceiling", func(x float64) (float64, error) { return math.Ceil(x), nil }),
+		numericFn("floor", func(x *big.Rat, _ int) string { return floorRat(x).String() }),
+		floatFn("floor", func(x float64) (float64, error) { return math.Floor(x), nil }),
+		numericFn("round", func(x *big.Rat, _ int) string { return roundRat(x, 0) }),
+		numericScaleFn("round", roundRat),
+		floatFn("round", func(x float64) (float64, error) { return math.RoundToEven(x), nil }),
+		numericFn("trunc", func(x *big.Rat, _ int) string { return truncRat(x, 0) }),
+		numericScaleFn("trunc", truncRat),
+		floatFn("trunc", func(x float64) (float64, error) { return math.Trunc(x), nil }),
+		fn("mod", params(types.Int4, types.Int4), types.Int4, modFn(types.Int4)),
+		fn("mod", params(types.Int8, types.Int8), types.Int8, modFn(types.Int8)),
+		fn("mod", params(types.Numeric, types.Numeric), types.Numeric, modFn(types.Numeric)),
+		floatFn("sqrt", func(x float64) (float64, error) {
+			if x < 0 {
+				return 0, pgerror.NewError(pgerror.CodeInvalidArgumentForPowerFunction, "cannot take square root of a negative number")
+			}
+			return math.Sqrt(x), nil
+		}),
+		floatFn("cbrt", func(x float64) (float64, error) { return math.Cbrt(x), nil }),
+		floatFn("exp", func(x float64) (float64, error) { return checkFloat(math.Exp(x), x) }),
+		floatFn("ln", func(x float64) (float64, error) { return logarithm(math.Log, x) }),
+		floatFn("log", func(x float64) (float64, error) { return logarithm(math.Log10, x) }),
+		fn("power", params(types.Float8, types.Float8), types.Float8, power),
+		fn("pow", params(types.Float8, types.Float8), types.Float8, power),
+	)
+}
+
+func absInt(t types.Type) func(*Env, []interface{}) (interface{}, error) {
+	return func(_ *Env, args []interface{}) (interface{}, error) {
+		n := args[0].(int64)
+		if n < 0 {
+			return arithInt("-", 0, n, t)
+		}
+		return n, nil
+	}
+}
+
+func modFn(t types.Type) func(*Env, []interface{}) (interface{}, error) {
+	return func(_ *Env, args []interface{}) (interface{}, error) {
+		if t.OID == pgtype.NumericOID {
+			return arithNumeric("%", args[0].(string), args[1].(string))
+		}
+		return arithInt("%", args[0].(int64), args[1].(int64), t)
+	}
+}
+
+// floorRat returns the largest integer not greater than x
+func floorRat(x *big.Rat) *big.Int {
+	// the denominator is positive, Div rounds towards negative infinity
+	return new(big.Int).Div(x.Num(), x.Denom())
+}
+
+// ceilRat returns the smallest integer not less than x
+func ceilRat(x *big.Rat) string {
+	f := floorRat(x)
+	if !x.IsInt() {
+		f.Add(f, big.NewInt(1))
+	}
+	return f.String()
+}
+
+// pow10 returns 10 to the power of n
+func pow10(n int) *big.Rat {
+	p := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(intAbs(n))), nil)
+	if n < 0 {
+		return new(big.Rat).SetFrac(big.NewInt(1), p)
+	}
+	return new(big.Rat).SetInt(p)
+}
+
+func intAbs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// roundRat rounds x half away from zero to scale digits after the decimal
+// point, a negative scale rounds to tens, hundreds and so on
+func roundRat(x *big.Rat, scale int) string {
+	if scale >= 0 {
+		return types.FormatNumeric(x, scale)
+	}
+	shifted := new(big.Rat).Mul(x, pow10(scale))
+	r, _ := types.ParseNumeric(types.FormatNumeric(shifted, 0))
+	return types.FormatNumeric(r.Mul(r, pow10(-scale)), 0)
+}
+
+// truncRat truncates x towards zero to scale digits after the decimal
+// point
+func truncRat(x *big.Rat, scale int) string {
+	shifted := new(big.Rat).Mul(x, pow10(scale))
+	q := new(big.Int).Quo(shifted.Num(), shifted.Denom())
+	r := new(big.Rat).SetInt(q)
+	r.Mul(r, pow10(-scale))
+	if scale < 0 {
+		scale = 0
+	}
+	return types.FormatNumeric(r, scale)
+}
+
+// checkFloat reports an overflow when an operation on finite values
+// returns infinity
+func checkFloat(r float64, args ...float64) (float64, error) {
+	if math.IsInf(r, 0) {
+		for _, x := range args {
+			if math.IsInf(x, 0) {
+				return r, nil
+			}
+		}
+		return 0, pgerror.NewError(pgerror.CodeNumericValueOutOfRange, "value out of range: overflow")
+	}
+	return r, nil
+}
+
+func logarithm(log func(float64) float64, x float64) (float64, error) {
+	switch {
+	case x == 0:
+		return 0, pgerror.NewError(pgerror.CodeInvalidArgumentForLogarithm, "cannot take logarithm of zero")
+	case x < 0:
+		return 0, pgerror.NewError(pgerror.CodeInvalidArgumentForLogarithm, "cannot take logarithm of a negative number")
+	}
+	return log(x), nil
+}
+
+func power(_ *Env, args []interface{}) (interface{}, error) {
+	x, y := args[0].(float64), args[1].(float64)
+	switch {
+	case x == 0 && y < 0:
+		return nil, pgerror.NewError(pgerror.CodeInvalidArgumentForPowerFunction, "zero raised to a negative power is undefined")
+	case x < 0 && y != math.Trunc(y):
+		return nil, pgerror.NewError(pgerror.CodeInvalidArgumentForPowerFunction, "a negative number raised to a non-integer power yields a complex result")
+	}
+	return checkFloat(math.Pow(x, y), x, y)
+}
@@ -0,0 +1,72 @@
+package executor
+
+import (
+	"math"
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMathFunctions(t *testing.T) {
+	num, float8, int4 := types.Numeric, types.Float8, types.Int4
+	tests := []struct {
+		name   string
+		params []types.Type
+		args   []interface{}
+		want   interface{}
+	}{
+		{"abs", params(int4), []interface{}{int64(-3)}, int64(3)},
+		{"abs", params(num), []interface{}{"-1.50"}, "1.50"},
+		{"abs", params(float8), []interface{}{-2.5}, 2.5},
+		{"sign", params(num), []interface{}{"-0.5"}, "-1"},
+		{"ceil", params(num), []interface{}{"-1.5"}, "-1"},
+		{"ceil", params(num), []interface{}{"1.2"}, "2"},
+		{"floor", params(num), []interface{}{"-1.5"}, "-2"},
+		{"floor", params(float8), []interface{}{1.5}, 1.0},
+		{"round", params(num), []interface{}{"2.5"}, "3"},
+		{"round", params(num), []interface{}{"-2.5"}, "-3"},
+		{"round", params(num, int4), []interface{}{"2.345", int64(2)}, "2.35"},
+		{"round", params(num, int4), []interface{}{"1234.5", int64(-2)}, "1200"},
+		{"round", params(float8), []interface{}{2.5}, 2.0},
+		{"trunc", params(num, int4), []interface{}{"-2.349", int64(2)}, "-2.34"},
+		{"mod", params(int4, int4), []interface{}{int64(-7), int64(3)}, int64(-1)},
+		{"mod", params(num, num), []interface{}{"7.5", "2"}, "1.5"},
+		{"sqrt", params(float8), []interface{}{16.0}, 4.0},
+		{"cbrt", params(float8), []interface{}{27.0}, 3.0},
+		{"ln", params(float8), []interface{}{math.E}, 1.0},
+		{"log", params(float8), []interface{}{1000.0}, 3.0},
+		{"power", params(float8, float8), []interface{}{2.0, 10.0}, 1024.0},
+		{"power", params(float8, float8), []interface{}{4.0, 0.5}, 2.0},
+	}
+	for _, test := range tests {
+		v, err := callFunc(t, test.name, test.params, test.args...)
+		require.NoError(t, err, "%s%v", test.name, test.args)
+		assert.Equal(t, test.want, v, "%s%v", test.name, test.args)
+	}
+}
+
+func TestMathFunctionErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		params []types.Type
+		args   []interface{}
+		code   string
+	}{
+		{"sqrt", params(types.Float8), []interface{}{-1.0}, pgerror.CodeInvalidArgumentForPowerFunction},
+		{"ln", params(types.Float8), []interface{}{0.0}, pgerror.CodeInvalidArgumentForLogarithm},
+		{"log", params(types.Float8), []interface{}{-1.0}, pgerror.CodeInvalidArgumentForLogarithm},
+		{"power", params(types.Float8, types.Float8), []interface{}{-1.0, 0.5}, pgerror.CodeInvalidArgumentForPowerFunction},
+		{"power", params(types.Float8, types.Float8), []interface{}{0.0, -1.0}, pgerror.CodeInvalidArgumentForPowerFunction},
+		{"exp", params(types.Float8), []interface{}{1000.0}, pgerror.CodeNumericValueOutOfRange},
+		{"mod", params(types.Int4, types.Int4), []interface{}{int64(1), int64(0)}, pgerror.CodeDivisionByZero},
+		{"abs", params(types.Int4), []interface{}{int64(math.MinInt32)}, pgerror.CodeNumericValueOutOfRange},
+	}
+	for _, test := range tests {
+		_, err := callFunc(t, test.name, test.params, test.args...)
+		require.Error(t, err, "%s%v", test.name, test.args)
+		assert.Equal(t, test.code, err.(*pgerror.Error).Code, "%s%v", test.name, test.args)
+	}
+}
@@ -0,0 +1,241 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package executor
+
+import (
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// mergeJoin joins two inputs sorted by their keys in ascending order with
+// NULLs last, reading the right rows with equal keys as a group
+type mergeJoin struct {
+	joiner
+	left, right Operator
+	keys        []JoinKey
+	// group holds the right rows whose keys are groupKey
+	group    []Row
+	groupKey []interface{}
+	// next is the first right row after the group, nil at the end
+	next     Row
+	nextKey  []interface{}
+	pos      int
+	queue    []Row
+	leftDone bool
+}
+
+// NewMergeJoin returns an operator joining the rows of left and right with
+// equal keys which satisfy cond, it may be nil. Both inputs must be sorted
+// by their keys in ascending order with NULLs last. cond is evaluated for
+// the left columns followed by the right ones. Rows with NULL keys match
+// no row.
+func NewMergeJoin(typ JoinType, left, right Operator, leftWidth, rightWidth int, keys []JoinKey, cond Expr) Operator {
+	return &mergeJoin{
+		joiner: joiner{typ: typ, cond: cond, leftWidth: leftWidth, rightWidth: rightWidth},
+		left:   left,
+		right:  right,
+		keys:   keys,
+	}
+}
+
+func (m *mergeJoin) Open(env *Env) error {
+	m.env, m.leftRow, m.leftDone = env, nil, false
+	m.group, m.groupKey, m.queue = nil, nil, nil
+	if err := m.left.Open(env); err != nil {
+		return err
+	}
+	if err := m.right.Open(env); err != nil {
+		return err
+	}
+	return m.advance()
+}
+
+// advance reads the next right row
+func (m *mergeJoin) advance() error {
+	row, err := m.right.Next()
+	if err != nil || row == nil {
+		m.next, m.nextKey = nil, nil
+		return err
+	}
+	key, err := m.keyValues(row, false)
+	if err != nil {
+		return err
+	}
+	m.next, m.nextKey = row, key
+	return nil
+}
+
+// keyValues returns the keys of the left or the right row, nil when one
+// of them is NULL
+func (m *mergeJoin) keyValues(row Row, left bool) ([]interface{}, error) {
+	key := make([]interface{}, len(m.keys))
+	for i, k := range m.keys {
+		e := k.Right
+		if left {
+			e = k.Left
+		}
+		v, err := e.Eval(m.env, row)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		key[i] = v
+	}
+	return key, nil
+}
+
+// compare orders the keys of two rows, NULL keys after all others
+func (m *mergeJoin) compare(a, b []interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	for i, k := range m.keys {
+		if c := types.Compare(k.Left.Type(), a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// releaseGroup drops the group, queueing its unmatched rows for right and
+// full joins
+func (m *mergeJoin) releaseGroup() {
+	m.unmatched = 0
+	for row := m.nextUnmatched(m.group); row != nil; row = m.nextUnmatched(m.group) {
+		m.queue = append(m.queue, row)
+	}
+	m.group, m.groupKey, m.matched = nil, nil, nil
+}
+
+// seek moves the group to the right rows whose keys are key, queueing the
+// right rows before them for right and full joins
+func (m *mergeJoin) seek(key []interface{}) error {
+	if m.group != nil && m.compare(m.groupKey, key) >= 0 {
+		return nil
+	}
+	m.releaseGroup()
+	for m.next != nil && m.compare(m.nextKey, key) < 0 {
+		if m.typ == RightJoin || m.typ == FullJoin {
+			m.queue = append(m.queue, m.combine(nil, m.next))
+		}
+		if err := m.advance(); err != nil {
+			return err
+		}
+	}
+	if m.next == nil || m.nextKey == nil || m.compare(m.nextKey, key) != 0 {
+		return nil
+	}
+	m.groupKey = m.nextKey
+	for m.next != nil && m.compare(m.nextKey, m.groupKey) == 0 {
+		m.group = append(m.group, m.next)
+		if err := m.advance(); err != nil {
+			return err
+		}
+	}
+	m.matched = make([]bool, len(m.group))
+	return nil
+}
+
+func (m *mergeJoin) Next() (Row, error) {
+	for {
+		if len(m.queue) > 0 {
+			row := m.queue[0]
+			m.queue = m.queue[1:]
+			return row, nil
+		}
+		if m.leftDone {
+			return nil, nil
+		}
+		if m.leftRow == nil {
+			row, err := m.left.Next()
+			if err != nil {
+				return nil, err
+			}
+			if row == nil {
+				if err := m.finishRight(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			key, err := m.keyValues(row, true)
+			if err != nil {
+				return nil, err
+			}
+			m.leftRow, m.leftMatched = row, false
+			if key != nil {
+				if err := m.seek(key); err != nil {
+					return nil, err
+				}
+			}
+			m.pos = len(m.group)
+			if key != nil && m.group != nil && m.compare(m.groupKey, key) == 0 {
+				m.pos = 0
+			}
+			// the right rows before the left row come first
+			continue
+		}
+		for m.leftRow != nil && m.pos < len(m.group) {
+			i := m.pos
+			m.pos++
+			row, ok, err := m.match(m.leftRow, m.group[i])
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				m.matched[i] = true
+			}
+			if row != nil {
+				return row, nil
+			}
+		}
+		if m.leftRow != nil {
+			if row := m.finishLeft(); row != nil {
+				return row, nil
+			}
+		}
+	}
+}
+
+// finishRight queues the right rows left once the left rows are
+// exhausted for right and full joins
+func (m *mergeJoin) finishRight() error {
+	m.leftDone = true
+	m.releaseGroup()
+	if m.typ != RightJoin && m.typ != FullJoin {
+		return nil
+	}
+	for m.next != nil {
+		m.queue = append(m.queue, m.combine(nil, m.next))
+		if err := m.advance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mergeJoin) Close() error {
+	m.group, m.queue, m.next = nil, nil, nil
+	err := m.left.Close()
+	if rightErr := m.right.Close(); err == nil {
+		err = rightErr
+	}
+	return err
+}
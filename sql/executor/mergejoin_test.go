@@ -0,0 +1,42 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeJoin(t *testing.T) {
+	for typ, want := range joinResults() {
+		left, right := joinInputs()
+		assert.ElementsMatch(t, want, collect(t, NewMergeJoin(typ, left, right, 2, 2, joinKeys, nil)), typ.String())
+	}
+
+	// the right rows before and after the left keys and the groups of
+	// equal keys on both sides
+	left := valuesOf(
+		[]interface{}{int64(3), int64(1)},
+		[]interface{}{int64(5), int64(2)},
+		[]interface{}{int64(5), int64(3)},
+		[]interface{}{int64(7), int64(4)},
+	)
+	right := valuesOf(
+		[]interface{}{int64(1), int64(10)},
+		[]interface{}{int64(5), int64(20)},
+		[]interface{}{int64(5), int64(30)},
+		[]interface{}{int64(6), int64(40)},
+		[]interface{}{int64(9), int64(50)},
+	)
+	cond := NewCompare("<>", NewColumn(3, "r.v", types.Int4), int4(30))
+	assert.Equal(t, []Row{
+		{nil, nil, int64(1), int64(10)},
+		{int64(3), int64(1), nil, nil},
+		{int64(5), int64(2), int64(5), int64(20)},
+		{int64(5), int64(3), int64(5), int64(20)},
+		{nil, nil, int64(5), int64(30)},
+		{nil, nil, int64(6), int64(40)},
+		{int64(7), int64(4), nil, nil},
+		{nil, nil, int64(9), int64(50)},
+	}, collect(t, NewMergeJoin(FullJoin, left, right, 2, 2, joinKeys, cond)))
+}
@@ -0,0 +1,342 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package executor
+
+import (
+	"github.com/patrickglass/dsql/sql/catalog"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// Operator is a node of a query plan producing its rows one at a time.
+// Open must succeed before Next is called, Close releases the resources
+// of the operator and its inputs even when Open failed.
+type Operator interface {
+	Open(env *Env) error
+	// Next returns the next row, nil once there are no more rows. The row
+	// must not be modified.
+	Next() (Row, error)
+	Close() error
+}
+
+// scan returns the rows of a table as they were when it was opened
+type scan struct {
+	table *catalog.Table
+	alias string
+	env   *Env
+	rows  []catalog.Row
+	pos   int
+}
+
+// NewScan returns an operator reading the rows of the table, alias is the
+// name the query gives to it or empty
+func NewScan(t *catalog.Table, alias string) Operator {
+	return &scan{table: t, alias: alias}
+}
+
+func (s *scan) Open(env *Env) error {
+	s.env, s.rows, s.pos = env, s.table.Rows(), 0
+	return nil
+}
+
+func (s *scan) Next() (Row, error) {
+	if s.pos >= len(s.rows) {
+		return nil, nil
+	}
+	// scans of large tables producing few rows are canceled promptly
+	if err := s.env.Context.Err(); err != nil {
+		return nil, err
+	}
+	row := s.rows[s.pos]
+	s.pos++
+	return Row(row), nil
+}
+
+func (s *scan) Close() error {
+	s.rows = nil
+	return nil
+}
+
+// values returns rows of expressions which do not depend on other rows
+type values struct {
+	rows [][]Expr
+	env  *Env
+	pos  int
+}
+
+// NewValues returns an operator evaluating the expressions of each row,
+// a single row without expressions is the input of a SELECT without FROM
+func NewValues(rows [][]Expr) Operator {
+	return &values{rows: rows}
+}
+
+func (v *values) Open(env *Env) error {
+	v.env, v.pos = env, 0
+	return nil
+}
+
+func (v *values) Next() (Row, error) {
+	if v.pos >= len(v.rows) {
+		return nil, nil
+	}
+	exprs := v.rows[v.pos]
+	v.pos++
+	return evalAll(v.env, exprs, nil)
+}
+
+func (v *values) Close() error { return nil }
+
+// evalAll returns the values of the expressions for the row
+func evalAll(env *Env, exprs []Expr, row Row) (Row, error) {
+	out := make(Row, len(exprs))
+	for i, e := range exprs {
+		v, err := e.Eval(env, row)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+// filter returns the rows of its input satisfying a condition
+type filter struct {
+	input Operator
+	cond  Expr
+	env   *Env
+}
+
+// NewFilter returns an operator skipping the rows for which the boolean
+// condition is false or NULL
+func NewFilter(input Operator, cond Expr) Operator {
+	return &filter{input: input, cond: cond}
+}
+
+func (f *filter) Open(env *Env) error {
+	f.env = env
+	return f.input.Open(env)
+}
+
+func (f *filter) Next() (Row, error) {
+	for {
+		row, err := f.input.Next()
+		if err != nil || row == nil {
+			return nil, err
+		}
+		v, err := f.cond.Eval(f.env, row)
+		if err != nil {
+			return nil, err
+		}
+		if v == true {
+			return row, nil
+		}
+	}
+}
+
+func (f *filter) Close() error { return f.input.Close() }
+
+// project computes new rows from the rows of its input
+type project struct {
+	input Operator
+	exprs []Expr
+	env   *Env
+}
+
+// NewProject returns an operator evaluating the expressions for every row
+// of its input
+func NewProject(input Operator, exprs []Expr) Operator {
+	return &project{input: input, exprs: exprs}
+}
+
+func (p *project) Open(env *Env) error {
+	p.env = env
+	return p.input.Open(env)
+}
+
+func (p *project) Next() (Row, error) {
+	row, err := p.input.Next()
+	if err != nil || row == nil {
+		return nil, err
+	}
+	return evalAll(p.env, p.exprs, row)
+}
+
+func (p *project) Close() error { return p.input.Close() }
+
+// limit skips the first offset rows of its input and stops after count
+type limit struct {
+	input         Operator
+	count, offset Expr
+	remaining     int64
+}
+
+// NewLimit returns an operator implementing LIMIT and OFFSET, count and
+// offset are bigint expressions evaluated when it is opened. A nil or NULL
+// count returns all rows.
+func NewLimit(input Operator, count, offset Expr) Operator {
+	return &limit{input: input, count: count, offset: offset}
+}
+
+func (l *limit) Open(env *Env) error {
+	count, err := limitValue(env, l.count, pgerror.CodeInvalidRowCountInLimitClause, "LIMIT")
+	if err != nil {
+		return err
+	}
+	offset, err := limitValue(env, l.offset, pgerror.CodeInvalidRowCountInResultOffset, "OFFSET")
+	if err != nil {
+		return err
+	}
+	if err := l.input.Open(env); err != nil {
+		return err
+	}
+	for ; offset > 0; offset-- {
+		row, err := l.input.Next()
+		if err != nil || row == nil {
+			return err
+		}
+	}
+	l.remaining = count
+	return nil
+}
+
+// limitValue evaluates the argument of LIMIT or OFFSET, -1 when there is
+// none
+func limitValue(env *Env, e Expr, code, clause string) (int64, error) {
+	if e == nil {
+		return -1, nil
+	}
+	v, err := e.Eval(env, nil)
+	if err != nil || v == nil {
+		return -1, err
+	}
+	n := v.(int64)
+	if n < 0 {
+		return -1, pgerror.NewError(code, "%s must not be negative", clause)
+	}
+	return n, nil
+}
+
+func (l *limit) Next() (Row, error) {
+	if l.remaining == 0 {
+		return nil, nil
+	}
+	row, err := l.input.Next()
+	if err != nil || row == nil {
+		return nil, err
+	}
+	if l.remaining > 0 {
+		l.remaining--
+	}
+	return row, nil
+}
+
+func (l *limit) Close() error { return l.input.Close() }
+
+// distinct removes the rows of its input whose keys were already returned
+type distinct struct {
+	input Operator
+	keys  []Expr
+	env   *Env
+	seen  map[string]struct{}
+	buf   []byte
+}
+
+// NewDistinct returns an operator returning the first row of its input
+// for each distinct value of the keys, NULLs are equal to each other
+func NewDistinct(input Operator, keys []Expr) Operator {
+	return &distinct{input: input, keys: keys}
+}
+
+func (d *distinct) Open(env *Env) error {
+	d.env, d.seen = env, make(map[string]struct{})
+	return d.input.Open(env)
+}
+
+func (d *distinct) Next() (Row, error) {
+	for {
+		row, err := d.input.Next()
+		if err != nil || row == nil {
+			return nil, err
+		}
+		d.buf = d.buf[:0]
+		for _, k := range d.keys {
+			v, err := k.Eval(d.env, row)
+			if err != nil {
+				return nil, err
+			}
+			d.buf = types.AppendKey(d.buf, k.Type(), v)
+		}
+		if _, ok := d.seen[string(d.buf)]; ok {
+			continue
+		}
+		d.seen[string(d.buf)] = struct{}{}
+		return row, nil
+	}
+}
+
+func (d *distinct) Close() error {
+	d.seen = nil
+	return d.input.Close()
+}
+
+// Rows streams the rows of an operator to the server, it implements the
+// Rows interface of the server
+type Rows struct {
+	op     Operator
+	row    Row
+	err    error
+	done   bool
+	closed bool
+}
+
+// NewRows returns the rows of the opened operator
+func NewRows(op Operator) *Rows {
+	return &Rows{op: op}
+}
+
+// Next advances to the next row
+func (r *Rows) Next() bool {
+	if r.done {
+		return false
+	}
+	r.row, r.err = r.op.Next()
+	if r.err != nil || r.row == nil {
+		r.done = true
+		return false
+	}
+	return true
+}
+
+// Values returns the values of the current row
+func (r *Rows) Values() []interface{} {
+	return r.row
+}
+
+// Err returns the error which ended the rows
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Close closes the operator, it may be called more than once
+func (r *Rows) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed, r.done = true, true
+	return r.op.Close()
+}
@@ -0,0 +1,120 @@
+package executor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/catalog"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// int4 returns an integer constant
+func int4(n int64) Expr {
+	return NewConst(n, types.Int4)
+}
+
+// valuesOf returns an operator returning rows of integer constants
+func valuesOf(rows ...[]interface{}) Operator {
+	exprs := make([][]Expr, len(rows))
+	for i, row := range rows {
+		for _, v := range row {
+			exprs[i] = append(exprs[i], NewConst(v, types.Int4))
+		}
+	}
+	return NewValues(exprs)
+}
+
+// collect opens the operator and returns its rows
+func collect(t *testing.T, op Operator) []Row {
+	require.NoError(t, op.Open(NewEnv(context.Background())))
+	var rows []Row
+	for {
+		row, err := op.Next()
+		require.NoError(t, err)
+		if row == nil {
+			break
+		}
+		rows = append(rows, row)
+	}
+	require.NoError(t, op.Close())
+	return rows
+}
+
+func TestScan(t *testing.T) {
+	table, err := catalog.New().CreateTable(catalog.DefaultSchema, "t", []*catalog.Column{{Name: "a", Type: types.Int4}}, nil)
+	require.NoError(t, err)
+	tx := table.Begin()
+	require.NoError(t, tx.Insert(catalog.Row{int64(1)}))
+	require.NoError(t, tx.Insert(catalog.Row{int64(2)}))
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, []Row{{int64(1)}, {int64(2)}}, collect(t, NewScan(table, "")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	scan := NewScan(table, "")
+	require.NoError(t, scan.Open(NewEnv(ctx)))
+	_, err = scan.Next()
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestFilterProject(t *testing.T) {
+	a := NewColumn(0, "a", types.Int4)
+	input := valuesOf([]interface{}{int64(1)}, []interface{}{int64(2)}, []interface{}{nil}, []interface{}{int64(3)})
+	op := NewProject(NewFilter(input, NewCompare("<>", a, int4(2))), []Expr{NewArith("*", a, int4(10), types.Int4)})
+	// NULL conditions filter rows out like false
+	assert.Equal(t, []Row{{int64(10)}, {int64(30)}}, collect(t, op))
+}
+
+func TestLimit(t *testing.T) {
+	input := func() Operator {
+		return valuesOf([]interface{}{int64(1)}, []interface{}{int64(2)}, []interface{}{int64(3)})
+	}
+	int8 := func(v interface{}) Expr { return NewConst(v, types.Int8) }
+	assert.Equal(t, []Row{{int64(1)}, {int64(2)}}, collect(t, NewLimit(input(), int8(int64(2)), nil)))
+	assert.Equal(t, []Row{{int64(2)}}, collect(t, NewLimit(input(), int8(int64(1)), int8(int64(1)))))
+	assert.Equal(t, []Row{{int64(3)}}, collect(t, NewLimit(input(), int8(nil), int8(int64(2)))))
+	assert.Nil(t, collect(t, NewLimit(input(), int8(int64(0)), nil)))
+	assert.Nil(t, collect(t, NewLimit(input(), nil, int8(int64(5)))))
+
+	err := NewLimit(input(), int8(int64(-1)), nil).Open(NewEnv(context.Background()))
+	assert.EqualError(t, err, "ERROR: LIMIT must not be negative (SQLSTATE 2201W)")
+	err = NewLimit(input(), nil, int8(int64(-1))).Open(NewEnv(context.Background()))
+	assert.Equal(t, pgerror.CodeInvalidRowCountInResultOffset, err.(*pgerror.Error).Code)
+}
+
+func TestDistinct(t *testing.T) {
+	input := valuesOf(
+		[]interface{}{int64(1), int64(1)},
+		[]interface{}{int64(1), int64(2)},
+		[]interface{}{nil, int64(3)},
+		[]interface{}{nil, int64(4)},
+		[]interface{}{int64(2), int64(5)},
+	)
+	op := NewDistinct(input, []Expr{NewColumn(0, "a", types.Int4)})
+	assert.Equal(t, []Row{{int64(1), int64(1)}, {nil, int64(3)}, {int64(2), int64(5)}}, collect(t, op))
+	// reopening starts over
+	assert.Len(t, collect(t, op), 3)
+}
+
+func TestRows(t *testing.T) {
+	op := valuesOf([]interface{}{int64(1)}, []interface{}{int64(2)})
+	require.NoError(t, op.Open(NewEnv(context.Background())))
+	rows := NewRows(op)
+	require.True(t, rows.Next())
+	assert.Equal(t, []interface{}{int64(1)}, rows.Values())
+	require.True(t, rows.Next())
+	assert.False(t, rows.Next())
+	assert.False(t, rows.Next())
+	assert.NoError(t, rows.Err())
+	assert.NoError(t, rows.Close())
+	assert.NoError(t, rows.Close())
+
+	failing := NewProject(valuesOf([]interface{}{int64(0)}), []Expr{NewArith("/", int4(1), NewColumn(0, "a", types.Int4), types.Int4)})
+	require.NoError(t, failing.Open(NewEnv(context.Background())))
+	rows = NewRows(failing)
+	assert.False(t, rows.Next())
+	assert.Equal(t, pgerror.CodeDivisionByZero, rows.Err().(*pgerror.Error).Code)
+}
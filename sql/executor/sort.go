@@ -0,0 +1,132 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package executor
+
+import (
+	"sort"
+
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// SortKey is an expression rows are ordered by
+type SortKey struct {
+	Expr       Expr
+	Desc       bool
+	NullsFirst bool
+}
+
+// sortedRow is a row with the values of its sort keys
+type sortedRow struct {
+	row  Row
+	keys []interface{}
+}
+
+// sortOp returns the rows of its input in the order of the keys, it reads
+// all of them when it is opened
+type sortOp struct {
+	input Operator
+	keys  []SortKey
+	rows  []sortedRow
+	pos   int
+}
+
+// NewSort returns an operator ordering the rows of its input, rows with
+// equal keys keep their order
+func NewSort(input Operator, keys []SortKey) Operator {
+	return &sortOp{input: input, keys: keys}
+}
+
+func (s *sortOp) Open(env *Env) error {
+	if err := s.input.Open(env); err != nil {
+		return err
+	}
+	s.rows, s.pos = nil, 0
+	for {
+		row, err := s.input.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		keys, err := evalKeys(env, s.keys, row)
+		if err != nil {
+			return err
+		}
+		s.rows = append(s.rows, sortedRow{row: row, keys: keys})
+	}
+	sort.SliceStable(s.rows, func(i, j int) bool {
+		return compareKeys(s.keys, s.rows[i].keys, s.rows[j].keys) < 0
+	})
+	return nil
+}
+
+// evalKeys returns the values of the sort keys for the row
+func evalKeys(env *Env, keys []SortKey, row Row) ([]interface{}, error) {
+	values := make([]interface{}, len(keys))
+	for i, k := range keys {
+		v, err := k.Expr.Eval(env, row)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// compareKeys compares the values of the sort keys of two rows
+func compareKeys(keys []SortKey, a, b []interface{}) int {
+	for i, k := range keys {
+		var c int
+		switch {
+		case a[i] == nil && b[i] == nil:
+			continue
+		case a[i] == nil:
+			c = 1
+		case b[i] == nil:
+			c = -1
+		default:
+			c = types.Compare(k.Expr.Type(), a[i], b[i])
+		}
+		if a[i] == nil || b[i] == nil {
+			if k.NullsFirst {
+				c = -c
+			}
+		} else if k.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func (s *sortOp) Next() (Row, error) {
+	if s.pos >= len(s.rows) {
+		return nil, nil
+	}
+	row := s.rows[s.pos].row
+	s.pos++
+	return row, nil
+}
+
+func (s *sortOp) Close() error {
+	s.rows = nil
+	return s.input.Close()
+}
@@ -0,0 +1,37 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSort(t *testing.T) {
+	input := func() Operator {
+		return valuesOf(
+			[]interface{}{int64(2), int64(1)},
+			[]interface{}{nil, int64(2)},
+			[]interface{}{int64(1), int64(3)},
+			[]interface{}{int64(2), int64(4)},
+		)
+	}
+	a, b := NewColumn(0, "a", types.Int4), NewColumn(1, "b", types.Int4)
+	tests := []struct {
+		keys []SortKey
+		want []interface{}
+	}{
+		// equal keys keep their order
+		{[]SortKey{{Expr: a}}, []interface{}{int64(3), int64(1), int64(4), int64(2)}},
+		{[]SortKey{{Expr: a, Desc: true, NullsFirst: true}}, []interface{}{int64(2), int64(1), int64(4), int64(3)}},
+		{[]SortKey{{Expr: a, Desc: true}, {Expr: b, Desc: true}}, []interface{}{int64(4), int64(1), int64(3), int64(2)}},
+		{[]SortKey{{Expr: a, NullsFirst: true}}, []interface{}{int64(2), int64(3), int64(1), int64(4)}},
+	}
+	for _, test := range tests {
+		var got []interface{}
+		for _, row := range collect(t, NewSort(input(), test.keys)) {
+			got = append(got, row[1])
+		}
+		assert.Equal(t, test.want, got, "%+v", test.keys)
+	}
+}
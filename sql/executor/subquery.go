@@ -0,0 +1,257 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package executor
+
+import (
+	"fmt"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// outerColumnExpr is a column of the row of an enclosing query
+type outerColumnExpr struct {
+	depth int
+	index int
+	name  string
+	typ   types.Type
+}
+
+// NewOuterColumn returns a reference to column index of the row of the
+// query enclosing a subquery, depth is 1 for the query directly enclosing
+// it, 2 for the one enclosing that and so on
+func NewOuterColumn(depth, index int, name string, t types.Type) Expr {
+	return &outerColumnExpr{depth: depth, index: index, name: name, typ: t}
+}
+
+func (e *outerColumnExpr) Type() types.Type { return e.typ }
+func (e *outerColumnExpr) String() string   { return e.name }
+
+func (e *outerColumnExpr) Eval(env *Env, row Row) (interface{}, error) {
+	return env.outer[len(env.outer)-e.depth][e.index], nil
+}
+
+// Subquery is a query run by an expression. A correlated subquery refers
+// to the rows of enclosing queries and runs again for each of them, the
+// rows of the others are read once.
+type Subquery struct {
+	Plan       Operator
+	Correlated bool
+
+	cached bool
+	rows   []Row
+}
+
+// each calls fn with the rows of the subquery run for the outer row until
+// it returns false
+func (s *Subquery) each(env *Env, outer Row, fn func(Row) (bool, error)) error {
+	if s.cached {
+		for _, row := range s.rows {
+			if more, err := fn(row); err != nil || !more {
+				return err
+			}
+		}
+		return nil
+	}
+	if !s.Correlated {
+		// read all rows once, fn sees them below
+		if err := s.run(env, outer, func(row Row) (bool, error) {
+			s.rows = append(s.rows, row)
+			return true, nil
+		}); err != nil {
+			s.rows = nil
+			return err
+		}
+		s.cached = true
+		return s.each(env, outer, fn)
+	}
+	return s.run(env, outer, fn)
+}
+
+// run opens the plan with the outer row visible to it
+func (s *Subquery) run(env *Env, outer Row, fn func(Row) (bool, error)) (err error) {
+	env.outer = append(env.outer, outer)
+	defer func() {
+		env.outer = env.outer[:len(env.outer)-1]
+		if closeErr := s.Plan.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	if err := s.Plan.Open(env); err != nil {
+		return err
+	}
+	for {
+		row, err := s.Plan.Next()
+		if err != nil || row == nil {
+			return err
+		}
+		if more, err := fn(row); err != nil || !more {
+			return err
+		}
+	}
+}
+
+// scalarSubqueryExpr is the value of the single column of the single row
+// of a subquery, NULL without rows
+type scalarSubqueryExpr struct {
+	sub *Subquery
+	typ types.Type
+}
+
+// NewScalarSubquery returns the value of a subquery returning a column of
+// type t
+func NewScalarSubquery(sub *Subquery, t types.Type) Expr {
+	return &scalarSubqueryExpr{sub: sub, typ: t}
+}
+
+func (e *scalarSubqueryExpr) Type() types.Type { return e.typ }
+func (e *scalarSubqueryExpr) String() string   { return "(SubPlan)" }
+
+func (e *scalarSubqueryExpr) Eval(env *Env, row Row) (interface{}, error) {
+	var v interface{}
+	found := false
+	err := e.sub.each(env, row, func(r Row) (bool, error) {
+		if found {
+			return false, pgerror.NewError(pgerror.CodeCardinalityViolation, "more than one row returned by a subquery used as an expression")
+		}
+		v, found = r[0], true
+		return true, nil
+	})
+	return v, err
+}
+
+// existsExpr tests whether a subquery returns rows
+type existsExpr struct {
+	sub *Subquery
+}
+
+// NewExists returns EXISTS of the subquery
+func NewExists(sub *Subquery) Expr {
+	return &existsExpr{sub: sub}
+}
+
+func (e *existsExpr) Type() types.Type { return types.Bool }
+func (e *existsExpr) String() string   { return "EXISTS(SubPlan)" }
+
+func (e *existsExpr) Eval(env *Env, row Row) (interface{}, error) {
+	found := false
+	err := e.sub.each(env, row, func(Row) (bool, error) {
+		found = true
+		return false, nil
+	})
+	return found, err
+}
+
+// quantifiedExpr compares a value with the values of a subquery or an
+// array with ANY or ALL
+type quantifiedExpr struct {
+	op   string
+	expr Expr
+	all  bool
+	// sub is the subquery whose first column is compared, array is the
+	// array when there is no subquery
+	sub   *Subquery
+	array Expr
+}
+
+// NewQuantifiedSubquery returns expr op ANY (subquery), or ALL when all is
+// set. IN (subquery) is = ANY and NOT IN is <> ALL.
+func NewQuantifiedSubquery(op string, expr Expr, sub *Subquery, all bool) Expr {
+	return &quantifiedExpr{op: op, expr: expr, sub: sub, all: all}
+}
+
+// NewQuantifiedArray returns expr op ANY (array), or ALL when all is set.
+// The elements of the array are of the type of expr.
+func NewQuantifiedArray(op string, expr, array Expr, all bool) Expr {
+	return &quantifiedExpr{op: op, expr: expr, array: array, all: all}
+}
+
+func (e *quantifiedExpr) Type() types.Type { return types.Bool }
+
+func (e *quantifiedExpr) String() string {
+	q := "ANY"
+	if e.all {
+		q = "ALL"
+	}
+	if e.sub != nil {
+		return fmt.Sprintf("(%s %s %s (SubPlan))", e.expr, e.op, q)
+	}
+	return fmt.Sprintf("(%s %s %s (%s))", e.expr, e.op, q, e.array)
+}
+
+// Eval is true when the comparison is true for any value, with ALL when it
+// is true for all values. Like a chain of OR or AND the result is NULL
+// when a NULL comparison would decide it.
+func (e *quantifiedExpr) Eval(env *Env, row Row) (interface{}, error) {
+	v, err := e.expr.Eval(env, row)
+	if err != nil {
+		return nil, err
+	}
+	result := interface{}(e.all)
+	test := func(w interface{}) bool {
+		if v == nil || w == nil {
+			result = nil
+			return true
+		}
+		if compareOp(e.op, types.Compare(e.expr.Type(), v, w)) != e.all {
+			// decided by this value
+			result = !e.all
+			return false
+		}
+		return true
+	}
+	if e.sub != nil {
+		err = e.sub.each(env, row, func(r Row) (bool, error) { return test(r[0]), nil })
+		return result, err
+	}
+	array, err := e.array.Eval(env, row)
+	if err != nil || array == nil {
+		return nil, err
+	}
+	for _, w := range array.([]interface{}) {
+		if !test(w) {
+			break
+		}
+	}
+	return result, nil
+}
+
+// arrayExpr builds an array of its elements
+type arrayExpr struct {
+	elems []Expr
+	typ   types.Type
+}
+
+// NewArray returns ARRAY[elems] of the array type t
+func NewArray(elems []Expr, t types.Type) Expr {
+	return &arrayExpr{elems: elems, typ: t}
+}
+
+func (e *arrayExpr) Type() types.Type { return e.typ }
+func (e *arrayExpr) String() string {
+	s := callString("", e.elems)
+	return "ARRAY[" + s[1:len(s)-1] + "]"
+}
+
+func (e *arrayExpr) Eval(env *Env, row Row) (interface{}, error) {
+	values, err := evalAll(env, e.elems, row)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}(values), nil
+}
@@ -0,0 +1,112 @@
+package executor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingValues counts how often its rows are read
+type countingValues struct {
+	Operator
+	opened int
+}
+
+func (v *countingValues) Open(env *Env) error {
+	v.opened++
+	return v.Operator.Open(env)
+}
+
+func TestScalarSubquery(t *testing.T) {
+	plan := &countingValues{Operator: valuesOf([]interface{}{int64(7)})}
+	e := NewScalarSubquery(&Subquery{Plan: plan}, types.Int4)
+	assert.Equal(t, int64(7), eval(t, e, nil))
+	env := NewEnv(context.Background())
+	for i := 0; i < 3; i++ {
+		v, err := e.Eval(env, Row{int64(i)})
+		require.NoError(t, err)
+		assert.Equal(t, int64(7), v)
+	}
+	// uncorrelated subqueries run once
+	assert.Equal(t, 1, plan.opened)
+
+	empty := NewScalarSubquery(&Subquery{Plan: NewValues(nil)}, types.Int4)
+	assert.Nil(t, eval(t, empty, nil))
+
+	many := NewScalarSubquery(&Subquery{Plan: valuesOf([]interface{}{int64(1)}, []interface{}{int64(2)})}, types.Int4)
+	_, err := many.Eval(env, nil)
+	assert.EqualError(t, err, "ERROR: more than one row returned by a subquery used as an expression (SQLSTATE 21000)")
+}
+
+func TestCorrelatedSubquery(t *testing.T) {
+	// (SELECT outer.a * 2)
+	plan := &countingValues{Operator: NewValues([][]Expr{{
+		NewArith("*", NewOuterColumn(1, 0, "a", types.Int4), int4(2), types.Int4),
+	}})}
+	e := NewScalarSubquery(&Subquery{Plan: plan, Correlated: true}, types.Int4)
+	env := NewEnv(context.Background())
+	for i := int64(1); i <= 3; i++ {
+		v, err := e.Eval(env, Row{i})
+		require.NoError(t, err)
+		assert.Equal(t, i*2, v)
+	}
+	assert.Equal(t, 3, plan.opened)
+	assert.Empty(t, env.outer)
+}
+
+func TestExists(t *testing.T) {
+	assert.Equal(t, true, eval(t, NewExists(&Subquery{Plan: valuesOf([]interface{}{nil})}), nil))
+	assert.Equal(t, false, eval(t, NewExists(&Subquery{Plan: NewValues(nil)}), nil))
+}
+
+func TestQuantified(t *testing.T) {
+	sub := func(values ...interface{}) *Subquery {
+		rows := make([][]interface{}, len(values))
+		for i, v := range values {
+			rows[i] = []interface{}{v}
+		}
+		return &Subquery{Plan: valuesOf(rows...)}
+	}
+	tests := []struct {
+		op     string
+		value  interface{}
+		all    bool
+		values []interface{}
+		want   interface{}
+	}{
+		{"=", int64(2), false, []interface{}{int64(1), int64(2)}, true},
+		{"=", int64(3), false, []interface{}{int64(1), int64(2)}, false},
+		{"=", int64(3), false, []interface{}{int64(1), nil}, nil},
+		{"=", int64(1), false, []interface{}{int64(1), nil}, true},
+		{"=", int64(1), false, nil, false},
+		{"<>", int64(3), true, []interface{}{int64(1), int64(2)}, true},
+		{"<>", int64(3), true, []interface{}{int64(1), nil}, nil},
+		{"<>", int64(1), true, []interface{}{int64(1), nil}, false},
+		{">", int64(3), true, nil, true},
+		{">", nil, true, []interface{}{int64(1)}, nil},
+	}
+	for _, test := range tests {
+		value := NewConst(test.value, types.Int4)
+		e := NewQuantifiedSubquery(test.op, value, sub(test.values...), test.all)
+		assert.Equal(t, test.want, eval(t, e, nil), e.String())
+
+		array := NewConst(test.values, types.Type{OID: 1007, Modifier: -1})
+		if test.values == nil {
+			array = NewConst([]interface{}{}, array.Type())
+		}
+		e = NewQuantifiedArray(test.op, value, array, test.all)
+		assert.Equal(t, test.want, eval(t, e, nil), e.String())
+	}
+	null := NewQuantifiedArray("=", int4(1), NewConst(nil, types.Unknown), false)
+	assert.Nil(t, eval(t, null, nil))
+}
+
+func TestArray(t *testing.T) {
+	arrayType, _ := types.ArrayOf(types.Int4)
+	e := NewArray([]Expr{int4(1), NewConst(nil, types.Int4)}, arrayType)
+	assert.Equal(t, []interface{}{int64(1), nil}, eval(t, e, nil))
+	assert.Equal(t, "ARRAY[1, NULL]", e.String())
+}
@@ -0,0 +1,290 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package executor
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// textFn returns a function of one text argument
+func textFn(name string, result types.Type, f func(s string) interface{}) *Function {
+	return fn(name, params(types.Text), result, func(_ *Env, args []interface{}) (interface{}, error) {
+		return f(args[0].(string)), nil
+	})
+}
+
+func init() {
+	register(
+		textFn("lower", types.Text, func(s string) interface{} { return strings.ToLower(s) }),
+		textFn("upper", types.Text, func(s string) interface{} { return strings.ToUpper(s) }),
+		textFn("initcap", types.Text, initcap),
+		textFn("length", types.Int4, charLength),
+		textFn("char_length", types.Int4, charLength),
+		textFn("character_length", types.Int4, charLength),
+		textFn("octet_length", types.Int4, func(s string) interface{} { return int64(len(s)) }),
+		textFn("reverse", types.Text, func(s string) interface{} {
+			r := []rune(s)
+			for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+				r[i], r[j] = r[j], r[i]
+			}
+			return string(r)
+		}),
+		textFn("md5", types.Text, func(s string) interface{} {
+			sum := md5.Sum([]byte(s))
+			return hex.EncodeToString(sum[:])
+		}),
+		textFn("ascii", types.Int4, func(s string) interface{} {
+			r, _ := utf8.DecodeRuneInString(s)
+			if r == utf8.RuneError {
+				return int64(0)
+			}
+			return int64(r)
+		}),
+		fn("chr", params(types.Int4), types.Text, func(_ *Env, args []interface{}) (interface{}, error) {
+			n := args[0].(int64)
+			switch {
+			case n == 0:
+				return nil, errInvalidParameter("null character not permitted")
+			case n < 0 || n > unicode.MaxRune:
+				return nil, errInvalidParameter("requested character too large for encoding: %d", n)
+			}
+			return string(rune(n)), nil
+		}),
+		fn("substring", params(types.Text, types.Int4), types.Text, substring),
+		fn("substring", params(types.Text, types.Int4, types.Int4), types.Text, substring),
+		fn("substr", params(types.Text, types.Int4), types.Text, substring),
+		fn("substr", params(types.Text, types.Int4, types.Int4), types.Text, substring),
+		fn("left", params(types.Text, types.Int4), types.Text, func(_ *Env, args []interface{}) (interface{}, error) {
+			r := []rune(args[0].(string))
+			return string(r[:sideLength(len(r), args[1].(int64))]), nil
+		}),
+		fn("right", params(types.Text, types.Int4), types.Text, func(_ *Env, args []interface{}) (interface{}, error) {
+			r := []rune(args[0].(string))
+			return string(r[len(r)-sideLength(len(r), args[1].(int64)):]), nil
+		}),
+		fn("strpos", params(types.Text, types.Text), types.Int4, func(_ *Env, args []interface{}) (interface{}, error) {
+			s, sub := args[0].(string), args[1].(string)
+			i := strings.Index(s, sub)
+			if i < 0 {
+				return int64(0), nil
+			}
+			return int64(utf8.RuneCountInString(s[:i]) + 1), nil
+		}),
+		fn("starts_with", params(types.Text, types.Text), types.Bool, func(_ *Env, args []interface{}) (interface{}, error) {
+			return strings.HasPrefix(args[0].(string), args[1].(string)), nil
+		}),
+		fn("replace", params(types.Text, types.Text, types.Text), types.Text, func(_ *Env, args []interface{}) (interface{}, error) {
+			s, from := args[0].(string), args[1].(string)
+			if from == "" {
+				return s, nil
+			}
+			return strings.ReplaceAll(s, from, args[2].(string)), nil
+		}),
+		fn("repeat", params(types.Text, types.Int4), types.Text, func(_ *Env, args []interface{}) (interface{}, error) {
+			n := args[1].(int64)
+			if n <= 0 {
+				return "", nil
+			}
+			s := args[0].(string)
+			if int64(len(s))*n > maxTextLength {
+				return nil, errTooLong()
+			}
+			return strings.Repeat(s, int(n)), nil
+		}),
+		fn("split_part", params(types.Text, types.Text, types.Int4), types.Text, splitPart),
+		fn("btrim", params(types.Text), types.Text, trimFn(true, true)),
+		fn("btrim", params(types.Text, types.Text), types.Text, trimFn(true, true)),
+		fn("ltrim", params(types.Text), types.Text, trimFn(true, false)),
+		fn("ltrim", params(types.Text, types.Text), types.Text, trimFn(true, false)),
+		fn("rtrim", params(types.Text), types.Text, trimFn(false, true)),
+		fn("rtrim", params(types.Text, types.Text), types.Text, trimFn(false, true)),
+		fn("lpad", params(types.Text, types.Int4), types.Text, padFn(true)),
+		fn("lpad", params(types.Text, types.Int4, types.Text), types.Text, padFn(true)),
+		fn("rpad", params(types.Text, types.Int4), types.Text, padFn(false)),
+		fn("rpad", params(types.Text, types.Int4, types.Text), types.Text, padFn(false)),
+		&Function{Name: "concat", Params: params(types.Text), Result: types.Text, Variadic: true, AnyArgs: true, NullArgs: true, impl: concat},
+		&Function{Name: "concat_ws", Params: params(types.Text, types.Text), Result: types.Text, Variadic: true, AnyArgs: true, NullArgs: true, impl: concatWS},
+	)
+}
+
+// maxTextLength is the length of the longest text value like in postgres
+const maxTextLength = 1<<30 - 1
+
+func errTooLong() error {
+	return pgerror.NewError(pgerror.CodeProgramLimitExceeded, "requested length too large")
+}
+
+func charLength(s string) interface{} {
+	return int64(utf8.RuneCountInString(s))
+}
+
+// initcap converts the first letter of each word to upper case and the
+// others to lower case
+func initcap(s string) interface{} {
+	r := []rune(s)
+	inWord := false
+	for i, c := range r {
+		if inWord {
+			r[i] = unicode.ToLower(c)
+		} else {
+			r[i] = unicode.ToUpper(c)
+		}
+		inWord = unicode.IsLetter(c) || unicode.IsDigit(c)
+	}
+	return string(r)
+}
+
+// substring returns count characters from position start, counted from 1
+func substring(_ *Env, args []interface{}) (interface{}, error) {
+	r := []rune(args[0].(string))
+	start := args[1].(int64)
+	end := int64(len(r)) + 1
+	if len(args) > 2 {
+		count := args[2].(int64)
+		if count < 0 {
+			return nil, pgerror.NewError(pgerror.CodeSubstringError, "negative substring length not allowed")
+		}
+		if start+count < end {
+			end = start + count
+		}
+	}
+	if start < 1 {
+		start = 1
+	}
+	if start >= end {
+		return "", nil
+	}
+	return string(r[start-1 : end-1]), nil
+}
+
+// sideLength returns how many characters of a string with length
+// characters left and right return, n < 0 removes -n characters
+func sideLength(length int, n int64) int {
+	if n < 0 {
+		n += int64(length)
+		if n < 0 {
+			n = 0
+		}
+	}
+	if n > int64(length) {
+		n = int64(length)
+	}
+	return int(n)
+}
+
+func splitPart(_ *Env, args []interface{}) (interface{}, error) {
+	s, delim, n := args[0].(string), args[1].(string), args[2].(int64)
+	if n == 0 {
+		return nil, errInvalidParameter("field position must not be zero")
+	}
+	parts := []string{s}
+	if delim != "" {
+		parts = strings.Split(s, delim)
+	}
+	if s == "" {
+		parts = nil
+	}
+	if n < 0 {
+		n += int64(len(parts)) + 1
+	}
+	if n < 1 || n > int64(len(parts)) {
+		return "", nil
+	}
+	return parts[n-1], nil
+}
+
+// trimFn returns btrim, ltrim or rtrim removing the characters of the
+// second argument, spaces by default
+func trimFn(left, right bool) func(*Env, []interface{}) (interface{}, error) {
+	return func(_ *Env, args []interface{}) (interface{}, error) {
+		s, chars := args[0].(string), " "
+		if len(args) > 1 {
+			chars = args[1].(string)
+		}
+		if left {
+			s = strings.TrimLeft(s, chars)
+		}
+		if right {
+			s = strings.TrimRight(s, chars)
+		}
+		return s, nil
+	}
+}
+
+// padFn returns lpad or rpad filling the string to a length with the
+// characters of the third argument, spaces by default
+func padFn(left bool) func(*Env, []interface{}) (interface{}, error) {
+	return func(_ *Env, args []interface{}) (interface{}, error) {
+		r, n := []rune(args[0].(string)), args[1].(int64)
+		fill := []rune(" ")
+		if len(args) > 2 {
+			fill = []rune(args[2].(string))
+		}
+		switch {
+		case n <= 0:
+			return "", nil
+		case n > maxTextLength/4:
+			return nil, errTooLong()
+		case int(n) <= len(r):
+			return string(r[:n]), nil
+		case len(fill) == 0:
+			return string(r), nil
+		}
+		pad := make([]rune, int(n)-len(r))
+		for i := range pad {
+			pad[i] = fill[i%len(fill)]
+		}
+		if left {
+			return string(pad) + string(r), nil
+		}
+		return string(r) + string(pad), nil
+	}
+}
+
+// concat concatenates the arguments ignoring NULLs
+func concat(_ *Env, args []interface{}) (interface{}, error) {
+	var b strings.Builder
+	for _, arg := range args {
+		if arg != nil {
+			b.WriteString(arg.(string))
+		}
+	}
+	return b.String(), nil
+}
+
+// concatWS concatenates the arguments but the first which separates them,
+// NULLs are ignored
+func concatWS(_ *Env, args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	var parts []string
+	for _, arg := range args[1:] {
+		if arg != nil {
+			parts = append(parts, arg.(string))
+		}
+	}
+	return strings.Join(parts, args[0].(string)), nil
+}
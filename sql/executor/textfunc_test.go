@@ -0,0 +1,71 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTextFunctions(t *testing.T) {
+	text, int4 := types.Text, types.Int4
+	tests := []struct {
+		name   string
+		params []types.Type
+		args   []interface{}
+		want   interface{}
+	}{
+		{"lower", params(text), []interface{}{"ÀbC"}, "àbc"},
+		{"upper", params(text), []interface{}{"àbc"}, "ÀBC"},
+		{"initcap", params(text), []interface{}{"hello wORLD-foo"}, "Hello World-Foo"},
+		{"length", params(text), []interface{}{"héllo"}, int64(5)},
+		{"octet_length", params(text), []interface{}{"héllo"}, int64(6)},
+		{"reverse", params(text), []interface{}{"héllo"}, "olléh"},
+		{"md5", params(text), []interface{}{"abc"}, "900150983cd24fb0d6963f7d28e17f72"},
+		{"ascii", params(text), []interface{}{"A"}, int64(65)},
+		{"ascii", params(text), []interface{}{""}, int64(0)},
+		{"chr", params(int4), []interface{}{int64(233)}, "é"},
+		{"substring", params(text, int4), []interface{}{"hello", int64(2)}, "ello"},
+		{"substring", params(text, int4, int4), []interface{}{"hello", int64(0), int64(3)}, "he"},
+		{"substr", params(text, int4, int4), []interface{}{"héllo", int64(2), int64(2)}, "él"},
+		{"left", params(text, int4), []interface{}{"hello", int64(2)}, "he"},
+		{"left", params(text, int4), []interface{}{"hello", int64(-2)}, "hel"},
+		{"right", params(text, int4), []interface{}{"hello", int64(2)}, "lo"},
+		{"right", params(text, int4), []interface{}{"hello", int64(-2)}, "llo"},
+		{"strpos", params(text, text), []interface{}{"héllo", "l"}, int64(3)},
+		{"strpos", params(text, text), []interface{}{"hello", "x"}, int64(0)},
+		{"starts_with", params(text, text), []interface{}{"hello", "he"}, true},
+		{"replace", params(text, text, text), []interface{}{"hello", "l", "L"}, "heLLo"},
+		{"repeat", params(text, int4), []interface{}{"ab", int64(3)}, "ababab"},
+		{"repeat", params(text, int4), []interface{}{"ab", int64(-1)}, ""},
+		{"split_part", params(text, text, int4), []interface{}{"a,b,c", ",", int64(2)}, "b"},
+		{"split_part", params(text, text, int4), []interface{}{"a,b,c", ",", int64(-1)}, "c"},
+		{"split_part", params(text, text, int4), []interface{}{"a,b,c", ",", int64(4)}, ""},
+		{"btrim", params(text), []interface{}{"  a  "}, "a"},
+		{"ltrim", params(text, text), []interface{}{"xxaxx", "x"}, "axx"},
+		{"rtrim", params(text, text), []interface{}{"xxaxx", "x"}, "xxa"},
+		{"lpad", params(text, int4), []interface{}{"a", int64(3)}, "  a"},
+		{"lpad", params(text, int4, text), []interface{}{"hello", int64(3), "x"}, "hel"},
+		{"rpad", params(text, int4, text), []interface{}{"a", int64(4), "xy"}, "axyx"},
+		{"concat", params(text), []interface{}{"a", nil, "b"}, "ab"},
+		{"concat_ws", params(text, text), []interface{}{",", "a", nil, "b"}, "a,b"},
+		{"concat_ws", params(text, text), []interface{}{nil, "a"}, nil},
+	}
+	for _, test := range tests {
+		v, err := callFunc(t, test.name, test.params, test.args...)
+		require.NoError(t, err, "%s%v", test.name, test.args)
+		assert.Equal(t, test.want, v, "%s%v", test.name, test.args)
+	}
+}
+
+func TestTextFunctionErrors(t *testing.T) {
+	_, err := callFunc(t, "substring", params(types.Text, types.Int4, types.Int4), "a", int64(1), int64(-1))
+	assert.EqualError(t, err, "ERROR: negative substring length not allowed (SQLSTATE 22011)")
+	_, err = callFunc(t, "chr", params(types.Int4), int64(0))
+	assert.EqualError(t, err, "ERROR: null character not permitted (SQLSTATE 22023)")
+	_, err = callFunc(t, "split_part", params(types.Text, types.Text, types.Int4), "a", ",", int64(0))
+	assert.EqualError(t, err, "ERROR: field position must not be zero (SQLSTATE 22023)")
+	_, err = callFunc(t, "repeat", params(types.Text, types.Int4), "abc", int64(1<<30))
+	assert.EqualError(t, err, "ERROR: requested length too large (SQLSTATE 54000)")
+}
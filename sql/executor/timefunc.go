@@ -0,0 +1,191 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package executor
+
+import (
+	"strings"
+	"time"
+
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+func init() {
+	register(
+		fn("date_part", params(types.Text, types.Timestamp), types.Float8, datePart(types.Timestamp)),
+		fn("date_part", params(types.Text, types.Timestamptz), types.Float8, datePart(types.Timestamptz)),
+		fn("date_part", params(types.Text, types.Interval), types.Float8, intervalPart),
+		fn("date_trunc", params(types.Text, types.Timestamp), types.Timestamp, dateTrunc(types.Timestamp)),
+		fn("date_trunc", params(types.Text, types.Timestamptz), types.Timestamptz, dateTrunc(types.Timestamptz)),
+	)
+}
+
+// errUnit is returned for a field which the type does not have
+func errUnit(unit string, t types.Type) error {
+	return errInvalidParameter("unit \"%s\" not recognized for type %s", unit, t)
+}
+
+// seconds returns the seconds of the time with their fraction
+func seconds(t time.Time) float64 {
+	return float64(t.Second()) + float64(t.Nanosecond())/1e9
+}
+
+func datePart(typ types.Type) func(*Env, []interface{}) (interface{}, error) {
+	return func(_ *Env, args []interface{}) (interface{}, error) {
+		unit, t := strings.ToLower(args[0].(string)), args[1].(time.Time)
+		year := t.Year()
+		switch unit {
+		case "microsecond", "microseconds":
+			return seconds(t) * 1e6, nil
+		case "millisecond", "milliseconds":
+			return seconds(t) * 1e3, nil
+		case "second", "seconds":
+			return seconds(t), nil
+		case "minute", "minutes":
+			return float64(t.Minute()), nil
+		case "hour", "hours":
+			return float64(t.Hour()), nil
+		case "day", "days":
+			return float64(t.Day()), nil
+		case "dow":
+			return float64(t.Weekday()), nil
+		case "isodow":
+			if t.Weekday() == time.Sunday {
+				return float64(7), nil
+			}
+			return float64(t.Weekday()), nil
+		case "doy":
+			return float64(t.YearDay()), nil
+		case "week":
+			_, week := t.ISOWeek()
+			return float64(week), nil
+		case "isoyear":
+			isoYear, _ := t.ISOWeek()
+			return float64(isoYear), nil
+		case "month", "months":
+			return float64(t.Month()), nil
+		case "quarter":
+			return float64((t.Month()-1)/3 + 1), nil
+		case "year", "years":
+			return float64(year), nil
+		case "decade":
+			return float64(floorDiv(year, 10)), nil
+		case "century":
+			if year > 0 {
+				return float64((year + 99) / 100), nil
+			}
+			return float64(-((99 - year) / 100)), nil
+		case "millennium":
+			if year > 0 {
+				return float64((year + 999) / 1000), nil
+			}
+			return float64(-((999 - year) / 1000)), nil
+		case "epoch":
+			return float64(t.Unix()) + float64(t.Nanosecond())/1e9, nil
+		case "timezone", "timezone_hour", "timezone_minute":
+			if typ.OID == pgtype.TimestamptzOID {
+				return float64(0), nil
+			}
+		}
+		return nil, errUnit(unit, typ)
+	}
+}
+
+// floorDiv divides rounding towards negative infinity
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+func intervalPart(_ *Env, args []interface{}) (interface{}, error) {
+	unit, iv := strings.ToLower(args[0].(string)), args[1].(pgtype.Interval)
+	micros := iv.Microseconds
+	switch unit {
+	case "microsecond", "microseconds":
+		return float64(micros % 60e6), nil
+	case "millisecond", "milliseconds":
+		return float64(micros%60e6) / 1e3, nil
+	case "second", "seconds":
+		return float64(micros%60e6) / 1e6, nil
+	case "minute", "minutes":
+		return float64(micros / 60e6 % 60), nil
+	case "hour", "hours":
+		return float64(micros / 3600e6), nil
+	case "day", "days":
+		return float64(iv.Days), nil
+	case "month", "months":
+		return float64(iv.Months % 12), nil
+	case "quarter":
+		return float64(iv.Months%12/3 + 1), nil
+	case "year", "years":
+		return float64(iv.Months / 12), nil
+	case "decade":
+		return float64(iv.Months / 120), nil
+	case "century":
+		return float64(iv.Months / 1200), nil
+	case "millennium":
+		return float64(iv.Months / 12000), nil
+	case "epoch":
+		// like postgres a year has 365.25 days and a month 30 days
+		years, months := iv.Months/12, iv.Months%12
+		days := float64(years)*365.25 + float64(months)*30 + float64(iv.Days)
+		return days*86400 + float64(micros)/1e6, nil
+	}
+	return nil, errUnit(unit, types.Interval)
+}
+
+func dateTrunc(typ types.Type) func(*Env, []interface{}) (interface{}, error) {
+	return func(_ *Env, args []interface{}) (interface{}, error) {
+		unit, t := strings.ToLower(args[0].(string)), args[1].(time.Time)
+		year, month, day := t.Date()
+		switch unit {
+		case "microsecond", "microseconds":
+			return t.Truncate(time.Microsecond), nil
+		case "millisecond", "milliseconds":
+			return t.Truncate(time.Millisecond), nil
+		case "second", "seconds":
+			return t.Truncate(time.Second), nil
+		case "minute", "minutes":
+			return t.Truncate(time.Minute), nil
+		case "hour", "hours":
+			return t.Truncate(time.Hour), nil
+		case "day", "days":
+			return time.Date(year, month, day, 0, 0, 0, 0, time.UTC), nil
+		case "week", "weeks":
+			// weeks start on monday
+			offset := (int(t.Weekday()) + 6) % 7
+			return time.Date(year, month, day-offset, 0, 0, 0, 0, time.UTC), nil
+		case "month", "months":
+			return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC), nil
+		case "quarter":
+			return time.Date(year, (month-1)/3*3+1, 1, 0, 0, 0, 0, time.UTC), nil
+		case "year", "years":
+			return time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC), nil
+		case "decade", "decades":
+			return time.Date(floorDiv(year, 10)*10, 1, 1, 0, 0, 0, 0, time.UTC), nil
+		case "century", "centuries":
+			return time.Date(floorDiv(year-1, 100)*100+1, 1, 1, 0, 0, 0, 0, time.UTC), nil
+		case "millennium", "millennia":
+			return time.Date(floorDiv(year-1, 1000)*1000+1, 1, 1, 0, 0, 0, 0, time.UTC), nil
+		}
+		return nil, errUnit(unit, typ)
+	}
+}
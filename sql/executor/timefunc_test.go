@@ -0,0 +1,69 @@
+package executor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatePart(t *testing.T) {
+	ts := time.Date(2024, 2, 29, 13, 4, 5, 250000000, time.UTC)
+	tests := []struct {
+		field string
+		want  float64
+	}{
+		{"year", 2024},
+		{"month", 2},
+		{"day", 29},
+		{"hour", 13},
+		{"minute", 4},
+		{"second", 5.25},
+		{"milliseconds", 5250},
+		{"dow", 4},
+		{"doy", 60},
+		{"quarter", 1},
+		{"decade", 202},
+		{"century", 21},
+		{"epoch", 1709211845.25},
+	}
+	for _, test := range tests {
+		v, err := callFunc(t, "date_part", params(types.Text, types.Timestamp), test.field, ts)
+		require.NoError(t, err, test.field)
+		assert.Equal(t, test.want, v, test.field)
+	}
+
+	iv := pgtype.Interval{Months: 14, Days: 3, Microseconds: 3723500000}
+	for field, want := range map[string]float64{"year": 1, "month": 2, "day": 3, "hour": 1, "minute": 2, "second": 3.5} {
+		v, err := callFunc(t, "date_part", params(types.Text, types.Interval), field, iv)
+		require.NoError(t, err, field)
+		assert.Equal(t, want, v, field)
+	}
+
+	_, err := callFunc(t, "date_part", params(types.Text, types.Timestamp), "fortnight", ts)
+	assert.EqualError(t, err, `ERROR: unit "fortnight" not recognized for type timestamp without time zone (SQLSTATE 22023)`)
+}
+
+func TestDateTrunc(t *testing.T) {
+	ts := time.Date(2024, 5, 16, 13, 4, 5, 250000000, time.UTC)
+	tests := []struct {
+		field string
+		want  time.Time
+	}{
+		{"year", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"quarter", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"month", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{"week", time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC)},
+		{"day", time.Date(2024, 5, 16, 0, 0, 0, 0, time.UTC)},
+		{"hour", time.Date(2024, 5, 16, 13, 0, 0, 0, time.UTC)},
+		{"second", time.Date(2024, 5, 16, 13, 4, 5, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		v, err := callFunc(t, "date_trunc", params(types.Text, types.Timestamptz), test.field, ts)
+		require.NoError(t, err, test.field)
+		assert.Equal(t, test.want, v, test.field)
+	}
+}
@@ -0,0 +1,777 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package parser
+
+// Node is implemented by every node of the syntax tree. Pos is the byte
+// offset of the node in the statement it was parsed from.
+type Node interface {
+	Pos() int
+}
+
+// Statement is a parsed SQL statement
+type Statement interface {
+	Node
+	statementNode()
+}
+
+// Expr is a value expression
+type Expr interface {
+	Node
+	exprNode()
+	// String formats the expression as SQL, parentheses are added around
+	// every operation so that the precedence is explicit.
+	String() string
+}
+
+// TableExpr is an item of a FROM clause
+type TableExpr interface {
+	Node
+	tableExprNode()
+}
+
+// position is embedded by the nodes to implement Node
+type position struct {
+	pos int
+}
+
+func (p position) Pos() int { return p.pos }
+
+// ObjectName is an optionally schema qualified name of a table or schema
+type ObjectName struct {
+	position
+	Schema string
+	Name   string
+}
+
+// String returns the name as SQL, quoting the parts when needed
+func (n *ObjectName) String() string {
+	if n.Schema != "" {
+		return QuoteIdent(n.Schema) + "." + QuoteIdent(n.Name)
+	}
+	return QuoteIdent(n.Name)
+}
+
+// TypeName is a data type. Name is the canonical name of built in types,
+// for example int4 for INTEGER or timestamptz for TIMESTAMP WITH TIME ZONE.
+type TypeName struct {
+	position
+	Name string
+	// Modifiers are the numbers in parentheses, such as the length of a
+	// VARCHAR or the precision and scale of a NUMERIC
+	Modifiers []int
+	// Array is set for arrays of the type
+	Array bool
+}
+
+// Statements
+
+// SelectStmt is a SELECT, a VALUES list or a set operation of selects.
+// Set operations have Op set and their operands in Left and Right, VALUES
+// lists have Values set, the clauses of a plain select are set otherwise.
+// With, OrderBy, Limit and Offset apply to any of them.
+type SelectStmt struct {
+	position
+	With *WithClause
+
+	Distinct   bool
+	DistinctOn []Expr
+	Targets    []*ResultTarget
+	From       []TableExpr
+	Where      Expr
+	GroupBy    []Expr
+	Having     Expr
+
+	Values [][]Expr
+
+	Op    SetOp
+	All   bool
+	Left  *SelectStmt
+	Right *SelectStmt
+
+	OrderBy []*OrderItem
+	Limit   Expr
+	Offset  Expr
+}
+
+// SetOp is the set operation combining two selects
+type SetOp int
+
+const (
+	SetOpNone SetOp = iota
+	SetOpUnion
+	SetOpIntersect
+	SetOpExcept
+)
+
+func (op SetOp) String() string {
+	return [...]string{"", "UNION", "INTERSECT", "EXCEPT"}[op]
+}
+
+// WithClause holds the common table expressions of a statement
+type WithClause struct {
+	position
+	Recursive bool
+	CTEs      []*CTE
+}
+
+// CTE is a named query of a WITH clause
+type CTE struct {
+	position
+	Name    string
+	Columns []string
+	Query   *SelectStmt
+}
+
+// ResultTarget is an item of the select list or of a RETURNING clause
+type ResultTarget struct {
+	position
+	Expr Expr
+	// Alias is the name given with AS, empty when there is none
+	Alias string
+}
+
+// OrderItem is an item of an ORDER BY clause
+type OrderItem struct {
+	position
+	Expr Expr
+	Desc bool
+	// NullsFirst is nil unless NULLS FIRST or NULLS LAST is given
+	NullsFirst *bool
+}
+
+// InsertStmt is an INSERT statement. The rows come from Query, which is a
+// VALUES list or a select, unless DefaultValues is set.
+type InsertStmt struct {
+	position
+	Table         *ObjectName
+	Alias         string
+	Columns       []string
+	Query         *SelectStmt
+	DefaultValues bool
+	OnConflict    *OnConflict
+	Returning     []*ResultTarget
+}
+
+// OnConflict is the ON CONFLICT clause of an INSERT. Without Update the
+// conflicting rows are skipped.
+type OnConflict struct {
+	position
+	Columns []string
+	Update  []*SetClause
+	Where   Expr
+}
+
+// UpdateStmt is an UPDATE statement
+type UpdateStmt struct {
+	position
+	Table     *ObjectName
+	Alias     string
+	Set       []*SetClause
+	From      []TableExpr
+	Where     Expr
+	Returning []*ResultTarget
+}
+
+// SetClause assigns a value to a column in UPDATE
+type SetClause struct {
+	position
+	Column string
+	Value  Expr
+}
+
+// DeleteStmt is a DELETE statement
+type DeleteStmt struct {
+	position
+	Table     *ObjectName
+	Alias     string
+	Using     []TableExpr
+	Where     Expr
+	Returning []*ResultTarget
+}
+
+// CreateTableStmt is a CREATE TABLE statement
+type CreateTableStmt struct {
+	position
+	Table       *ObjectName
+	IfNotExists bool
+	Columns     []*ColumnDef
+	// Constraints are the table constraints, column constraints are part
+	// of the column definitions
+	Constraints []*Constraint
+}
+
+// ColumnDef is the definition of a column in CREATE or ALTER TABLE
+type ColumnDef struct {
+	position
+	Name        string
+	Type        *TypeName
+	Constraints []*Constraint
+}
+
+// ConstraintType is the kind of a column or table constraint
+type ConstraintType int
+
+const (
+	ConstraintNotNull ConstraintType = iota
+	ConstraintNull
+	ConstraintDefault
+	ConstraintPrimaryKey
+	ConstraintUnique
+	ConstraintCheck
+	ConstraintForeignKey
+)
+
+func (t ConstraintType) String() string {
+	return [...]string{"NOT NULL", "NULL", "DEFAULT", "PRIMARY KEY", "UNIQUE", "CHECK", "FOREIGN KEY"}[t]
+}
+
+// Constraint is a column or table constraint. Columns is empty for
+// column constraints, which apply to the column they are defined on.
+type Constraint struct {
+	position
+	Name    string
+	Type    ConstraintType
+	Columns []string
+	// Expr is the default value or the check expression
+	Expr Expr
+	// RefTable and RefColumns are the referenced table and columns of a
+	// foreign key
+	RefTable   *ObjectName
+	RefColumns []string
+}
+
+// CreateSchemaStmt is a CREATE SCHEMA statement
+type CreateSchemaStmt struct {
+	position
+	Name        string
+	IfNotExists bool
+}
+
+// ObjectType is the type of object a DROP statement removes
+type ObjectType int
+
+const (
+	ObjectTable ObjectType = iota
+	ObjectSchema
+)
+
+func (t ObjectType) String() string {
+	return [...]string{"TABLE", "SCHEMA"}[t]
+}
+
+// DropStmt is a DROP TABLE or DROP SCHEMA statement
+type DropStmt struct {
+	position
+	Type     ObjectType
+	Names    []*ObjectName
+	IfExists bool
+	Cascade  bool
+}
+
+// AlterTableStmt is an ALTER TABLE statement
+type AlterTableStmt struct {
+	position
+	Table    *ObjectName
+	IfExists bool
+	Commands []*AlterTableCmd
+}
+
+// AlterTableAction is the kind of change made by an ALTER TABLE command
+type AlterTableAction int
+
+const (
+	AlterAddColumn AlterTableAction = iota
+	AlterDropColumn
+	AlterColumnSetDefault
+	AlterColumnDropDefault
+	AlterColumnSetNotNull
+	AlterColumnDropNotNull
+	AlterColumnType
+	AlterRenameColumn
+	AlterRenameTable
+	AlterAddConstraint
+	AlterDropConstraint
+)
+
+// AlterTableCmd is one of the comma separated changes of ALTER TABLE. The
+// fields used depend on the Action.
+type AlterTableCmd struct {
+	position
+	Action AlterTableAction
+	// Name is the column or constraint the command applies to
+	Name string
+	// NewName is the new name of a column or table being renamed
+	NewName    string
+	Column     *ColumnDef
+	Constraint *Constraint
+	Type       *TypeName
+	Default    Expr
+	// IfExists and IfNotExists apply to dropping and adding columns and
+	// constraints
+	IfExists    bool
+	IfNotExists bool
+	Cascade     bool
+}
+
+// TransactionKind is the transaction control statement
+type TransactionKind int
+
+const (
+	TransactionBegin TransactionKind = iota
+	TransactionCommit
+	TransactionRollback
+)
+
+func (k TransactionKind) String() string {
+	return [...]string{"BEGIN", "COMMIT", "ROLLBACK"}[k]
+}
+
+// TransactionStmt is BEGIN, START TRANSACTION, COMMIT, END, ROLLBACK or
+// ABORT
+type TransactionStmt struct {
+	position
+	Kind TransactionKind
+	// Modes are the transaction modes of BEGIN, such as "ISOLATION LEVEL
+	// SERIALIZABLE" or "READ ONLY"
+	Modes []string
+}
+
+// SetStmt is a SET statement. Values is empty when setting the default.
+type SetStmt struct {
+	position
+	Local  bool
+	Name   string
+	Values []Expr
+}
+
+// ResetStmt is a RESET statement, Name is "all" for RESET ALL
+type ResetStmt struct {
+	position
+	Name string
+}
+
+// ShowStmt is a SHOW statement, Name is "all" for SHOW ALL
+type ShowStmt struct {
+	position
+	Name string
+}
+
+// CopyStmt is a COPY statement. Either Table, with the Columns if any are
+// listed, or Query is set. File is empty when copying from STDIN or to
+// STDOUT.
+type CopyStmt struct {
+	position
+	Table   *ObjectName
+	Columns []string
+	Query   *SelectStmt
+	From    bool
+	File    string
+	Options []*CopyOption
+}
+
+// CopyOption is an option of COPY. Value is empty when none is given and
+// the options of the syntax used before postgres 9.0, such as CSV HEADER,
+// are given with their current names.
+type CopyOption struct {
+	Name  string
+	Value string
+}
+
+// ListenStmt is a LISTEN statement
+type ListenStmt struct {
+	position
+	Channel string
+}
+
+// UnlistenStmt is an UNLISTEN statement, Channel is empty for UNLISTEN *
+type UnlistenStmt struct {
+	position
+	Channel string
+}
+
+// NotifyStmt is a NOTIFY statement, Payload is empty when none is given
+type NotifyStmt struct {
+	position
+	Channel string
+	Payload string
+}
+
+// DeclareCursorStmt is a DECLARE statement. Without transactions every
+// cursor is held until it is closed, so Hold is only informative.
+type DeclareCursorStmt struct {
+	position
+	Name   string
+	Binary bool
+	Scroll bool
+	Hold   bool
+	Query  *SelectStmt
+}
+
+// FetchDirection is the direction of FETCH and MOVE
+type FetchDirection int
+
+const (
+	FetchForward FetchDirection = iota
+	FetchBackward
+	FetchAbsolute
+	FetchRelative
+)
+
+// FetchStmt is a FETCH or MOVE statement. NEXT, PRIOR, FIRST and LAST are
+// given as a direction with a Count of 1 or -1 like in postgres, All is
+// set for ALL, FORWARD ALL and BACKWARD ALL.
+type FetchStmt struct {
+	position
+	Move      bool
+	Direction FetchDirection
+	Count     int64
+	All       bool
+	Name      string
+}
+
+// CloseStmt is a CLOSE statement, Name is empty for CLOSE ALL
+type CloseStmt struct {
+	position
+	Name string
+}
+
+// ExplainStmt is an EXPLAIN statement
+type ExplainStmt struct {
+	position
+	Analyze bool
+	Verbose bool
+	Costs   bool
+	// Format is the lower case output format, text unless specified
+	Format    string
+	Statement Statement
+}
+
+func (*SelectStmt) statementNode()        {}
+func (*InsertStmt) statementNode()        {}
+func (*UpdateStmt) statementNode()        {}
+func (*DeleteStmt) statementNode()        {}
+func (*CreateTableStmt) statementNode()   {}
+func (*CreateSchemaStmt) statementNode()  {}
+func (*DropStmt) statementNode()          {}
+func (*AlterTableStmt) statementNode()    {}
+func (*TransactionStmt) statementNode()   {}
+func (*SetStmt) statementNode()           {}
+func (*ResetStmt) statementNode()         {}
+func (*ShowStmt) statementNode()          {}
+func (*CopyStmt) statementNode()          {}
+func (*ListenStmt) statementNode()        {}
+func (*UnlistenStmt) statementNode()      {}
+func (*NotifyStmt) statementNode()        {}
+func (*DeclareCursorStmt) statementNode() {}
+func (*FetchStmt) statementNode()         {}
+func (*CloseStmt) statementNode()         {}
+func (*ExplainStmt) statementNode()       {}
+
+// FROM items
+
+// TableRef is a table referenced by name in a FROM clause
+type TableRef struct {
+	position
+	Name  *ObjectName
+	Alias *Alias
+}
+
+// SubqueryRef is a subquery in a FROM clause
+type SubqueryRef struct {
+	position
+	Lateral bool
+	Query   *SelectStmt
+	Alias   *Alias
+}
+
+// FuncRef is a set returning function in a FROM clause, such as
+// generate_series
+type FuncRef struct {
+	position
+	Lateral bool
+	Func    *FuncCall
+	Alias   *Alias
+}
+
+// JoinType is the type of a join
+type JoinType int
+
+const (
+	JoinInner JoinType = iota
+	JoinLeft
+	JoinRight
+	JoinFull
+	JoinCross
+)
+
+func (t JoinType) String() string {
+	return [...]string{"INNER", "LEFT", "RIGHT", "FULL", "CROSS"}[t]
+}
+
+// JoinExpr is a join of two FROM items
+type JoinExpr struct {
+	position
+	Type    JoinType
+	Natural bool
+	Left    TableExpr
+	Right   TableExpr
+	On      Expr
+	Using   []string
+	Alias   *Alias
+}
+
+// Alias renames a FROM item and optionally its columns
+type Alias struct {
+	position
+	Name    string
+	Columns []string
+}
+
+func (*TableRef) tableExprNode()    {}
+func (*SubqueryRef) tableExprNode() {}
+func (*FuncRef) tableExprNode()     {}
+func (*JoinExpr) tableExprNode()    {}
+
+// Expressions
+
+// LiteralKind is the type of a literal
+type LiteralKind int
+
+const (
+	LiteralNull LiteralKind = iota
+	LiteralBool
+	LiteralInteger
+	LiteralNumeric
+	LiteralString
+)
+
+// Literal is a constant. Value is the text of the number or string,
+// "true" or "false" for booleans and empty for NULL. Integers which do not
+// fit in 64 bits are numeric like decimal numbers.
+type Literal struct {
+	position
+	Kind  LiteralKind
+	Value string
+}
+
+// ColumnRef references a column, optionally qualified by a table
+type ColumnRef struct {
+	position
+	Table string
+	Name  string
+}
+
+// Star is * in a select list or in count(*), optionally qualified by a
+// table
+type Star struct {
+	position
+	Table string
+}
+
+// Param is a positional parameter, $1 has Number 1
+type Param struct {
+	position
+	Number int
+}
+
+// UnaryExpr is a prefix operator: -, + or NOT
+type UnaryExpr struct {
+	position
+	Op   string
+	Expr Expr
+}
+
+// BinaryExpr is an infix operator, including AND and OR. Op is the
+// operator in upper case for keywords, != is normalized to <>.
+type BinaryExpr struct {
+	position
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+// IsTest is the predicate tested by IS
+type IsTest int
+
+const (
+	IsNull IsTest = iota
+	IsTrue
+	IsFalse
+	IsUnknown
+	IsDistinctFrom
+)
+
+// IsExpr is expr IS [NOT] { NULL | TRUE | FALSE | UNKNOWN | DISTINCT FROM
+// right }
+type IsExpr struct {
+	position
+	Expr  Expr
+	Not   bool
+	Test  IsTest
+	Right Expr
+}
+
+// LikeExpr is expr [NOT] { LIKE | ILIKE } pattern [ESCAPE escape]
+type LikeExpr struct {
+	position
+	Expr            Expr
+	Pattern         Expr
+	Escape          Expr
+	Not             bool
+	CaseInsensitive bool
+}
+
+// BetweenExpr is expr [NOT] BETWEEN [SYMMETRIC] low AND high
+type BetweenExpr struct {
+	position
+	Expr      Expr
+	Low       Expr
+	High      Expr
+	Not       bool
+	Symmetric bool
+}
+
+// InExpr is expr [NOT] IN (list) or expr [NOT] IN (subquery)
+type InExpr struct {
+	position
+	Expr     Expr
+	List     []Expr
+	Subquery *SelectStmt
+	Not      bool
+}
+
+// ExistsExpr is EXISTS (subquery)
+type ExistsExpr struct {
+	position
+	Subquery *SelectStmt
+}
+
+// SubqueryExpr is a scalar subquery
+type SubqueryExpr struct {
+	position
+	Subquery *SelectStmt
+}
+
+// QuantifiedExpr compares a value with the rows of a subquery or the
+// elements of an array: expr op { ANY | SOME | ALL } (subquery | array)
+type QuantifiedExpr struct {
+	position
+	Op       string
+	Expr     Expr
+	All      bool
+	Subquery *SelectStmt
+	Array    Expr
+}
+
+// CaseExpr is a CASE expression, Operand is nil for the searched form
+type CaseExpr struct {
+	position
+	Operand Expr
+	Whens   []*When
+	Else    Expr
+}
+
+// When is a WHEN cond THEN result clause of CASE
+type When struct {
+	position
+	Cond   Expr
+	Result Expr
+}
+
+// CastExpr is CAST(expr AS type), expr::type or a typed literal such as
+// DATE '2021-01-01'
+type CastExpr struct {
+	position
+	Expr Expr
+	Type *TypeName
+}
+
+// FuncCall is a call of a function or an aggregate. COALESCE, NULLIF,
+// GREATEST and LEAST are function calls as well as the special forms
+// EXTRACT, SUBSTRING, POSITION and TRIM, which are converted to the
+// equivalent function.
+type FuncCall struct {
+	position
+	// Name is the lower case name of the function, schema qualified
+	// names keep the schema, such as pg_catalog.now
+	Name string
+	Args []Expr
+	// Star is set for count(*)
+	Star        bool
+	Distinct    bool
+	OrderBy     []*OrderItem
+	Filter      Expr
+	WithinGroup []*OrderItem
+}
+
+// ArrayExpr is ARRAY[elems]
+type ArrayExpr struct {
+	position
+	Elems []Expr
+}
+
+// RowExpr is ROW(items) or a parenthesized list of expressions
+type RowExpr struct {
+	position
+	Items []Expr
+}
+
+// DefaultExpr is the DEFAULT keyword in VALUES lists and UPDATE
+type DefaultExpr struct {
+	position
+}
+
+// GroupingKind is the kind of grouping set
+type GroupingKind int
+
+const (
+	GroupingEmpty GroupingKind = iota
+	GroupingRollup
+	GroupingCube
+	GroupingSets
+)
+
+// GroupingSet is (), ROLLUP, CUBE or GROUPING SETS in a GROUP BY clause.
+// The items are expressions, RowExpr for parenthesized lists and nested
+// grouping sets.
+type GroupingSet struct {
+	position
+	Kind  GroupingKind
+	Items []Expr
+}
+
+func (*Literal) exprNode()        {}
+func (*ColumnRef) exprNode()      {}
+func (*Star) exprNode()           {}
+func (*Param) exprNode()          {}
+func (*UnaryExpr) exprNode()      {}
+func (*BinaryExpr) exprNode()     {}
+func (*IsExpr) exprNode()         {}
+func (*LikeExpr) exprNode()       {}
+func (*BetweenExpr) exprNode()    {}
+func (*InExpr) exprNode()         {}
+func (*ExistsExpr) exprNode()     {}
+func (*SubqueryExpr) exprNode()   {}
+func (*QuantifiedExpr) exprNode() {}
+func (*CaseExpr) exprNode()       {}
+func (*CastExpr) exprNode()       {}
+func (*FuncCall) exprNode()       {}
+func (*ArrayExpr) exprNode()      {}
+func (*RowExpr) exprNode()        {}
+func (*DefaultExpr) exprNode()    {}
+func (*GroupingSet) exprNode()    {}
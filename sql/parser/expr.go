@@ -0,0 +1,725 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package parser
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Operator precedence from the loosest to the tightest binding, like
+// https://www.postgresql.org/docs/14/sql-syntax-lexical.html#SQL-PRECEDENCE
+const (
+	precOr = iota + 1
+	precAnd
+	precNot
+	precIs
+	precCmp
+	precLike
+	precOther
+	precAdd
+	precMul
+	precExp
+	precUnary
+	precCast
+)
+
+// funcKeywords are reserved keywords which may still name a function
+var funcKeywords = map[string]bool{
+	"left":           true,
+	"right":          true,
+	"current_schema": true,
+	"similar":        true,
+}
+
+func (p *parser) expr() (Expr, error) {
+	return p.exprPrec(0)
+}
+
+// exprPrec reads an expression whose operators bind tighter than prec
+func (p *parser) exprPrec(prec int) (Expr, error) {
+	left, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		next := p.infixPrec()
+		if next <= prec {
+			return left, nil
+		}
+		if left, err = p.infix(left, next); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) exprList() ([]Expr, error) {
+	var exprs []Expr
+	for {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+		if !p.acceptOp(",") {
+			return exprs, nil
+		}
+	}
+}
+
+// infixPrec returns the precedence of the operator following an
+// expression, or 0 when the expression ends
+func (p *parser) infixPrec() int {
+	tok := p.peek()
+	switch tok.kind {
+	case tokIdent:
+		switch tok.text {
+		case "or":
+			return precOr
+		case "and":
+			return precAnd
+		case "is", "isnull", "notnull":
+			return precIs
+		case "like", "ilike", "in", "between":
+			return precLike
+		case "not":
+			if tokenIsKeyword(p.peekAt(1), "like", "ilike", "in", "between") {
+				return precLike
+			}
+		}
+	case tokOp:
+		switch tok.text {
+		case "=", "<", ">", "<=", ">=", "<>", "!=":
+			return precCmp
+		case "||", "~~", "~~*", "!~~", "!~~*":
+			return precOther
+		case "+", "-":
+			return precAdd
+		case "*", "/", "%":
+			return precMul
+		case "^":
+			return precExp
+		case "::":
+			return precCast
+		}
+	}
+	return 0
+}
+
+// infix reads the operator following left and its right operand
+func (p *parser) infix(left Expr, prec int) (Expr, error) {
+	tok := p.advance()
+	pos := position{tok.pos}
+	if tok.kind == tokOp {
+		switch tok.text {
+		case "::":
+			t, err := p.typeName()
+			if err != nil {
+				return nil, err
+			}
+			return &CastExpr{position: pos, Expr: left, Type: t}, nil
+		case "~~", "~~*", "!~~", "!~~*":
+			pattern, err := p.exprPrec(prec)
+			if err != nil {
+				return nil, err
+			}
+			return &LikeExpr{
+				position:        pos,
+				Expr:            left,
+				Pattern:         pattern,
+				Not:             tok.text[0] == '!',
+				CaseInsensitive: strings.HasSuffix(tok.text, "*"),
+			}, nil
+		}
+		op := tok.text
+		if op == "!=" {
+			op = "<>"
+		}
+		if prec == precCmp && p.isKeyword("any", "some", "all") && p.peekAt(1).kind == tokOp && p.peekAt(1).text == "(" {
+			return p.quantified(pos, op, left)
+		}
+		right, err := p.exprPrec(prec)
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryExpr{position: pos, Op: op, Left: left, Right: right}, nil
+	}
+
+	not := false
+	if tok.text == "not" {
+		not = true
+		tok = p.advance()
+	}
+	switch tok.text {
+	case "and", "or":
+		right, err := p.exprPrec(prec)
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryExpr{position: pos, Op: strings.ToUpper(tok.text), Left: left, Right: right}, nil
+	case "isnull":
+		return &IsExpr{position: pos, Expr: left, Test: IsNull}, nil
+	case "notnull":
+		return &IsExpr{position: pos, Expr: left, Test: IsNull, Not: true}, nil
+	case "is":
+		return p.isExpr(pos, left)
+	case "like", "ilike":
+		e := &LikeExpr{position: pos, Expr: left, Not: not, CaseInsensitive: tok.text == "ilike"}
+		var err error
+		if e.Pattern, err = p.exprPrec(prec); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("escape") {
+			if e.Escape, err = p.exprPrec(prec); err != nil {
+				return nil, err
+			}
+		}
+		return e, nil
+	case "in":
+		return p.inExpr(pos, left, not)
+	default:
+		e := &BetweenExpr{position: pos, Expr: left, Not: not}
+		if !p.acceptKeyword("symmetric") {
+			p.acceptKeyword("asymmetric")
+		} else {
+			e.Symmetric = true
+		}
+		var err error
+		if e.Low, err = p.exprPrec(prec); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("and"); err != nil {
+			return nil, err
+		}
+		if e.High, err = p.exprPrec(prec); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+}
+
+func (p *parser) isExpr(pos position, left Expr) (Expr, error) {
+	e := &IsExpr{position: pos, Expr: left, Not: p.acceptKeyword("not")}
+	switch {
+	case p.acceptKeyword("null"):
+		e.Test = IsNull
+	case p.acceptKeyword("true"):
+		e.Test = IsTrue
+	case p.acceptKeyword("false"):
+		e.Test = IsFalse
+	case p.acceptKeyword("unknown"):
+		e.Test = IsUnknown
+	case p.acceptKeywords("distinct", "from"):
+		e.Test = IsDistinctFrom
+		var err error
+		if e.Right, err = p.exprPrec(precIs); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, p.syntaxError()
+	}
+	return e, nil
+}
+
+func (p *parser) inExpr(pos position, left Expr, not bool) (Expr, error) {
+	e := &InExpr{position: pos, Expr: left, Not: not}
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	var err error
+	if p.isKeyword("select", "values", "with", "table") {
+		e.Subquery, err = p.selectStmt()
+	} else {
+		e.List, err = p.exprList()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, p.expectOp(")")
+}
+
+// quantified reads the ANY, SOME or ALL following a comparison operator
+func (p *parser) quantified(pos position, op string, left Expr) (Expr, error) {
+	e := &QuantifiedExpr{position: pos, Op: op, Expr: left, All: p.advance().text == "all"}
+	p.pos++
+	var err error
+	if p.isKeyword("select", "values", "with", "table") {
+		e.Subquery, err = p.selectStmt()
+	} else {
+		e.Array, err = p.expr()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, p.expectOp(")")
+}
+
+// primary reads an operand: a literal, a name, a function call, a prefix
+// operator or a parenthesized expression
+func (p *parser) primary() (Expr, error) {
+	tok := p.peek()
+	pos := position{tok.pos}
+	switch tok.kind {
+	case tokInteger:
+		p.pos++
+		kind := LiteralInteger
+		if _, err := strconv.ParseInt(tok.text, 10, 64); err != nil {
+			kind = LiteralNumeric
+		}
+		return &Literal{position: pos, Kind: kind, Value: tok.text}, nil
+	case tokFloat:
+		p.pos++
+		return &Literal{position: pos, Kind: LiteralNumeric, Value: tok.text}, nil
+	case tokString:
+		p.pos++
+		return &Literal{position: pos, Kind: LiteralString, Value: tok.text}, nil
+	case tokParam:
+		p.pos++
+		n, err := strconv.Atoi(tok.text)
+		if err != nil || n < 1 {
+			return nil, errorAt(p.src, tok.pos, "there is no parameter $%s", tok.text)
+		}
+		return &Param{position: pos, Number: n}, nil
+	case tokOp:
+		switch tok.text {
+		case "(":
+			return p.parenExpr()
+		case "-", "+":
+			p.pos++
+			operand, err := p.exprPrec(precUnary)
+			if err != nil {
+				return nil, err
+			}
+			if lit, ok := operand.(*Literal); ok && (lit.Kind == LiteralInteger || lit.Kind == LiteralNumeric) {
+				// signed numbers are constants rather than operations
+				if tok.text == "-" {
+					lit.Value = negate(lit.Value)
+				}
+				lit.pos = tok.pos
+				return lit, nil
+			}
+			return &UnaryExpr{position: pos, Op: tok.text, Expr: operand}, nil
+		}
+	case tokIdent:
+		if e, ok, err := p.keywordExpr(); ok || err != nil {
+			return e, err
+		}
+		if e, ok := p.typedLiteral(); ok {
+			return e, nil
+		}
+		if isReserved(tok.text) && !(funcKeywords[tok.text] && p.peekAt(1).kind == tokOp && p.peekAt(1).text == "(") {
+			return nil, p.syntaxError()
+		}
+		return p.name()
+	case tokQuotedIdent:
+		return p.name()
+	}
+	return nil, p.syntaxError()
+}
+
+// negate returns the text of the negated number
+func negate(n string) string {
+	if strings.HasPrefix(n, "-") {
+		return n[1:]
+	}
+	return "-" + n
+}
+
+// parenExpr reads a parenthesized expression, a row of expressions or a
+// scalar subquery
+func (p *parser) parenExpr() (Expr, error) {
+	pos := position{p.advance().pos}
+	if p.isKeyword("select", "values", "with", "table") {
+		query, err := p.selectStmt()
+		if err != nil {
+			return nil, err
+		}
+		return &SubqueryExpr{position: pos, Subquery: query}, p.expectOp(")")
+	}
+	items, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	if len(items) == 1 {
+		return items[0], nil
+	}
+	return &RowExpr{position: pos, Items: items}, nil
+}
+
+// keywordExpr reads the expressions introduced by a keyword, ok is false
+// when the next token does not start one
+func (p *parser) keywordExpr() (e Expr, ok bool, err error) {
+	tok := p.peek()
+	pos := position{tok.pos}
+	next := p.peekAt(1)
+	paren := next.kind == tokOp && next.text == "("
+	switch tok.text {
+	case "null":
+		p.pos++
+		return &Literal{position: pos, Kind: LiteralNull}, true, nil
+	case "true", "false":
+		p.pos++
+		return &Literal{position: pos, Kind: LiteralBool, Value: tok.text}, true, nil
+	case "default":
+		p.pos++
+		return &DefaultExpr{position: pos}, true, nil
+	case "not":
+		p.pos++
+		operand, err := p.exprPrec(precNot)
+		if err != nil {
+			return nil, true, err
+		}
+		return &UnaryExpr{position: pos, Op: "NOT", Expr: operand}, true, nil
+	case "case":
+		e, err := p.caseExpr()
+		return e, true, err
+	case "cast":
+		p.pos++
+		if err := p.expectOp("("); err != nil {
+			return nil, true, err
+		}
+		e := &CastExpr{position: pos}
+		if e.Expr, err = p.expr(); err != nil {
+			return nil, true, err
+		}
+		if err := p.expectKeyword("as"); err != nil {
+			return nil, true, err
+		}
+		if e.Type, err = p.typeName(); err != nil {
+			return nil, true, err
+		}
+		return e, true, p.expectOp(")")
+	case "exists":
+		if !paren {
+			return nil, false, nil
+		}
+		p.pos += 2
+		query, err := p.selectStmt()
+		if err != nil {
+			return nil, true, err
+		}
+		return &ExistsExpr{position: pos, Subquery: query}, true, p.expectOp(")")
+	case "array":
+		p.pos++
+		if err := p.expectOp("["); err != nil {
+			return nil, true, err
+		}
+		e := &ArrayExpr{position: pos}
+		if !p.isOp("]") {
+			if e.Elems, err = p.exprList(); err != nil {
+				return nil, true, err
+			}
+		}
+		return e, true, p.expectOp("]")
+	case "row":
+		if !paren {
+			return nil, false, nil
+		}
+		p.pos += 2
+		e := &RowExpr{position: pos}
+		if !p.isOp(")") {
+			if e.Items, err = p.exprList(); err != nil {
+				return nil, true, err
+			}
+		}
+		return e, true, p.expectOp(")")
+	case "extract", "substring", "position", "trim":
+		if !paren {
+			return nil, false, nil
+		}
+		e, err := p.specialFunc()
+		return e, true, err
+	}
+	if keywordFuncs[tok.text] || (tok.text == "current_schema" && !paren) {
+		p.pos++
+		call := &FuncCall{position: pos, Name: tok.text}
+		if paren && (tok.text == "current_time" || tok.text == "current_timestamp" ||
+			tok.text == "localtime" || tok.text == "localtimestamp") {
+			p.pos++
+			if call.Args, err = p.exprList(); err != nil {
+				return nil, true, err
+			}
+			err = p.expectOp(")")
+		}
+		return call, true, err
+	}
+	return nil, false, nil
+}
+
+func (p *parser) caseExpr() (Expr, error) {
+	e := &CaseExpr{position: position{p.advance().pos}}
+	var err error
+	if !p.isKeyword("when") {
+		if e.Operand, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	for p.isKeyword("when") {
+		w := &When{position: position{p.advance().pos}}
+		if w.Cond, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("then"); err != nil {
+			return nil, err
+		}
+		if w.Result, err = p.expr(); err != nil {
+			return nil, err
+		}
+		e.Whens = append(e.Whens, w)
+	}
+	if len(e.Whens) == 0 {
+		return nil, p.syntaxError()
+	}
+	if p.acceptKeyword("else") {
+		if e.Else, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	return e, p.expectKeyword("end")
+}
+
+// specialFunc reads the SQL standard function syntax of EXTRACT,
+// SUBSTRING, POSITION and TRIM and converts it to a plain function call
+func (p *parser) specialFunc() (Expr, error) {
+	tok := p.advance()
+	p.pos++
+	call := &FuncCall{position: position{tok.pos}, Name: tok.text}
+	var err error
+	switch tok.text {
+	case "extract":
+		// EXTRACT(field FROM source) is date_part('field', source)
+		field := p.advance()
+		if field.kind != tokIdent && field.kind != tokString {
+			p.pos--
+			return nil, p.syntaxError()
+		}
+		if err := p.expectKeyword("from"); err != nil {
+			return nil, err
+		}
+		source, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		call.Name = "date_part"
+		call.Args = []Expr{&Literal{position: position{field.pos}, Kind: LiteralString, Value: strings.ToLower(field.text)}, source}
+
+	case "substring":
+		// SUBSTRING(s FROM start FOR count) is substring(s, start, count)
+		s, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = []Expr{s}
+		if p.acceptOp(",") {
+			rest, err := p.exprList()
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, rest...)
+			break
+		}
+		var from, count Expr
+		for p.isKeyword("from", "for") {
+			kw := p.advance().text
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if kw == "from" {
+				from = e
+			} else {
+				count = e
+			}
+		}
+		if from == nil && count != nil {
+			from = &Literal{position: position{count.Pos()}, Kind: LiteralInteger, Value: "1"}
+		}
+		if from != nil {
+			call.Args = append(call.Args, from)
+		}
+		if count != nil {
+			call.Args = append(call.Args, count)
+		}
+
+	case "position":
+		// POSITION(sub IN s) is strpos(s, sub)
+		sub, err := p.exprPrec(precLike)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("in"); err != nil {
+			return nil, err
+		}
+		s, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		call.Name = "strpos"
+		call.Args = []Expr{s, sub}
+
+	case "trim":
+		// TRIM([BOTH | LEADING | TRAILING] [chars] FROM s) is btrim,
+		// ltrim or rtrim(s, chars)
+		call.Name = "btrim"
+		switch {
+		case p.acceptKeyword("leading"):
+			call.Name = "ltrim"
+		case p.acceptKeyword("trailing"):
+			call.Name = "rtrim"
+		default:
+			p.acceptKeyword("both")
+		}
+		var chars Expr
+		if !p.isKeyword("from") {
+			if chars, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+		if p.acceptKeyword("from") {
+			s, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			call.Args = []Expr{s}
+			if chars != nil {
+				call.Args = append(call.Args, chars)
+			}
+		} else {
+			call.Args = []Expr{chars}
+			if p.acceptOp(",") {
+				if chars, err = p.expr(); err != nil {
+					return nil, err
+				}
+				call.Args = append(call.Args, chars)
+			}
+		}
+	}
+	return call, p.expectOp(")")
+}
+
+// typedLiteral reads a string constant preceded by its type, such as
+// DATE '2021-01-01', ok is false when the next tokens are not one
+func (p *parser) typedLiteral() (Expr, bool) {
+	start := p.pos
+	t, err := p.typeName()
+	if err != nil || p.peek().kind != tokString || t.Array {
+		p.pos = start
+		return nil, false
+	}
+	lit := p.advance()
+	return &CastExpr{
+		position: position{t.pos},
+		Expr:     &Literal{position: position{lit.pos}, Kind: LiteralString, Value: lit.text},
+		Type:     t,
+	}, true
+}
+
+// name reads a column reference, a qualified star or a function call
+func (p *parser) name() (Expr, error) {
+	tok := p.advance()
+	pos := position{tok.pos}
+	parts := []string{tok.text}
+	for p.acceptOp(".") {
+		if p.acceptOp("*") {
+			if len(parts) > 1 {
+				return nil, errorAt(p.src, tok.pos, "improper qualified name (too many dotted names): %s.*", strings.Join(parts, "."))
+			}
+			return &Star{position: pos, Table: parts[0]}, nil
+		}
+		part, err := p.colLabel()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	if p.isOp("(") {
+		return p.funcCall(pos, strings.Join(parts, "."))
+	}
+	switch len(parts) {
+	case 1:
+		return &ColumnRef{position: pos, Name: parts[0]}, nil
+	case 2:
+		return &ColumnRef{position: pos, Table: parts[0], Name: parts[1]}, nil
+	}
+	return nil, errorAt(p.src, tok.pos, "cross-database references are not implemented: %s", strings.Join(parts, "."))
+}
+
+func (p *parser) funcCall(pos position, name string) (Expr, error) {
+	p.pos++
+	call := &FuncCall{position: pos, Name: name}
+	var err error
+	switch {
+	case p.acceptOp(")"):
+	case p.isOp("*") && p.peekAt(1).kind == tokOp && p.peekAt(1).text == ")":
+		p.pos += 2
+		call.Star = true
+	default:
+		if p.acceptKeyword("distinct") {
+			call.Distinct = true
+		} else {
+			p.acceptKeyword("all")
+		}
+		if call.Args, err = p.exprList(); err != nil {
+			return nil, err
+		}
+		if p.acceptKeywords("order", "by") {
+			if call.OrderBy, err = p.orderByList(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.isKeyword("within") && tokenIsKeyword(p.peekAt(1), "group") {
+		p.pos += 2
+		if err := p.expectOp("("); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("order", "by"); err != nil {
+			return nil, err
+		}
+		if call.WithinGroup, err = p.orderByList(); err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+	}
+	if p.isKeyword("filter") && p.peekAt(1).kind == tokOp && p.peekAt(1).text == "(" {
+		p.pos += 2
+		if err := p.expectKeyword("where"); err != nil {
+			return nil, err
+		}
+		if call.Filter, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+	}
+	if p.isKeyword("over") {
+		// window functions are not supported
+		return nil, p.syntaxError()
+	}
+	return call, nil
+}
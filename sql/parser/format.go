@@ -0,0 +1,447 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package parser
+
+import (
+	"strconv"
+	"strings"
+)
+
+// QuoteIdent returns the identifier as SQL, it is double quoted unless it
+// is a lower case name which is not a reserved keyword
+func QuoteIdent(name string) string {
+	plain := name != "" && !isReserved(name) && !isDigit(name[0]) && name[0] != '$'
+	for i := 0; i < len(name) && plain; i++ {
+		c := name[i]
+		plain = c == '_' || ('a' <= c && c <= 'z') || isDigit(c) || c == '$'
+	}
+	if plain {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// QuoteString returns the string as a SQL string literal
+func QuoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// String returns the type as SQL
+func (t *TypeName) String() string {
+	s := t.Name
+	if len(t.Modifiers) > 0 {
+		mods := make([]string, len(t.Modifiers))
+		for i, m := range t.Modifiers {
+			mods[i] = strconv.Itoa(m)
+		}
+		s += "(" + strings.Join(mods, ",") + ")"
+	}
+	if t.Array {
+		s += "[]"
+	}
+	return s
+}
+
+func (e *Literal) String() string {
+	switch e.Kind {
+	case LiteralNull:
+		return "NULL"
+	case LiteralString:
+		return QuoteString(e.Value)
+	}
+	return e.Value
+}
+
+func (e *ColumnRef) String() string {
+	if e.Table != "" {
+		return QuoteIdent(e.Table) + "." + QuoteIdent(e.Name)
+	}
+	return QuoteIdent(e.Name)
+}
+
+func (e *Star) String() string {
+	if e.Table != "" {
+		return QuoteIdent(e.Table) + ".*"
+	}
+	return "*"
+}
+
+func (e *Param) String() string {
+	return "$" + strconv.Itoa(e.Number)
+}
+
+func (e *UnaryExpr) String() string {
+	if e.Op == "NOT" {
+		return "(NOT " + e.Expr.String() + ")"
+	}
+	return "(" + e.Op + " " + e.Expr.String() + ")"
+}
+
+func (e *BinaryExpr) String() string {
+	return "(" + e.Left.String() + " " + e.Op + " " + e.Right.String() + ")"
+}
+
+func (e *IsExpr) String() string {
+	s := "(" + e.Expr.String() + " IS "
+	if e.Not {
+		s += "NOT "
+	}
+	switch e.Test {
+	case IsNull:
+		s += "NULL"
+	case IsTrue:
+		s += "TRUE"
+	case IsFalse:
+		s += "FALSE"
+	case IsUnknown:
+		s += "UNKNOWN"
+	case IsDistinctFrom:
+		s += "DISTINCT FROM " + e.Right.String()
+	}
+	return s + ")"
+}
+
+func (e *LikeExpr) String() string {
+	op := "LIKE"
+	if e.CaseInsensitive {
+		op = "ILIKE"
+	}
+	if e.Not {
+		op = "NOT " + op
+	}
+	s := "(" + e.Expr.String() + " " + op + " " + e.Pattern.String()
+	if e.Escape != nil {
+		s += " ESCAPE " + e.Escape.String()
+	}
+	return s + ")"
+}
+
+func (e *BetweenExpr) String() string {
+	op := "BETWEEN"
+	if e.Not {
+		op = "NOT " + op
+	}
+	if e.Symmetric {
+		op += " SYMMETRIC"
+	}
+	return "(" + e.Expr.String() + " " + op + " " + e.Low.String() + " AND " + e.High.String() + ")"
+}
+
+func (e *InExpr) String() string {
+	op := " IN "
+	if e.Not {
+		op = " NOT IN "
+	}
+	if e.Subquery != nil {
+		return "(" + e.Expr.String() + op + "(" + e.Subquery.String() + "))"
+	}
+	return "(" + e.Expr.String() + op + "(" + formatExprs(e.List) + "))"
+}
+
+func (e *ExistsExpr) String() string {
+	return "EXISTS (" + e.Subquery.String() + ")"
+}
+
+func (e *SubqueryExpr) String() string {
+	return "(" + e.Subquery.String() + ")"
+}
+
+func (e *QuantifiedExpr) String() string {
+	q := "ANY"
+	if e.All {
+		q = "ALL"
+	}
+	operand := ""
+	if e.Subquery != nil {
+		operand = e.Subquery.String()
+	} else {
+		operand = e.Array.String()
+	}
+	return "(" + e.Expr.String() + " " + e.Op + " " + q + " (" + operand + "))"
+}
+
+func (e *CaseExpr) String() string {
+	var b strings.Builder
+	b.WriteString("CASE")
+	if e.Operand != nil {
+		b.WriteString(" " + e.Operand.String())
+	}
+	for _, w := range e.Whens {
+		b.WriteString(" WHEN " + w.Cond.String() + " THEN " + w.Result.String())
+	}
+	if e.Else != nil {
+		b.WriteString(" ELSE " + e.Else.String())
+	}
+	b.WriteString(" END")
+	return b.String()
+}
+
+func (e *CastExpr) String() string {
+	return "CAST(" + e.Expr.String() + " AS " + e.Type.String() + ")"
+}
+
+// keywordFuncs are the functions called without parentheses
+var keywordFuncs = map[string]bool{
+	"current_catalog":   true,
+	"current_date":      true,
+	"current_role":      true,
+	"current_time":      true,
+	"current_timestamp": true,
+	"current_user":      true,
+	"localtime":         true,
+	"localtimestamp":    true,
+	"session_user":      true,
+	"user":              true,
+}
+
+func (e *FuncCall) String() string {
+	if keywordFuncs[e.Name] && len(e.Args) == 0 {
+		return e.Name
+	}
+	var b strings.Builder
+	for i, part := range strings.Split(e.Name, ".") {
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(QuoteIdent(part))
+	}
+	b.WriteByte('(')
+	if e.Star {
+		b.WriteByte('*')
+	}
+	if e.Distinct {
+		b.WriteString("DISTINCT ")
+	}
+	b.WriteString(formatExprs(e.Args))
+	if len(e.OrderBy) > 0 {
+		b.WriteString(" ORDER BY " + formatOrderBy(e.OrderBy))
+	}
+	b.WriteByte(')')
+	if len(e.WithinGroup) > 0 {
+		b.WriteString(" WITHIN GROUP (ORDER BY " + formatOrderBy(e.WithinGroup) + ")")
+	}
+	if e.Filter != nil {
+		b.WriteString(" FILTER (WHERE " + e.Filter.String() + ")")
+	}
+	return b.String()
+}
+
+func (e *ArrayExpr) String() string {
+	return "ARRAY[" + formatExprs(e.Elems) + "]"
+}
+
+func (e *RowExpr) String() string {
+	return "ROW(" + formatExprs(e.Items) + ")"
+}
+
+func (e *DefaultExpr) String() string {
+	return "DEFAULT"
+}
+
+func (e *GroupingSet) String() string {
+	switch e.Kind {
+	case GroupingRollup:
+		return "ROLLUP(" + formatExprs(e.Items) + ")"
+	case GroupingCube:
+		return "CUBE(" + formatExprs(e.Items) + ")"
+	case GroupingSets:
+		return "GROUPING SETS(" + formatExprs(e.Items) + ")"
+	}
+	return "()"
+}
+
+func formatExprs(exprs []Expr) string {
+	s := make([]string, len(exprs))
+	for i, e := range exprs {
+		s[i] = e.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+func formatIdents(names []string) string {
+	s := make([]string, len(names))
+	for i, n := range names {
+		s[i] = QuoteIdent(n)
+	}
+	return strings.Join(s, ", ")
+}
+
+func formatOrderBy(items []*OrderItem) string {
+	s := make([]string, len(items))
+	for i, item := range items {
+		s[i] = item.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// String returns the item as SQL
+func (o *OrderItem) String() string {
+	s := o.Expr.String()
+	if o.Desc {
+		s += " DESC"
+	}
+	if o.NullsFirst != nil {
+		if *o.NullsFirst {
+			s += " NULLS FIRST"
+		} else {
+			s += " NULLS LAST"
+		}
+	}
+	return s
+}
+
+// String returns the query as SQL
+func (s *SelectStmt) String() string {
+	var b strings.Builder
+	if s.With != nil {
+		b.WriteString("WITH ")
+		if s.With.Recursive {
+			b.WriteString("RECURSIVE ")
+		}
+		for i, cte := range s.With.CTEs {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(QuoteIdent(cte.Name))
+			if len(cte.Columns) > 0 {
+				b.WriteString("(" + formatIdents(cte.Columns) + ")")
+			}
+			b.WriteString(" AS (" + cte.Query.String() + ")")
+		}
+		b.WriteByte(' ')
+	}
+
+	switch {
+	case s.Op != SetOpNone:
+		b.WriteString("(" + s.Left.String() + ") " + s.Op.String())
+		if s.All {
+			b.WriteString(" ALL")
+		}
+		b.WriteString(" (" + s.Right.String() + ")")
+	case s.Values != nil:
+		b.WriteString("VALUES ")
+		for i, row := range s.Values {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString("(" + formatExprs(row) + ")")
+		}
+	default:
+		s.formatSelect(&b)
+	}
+
+	if len(s.OrderBy) > 0 {
+		b.WriteString(" ORDER BY " + formatOrderBy(s.OrderBy))
+	}
+	if s.Limit != nil {
+		b.WriteString(" LIMIT " + s.Limit.String())
+	}
+	if s.Offset != nil {
+		b.WriteString(" OFFSET " + s.Offset.String())
+	}
+	return b.String()
+}
+
+func (s *SelectStmt) formatSelect(b *strings.Builder) {
+	b.WriteString("SELECT")
+	if s.Distinct {
+		b.WriteString(" DISTINCT")
+		if len(s.DistinctOn) > 0 {
+			b.WriteString(" ON (" + formatExprs(s.DistinctOn) + ")")
+		}
+	}
+	for i, t := range s.Targets {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(" " + t.String())
+	}
+	if len(s.From) > 0 {
+		b.WriteString(" FROM ")
+		for i, from := range s.From {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(FormatTableExpr(from))
+		}
+	}
+	if s.Where != nil {
+		b.WriteString(" WHERE " + s.Where.String())
+	}
+	if len(s.GroupBy) > 0 {
+		b.WriteString(" GROUP BY " + formatExprs(s.GroupBy))
+	}
+	if s.Having != nil {
+		b.WriteString(" HAVING " + s.Having.String())
+	}
+}
+
+// String returns the target as SQL
+func (t *ResultTarget) String() string {
+	if t.Alias != "" {
+		return t.Expr.String() + " AS " + QuoteIdent(t.Alias)
+	}
+	return t.Expr.String()
+}
+
+// String returns the alias as SQL
+func (a *Alias) String() string {
+	s := QuoteIdent(a.Name)
+	if len(a.Columns) > 0 {
+		s += "(" + formatIdents(a.Columns) + ")"
+	}
+	return s
+}
+
+// FormatTableExpr returns the FROM item as SQL
+func FormatTableExpr(t TableExpr) string {
+	var s string
+	var alias *Alias
+	switch t := t.(type) {
+	case *TableRef:
+		s, alias = t.Name.String(), t.Alias
+	case *SubqueryRef:
+		s, alias = "("+t.Query.String()+")", t.Alias
+		if t.Lateral {
+			s = "LATERAL " + s
+		}
+	case *FuncRef:
+		s, alias = t.Func.String(), t.Alias
+		if t.Lateral {
+			s = "LATERAL " + s
+		}
+	case *JoinExpr:
+		op := t.Type.String() + " JOIN"
+		if t.Natural {
+			op = "NATURAL " + op
+		}
+		s = "(" + FormatTableExpr(t.Left) + " " + op + " " + FormatTableExpr(t.Right)
+		switch {
+		case t.On != nil:
+			s += " ON " + t.On.String()
+		case t.Using != nil:
+			s += " USING (" + formatIdents(t.Using) + ")"
+		}
+		s += ")"
+		alias = t.Alias
+	}
+	if alias != nil {
+		s += " AS " + alias.String()
+	}
+	return s
+}
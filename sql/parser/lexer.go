@@ -0,0 +1,359 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// tokenKind classifies the tokens of a statement
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	// tokIdent is an unquoted identifier or keyword, folded to lower case
+	tokIdent
+	// tokQuotedIdent is a double quoted identifier, case is preserved
+	tokQuotedIdent
+	tokString
+	tokInteger
+	tokFloat
+	// tokParam is a positional parameter such as $1
+	tokParam
+	tokOp
+)
+
+// token is a lexical token and its byte offset in the statement
+type token struct {
+	kind tokenKind
+	// text is the identifier, the unescaped string, the number or the
+	// operator
+	text string
+	// pos and end are the offsets of the first byte and past the last
+	pos int
+	end int
+}
+
+// Error is a syntax error. Position is the 1-based character position of
+// the offending token in the statement, like the position postgres reports.
+type Error struct {
+	Message  string
+	Position int
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at character %d", e.Message, e.Position)
+}
+
+// errorAt returns an error positioned at the byte offset pos of src
+func errorAt(src string, pos int, format string, args ...interface{}) *Error {
+	if pos > len(src) {
+		pos = len(src)
+	}
+	return &Error{
+		Message:  fmt.Sprintf(format, args...),
+		Position: utf8.RuneCountInString(src[:pos]) + 1,
+	}
+}
+
+// operators are the operators recognized by the lexer, longest first
+var operators = []string{
+	"::", "<=", ">=", "<>", "!=", "||", "~~*", "!~~*", "!~~", "~~",
+	"+", "-", "*", "/", "%", "^", "=", "<", ">", "(", ")", "[", "]", ",", ";", ".", ":",
+}
+
+// lexer splits a statement into tokens
+type lexer struct {
+	src string
+	pos int
+}
+
+// tokenize returns the tokens of src followed by tokEOF
+func tokenize(src string) ([]token, error) {
+	l := &lexer{src: src}
+	var tokens []token
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tok.end = l.pos
+		tokens = append(tokens, tok)
+		if tok.kind == tokEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	if err := l.skipSpace(); err != nil {
+		return token{}, err
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case c == '\'':
+		s, err := l.quoted('\'', false)
+		return token{kind: tokString, text: s, pos: start}, err
+	case (c == 'E' || c == 'e') && l.peek(1) == '\'':
+		l.pos++
+		s, err := l.quoted('\'', true)
+		return token{kind: tokString, text: s, pos: start}, err
+	case c == '"':
+		s, err := l.quoted('"', false)
+		if err == nil && s == "" {
+			err = errorAt(l.src, start, "zero-length delimited identifier at or near \"\"\"\"")
+		}
+		return token{kind: tokQuotedIdent, text: s, pos: start}, err
+	case c == '$':
+		return l.dollar()
+	case isDigit(c) || (c == '.' && isDigit(l.peek(1))):
+		return l.number()
+	case isIdentStart(c):
+		for l.pos < len(l.src) && isIdentByte(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: strings.ToLower(l.src[start:l.pos]), pos: start}, nil
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: tokOp, text: op, pos: start}, nil
+		}
+	}
+	_, size := utf8.DecodeRuneInString(l.src[l.pos:])
+	return token{}, errorAt(l.src, start, "syntax error at or near \"%s\"", l.src[start:start+size])
+}
+
+func (l *lexer) peek(n int) byte {
+	if l.pos+n < len(l.src) {
+		return l.src[l.pos+n]
+	}
+	return 0
+}
+
+// skipSpace skips white space and comments, block comments nest
+func (l *lexer) skipSpace() error {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			l.pos++
+		case c == '-' && l.peek(1) == '-':
+			end := strings.IndexByte(l.src[l.pos:], '\n')
+			if end < 0 {
+				l.pos = len(l.src)
+			} else {
+				l.pos += end + 1
+			}
+		case c == '/' && l.peek(1) == '*':
+			start := l.pos
+			l.pos += 2
+			for depth := 1; depth > 0; {
+				if l.pos+1 >= len(l.src) {
+					return errorAt(l.src, start, "unterminated /* comment at or near \"%s\"", l.src[start:])
+				}
+				switch l.src[l.pos : l.pos+2] {
+				case "/*":
+					depth++
+					l.pos += 2
+				case "*/":
+					depth--
+					l.pos += 2
+				default:
+					l.pos++
+				}
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// quoted reads a string or identifier enclosed in quote, a doubled quote
+// stands for the quote itself. Escape strings also accept backslash
+// escapes.
+func (l *lexer) quoted(quote byte, escapes bool) (string, error) {
+	start := l.pos
+	if escapes {
+		start--
+	}
+	l.pos++
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == quote && l.peek(1) == quote:
+			b.WriteByte(quote)
+			l.pos += 2
+		case c == quote:
+			l.pos++
+			return b.String(), nil
+		case escapes && c == '\\' && l.pos+1 < len(l.src):
+			l.pos++
+			if err := l.escape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+	kind := "quoted string"
+	if quote == '"' {
+		kind = "quoted identifier"
+	}
+	return "", errorAt(l.src, start, "unterminated %s at or near \"%s\"", kind, l.src[start:])
+}
+
+// escape decodes the backslash escape following the backslash at l.pos-1
+func (l *lexer) escape(b *strings.Builder) error {
+	c := l.src[l.pos]
+	l.pos++
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 'f':
+		b.WriteByte('\f')
+	case 'n':
+		b.WriteByte('\n')
+	case 'r':
+		b.WriteByte('\r')
+	case 't':
+		b.WriteByte('\t')
+	case 'x', 'u', 'U':
+		digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+		end := l.pos
+		for end < len(l.src) && end-l.pos < digits && isHexDigit(l.src[end]) {
+			end++
+		}
+		if end == l.pos {
+			b.WriteByte(c)
+			return nil
+		}
+		n, _ := strconv.ParseUint(l.src[l.pos:end], 16, 32)
+		start := l.pos - 2
+		l.pos = end
+		if c == 'x' {
+			b.WriteByte(byte(n))
+			return nil
+		}
+		if !utf8.ValidRune(rune(n)) {
+			return errorAt(l.src, start, "invalid Unicode escape value")
+		}
+		b.WriteRune(rune(n))
+	default:
+		if '0' <= c && c <= '7' {
+			n := int(c - '0')
+			for i := 0; i < 2 && l.pos < len(l.src) && '0' <= l.src[l.pos] && l.src[l.pos] <= '7'; i++ {
+				n = n*8 + int(l.src[l.pos]-'0')
+				l.pos++
+			}
+			b.WriteByte(byte(n))
+			return nil
+		}
+		b.WriteByte(c)
+	}
+	return nil
+}
+
+// dollar reads a positional parameter or a dollar quoted string
+func (l *lexer) dollar() (token, error) {
+	start := l.pos
+	if isDigit(l.peek(1)) {
+		l.pos++
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokParam, text: l.src[start+1 : l.pos], pos: start}, nil
+	}
+	end := start + 1
+	for end < len(l.src) && l.src[end] != '$' && isIdentByte(l.src[end]) {
+		end++
+	}
+	if end >= len(l.src) || l.src[end] != '$' || (end > start+1 && isDigit(l.src[start+1])) {
+		return token{}, errorAt(l.src, start, "syntax error at or near \"$\"")
+	}
+	tag := l.src[start : end+1]
+	body := end + 1
+	closing := strings.Index(l.src[body:], tag)
+	if closing < 0 {
+		return token{}, errorAt(l.src, start, "unterminated dollar-quoted string at or near \"%s\"", l.src[start:])
+	}
+	l.pos = body + closing + len(tag)
+	return token{kind: tokString, text: l.src[body : body+closing], pos: start}, nil
+}
+
+// number reads an integer or a decimal number with an optional exponent
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	kind := tokInteger
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.pos++
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '.' && l.peek(1) != '.' {
+		kind = tokFloat
+		l.pos++
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+	}
+	if c := l.peek(0); c == 'e' || c == 'E' {
+		exp := l.pos + 1
+		if exp < len(l.src) && (l.src[exp] == '+' || l.src[exp] == '-') {
+			exp++
+		}
+		if exp < len(l.src) && isDigit(l.src[exp]) {
+			kind = tokFloat
+			l.pos = exp
+			for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+				l.pos++
+			}
+		}
+	}
+	if l.pos < len(l.src) && isIdentStart(l.src[l.pos]) {
+		end := l.pos
+		for end < len(l.src) && isIdentByte(l.src[end]) {
+			end++
+		}
+		return token{}, errorAt(l.src, start, "trailing junk after numeric literal at or near \"%s\"", l.src[start:end])
+	}
+	return token{kind: kind, text: l.src[start:l.pos], pos: start}, nil
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c >= 0x80
+}
+
+func isIdentByte(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '$'
+}
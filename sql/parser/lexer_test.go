@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens, err := tokenize(`SELECT "Name", e'a\tb''c', $1, 1.5e3, .5, 42, $x$it's$x$ /* a /* nested */ comment */ FROM t -- end`)
+	require.NoError(t, err)
+
+	type tok struct {
+		kind tokenKind
+		text string
+	}
+	var got []tok
+	for _, t := range tokens {
+		got = append(got, tok{t.kind, t.text})
+	}
+	assert.Equal(t, []tok{
+		{tokIdent, "select"},
+		{tokQuotedIdent, "Name"},
+		{tokOp, ","},
+		{tokString, "a\tb'c"},
+		{tokOp, ","},
+		{tokParam, "1"},
+		{tokOp, ","},
+		{tokFloat, "1.5e3"},
+		{tokOp, ","},
+		{tokFloat, ".5"},
+		{tokOp, ","},
+		{tokInteger, "42"},
+		{tokOp, ","},
+		{tokString, "it's"},
+		{tokIdent, "from"},
+		{tokIdent, "t"},
+		{tokEOF, ""},
+	}, got)
+}
+
+func TestTokenizeOperators(t *testing.T) {
+	tokens, err := tokenize("a::int<>b||c!=d<=e")
+	require.NoError(t, err)
+	var ops []string
+	for _, t := range tokens {
+		if t.kind == tokOp {
+			ops = append(ops, t.text)
+		}
+	}
+	assert.Equal(t, []string{"::", "<>", "||", "!=", "<="}, ops)
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	tests := []struct {
+		sql      string
+		message  string
+		position int
+	}{
+		{"SELECT 'abc", `unterminated quoted string at or near "'abc"`, 8},
+		{`SELECT "abc`, `unterminated quoted identifier at or near ""abc"`, 8},
+		{"SELECT ''; /* open", `unterminated /* comment at or near "/* open"`, 12},
+		{"SELECT $tag$ abc", `unterminated dollar-quoted string at or near "$tag$ abc"`, 8},
+		{"SELECT 12abc", `trailing junk after numeric literal at or near "12abc"`, 8},
+		{"SELECT 'é', #", `syntax error at or near "#"`, 13},
+		{`SELECT ""`, `zero-length delimited identifier at or near """"`, 8},
+	}
+	for _, tt := range tests {
+		_, err := tokenize(tt.sql)
+		require.Error(t, err, tt.sql)
+		assert.Equal(t, &Error{Message: tt.message, Position: tt.position}, err, tt.sql)
+	}
+}
@@ -0,0 +1,1997 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package parser parses the PostgreSQL dialect of SQL into a syntax tree.
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parse parses the statements separated by semicolons in sql. Empty
+// statements are skipped.
+func Parse(sql string) ([]Statement, error) {
+	p, err := newParser(sql)
+	if err != nil {
+		return nil, err
+	}
+	var statements []Statement
+	for {
+		for p.acceptOp(";") {
+		}
+		if p.peek().kind == tokEOF {
+			return statements, nil
+		}
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, stmt)
+		if p.peek().kind != tokEOF && !p.isOp(";") {
+			return nil, p.syntaxError()
+		}
+	}
+}
+
+// ParseOne parses a single statement, it returns nil when sql holds no
+// statement and an error when it holds more than one.
+func ParseOne(sql string) (Statement, error) {
+	statements, err := Parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	switch len(statements) {
+	case 0:
+		return nil, nil
+	case 1:
+		return statements[0], nil
+	}
+	return nil, errorAt(sql, statements[1].Pos(), "cannot insert multiple commands into a prepared statement")
+}
+
+// ParseExpr parses a single value expression
+func ParseExpr(sql string) (Expr, error) {
+	p, err := newParser(sql)
+	if err != nil {
+		return nil, err
+	}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, p.syntaxError()
+	}
+	return e, nil
+}
+
+type parser struct {
+	src    string
+	tokens []token
+	pos    int
+}
+
+func newParser(sql string) (*parser, error) {
+	tokens, err := tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{src: sql, tokens: tokens}, nil
+}
+
+// reserved are the keywords which cannot be used as names without quotes
+// nor as aliases without AS
+var reserved = map[string]bool{}
+
+func init() {
+	for _, kw := range strings.Fields(`
+		all analyse analyze and any array as asc asymmetric authorization
+		between binary both case cast check collate collation column
+		concurrently constraint create cross current_catalog current_date
+		current_role current_schema current_time current_timestamp
+		current_user default deferrable desc distinct do else end except
+		false fetch for foreign freeze from full grant group having
+		ilike in initially inner intersect into is isnull join lateral
+		leading left like limit localtime localtimestamp natural not
+		notnull null offset on only or order outer overlaps placing
+		primary references returning right select session_user similar
+		some symmetric table tablesample then to trailing true union unique
+		user using variadic verbose when where window with`) {
+		reserved[kw] = true
+	}
+}
+
+func isReserved(word string) bool {
+	return reserved[word]
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+// peekAt returns the token n positions ahead
+func (p *parser) peekAt(n int) token {
+	if p.pos+n < len(p.tokens) {
+		return p.tokens[p.pos+n]
+	}
+	return p.tokens[len(p.tokens)-1]
+}
+
+func (p *parser) advance() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// isKeyword reports whether the next token is one of the keywords
+func (p *parser) isKeyword(keywords ...string) bool {
+	return tokenIsKeyword(p.peek(), keywords...)
+}
+
+func tokenIsKeyword(tok token, keywords ...string) bool {
+	if tok.kind != tokIdent {
+		return false
+	}
+	for _, kw := range keywords {
+		if tok.text == kw {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) acceptKeyword(kw string) bool {
+	if p.isKeyword(kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// acceptKeywords consumes the sequence of keywords when the next tokens
+// match all of them
+func (p *parser) acceptKeywords(keywords ...string) bool {
+	for i, kw := range keywords {
+		if !tokenIsKeyword(p.peekAt(i), kw) {
+			return false
+		}
+	}
+	p.pos += len(keywords)
+	return true
+}
+
+func (p *parser) expectKeyword(keywords ...string) error {
+	for _, kw := range keywords {
+		if !p.acceptKeyword(kw) {
+			return p.syntaxError()
+		}
+	}
+	return nil
+}
+
+func (p *parser) isOp(op string) bool {
+	tok := p.peek()
+	return tok.kind == tokOp && tok.text == op
+}
+
+func (p *parser) acceptOp(op string) bool {
+	if p.isOp(op) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectOp(op string) error {
+	if !p.acceptOp(op) {
+		return p.syntaxError()
+	}
+	return nil
+}
+
+// syntaxError reports a syntax error at the next token
+func (p *parser) syntaxError() *Error {
+	tok := p.peek()
+	if tok.kind == tokEOF {
+		return errorAt(p.src, len(p.src), "syntax error at end of input")
+	}
+	return errorAt(p.src, tok.pos, "syntax error at or near \"%s\"", p.src[tok.pos:tok.end])
+}
+
+// ident reads a name which is not a reserved keyword
+func (p *parser) ident() (string, error) {
+	tok := p.peek()
+	switch {
+	case tok.kind == tokQuotedIdent, tok.kind == tokIdent && !isReserved(tok.text):
+		p.pos++
+		return tok.text, nil
+	}
+	return "", p.syntaxError()
+}
+
+// colLabel reads a name where reserved keywords are allowed too, such as
+// after AS
+func (p *parser) colLabel() (string, error) {
+	tok := p.peek()
+	if tok.kind == tokQuotedIdent || tok.kind == tokIdent {
+		p.pos++
+		return tok.text, nil
+	}
+	return "", p.syntaxError()
+}
+
+// isIdent reports whether the next token is a name which may be read by
+// ident
+func (p *parser) isIdent() bool {
+	tok := p.peek()
+	return tok.kind == tokQuotedIdent || (tok.kind == tokIdent && !isReserved(tok.text))
+}
+
+// identList reads a parenthesized list of names
+func (p *parser) identList() ([]string, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	var names []string
+	for {
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	return names, p.expectOp(")")
+}
+
+// objectName reads an optionally schema qualified name
+func (p *parser) objectName() (*ObjectName, error) {
+	pos := p.peek().pos
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	n := &ObjectName{position: position{pos}, Name: name}
+	if p.acceptOp(".") {
+		n.Schema = n.Name
+		if n.Name, err = p.colLabel(); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+func (p *parser) statement() (Statement, error) {
+	tok := p.peek()
+	if tok.kind == tokOp && tok.text == "(" {
+		return p.selectStmt()
+	}
+	if tok.kind != tokIdent {
+		return nil, p.syntaxError()
+	}
+	switch tok.text {
+	case "select", "values", "with", "table":
+		return p.selectStmt()
+	case "insert":
+		return p.insertStmt()
+	case "update":
+		return p.updateStmt()
+	case "delete":
+		return p.deleteStmt()
+	case "create":
+		return p.createStmt()
+	case "drop":
+		return p.dropStmt()
+	case "alter":
+		return p.alterTableStmt()
+	case "begin", "start", "commit", "end", "rollback", "abort":
+		return p.transactionStmt()
+	case "set":
+		return p.setStmt()
+	case "reset":
+		return p.resetStmt()
+	case "show":
+		return p.showStmt()
+	case "copy":
+		return p.copyStmt()
+	case "listen":
+		return p.listenStmt()
+	case "unlisten":
+		return p.unlistenStmt()
+	case "notify":
+		return p.notifyStmt()
+	case "declare":
+		return p.declareCursorStmt()
+	case "fetch", "move":
+		return p.fetchStmt()
+	case "close":
+		return p.closeStmt()
+	case "explain":
+		return p.explainStmt()
+	}
+	return nil, p.syntaxError()
+}
+
+// isSelectStart reports whether the tokens starting n tokens ahead begin a
+// query, skipping opening parentheses
+func (p *parser) isSelectStart(n int) bool {
+	for {
+		tok := p.peekAt(n)
+		if tok.kind == tokOp && tok.text == "(" {
+			n++
+			continue
+		}
+		return tokenIsKeyword(tok, "select", "values", "with", "table")
+	}
+}
+
+// SELECT
+
+func (p *parser) selectStmt() (*SelectStmt, error) {
+	pos := p.peek().pos
+	var with *WithClause
+	if p.isKeyword("with") {
+		var err error
+		if with, err = p.withClause(); err != nil {
+			return nil, err
+		}
+	}
+	stmt, err := p.selectSetOps()
+	if err != nil {
+		return nil, err
+	}
+	if with != nil {
+		if stmt.With != nil {
+			return nil, errorAt(p.src, with.pos, "multiple WITH clauses not allowed")
+		}
+		stmt.With = with
+		stmt.pos = pos
+	}
+
+	if p.isKeyword("order") {
+		orderPos := p.peek().pos
+		p.pos++
+		if err := p.expectKeyword("by"); err != nil {
+			return nil, err
+		}
+		if stmt.OrderBy != nil {
+			return nil, errorAt(p.src, orderPos, "multiple ORDER BY clauses not allowed")
+		}
+		if stmt.OrderBy, err = p.orderByList(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		tok := p.peek()
+		switch {
+		case p.acceptKeyword("limit"):
+			if stmt.Limit != nil {
+				return nil, errorAt(p.src, tok.pos, "multiple LIMIT clauses not allowed")
+			}
+			if p.acceptKeyword("all") {
+				stmt.Limit = &Literal{position: position{tok.pos}, Kind: LiteralNull}
+				continue
+			}
+			if stmt.Limit, err = p.expr(); err != nil {
+				return nil, err
+			}
+		case p.acceptKeyword("offset"):
+			if stmt.Offset != nil {
+				return nil, errorAt(p.src, tok.pos, "multiple OFFSET clauses not allowed")
+			}
+			if stmt.Offset, err = p.expr(); err != nil {
+				return nil, err
+			}
+			if !p.acceptKeyword("rows") {
+				p.acceptKeyword("row")
+			}
+		case p.acceptKeyword("fetch"):
+			// FETCH { FIRST | NEXT } [ count ] { ROW | ROWS } ONLY
+			if stmt.Limit != nil {
+				return nil, errorAt(p.src, tok.pos, "multiple LIMIT clauses not allowed")
+			}
+			if !p.acceptKeyword("first") && !p.acceptKeyword("next") {
+				return nil, p.syntaxError()
+			}
+			if p.isKeyword("row", "rows") {
+				stmt.Limit = &Literal{position: position{p.peek().pos}, Kind: LiteralInteger, Value: "1"}
+			} else if stmt.Limit, err = p.exprPrec(precAdd - 1); err != nil {
+				return nil, err
+			}
+			if !p.acceptKeyword("rows") && !p.acceptKeyword("row") {
+				return nil, p.syntaxError()
+			}
+			if err := p.expectKeyword("only"); err != nil {
+				return nil, err
+			}
+		default:
+			return stmt, nil
+		}
+	}
+}
+
+func (p *parser) withClause() (*WithClause, error) {
+	with := &WithClause{position: position{p.advance().pos}}
+	with.Recursive = p.acceptKeyword("recursive")
+	for {
+		cte := &CTE{position: position{p.peek().pos}}
+		var err error
+		if cte.Name, err = p.ident(); err != nil {
+			return nil, err
+		}
+		if p.isOp("(") {
+			if cte.Columns, err = p.identList(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expectKeyword("as"); err != nil {
+			return nil, err
+		}
+		if !p.acceptKeywords("not", "materialized") {
+			p.acceptKeyword("materialized")
+		}
+		if err := p.expectOp("("); err != nil {
+			return nil, err
+		}
+		if cte.Query, err = p.selectStmt(); err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		with.CTEs = append(with.CTEs, cte)
+		if !p.acceptOp(",") {
+			return with, nil
+		}
+	}
+}
+
+// selectSetOps reads selects combined with UNION and EXCEPT, INTERSECT
+// binds tighter
+func (p *parser) selectSetOps() (*SelectStmt, error) {
+	left, err := p.selectIntersect()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("union", "except") {
+		tok := p.advance()
+		op := SetOpUnion
+		if tok.text == "except" {
+			op = SetOpExcept
+		}
+		all := p.acceptKeyword("all")
+		if !all {
+			p.acceptKeyword("distinct")
+		}
+		right, err := p.selectIntersect()
+		if err != nil {
+			return nil, err
+		}
+		left = &SelectStmt{position: position{left.pos}, Op: op, All: all, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) selectIntersect() (*SelectStmt, error) {
+	left, err := p.selectPrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("intersect") {
+		all := p.acceptKeyword("all")
+		if !all {
+			p.acceptKeyword("distinct")
+		}
+		right, err := p.selectPrimary()
+		if err != nil {
+			return nil, err
+		}
+		left = &SelectStmt{position: position{left.pos}, Op: SetOpIntersect, All: all, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) selectPrimary() (*SelectStmt, error) {
+	tok := p.peek()
+	switch {
+	case tok.kind == tokOp && tok.text == "(":
+		p.pos++
+		stmt, err := p.selectStmt()
+		if err != nil {
+			return nil, err
+		}
+		return stmt, p.expectOp(")")
+	case tokenIsKeyword(tok, "select"):
+		return p.simpleSelect()
+	case tokenIsKeyword(tok, "values"):
+		return p.valuesList()
+	case tokenIsKeyword(tok, "table"):
+		p.pos++
+		name, err := p.objectName()
+		if err != nil {
+			return nil, err
+		}
+		return &SelectStmt{
+			position: position{tok.pos},
+			Targets:  []*ResultTarget{{position: position{tok.pos}, Expr: &Star{position: position{tok.pos}}}},
+			From:     []TableExpr{&TableRef{position: name.position, Name: name}},
+		}, nil
+	}
+	return nil, p.syntaxError()
+}
+
+func (p *parser) valuesList() (*SelectStmt, error) {
+	stmt := &SelectStmt{position: position{p.advance().pos}}
+	for {
+		if err := p.expectOp("("); err != nil {
+			return nil, err
+		}
+		row, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		if len(stmt.Values) > 0 && len(row) != len(stmt.Values[0]) {
+			return nil, errorAt(p.src, row[0].Pos(), "VALUES lists must all be the same length")
+		}
+		stmt.Values = append(stmt.Values, row)
+		if !p.acceptOp(",") {
+			return stmt, nil
+		}
+	}
+}
+
+func (p *parser) simpleSelect() (*SelectStmt, error) {
+	stmt := &SelectStmt{position: position{p.advance().pos}}
+	var err error
+	if p.acceptKeyword("distinct") {
+		stmt.Distinct = true
+		if p.acceptKeyword("on") {
+			if err := p.expectOp("("); err != nil {
+				return nil, err
+			}
+			if stmt.DistinctOn, err = p.exprList(); err != nil {
+				return nil, err
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		p.acceptKeyword("all")
+	}
+
+	if !p.isSelectListEnd() {
+		if stmt.Targets, err = p.targetList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("from") {
+		if stmt.From, err = p.fromList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("where") {
+		if stmt.Where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeywords("group", "by") {
+		p.acceptKeyword("all")
+		for {
+			item, err := p.groupingElement()
+			if err != nil {
+				return nil, err
+			}
+			stmt.GroupBy = append(stmt.GroupBy, item)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	if p.acceptKeyword("having") {
+		if stmt.Having, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+// isSelectListEnd reports whether the select list is empty, as in
+// SELECT FROM t
+func (p *parser) isSelectListEnd() bool {
+	tok := p.peek()
+	switch {
+	case tok.kind == tokEOF:
+		return true
+	case tok.kind == tokOp:
+		return tok.text == ";" || tok.text == ")"
+	}
+	return tokenIsKeyword(tok, "from", "where", "group", "having", "order", "limit",
+		"offset", "fetch", "union", "intersect", "except", "into", "window", "for")
+}
+
+func (p *parser) targetList() ([]*ResultTarget, error) {
+	var targets []*ResultTarget
+	for {
+		t, err := p.target()
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+		if !p.acceptOp(",") {
+			return targets, nil
+		}
+	}
+}
+
+func (p *parser) target() (*ResultTarget, error) {
+	t := &ResultTarget{position: position{p.peek().pos}}
+	if p.isOp("*") {
+		t.Expr = &Star{position: position{p.advance().pos}}
+		return t, nil
+	}
+	var err error
+	if t.Expr, err = p.expr(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("as") {
+		t.Alias, err = p.colLabel()
+	} else if p.isIdent() {
+		t.Alias, err = p.ident()
+	}
+	return t, err
+}
+
+// groupingElement reads an item of GROUP BY
+func (p *parser) groupingElement() (Expr, error) {
+	tok := p.peek()
+	set := &GroupingSet{position: position{tok.pos}}
+	switch {
+	case tok.kind == tokOp && tok.text == "(" && p.peekAt(1).kind == tokOp && p.peekAt(1).text == ")":
+		p.pos += 2
+		set.Kind = GroupingEmpty
+		return set, nil
+	case tokenIsKeyword(tok, "rollup", "cube") && p.peekAt(1).kind == tokOp && p.peekAt(1).text == "(":
+		set.Kind = GroupingRollup
+		if tok.text == "cube" {
+			set.Kind = GroupingCube
+		}
+		p.pos += 2
+		var err error
+		if set.Items, err = p.exprList(); err != nil {
+			return nil, err
+		}
+		return set, p.expectOp(")")
+	case tokenIsKeyword(tok, "grouping") && tokenIsKeyword(p.peekAt(1), "sets"):
+		p.pos += 2
+		set.Kind = GroupingSets
+		if err := p.expectOp("("); err != nil {
+			return nil, err
+		}
+		for {
+			item, err := p.groupingElement()
+			if err != nil {
+				return nil, err
+			}
+			set.Items = append(set.Items, item)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		return set, p.expectOp(")")
+	}
+	return p.expr()
+}
+
+func (p *parser) orderByList() ([]*OrderItem, error) {
+	var items []*OrderItem
+	for {
+		item := &OrderItem{position: position{p.peek().pos}}
+		var err error
+		if item.Expr, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("desc") {
+			item.Desc = true
+		} else {
+			p.acceptKeyword("asc")
+		}
+		if p.acceptKeyword("nulls") {
+			first := p.isKeyword("first")
+			if !p.acceptKeyword("first") && !p.acceptKeyword("last") {
+				return nil, p.syntaxError()
+			}
+			item.NullsFirst = &first
+		}
+		items = append(items, item)
+		if !p.acceptOp(",") {
+			return items, nil
+		}
+	}
+}
+
+// FROM
+
+func (p *parser) fromList() ([]TableExpr, error) {
+	var items []TableExpr
+	for {
+		item, err := p.tableRef()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if !p.acceptOp(",") {
+			return items, nil
+		}
+	}
+}
+
+// tableRef reads a FROM item followed by any number of joins
+func (p *parser) tableRef() (TableExpr, error) {
+	left, err := p.tablePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		join := &JoinExpr{position: position{tok.pos}, Left: left}
+		switch {
+		case p.acceptKeywords("cross", "join"):
+			join.Type = JoinCross
+			if join.Right, err = p.tablePrimary(); err != nil {
+				return nil, err
+			}
+			left = join
+			continue
+		case p.isKeyword("natural", "join", "inner", "left", "right", "full"):
+		default:
+			return left, nil
+		}
+		join.Natural = p.acceptKeyword("natural")
+		switch {
+		case p.acceptKeyword("inner"):
+		case p.acceptKeyword("left"):
+			join.Type = JoinLeft
+			p.acceptKeyword("outer")
+		case p.acceptKeyword("right"):
+			join.Type = JoinRight
+			p.acceptKeyword("outer")
+		case p.acceptKeyword("full"):
+			join.Type = JoinFull
+			p.acceptKeyword("outer")
+		}
+		if err := p.expectKeyword("join"); err != nil {
+			return nil, err
+		}
+		if join.Right, err = p.tablePrimary(); err != nil {
+			return nil, err
+		}
+		if !join.Natural {
+			switch {
+			case p.acceptKeyword("on"):
+				if join.On, err = p.expr(); err != nil {
+					return nil, err
+				}
+			case p.acceptKeyword("using"):
+				if join.Using, err = p.identList(); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, p.syntaxError()
+			}
+		}
+		left = join
+	}
+}
+
+func (p *parser) tablePrimary() (TableExpr, error) {
+	tok := p.peek()
+	lateral := p.acceptKeyword("lateral")
+	switch {
+	case p.isOp("(") && p.isSelectStart(1):
+		p.pos++
+		query, err := p.selectStmt()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		ref := &SubqueryRef{position: position{tok.pos}, Lateral: lateral, Query: query}
+		if ref.Alias, err = p.alias(); err != nil {
+			return nil, err
+		}
+		if ref.Alias == nil {
+			return nil, errorAt(p.src, tok.pos, "subquery in FROM must have an alias")
+		}
+		return ref, nil
+	case p.isOp("(") && !lateral:
+		p.pos++
+		ref, err := p.tableRef()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		if join, ok := ref.(*JoinExpr); ok {
+			join.Alias, err = p.alias()
+		}
+		return ref, err
+	}
+
+	if p.isIdent() && (p.peekAt(1).kind == tokOp && p.peekAt(1).text == "(" ||
+		p.peekAt(1).kind == tokOp && p.peekAt(1).text == "." && p.peekAt(3).kind == tokOp && p.peekAt(3).text == "(") {
+		e, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		call, ok := e.(*FuncCall)
+		if !ok {
+			return nil, errorAt(p.src, tok.pos, "syntax error at or near \"%s\"", p.src[tok.pos:p.peek().pos])
+		}
+		ref := &FuncRef{position: position{tok.pos}, Lateral: lateral, Func: call}
+		ref.Alias, err = p.alias()
+		return ref, err
+	}
+	if lateral {
+		return nil, p.syntaxError()
+	}
+	p.acceptKeyword("only")
+	name, err := p.objectName()
+	if err != nil {
+		return nil, err
+	}
+	ref := &TableRef{position: position{tok.pos}, Name: name}
+	ref.Alias, err = p.alias()
+	return ref, err
+}
+
+// alias reads an optional [AS] name [(columns)]
+func (p *parser) alias() (*Alias, error) {
+	tok := p.peek()
+	var name string
+	var err error
+	switch {
+	case p.acceptKeyword("as"):
+		name, err = p.colLabel()
+	case p.isIdent():
+		name, err = p.ident()
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	alias := &Alias{position: position{tok.pos}, Name: name}
+	if p.isOp("(") {
+		if alias.Columns, err = p.identList(); err != nil {
+			return nil, err
+		}
+	}
+	return alias, nil
+}
+
+// INSERT, UPDATE and DELETE
+
+func (p *parser) insertStmt() (*InsertStmt, error) {
+	stmt := &InsertStmt{position: position{p.advance().pos}}
+	if err := p.expectKeyword("into"); err != nil {
+		return nil, err
+	}
+	var err error
+	if stmt.Table, err = p.objectName(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("as") {
+		if stmt.Alias, err = p.colLabel(); err != nil {
+			return nil, err
+		}
+	}
+	if p.isOp("(") && !p.isSelectStart(1) {
+		if stmt.Columns, err = p.identList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeywords("default", "values") {
+		stmt.DefaultValues = true
+	} else if stmt.Query, err = p.selectStmt(); err != nil {
+		return nil, err
+	}
+
+	if p.isKeyword("on") {
+		if stmt.OnConflict, err = p.onConflict(); err != nil {
+			return nil, err
+		}
+	}
+	stmt.Returning, err = p.returning()
+	return stmt, err
+}
+
+func (p *parser) onConflict() (*OnConflict, error) {
+	c := &OnConflict{position: position{p.advance().pos}}
+	if err := p.expectKeyword("conflict"); err != nil {
+		return nil, err
+	}
+	var err error
+	if p.isOp("(") {
+		if c.Columns, err = p.identList(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("do"); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("nothing") {
+		return c, nil
+	}
+	if err := p.expectKeyword("update", "set"); err != nil {
+		return nil, err
+	}
+	if c.Columns == nil {
+		return nil, errorAt(p.src, c.pos, "ON CONFLICT DO UPDATE requires inference specification or constraint name")
+	}
+	if c.Update, err = p.setClauses(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("where") {
+		c.Where, err = p.expr()
+	}
+	return c, err
+}
+
+func (p *parser) returning() ([]*ResultTarget, error) {
+	if !p.acceptKeyword("returning") {
+		return nil, nil
+	}
+	return p.targetList()
+}
+
+func (p *parser) updateStmt() (*UpdateStmt, error) {
+	stmt := &UpdateStmt{position: position{p.advance().pos}}
+	p.acceptKeyword("only")
+	var err error
+	if stmt.Table, err = p.objectName(); err != nil {
+		return nil, err
+	}
+	if stmt.Alias, err = p.dmlAlias("set"); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("set"); err != nil {
+		return nil, err
+	}
+	if stmt.Set, err = p.setClauses(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("from") {
+		if stmt.From, err = p.fromList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("where") {
+		if stmt.Where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	stmt.Returning, err = p.returning()
+	return stmt, err
+}
+
+// dmlAlias reads the optional alias of the target table of UPDATE or
+// DELETE, next is the keyword which may follow the table name
+func (p *parser) dmlAlias(next string) (string, error) {
+	if p.acceptKeyword("as") {
+		return p.colLabel()
+	}
+	if p.isIdent() && !p.isKeyword(next) {
+		return p.ident()
+	}
+	return "", nil
+}
+
+func (p *parser) setClauses() ([]*SetClause, error) {
+	var clauses []*SetClause
+	for {
+		c := &SetClause{position: position{p.peek().pos}}
+		var err error
+		if c.Column, err = p.ident(); err != nil {
+			return nil, err
+		}
+		if err := p.expectOp("="); err != nil {
+			return nil, err
+		}
+		if c.Value, err = p.expr(); err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, c)
+		if !p.acceptOp(",") {
+			return clauses, nil
+		}
+	}
+}
+
+func (p *parser) deleteStmt() (*DeleteStmt, error) {
+	stmt := &DeleteStmt{position: position{p.advance().pos}}
+	if err := p.expectKeyword("from"); err != nil {
+		return nil, err
+	}
+	p.acceptKeyword("only")
+	var err error
+	if stmt.Table, err = p.objectName(); err != nil {
+		return nil, err
+	}
+	if stmt.Alias, err = p.dmlAlias("using"); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("using") {
+		if stmt.Using, err = p.fromList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("where") {
+		if stmt.Where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	stmt.Returning, err = p.returning()
+	return stmt, err
+}
+
+// CREATE, DROP and ALTER
+
+func (p *parser) createStmt() (Statement, error) {
+	pos := p.advance().pos
+	if p.acceptKeyword("schema") {
+		stmt := &CreateSchemaStmt{position: position{pos}}
+		stmt.IfNotExists = p.acceptKeywords("if", "not", "exists")
+		var err error
+		stmt.Name, err = p.ident()
+		return stmt, err
+	}
+	if err := p.expectKeyword("table"); err != nil {
+		return nil, err
+	}
+	stmt := &CreateTableStmt{position: position{pos}}
+	stmt.IfNotExists = p.acceptKeywords("if", "not", "exists")
+	var err error
+	if stmt.Table, err = p.objectName(); err != nil {
+		return nil, err
+	}
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	if p.acceptOp(")") {
+		return stmt, nil
+	}
+	for {
+		if p.isTableConstraint() {
+			c, err := p.tableConstraint()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Constraints = append(stmt.Constraints, c)
+		} else {
+			col, err := p.columnDef()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Columns = append(stmt.Columns, col)
+		}
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	return stmt, p.expectOp(")")
+}
+
+func (p *parser) isTableConstraint() bool {
+	return p.isKeyword("constraint", "primary", "unique", "check", "foreign")
+}
+
+func (p *parser) columnDef() (*ColumnDef, error) {
+	col := &ColumnDef{position: position{p.peek().pos}}
+	var err error
+	if col.Name, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if col.Type, err = p.typeName(); err != nil {
+		return nil, err
+	}
+	for {
+		c, err := p.columnConstraint()
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			return col, nil
+		}
+		col.Constraints = append(col.Constraints, c)
+	}
+}
+
+// columnConstraint reads a constraint following a column definition, it
+// returns nil when there is none
+func (p *parser) columnConstraint() (*Constraint, error) {
+	c := &Constraint{position: position{p.peek().pos}}
+	var err error
+	named := p.acceptKeyword("constraint")
+	if named {
+		if c.Name, err = p.ident(); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case p.acceptKeywords("not", "null"):
+		c.Type = ConstraintNotNull
+	case p.acceptKeyword("null"):
+		c.Type = ConstraintNull
+	case p.acceptKeyword("default"):
+		c.Type = ConstraintDefault
+		if c.Expr, err = p.exprPrec(precIs); err != nil {
+			return nil, err
+		}
+	case p.acceptKeywords("primary", "key"):
+		c.Type = ConstraintPrimaryKey
+	case p.acceptKeyword("unique"):
+		c.Type = ConstraintUnique
+	case p.isKeyword("check"):
+		c.Type = ConstraintCheck
+		if c.Expr, err = p.checkExpr(); err != nil {
+			return nil, err
+		}
+	case p.acceptKeyword("references"):
+		c.Type = ConstraintForeignKey
+		if err := p.references(c); err != nil {
+			return nil, err
+		}
+	default:
+		if named {
+			return nil, p.syntaxError()
+		}
+		return nil, nil
+	}
+	return c, nil
+}
+
+func (p *parser) tableConstraint() (*Constraint, error) {
+	c := &Constraint{position: position{p.peek().pos}}
+	var err error
+	if p.acceptKeyword("constraint") {
+		if c.Name, err = p.ident(); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case p.acceptKeywords("primary", "key"):
+		c.Type = ConstraintPrimaryKey
+		c.Columns, err = p.identList()
+	case p.acceptKeyword("unique"):
+		c.Type = ConstraintUnique
+		c.Columns, err = p.identList()
+	case p.isKeyword("check"):
+		c.Type = ConstraintCheck
+		c.Expr, err = p.checkExpr()
+	case p.acceptKeywords("foreign", "key"):
+		c.Type = ConstraintForeignKey
+		if c.Columns, err = p.identList(); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("references"); err != nil {
+			return nil, err
+		}
+		err = p.references(c)
+	default:
+		return nil, p.syntaxError()
+	}
+	return c, err
+}
+
+func (p *parser) checkExpr() (Expr, error) {
+	p.pos++
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return e, p.expectOp(")")
+}
+
+// references reads the table and columns referenced by a foreign key and
+// skips its referential actions
+func (p *parser) references(c *Constraint) error {
+	var err error
+	if c.RefTable, err = p.objectName(); err != nil {
+		return err
+	}
+	if p.isOp("(") {
+		if c.RefColumns, err = p.identList(); err != nil {
+			return err
+		}
+	}
+	for p.acceptKeyword("on") {
+		if !p.acceptKeyword("delete") && !p.acceptKeyword("update") {
+			return p.syntaxError()
+		}
+		switch {
+		case p.acceptKeywords("no", "action"), p.acceptKeyword("restrict"), p.acceptKeyword("cascade"),
+			p.acceptKeywords("set", "null"), p.acceptKeywords("set", "default"):
+		default:
+			return p.syntaxError()
+		}
+	}
+	return nil
+}
+
+func (p *parser) dropStmt() (*DropStmt, error) {
+	stmt := &DropStmt{position: position{p.advance().pos}}
+	switch {
+	case p.acceptKeyword("table"):
+		stmt.Type = ObjectTable
+	case p.acceptKeyword("schema"):
+		stmt.Type = ObjectSchema
+	default:
+		return nil, p.syntaxError()
+	}
+	stmt.IfExists = p.acceptKeywords("if", "exists")
+	for {
+		var name *ObjectName
+		var err error
+		if stmt.Type == ObjectSchema {
+			name = &ObjectName{position: position{p.peek().pos}}
+			name.Name, err = p.ident()
+		} else {
+			name, err = p.objectName()
+		}
+		if err != nil {
+			return nil, err
+		}
+		stmt.Names = append(stmt.Names, name)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	stmt.Cascade = p.acceptKeyword("cascade")
+	if !stmt.Cascade {
+		p.acceptKeyword("restrict")
+	}
+	return stmt, nil
+}
+
+func (p *parser) alterTableStmt() (*AlterTableStmt, error) {
+	stmt := &AlterTableStmt{position: position{p.advance().pos}}
+	if err := p.expectKeyword("table"); err != nil {
+		return nil, err
+	}
+	stmt.IfExists = p.acceptKeywords("if", "exists")
+	p.acceptKeyword("only")
+	var err error
+	if stmt.Table, err = p.objectName(); err != nil {
+		return nil, err
+	}
+	for {
+		cmd, err := p.alterTableCmd()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Commands = append(stmt.Commands, cmd)
+		if !p.acceptOp(",") {
+			return stmt, nil
+		}
+	}
+}
+
+func (p *parser) alterTableCmd() (*AlterTableCmd, error) {
+	cmd := &AlterTableCmd{position: position{p.peek().pos}}
+	var err error
+	switch {
+	case p.acceptKeyword("add"):
+		if p.isTableConstraint() {
+			cmd.Action = AlterAddConstraint
+			cmd.Constraint, err = p.tableConstraint()
+			return cmd, err
+		}
+		p.acceptKeyword("column")
+		cmd.Action = AlterAddColumn
+		cmd.IfNotExists = p.acceptKeywords("if", "not", "exists")
+		cmd.Column, err = p.columnDef()
+		return cmd, err
+
+	case p.acceptKeyword("drop"):
+		cmd.Action = AlterDropColumn
+		if p.acceptKeyword("constraint") {
+			cmd.Action = AlterDropConstraint
+		} else {
+			p.acceptKeyword("column")
+		}
+		cmd.IfExists = p.acceptKeywords("if", "exists")
+		if cmd.Name, err = p.ident(); err != nil {
+			return nil, err
+		}
+		cmd.Cascade = p.acceptKeyword("cascade")
+		if !cmd.Cascade {
+			p.acceptKeyword("restrict")
+		}
+		return cmd, nil
+
+	case p.acceptKeyword("alter"):
+		p.acceptKeyword("column")
+		if cmd.Name, err = p.ident(); err != nil {
+			return nil, err
+		}
+		switch {
+		case p.acceptKeywords("set", "default"):
+			cmd.Action = AlterColumnSetDefault
+			cmd.Default, err = p.exprPrec(precIs)
+		case p.acceptKeywords("drop", "default"):
+			cmd.Action = AlterColumnDropDefault
+		case p.acceptKeywords("set", "not", "null"):
+			cmd.Action = AlterColumnSetNotNull
+		case p.acceptKeywords("drop", "not", "null"):
+			cmd.Action = AlterColumnDropNotNull
+		case p.acceptKeywords("set", "data", "type"), p.acceptKeyword("type"):
+			cmd.Action = AlterColumnType
+			cmd.Type, err = p.typeName()
+		default:
+			return nil, p.syntaxError()
+		}
+		return cmd, err
+
+	case p.acceptKeyword("rename"):
+		if p.acceptKeyword("to") {
+			cmd.Action = AlterRenameTable
+			cmd.NewName, err = p.ident()
+			return cmd, err
+		}
+		p.acceptKeyword("column")
+		cmd.Action = AlterRenameColumn
+		if cmd.Name, err = p.ident(); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("to"); err != nil {
+			return nil, err
+		}
+		cmd.NewName, err = p.ident()
+		return cmd, err
+	}
+	return nil, p.syntaxError()
+}
+
+// Utility statements
+
+func (p *parser) transactionStmt() (*TransactionStmt, error) {
+	tok := p.advance()
+	stmt := &TransactionStmt{position: position{tok.pos}}
+	switch tok.text {
+	case "begin":
+		if !p.acceptKeyword("work") {
+			p.acceptKeyword("transaction")
+		}
+		return stmt, p.transactionModes(stmt)
+	case "start":
+		if err := p.expectKeyword("transaction"); err != nil {
+			return nil, err
+		}
+		return stmt, p.transactionModes(stmt)
+	case "commit", "end":
+		stmt.Kind = TransactionCommit
+	default:
+		stmt.Kind = TransactionRollback
+	}
+	if !p.acceptKeyword("work") {
+		p.acceptKeyword("transaction")
+	}
+	if p.acceptKeyword("and") {
+		p.acceptKeyword("no")
+		if err := p.expectKeyword("chain"); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (p *parser) transactionModes(stmt *TransactionStmt) error {
+	for {
+		switch {
+		case p.acceptKeywords("isolation", "level"):
+			var level string
+			switch {
+			case p.acceptKeyword("serializable"):
+				level = "SERIALIZABLE"
+			case p.acceptKeywords("repeatable", "read"):
+				level = "REPEATABLE READ"
+			case p.acceptKeywords("read", "committed"):
+				level = "READ COMMITTED"
+			case p.acceptKeywords("read", "uncommitted"):
+				level = "READ UNCOMMITTED"
+			default:
+				return p.syntaxError()
+			}
+			stmt.Modes = append(stmt.Modes, "ISOLATION LEVEL "+level)
+		case p.acceptKeywords("read", "write"):
+			stmt.Modes = append(stmt.Modes, "READ WRITE")
+		case p.acceptKeywords("read", "only"):
+			stmt.Modes = append(stmt.Modes, "READ ONLY")
+		case p.acceptKeyword("deferrable"):
+			stmt.Modes = append(stmt.Modes, "DEFERRABLE")
+		case p.acceptKeywords("not", "deferrable"):
+			stmt.Modes = append(stmt.Modes, "NOT DEFERRABLE")
+		default:
+			return nil
+		}
+		p.acceptOp(",")
+	}
+}
+
+// settingName reads the possibly dotted name of a setting
+func (p *parser) settingName() (string, error) {
+	name, err := p.colLabel()
+	if err != nil {
+		return "", err
+	}
+	for p.acceptOp(".") {
+		part, err := p.colLabel()
+		if err != nil {
+			return "", err
+		}
+		name += "." + part
+	}
+	return name, nil
+}
+
+func (p *parser) setStmt() (*SetStmt, error) {
+	stmt := &SetStmt{position: position{p.advance().pos}}
+	if !p.acceptKeyword("session") {
+		stmt.Local = p.acceptKeyword("local")
+	}
+	var err error
+	if p.acceptKeywords("time", "zone") {
+		stmt.Name = "timezone"
+		if p.acceptKeyword("local") || p.acceptKeyword("default") {
+			return stmt, nil
+		}
+		v, err := p.settingValue()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Values = []Expr{v}
+		return stmt, nil
+	}
+	if stmt.Name, err = p.settingName(); err != nil {
+		return nil, err
+	}
+	if !p.acceptKeyword("to") && !p.acceptOp("=") {
+		return nil, p.syntaxError()
+	}
+	if p.acceptKeyword("default") {
+		return stmt, nil
+	}
+	for {
+		v, err := p.settingValue()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Values = append(stmt.Values, v)
+		if !p.acceptOp(",") {
+			return stmt, nil
+		}
+	}
+}
+
+// settingValue reads a value of SET, names and keywords such as on are
+// returned as strings
+func (p *parser) settingValue() (Expr, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokIdent, tokQuotedIdent:
+		p.pos++
+		return &Literal{position: position{tok.pos}, Kind: LiteralString, Value: tok.text}, nil
+	case tokString, tokInteger, tokFloat:
+		return p.primary()
+	case tokOp:
+		if tok.text == "-" || tok.text == "+" {
+			return p.exprPrec(precUnary - 1)
+		}
+	}
+	return nil, p.syntaxError()
+}
+
+func (p *parser) resetStmt() (*ResetStmt, error) {
+	stmt := &ResetStmt{position: position{p.advance().pos}}
+	var err error
+	if p.acceptKeywords("time", "zone") {
+		stmt.Name = "timezone"
+		return stmt, nil
+	}
+	stmt.Name, err = p.settingName()
+	return stmt, err
+}
+
+func (p *parser) showStmt() (*ShowStmt, error) {
+	stmt := &ShowStmt{position: position{p.advance().pos}}
+	var err error
+	switch {
+	case p.acceptKeywords("time", "zone"):
+		stmt.Name = "timezone"
+	case p.acceptKeywords("transaction", "isolation", "level"):
+		stmt.Name = "transaction_isolation"
+	default:
+		stmt.Name, err = p.settingName()
+	}
+	return stmt, err
+}
+
+// copyStmt parses
+//
+//	COPY table [(column [, ...])] FROM {'file' | STDIN} [[WITH] (option [, ...])]
+//	COPY {table [(column [, ...])] | (query)} TO {'file' | STDOUT} [[WITH] (option [, ...])]
+//
+// and the options used before postgres 9.0.
+func (p *parser) copyStmt() (*CopyStmt, error) {
+	stmt := &CopyStmt{position: position{p.advance().pos}}
+	var err error
+	if p.acceptOp("(") {
+		if stmt.Query, err = p.selectStmt(); err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+	} else {
+		if stmt.Table, err = p.objectName(); err != nil {
+			return nil, err
+		}
+		if p.isOp("(") {
+			if stmt.Columns, err = p.identList(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	switch {
+	case p.acceptKeyword("from"):
+		stmt.From = true
+		if !p.acceptKeyword("stdin") {
+			stmt.File, err = p.stringConst()
+		}
+	case p.acceptKeyword("to"):
+		if !p.acceptKeyword("stdout") {
+			stmt.File, err = p.stringConst()
+		}
+	default:
+		return nil, p.syntaxError()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.acceptKeyword("with")
+	if p.acceptOp("(") {
+		for {
+			name, err := p.colLabel()
+			if err != nil {
+				return nil, err
+			}
+			opt := &CopyOption{Name: name}
+			if !p.isOp(",") && !p.isOp(")") {
+				if opt.Value, err = p.copyOptionValue(); err != nil {
+					return nil, err
+				}
+			}
+			stmt.Options = append(stmt.Options, opt)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		return stmt, p.expectOp(")")
+	}
+	for {
+		var opt *CopyOption
+		switch {
+		case p.acceptKeyword("binary"):
+			opt = &CopyOption{Name: "format", Value: "binary"}
+		case p.acceptKeyword("csv"):
+			opt = &CopyOption{Name: "format", Value: "csv"}
+		case p.acceptKeyword("header"):
+			opt = &CopyOption{Name: "header"}
+		case p.isKeyword("delimiter", "null", "quote", "escape"):
+			opt = &CopyOption{Name: p.advance().text}
+			p.acceptKeyword("as")
+			var err error
+			if opt.Value, err = p.stringConst(); err != nil {
+				return nil, err
+			}
+		default:
+			return stmt, nil
+		}
+		stmt.Options = append(stmt.Options, opt)
+	}
+}
+
+// stringConst reads a string constant
+func (p *parser) stringConst() (string, error) {
+	tok := p.peek()
+	if tok.kind != tokString {
+		return "", p.syntaxError()
+	}
+	p.pos++
+	return tok.text, nil
+}
+
+// copyOptionValue reads the value of a COPY option given in parentheses,
+// names and keywords are returned in lower case
+func (p *parser) copyOptionValue() (string, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokIdent, tokString, tokInteger, tokFloat:
+		p.pos++
+		return tok.text, nil
+	}
+	return "", p.syntaxError()
+}
+
+func (p *parser) listenStmt() (*ListenStmt, error) {
+	stmt := &ListenStmt{position: position{p.advance().pos}}
+	var err error
+	stmt.Channel, err = p.ident()
+	return stmt, err
+}
+
+func (p *parser) unlistenStmt() (*UnlistenStmt, error) {
+	stmt := &UnlistenStmt{position: position{p.advance().pos}}
+	if p.acceptOp("*") {
+		return stmt, nil
+	}
+	var err error
+	stmt.Channel, err = p.ident()
+	return stmt, err
+}
+
+func (p *parser) notifyStmt() (*NotifyStmt, error) {
+	stmt := &NotifyStmt{position: position{p.advance().pos}}
+	var err error
+	if stmt.Channel, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if p.acceptOp(",") {
+		stmt.Payload, err = p.stringConst()
+	}
+	return stmt, err
+}
+
+// declareCursorStmt parses
+//
+//	DECLARE name [BINARY] [ASENSITIVE | INSENSITIVE] [[NO] SCROLL]
+//	    CURSOR [{WITH | WITHOUT} HOLD] FOR query
+func (p *parser) declareCursorStmt() (*DeclareCursorStmt, error) {
+	stmt := &DeclareCursorStmt{position: position{p.advance().pos}}
+	var err error
+	if stmt.Name, err = p.ident(); err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.acceptKeyword("binary"):
+			stmt.Binary = true
+		case p.acceptKeyword("asensitive"), p.acceptKeyword("insensitive"),
+			p.acceptKeywords("no", "scroll"):
+		case p.acceptKeyword("scroll"):
+			stmt.Scroll = true
+		default:
+			if err := p.expectKeyword("cursor"); err != nil {
+				return nil, err
+			}
+			switch {
+			case p.acceptKeywords("with", "hold"):
+				stmt.Hold = true
+			case p.acceptKeywords("without", "hold"):
+			}
+			if err := p.expectKeyword("for"); err != nil {
+				return nil, err
+			}
+			stmt.Query, err = p.selectStmt()
+			return stmt, err
+		}
+	}
+}
+
+// fetchStmt parses
+//
+//	{FETCH | MOVE} [direction] [FROM | IN] name
+func (p *parser) fetchStmt() (*FetchStmt, error) {
+	tok := p.advance()
+	stmt := &FetchStmt{position: position{tok.pos}, Move: tok.text == "move", Count: 1}
+	// the direction keywords are not reserved and may name the cursor
+	if !p.isIdent() || !p.isEnd(1) {
+		if err := p.fetchDirection(stmt); err != nil {
+			return nil, err
+		}
+		if !p.acceptKeyword("from") {
+			p.acceptKeyword("in")
+		}
+	}
+	var err error
+	stmt.Name, err = p.ident()
+	return stmt, err
+}
+
+// isEnd reports whether the statement ends n tokens ahead
+func (p *parser) isEnd(n int) bool {
+	tok := p.peekAt(n)
+	return tok.kind == tokEOF || (tok.kind == tokOp && tok.text == ";")
+}
+
+// fetchDirection reads the direction of FETCH and MOVE, if any
+func (p *parser) fetchDirection(stmt *FetchStmt) error {
+	var err error
+	switch {
+	case p.acceptKeyword("next"):
+	case p.acceptKeyword("prior"):
+		stmt.Direction, stmt.Count = FetchBackward, 1
+	case p.acceptKeyword("first"):
+		stmt.Direction, stmt.Count = FetchAbsolute, 1
+	case p.acceptKeyword("last"):
+		stmt.Direction, stmt.Count = FetchAbsolute, -1
+	case p.acceptKeyword("absolute"):
+		stmt.Direction = FetchAbsolute
+		stmt.Count, err = p.signedInt()
+	case p.acceptKeyword("relative"):
+		stmt.Direction = FetchRelative
+		stmt.Count, err = p.signedInt()
+	case p.acceptKeyword("all"):
+		stmt.All = true
+	case p.isKeyword("forward"), p.isKeyword("backward"):
+		if p.advance().text == "backward" {
+			stmt.Direction = FetchBackward
+		}
+		switch {
+		case p.acceptKeyword("all"):
+			stmt.All = true
+		case p.isOp("-"), p.isOp("+"), p.peek().kind == tokInteger:
+			stmt.Count, err = p.signedInt()
+		}
+	case p.isOp("-"), p.isOp("+"), p.peek().kind == tokInteger:
+		stmt.Count, err = p.signedInt()
+	}
+	return err
+}
+
+// signedInt reads an integer constant with an optional sign
+func (p *parser) signedInt() (int64, error) {
+	sign := ""
+	if p.isOp("-") || p.isOp("+") {
+		sign = p.advance().text
+	}
+	tok := p.peek()
+	if tok.kind != tokInteger {
+		return 0, p.syntaxError()
+	}
+	n, err := strconv.ParseInt(sign+tok.text, 10, 64)
+	if err != nil {
+		return 0, p.syntaxError()
+	}
+	p.pos++
+	return n, nil
+}
+
+func (p *parser) closeStmt() (*CloseStmt, error) {
+	stmt := &CloseStmt{position: position{p.advance().pos}}
+	if p.acceptKeyword("all") {
+		return stmt, nil
+	}
+	var err error
+	stmt.Name, err = p.ident()
+	return stmt, err
+}
+
+func (p *parser) explainStmt() (*ExplainStmt, error) {
+	stmt := &ExplainStmt{position: position{p.advance().pos}, Costs: true, Format: "text"}
+	if p.isOp("(") && !p.isSelectStart(1) {
+		p.pos++
+		for {
+			tok := p.peek()
+			name, err := p.colLabel()
+			if err != nil {
+				return nil, err
+			}
+			value := "true"
+			if !p.isOp(",") && !p.isOp(")") {
+				v := p.advance()
+				if v.kind != tokIdent && v.kind != tokString && v.kind != tokInteger {
+					p.pos--
+					return nil, p.syntaxError()
+				}
+				value = strings.ToLower(v.text)
+			}
+			if err := stmt.setOption(name, value); err != nil {
+				return nil, errorAt(p.src, tok.pos, "%s", err.Message)
+			}
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+	} else {
+		stmt.Analyze = p.acceptKeyword("analyze") || p.acceptKeyword("analyse")
+		stmt.Verbose = p.acceptKeyword("verbose")
+	}
+
+	var err error
+	switch {
+	case p.isOp("(") || p.isKeyword("select", "values", "with", "table"):
+		stmt.Statement, err = p.selectStmt()
+	case p.isKeyword("insert"):
+		stmt.Statement, err = p.insertStmt()
+	case p.isKeyword("update"):
+		stmt.Statement, err = p.updateStmt()
+	case p.isKeyword("delete"):
+		stmt.Statement, err = p.deleteStmt()
+	default:
+		return nil, p.syntaxError()
+	}
+	return stmt, err
+}
+
+// setOption applies an option given in parentheses to EXPLAIN
+func (stmt *ExplainStmt) setOption(name, value string) *Error {
+	if name == "format" {
+		switch value {
+		case "text", "json", "xml", "yaml":
+			stmt.Format = value
+			return nil
+		}
+		return &Error{Message: fmt.Sprintf("unrecognized value for EXPLAIN option \"format\": \"%s\"", value)}
+	}
+	var on bool
+	switch value {
+	case "true", "on", "1":
+		on = true
+	case "false", "off", "0":
+	default:
+		return &Error{Message: fmt.Sprintf("%s requires a Boolean value", name)}
+	}
+	switch name {
+	case "analyze", "analyse":
+		stmt.Analyze = on
+	case "verbose":
+		stmt.Verbose = on
+	case "costs":
+		stmt.Costs = on
+	case "buffers", "timing", "summary", "settings", "wal":
+		// accepted for compatibility, they change nothing
+	default:
+		return &Error{Message: fmt.Sprintf("unrecognized EXPLAIN option \"%s\"", name)}
+	}
+	return nil
+}
+
+// Types
+
+// typeAliases maps the names of built in types to their canonical name
+var typeAliases = map[string]string{
+	"int":       "int4",
+	"integer":   "int4",
+	"smallint":  "int2",
+	"bigint":    "int8",
+	"real":      "float4",
+	"decimal":   "numeric",
+	"dec":       "numeric",
+	"boolean":   "bool",
+	"character": "bpchar",
+	"char":      "bpchar",
+}
+
+func (p *parser) typeName() (*TypeName, error) {
+	tok := p.peek()
+	t := &TypeName{position: position{tok.pos}}
+	name, err := p.colLabel()
+	if err != nil {
+		return nil, err
+	}
+	if alias, ok := typeAliases[name]; ok {
+		t.Name = alias
+	} else {
+		t.Name = name
+	}
+	switch name {
+	case "double":
+		if err := p.expectKeyword("precision"); err != nil {
+			return nil, err
+		}
+		t.Name = "float8"
+	case "character", "char":
+		if p.acceptKeyword("varying") {
+			t.Name = "varchar"
+		}
+	case "float":
+		t.Name = "float8"
+		if p.isOp("(") {
+			mods, err := p.typeModifiers()
+			if err != nil {
+				return nil, err
+			}
+			if len(mods) == 1 && mods[0] <= 24 {
+				t.Name = "float4"
+			}
+		}
+	case "time", "timestamp":
+		if p.isOp("(") {
+			if t.Modifiers, err = p.typeModifiers(); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case p.acceptKeywords("with", "time", "zone"):
+			t.Name += "tz"
+		case p.acceptKeywords("without", "time", "zone"):
+		}
+	case "interval":
+		// the fields of an interval type such as DAY TO SECOND are ignored
+		for p.isKeyword("year", "month", "day", "hour", "minute", "second", "to") {
+			p.pos++
+		}
+	}
+	if t.Modifiers == nil && p.isOp("(") && t.Name != "float4" && t.Name != "float8" {
+		if t.Modifiers, err = p.typeModifiers(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		switch {
+		case p.isOp("[") && p.peekAt(1).kind == tokOp && p.peekAt(1).text == "]":
+			p.pos += 2
+		case p.isOp("[") && p.peekAt(1).kind == tokInteger && p.peekAt(2).kind == tokOp && p.peekAt(2).text == "]":
+			p.pos += 3
+		case p.acceptKeyword("array"):
+		default:
+			return t, nil
+		}
+		t.Array = true
+	}
+}
+
+func (p *parser) typeModifiers() ([]int, error) {
+	p.pos++
+	var mods []int
+	for {
+		tok := p.peek()
+		if tok.kind != tokInteger {
+			return nil, p.syntaxError()
+		}
+		n, err := strconv.Atoi(tok.text)
+		if err != nil {
+			return nil, p.syntaxError()
+		}
+		p.pos++
+		mods = append(mods, n)
+		if !p.acceptOp(",") {
+			return mods, p.expectOp(")")
+		}
+	}
+}
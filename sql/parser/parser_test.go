@@ -0,0 +1,387 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parseSelect parses a single SELECT statement
+func parseSelect(t *testing.T, sql string) *SelectStmt {
+	stmt, err := ParseOne(sql)
+	require.NoError(t, err, sql)
+	require.IsType(t, &SelectStmt{}, stmt, sql)
+	return stmt.(*SelectStmt)
+}
+
+func TestParseSelect(t *testing.T) {
+	tests := map[string]string{
+		"select a, b as c, t.* from t where a > 1 and not b is null order by a desc nulls last limit 10 offset 2":      "SELECT a, b AS c, t.* FROM t WHERE ((a > 1) AND (NOT (b IS NULL))) ORDER BY a DESC NULLS LAST LIMIT 10 OFFSET 2",
+		"SELECT DISTINCT ON (a) a, b total FROM s.t x":                                                                 "SELECT DISTINCT ON (a) a, b AS total FROM s.t AS x",
+		"select -2^2, -a, 1 + 2 * 3 - 4, 'it''s' || $1":                                                                "SELECT (-2 ^ 2), (- a), ((1 + (2 * 3)) - 4), ('it''s' || $1)",
+		"select a = b is null, not a = b and c or d":                                                                   "SELECT ((a = b) IS NULL), (((NOT (a = b)) AND c) OR d)",
+		"select x::int, cast(y as varchar(10)), date '2021-01-01', timestamp with time zone 'now', z::text[]":          "SELECT CAST(x AS int4), CAST(y AS varchar(10)), CAST('2021-01-01' AS date), CAST('now' AS timestamptz), CAST(z AS text[])",
+		"select x between 1 and 2 + 3, x not between symmetric 3 and 1, x isnull, x is not distinct from y":            "SELECT (x BETWEEN 1 AND (2 + 3)), (x NOT BETWEEN SYMMETRIC 3 AND 1), (x IS NULL), (x IS NOT DISTINCT FROM y)",
+		"select x in (1, 2), x not in (select a from t), exists (select 1), x = any (array[1, 2]), x > all (select 1)": "SELECT (x IN (1, 2)), (x NOT IN (SELECT a FROM t)), EXISTS (SELECT 1), (x = ANY (ARRAY[1, 2])), (x > ALL (SELECT 1))",
+		"select x like 'a%' escape '!', x not ilike 'b', x !~~ 'c'":                                                    "SELECT (x LIKE 'a%' ESCAPE '!'), (x NOT ILIKE 'b'), (x NOT LIKE 'c')",
+		"select case when a then 1 when b then 2 else 3 end, case a when 1 then 'x' end":                               "SELECT CASE WHEN a THEN 1 WHEN b THEN 2 ELSE 3 END, CASE a WHEN 1 THEN 'x' END",
+		"select coalesce(a, b), nullif(a, 0), current_date, now(), pg_catalog.version(), left('abc', 2)":               "SELECT coalesce(a, b), nullif(a, 0), current_date, now(), pg_catalog.version(), \"left\"('abc', 2)",
+		"select extract(year from d), substring(s from 2 for 3), position('b' in s), trim(both 'x' from s)":            "SELECT date_part('year', d), substring(s, 2, 3), strpos(s, 'b'), btrim(s, 'x')",
+		"select (select 1) + 1, ((select 2)), (1, 2), row(3)":                                                          "SELECT ((SELECT 1) + 1), (SELECT 2), ROW(1, 2), ROW(3)",
+		"select 1 union all select 2 intersect select 3 except select 4 order by 1 limit 1":                            "((SELECT 1) UNION ALL ((SELECT 2) INTERSECT (SELECT 3))) EXCEPT (SELECT 4) ORDER BY 1 LIMIT 1",
+		"(select 1 order by 1) union (select 2)":                                                                       "(SELECT 1 ORDER BY 1) UNION (SELECT 2)",
+		"values (1, 'a'), (2, 'b') order by 1":                                                                         "VALUES (1, 'a'), (2, 'b') ORDER BY 1",
+		"with recursive x(a) as (select 1) select * from x":                                                            "WITH RECURSIVE x(a) AS (SELECT 1) SELECT * FROM x",
+		"table t": "SELECT * FROM t",
+		"select from t offset 5 rows fetch first 3 rows only":                    "SELECT FROM t LIMIT 3 OFFSET 5",
+		"select 12345678901234567890, 1.5e3, .5, \"Mixed Case\" from \"select\"": "SELECT 12345678901234567890, 1.5e3, .5, \"Mixed Case\" FROM \"select\"",
+	}
+	for sql, want := range tests {
+		stmt := parseSelect(t, sql)
+		assert.Equal(t, want, stmt.String(), sql)
+
+		// the formatted statement parses to the same tree
+		assert.Equal(t, want, parseSelect(t, want).String(), sql)
+	}
+}
+
+func TestParseLiterals(t *testing.T) {
+	stmt := parseSelect(t, "select 1, -9223372036854775808, 9223372036854775808, 1.5, 'x', true, null")
+	var kinds []LiteralKind
+	for _, target := range stmt.Targets {
+		kinds = append(kinds, target.Expr.(*Literal).Kind)
+	}
+	assert.Equal(t, []LiteralKind{LiteralInteger, LiteralNumeric, LiteralNumeric, LiteralNumeric, LiteralString, LiteralBool, LiteralNull}, kinds)
+	assert.Equal(t, "-9223372036854775808", stmt.Targets[1].Expr.(*Literal).Value)
+}
+
+func TestParseFrom(t *testing.T) {
+	stmt := parseSelect(t, "select * from a join b on a.id = b.id left join c using (id) cross join d, lateral (select 1) s(x), generate_series(1, 3) g(n), (e natural full join f) j")
+	assert.Equal(t, "SELECT * FROM (((a INNER JOIN b ON (a.id = b.id)) LEFT JOIN c USING (id)) CROSS JOIN d), LATERAL (SELECT 1) AS s(x), generate_series(1, 3) AS g(n), (e NATURAL FULL JOIN f) AS j", stmt.String())
+	require.Len(t, stmt.From, 4)
+
+	join := stmt.From[0].(*JoinExpr)
+	assert.Equal(t, JoinCross, join.Type)
+	assert.Equal(t, JoinLeft, join.Left.(*JoinExpr).Type)
+	sub := stmt.From[1].(*SubqueryRef)
+	assert.True(t, sub.Lateral)
+	assert.Equal(t, &Alias{position: position{pos: 94}, Name: "s", Columns: []string{"x"}}, sub.Alias)
+	assert.Equal(t, "generate_series", stmt.From[2].(*FuncRef).Func.Name)
+	assert.True(t, stmt.From[3].(*JoinExpr).Natural)
+}
+
+func TestParseAggregates(t *testing.T) {
+	stmt := parseSelect(t, "select count(*), sum(distinct x) filter (where x > 0), string_agg(name, ',' order by name desc), percentile_cont(0.5) within group (order by x) from t group by rollup(a, (b, c)), cube(d), grouping sets ((a), (), b) having count(*) > 1")
+	assert.Equal(t, "SELECT count(*), sum(DISTINCT x) FILTER (WHERE (x > 0)), string_agg(name, ',' ORDER BY name DESC), percentile_cont(0.5) WITHIN GROUP (ORDER BY x) FROM t GROUP BY ROLLUP(a, ROW(b, c)), CUBE(d), GROUPING SETS(a, (), b) HAVING (count(*) > 1)", stmt.String())
+
+	count := stmt.Targets[0].Expr.(*FuncCall)
+	assert.True(t, count.Star)
+	sum := stmt.Targets[1].Expr.(*FuncCall)
+	assert.True(t, sum.Distinct)
+	assert.NotNil(t, sum.Filter)
+	require.Len(t, stmt.GroupBy, 3)
+	assert.Equal(t, GroupingRollup, stmt.GroupBy[0].(*GroupingSet).Kind)
+	sets := stmt.GroupBy[2].(*GroupingSet)
+	assert.Equal(t, GroupingSets, sets.Kind)
+	assert.Equal(t, GroupingEmpty, sets.Items[1].(*GroupingSet).Kind)
+}
+
+func TestParseInsert(t *testing.T) {
+	stmt, err := ParseOne("insert into s.t (a, b) values (1, default), (2, 3) on conflict (a) do update set b = 1 where b > 0 returning *")
+	require.NoError(t, err)
+	insert := stmt.(*InsertStmt)
+	assert.Equal(t, "s.t", insert.Table.String())
+	assert.Equal(t, []string{"a", "b"}, insert.Columns)
+	require.Len(t, insert.Query.Values, 2)
+	assert.IsType(t, &DefaultExpr{}, insert.Query.Values[0][1])
+	assert.Equal(t, []string{"a"}, insert.OnConflict.Columns)
+	require.Len(t, insert.OnConflict.Update, 1)
+	assert.Equal(t, "b", insert.OnConflict.Update[0].Column)
+	assert.NotNil(t, insert.OnConflict.Where)
+	assert.IsType(t, &Star{}, insert.Returning[0].Expr)
+
+	stmt, err = ParseOne("insert into t default values")
+	require.NoError(t, err)
+	assert.True(t, stmt.(*InsertStmt).DefaultValues)
+
+	stmt, err = ParseOne("insert into t (select * from u)")
+	require.NoError(t, err)
+	assert.Nil(t, stmt.(*InsertStmt).Columns)
+	assert.Equal(t, "SELECT * FROM u", stmt.(*InsertStmt).Query.String())
+}
+
+func TestParseUpdateDelete(t *testing.T) {
+	stmt, err := ParseOne("update t x set a = a + 1, b = default from u where x.id = u.id returning a")
+	require.NoError(t, err)
+	update := stmt.(*UpdateStmt)
+	assert.Equal(t, "x", update.Alias)
+	require.Len(t, update.Set, 2)
+	assert.Equal(t, "(a + 1)", update.Set[0].Value.String())
+	assert.Len(t, update.From, 1)
+	assert.Equal(t, "(x.id = u.id)", update.Where.String())
+	assert.Len(t, update.Returning, 1)
+
+	stmt, err = ParseOne("update t set a = 1")
+	require.NoError(t, err)
+	assert.Empty(t, stmt.(*UpdateStmt).Alias)
+
+	stmt, err = ParseOne("delete from t using u where t.a = u.a returning *")
+	require.NoError(t, err)
+	del := stmt.(*DeleteStmt)
+	assert.Equal(t, "t", del.Table.Name)
+	assert.Len(t, del.Using, 1)
+	assert.NotNil(t, del.Where)
+}
+
+func TestParseCreateTable(t *testing.T) {
+	stmt, err := ParseOne(`create table if not exists s.t (
+		id serial primary key,
+		name varchar(20) not null default 'x',
+		price numeric(10, 2) constraint positive check (price > 0),
+		score double precision,
+		at timestamp(3) with time zone,
+		ref int references u (id) on delete cascade,
+		constraint uq unique (name, price),
+		primary key (id)
+	)`)
+	require.NoError(t, err)
+	create := stmt.(*CreateTableStmt)
+	assert.True(t, create.IfNotExists)
+	assert.Equal(t, "s", create.Table.Schema)
+	require.Len(t, create.Columns, 6)
+
+	var types []string
+	for _, col := range create.Columns {
+		types = append(types, col.Type.String())
+	}
+	assert.Equal(t, []string{"serial", "varchar(20)", "numeric(10,2)", "float8", "timestamptz(3)", "int4"}, types)
+
+	name := create.Columns[1]
+	require.Len(t, name.Constraints, 2)
+	assert.Equal(t, ConstraintNotNull, name.Constraints[0].Type)
+	assert.Equal(t, ConstraintDefault, name.Constraints[1].Type)
+	assert.Equal(t, "'x'", name.Constraints[1].Expr.String())
+
+	check := create.Columns[2].Constraints[0]
+	assert.Equal(t, "positive", check.Name)
+	assert.Equal(t, ConstraintCheck, check.Type)
+	ref := create.Columns[5].Constraints[0]
+	assert.Equal(t, ConstraintForeignKey, ref.Type)
+	assert.Equal(t, []string{"id"}, ref.RefColumns)
+
+	require.Len(t, create.Constraints, 2)
+	assert.Equal(t, &Constraint{position: create.Constraints[0].position, Name: "uq", Type: ConstraintUnique, Columns: []string{"name", "price"}}, create.Constraints[0])
+	assert.Equal(t, ConstraintPrimaryKey, create.Constraints[1].Type)
+}
+
+func TestParseAlterTable(t *testing.T) {
+	stmt, err := ParseOne("alter table if exists t add column c int not null default 0, add unique (c), drop column if exists d cascade, " +
+		"alter column e set default 1, alter e drop not null, alter column f type bigint, rename column g to h, drop constraint k")
+	require.NoError(t, err)
+	alter := stmt.(*AlterTableStmt)
+	assert.True(t, alter.IfExists)
+
+	var actions []AlterTableAction
+	for _, cmd := range alter.Commands {
+		actions = append(actions, cmd.Action)
+	}
+	assert.Equal(t, []AlterTableAction{
+		AlterAddColumn, AlterAddConstraint, AlterDropColumn, AlterColumnSetDefault,
+		AlterColumnDropNotNull, AlterColumnType, AlterRenameColumn, AlterDropConstraint,
+	}, actions)
+	assert.Equal(t, "c", alter.Commands[0].Column.Name)
+	assert.True(t, alter.Commands[2].IfExists)
+	assert.True(t, alter.Commands[2].Cascade)
+	assert.Equal(t, "int8", alter.Commands[5].Type.Name)
+	assert.Equal(t, "h", alter.Commands[6].NewName)
+
+	stmt, err = ParseOne("alter table t rename to u")
+	require.NoError(t, err)
+	assert.Equal(t, AlterRenameTable, stmt.(*AlterTableStmt).Commands[0].Action)
+}
+
+func TestParseUtilityStatements(t *testing.T) {
+	tests := map[string]Statement{
+		"create schema if not exists s": &CreateSchemaStmt{Name: "s", IfNotExists: true},
+		"drop table if exists a, s.b cascade": &DropStmt{Type: ObjectTable, IfExists: true, Cascade: true, Names: []*ObjectName{
+			{position: position{21}, Name: "a"}, {position: position{24}, Schema: "s", Name: "b"},
+		}},
+		"drop schema s restrict":                        &DropStmt{Type: ObjectSchema, Names: []*ObjectName{{position: position{12}, Name: "s"}}},
+		"begin isolation level serializable, read only": &TransactionStmt{Kind: TransactionBegin, Modes: []string{"ISOLATION LEVEL SERIALIZABLE", "READ ONLY"}},
+		"start transaction":                             &TransactionStmt{Kind: TransactionBegin},
+		"commit work":                                   &TransactionStmt{Kind: TransactionCommit},
+		"end":                                           &TransactionStmt{Kind: TransactionCommit},
+		"rollback":                                      &TransactionStmt{Kind: TransactionRollback},
+		"abort":                                         &TransactionStmt{Kind: TransactionRollback},
+		"set local search_path to public, 'x'": &SetStmt{Local: true, Name: "search_path", Values: []Expr{
+			&Literal{position: position{25}, Kind: LiteralString, Value: "public"},
+			&Literal{position: position{33}, Kind: LiteralString, Value: "x"},
+		}},
+		"set time zone default":            &SetStmt{Name: "timezone"},
+		"set x.y = -5":                     &SetStmt{Name: "x.y", Values: []Expr{&Literal{position: position{10}, Kind: LiteralInteger, Value: "-5"}}},
+		"reset all":                        &ResetStmt{Name: "all"},
+		"show transaction isolation level": &ShowStmt{Name: "transaction_isolation"},
+		"show DateStyle":                   &ShowStmt{Name: "datestyle"},
+		"listen Cache":                     &ListenStmt{Channel: "cache"},
+		"unlisten \"Cache\"":               &UnlistenStmt{Channel: "Cache"},
+		"unlisten *":                       &UnlistenStmt{},
+		"notify cache, 'it''s stale'":      &NotifyStmt{Channel: "cache", Payload: "it's stale"},
+	}
+	for sql, want := range tests {
+		stmt, err := ParseOne(sql)
+		require.NoError(t, err, sql)
+		assert.Equal(t, want, stmt, sql)
+	}
+}
+
+func TestParseExplain(t *testing.T) {
+	stmt, err := ParseOne("explain select 1")
+	require.NoError(t, err)
+	explain := stmt.(*ExplainStmt)
+	assert.False(t, explain.Analyze)
+	assert.True(t, explain.Costs)
+	assert.Equal(t, "text", explain.Format)
+	assert.IsType(t, &SelectStmt{}, explain.Statement)
+
+	stmt, err = ParseOne("explain analyze verbose update t set a = 1")
+	require.NoError(t, err)
+	explain = stmt.(*ExplainStmt)
+	assert.True(t, explain.Analyze)
+	assert.True(t, explain.Verbose)
+	assert.IsType(t, &UpdateStmt{}, explain.Statement)
+
+	stmt, err = ParseOne("explain (analyze, costs off, format json) (select 1)")
+	require.NoError(t, err)
+	explain = stmt.(*ExplainStmt)
+	assert.True(t, explain.Analyze)
+	assert.False(t, explain.Costs)
+	assert.Equal(t, "json", explain.Format)
+
+	_, err = ParseOne("explain (color on) select 1")
+	assert.Equal(t, &Error{Message: `unrecognized EXPLAIN option "color"`, Position: 10}, err)
+}
+
+func TestParseCopy(t *testing.T) {
+	stmt, err := ParseOne(`copy s.Items (id, "Name") from stdin with (format csv, header, delimiter ';')`)
+	require.NoError(t, err)
+	cp := stmt.(*CopyStmt)
+	assert.Equal(t, "s.items", cp.Table.String())
+	assert.Equal(t, []string{"id", "Name"}, cp.Columns)
+	assert.True(t, cp.From)
+	assert.Empty(t, cp.File)
+	assert.Equal(t, []*CopyOption{{Name: "format", Value: "csv"}, {Name: "header"}, {Name: "delimiter", Value: ";"}}, cp.Options)
+
+	stmt, err = ParseOne("copy (select a from t) to stdout csv header quote as ''''")
+	require.NoError(t, err)
+	cp = stmt.(*CopyStmt)
+	assert.False(t, cp.From)
+	require.NotNil(t, cp.Query)
+	assert.Equal(t, []*CopyOption{{Name: "format", Value: "csv"}, {Name: "header"}, {Name: "quote", Value: "'"}}, cp.Options)
+
+	stmt, err = ParseOne("copy t to '/tmp/t.csv'")
+	require.NoError(t, err)
+	assert.Equal(t, "/tmp/t.csv", stmt.(*CopyStmt).File)
+
+	_, err = ParseOne("copy t from stdin with csv x")
+	assert.Error(t, err)
+}
+
+func TestParseCursorStatements(t *testing.T) {
+	stmt, err := ParseOne(`declare "My Cursor" binary no scroll cursor with hold for select * from t`)
+	require.NoError(t, err)
+	declare := stmt.(*DeclareCursorStmt)
+	assert.Equal(t, "My Cursor", declare.Name)
+	assert.True(t, declare.Binary)
+	assert.False(t, declare.Scroll)
+	assert.True(t, declare.Hold)
+	assert.Equal(t, "SELECT * FROM t", declare.Query.String())
+
+	tests := map[string]Statement{
+		"fetch c":                  &FetchStmt{Count: 1, Name: "c"},
+		"fetch next":               &FetchStmt{Count: 1, Name: "next"},
+		"fetch next from c":        &FetchStmt{Count: 1, Name: "c"},
+		"fetch 10 in c":            &FetchStmt{Count: 10, Name: "c"},
+		"fetch -2 c":               &FetchStmt{Count: -2, Name: "c"},
+		"fetch forward all from c": &FetchStmt{Count: 1, All: true, Name: "c"},
+		"fetch backward 3 c":       &FetchStmt{Direction: FetchBackward, Count: 3, Name: "c"},
+		"fetch prior c":            &FetchStmt{Direction: FetchBackward, Count: 1, Name: "c"},
+		"fetch last c":             &FetchStmt{Direction: FetchAbsolute, Count: -1, Name: "c"},
+		"fetch relative -1 c":      &FetchStmt{Direction: FetchRelative, Count: -1, Name: "c"},
+		"move all c":               &FetchStmt{Move: true, Count: 1, All: true, Name: "c"},
+		"close c":                  &CloseStmt{Name: "c"},
+		"close all":                &CloseStmt{},
+	}
+	for sql, want := range tests {
+		stmt, err := ParseOne(sql)
+		require.NoError(t, err, sql)
+		assert.Equal(t, want, stmt, sql)
+	}
+
+	for _, sql := range []string{"declare c cursor", "declare c for select 1", "declare c cursor for insert into t values (1)", "fetch 1 2 c", "fetch all", "close"} {
+		_, err := ParseOne(sql)
+		assert.Error(t, err, sql)
+	}
+}
+
+func TestParseMultipleStatements(t *testing.T) {
+	statements, err := Parse(";select 1;; select 2;")
+	require.NoError(t, err)
+	require.Len(t, statements, 2)
+	assert.Equal(t, 12, statements[1].Pos())
+
+	statements, err = Parse(" -- nothing")
+	require.NoError(t, err)
+	assert.Empty(t, statements)
+
+	_, err = ParseOne("select 1; select 2")
+	assert.Equal(t, &Error{Message: "cannot insert multiple commands into a prepared statement", Position: 11}, err)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		sql      string
+		message  string
+		position int
+	}{
+		{"selec 1", `syntax error at or near "selec"`, 1},
+		{"select 1 +", "syntax error at end of input", 11},
+		{"select a from", "syntax error at end of input", 14},
+		{"select * form t", `syntax error at or near "form"`, 10},
+		{"select (1", "syntax error at end of input", 10},
+		{"select 1 2", `syntax error at or near "2"`, 10},
+		{"select 'é' from where", `syntax error at or near "where"`, 17},
+		{"select $0", "there is no parameter $0", 8},
+		{"select * from (select 1)", "subquery in FROM must have an alias", 15},
+		{"insert into t values (1), (1, 2)", "VALUES lists must all be the same length", 28},
+		{"create table t (a int,)", `syntax error at or near ")"`, 23},
+		{"(select 1 order by 1) order by 1", "multiple ORDER BY clauses not allowed", 23},
+		{"select 1 limit 1 limit 2", "multiple LIMIT clauses not allowed", 18},
+		{"insert into t values (1) on conflict do update set a = 1", "ON CONFLICT DO UPDATE requires inference specification or constraint name", 26},
+		{"select from", `syntax error at end of input`, 12},
+		{"select case end", `syntax error at or near "end"`, 13},
+		{"select sum(x) over ()", `syntax error at or near "over"`, 15},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.sql)
+		assert.Equal(t, &Error{Message: tt.message, Position: tt.position}, err, tt.sql)
+	}
+}
+
+func TestParseExpr(t *testing.T) {
+	e, err := ParseExpr("a + 1 > b")
+	require.NoError(t, err)
+	assert.Equal(t, "((a + 1) > b)", e.String())
+	assert.Equal(t, 6, e.Pos())
+
+	_, err = ParseExpr("a +")
+	assert.Error(t, err)
+}
+
+func TestQuoteIdent(t *testing.T) {
+	assert.Equal(t, "abc_1", QuoteIdent("abc_1"))
+	assert.Equal(t, `"Abc"`, QuoteIdent("Abc"))
+	assert.Equal(t, `"select"`, QuoteIdent("select"))
+	assert.Equal(t, `"1a"`, QuoteIdent("1a"))
+	assert.Equal(t, `"a""b"`, QuoteIdent(`a"b`))
+}
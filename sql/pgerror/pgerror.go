@@ -0,0 +1,129 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package pgerror defines the errors reported to PostgreSQL clients and
+// their SQLSTATE codes. It is shared by the server and the SQL packages
+// so that neither depends on the other.
+package pgerror
+
+import "fmt"
+
+// Severity levels of errors and notices
+const (
+	SeverityPanic   = "PANIC"
+	SeverityFatal   = "FATAL"
+	SeverityError   = "ERROR"
+	SeverityWarning = "WARNING"
+	SeverityNotice  = "NOTICE"
+	SeverityDebug   = "DEBUG"
+	SeverityInfo    = "INFO"
+	SeverityLog     = "LOG"
+)
+
+// SQLSTATE codes
+// https://www.postgresql.org/docs/14/errcodes-appendix.html
+const (
+	CodeSuccessfulCompletion              = "00000"
+	CodeWarning                           = "01000"
+	CodeConnectionException               = "08000"
+	CodeProtocolViolation                 = "08P01"
+	CodeFeatureNotSupported               = "0A000"
+	CodeCardinalityViolation              = "21000"
+	CodeStringDataRightTruncation         = "22001"
+	CodeNumericValueOutOfRange            = "22003"
+	CodeSubstringError                    = "22011"
+	CodeDivisionByZero                    = "22012"
+	CodeInvalidArgumentForLogarithm       = "2201E"
+	CodeInvalidArgumentForPowerFunction   = "2201F"
+	CodeInvalidRowCountInLimitClause      = "2201W"
+	CodeInvalidRowCountInResultOffset     = "2201X"
+	CodeInvalidEscapeSequence             = "22025"
+	CodeSequenceGeneratorLimitExceeded    = "2200H"
+	CodeInvalidParameterValue             = "22023"
+	CodeInvalidTextRepresentation         = "22P02"
+	CodeInvalidBinaryRepresentation       = "22P03"
+	CodeBadCopyFileFormat                 = "22P04"
+	CodeNotNullViolation                  = "23502"
+	CodeUniqueViolation                   = "23505"
+	CodeInvalidAuthorizationSpecification = "28000"
+	CodeInvalidPassword                   = "28P01"
+	CodeInvalidSQLStatementName           = "26000"
+	CodeDependentObjectsStillExist        = "2BP01"
+	CodeInvalidCursorName                 = "34000"
+	CodeInvalidSchemaName                 = "3F000"
+	CodeSyntaxError                       = "42601"
+	CodeDuplicateColumn                   = "42701"
+	CodeAmbiguousColumn                   = "42702"
+	CodeUndefinedColumn                   = "42703"
+	CodeUndefinedObject                   = "42704"
+	CodeGroupingError                     = "42803"
+	CodeDatatypeMismatch                  = "42804"
+	CodeWrongObjectType                   = "42809"
+	CodeCannotCoerce                      = "42846"
+	CodeUndefinedFunction                 = "42883"
+	CodeUndefinedTable                    = "42P01"
+	CodeUndefinedParameter                = "42P02"
+	CodeDuplicateCursor                   = "42P03"
+	CodeDuplicatePreparedStatement        = "42P05"
+	CodeDuplicateSchema                   = "42P06"
+	CodeDuplicateTable                    = "42P07"
+	CodeInvalidColumnReference            = "42P10"
+	CodeInvalidCursorDefinition           = "42P11"
+	CodeInvalidTableDefinition            = "42P16"
+	CodeIndeterminateDatatype             = "42P18"
+	CodeProgramLimitExceeded              = "54000"
+	CodeCantChangeRuntimeParam            = "55P02"
+	CodeQueryCanceled                     = "57014"
+	CodeAdminShutdown                     = "57P01"
+	CodeInternalError                     = "XX000"
+)
+
+// Error is an error which is reported to the client as an ErrorResponse.
+// Handlers return it to control the SQLSTATE code and the other fields
+// shown to the user, any other error is reported as an internal error.
+type Error struct {
+	Severity         string
+	Code             string
+	Message          string
+	Detail           string
+	Hint             string
+	Position         int32
+	InternalPosition int32
+	InternalQuery    string
+	Where            string
+	SchemaName       string
+	TableName        string
+	ColumnName       string
+	DataTypeName     string
+	ConstraintName   string
+	File             string
+	Line             int32
+	Routine          string
+}
+
+// NewError returns an error with the ERROR severity and the given SQLSTATE code
+func NewError(code string, format string, args ...interface{}) *Error {
+	return &Error{
+		Severity: SeverityError,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s (SQLSTATE %s)", e.Severity, e.Message, e.Code)
+}
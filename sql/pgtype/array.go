@@ -0,0 +1,255 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pgtype
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+)
+
+// arrayCodec handles one dimensional arrays of elem which decode to
+// []interface{} with nil for NULL elements. Encoding accepts any slice
+// whose elements the element type can encode.
+type arrayCodec struct {
+	elem *Type
+}
+
+func (c *arrayCodec) name() string {
+	return c.elem.Name + "[]"
+}
+
+// values returns the elements of the slice
+func (c *arrayCodec) values(v interface{}) ([]interface{}, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		return v, nil
+	case string:
+		elems, err := c.DecodeText([]byte(v))
+		if err != nil {
+			return nil, err
+		}
+		return elems.([]interface{}), nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, errCannotEncode(c.name(), v)
+	}
+	elems := make([]interface{}, rv.Len())
+	for i := range elems {
+		elems[i] = rv.Index(i).Interface()
+	}
+	return elems, nil
+}
+
+func (c *arrayCodec) EncodeText(v interface{}) ([]byte, error) {
+	elems, err := c.values(v)
+	if err != nil {
+		return nil, err
+	}
+	buf := []byte{'{'}
+	for i, e := range elems {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		if e == nil {
+			buf = append(buf, "NULL"...)
+			continue
+		}
+		text, err := c.elem.Codec.EncodeText(e)
+		if err != nil {
+			return nil, err
+		}
+		buf = appendArrayElem(buf, text)
+	}
+	return append(buf, '}'), nil
+}
+
+// appendArrayElem quotes the element when it would otherwise be read back
+// differently
+func appendArrayElem(buf, text []byte) []byte {
+	if len(text) > 0 && !bytes.ContainsAny(text, "{},\"\\ \t\n\r") && !strings.EqualFold(string(text), "NULL") {
+		return append(buf, text...)
+	}
+	buf = append(buf, '"')
+	for _, c := range text {
+		if c == '"' || c == '\\' {
+			buf = append(buf, '\\')
+		}
+		buf = append(buf, c)
+	}
+	return append(buf, '"')
+}
+
+func (c *arrayCodec) EncodeBinary(v interface{}) ([]byte, error) {
+	elems, err := c.values(v)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 12, 20)
+	binary.BigEndian.PutUint32(buf[8:], c.elem.OID)
+	if len(elems) == 0 {
+		// an empty array has no dimensions
+		return buf, nil
+	}
+	binary.BigEndian.PutUint32(buf, 1)
+	buf = append(buf, 0, 0, 0, 0, 0, 0, 0, 1)
+	binary.BigEndian.PutUint32(buf[12:], uint32(len(elems)))
+
+	hasNull := uint32(0)
+	for _, e := range elems {
+		if e == nil {
+			hasNull = 1
+			buf = append(buf, 0xff, 0xff, 0xff, 0xff)
+			continue
+		}
+		data, err := c.elem.Codec.EncodeBinary(e)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(buf[len(buf)-4:], uint32(len(data)))
+		buf = append(buf, data...)
+	}
+	binary.BigEndian.PutUint32(buf[4:], hasNull)
+	return buf, nil
+}
+
+func (c *arrayCodec) DecodeText(src []byte) (interface{}, error) {
+	s := bytes.TrimSpace(src)
+	if len(s) < 2 || s[0] != '{' || s[len(s)-1] != '}' {
+		return nil, errInvalidText(c.name(), src)
+	}
+	s = s[1 : len(s)-1]
+	elems := []interface{}{}
+	if len(bytes.TrimSpace(s)) == 0 {
+		return elems, nil
+	}
+
+	for i := 0; ; i++ {
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		var text []byte
+		quoted := false
+		switch {
+		case i < len(s) && s[i] == '{':
+			return nil, pgerror.NewError(pgerror.CodeFeatureNotSupported, "multidimensional arrays are not supported")
+		case i < len(s) && s[i] == '"':
+			quoted = true
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				text = append(text, s[i])
+			}
+			if i == len(s) {
+				return nil, errInvalidText(c.name(), src)
+			}
+			i++
+		default:
+			start := i
+			for i < len(s) && s[i] != ',' {
+				if s[i] == '"' || s[i] == '{' || s[i] == '}' {
+					return nil, errInvalidText(c.name(), src)
+				}
+				i++
+			}
+			text = bytes.TrimSpace(s[start:i])
+			if len(text) == 0 {
+				return nil, errInvalidText(c.name(), src)
+			}
+		}
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+
+		if !quoted && strings.EqualFold(string(text), "NULL") {
+			elems = append(elems, nil)
+		} else {
+			e, err := c.elem.Codec.DecodeText(text)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, e)
+		}
+
+		if i == len(s) {
+			return elems, nil
+		}
+		if s[i] != ',' {
+			return nil, errInvalidText(c.name(), src)
+		}
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func (c *arrayCodec) DecodeBinary(src []byte) (interface{}, error) {
+	if len(src) < 12 {
+		return nil, errInvalidBinary(c.name())
+	}
+	ndim := int32(binary.BigEndian.Uint32(src))
+	elemOID := binary.BigEndian.Uint32(src[8:])
+	if elemOID != c.elem.OID {
+		return nil, pgerror.NewError(pgerror.CodeDatatypeMismatch, "binary data has array element type %d instead of expected %d", elemOID, c.elem.OID)
+	}
+	switch {
+	case ndim == 0:
+		return []interface{}{}, nil
+	case ndim > 1:
+		return nil, pgerror.NewError(pgerror.CodeFeatureNotSupported, "multidimensional arrays are not supported")
+	case ndim < 0 || len(src) < 20:
+		return nil, errInvalidBinary(c.name())
+	}
+
+	n := int32(binary.BigEndian.Uint32(src[12:]))
+	if n < 0 || int(n) > (len(src)-20)/4 {
+		return nil, errInvalidBinary(c.name())
+	}
+	elems := make([]interface{}, n)
+	src = src[20:]
+	for i := range elems {
+		if len(src) < 4 {
+			return nil, errInvalidBinary(c.name())
+		}
+		size := int32(binary.BigEndian.Uint32(src))
+		src = src[4:]
+		if size == -1 {
+			continue
+		}
+		if size < 0 || int(size) > len(src) {
+			return nil, errInvalidBinary(c.name())
+		}
+		e, err := c.elem.Codec.DecodeBinary(src[:size])
+		if err != nil {
+			return nil, err
+		}
+		elems[i] = e
+		src = src[size:]
+	}
+	if len(src) != 0 {
+		return nil, errInvalidBinary(c.name())
+	}
+	return elems, nil
+}
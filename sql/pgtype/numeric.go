@@ -0,0 +1,281 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pgtype
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// numericCodec decodes to the decimal string of the value so no precision
+// is lost. Encoding also accepts integers, floats and *big.Int.
+type numericCodec struct{}
+
+// numeric signs of the binary format
+const (
+	numericPos    = 0x0000
+	numericNeg    = 0x4000
+	numericNaN    = 0xC000
+	numericPosInf = 0xD000
+	numericNegInf = 0xF000
+)
+
+func (numericCodec) value(v interface{}) (string, error) {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		s = fmt.Sprint(v)
+	case float32:
+		s = strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case *big.Int:
+		s = v.String()
+	default:
+		return "", errCannotEncode("numeric", v)
+	}
+	n, err := parseNumeric(s)
+	if err != nil {
+		return "", errInvalidText("numeric", []byte(s))
+	}
+	return n.String(), nil
+}
+
+func (c numericCodec) EncodeText(v interface{}) ([]byte, error) {
+	s, err := c.value(v)
+	return []byte(s), err
+}
+
+func (c numericCodec) EncodeBinary(v interface{}) ([]byte, error) {
+	s, err := c.value(v)
+	if err != nil {
+		return nil, err
+	}
+	n, err := parseNumeric(s)
+	if err != nil {
+		return nil, errInvalidText("numeric", []byte(s))
+	}
+	return n.binary(), nil
+}
+
+func (numericCodec) DecodeText(src []byte) (interface{}, error) {
+	n, err := parseNumeric(strings.TrimSpace(string(src)))
+	if err != nil {
+		return nil, errInvalidText("numeric", src)
+	}
+	return n.String(), nil
+}
+
+func (numericCodec) DecodeBinary(src []byte) (interface{}, error) {
+	n, err := decodeNumeric(src)
+	if err != nil {
+		return nil, err
+	}
+	return n.String(), nil
+}
+
+// decimal is a parsed numeric value, digits holds the decimal digits of
+// the integer part followed by scale digits of the fraction.
+type decimal struct {
+	special uint16 // numericNaN, numericPosInf or numericNegInf
+	neg     bool
+	digits  string
+	scale   int
+}
+
+// parseNumeric parses a decimal number in plain or exponent notation
+func parseNumeric(s string) (decimal, error) {
+	switch strings.ToLower(s) {
+	case "nan":
+		return decimal{special: numericNaN}, nil
+	case "infinity", "+infinity", "inf", "+inf":
+		return decimal{special: numericPosInf}, nil
+	case "-infinity", "-inf":
+		return decimal{special: numericNegInf}, nil
+	}
+
+	var d decimal
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		d.neg = s[0] == '-'
+		s = s[1:]
+	}
+	exp := 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(s[i+1:])
+		if err != nil || e > 1000 || e < -1000 {
+			return d, fmt.Errorf("invalid exponent")
+		}
+		exp, s = e, s[:i]
+	}
+	intPart, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, frac = s[:i], s[i+1:]
+	}
+	if intPart == "" && frac == "" {
+		return d, fmt.Errorf("no digits")
+	}
+	for _, c := range intPart + frac {
+		if c < '0' || c > '9' {
+			return d, fmt.Errorf("invalid digit")
+		}
+	}
+
+	// shift the decimal point by the exponent
+	digits := intPart + frac
+	scale := len(frac) - exp
+	if scale < 0 {
+		digits += strings.Repeat("0", -scale)
+		scale = 0
+	}
+	d.digits = strings.TrimLeft(digits, "0")
+	if len(d.digits) < scale {
+		d.digits = strings.Repeat("0", scale-len(d.digits)) + d.digits
+	}
+	d.scale = scale
+	if strings.Trim(d.digits, "0") == "" {
+		d.neg = false
+	}
+	return d, nil
+}
+
+// String formats the number with its scale like postgres
+func (d decimal) String() string {
+	switch d.special {
+	case numericNaN:
+		return "NaN"
+	case numericPosInf:
+		return "Infinity"
+	case numericNegInf:
+		return "-Infinity"
+	}
+	intPart := d.digits[:len(d.digits)-d.scale]
+	if intPart == "" {
+		intPart = "0"
+	}
+	s := intPart
+	if d.scale > 0 {
+		s += "." + d.digits[len(d.digits)-d.scale:]
+	}
+	if d.neg {
+		s = "-" + s
+	}
+	return s
+}
+
+// binary encodes the number in base 10000 digits
+func (d decimal) binary() []byte {
+	header := func(ndigits, weight int, sign uint16, dscale int) []byte {
+		buf := make([]byte, 8, 8+2*ndigits)
+		binary.BigEndian.PutUint16(buf, uint16(ndigits))
+		binary.BigEndian.PutUint16(buf[2:], uint16(int16(weight)))
+		binary.BigEndian.PutUint16(buf[4:], sign)
+		binary.BigEndian.PutUint16(buf[6:], uint16(dscale))
+		return buf
+	}
+	if d.special != 0 {
+		return header(0, 0, d.special, 0)
+	}
+
+	intPart := d.digits[:len(d.digits)-d.scale]
+	frac := d.digits[len(d.digits)-d.scale:]
+	// pad both parts to whole groups of four digits
+	if r := len(intPart) % 4; r != 0 {
+		intPart = strings.Repeat("0", 4-r) + intPart
+	}
+	if r := len(frac) % 4; r != 0 {
+		frac += strings.Repeat("0", 4-r)
+	}
+	all := intPart + frac
+	groups := make([]uint16, len(all)/4)
+	for i := range groups {
+		n, _ := strconv.Atoi(all[i*4 : i*4+4])
+		groups[i] = uint16(n)
+	}
+	weight := len(intPart)/4 - 1
+
+	// leading and trailing zero groups are not stored
+	for len(groups) > 0 && groups[0] == 0 {
+		groups = groups[1:]
+		weight--
+	}
+	for len(groups) > 0 && groups[len(groups)-1] == 0 {
+		groups = groups[:len(groups)-1]
+	}
+	if len(groups) == 0 {
+		weight = 0
+	}
+
+	sign := uint16(numericPos)
+	if d.neg {
+		sign = numericNeg
+	}
+	buf := header(len(groups), weight, sign, d.scale)
+	for _, g := range groups {
+		buf = append(buf, byte(g>>8), byte(g))
+	}
+	return buf
+}
+
+func decodeNumeric(src []byte) (decimal, error) {
+	if len(src) < 8 {
+		return decimal{}, errInvalidBinary("numeric")
+	}
+	ndigits := int(binary.BigEndian.Uint16(src))
+	weight := int(int16(binary.BigEndian.Uint16(src[2:])))
+	sign := binary.BigEndian.Uint16(src[4:])
+	dscale := int(binary.BigEndian.Uint16(src[6:]))
+	if len(src) != 8+2*ndigits {
+		return decimal{}, errInvalidBinary("numeric")
+	}
+	switch sign {
+	case numericNaN, numericPosInf, numericNegInf:
+		return decimal{special: sign}, nil
+	case numericPos, numericNeg:
+	default:
+		return decimal{}, errInvalidBinary("numeric")
+	}
+
+	// the digit at position p has the value 10000^p, positions which are
+	// not stored are zero
+	group := func(p int) int {
+		i := weight - p
+		if i < 0 || i >= ndigits {
+			return 0
+		}
+		return int(binary.BigEndian.Uint16(src[8+2*i:]))
+	}
+	var sb strings.Builder
+	for p := weight; p >= 0; p-- {
+		fmt.Fprintf(&sb, "%04d", group(p))
+	}
+	intPart := strings.TrimLeft(sb.String(), "0")
+	sb.Reset()
+	for p := -1; sb.Len() < dscale; p-- {
+		fmt.Fprintf(&sb, "%04d", group(p))
+	}
+	digits := intPart + sb.String()[:dscale]
+	if len(digits) < dscale {
+		digits = strings.Repeat("0", dscale-len(digits)) + digits
+	}
+	return decimal{neg: sign == numericNeg, digits: digits, scale: dscale}, nil
+}
@@ -0,0 +1,1149 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pgtype
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+)
+
+// OIDs of the built in postgres types
+// https://github.com/postgres/postgres/blob/REL_14_STABLE/src/include/catalog/pg_type.dat
+const (
+	BoolOID             = 16
+	ByteaOID            = 17
+	NameOID             = 19
+	Int8OID             = 20
+	Int2OID             = 21
+	Int4OID             = 23
+	TextOID             = 25
+	JSONOID             = 114
+	JSONArrayOID        = 199
+	Float4OID           = 700
+	Float8OID           = 701
+	BoolArrayOID        = 1000
+	ByteaArrayOID       = 1001
+	NameArrayOID        = 1003
+	Int2ArrayOID        = 1005
+	Int4ArrayOID        = 1007
+	TextArrayOID        = 1009
+	BPCharArrayOID      = 1014
+	VarcharArrayOID     = 1015
+	Int8ArrayOID        = 1016
+	Float4ArrayOID      = 1021
+	Float8ArrayOID      = 1022
+	BPCharOID           = 1042
+	VarcharOID          = 1043
+	DateOID             = 1082
+	TimestampOID        = 1114
+	TimestampArrayOID   = 1115
+	DateArrayOID        = 1182
+	TimestamptzOID      = 1184
+	TimestamptzArrayOID = 1185
+	IntervalOID         = 1186
+	IntervalArrayOID    = 1187
+	NumericArrayOID     = 1231
+	NumericOID          = 1700
+	UUIDOID             = 2950
+	UUIDArrayOID        = 2951
+	JSONBOID            = 3802
+	JSONBArrayOID       = 3807
+)
+
+// Codec converts between Go values and the text and binary formats of a
+// type. Decoding returns the Go type the codec was written for, encoding
+// also accepts the types a value can be converted from without loss.
+type Codec interface {
+	EncodeText(v interface{}) ([]byte, error)
+	EncodeBinary(v interface{}) ([]byte, error)
+	DecodeText(src []byte) (interface{}, error)
+	DecodeBinary(src []byte) (interface{}, error)
+}
+
+// Type is a postgres data type known to the server
+type Type struct {
+	Name string
+	OID  uint32
+	// Size is the length of the binary representation, -1 when variable
+	Size  int16
+	Codec Codec
+}
+
+// TypeRegistry maps type OIDs to the codecs used to send results and to
+// receive parameters in the format requested by the client.
+type TypeRegistry struct {
+	mu     sync.RWMutex
+	byOID  map[uint32]*Type
+	byName map[string]*Type
+}
+
+// DefaultTypes holds the built in types
+var DefaultTypes = NewTypeRegistry()
+
+// NewTypeRegistry returns a registry holding the built in types
+func NewTypeRegistry() *TypeRegistry {
+	r := &TypeRegistry{byOID: make(map[uint32]*Type), byName: make(map[string]*Type)}
+	scalars := []struct {
+		t        Type
+		arrayOID uint32
+	}{
+		{Type{"bool", BoolOID, 1, boolCodec{}}, BoolArrayOID},
+		{Type{"bytea", ByteaOID, -1, byteaCodec{}}, ByteaArrayOID},
+		{Type{"name", NameOID, 64, textCodec{}}, NameArrayOID},
+		{Type{"int8", Int8OID, 8, intCodec{size: 8}}, Int8ArrayOID},
+		{Type{"int2", Int2OID, 2, intCodec{size: 2}}, Int2ArrayOID},
+		{Type{"int4", Int4OID, 4, intCodec{size: 4}}, Int4ArrayOID},
+		{Type{"text", TextOID, -1, textCodec{}}, TextArrayOID},
+		{Type{"json", JSONOID, -1, jsonCodec{}}, JSONArrayOID},
+		{Type{"float4", Float4OID, 4, floatCodec{size: 4}}, Float4ArrayOID},
+		{Type{"float8", Float8OID, 8, floatCodec{size: 8}}, Float8ArrayOID},
+		{Type{"bpchar", BPCharOID, -1, textCodec{}}, BPCharArrayOID},
+		{Type{"varchar", VarcharOID, -1, textCodec{}}, VarcharArrayOID},
+		{Type{"date", DateOID, 4, dateCodec{}}, DateArrayOID},
+		{Type{"timestamp", TimestampOID, 8, timestampCodec{}}, TimestampArrayOID},
+		{Type{"timestamptz", TimestamptzOID, 8, timestampCodec{tz: true}}, TimestamptzArrayOID},
+		{Type{"interval", IntervalOID, 16, intervalCodec{}}, IntervalArrayOID},
+		{Type{"numeric", NumericOID, -1, numericCodec{}}, NumericArrayOID},
+		{Type{"uuid", UUIDOID, 16, uuidCodec{}}, UUIDArrayOID},
+		{Type{"jsonb", JSONBOID, -1, jsonCodec{binary: true}}, JSONBArrayOID},
+	}
+	for _, s := range scalars {
+		elem := s.t
+		r.Register(&elem)
+		r.Register(&Type{
+			Name:  "_" + elem.Name,
+			OID:   s.arrayOID,
+			Size:  -1,
+			Codec: &arrayCodec{elem: &elem},
+		})
+	}
+	return r
+}
+
+// Register adds the type, replacing any type with the same OID or name
+func (r *TypeRegistry) Register(t *Type) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byOID[t.OID] = t
+	r.byName[t.Name] = t
+}
+
+// Lookup returns the type with the OID
+func (r *TypeRegistry) Lookup(oid uint32) (*Type, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.byOID[oid]
+	return t, ok
+}
+
+// LookupName returns the type with the name, array types are prefixed
+// with an underscore like in pg_type.
+func (r *TypeRegistry) LookupName(name string) (*Type, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.byName[name]
+	return t, ok
+}
+
+// Encode converts the value to the format of the type, nil is NULL. Values
+// of unknown types are encoded with their default text representation.
+func (r *TypeRegistry) Encode(oid uint32, v interface{}, format int16) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	t, ok := r.Lookup(oid)
+	if !ok {
+		return encodeValue(v, format)
+	}
+	if b, ok := v.([]byte); ok && oid != ByteaOID {
+		// already encoded by the handler
+		return b, nil
+	}
+	if format == 1 {
+		return t.Codec.EncodeBinary(v)
+	}
+	return t.Codec.EncodeText(v)
+}
+
+// Decode converts a parameter sent by the client, nil is NULL. Parameters
+// of unknown types are returned as string in text and []byte in binary
+// format.
+func (r *TypeRegistry) Decode(oid uint32, src []byte, format int16) (interface{}, error) {
+	if src == nil {
+		return nil, nil
+	}
+	t, ok := r.Lookup(oid)
+	switch {
+	case !ok && format == 0:
+		return string(src), nil
+	case !ok:
+		return append([]byte{}, src...), nil
+	case format == 1:
+		return t.Codec.DecodeBinary(src)
+	default:
+		return t.Codec.DecodeText(src)
+	}
+}
+
+// Column describes a single column of a result set
+type Column struct {
+	Name            string
+	TableOID        uint32
+	AttributeNumber uint16
+	TypeOID         uint32
+	TypeSize        int16
+	TypeModifier    int32
+	// Format is 0 for text and 1 for binary, the server overrides it with
+	// the result format requested by the client.
+	Format int16
+}
+
+// NewColumn returns a column of the built in type
+func NewColumn(name string, oid uint32) Column {
+	size := int16(-1)
+	if t, ok := DefaultTypes.Lookup(oid); ok {
+		size = t.Size
+	}
+	return Column{Name: name, TypeOID: oid, TypeSize: size, TypeModifier: -1}
+}
+
+// errInvalidText is returned for text which is not valid input of a type
+func errInvalidText(typ string, src []byte) error {
+	return pgerror.NewError(pgerror.CodeInvalidTextRepresentation, "invalid input syntax for type %s: \"%s\"", typ, src)
+}
+
+// errInvalidBinary is returned for malformed binary input of a type
+func errInvalidBinary(typ string) error {
+	return pgerror.NewError(pgerror.CodeInvalidBinaryRepresentation, "incorrect binary data format for type %s", typ)
+}
+
+func errCannotEncode(typ string, v interface{}) error {
+	return pgerror.NewError(pgerror.CodeDatatypeMismatch, "cannot encode %T as %s", v, typ)
+}
+
+type boolCodec struct{}
+
+func (boolCodec) value(v interface{}) (bool, error) {
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case string:
+		b, err := boolCodec{}.DecodeText([]byte(v))
+		if err != nil {
+			return false, err
+		}
+		return b.(bool), nil
+	}
+	return false, errCannotEncode("boolean", v)
+}
+
+func (c boolCodec) EncodeText(v interface{}) ([]byte, error) {
+	b, err := c.value(v)
+	if err != nil {
+		return nil, err
+	}
+	if b {
+		return []byte("t"), nil
+	}
+	return []byte("f"), nil
+}
+
+func (c boolCodec) EncodeBinary(v interface{}) ([]byte, error) {
+	b, err := c.value(v)
+	if err != nil {
+		return nil, err
+	}
+	if b {
+		return []byte{1}, nil
+	}
+	return []byte{0}, nil
+}
+
+func (boolCodec) DecodeText(src []byte) (interface{}, error) {
+	switch strings.ToLower(strings.TrimSpace(string(src))) {
+	case "t", "true", "y", "yes", "on", "1":
+		return true, nil
+	case "f", "false", "n", "no", "off", "0":
+		return false, nil
+	}
+	return nil, errInvalidText("boolean", src)
+}
+
+func (boolCodec) DecodeBinary(src []byte) (interface{}, error) {
+	if len(src) != 1 {
+		return nil, errInvalidBinary("boolean")
+	}
+	return src[0] != 0, nil
+}
+
+// intCodec handles int2, int4 and int8 which decode to int16, int32 and
+// int64.
+type intCodec struct {
+	size int
+}
+
+func (c intCodec) name() string {
+	switch c.size {
+	case 2:
+		return "smallint"
+	case 4:
+		return "integer"
+	}
+	return "bigint"
+}
+
+func (c intCodec) value(v interface{}) (int64, error) {
+	var n int64
+	switch v := v.(type) {
+	case int:
+		n = int64(v)
+	case int8:
+		n = int64(v)
+	case int16:
+		n = int64(v)
+	case int32:
+		n = int64(v)
+	case int64:
+		n = v
+	case uint:
+		if uint64(v) > math.MaxInt64 {
+			return 0, c.outOfRange()
+		}
+		n = int64(v)
+	case uint8:
+		n = int64(v)
+	case uint16:
+		n = int64(v)
+	case uint32:
+		n = int64(v)
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, c.outOfRange()
+		}
+		n = int64(v)
+	case string:
+		i, err := c.DecodeText([]byte(v))
+		if err != nil {
+			return 0, err
+		}
+		return c.value(i)
+	default:
+		return 0, errCannotEncode(c.name(), v)
+	}
+	bits := uint(c.size * 8)
+	if n < -1<<(bits-1) || n > 1<<(bits-1)-1 {
+		return 0, c.outOfRange()
+	}
+	return n, nil
+}
+
+func (c intCodec) outOfRange() error {
+	return pgerror.NewError(pgerror.CodeNumericValueOutOfRange, "value out of range for type %s", c.name())
+}
+
+func (c intCodec) typed(n int64) interface{} {
+	switch c.size {
+	case 2:
+		return int16(n)
+	case 4:
+		return int32(n)
+	}
+	return n
+}
+
+func (c intCodec) EncodeText(v interface{}) ([]byte, error) {
+	n, err := c.value(v)
+	if err != nil {
+		return nil, err
+	}
+	return strconv.AppendInt(nil, n, 10), nil
+}
+
+func (c intCodec) EncodeBinary(v interface{}) ([]byte, error) {
+	n, err := c.value(v)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, c.size)
+	switch c.size {
+	case 2:
+		binary.BigEndian.PutUint16(buf, uint16(n))
+	case 4:
+		binary.BigEndian.PutUint32(buf, uint32(n))
+	default:
+		binary.BigEndian.PutUint64(buf, uint64(n))
+	}
+	return buf, nil
+}
+
+func (c intCodec) DecodeText(src []byte) (interface{}, error) {
+	n, err := strconv.ParseInt(strings.TrimSpace(string(src)), 10, c.size*8)
+	if err != nil {
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+			return nil, pgerror.NewError(pgerror.CodeNumericValueOutOfRange, "value \"%s\" is out of range for type %s", src, c.name())
+		}
+		return nil, errInvalidText(c.name(), src)
+	}
+	return c.typed(n), nil
+}
+
+func (c intCodec) DecodeBinary(src []byte) (interface{}, error) {
+	if len(src) != c.size {
+		return nil, errInvalidBinary(c.name())
+	}
+	switch c.size {
+	case 2:
+		return int16(binary.BigEndian.Uint16(src)), nil
+	case 4:
+		return int32(binary.BigEndian.Uint32(src)), nil
+	}
+	return int64(binary.BigEndian.Uint64(src)), nil
+}
+
+// floatCodec handles float4 and float8 which decode to float32 and float64
+type floatCodec struct {
+	size int
+}
+
+func (c floatCodec) name() string {
+	if c.size == 4 {
+		return "real"
+	}
+	return "double precision"
+}
+
+func (c floatCodec) value(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case int16:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case string:
+		f, err := c.DecodeText([]byte(v))
+		if err != nil {
+			return 0, err
+		}
+		return c.value(f)
+	}
+	return 0, errCannotEncode(c.name(), v)
+}
+
+func (c floatCodec) EncodeText(v interface{}) ([]byte, error) {
+	f, err := c.value(v)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case math.IsNaN(f):
+		return []byte("NaN"), nil
+	case math.IsInf(f, 1):
+		return []byte("Infinity"), nil
+	case math.IsInf(f, -1):
+		return []byte("-Infinity"), nil
+	}
+	return strconv.AppendFloat(nil, f, 'g', -1, c.size*8), nil
+}
+
+func (c floatCodec) EncodeBinary(v interface{}) ([]byte, error) {
+	f, err := c.value(v)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, c.size)
+	if c.size == 4 {
+		binary.BigEndian.PutUint32(buf, math.Float32bits(float32(f)))
+	} else {
+		binary.BigEndian.PutUint64(buf, math.Float64bits(f))
+	}
+	return buf, nil
+}
+
+func (c floatCodec) DecodeText(src []byte) (interface{}, error) {
+	s := strings.TrimSpace(string(src))
+	var f float64
+	switch strings.ToLower(s) {
+	case "nan":
+		f = math.NaN()
+	case "infinity", "inf", "+infinity", "+inf":
+		f = math.Inf(1)
+	case "-infinity", "-inf":
+		f = math.Inf(-1)
+	default:
+		var err error
+		f, err = strconv.ParseFloat(s, c.size*8)
+		if err != nil {
+			return nil, errInvalidText(c.name(), src)
+		}
+	}
+	if c.size == 4 {
+		return float32(f), nil
+	}
+	return f, nil
+}
+
+func (c floatCodec) DecodeBinary(src []byte) (interface{}, error) {
+	if len(src) != c.size {
+		return nil, errInvalidBinary(c.name())
+	}
+	if c.size == 4 {
+		return math.Float32frombits(binary.BigEndian.Uint32(src)), nil
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(src)), nil
+}
+
+// textCodec handles text, varchar, bpchar and name which decode to string.
+// Their binary format is the same as the text format.
+type textCodec struct{}
+
+func (textCodec) EncodeText(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case string:
+		return []byte(v), nil
+	case fmt.Stringer:
+		return []byte(v.String()), nil
+	}
+	return encodeValue(v, 0)
+}
+
+func (c textCodec) EncodeBinary(v interface{}) ([]byte, error) { return c.EncodeText(v) }
+func (textCodec) DecodeText(src []byte) (interface{}, error)   { return string(src), nil }
+func (textCodec) DecodeBinary(src []byte) (interface{}, error) { return string(src), nil }
+
+// byteaCodec decodes to []byte, the text format is hex encoded
+type byteaCodec struct{}
+
+func (byteaCodec) value(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, errCannotEncode("bytea", v)
+}
+
+func (c byteaCodec) EncodeText(v interface{}) ([]byte, error) {
+	b, err := c.value(v)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 2+hex.EncodedLen(len(b)))
+	buf[0], buf[1] = '\\', 'x'
+	hex.Encode(buf[2:], b)
+	return buf, nil
+}
+
+func (c byteaCodec) EncodeBinary(v interface{}) ([]byte, error) { return c.value(v) }
+
+func (byteaCodec) DecodeText(src []byte) (interface{}, error) {
+	if len(src) >= 2 && src[0] == '\\' && src[1] == 'x' {
+		buf := make([]byte, hex.DecodedLen(len(src)-2))
+		if _, err := hex.Decode(buf, src[2:]); err != nil {
+			return nil, errInvalidText("bytea", src)
+		}
+		return buf, nil
+	}
+
+	// the escape format, backslashes start an octal escape
+	buf := make([]byte, 0, len(src))
+	for i := 0; i < len(src); i++ {
+		if src[i] != '\\' {
+			buf = append(buf, src[i])
+			continue
+		}
+		switch {
+		case i+1 < len(src) && src[i+1] == '\\':
+			buf = append(buf, '\\')
+			i++
+		case i+3 < len(src) && isOctal(src[i+1]) && isOctal(src[i+2]) && isOctal(src[i+3]):
+			buf = append(buf, (src[i+1]-'0')<<6|(src[i+2]-'0')<<3|(src[i+3]-'0'))
+			i += 3
+		default:
+			return nil, errInvalidText("bytea", src)
+		}
+	}
+	return buf, nil
+}
+
+func isOctal(c byte) bool { return '0' <= c && c <= '7' }
+
+func (byteaCodec) DecodeBinary(src []byte) (interface{}, error) {
+	return append([]byte{}, src...), nil
+}
+
+// jsonCodec handles json and jsonb which decode to string. Encoding also
+// accepts []byte and any value which can be marshaled.
+type jsonCodec struct {
+	// binary is set for jsonb whose binary format has a version prefix
+	binary bool
+}
+
+// jsonbVersion is the version of the jsonb binary format
+const jsonbVersion = 1
+
+func (c jsonCodec) name() string {
+	if c.binary {
+		return "jsonb"
+	}
+	return "json"
+}
+
+func (c jsonCodec) EncodeText(v interface{}) ([]byte, error) {
+	var buf []byte
+	switch v := v.(type) {
+	case string:
+		buf = []byte(v)
+	case []byte:
+		buf = v
+	case json.RawMessage:
+		buf = v
+	default:
+		var err error
+		if buf, err = json.Marshal(v); err != nil {
+			return nil, errCannotEncode(c.name(), v)
+		}
+	}
+	if !json.Valid(buf) {
+		return nil, errInvalidText(c.name(), buf)
+	}
+	return buf, nil
+}
+
+func (c jsonCodec) EncodeBinary(v interface{}) ([]byte, error) {
+	buf, err := c.EncodeText(v)
+	if err != nil || !c.binary {
+		return buf, err
+	}
+	return append([]byte{jsonbVersion}, buf...), nil
+}
+
+func (c jsonCodec) DecodeText(src []byte) (interface{}, error) {
+	if !json.Valid(src) {
+		return nil, errInvalidText(c.name(), src)
+	}
+	return string(src), nil
+}
+
+func (c jsonCodec) DecodeBinary(src []byte) (interface{}, error) {
+	if c.binary {
+		if len(src) == 0 || src[0] != jsonbVersion {
+			return nil, pgerror.NewError(pgerror.CodeInvalidBinaryRepresentation, "unsupported jsonb version number")
+		}
+		src = src[1:]
+	}
+	return c.DecodeText(src)
+}
+
+// postgresEpoch is the zero point of the binary date and time formats
+var postgresEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const microsPerDay = 24 * 60 * 60 * 1000000
+
+// dateCodec decodes to a time.Time at midnight UTC
+type dateCodec struct{}
+
+func (dateCodec) value(v interface{}) (time.Time, error) {
+	switch v := v.(type) {
+	case time.Time:
+		y, m, d := v.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC), nil
+	case string:
+		t, err := dateCodec{}.DecodeText([]byte(v))
+		if err != nil {
+			return time.Time{}, err
+		}
+		return t.(time.Time), nil
+	}
+	return time.Time{}, errCannotEncode("date", v)
+}
+
+func (c dateCodec) EncodeText(v interface{}) ([]byte, error) {
+	t, err := c.value(v)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(t.Format("2006-01-02")), nil
+}
+
+func (c dateCodec) EncodeBinary(v interface{}) ([]byte, error) {
+	t, err := c.value(v)
+	if err != nil {
+		return nil, err
+	}
+	days := t.Sub(postgresEpoch).Hours() / 24
+	if t.Before(postgresEpoch) && days != math.Trunc(days) {
+		days--
+	}
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, uint32(int32(days)))
+	return buf, nil
+}
+
+func (dateCodec) DecodeText(src []byte) (interface{}, error) {
+	t, err := time.Parse("2006-01-02", strings.TrimSpace(string(src)))
+	if err != nil {
+		return nil, errInvalidText("date", src)
+	}
+	return t, nil
+}
+
+func (dateCodec) DecodeBinary(src []byte) (interface{}, error) {
+	if len(src) != 4 {
+		return nil, errInvalidBinary("date")
+	}
+	days := int32(binary.BigEndian.Uint32(src))
+	if days == math.MaxInt32 || days == math.MinInt32 {
+		return nil, pgerror.NewError(pgerror.CodeFeatureNotSupported, "infinite dates are not supported")
+	}
+	return postgresEpoch.AddDate(0, 0, int(days)), nil
+}
+
+// timestampCodec handles timestamp and timestamptz which decode to
+// time.Time in UTC. The wall clock of a value is used for timestamp.
+type timestampCodec struct {
+	tz bool
+}
+
+func (c timestampCodec) name() string {
+	if c.tz {
+		return "timestamp with time zone"
+	}
+	return "timestamp without time zone"
+}
+
+func (c timestampCodec) value(v interface{}) (time.Time, error) {
+	switch v := v.(type) {
+	case time.Time:
+		if c.tz {
+			return v.UTC(), nil
+		}
+		y, m, d := v.Date()
+		return time.Date(y, m, d, v.Hour(), v.Minute(), v.Second(), v.Nanosecond(), time.UTC), nil
+	case string:
+		t, err := c.DecodeText([]byte(v))
+		if err != nil {
+			return time.Time{}, err
+		}
+		return t.(time.Time), nil
+	}
+	return time.Time{}, errCannotEncode(c.name(), v)
+}
+
+func (c timestampCodec) EncodeText(v interface{}) ([]byte, error) {
+	t, err := c.value(v)
+	if err != nil {
+		return nil, err
+	}
+	s := t.Format("2006-01-02 15:04:05.999999")
+	if c.tz {
+		// the server reports timestamps in UTC
+		s += "+00"
+	}
+	return []byte(s), nil
+}
+
+func (c timestampCodec) EncodeBinary(v interface{}) ([]byte, error) {
+	t, err := c.value(v)
+	if err != nil {
+		return nil, err
+	}
+	micros := t.Unix()*1000000 + int64(t.Nanosecond())/1000 - postgresEpoch.Unix()*1000000
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(micros))
+	return buf, nil
+}
+
+// timestampLayouts are the accepted input formats, without a zone the
+// time is in UTC
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999Z07:00:00",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func (c timestampCodec) DecodeText(src []byte) (interface{}, error) {
+	s := strings.TrimSpace(string(src))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, " UTC"), " GMT")
+	for _, layout := range timestampLayouts {
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			continue
+		}
+		if !c.tz {
+			// the zone of the input is ignored like postgres does
+			y, m, d := t.Date()
+			t = time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+		}
+		return t.UTC(), nil
+	}
+	return nil, errInvalidText(c.name(), src)
+}
+
+func (c timestampCodec) DecodeBinary(src []byte) (interface{}, error) {
+	if len(src) != 8 {
+		return nil, errInvalidBinary(c.name())
+	}
+	micros := int64(binary.BigEndian.Uint64(src))
+	if micros == math.MaxInt64 || micros == math.MinInt64 {
+		return nil, pgerror.NewError(pgerror.CodeFeatureNotSupported, "infinite timestamps are not supported")
+	}
+	return postgresEpoch.Add(time.Duration(micros) * time.Microsecond), nil
+}
+
+// Interval is the Go representation of the interval type. Months and days
+// are kept apart from the time as their length varies.
+type Interval struct {
+	Months       int32
+	Days         int32
+	Microseconds int64
+}
+
+// String formats the interval like postgres with IntervalStyle postgres
+func (iv Interval) String() string {
+	var sb strings.Builder
+	before, empty := false, true
+	part := func(value int64, unit string) {
+		if value == 0 {
+			return
+		}
+		if !empty {
+			sb.WriteByte(' ')
+		}
+		if before && value > 0 {
+			sb.WriteByte('+')
+		}
+		fmt.Fprintf(&sb, "%d %s", value, unit)
+		if value != 1 {
+			sb.WriteByte('s')
+		}
+		before, empty = value < 0, false
+	}
+	part(int64(iv.Months/12), "year")
+	part(int64(iv.Months%12), "mon")
+	part(int64(iv.Days), "day")
+
+	if empty || iv.Microseconds != 0 {
+		if !empty {
+			sb.WriteByte(' ')
+		}
+		micros := iv.Microseconds
+		switch {
+		case micros < 0:
+			sb.WriteByte('-')
+			micros = -micros
+		case before:
+			sb.WriteByte('+')
+		}
+		secs := micros / 1000000
+		fmt.Fprintf(&sb, "%02d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+		if frac := micros % 1000000; frac != 0 {
+			sb.WriteString(strings.TrimRight(fmt.Sprintf(".%06d", frac), "0"))
+		}
+	}
+	return sb.String()
+}
+
+// intervalCodec decodes to Interval, encoding also accepts time.Duration
+type intervalCodec struct{}
+
+func (intervalCodec) value(v interface{}) (Interval, error) {
+	switch v := v.(type) {
+	case Interval:
+		return v, nil
+	case time.Duration:
+		return Interval{Microseconds: v.Microseconds()}, nil
+	case string:
+		iv, err := intervalCodec{}.DecodeText([]byte(v))
+		if err != nil {
+			return Interval{}, err
+		}
+		return iv.(Interval), nil
+	}
+	return Interval{}, errCannotEncode("interval", v)
+}
+
+func (c intervalCodec) EncodeText(v interface{}) ([]byte, error) {
+	iv, err := c.value(v)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(iv.String()), nil
+}
+
+func (c intervalCodec) EncodeBinary(v interface{}) ([]byte, error) {
+	iv, err := c.value(v)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf, uint64(iv.Microseconds))
+	binary.BigEndian.PutUint32(buf[8:], uint32(iv.Days))
+	binary.BigEndian.PutUint32(buf[12:], uint32(iv.Months))
+	return buf, nil
+}
+
+// DecodeText parses the postgres output format such as
+// "1 year 2 mons -3 days +04:05:06.5" and the units spelled out.
+func (intervalCodec) DecodeText(src []byte) (interface{}, error) {
+	fields := strings.Fields(strings.ToLower(string(src)))
+	if len(fields) > 0 && fields[0] == "@" {
+		fields = fields[1:]
+	}
+	ago := len(fields) > 0 && fields[len(fields)-1] == "ago"
+	if ago {
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) == 0 {
+		return nil, errInvalidText("interval", src)
+	}
+
+	var iv Interval
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if strings.Contains(f, ":") {
+			micros, ok := parseIntervalTime(f)
+			if !ok {
+				return nil, errInvalidText("interval", src)
+			}
+			iv.Microseconds += micros
+			continue
+		}
+		n, err := strconv.ParseFloat(f, 64)
+		if err != nil || i+1 == len(fields) {
+			return nil, errInvalidText("interval", src)
+		}
+		i++
+		unit := fields[i]
+		if unit != "ms" && unit != "us" {
+			unit = strings.TrimSuffix(unit, "s")
+		}
+		switch unit {
+		case "year", "yr", "y":
+			iv.Months += int32(n * 12)
+		case "mon", "month":
+			iv.Months += int32(n)
+		case "week", "w":
+			iv.Days += int32(n * 7)
+		case "day", "d":
+			iv.Days += int32(n)
+		case "hour", "hr", "h":
+			iv.Microseconds += int64(n * 3600 * 1000000)
+		case "minute", "min", "m":
+			iv.Microseconds += int64(n * 60 * 1000000)
+		case "second", "sec":
+			iv.Microseconds += int64(math.Round(n * 1000000))
+		case "millisecond", "ms":
+			iv.Microseconds += int64(math.Round(n * 1000))
+		case "microsecond", "us":
+			iv.Microseconds += int64(n)
+		default:
+			return nil, errInvalidText("interval", src)
+		}
+	}
+	if ago {
+		iv = Interval{Months: -iv.Months, Days: -iv.Days, Microseconds: -iv.Microseconds}
+	}
+	return iv, nil
+}
+
+// parseIntervalTime parses [+-]HH:MM[:SS[.ffffff]] to microseconds
+func parseIntervalTime(s string) (int64, bool) {
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimLeft(s, "+-")
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+	h, err1 := strconv.ParseInt(parts[0], 10, 64)
+	m, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, false
+	}
+	var secs float64
+	if len(parts) == 3 {
+		var err error
+		if secs, err = strconv.ParseFloat(parts[2], 64); err != nil {
+			return 0, false
+		}
+	}
+	micros := (h*3600+m*60)*1000000 + int64(math.Round(secs*1000000))
+	if neg {
+		micros = -micros
+	}
+	return micros, true
+}
+
+func (intervalCodec) DecodeBinary(src []byte) (interface{}, error) {
+	if len(src) != 16 {
+		return nil, errInvalidBinary("interval")
+	}
+	return Interval{
+		Microseconds: int64(binary.BigEndian.Uint64(src)),
+		Days:         int32(binary.BigEndian.Uint32(src[8:])),
+		Months:       int32(binary.BigEndian.Uint32(src[12:])),
+	}, nil
+}
+
+// UUID is the Go representation of the uuid type
+type UUID [16]byte
+
+// String formats the UUID in its canonical lower case form
+func (u UUID) String() string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+// ParseUUID accepts the canonical form with or without hyphens and braces
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}")
+	s = strings.ReplaceAll(s, "-", "")
+	if len(s) != 32 {
+		return u, fmt.Errorf("invalid uuid: %q", s)
+	}
+	if _, err := hex.Decode(u[:], []byte(s)); err != nil {
+		return u, fmt.Errorf("invalid uuid: %q", s)
+	}
+	return u, nil
+}
+
+// uuidCodec decodes to UUID
+type uuidCodec struct{}
+
+func (uuidCodec) value(v interface{}) (UUID, error) {
+	switch v := v.(type) {
+	case UUID:
+		return v, nil
+	case [16]byte:
+		return UUID(v), nil
+	case string:
+		u, err := ParseUUID(v)
+		if err != nil {
+			return u, errInvalidText("uuid", []byte(v))
+		}
+		return u, nil
+	}
+	return UUID{}, errCannotEncode("uuid", v)
+}
+
+func (c uuidCodec) EncodeText(v interface{}) ([]byte, error) {
+	u, err := c.value(v)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(u.String()), nil
+}
+
+func (c uuidCodec) EncodeBinary(v interface{}) ([]byte, error) {
+	u, err := c.value(v)
+	if err != nil {
+		return nil, err
+	}
+	return u[:], nil
+}
+
+func (uuidCodec) DecodeText(src []byte) (interface{}, error) {
+	u, err := ParseUUID(strings.TrimSpace(string(src)))
+	if err != nil {
+		return nil, errInvalidText("uuid", src)
+	}
+	return u, nil
+}
+
+func (uuidCodec) DecodeBinary(src []byte) (interface{}, error) {
+	if len(src) != 16 {
+		return nil, errInvalidBinary("uuid")
+	}
+	var u UUID
+	copy(u[:], src)
+	return u, nil
+}
+
+// encodeValue converts a value returned by a handler to its wire format.
+// Values of type []byte are assumed to be already encoded.
+func encodeValue(v interface{}, format int16) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return v, nil
+	case string:
+		// the binary representation of text is the same as its text format
+		return []byte(v), nil
+	}
+	if format != 0 {
+		return nil, fmt.Errorf("binary format is not supported for %T", v)
+	}
+
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return []byte("t"), nil
+		}
+		return []byte("f"), nil
+	case int:
+		return strconv.AppendInt(nil, int64(v), 10), nil
+	case int16:
+		return strconv.AppendInt(nil, int64(v), 10), nil
+	case int32:
+		return strconv.AppendInt(nil, int64(v), 10), nil
+	case int64:
+		return strconv.AppendInt(nil, v, 10), nil
+	case float32:
+		return strconv.AppendFloat(nil, float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.AppendFloat(nil, v, 'g', -1, 64), nil
+	case time.Time:
+		return []byte(v.Format("2006-01-02 15:04:05.999999Z07:00")), nil
+	case fmt.Stringer:
+		return []byte(v.String()), nil
+	default:
+		return []byte(fmt.Sprint(v)), nil
+	}
+}
@@ -0,0 +1,184 @@
+package pgtype
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypeRegistry_RoundTrip(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 890000000, time.UTC)
+	u, err := ParseUUID("a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11")
+	require.NoError(t, err)
+
+	tests := []struct {
+		oid   uint32
+		value interface{}
+		text  string
+	}{
+		{BoolOID, true, "t"},
+		{Int2OID, int16(-12), "-12"},
+		{Int4OID, int32(1 << 20), "1048576"},
+		{Int8OID, int64(math.MaxInt64), "9223372036854775807"},
+		{Float4OID, float32(1.5), "1.5"},
+		{Float8OID, 0.1, "0.1"},
+		{NumericOID, "12345.6789", "12345.6789"},
+		{NumericOID, "-0.00012", "-0.00012"},
+		{NumericOID, "100000000", "100000000"},
+		{NumericOID, "NaN", "NaN"},
+		{TextOID, "hello", "hello"},
+		{VarcharOID, "", ""},
+		{ByteaOID, []byte{0xde, 0xad, 0xbe, 0xef}, `\xdeadbeef`},
+		{DateOID, time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC), "1999-12-31"},
+		{TimestampOID, ts, "2021-03-04 05:06:07.89"},
+		{TimestamptzOID, ts, "2021-03-04 05:06:07.89+00"},
+		{IntervalOID, Interval{Months: 14, Days: -3, Microseconds: 3723500000}, "1 year 2 mons -3 days +01:02:03.5"},
+		{IntervalOID, Interval{}, "00:00:00"},
+		{UUIDOID, u, "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"},
+		{JSONOID, `{"a": [1, 2]}`, `{"a": [1, 2]}`},
+		{JSONBOID, `{"a":1}`, `{"a":1}`},
+		{Int4ArrayOID, []interface{}{int32(1), nil, int32(3)}, "{1,NULL,3}"},
+		{TextArrayOID, []interface{}{"a b", `q"`, "NULL", ""}, `{"a b","q\"","NULL",""}`},
+		{TextArrayOID, []interface{}{}, "{}"},
+	}
+	for _, tt := range tests {
+		typ, ok := DefaultTypes.Lookup(tt.oid)
+		require.True(t, ok, tt.oid)
+
+		text, err := DefaultTypes.Encode(tt.oid, tt.value, 0)
+		require.NoError(t, err, typ.Name)
+		assert.Equal(t, tt.text, string(text), typ.Name)
+		got, err := DefaultTypes.Decode(tt.oid, text, 0)
+		require.NoError(t, err, typ.Name)
+		assert.Equal(t, tt.value, got, typ.Name)
+
+		bin, err := DefaultTypes.Encode(tt.oid, tt.value, 1)
+		require.NoError(t, err, typ.Name)
+		if typ.Size > 0 {
+			assert.Len(t, bin, int(typ.Size), typ.Name)
+		}
+		got, err = DefaultTypes.Decode(tt.oid, bin, 1)
+		require.NoError(t, err, typ.Name)
+		assert.Equal(t, tt.value, got, typ.Name)
+	}
+}
+
+func TestTypeRegistry_BinaryLayout(t *testing.T) {
+	buf, err := DefaultTypes.Encode(DateOID, time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC), 1)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 1}, buf)
+
+	buf, err = DefaultTypes.Encode(DateOID, time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC), 1)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 0xff, 0xff, 0xff}, buf)
+
+	// 12345.678 is stored as the base 10000 digits 1 2345 6780
+	buf, err = DefaultTypes.Encode(NumericOID, "12345.678", 1)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 3, 0, 1, 0, 0, 0, 3, 0, 1, 0x09, 0x29, 0x1a, 0x7c}, buf)
+
+	buf, err = DefaultTypes.Encode(JSONBOID, `{}`, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, '{', '}'}, buf)
+
+	buf, err = DefaultTypes.Encode(Int8ArrayOID, []int64{7}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0, 0, 0, 1, // dimensions
+		0, 0, 0, 0, // no nulls
+		0, 0, 0, 20, // element type
+		0, 0, 0, 1, 0, 0, 0, 1, // length and lower bound
+		0, 0, 0, 8, 0, 0, 0, 0, 0, 0, 0, 7,
+	}, buf)
+}
+
+func TestTypeRegistry_Conversions(t *testing.T) {
+	buf, err := DefaultTypes.Encode(Int4OID, 42, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 42}, buf)
+
+	buf, err = DefaultTypes.Encode(NumericOID, 1.25, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "1.25", string(buf))
+
+	buf, err = DefaultTypes.Encode(IntervalOID, 90*time.Minute, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "01:30:00", string(buf))
+
+	buf, err = DefaultTypes.Encode(Int2ArrayOID, []int{1, 2}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "{1,2}", string(buf))
+
+	// values of unknown types fall back to their default representation
+	buf, err = DefaultTypes.Encode(0, 42, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "42", string(buf))
+
+	got, err := DefaultTypes.Decode(IntervalOID, []byte("2 days 3 hours ago"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, Interval{Days: -2, Microseconds: -3 * 3600 * 1000000}, got)
+
+	got, err = DefaultTypes.Decode(NumericOID, []byte("1.5e3"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, "1500", got)
+
+	got, err = DefaultTypes.Decode(TimestamptzOID, []byte("2021-03-04 05:06:07+02"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 3, 4, 3, 6, 7, 0, time.UTC), got)
+}
+
+func TestTypeRegistry_Errors(t *testing.T) {
+	tests := []struct {
+		oid    uint32
+		src    string
+		format int16
+		code   string
+	}{
+		{Int4OID, "abc", 0, pgerror.CodeInvalidTextRepresentation},
+		{Int2OID, "40000", 0, pgerror.CodeNumericValueOutOfRange},
+		{Int4OID, "\x00\x01", 1, pgerror.CodeInvalidBinaryRepresentation},
+		{BoolOID, "maybe", 0, pgerror.CodeInvalidTextRepresentation},
+		{UUIDOID, "not-a-uuid", 0, pgerror.CodeInvalidTextRepresentation},
+		{JSONOID, "{", 0, pgerror.CodeInvalidTextRepresentation},
+		{JSONBOID, "\x02{}", 1, pgerror.CodeInvalidBinaryRepresentation},
+		{Int4ArrayOID, "{1,{2}}", 0, pgerror.CodeFeatureNotSupported},
+		{Int4ArrayOID, "{1,", 0, pgerror.CodeInvalidTextRepresentation},
+	}
+	for _, tt := range tests {
+		_, err := DefaultTypes.Decode(tt.oid, []byte(tt.src), tt.format)
+		var pgErr *pgerror.Error
+		require.ErrorAs(t, err, &pgErr, tt.src)
+		assert.Equal(t, tt.code, pgErr.Code, tt.src)
+	}
+
+	_, err := DefaultTypes.Encode(Int2OID, 1<<20, 0)
+	assert.Error(t, err)
+	_, err = DefaultTypes.Encode(UUIDOID, 42, 0)
+	assert.Error(t, err)
+}
+
+func TestEncodeValue(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  []byte
+	}{
+		{nil, nil},
+		{"abc", []byte("abc")},
+		{[]byte{1, 2}, []byte{1, 2}},
+		{true, []byte("t")},
+		{int32(-7), []byte("-7")},
+		{1.5, []byte("1.5")},
+	}
+	for _, tt := range tests {
+		got, err := encodeValue(tt.value, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, got)
+	}
+
+	_, err := encodeValue(42, 1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,626 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package planner
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/patrickglass/dsql/sql/executor"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// aggregation is the grouping of the rows of a query. The expressions
+// computed for the groups are compiled against a scope referring to it,
+// its rows hold the keys, the aggregate calls and the grouping set mask.
+type aggregation struct {
+	// input is the scope of the rows grouped
+	input *Scope
+	keys  []executor.Expr
+	sets  [][]int
+	// columns maps the positions of the input columns grouped by to their
+	// keys and exprKeys the other expressions grouped by
+	columns  map[int]int
+	exprKeys map[string]int
+	calls    []*executor.AggregateCall
+	// callIndex maps the calls of the query to their aggregate calls,
+	// equal calls are computed once
+	callIndex map[*parser.FuncCall]int
+}
+
+// keyColumn returns the value of key k in the rows of the groups
+func (a *aggregation) keyColumn(k int) executor.Expr {
+	return executor.NewColumn(k, a.keys[k].String(), a.keys[k].Type())
+}
+
+// callColumn returns the value of call i in the rows of the groups
+func (a *aggregation) callColumn(i int) executor.Expr {
+	return executor.NewColumn(len(a.keys)+i, a.calls[i].String(), a.calls[i].Func.Result)
+}
+
+// maskColumn returns the grouping set mask in the rows of the groups
+func (a *aggregation) maskColumn() executor.Expr {
+	return executor.NewColumn(len(a.keys)+len(a.calls), "grouping", types.Int8)
+}
+
+// aggregate plans the grouping of the rows of the input of a query with
+// GROUP BY, HAVING, aggregates or GROUPING in its select list or ORDER BY
+// and returns the query computing the groups, nil for other queries
+func (c *Compiler) aggregate(stmt *parser.SelectStmt, in *input) (*query, error) {
+	var calls []*parser.FuncCall
+	var err error
+	nodes := []parser.Expr{stmt.Having}
+	for _, target := range stmt.Targets {
+		nodes = append(nodes, target.Expr)
+	}
+	for _, item := range stmt.OrderBy {
+		nodes = append(nodes, item.Expr)
+	}
+	nodes = append(nodes, stmt.DistinctOn...)
+	for _, node := range nodes {
+		if calls, err = c.aggregateCalls(node, calls); err != nil {
+			return nil, err
+		}
+	}
+	if len(calls) == 0 && len(stmt.GroupBy) == 0 && stmt.Having == nil {
+		return nil, nil
+	}
+
+	agg := &aggregation{
+		input:     in.scope,
+		columns:   make(map[int]int),
+		exprKeys:  make(map[string]int),
+		callIndex: make(map[*parser.FuncCall]int),
+	}
+	sets, err := c.groupingSets(stmt, in.scope)
+	if err != nil {
+		return nil, err
+	}
+	for _, set := range sets {
+		var keys []int
+		for _, node := range set {
+			k, err := c.groupKey(agg, node)
+			if err != nil {
+				return nil, err
+			}
+			if !containsInt(keys, k) {
+				keys = append(keys, k)
+			}
+		}
+		agg.sets = append(agg.sets, keys)
+	}
+	sorted := orderKeys(agg, in)
+	for _, call := range calls {
+		if strings.TrimPrefix(call.Name, "pg_catalog.") == "grouping" {
+			continue
+		}
+		compiled, err := c.aggregateCall(call, in.scope)
+		if err != nil {
+			return nil, err
+		}
+		i := len(agg.calls)
+		for j, existing := range agg.calls {
+			if existing.String() == compiled.String() {
+				i = j
+				break
+			}
+		}
+		if i == len(agg.calls) {
+			agg.calls = append(agg.calls, compiled)
+		}
+		agg.callIndex[call] = i
+	}
+
+	scope := &Scope{Outer: in.scope.Outer, agg: agg, correlated: in.scope.correlated}
+	for k, key := range agg.keys {
+		col := ScopeColumn{Name: key.String(), Type: key.Type(), Hidden: true}
+		for i, j := range agg.columns {
+			if j == k {
+				col = in.scope.Columns[i]
+			}
+		}
+		scope.Columns = append(scope.Columns, col)
+	}
+	for _, call := range agg.calls {
+		scope.Columns = append(scope.Columns, ScopeColumn{Name: call.String(), Type: call.Func.Result, Hidden: true})
+	}
+	scope.Columns = append(scope.Columns, ScopeColumn{Name: "grouping", Type: types.Int8, Hidden: true})
+
+	q := &query{scope: scope}
+	switch {
+	case sorted:
+		q.root = executor.NewSortAggregate(in.root, agg.keys, agg.sets, agg.calls)
+	case len(agg.sets) > 1 && chained(agg.sets):
+		// rollups sort their input to compute the subtotals as it goes
+		keys := make([]executor.SortKey, len(agg.keys))
+		for i, key := range agg.keys {
+			keys[i] = executor.SortKey{Expr: key}
+		}
+		q.root = executor.NewSortAggregate(executor.NewSort(in.root, keys), agg.keys, agg.sets, agg.calls)
+	default:
+		q.root = executor.NewHashAggregate(in.root, agg.keys, agg.sets, agg.calls)
+	}
+	if stmt.Having != nil {
+		cond, err := c.Condition(stmt.Having, scope, "HAVING")
+		if err != nil {
+			return nil, err
+		}
+		q.root = executor.NewFilter(q.root, cond)
+	}
+	return q, nil
+}
+
+// aggregateCalls appends the calls of aggregates and GROUPING in the
+// expression outside of subqueries to calls
+func (c *Compiler) aggregateCalls(e parser.Expr, calls []*parser.FuncCall) ([]*parser.FuncCall, error) {
+	var err error
+	walk(e, func(e parser.Expr) bool {
+		call, ok := e.(*parser.FuncCall)
+		if !ok || err != nil {
+			return err == nil
+		}
+		name := strings.TrimPrefix(call.Name, "pg_catalog.")
+		if name == "grouping" {
+			calls = append(calls, call)
+			return false
+		}
+		if !isAggregate(name) {
+			return true
+		}
+		calls = append(calls, call)
+		nested := call.Args
+		for _, item := range append(call.OrderBy, call.WithinGroup...) {
+			nested = append(nested, item.Expr)
+		}
+		for _, arg := range nested {
+			if inner := findAggregate(arg); inner != nil {
+				err = c.ErrorAt(inner, pgerror.CodeGroupingError, "aggregate function calls cannot be nested")
+			}
+		}
+		return false
+	})
+	return calls, err
+}
+
+// isAggregate reports whether the function with the name is an aggregate
+func isAggregate(name string) bool {
+	return executor.Aggregates(name) != nil
+}
+
+// findAggregate returns the first call of an aggregate in the expression
+// outside of subqueries, nil when there is none
+func findAggregate(e parser.Expr) *parser.FuncCall {
+	var found *parser.FuncCall
+	walk(e, func(e parser.Expr) bool {
+		if call, ok := e.(*parser.FuncCall); ok && found == nil && isAggregate(strings.TrimPrefix(call.Name, "pg_catalog.")) {
+			found = call
+		}
+		return found == nil
+	})
+	return found
+}
+
+// walk calls visit for the expression and, while visit returns true, for
+// the operands of each expression visited. Subqueries are not visited.
+func walk(e parser.Expr, visit func(parser.Expr) bool) {
+	if e == nil || !visit(e) {
+		return
+	}
+	var operands []parser.Expr
+	switch e := e.(type) {
+	case *parser.UnaryExpr:
+		operands = []parser.Expr{e.Expr}
+	case *parser.BinaryExpr:
+		operands = []parser.Expr{e.Left, e.Right}
+	case *parser.IsExpr:
+		operands = []parser.Expr{e.Expr, e.Right}
+	case *parser.LikeExpr:
+		operands = []parser.Expr{e.Expr, e.Pattern, e.Escape}
+	case *parser.BetweenExpr:
+		operands = []parser.Expr{e.Expr, e.Low, e.High}
+	case *parser.InExpr:
+		operands = append([]parser.Expr{e.Expr}, e.List...)
+	case *parser.QuantifiedExpr:
+		operands = []parser.Expr{e.Expr, e.Array}
+	case *parser.CaseExpr:
+		operands = []parser.Expr{e.Operand, e.Else}
+		for _, when := range e.Whens {
+			operands = append(operands, when.Cond, when.Result)
+		}
+	case *parser.CastExpr:
+		operands = []parser.Expr{e.Expr}
+	case *parser.FuncCall:
+		operands = append(append(operands, e.Args...), e.Filter)
+		for _, item := range append(e.OrderBy, e.WithinGroup...) {
+			operands = append(operands, item.Expr)
+		}
+	case *parser.ArrayExpr:
+		operands = e.Elems
+	case *parser.RowExpr:
+		operands = e.Items
+	case *parser.GroupingSet:
+		operands = e.Items
+	}
+	for _, operand := range operands {
+		walk(operand, visit)
+	}
+}
+
+// groupingSets expands GROUP BY into the lists of expressions of its
+// grouping sets, the sets of the items are combined with each other. A
+// query without GROUP BY has a single empty grouping set.
+func (c *Compiler) groupingSets(stmt *parser.SelectStmt, scope *Scope) ([][]parser.Expr, error) {
+	sets := [][]parser.Expr{nil}
+	for _, item := range stmt.GroupBy {
+		var itemSets [][]parser.Expr
+		switch item := item.(type) {
+		case *parser.GroupingSet:
+			itemSets = expandGroupingSet(item)
+		case *parser.RowExpr:
+			itemSets = [][]parser.Expr{item.Items}
+		default:
+			itemSets = [][]parser.Expr{{item}}
+		}
+		var combined [][]parser.Expr
+		for _, set := range sets {
+			for _, itemSet := range itemSets {
+				combined = append(combined, append(append([]parser.Expr(nil), set...), itemSet...))
+			}
+		}
+		sets = combined
+	}
+	for _, set := range sets {
+		for i, e := range set {
+			key, err := c.groupByTarget(e, stmt.Targets, scope)
+			if err != nil {
+				return nil, err
+			}
+			set[i] = key
+		}
+	}
+	return sets, nil
+}
+
+// expandGroupingSet returns the lists of expressions of the grouping sets
+// of ROLLUP, CUBE, GROUPING SETS or ()
+func expandGroupingSet(g *parser.GroupingSet) [][]parser.Expr {
+	// the items of ROLLUP and CUBE are expressions or lists of them
+	items := make([][]parser.Expr, len(g.Items))
+	for i, item := range g.Items {
+		if row, ok := item.(*parser.RowExpr); ok {
+			items[i] = row.Items
+		} else {
+			items[i] = []parser.Expr{item}
+		}
+	}
+	var sets [][]parser.Expr
+	switch g.Kind {
+	case parser.GroupingEmpty:
+		sets = [][]parser.Expr{nil}
+	case parser.GroupingRollup:
+		for n := len(items); n >= 0; n-- {
+			var set []parser.Expr
+			for _, item := range items[:n] {
+				set = append(set, item...)
+			}
+			sets = append(sets, set)
+		}
+	case parser.GroupingCube:
+		for mask := 1<<uint(len(items)) - 1; mask >= 0; mask-- {
+			var set []parser.Expr
+			for i, item := range items {
+				if mask&(1<<uint(len(items)-1-i)) != 0 {
+					set = append(set, item...)
+				}
+			}
+			sets = append(sets, set)
+		}
+	case parser.GroupingSets:
+		for i, item := range g.Items {
+			if nested, ok := item.(*parser.GroupingSet); ok {
+				sets = append(sets, expandGroupingSet(nested)...)
+			} else {
+				sets = append(sets, items[i])
+			}
+		}
+	}
+	return sets
+}
+
+// groupByTarget returns the expression a GROUP BY expression refers to.
+// Integer constants are positions in the select list and names which are
+// not columns of the input refer to result columns.
+func (c *Compiler) groupByTarget(e parser.Expr, targets []*parser.ResultTarget, scope *Scope) (parser.Expr, error) {
+	switch e := e.(type) {
+	case *parser.Literal:
+		if e.Kind != parser.LiteralInteger {
+			break
+		}
+		n, err := strconv.ParseInt(e.Value, 10, 64)
+		if err != nil || n < 1 || n > int64(len(targets)) {
+			return nil, c.ErrorAt(e, pgerror.CodeInvalidColumnReference, "GROUP BY position %s is not in select list", e.Value)
+		}
+		target := targets[n-1].Expr
+		if _, ok := target.(*parser.Star); ok {
+			return nil, c.ErrorAt(e, pgerror.CodeFeatureNotSupported, "GROUP BY position %s refers to *", e.Value)
+		}
+		return target, nil
+	case *parser.ColumnRef:
+		if e.Table != "" {
+			break
+		}
+		if i, _, err := c.lookup(scope, e); err != nil || i >= 0 {
+			break
+		}
+		for _, target := range targets {
+			name := target.Alias
+			if name == "" {
+				name = TargetName(target.Expr)
+			}
+			if _, ok := target.Expr.(*parser.Star); !ok && name == e.Name {
+				return target.Expr, nil
+			}
+		}
+	}
+	return e, nil
+}
+
+// groupKey returns the position of the key grouping by the expression,
+// adding it unless an equal key exists
+func (c *Compiler) groupKey(agg *aggregation, e parser.Expr) (int, error) {
+	if call := findAggregate(e); call != nil {
+		return 0, c.ErrorAt(call, pgerror.CodeGroupingError, "aggregate functions are not allowed in GROUP BY")
+	}
+	compiled, err := c.Expr(e, agg.input)
+	if err != nil {
+		return 0, err
+	}
+	if ref, ok := e.(*parser.ColumnRef); ok {
+		if _, depth, i, _ := c.resolve(agg.input, ref); depth == 0 {
+			if k, ok := agg.columns[i]; ok {
+				return k, nil
+			}
+			agg.columns[i] = len(agg.keys)
+			agg.keys = append(agg.keys, compiled)
+			return len(agg.keys) - 1, nil
+		}
+	}
+	if k, ok := agg.exprKeys[e.String()]; ok {
+		return k, nil
+	}
+	for k, key := range agg.keys {
+		if key.String() == compiled.String() && key.Type() == compiled.Type() {
+			agg.exprKeys[e.String()] = k
+			return k, nil
+		}
+	}
+	agg.exprKeys[e.String()] = len(agg.keys)
+	agg.keys = append(agg.keys, compiled)
+	return len(agg.keys) - 1, nil
+}
+
+// orderKeys orders the keys so that the grouping sets are prefixes of
+// them, following the order of the input when it is sorted by them. It
+// reports whether the input is sorted by the keys in that order.
+func orderKeys(agg *aggregation, in *input) bool {
+	if len(agg.keys) == 0 {
+		// the only group is that of all rows
+		return true
+	}
+	order := make([]int, 0, len(agg.keys))
+	sorted := len(in.ordering) >= len(agg.keys)
+	for i := 0; sorted && i < len(agg.keys); i++ {
+		k, ok := agg.columns[in.ordering[i]]
+		sorted = ok && !containsInt(order, k)
+		order = append(order, k)
+	}
+	if !sorted {
+		// the keys of the smaller sets first
+		sets := append([][]int(nil), agg.sets...)
+		sort.SliceStable(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+		order = order[:0]
+		for _, set := range sets {
+			for _, k := range set {
+				if !containsInt(order, k) {
+					order = append(order, k)
+				}
+			}
+		}
+	}
+
+	position := make([]int, len(agg.keys))
+	keys := make([]executor.Expr, len(agg.keys))
+	for i, k := range order {
+		position[k], keys[i] = i, agg.keys[k]
+	}
+	agg.keys = keys
+	for i, k := range agg.columns {
+		agg.columns[i] = position[k]
+	}
+	for e, k := range agg.exprKeys {
+		agg.exprKeys[e] = position[k]
+	}
+	for _, set := range agg.sets {
+		for i, k := range set {
+			set[i] = position[k]
+		}
+		sort.Ints(set)
+	}
+	// the sets from the longest to the shortest like sort aggregates take them
+	sort.SliceStable(agg.sets, func(i, j int) bool { return len(agg.sets[i]) > len(agg.sets[j]) })
+	return sorted && chained(agg.sets)
+}
+
+// chained reports whether each grouping set is a prefix of the keys of
+// the one before it
+func chained(sets [][]int) bool {
+	for _, set := range sets {
+		for i, k := range set {
+			if k != i {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func containsInt(list []int, n int) bool {
+	for _, m := range list {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
+
+// aggregateCall compiles a call of an aggregate function, its arguments
+// are evaluated for the rows of the input
+func (c *Compiler) aggregateCall(e *parser.FuncCall, scope *Scope) (*executor.AggregateCall, error) {
+	name := strings.TrimPrefix(e.Name, "pg_catalog.")
+	orderedSet := executor.Aggregates(name)[0].OrderedSet
+	switch {
+	case orderedSet && len(e.WithinGroup) == 0:
+		return nil, c.ErrorAt(e, pgerror.CodeWrongObjectType, "WITHIN GROUP is required for ordered-set aggregate %s", name)
+	case !orderedSet && len(e.WithinGroup) > 0:
+		return nil, c.ErrorAt(e, pgerror.CodeWrongObjectType, "%s is not an ordered-set aggregate, so it cannot have WITHIN GROUP", name)
+	case orderedSet && (e.Distinct || len(e.OrderBy) > 0):
+		return nil, c.ErrorAt(e, pgerror.CodeWrongObjectType, "ordered-set aggregate %s cannot have DISTINCT or ORDER BY in its arguments", name)
+	}
+	nodes := append([]parser.Expr(nil), e.Args...)
+	for _, item := range e.WithinGroup {
+		nodes = append(nodes, item.Expr)
+	}
+	args, err := c.exprs(nodes, scope)
+	if err != nil {
+		return nil, err
+	}
+	var f *executor.AggregateFunc
+	bestExact := -1
+	for _, overload := range executor.Aggregates(name) {
+		if exact, ok := matchArgs(&overload.Function, args); ok && exact > bestExact {
+			f, bestExact = overload, exact
+		}
+	}
+	if f == nil {
+		return nil, c.errNoFunction(e, name, args)
+	}
+	for i, arg := range args {
+		if t := paramType(&f.Function, i); t.OID != types.UnknownOID {
+			if args[i], err = c.convert(nodes[i], arg, t, types.CastImplicit); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	call := &executor.AggregateCall{Func: f, Args: args, Distinct: e.Distinct}
+	order := e.OrderBy
+	if orderedSet {
+		order = e.WithinGroup
+	}
+	for _, item := range order {
+		key := executor.SortKey{Desc: item.Desc, NullsFirst: item.Desc}
+		if item.NullsFirst != nil {
+			key.NullsFirst = *item.NullsFirst
+		}
+		if orderedSet {
+			// the values sorted are the converted argument
+			key.Expr = args[len(args)-1]
+		} else if key.Expr, err = c.Expr(item.Expr, scope); err != nil {
+			return nil, err
+		}
+		call.OrderBy = append(call.OrderBy, key)
+	}
+	if e.Filter != nil {
+		if call.Filter, err = c.Condition(e.Filter, scope, "FILTER"); err != nil {
+			return nil, err
+		}
+	}
+	return call, nil
+}
+
+// grouped compiles the parts of an expression computed for groups which
+// are keys, aggregate calls, GROUPING or columns, ok is false for the
+// other expressions whose operands are compiled in turn
+func (c *Compiler) grouped(e parser.Expr, scope *Scope) (compiled executor.Expr, ok bool, err error) {
+	agg := scope.agg
+	switch e := e.(type) {
+	case *parser.ColumnRef:
+		s, depth, i, err := c.resolve(agg.input, e)
+		if err != nil {
+			return nil, true, err
+		}
+		if depth > 0 {
+			scope.correlated = true
+			return executor.NewOuterColumn(depth, i, e.String(), s.Columns[i].Type), true, nil
+		}
+		compiled, err := c.groupedColumn(e, agg, i)
+		return compiled, true, err
+	case *parser.FuncCall:
+		if i, ok := agg.callIndex[e]; ok {
+			return agg.callColumn(i), true, nil
+		}
+		if strings.TrimPrefix(e.Name, "pg_catalog.") == "grouping" {
+			compiled, err := c.grouping(e, agg)
+			return compiled, true, err
+		}
+	}
+	if k, ok := agg.exprKeys[e.String()]; ok {
+		return agg.keyColumn(k), true, nil
+	}
+	return nil, false, nil
+}
+
+// groupedColumn returns the value of column i of the input for the
+// groups, it must be grouped by
+func (c *Compiler) groupedColumn(node parser.Node, agg *aggregation, i int) (executor.Expr, error) {
+	if k, ok := agg.columns[i]; ok {
+		return agg.keyColumn(k), nil
+	}
+	return nil, c.ErrorAt(node, pgerror.CodeGroupingError, "column \"%s\" must appear in the GROUP BY clause or be used in an aggregate function", agg.input.Columns[i].qualifiedName())
+}
+
+// grouping compiles GROUPING, whose arguments must be keys
+func (c *Compiler) grouping(e *parser.FuncCall, agg *aggregation) (executor.Expr, error) {
+	if err := c.checkScalarCall(e, "grouping"); err != nil {
+		return nil, err
+	}
+	if len(e.Args) == 0 || len(e.Args) > 31 {
+		return nil, c.ErrorAt(e, pgerror.CodeSyntaxError, "GROUPING must have between 1 and 31 arguments")
+	}
+	args := make([]executor.Expr, len(e.Args))
+	keys := make([]int, len(e.Args))
+	for i, arg := range e.Args {
+		k, ok := agg.exprKeys[arg.String()]
+		if ref, isRef := arg.(*parser.ColumnRef); isRef {
+			_, depth, j, err := c.resolve(agg.input, ref)
+			if err != nil {
+				return nil, err
+			}
+			k, ok = agg.columns[j]
+			ok = ok && depth == 0
+		}
+		if !ok {
+			return nil, c.ErrorAt(arg, pgerror.CodeGroupingError, "arguments to GROUPING must be grouping expressions of the associated query level")
+		}
+		args[i], keys[i] = agg.keyColumn(k), k
+	}
+	return executor.NewGrouping(agg.maskColumn(), args, keys), nil
+}
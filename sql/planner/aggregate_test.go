@@ -0,0 +1,116 @@
+package planner
+
+import (
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/executor"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		query string
+		want  [][]interface{}
+	}{
+		{"SELECT count(*), count(a), sum(a), min(b), max(a) FROM t", [][]interface{}{{int64(4), int64(3), int64(6), "a", int64(3)}}},
+		{"SELECT count(*), sum(a) FROM t WHERE a > 5", [][]interface{}{{int64(0), nil}}},
+		{"SELECT a > 1 AS big, count(*) FROM t GROUP BY 1 ORDER BY 1", [][]interface{}{{false, int64(1)}, {true, int64(2)}, {nil, int64(1)}}},
+		{"SELECT a % 2 AS odd, string_agg(b, ',' ORDER BY b DESC) FROM t WHERE a IS NOT NULL GROUP BY odd ORDER BY odd", [][]interface{}{{int64(0), "b"}, {int64(1), "c,a"}}},
+		{"SELECT b, a + 1 FROM t GROUP BY a, b HAVING a < 3 ORDER BY b", [][]interface{}{{"a", int64(2)}, {"b", int64(3)}}},
+		{"SELECT count(*) FILTER (WHERE a > 1), count(DISTINCT a % 2) FROM t", [][]interface{}{{int64(2), int64(2)}}},
+		{"SELECT 1 FROM t HAVING count(*) > 10", nil},
+		{"SELECT * FROM t GROUP BY a, b HAVING a = 1", [][]interface{}{{int64(1), "a"}}},
+		{"SELECT a, count(*), GROUPING(a) FROM t WHERE a < 3 GROUP BY ROLLUP (a)", [][]interface{}{
+			{int64(1), int64(1), int64(0)}, {int64(2), int64(1), int64(0)}, {nil, int64(2), int64(1)},
+		}},
+		{"SELECT a, b, GROUPING(a, b) FROM t WHERE a = 1 GROUP BY CUBE (a, b)", [][]interface{}{
+			{int64(1), "a", int64(0)}, {int64(1), nil, int64(1)}, {nil, "a", int64(2)}, {nil, nil, int64(3)},
+		}},
+		{"SELECT a, b FROM t WHERE a < 3 GROUP BY GROUPING SETS ((a), (b), ()) ORDER BY 1, 2", [][]interface{}{
+			{int64(1), nil}, {int64(2), nil}, {nil, "a"}, {nil, "b"}, {nil, nil},
+		}},
+		{"SELECT b, (SELECT count(*) FROM t u WHERE u.a < x.a) FROM t x WHERE a > 1 GROUP BY a, b ORDER BY 1", [][]interface{}{{"b", int64(1)}, {"c", int64(2)}}},
+		{"SELECT b FROM t x WHERE EXISTS (SELECT count(*) FROM t y WHERE y.a = x.a + 10) ORDER BY 1", [][]interface{}{{"a"}, {"b"}, {"c"}, {"n"}}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, run(t, test.query), test.query)
+	}
+}
+
+func TestAggregateStrategy(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"SELECT count(*) FROM t", []string{"Aggregate", "  ->  Seq Scan on t"}},
+		{"SELECT b, count(*) FROM t GROUP BY b HAVING count(*) > 1", []string{
+			"HashAggregate",
+			"  Group Key: b",
+			"  Filter: (count(*) > 1)",
+			"  ->  Seq Scan on t",
+		}},
+		{"SELECT a, count(*) FROM (SELECT a FROM t ORDER BY a) s GROUP BY a", []string{
+			"GroupAggregate",
+			"  Group Key: a",
+			"  ->  Sort",
+			"        Sort Key: a",
+			"        ->  Seq Scan on t",
+		}},
+		{"SELECT a, b, count(*) FROM t GROUP BY ROLLUP (b, a)", []string{
+			"GroupAggregate",
+			"  Group Key: b, a",
+			"  Group Key: b",
+			"  Group Key: ()",
+			"  ->  Sort",
+			"        Sort Key: b, a",
+			"        ->  Seq Scan on t",
+		}},
+		{"SELECT a, b, count(*) FROM t GROUP BY CUBE (a, b)", []string{
+			"MixedAggregate",
+			"  Hash Key: a, b",
+			"  Hash Key: a",
+			"  Hash Key: b",
+			"  Group Key: ()",
+			"  ->  Seq Scan on t",
+		}},
+	}
+	for _, test := range tests {
+		p, err := plan(t, test.query)
+		require.NoError(t, err, test.query)
+		assert.Equal(t, test.want, executor.Explain(p.Root), test.query)
+	}
+}
+
+func TestAggregateErrors(t *testing.T) {
+	tests := []struct {
+		query    string
+		code     string
+		message  string
+		position int32
+	}{
+		{"SELECT a, count(*) FROM t", pgerror.CodeGroupingError, `column "t.a" must appear in the GROUP BY clause or be used in an aggregate function`, 8},
+		{"SELECT a FROM t GROUP BY b ORDER BY a", pgerror.CodeGroupingError, `column "t.a" must appear in the GROUP BY clause or be used in an aggregate function`, 8},
+		{"SELECT * FROM t GROUP BY a", pgerror.CodeGroupingError, `column "t.b" must appear in the GROUP BY clause or be used in an aggregate function`, 8},
+		{"SELECT a FROM t WHERE count(*) > 1", pgerror.CodeGroupingError, "aggregate functions are not allowed in WHERE", 23},
+		{"SELECT count(*) FROM t GROUP BY count(*)", pgerror.CodeGroupingError, "aggregate functions are not allowed in GROUP BY", 33},
+		{"SELECT sum(count(*)) FROM t", pgerror.CodeGroupingError, "aggregate function calls cannot be nested", 12},
+		{"SELECT count(*) FILTER (WHERE max(a) > 1) FROM t", pgerror.CodeGroupingError, "aggregate functions are not allowed in FILTER", 31},
+		{"SELECT GROUPING(b) FROM t GROUP BY a", pgerror.CodeGroupingError, "arguments to GROUPING must be grouping expressions of the associated query level", 17},
+		{"SELECT a FROM t GROUP BY 3", pgerror.CodeInvalidColumnReference, "GROUP BY position 3 is not in select list", 26},
+		{"SELECT sum(b) FROM t", pgerror.CodeUndefinedFunction, "function sum(text) does not exist", 8},
+		{"SELECT percentile_cont(0.5) FROM t", pgerror.CodeWrongObjectType, "WITHIN GROUP is required for ordered-set aggregate percentile_cont", 8},
+		{"SELECT count(*) WITHIN GROUP (ORDER BY a) FROM t", pgerror.CodeWrongObjectType, "count is not an ordered-set aggregate, so it cannot have WITHIN GROUP", 8},
+		{"SELECT (SELECT b) FROM t GROUP BY a", pgerror.CodeGroupingError, `subquery uses ungrouped column "t.b" from outer query`, 16},
+		{"VALUES (count(*))", pgerror.CodeGroupingError, "aggregate functions are not allowed in this context", 9},
+	}
+	for _, test := range tests {
+		_, err := plan(t, test.query)
+		require.Error(t, err, test.query)
+		pgErr := err.(*pgerror.Error)
+		assert.Equal(t, test.code, pgErr.Code, test.query)
+		assert.Equal(t, test.message, pgErr.Message, test.query)
+		assert.Equal(t, test.position, pgErr.Position, test.query)
+	}
+}
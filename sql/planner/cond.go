@@ -0,0 +1,377 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package planner
+
+import (
+	"github.com/patrickglass/dsql/sql/executor"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// commonType returns the type the compiled expressions of nodes are
+// converted to when they are combined by construct, such as CASE. Only
+// unknown expressions resolve to text.
+func (c *Compiler) commonType(nodes []parser.Expr, exprs []executor.Expr, construct string) (types.Type, error) {
+	t := types.Unknown
+	for i, e := range exprs {
+		common, ok := types.Common(t, e.Type())
+		if !ok {
+			return types.Type{}, c.ErrorAt(nodes[i], pgerror.CodeDatatypeMismatch, "%s types %s and %s cannot be matched", construct, t, e.Type())
+		}
+		t = common
+	}
+	if t.OID == types.UnknownOID {
+		t = types.Text
+	}
+	return t, nil
+}
+
+// convertAll converts the compiled expressions of nodes to type t
+func (c *Compiler) convertAll(nodes []parser.Expr, exprs []executor.Expr, t types.Type) error {
+	for i, e := range exprs {
+		converted, err := c.convert(nodes[i], e, t, types.CastImplicit)
+		if err != nil {
+			return err
+		}
+		exprs[i] = converted
+	}
+	return nil
+}
+
+// exprs compiles a list of expressions
+func (c *Compiler) exprs(nodes []parser.Expr, scope *Scope) ([]executor.Expr, error) {
+	exprs := make([]executor.Expr, len(nodes))
+	for i, node := range nodes {
+		e, err := c.Expr(node, scope)
+		if err != nil {
+			return nil, err
+		}
+		exprs[i] = e
+	}
+	return exprs, nil
+}
+
+// combine compiles expressions converted to their common type
+func (c *Compiler) combine(nodes []parser.Expr, scope *Scope, construct string) ([]executor.Expr, types.Type, error) {
+	exprs, err := c.exprs(nodes, scope)
+	if err != nil {
+		return nil, types.Type{}, err
+	}
+	t, err := c.commonType(nodes, exprs, construct)
+	if err != nil {
+		return nil, types.Type{}, err
+	}
+	return exprs, t, c.convertAll(nodes, exprs, t)
+}
+
+func (c *Compiler) is(e *parser.IsExpr, scope *Scope) (executor.Expr, error) {
+	operand, err := c.Expr(e.Expr, scope)
+	if err != nil {
+		return nil, err
+	}
+	switch e.Test {
+	case parser.IsNull:
+		return executor.NewIsNull(operand, e.Not), nil
+	case parser.IsDistinctFrom:
+		right, err := c.Expr(e.Right, scope)
+		if err != nil {
+			return nil, err
+		}
+		op := "IS DISTINCT FROM"
+		if e.Not {
+			op = "IS NOT DISTINCT FROM"
+		}
+		t, ok := c.operandType(operand, right, types.Text)
+		if !ok {
+			return nil, c.errOperator(e, op, operand, right)
+		}
+		left, right, err := c.convertOperands(e.Expr, e.Right, operand, right, t)
+		if err != nil {
+			return nil, err
+		}
+		return executor.NewIsDistinct(left, right, e.Not), nil
+	}
+	var want interface{}
+	clause := "IS UNKNOWN"
+	switch e.Test {
+	case parser.IsTrue:
+		want, clause = true, "IS TRUE"
+	case parser.IsFalse:
+		want, clause = false, "IS FALSE"
+	}
+	if e.Not {
+		clause = "IS NOT" + clause[2:]
+	}
+	if operand, err = c.boolean(e.Expr, operand, clause); err != nil {
+		return nil, err
+	}
+	return executor.NewIsBool(operand, want, e.Not), nil
+}
+
+func (c *Compiler) caseExpr(e *parser.CaseExpr, scope *Scope) (executor.Expr, error) {
+	var operand executor.Expr
+	if e.Operand != nil {
+		var err error
+		if operand, err = c.Expr(e.Operand, scope); err != nil {
+			return nil, err
+		}
+	}
+	whens := make([]executor.When, len(e.Whens))
+	nodes := make([]parser.Expr, 0, len(e.Whens)+1)
+	results := make([]executor.Expr, 0, len(e.Whens)+1)
+	for i, w := range e.Whens {
+		var cond executor.Expr
+		var err error
+		if operand != nil {
+			var value executor.Expr
+			if value, err = c.Expr(w.Cond, scope); err != nil {
+				return nil, err
+			}
+			cond, err = c.compare(w.Cond, "=", e.Operand, w.Cond, operand, value)
+		} else {
+			cond, err = c.Condition(w.Cond, scope, "CASE")
+		}
+		if err != nil {
+			return nil, err
+		}
+		result, err := c.Expr(w.Result, scope)
+		if err != nil {
+			return nil, err
+		}
+		whens[i].Cond = cond
+		nodes = append(nodes, w.Result)
+		results = append(results, result)
+	}
+	if e.Else != nil {
+		els, err := c.Expr(e.Else, scope)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, e.Else)
+		results = append(results, els)
+	}
+	t, err := c.commonType(nodes, results, "CASE")
+	if err != nil {
+		return nil, err
+	}
+	if err := c.convertAll(nodes, results, t); err != nil {
+		return nil, err
+	}
+	els := executor.NewConst(nil, t)
+	if e.Else != nil {
+		els = results[len(results)-1]
+	}
+	for i := range whens {
+		whens[i].Result = results[i]
+	}
+	return executor.NewCase(whens, els, t), nil
+}
+
+func (c *Compiler) like(e *parser.LikeExpr, scope *Scope) (executor.Expr, error) {
+	operand, err := c.Expr(e.Expr, scope)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := c.Expr(e.Pattern, scope)
+	if err != nil {
+		return nil, err
+	}
+	if !operand.Type().IsText() || !pattern.Type().IsText() {
+		op := "~~"
+		if e.CaseInsensitive {
+			op = "~~*"
+		}
+		if e.Not {
+			op = "!" + op
+		}
+		return nil, c.errOperator(e, op, operand, pattern)
+	}
+	if operand, pattern, err = c.convertOperands(e.Expr, e.Pattern, operand, pattern, types.Text); err != nil {
+		return nil, err
+	}
+	var escape executor.Expr
+	if e.Escape != nil {
+		if escape, err = c.Expr(e.Escape, scope); err != nil {
+			return nil, err
+		}
+		if escape, err = c.convert(e.Escape, escape, types.Text, types.CastImplicit); err != nil {
+			return nil, err
+		}
+	}
+	return executor.NewLike(operand, pattern, escape, e.CaseInsensitive, e.Not), nil
+}
+
+func (c *Compiler) between(e *parser.BetweenExpr, scope *Scope) (executor.Expr, error) {
+	operand, err := c.Expr(e.Expr, scope)
+	if err != nil {
+		return nil, err
+	}
+	low, err := c.Expr(e.Low, scope)
+	if err != nil {
+		return nil, err
+	}
+	high, err := c.Expr(e.High, scope)
+	if err != nil {
+		return nil, err
+	}
+	result, err := c.inRange(e, operand, low, high, e.Low, e.High)
+	if err != nil {
+		return nil, err
+	}
+	if e.Symmetric {
+		swapped, err := c.inRange(e, operand, high, low, e.High, e.Low)
+		if err != nil {
+			return nil, err
+		}
+		result = executor.NewOr(result, swapped)
+	}
+	if e.Not {
+		result = executor.NewNot(result)
+	}
+	return result, nil
+}
+
+// inRange compiles low <= e.Expr AND e.Expr <= high
+func (c *Compiler) inRange(e *parser.BetweenExpr, operand, low, high executor.Expr, lowNode, highNode parser.Expr) (executor.Expr, error) {
+	above, err := c.compare(e, ">=", e.Expr, lowNode, operand, low)
+	if err != nil {
+		return nil, err
+	}
+	below, err := c.compare(e, "<=", e.Expr, highNode, operand, high)
+	if err != nil {
+		return nil, err
+	}
+	return executor.NewAnd(above, below), nil
+}
+
+func (c *Compiler) in(e *parser.InExpr, scope *Scope) (executor.Expr, error) {
+	if e.Subquery != nil {
+		op := "="
+		if e.Not {
+			op = "<>"
+		}
+		return c.quantifiedSubquery(e, op, e.Expr, e.Subquery, e.Not, scope)
+	}
+	nodes := append([]parser.Expr{e.Expr}, e.List...)
+	exprs, _, err := c.combine(nodes, scope, "IN")
+	if err != nil {
+		return nil, err
+	}
+	return executor.NewIn(exprs[0], exprs[1:], e.Not), nil
+}
+
+func (c *Compiler) quantified(e *parser.QuantifiedExpr, scope *Scope) (executor.Expr, error) {
+	if e.Subquery != nil {
+		return c.quantifiedSubquery(e, e.Op, e.Expr, e.Subquery, e.All, scope)
+	}
+	operand, err := c.Expr(e.Expr, scope)
+	if err != nil {
+		return nil, err
+	}
+	array, err := c.Expr(e.Array, scope)
+	if err != nil {
+		return nil, err
+	}
+	if array.Type().OID == types.UnknownOID {
+		// a literal such as '{1,2}' is an array of the type of the operand
+		t, ok := types.ArrayOf(operand.Type())
+		if !ok {
+			return nil, c.ErrorAt(e.Array, pgerror.CodeIndeterminateDatatype, "could not determine polymorphic type because input has type unknown")
+		}
+		if array, err = c.convert(e.Array, array, t, types.CastImplicit); err != nil {
+			return nil, err
+		}
+	}
+	if !array.Type().IsArray() {
+		return nil, c.ErrorAt(e, pgerror.CodeWrongObjectType, "op ANY/ALL (array) requires array on right side")
+	}
+	elem := executor.NewConst(nil, array.Type().Elem())
+	t, ok := c.operandType(operand, elem, types.Text)
+	if !ok {
+		return nil, c.errOperator(e, e.Op, operand, elem)
+	}
+	if operand, err = c.convert(e.Expr, operand, t, types.CastImplicit); err != nil {
+		return nil, err
+	}
+	arrayType, _ := types.ArrayOf(t)
+	if array, err = c.convert(e.Array, array, arrayType, types.CastImplicit); err != nil {
+		return nil, err
+	}
+	return executor.NewQuantifiedArray(e.Op, operand, array, e.All), nil
+}
+
+// quantifiedSubquery compiles operand op ANY or ALL over a subquery
+// returning a single column
+func (c *Compiler) quantifiedSubquery(node parser.Expr, op string, operandNode parser.Expr, stmt *parser.SelectStmt, all bool, scope *Scope) (executor.Expr, error) {
+	operand, err := c.Expr(operandNode, scope)
+	if err != nil {
+		return nil, err
+	}
+	sub, plan, err := c.subquery(stmt, scope)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(plan.Columns) > 1:
+		return nil, c.ErrorAt(node, pgerror.CodeSyntaxError, "subquery has too many columns")
+	case len(plan.Columns) == 0:
+		return nil, c.ErrorAt(node, pgerror.CodeSyntaxError, "subquery has too few columns")
+	}
+	column := executor.NewColumn(0, plan.Columns[0].Name, plan.Types[0])
+	t, ok := c.operandType(operand, column, types.Text)
+	if !ok {
+		return nil, c.errOperator(node, op, operand, column)
+	}
+	if operand, err = c.convert(operandNode, operand, t, types.CastImplicit); err != nil {
+		return nil, err
+	}
+	if plan.Types[0] != t {
+		if !types.CanCast(plan.Types[0], t, types.CastImplicit) {
+			return nil, c.ErrorAt(stmt, pgerror.CodeCannotCoerce, "cannot cast type %s to %s", plan.Types[0], t)
+		}
+		sub.Plan = executor.NewProject(sub.Plan, []executor.Expr{executor.NewCast(column, t, false)})
+	}
+	return executor.NewQuantifiedSubquery(op, operand, sub, all), nil
+}
+
+// subquery plans a query nested in an expression compiled in scope
+func (c *Compiler) subquery(stmt *parser.SelectStmt, scope *Scope) (*executor.Subquery, *Plan, error) {
+	plan, err := c.Select(stmt, scope)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &executor.Subquery{Plan: plan.Root, Correlated: plan.Correlated}, plan, nil
+}
+
+func (c *Compiler) array(e *parser.ArrayExpr, scope *Scope) (executor.Expr, error) {
+	if len(e.Elems) == 0 {
+		err := c.ErrorAt(e, pgerror.CodeIndeterminateDatatype, "cannot determine type of empty array")
+		err.Hint = "Explicitly cast to the desired type, for example ARRAY[]::integer[]."
+		return nil, err
+	}
+	elems, t, err := c.combine(e.Elems, scope, "ARRAY")
+	if err != nil {
+		return nil, err
+	}
+	arrayType, ok := types.ArrayOf(t)
+	if !ok {
+		return nil, c.ErrorAt(e, pgerror.CodeFeatureNotSupported, "arrays of type %s are not supported", t)
+	}
+	return executor.NewArray(elems, arrayType), nil
+}
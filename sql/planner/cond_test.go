@@ -0,0 +1,75 @@
+package planner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/executor"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConditionalExpr(t *testing.T) {
+	tests := []struct {
+		query string
+		t     types.Type
+		want  interface{}
+	}{
+		{"CASE WHEN t.a = 1 THEN 'one' END", types.Text, "one"},
+		{"CASE t.a WHEN 2 THEN 1 WHEN 1 THEN 2.5 END", types.Numeric, "2.5"},
+		{"CASE WHEN false THEN 1 END", types.Int4, nil},
+		{"CASE WHEN false THEN b ELSE 'x' END", types.Text, "x"},
+		{"COALESCE(NULL, u.a, t.a)", types.Int8, int64(10)},
+		{"NULLIF(b, 'one')", types.Text, nil},
+		{"GREATEST(t.a, c)", types.Numeric, "1.5"},
+		{"t.a IN (1, 2.5)", types.Bool, true},
+		{"b NOT IN ('one', NULL)", types.Bool, false},
+		{"c BETWEEN 1 AND 2", types.Bool, true},
+		{"b LIKE 'o%'", types.Bool, true},
+		{"b NOT ILIKE 'O_E'", types.Bool, false},
+		{"t.a = 2 IS NOT FALSE", types.Bool, false},
+		{"NULL::boolean IS UNKNOWN", types.Bool, true},
+		{"t.a IS DISTINCT FROM NULL", types.Bool, true},
+		{"t.a = ANY (ARRAY[u.a, 2])", types.Bool, false},
+		{"ARRAY[t.a, u.a]", types.Type{OID: pgtype.Int8ArrayOID, Modifier: -1}, []interface{}{int64(1), int64(10)}},
+		{"ARRAY[]::text[]", types.Type{OID: pgtype.TextArrayOID, Modifier: -1}, []interface{}{}},
+	}
+	for _, test := range tests {
+		e, err := compile(t, test.query)
+		require.NoError(t, err, test.query)
+		assert.Equal(t, test.t, e.Type(), test.query)
+		v, err := e.Eval(executor.NewEnv(context.Background()), testRow)
+		require.NoError(t, err, test.query)
+		assert.Equal(t, test.want, v, test.query)
+	}
+}
+
+func TestConditionalExprErrors(t *testing.T) {
+	tests := []struct {
+		query    string
+		code     string
+		message  string
+		position int32
+	}{
+		{"CASE WHEN true THEN 1 ELSE b END", pgerror.CodeDatatypeMismatch, "CASE types integer and text cannot be matched", 28},
+		{"CASE WHEN 1 THEN 1 END", pgerror.CodeDatatypeMismatch, "argument of CASE must be type boolean, not type integer", 11},
+		{"t.a IN (1, b)", pgerror.CodeDatatypeMismatch, "IN types integer and text cannot be matched", 12},
+		{"COALESCE(t.a, true)", pgerror.CodeDatatypeMismatch, "COALESCE types integer and boolean cannot be matched", 15},
+		{"t.a LIKE 'x'", pgerror.CodeUndefinedFunction, "operator does not exist: integer ~~ unknown", 5},
+		{"b BETWEEN 1 AND 2", pgerror.CodeUndefinedFunction, "operator does not exist: text >= integer", 3},
+		{"t.a = ANY (1)", pgerror.CodeWrongObjectType, "op ANY/ALL (array) requires array on right side", 5},
+		{"ARRAY[]", pgerror.CodeIndeterminateDatatype, "cannot determine type of empty array", 1},
+		{"t.a IS FALSE", pgerror.CodeDatatypeMismatch, "argument of IS FALSE must be type boolean, not type integer", 1},
+	}
+	for _, test := range tests {
+		_, err := compile(t, test.query)
+		require.Error(t, err, test.query)
+		pgErr := err.(*pgerror.Error)
+		assert.Equal(t, test.code, pgErr.Code, test.query)
+		assert.Equal(t, test.message, pgErr.Message, test.query)
+		assert.Equal(t, test.position, pgErr.Position, test.query)
+	}
+}
@@ -0,0 +1,390 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package planner compiles the statements of the parser into expressions
+// and plans run by the executor, resolving names and types.
+package planner
+
+import (
+	"errors"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/patrickglass/dsql/sql/catalog"
+	"github.com/patrickglass/dsql/sql/executor"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// Compiler compiles the expressions of a statement
+type Compiler struct {
+	// Query is the text of the statement, errors are positioned in it
+	Query string
+	// Args are the values of the parameters, nil while describing a
+	// statement whose parameters are not known yet
+	Args []interface{}
+	// Tables resolves the names of the tables the statement refers to
+	Tables Tables
+}
+
+// Tables resolves the names of tables
+type Tables interface {
+	Table(name *parser.ObjectName) (*catalog.Table, error)
+}
+
+// ErrorAt returns an error positioned at the node
+func (c *Compiler) ErrorAt(node parser.Node, code string, format string, args ...interface{}) *pgerror.Error {
+	err := pgerror.NewError(code, format, args...)
+	err.Position = c.Position(node)
+	return err
+}
+
+// Position returns the 1-based character position of the node
+func (c *Compiler) Position(node parser.Node) int32 {
+	pos := node.Pos()
+	if pos > len(c.Query) {
+		pos = len(c.Query)
+	}
+	return int32(utf8.RuneCountInString(c.Query[:pos]) + 1)
+}
+
+// Positioned adds the position of the node to an error without one
+func (c *Compiler) Positioned(node parser.Node, err error) error {
+	var pgErr *pgerror.Error
+	if !errors.As(err, &pgErr) || pgErr.Position != 0 {
+		return err
+	}
+	positioned := *pgErr
+	positioned.Position = c.Position(node)
+	return &positioned
+}
+
+// Expr compiles the expression against the columns of the scope
+func (c *Compiler) Expr(e parser.Expr, scope *Scope) (executor.Expr, error) {
+	if scope.agg != nil {
+		if compiled, ok, err := c.grouped(e, scope); ok {
+			return compiled, err
+		}
+	}
+	switch e := e.(type) {
+	case *parser.Literal:
+		return c.literal(e)
+	case *parser.Param:
+		return c.param(e)
+	case *parser.ColumnRef:
+		s, depth, i, err := c.resolve(scope, e)
+		if err != nil {
+			return nil, err
+		}
+		if depth > 0 {
+			return executor.NewOuterColumn(depth, i, e.String(), s.Columns[i].Type), nil
+		}
+		return executor.NewColumn(i, e.String(), s.Columns[i].Type), nil
+	case *parser.UnaryExpr:
+		return c.unary(e, scope)
+	case *parser.BinaryExpr:
+		return c.binary(e, scope)
+	case *parser.IsExpr:
+		return c.is(e, scope)
+	case *parser.CastExpr:
+		return c.cast(e, scope)
+	case *parser.CaseExpr:
+		return c.caseExpr(e, scope)
+	case *parser.LikeExpr:
+		return c.like(e, scope)
+	case *parser.BetweenExpr:
+		return c.between(e, scope)
+	case *parser.InExpr:
+		return c.in(e, scope)
+	case *parser.ExistsExpr:
+		sub, _, err := c.subquery(e.Subquery, scope)
+		if err != nil {
+			return nil, err
+		}
+		return executor.NewExists(sub), nil
+	case *parser.SubqueryExpr:
+		sub, plan, err := c.subquery(e.Subquery, scope)
+		if err != nil {
+			return nil, err
+		}
+		if len(plan.Columns) != 1 {
+			return nil, c.ErrorAt(e, pgerror.CodeSyntaxError, "subquery must return only one column")
+		}
+		return executor.NewScalarSubquery(sub, plan.Types[0]), nil
+	case *parser.QuantifiedExpr:
+		return c.quantified(e, scope)
+	case *parser.ArrayExpr:
+		return c.array(e, scope)
+	case *parser.FuncCall:
+		return c.funcCall(e, scope)
+	case *parser.DefaultExpr:
+		return nil, c.ErrorAt(e, pgerror.CodeSyntaxError, "DEFAULT is not allowed in this context")
+	}
+	return nil, c.ErrorAt(e, pgerror.CodeFeatureNotSupported, "expression %s is not supported", e)
+}
+
+func (c *Compiler) literal(e *parser.Literal) (executor.Expr, error) {
+	switch e.Kind {
+	case parser.LiteralNull:
+		return executor.NewConst(nil, types.Unknown), nil
+	case parser.LiteralBool:
+		return executor.NewConst(e.Value == "true", types.Bool), nil
+	case parser.LiteralInteger:
+		n, err := strconv.ParseInt(e.Value, 10, 64)
+		switch {
+		case err != nil:
+			// integers too large for bigint are numeric
+		case int64(int32(n)) == n:
+			return executor.NewConst(n, types.Int4), nil
+		default:
+			return executor.NewConst(n, types.Int8), nil
+		}
+		fallthrough
+	case parser.LiteralNumeric:
+		v, err := types.Cast(e.Value, types.Unknown, types.Numeric)
+		if err != nil {
+			return nil, c.Positioned(e, err)
+		}
+		return executor.NewConst(v, types.Numeric), nil
+	}
+	return executor.NewConst(e.Value, types.Unknown), nil
+}
+
+func (c *Compiler) param(e *parser.Param) (executor.Expr, error) {
+	if c.Args == nil {
+		return executor.NewConst(nil, types.Unknown), nil
+	}
+	if e.Number > len(c.Args) {
+		return nil, c.ErrorAt(e, pgerror.CodeUndefinedParameter, "there is no parameter $%d", e.Number)
+	}
+	v := c.Args[e.Number-1]
+	return executor.NewConst(types.Normalize(v), types.TypeOf(v)), nil
+}
+
+// convert casts the compiled expression of node to type t, constants are
+// converted right away
+func (c *Compiler) convert(node parser.Node, e executor.Expr, t types.Type, ctx types.CastContext) (executor.Expr, error) {
+	from := e.Type()
+	if from == t {
+		return e, nil
+	}
+	if !types.CanCast(from, t, ctx) {
+		return nil, c.ErrorAt(node, pgerror.CodeCannotCoerce, "cannot cast type %s to %s", from, t)
+	}
+	if v, ok := executor.IsConst(e); ok {
+		var err error
+		if ctx == types.CastExplicit {
+			v, err = types.Cast(v, from, t)
+		} else {
+			v, err = types.Assign(v, from, t)
+		}
+		if err != nil {
+			return nil, c.Positioned(node, err)
+		}
+		return executor.NewConst(v, t), nil
+	}
+	return executor.NewCast(e, t, ctx == types.CastExplicit), nil
+}
+
+// Condition compiles an expression which must be boolean, such as the
+// argument of WHERE named by clause
+func (c *Compiler) Condition(e parser.Expr, scope *Scope, clause string) (executor.Expr, error) {
+	if call := findAggregate(e); call != nil && scope.agg == nil {
+		return nil, c.ErrorAt(call, pgerror.CodeGroupingError, "aggregate functions are not allowed in %s", clause)
+	}
+	compiled, err := c.Expr(e, scope)
+	if err != nil {
+		return nil, err
+	}
+	return c.boolean(e, compiled, clause)
+}
+
+// boolean converts the compiled expression of node to boolean
+func (c *Compiler) boolean(node parser.Expr, e executor.Expr, clause string) (executor.Expr, error) {
+	t := e.Type()
+	if t.OID != pgtype.BoolOID && t.OID != types.UnknownOID {
+		return nil, c.ErrorAt(node, pgerror.CodeDatatypeMismatch, "argument of %s must be type boolean, not type %s", clause, t)
+	}
+	return c.convert(node, e, types.Bool, types.CastImplicit)
+}
+
+// Assignment compiles an expression whose value is stored in the column
+// with the name and type
+func (c *Compiler) Assignment(e parser.Expr, scope *Scope, column string, t types.Type) (executor.Expr, error) {
+	compiled, err := c.Expr(e, scope)
+	if err != nil {
+		return nil, err
+	}
+	return c.Assign(e, compiled, column, t)
+}
+
+// Assign converts the compiled expression of node to the type of the
+// column it is stored in
+func (c *Compiler) Assign(node parser.Node, e executor.Expr, column string, t types.Type) (executor.Expr, error) {
+	if !types.CanCast(e.Type(), t, types.CastAssignment) {
+		err := c.ErrorAt(node, pgerror.CodeDatatypeMismatch, "column \"%s\" is of type %s but expression is of type %s", column, t, e.Type())
+		err.Hint = "You will need to rewrite or cast the expression."
+		return nil, err
+	}
+	return c.convert(node, e, t, types.CastAssignment)
+}
+
+func (c *Compiler) unary(e *parser.UnaryExpr, scope *Scope) (executor.Expr, error) {
+	operand, err := c.Expr(e.Expr, scope)
+	if err != nil {
+		return nil, err
+	}
+	if e.Op == "NOT" {
+		operand, err = c.boolean(e.Expr, operand, "NOT")
+		if err != nil {
+			return nil, err
+		}
+		return executor.NewNot(operand), nil
+	}
+
+	t := operand.Type()
+	if t.OID == types.UnknownOID {
+		t = types.Numeric
+	}
+	if t.Category() != types.CategoryNumeric {
+		return nil, c.ErrorAt(e, pgerror.CodeUndefinedFunction, "operator does not exist: %s %s", e.Op, t)
+	}
+	if operand, err = c.convert(e.Expr, operand, t, types.CastImplicit); err != nil {
+		return nil, err
+	}
+	if e.Op == "+" {
+		return operand, nil
+	}
+	return executor.NewNeg(operand), nil
+}
+
+func (c *Compiler) binary(e *parser.BinaryExpr, scope *Scope) (executor.Expr, error) {
+	left, err := c.Expr(e.Left, scope)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.Expr(e.Right, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Op {
+	case "AND", "OR":
+		if left, err = c.boolean(e.Left, left, e.Op); err != nil {
+			return nil, err
+		}
+		if right, err = c.boolean(e.Right, right, e.Op); err != nil {
+			return nil, err
+		}
+		if e.Op == "AND" {
+			return executor.NewAnd(left, right), nil
+		}
+		return executor.NewOr(left, right), nil
+	case "=", "<>", "<", "<=", ">", ">=":
+		return c.compare(e, e.Op, e.Left, e.Right, left, right)
+	case "+", "-", "*", "/", "%":
+		t, ok := c.operandType(left, right, types.Numeric)
+		if !ok || t.Category() != types.CategoryNumeric {
+			return nil, c.errOperator(e, e.Op, left, right)
+		}
+		if left, right, err = c.convertOperands(e.Left, e.Right, left, right, t); err != nil {
+			return nil, err
+		}
+		return executor.NewArith(e.Op, left, right, t), nil
+	case "^":
+		return c.call(e, "power", []parser.Expr{e.Left, e.Right}, []executor.Expr{left, right})
+	case "||":
+		if !left.Type().IsText() && !right.Type().IsText() {
+			return nil, c.errOperator(e, e.Op, left, right)
+		}
+		if left, err = c.convert(e.Left, left, types.Text, types.CastAssignment); err != nil {
+			return nil, err
+		}
+		if right, err = c.convert(e.Right, right, types.Text, types.CastAssignment); err != nil {
+			return nil, err
+		}
+		return executor.NewConcat(left, right), nil
+	}
+	return nil, c.errOperator(e, e.Op, left, right)
+}
+
+// compare compiles a comparison of the compiled operands of node
+func (c *Compiler) compare(node parser.Node, op string, lnode, rnode parser.Expr, left, right executor.Expr) (executor.Expr, error) {
+	t, ok := c.operandType(left, right, types.Text)
+	if !ok {
+		return nil, c.errOperator(node, op, left, right)
+	}
+	left, right, err := c.convertOperands(lnode, rnode, left, right, t)
+	if err != nil {
+		return nil, err
+	}
+	return executor.NewCompare(op, left, right), nil
+}
+
+// operandType returns the type both operands of an operator are converted
+// to, operands of unknown type become def
+func (c *Compiler) operandType(left, right executor.Expr, def types.Type) (types.Type, bool) {
+	t, ok := types.Common(left.Type(), right.Type())
+	if !ok {
+		return t, false
+	}
+	if t.OID == types.UnknownOID {
+		t = def
+	}
+	// operators ignore the modifiers of their operands
+	t.Modifier = -1
+	return t, true
+}
+
+// convertOperands converts both operands of an operator to type t
+func (c *Compiler) convertOperands(lnode, rnode parser.Expr, left, right executor.Expr, t types.Type) (executor.Expr, executor.Expr, error) {
+	left, err := c.convert(lnode, left, t, types.CastImplicit)
+	if err != nil {
+		return nil, nil, err
+	}
+	right, err = c.convert(rnode, right, t, types.CastImplicit)
+	if err != nil {
+		return nil, nil, err
+	}
+	return left, right, nil
+}
+
+// errOperator is returned for an operator which is not defined for the
+// types of its operands
+func (c *Compiler) errOperator(node parser.Node, op string, left, right executor.Expr) error {
+	err := c.ErrorAt(node, pgerror.CodeUndefinedFunction, "operator does not exist: %s %s %s", left.Type(), op, right.Type())
+	err.Hint = "No operator matches the given name and argument types. You might need to add explicit type casts."
+	return err
+}
+
+func (c *Compiler) cast(e *parser.CastExpr, scope *Scope) (executor.Expr, error) {
+	t, err := types.FromName(e.Type)
+	if err != nil {
+		return nil, c.Positioned(e.Type, err)
+	}
+	if array, ok := e.Expr.(*parser.ArrayExpr); ok && len(array.Elems) == 0 && t.IsArray() {
+		// the type of an empty array comes from the cast
+		return executor.NewConst([]interface{}{}, t), nil
+	}
+	operand, err := c.Expr(e.Expr, scope)
+	if err != nil {
+		return nil, err
+	}
+	return c.convert(e, operand, t, types.CastExplicit)
+}
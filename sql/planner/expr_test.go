@@ -0,0 +1,171 @@
+package planner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/executor"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testScope has the columns a integer, b text and c numeric of table t
+// and the column a of table u
+var testScope = &Scope{Columns: []ScopeColumn{
+	{Table: "t", Name: "a", Type: types.Int4, TableOID: 1, AttributeNumber: 1},
+	{Table: "t", Name: "b", Type: types.Text, TableOID: 1, AttributeNumber: 2},
+	{Table: "t", Name: "c", Type: types.Numeric, TableOID: 1, AttributeNumber: 3},
+	{Table: "u", Name: "a", Type: types.Int8, TableOID: 2, AttributeNumber: 1},
+}}
+
+var testRow = executor.Row{int64(1), "one", "1.5", int64(10)}
+
+// compile compiles the expression with the arguments
+func compile(t *testing.T, query string, args ...interface{}) (executor.Expr, error) {
+	e, err := parser.ParseExpr(query)
+	require.NoError(t, err, query)
+	c := &Compiler{Query: query, Args: args}
+	return c.Expr(e, testScope)
+}
+
+func TestExpr(t *testing.T) {
+	tests := []struct {
+		query string
+		t     types.Type
+		want  interface{}
+	}{
+		{"1", types.Int4, int64(1)},
+		{"5000000000", types.Int8, int64(5000000000)},
+		{"99999999999999999999", types.Numeric, "99999999999999999999"},
+		{"1.50", types.Numeric, "1.50"},
+		{"'x'", types.Unknown, "x"},
+		{"true", types.Bool, true},
+		{"NULL", types.Unknown, nil},
+		{"t.a", types.Int4, int64(1)},
+		{"u.a", types.Int8, int64(10)},
+		{"b", types.Text, "one"},
+		{"t.a + 1", types.Type{OID: pgtype.Int4OID, Modifier: -1}, int64(2)},
+		{"t.a + u.a", types.Type{OID: pgtype.Int8OID, Modifier: -1}, int64(11)},
+		{"c * 2", types.Type{OID: pgtype.NumericOID, Modifier: -1}, "3.0"},
+		{"t.a + 0.5", types.Type{OID: pgtype.NumericOID, Modifier: -1}, "1.5"},
+		{"1 + '2'", types.Type{OID: pgtype.Int4OID, Modifier: -1}, int64(3)},
+		{"-c", types.Numeric, "-1.5"},
+		{"b || 'x'", types.Text, "onex"},
+		{"b || t.a", types.Text, "one1"},
+		{"t.a = 1", types.Bool, true},
+		{"b < 'p'", types.Bool, true},
+		{"c > t.a", types.Bool, true},
+		{"t.a = 1 AND NOT b = 'two'", types.Bool, true},
+		{"b IS NULL", types.Bool, false},
+		{"NULL IS NOT NULL", types.Bool, false},
+		{"'42'::int4", types.Int4, int64(42)},
+		{"c::int4", types.Int4, int64(2)},
+		{"$1 = t.a", types.Bool, true},
+	}
+	for _, test := range tests {
+		e, err := compile(t, test.query, int64(1))
+		require.NoError(t, err, test.query)
+		assert.Equal(t, test.t, e.Type(), test.query)
+		v, err := e.Eval(executor.NewEnv(context.Background()), testRow)
+		require.NoError(t, err, test.query)
+		assert.Equal(t, test.want, v, test.query)
+	}
+}
+
+func TestExprConstantFolding(t *testing.T) {
+	e, err := compile(t, "'12'::int8")
+	require.NoError(t, err)
+	v, ok := executor.IsConst(e)
+	assert.True(t, ok)
+	assert.Equal(t, int64(12), v)
+}
+
+func TestExprDescribe(t *testing.T) {
+	e, err := parser.ParseExpr("$1 = $2")
+	require.NoError(t, err)
+	c := &Compiler{Query: "$1 = $2"}
+	compiled, err := c.Expr(e, testScope)
+	require.NoError(t, err, "parameters are not known while describing")
+	assert.Equal(t, types.Bool, compiled.Type())
+}
+
+func TestExprErrors(t *testing.T) {
+	tests := []struct {
+		query    string
+		code     string
+		message  string
+		position int32
+	}{
+		{"x", pgerror.CodeUndefinedColumn, `column "x" does not exist`, 1},
+		{"1 + a", pgerror.CodeAmbiguousColumn, `column reference "a" is ambiguous`, 5},
+		{"v.a", pgerror.CodeUndefinedTable, `missing FROM-clause entry for table "v"`, 1},
+		{"t.x", pgerror.CodeUndefinedColumn, `column t.x does not exist`, 1},
+		{"b + 1", pgerror.CodeUndefinedFunction, `operator does not exist: text + integer`, 3},
+		{"t.a = true", pgerror.CodeUndefinedFunction, `operator does not exist: integer = boolean`, 5},
+		{"NOT t.a", pgerror.CodeDatatypeMismatch, `argument of NOT must be type boolean, not type integer`, 5},
+		{"t.a AND true", pgerror.CodeDatatypeMismatch, `argument of AND must be type boolean, not type integer`, 1},
+		{"'é' || 'x'::int4", pgerror.CodeInvalidTextRepresentation, `invalid input syntax for type integer: "x"`, 11},
+		{"$2", pgerror.CodeUndefinedParameter, `there is no parameter $2`, 1},
+		{"true::date", pgerror.CodeCannotCoerce, `cannot cast type boolean to date`, 5},
+		{"1 / 0", pgerror.CodeDivisionByZero, `division by zero`, 0},
+	}
+	for _, test := range tests {
+		e, err := compile(t, test.query, int64(1))
+		if err == nil {
+			_, err = e.Eval(executor.NewEnv(context.Background()), testRow)
+		}
+		require.Error(t, err, test.query)
+		pgErr := err.(*pgerror.Error)
+		assert.Equal(t, test.code, pgErr.Code, test.query)
+		assert.Equal(t, test.message, pgErr.Message, test.query)
+		assert.Equal(t, test.position, pgErr.Position, test.query)
+	}
+}
+
+func TestCondition(t *testing.T) {
+	c := &Compiler{Query: "t.a"}
+	e, err := parser.ParseExpr(c.Query)
+	require.NoError(t, err)
+	_, err = c.Condition(e, testScope, "WHERE")
+	assert.EqualError(t, err, "ERROR: argument of WHERE must be type boolean, not type integer (SQLSTATE 42804)")
+
+	c.Query = "NULL"
+	e, err = parser.ParseExpr(c.Query)
+	require.NoError(t, err)
+	cond, err := c.Condition(e, testScope, "WHERE")
+	require.NoError(t, err)
+	assert.Equal(t, types.Bool, cond.Type())
+}
+
+func TestAssignment(t *testing.T) {
+	varchar3 := types.Type{OID: pgtype.VarcharOID, Modifier: 3 + 4}
+	c := &Compiler{Query: "b"}
+	e, err := parser.ParseExpr(c.Query)
+	require.NoError(t, err)
+	compiled, err := c.Assignment(e, testScope, "v", varchar3)
+	require.NoError(t, err)
+	assert.Equal(t, varchar3, compiled.Type())
+
+	c.Query = "'abcd'"
+	e, err = parser.ParseExpr(c.Query)
+	require.NoError(t, err)
+	_, err = c.Assignment(e, testScope, "v", varchar3)
+	assert.EqualError(t, err, "ERROR: value too long for type character varying(3) (SQLSTATE 22001)")
+
+	c.Query = "true"
+	e, err = parser.ParseExpr(c.Query)
+	require.NoError(t, err)
+	_, err = c.Assignment(e, testScope, "n", types.Int4)
+	require.Error(t, err)
+	assert.Equal(t, &pgerror.Error{
+		Severity: pgerror.SeverityError,
+		Code:     pgerror.CodeDatatypeMismatch,
+		Message:  `column "n" is of type integer but expression is of type boolean`,
+		Hint:     "You will need to rewrite or cast the expression.",
+		Position: 1,
+	}, err)
+}
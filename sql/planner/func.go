@@ -0,0 +1,166 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package planner
+
+import (
+	"strings"
+
+	"github.com/patrickglass/dsql/sql/executor"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+func (c *Compiler) funcCall(e *parser.FuncCall, scope *Scope) (executor.Expr, error) {
+	name := strings.TrimPrefix(e.Name, "pg_catalog.")
+	if isAggregate(name) {
+		// the calls of queries computing aggregates are compiled by grouped
+		return nil, c.ErrorAt(e, pgerror.CodeGroupingError, "aggregate functions are not allowed in this context")
+	}
+	if err := c.checkScalarCall(e, name); err != nil {
+		return nil, err
+	}
+	switch name {
+	case "coalesce", "greatest", "least":
+		if len(e.Args) == 0 {
+			break
+		}
+		args, t, err := c.combine(e.Args, scope, strings.ToUpper(name))
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case "coalesce":
+			return executor.NewCoalesce(args, t), nil
+		case "greatest":
+			return executor.NewGreatest(args, t), nil
+		}
+		return executor.NewLeast(args, t), nil
+	case "nullif":
+		if len(e.Args) != 2 {
+			break
+		}
+		args, err := c.exprs(e.Args, scope)
+		if err != nil {
+			return nil, err
+		}
+		t, ok := c.operandType(args[0], args[1], types.Text)
+		if !ok {
+			return nil, c.errOperator(e, "=", args[0], args[1])
+		}
+		left, right, err := c.convertOperands(e.Args[0], e.Args[1], args[0], args[1], t)
+		if err != nil {
+			return nil, err
+		}
+		return executor.NewNullIf(left, right), nil
+	}
+	args, err := c.exprs(e.Args, scope)
+	if err != nil {
+		return nil, err
+	}
+	return c.call(e, name, e.Args, args)
+}
+
+// checkScalarCall rejects the aggregate syntax in calls of scalar
+// functions
+func (c *Compiler) checkScalarCall(e *parser.FuncCall, name string) error {
+	var clause string
+	switch {
+	case e.Star:
+		clause = name + "(*)"
+	case e.Distinct:
+		clause = "DISTINCT"
+	case len(e.OrderBy) > 0:
+		clause = "ORDER BY"
+	case e.Filter != nil:
+		clause = "FILTER"
+	case len(e.WithinGroup) > 0:
+		clause = "WITHIN GROUP"
+	default:
+		return nil
+	}
+	return c.ErrorAt(e, pgerror.CodeWrongObjectType, "%s specified, but %s is not an aggregate function", clause, name)
+}
+
+// call compiles a call of the library function with the name, choosing the
+// overload matching most argument types exactly
+func (c *Compiler) call(node parser.Node, name string, nodes []parser.Expr, args []executor.Expr) (executor.Expr, error) {
+	var best *executor.Function
+	bestExact := -1
+	for _, f := range executor.Functions(name) {
+		if exact, ok := matchArgs(f, args); ok && exact > bestExact {
+			best, bestExact = f, exact
+		}
+	}
+	if best == nil {
+		return nil, c.errNoFunction(node, name, args)
+	}
+	ctx := types.CastImplicit
+	if best.AnyArgs {
+		ctx = types.CastExplicit
+	}
+	converted := make([]executor.Expr, len(args))
+	for i, arg := range args {
+		e, err := c.convert(nodes[i], arg, paramType(best, i), ctx)
+		if err != nil {
+			return nil, err
+		}
+		converted[i] = e
+	}
+	return executor.NewFunc(best, converted), nil
+}
+
+// errNoFunction is returned when no overload of a function takes the
+// arguments
+func (c *Compiler) errNoFunction(node parser.Node, name string, args []executor.Expr) error {
+	argTypes := make([]string, len(args))
+	for i, arg := range args {
+		argTypes[i] = arg.Type().String()
+	}
+	err := c.ErrorAt(node, pgerror.CodeUndefinedFunction, "function %s(%s) does not exist", name, strings.Join(argTypes, ", "))
+	err.Hint = "No function matches the given name and argument types. You might need to add explicit type casts."
+	return err
+}
+
+// matchArgs reports whether the function can be called with the
+// arguments and how many of them are of the parameter type
+func matchArgs(f *executor.Function, args []executor.Expr) (exact int, ok bool) {
+	n := len(f.Params)
+	if len(args) != n && !(f.Variadic && len(args) >= n-1) {
+		return 0, false
+	}
+	for i, arg := range args {
+		t := paramType(f, i)
+		switch {
+		case arg.Type().OID == t.OID:
+			exact++
+		case f.AnyArgs, types.CanCast(arg.Type(), t, types.CastImplicit):
+		default:
+			return 0, false
+		}
+	}
+	return exact, true
+}
+
+// paramType returns the type of argument i of the function
+func paramType(f *executor.Function, i int) types.Type {
+	if i >= len(f.Params) {
+		return f.Params[len(f.Params)-1]
+	}
+	return f.Params[i]
+}
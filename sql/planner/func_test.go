@@ -0,0 +1,68 @@
+package planner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/executor"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFuncCall(t *testing.T) {
+	tests := []struct {
+		query string
+		t     types.Type
+		want  interface{}
+	}{
+		{"upper(b)", types.Text, "ONE"},
+		{"pg_catalog.upper('x')", types.Text, "X"},
+		{"length(b)", types.Int4, int64(3)},
+		{"abs(-t.a)", types.Int4, int64(1)},
+		{"abs(u.a)", types.Int8, int64(10)},
+		{"abs(c)", types.Numeric, "1.5"},
+		{"round(c)", types.Numeric, "2"},
+		{"round(c, 1)", types.Numeric, "1.5"},
+		{"sqrt(t.a)", types.Float8, 1.0},
+		{"t.a ^ 2", types.Float8, 1.0},
+		{"substring(b from 2)", types.Text, "ne"},
+		{"trim(leading 'o' from b)", types.Text, "ne"},
+		{"concat(b, t.a, NULL, c)", types.Text, "one11.5"},
+		{"extract(day from interval '3 days')", types.Float8, 3.0},
+	}
+	for _, test := range tests {
+		e, err := compile(t, test.query)
+		require.NoError(t, err, test.query)
+		assert.Equal(t, test.t, e.Type(), test.query)
+		v, err := e.Eval(executor.NewEnv(context.Background()), testRow)
+		require.NoError(t, err, test.query)
+		assert.Equal(t, test.want, v, test.query)
+	}
+}
+
+func TestFuncCallErrors(t *testing.T) {
+	tests := []struct {
+		query   string
+		code    string
+		message string
+	}{
+		{"nope(1)", pgerror.CodeUndefinedFunction, "function nope(integer) does not exist"},
+		{"upper(t.a)", pgerror.CodeUndefinedFunction, "function upper(integer) does not exist"},
+		{"length(b, b)", pgerror.CodeUndefinedFunction, "function length(text, text) does not exist"},
+		{"upper(DISTINCT b)", pgerror.CodeWrongObjectType, "DISTINCT specified, but upper is not an aggregate function"},
+		{"upper(*)", pgerror.CodeWrongObjectType, "upper(*) specified, but upper is not an aggregate function"},
+		{"upper(b ORDER BY b)", pgerror.CodeWrongObjectType, "ORDER BY specified, but upper is not an aggregate function"},
+	}
+	for _, test := range tests {
+		_, err := compile(t, test.query)
+		require.Error(t, err, test.query)
+		pgErr := err.(*pgerror.Error)
+		assert.Equal(t, test.code, pgErr.Code, test.query)
+		assert.Equal(t, test.message, pgErr.Message, test.query)
+		assert.Equal(t, int32(1), pgErr.Position, test.query)
+	}
+	_, err := compile(t, "nope()")
+	assert.Equal(t, "No function matches the given name and argument types. You might need to add explicit type casts.", err.(*pgerror.Error).Hint)
+}
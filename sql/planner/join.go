@@ -0,0 +1,803 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package planner
+
+import (
+	"github.com/patrickglass/dsql/sql/executor"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// input is a planned FROM item or join of FROM items
+type input struct {
+	root  executor.Operator
+	scope *Scope
+	// ordering holds the positions of the columns the rows are sorted by
+	// in ascending order with NULLs last
+	ordering []int
+	// lateral names a table of the FROM items before a LATERAL item which
+	// the item refers to, the item then runs for each of their rows
+	lateral string
+}
+
+// joinKey is an equality of an expression of the left columns of a join
+// with an expression of the right columns
+type joinKey struct {
+	executor.JoinKey
+	// cond is the equality evaluated for the joined rows
+	cond executor.Expr
+	// leftColumn and rightColumn are the positions of the columns the
+	// keys are, -1 for other expressions
+	leftColumn, rightColumn int
+}
+
+// sides tells which inputs of a join an expression refers to
+type sides struct {
+	left, right bool
+}
+
+var joinTypes = map[parser.JoinType]executor.JoinType{
+	parser.JoinInner: executor.InnerJoin,
+	parser.JoinCross: executor.InnerJoin,
+	parser.JoinLeft:  executor.LeftJoin,
+	parser.JoinRight: executor.RightJoin,
+	parser.JoinFull:  executor.FullJoin,
+}
+
+// from plans the FROM clause and the WHERE condition, a query without
+// FROM items returns a single empty row. The items are joined from left
+// to right, inner joins as if they were listed in FROM with their ON
+// conditions in WHERE. Each condition is evaluated by the first item or
+// join of all the columns it refers to. EXISTS, NOT EXISTS and IN over
+// subqueries become semi and anti joins when the subquery allows it.
+func (c *Compiler) from(items []parser.TableExpr, where parser.Expr, outer *Scope) (*input, error) {
+	if len(items) == 0 {
+		in := &input{root: executor.NewValues([][]executor.Expr{{}}), scope: &Scope{Outer: outer}}
+		if where != nil {
+			cond, err := c.Condition(where, in.scope, "WHERE")
+			if err != nil {
+				return nil, err
+			}
+			in.root = executor.NewFilter(in.root, cond)
+		}
+		return in, nil
+	}
+
+	items, conditions := flatten(items, nil, nil)
+	for _, e := range conjuncts(where) {
+		conditions = append(conditions, condition{expr: e, clause: "WHERE", items: len(items)})
+	}
+	inputs := make([]*input, len(items))
+	scopes := make([]*Scope, len(items))
+	starts := make([]int, len(items)+1)
+	var scope *Scope
+	for i, item := range items {
+		in, err := c.tableExpr(item, outer, scope)
+		if err != nil {
+			return nil, err
+		}
+		inputs[i] = in
+		scope = joinScope(scope, in.scope, outer)
+		scopes[i], starts[i+1] = scope, len(scope.Columns)
+	}
+
+	filters := make([][]executor.Expr, len(items))
+	nodes := make([][]parser.Expr, len(items))
+	conds := make([][]executor.Expr, len(items))
+	var semi []parser.Expr
+	for _, cond := range conditions {
+		if cond.clause == "WHERE" && semiJoinable(cond.expr) {
+			semi = append(semi, cond.expr)
+			continue
+		}
+		compiled, level, single, err := c.place(cond, scopes, starts)
+		if err != nil {
+			return nil, err
+		}
+		if single && (level == 0 || inputs[level].lateral == "") {
+			// the condition only refers to the columns of the item
+			if level > 0 {
+				if compiled, err = c.Condition(cond.expr, inputs[level].scope, cond.clause); err != nil {
+					return nil, err
+				}
+			}
+			filters[level] = append(filters[level], compiled)
+			continue
+		}
+		nodes[level] = append(nodes[level], cond.expr)
+		conds[level] = append(conds[level], compiled)
+	}
+
+	for i, in := range inputs {
+		if cond := and(filters[i]); cond != nil {
+			in.root = executor.NewFilter(in.root, cond)
+		}
+	}
+	in := inputs[0]
+	for i := 1; i < len(items); i++ {
+		keys, cond, err := c.joinCondition(nodes[i], conds[i], scopes[i], starts[i], scopes[i-1], inputs[i].scope)
+		if err != nil {
+			return nil, err
+		}
+		in = c.join(executor.InnerJoin, in, inputs[i], keys, cond, scopes[i])
+	}
+	// conditions compiled in the scopes of the first items may refer to
+	// the columns of enclosing queries
+	for _, s := range scopes {
+		scope.correlated = scope.correlated || s.correlated
+	}
+	for _, e := range semi {
+		joined, err := c.semiJoin(in, e, outer)
+		if err != nil {
+			return nil, err
+		}
+		if joined != nil {
+			in = joined
+			continue
+		}
+		cond, err := c.Condition(e, in.scope, "WHERE")
+		if err != nil {
+			return nil, err
+		}
+		in.root = executor.NewFilter(in.root, cond)
+	}
+	return in, nil
+}
+
+// condition is a condition of WHERE or of the ON clause of an inner join,
+// it refers to the columns of the first items of FROM
+type condition struct {
+	expr   parser.Expr
+	clause string
+	items  int
+}
+
+// flatten appends the FROM items to flat, the items of inner joins
+// without USING or alias are appended in their place and their ON
+// conditions to conds
+func flatten(items, flat []parser.TableExpr, conds []condition) ([]parser.TableExpr, []condition) {
+	for _, item := range items {
+		j, ok := item.(*parser.JoinExpr)
+		if !ok || j.Type != parser.JoinInner && j.Type != parser.JoinCross || j.Natural || j.Using != nil || j.Alias != nil {
+			flat = append(flat, item)
+			continue
+		}
+		flat, conds = flatten([]parser.TableExpr{j.Left, j.Right}, flat, conds)
+		for _, e := range conjuncts(j.On) {
+			conds = append(conds, condition{expr: e, clause: "JOIN/ON", items: len(flat)})
+		}
+	}
+	return flat, conds
+}
+
+// place compiles a condition in the scope of the items it may refer to,
+// scopes holds the scopes of the joins of the first items and starts the
+// positions of the columns of each item. It returns the first item all
+// the columns the condition refers to belong to or follow and whether
+// they all belong to that item.
+func (c *Compiler) place(cond condition, scopes []*Scope, starts []int) (executor.Expr, int, bool, error) {
+	scope := scopes[cond.items-1]
+	var compiled executor.Expr
+	used, err := track([]*Scope{scope}, func() (err error) {
+		compiled, err = c.Condition(cond.expr, scope, cond.clause)
+		return err
+	})
+	if err != nil {
+		return nil, 0, false, err
+	}
+	first, level := -1, 0
+	for i, u := range used[0] {
+		if !u {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		for i >= starts[level+1] {
+			level++
+		}
+	}
+	return compiled, level, first < 0 || first >= starts[level], nil
+}
+
+// conjuncts returns the conditions combined by the AND operators of a
+// condition
+func conjuncts(e parser.Expr) []parser.Expr {
+	if e == nil {
+		return nil
+	}
+	if b, ok := e.(*parser.BinaryExpr); ok && b.Op == "AND" {
+		return append(conjuncts(b.Left), conjuncts(b.Right)...)
+	}
+	return []parser.Expr{e}
+}
+
+// and returns the conjunction of the conditions, nil without any
+func and(conds []executor.Expr) executor.Expr {
+	var e executor.Expr
+	for _, cond := range conds {
+		if e == nil {
+			e = cond
+		} else {
+			e = executor.NewAnd(e, cond)
+		}
+	}
+	return e
+}
+
+// joinScope returns the scope of the rows joining the rows of two scopes,
+// right when there are no left rows
+func joinScope(left, right, outer *Scope) *Scope {
+	if left == nil {
+		return right
+	}
+	s := &Scope{Outer: outer, correlated: left.correlated || right.correlated}
+	s.Columns = make([]ScopeColumn, 0, len(left.Columns)+len(right.Columns))
+	s.Columns = append(append(s.Columns, left.Columns...), right.Columns...)
+	return s
+}
+
+// tableExpr plans an item of the FROM clause, LATERAL subqueries see the
+// columns of lateral, the scope of the items before them
+func (c *Compiler) tableExpr(item parser.TableExpr, outer, lateral *Scope) (*input, error) {
+	switch item := item.(type) {
+	case *parser.TableRef:
+		t, err := c.Tables.Table(item.Name)
+		if err != nil {
+			return nil, err
+		}
+		alias := ""
+		if item.Alias != nil {
+			alias = item.Alias.Name
+		}
+		scope := TableScope(t, alias)
+		scope.Outer = outer
+		if err := c.aliasColumns(item, item.Alias, scope); err != nil {
+			return nil, err
+		}
+		return &input{root: executor.NewScan(t, alias), scope: scope}, nil
+	case *parser.SubqueryRef:
+		if item.Alias == nil {
+			err := c.ErrorAt(item, pgerror.CodeSyntaxError, "subquery in FROM must have an alias")
+			err.Hint = "For example, FROM (SELECT ...) [AS] foo."
+			return nil, err
+		}
+		in := &input{}
+		plan, err := c.lateralSelect(item, in, outer, lateral)
+		if err != nil {
+			return nil, err
+		}
+		in.root, in.ordering = plan.Root, plan.Ordering
+		in.scope = &Scope{Outer: outer, correlated: plan.Correlated && in.lateral == ""}
+		for i, col := range plan.Columns {
+			in.scope.Columns = append(in.scope.Columns, ScopeColumn{
+				Table:           item.Alias.Name,
+				Name:            col.Name,
+				Type:            plan.Types[i],
+				TableOID:        col.TableOID,
+				AttributeNumber: col.AttributeNumber,
+			})
+		}
+		if err := c.aliasColumns(item, item.Alias, in.scope); err != nil {
+			return nil, err
+		}
+		return in, nil
+	case *parser.JoinExpr:
+		return c.joinExpr(item, outer)
+	case *parser.FuncRef:
+		return nil, c.ErrorAt(item, pgerror.CodeFeatureNotSupported, "function calls are not supported in FROM")
+	}
+	return nil, c.ErrorAt(item, pgerror.CodeFeatureNotSupported, "this kind of FROM item is not supported")
+}
+
+// lateralSelect plans the query of a subquery in FROM, setting the
+// lateral table of in when it is a LATERAL subquery referring to the
+// columns of lateral
+func (c *Compiler) lateralSelect(item *parser.SubqueryRef, in *input, outer, lateral *Scope) (*Plan, error) {
+	if !item.Lateral || lateral == nil {
+		return c.Select(item.Query, outer)
+	}
+	var plan *Plan
+	used, err := track([]*Scope{lateral}, func() (err error) {
+		plan, err = c.Select(item.Query, lateral)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, u := range used[0] {
+		if u {
+			in.lateral = lateral.Columns[i].Table
+			return plan, nil
+		}
+	}
+	// the enclosing queries are one level closer without the lateral rows
+	return c.Select(item.Query, outer)
+}
+
+// joinExpr plans a JOIN of two FROM items
+func (c *Compiler) joinExpr(j *parser.JoinExpr, outer *Scope) (*input, error) {
+	left, err := c.tableExpr(j.Left, outer, nil)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.tableExpr(j.Right, outer, left.scope)
+	if err != nil {
+		return nil, err
+	}
+	typ := joinTypes[j.Type]
+	if right.lateral != "" && (typ == executor.RightJoin || typ == executor.FullJoin) {
+		err := c.ErrorAt(j.Right, pgerror.CodeInvalidColumnReference, "invalid reference to FROM-clause entry for table \"%s\"", right.lateral)
+		err.Detail = "The combining JOIN type must be INNER or LEFT for a LATERAL reference."
+		return nil, err
+	}
+	scope := joinScope(left.scope, right.scope, outer)
+	width := len(left.scope.Columns)
+
+	var in *input
+	if j.Natural || j.Using != nil {
+		names := j.Using
+		if j.Natural {
+			names = commonColumns(left.scope, right.scope)
+		}
+		keys, pairs, err := c.usingKeys(j, names, scope, width, right.scope)
+		if err != nil {
+			return nil, err
+		}
+		in = mergeUsing(c.join(typ, left, right, keys, nil, scope), typ, pairs)
+	} else {
+		var nodes []parser.Expr
+		var conds []executor.Expr
+		for _, e := range conjuncts(j.On) {
+			cond, err := c.Condition(e, scope, "JOIN/ON")
+			if err != nil {
+				return nil, err
+			}
+			nodes, conds = append(nodes, e), append(conds, cond)
+		}
+		keys, cond, err := c.joinCondition(nodes, conds, scope, width, left.scope, right.scope)
+		if err != nil {
+			return nil, err
+		}
+		in = c.join(typ, left, right, keys, cond, scope)
+	}
+	if j.Alias != nil {
+		return c.aliasJoin(j, in)
+	}
+	return in, nil
+}
+
+// joinCondition splits the conditions of a join, compiled in scope, into
+// the keys of an equi-join and the other conditions. The columns of the
+// right input start at position width of scope.
+func (c *Compiler) joinCondition(nodes []parser.Expr, conds []executor.Expr, scope *Scope, width int, left, right *Scope) ([]*joinKey, executor.Expr, error) {
+	refs := func(e parser.Expr) (sides, error) {
+		used, err := track([]*Scope{scope}, func() error {
+			_, err := c.Expr(e, scope)
+			return err
+		})
+		var s sides
+		for i, u := range used[0] {
+			if u {
+				s.left, s.right = s.left || i < width, s.right || i >= width
+			}
+		}
+		return s, err
+	}
+	var keys []*joinKey
+	var others []executor.Expr
+	for i, node := range nodes {
+		key, err := c.equiKey(node, refs, left, right)
+		if err != nil {
+			return nil, nil, err
+		}
+		if key == nil {
+			others = append(others, conds[i])
+			continue
+		}
+		key.cond = conds[i]
+		keys = append(keys, key)
+	}
+	return keys, and(others), nil
+}
+
+// equiKey returns the key of a condition comparing an expression of the
+// left columns with an expression of the right columns for equality, nil
+// for other conditions. The expressions are compiled in the scopes of the
+// inputs.
+func (c *Compiler) equiKey(e parser.Expr, refs func(parser.Expr) (sides, error), left, right *Scope) (*joinKey, error) {
+	b, ok := e.(*parser.BinaryExpr)
+	if !ok || b.Op != "=" {
+		return nil, nil
+	}
+	ls, err := refs(b.Left)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := refs(b.Right)
+	if err != nil {
+		return nil, err
+	}
+	lnode, rnode := b.Left, b.Right
+	switch {
+	case ls == sides{left: true} && rs == sides{right: true}:
+	case ls == sides{right: true} && rs == sides{left: true}:
+		lnode, rnode = rnode, lnode
+	default:
+		return nil, nil
+	}
+	return c.keyOf(lnode, rnode, left, right)
+}
+
+// keyOf compiles the expressions of a join key in the scopes of the
+// inputs
+func (c *Compiler) keyOf(lnode, rnode parser.Expr, left, right *Scope) (*joinKey, error) {
+	l, err := c.Expr(lnode, left)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.Expr(rnode, right)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := c.operandType(l, r, types.Text)
+	if !ok {
+		return nil, c.errOperator(lnode, "=", l, r)
+	}
+	if l, r, err = c.convertOperands(lnode, rnode, l, r, t); err != nil {
+		return nil, err
+	}
+	return &joinKey{
+		JoinKey:     executor.JoinKey{Left: l, Right: r},
+		leftColumn:  c.keyColumn(lnode, left, t),
+		rightColumn: c.keyColumn(rnode, right, t),
+	}, nil
+}
+
+// keyColumn returns the position of the column of scope a key of type t
+// refers to when it is the column itself, -1 otherwise
+func (c *Compiler) keyColumn(node parser.Expr, scope *Scope, t types.Type) int {
+	ref, ok := node.(*parser.ColumnRef)
+	if !ok {
+		return -1
+	}
+	s, depth, i, err := c.resolve(scope, ref)
+	if err != nil || depth > 0 || s.Columns[i].Type.OID != t.OID {
+		return -1
+	}
+	return i
+}
+
+// commonColumns returns the names of the columns of NATURAL JOIN, those
+// of the left columns which are also right columns
+func commonColumns(left, right *Scope) []string {
+	var names []string
+	for _, l := range left.Columns {
+		if l.Hidden {
+			continue
+		}
+		for _, r := range right.Columns {
+			if !r.Hidden && r.Name == l.Name {
+				names = append(names, l.Name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// usingPair is a pair of columns joined by USING
+type usingPair struct {
+	name string
+	// left and right are the columns in the joined rows converted to
+	// their common type, at positions leftIndex and rightIndex
+	left, right           executor.Expr
+	leftIndex, rightIndex int
+}
+
+// usingKeys returns the keys equating the columns of USING, the right
+// columns start at position width of scope
+func (c *Compiler) usingKeys(j *parser.JoinExpr, names []string, scope *Scope, width int, right *Scope) ([]*joinKey, []usingPair, error) {
+	keys := make([]*joinKey, len(names))
+	pairs := make([]usingPair, len(names))
+	for k, name := range names {
+		li, err := c.usingColumn(j, name, scope.Columns[:width], "left")
+		if err != nil {
+			return nil, nil, err
+		}
+		ri, err := c.usingColumn(j, name, right.Columns, "right")
+		if err != nil {
+			return nil, nil, err
+		}
+		lcol, rcol := scope.Columns[li], right.Columns[ri]
+		l := executor.NewColumn(li, lcol.qualifiedName(), lcol.Type)
+		r := executor.NewColumn(ri, rcol.qualifiedName(), rcol.Type)
+		t, ok := c.operandType(l, r, types.Text)
+		if !ok {
+			return nil, nil, c.errOperator(j, "=", l, r)
+		}
+		key := &joinKey{leftColumn: -1, rightColumn: -1}
+		if key.Left, err = c.convert(j, l, t, types.CastImplicit); err != nil {
+			return nil, nil, err
+		}
+		if key.Right, err = c.convert(j, r, t, types.CastImplicit); err != nil {
+			return nil, nil, err
+		}
+		joined, err := c.convert(j, executor.NewColumn(width+ri, rcol.qualifiedName(), rcol.Type), t, types.CastImplicit)
+		if err != nil {
+			return nil, nil, err
+		}
+		key.cond = executor.NewCompare("=", key.Left, joined)
+		if lcol.Type.OID == t.OID && rcol.Type.OID == t.OID {
+			key.leftColumn, key.rightColumn = li, ri
+		}
+		keys[k] = key
+		pairs[k] = usingPair{name: name, left: key.Left, right: joined, leftIndex: li, rightIndex: width + ri}
+	}
+	return keys, pairs, nil
+}
+
+// usingColumn returns the position of the column of USING among the
+// columns of a side of the join
+func (c *Compiler) usingColumn(j *parser.JoinExpr, name string, columns []ScopeColumn, side string) (int, error) {
+	found := -1
+	for i, col := range columns {
+		if col.Hidden || col.Name != name {
+			continue
+		}
+		if found >= 0 {
+			return -1, c.ErrorAt(j, pgerror.CodeAmbiguousColumn, "common column name \"%s\" appears more than once in %s table", name, side)
+		}
+		found = i
+	}
+	if found < 0 {
+		return -1, c.ErrorAt(j, pgerror.CodeUndefinedColumn, "column \"%s\" specified in USING clause does not exist in %s table", name, side)
+	}
+	return found, nil
+}
+
+// mergeUsing returns the rows of a join by USING with a single column for
+// each pair of columns it joins, those columns come first and hide the
+// joined columns from unqualified references
+func mergeUsing(in *input, typ executor.JoinType, pairs []usingPair) *input {
+	scope := &Scope{Outer: in.scope.Outer, correlated: in.scope.correlated}
+	var exprs []executor.Expr
+	hidden := make(map[int]bool)
+	for _, p := range pairs {
+		col := ScopeColumn{Name: p.name, Type: p.left.Type()}
+		switch typ {
+		case executor.RightJoin:
+			exprs = append(exprs, p.right)
+			col.TableOID, col.AttributeNumber = in.scope.Columns[p.rightIndex].TableOID, in.scope.Columns[p.rightIndex].AttributeNumber
+		case executor.FullJoin:
+			exprs = append(exprs, executor.NewCoalesce([]executor.Expr{p.left, p.right}, p.left.Type()))
+		default:
+			exprs = append(exprs, p.left)
+			col.TableOID, col.AttributeNumber = in.scope.Columns[p.leftIndex].TableOID, in.scope.Columns[p.leftIndex].AttributeNumber
+		}
+		scope.Columns = append(scope.Columns, col)
+		hidden[p.leftIndex], hidden[p.rightIndex] = true, true
+	}
+	for i, col := range in.scope.Columns {
+		col.Hidden = col.Hidden || hidden[i]
+		exprs = append(exprs, executor.NewColumn(i, col.qualifiedName(), col.Type))
+		scope.Columns = append(scope.Columns, col)
+	}
+	out := &input{root: executor.NewProject(in.root, exprs), scope: scope, lateral: in.lateral}
+	for _, i := range in.ordering {
+		out.ordering = append(out.ordering, i+len(pairs))
+	}
+	return out
+}
+
+// aliasJoin renames a join, the alias replaces the names of the joined
+// tables and its hidden columns can no longer be referred to
+func (c *Compiler) aliasJoin(j *parser.JoinExpr, in *input) (*input, error) {
+	scope := &Scope{Outer: in.scope.Outer, correlated: in.scope.correlated}
+	var exprs []executor.Expr
+	for i, col := range in.scope.Columns {
+		if col.Hidden {
+			continue
+		}
+		exprs = append(exprs, executor.NewColumn(i, col.qualifiedName(), col.Type))
+		col.Table = j.Alias.Name
+		scope.Columns = append(scope.Columns, col)
+	}
+	if len(exprs) < len(in.scope.Columns) {
+		in = &input{root: executor.NewProject(in.root, exprs), lateral: in.lateral}
+	}
+	in.scope = scope
+	if err := c.aliasColumns(j, j.Alias, scope); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// join plans a join of two inputs whose rows are those of scope. It is a
+// merge join for inputs sorted by the keys, a hash join for other keys
+// and a nested loop without keys or when right runs for each left row.
+func (c *Compiler) join(typ executor.JoinType, left, right *input, keys []*joinKey, cond executor.Expr, scope *Scope) *input {
+	lw, rw := len(left.scope.Columns), len(right.scope.Columns)
+	out := &input{scope: scope, lateral: left.lateral}
+	if typ != executor.RightJoin && typ != executor.FullJoin {
+		out.ordering = left.ordering
+	}
+	if len(keys) == 0 || right.lateral != "" {
+		conds := make([]executor.Expr, 0, len(keys)+1)
+		for _, k := range keys {
+			conds = append(conds, k.cond)
+		}
+		if cond != nil {
+			conds = append(conds, cond)
+		}
+		out.root = executor.NewNestedLoop(typ, left.root, right.root, lw, rw, and(conds), right.lateral != "")
+		return out
+	}
+	if merge, ok := mergeKeys(keys, left, right); ok {
+		out.root = executor.NewMergeJoin(typ, left.root, right.root, lw, rw, merge, cond)
+		return out
+	}
+	hash := make([]executor.JoinKey, len(keys))
+	for i, k := range keys {
+		hash[i] = k.JoinKey
+	}
+	out.root = executor.NewHashJoin(typ, left.root, right.root, lw, rw, hash, cond)
+	return out
+}
+
+// mergeKeys returns the keys of a merge join in the order both inputs are
+// sorted by, false when they are not sorted by the keys
+func mergeKeys(keys []*joinKey, left, right *input) ([]executor.JoinKey, bool) {
+	if len(left.ordering) < len(keys) || len(right.ordering) < len(keys) {
+		return nil, false
+	}
+	merge := make([]executor.JoinKey, len(keys))
+	for i := range keys {
+		found := false
+		for _, k := range keys {
+			if k.leftColumn == left.ordering[i] && k.rightColumn == right.ordering[i] {
+				merge[i], found = k.JoinKey, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return merge, true
+}
+
+// semiJoinable reports whether a condition of WHERE may become a semi or
+// anti join: EXISTS, NOT EXISTS or IN over a simple subquery with FROM
+func semiJoinable(e parser.Expr) bool {
+	var sub *parser.SelectStmt
+	switch e := e.(type) {
+	case *parser.ExistsExpr:
+		sub = e.Subquery
+	case *parser.UnaryExpr:
+		exists, ok := e.Expr.(*parser.ExistsExpr)
+		if !ok || e.Op != "NOT" {
+			return false
+		}
+		sub = exists.Subquery
+	case *parser.InExpr:
+		if e.Subquery == nil || e.Not {
+			return false
+		}
+		sub = e.Subquery
+		if len(sub.Targets) != 1 {
+			return false
+		}
+		if _, star := sub.Targets[0].Expr.(*parser.Star); star {
+			return false
+		}
+	default:
+		return false
+	}
+	for _, target := range sub.Targets {
+		if findAggregate(target.Expr) != nil {
+			// aggregates return a row even without rows to aggregate
+			return false
+		}
+	}
+	return sub.With == nil && sub.Op == parser.SetOpNone && sub.Values == nil && len(sub.From) > 0 &&
+		len(sub.GroupBy) == 0 && sub.Having == nil && sub.Limit == nil && sub.Offset == nil
+}
+
+// semiJoin plans a condition of WHERE accepted by semiJoinable as a join
+// of in with the FROM items of its subquery, it returns nil when the
+// subquery is not correlated by equalities with the columns of in.
+func (c *Compiler) semiJoin(in *input, e parser.Expr, outer *Scope) (*input, error) {
+	// the errors are those of the condition
+	if _, err := c.Condition(e, in.scope, "WHERE"); err != nil {
+		return nil, err
+	}
+	typ := executor.SemiJoin
+	var sub *parser.SelectStmt
+	var operand parser.Expr
+	switch e := e.(type) {
+	case *parser.ExistsExpr:
+		sub = e.Subquery
+	case *parser.UnaryExpr:
+		sub, typ = e.Expr.(*parser.ExistsExpr).Subquery, executor.AntiJoin
+	case *parser.InExpr:
+		sub, operand = e.Subquery, e.Expr
+	}
+	right, err := c.from(sub.From, nil, outer)
+	if err != nil {
+		// the FROM items of the subquery refer to the columns of in
+		return nil, nil
+	}
+	// the subquery sees the columns of in as those of its enclosing query
+	scope := &Scope{Columns: right.scope.Columns, Outer: in.scope}
+	refs := func(e parser.Expr) (sides, error) {
+		used, err := track([]*Scope{in.scope, scope}, func() error {
+			_, err := c.Expr(e, scope)
+			return err
+		})
+		var s sides
+		for _, u := range used[0] {
+			s.left = s.left || u
+		}
+		for _, u := range used[1] {
+			s.right = s.right || u
+		}
+		return s, err
+	}
+
+	var keys []*joinKey
+	var filters []executor.Expr
+	if operand != nil {
+		s, err := refs(sub.Targets[0].Expr)
+		if err != nil || s.left {
+			return nil, err
+		}
+		key, err := c.keyOf(operand, sub.Targets[0].Expr, in.scope, right.scope)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	for _, e := range conjuncts(sub.Where) {
+		s, err := refs(e)
+		if err != nil {
+			return nil, err
+		}
+		if !s.left {
+			cond, err := c.Condition(e, right.scope, "WHERE")
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, cond)
+			continue
+		}
+		key, err := c.equiKey(e, refs, in.scope, right.scope)
+		if err != nil || key == nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	if cond := and(filters); cond != nil {
+		right.root = executor.NewFilter(right.root, cond)
+	}
+	return c.join(typ, in, right, keys, nil, in.scope), nil
+}
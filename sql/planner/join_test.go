@@ -0,0 +1,121 @@
+package planner
+
+import (
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/executor"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoin(t *testing.T) {
+	tests := []struct {
+		query string
+		want  [][]interface{}
+	}{
+		{"SELECT x.b, y.b FROM t x JOIN t y ON x.a = y.a - 1 ORDER BY 1", [][]interface{}{{"a", "b"}, {"b", "c"}}},
+		{"SELECT x.b, y.b FROM t x LEFT JOIN t y ON x.a = y.a - 1 ORDER BY 1", [][]interface{}{{"a", "b"}, {"b", "c"}, {"c", nil}, {"n", nil}}},
+		{"SELECT x.b, y.b FROM t x RIGHT JOIN t y ON x.a = y.a - 1 ORDER BY 2", [][]interface{}{{nil, "a"}, {"a", "b"}, {"b", "c"}, {nil, "n"}}},
+		{"SELECT x.b, y.b FROM t x FULL JOIN t y ON x.a = y.a - 1 AND y.b <> 'c' ORDER BY 1, 2", [][]interface{}{{"a", "b"}, {"b", nil}, {"c", nil}, {"n", nil}, {nil, "a"}, {nil, "c"}, {nil, "n"}}},
+		{"SELECT x.b, y.b FROM t x JOIN t y ON x.a < y.a ORDER BY 1, 2", [][]interface{}{{"a", "b"}, {"a", "c"}, {"b", "c"}}},
+		{"SELECT x.b, y.b FROM t x, t y WHERE x.a = y.a AND x.a > 1 ORDER BY 1", [][]interface{}{{"b", "b"}, {"c", "c"}}},
+		{"SELECT x.b FROM t x CROSS JOIN t y WHERE y.a = 1 ORDER BY 1", [][]interface{}{{"a"}, {"b"}, {"c"}, {"n"}}},
+		{"SELECT * FROM t JOIN (VALUES (1, 'x'), (5, 'y')) AS v (a, c) USING (a)", [][]interface{}{{int64(1), "a", "x"}}},
+		{"SELECT a, t.a, v.a FROM t FULL JOIN (VALUES (1), (5)) AS v (a) USING (a) WHERE a <> 2 ORDER BY 1", [][]interface{}{{int64(1), int64(1), int64(1)}, {int64(3), int64(3), nil}, {int64(5), nil, int64(5)}}},
+		{"SELECT * FROM t NATURAL JOIN (VALUES (2, 'b')) AS v (a, b)", [][]interface{}{{int64(2), "b"}}},
+		{"SELECT j.b FROM (t JOIN t AS u USING (a, b)) AS j ORDER BY 1", [][]interface{}{{"a"}, {"b"}, {"c"}}},
+		{"SELECT x.b, y.n FROM t x, LATERAL (SELECT x.a * 10 AS n) y WHERE x.a < 3 ORDER BY 1", [][]interface{}{{"a", int64(10)}, {"b", int64(20)}}},
+		{"SELECT x.b, y.n FROM t x LEFT JOIN LATERAL (SELECT u.a AS n FROM t u WHERE u.a > x.a) y ON true ORDER BY 1, 2", [][]interface{}{{"a", int64(2)}, {"a", int64(3)}, {"b", int64(3)}, {"c", nil}, {"n", nil}}},
+		{"SELECT b FROM t x WHERE EXISTS (SELECT 1 FROM t y WHERE y.a = x.a + 1 AND y.b <> 'z') ORDER BY b", [][]interface{}{{"a"}, {"b"}}},
+		{"SELECT b FROM t x WHERE NOT EXISTS (SELECT FROM t y WHERE x.a + 1 = y.a) ORDER BY b", [][]interface{}{{"c"}, {"n"}}},
+		{"SELECT b FROM t WHERE a IN (SELECT a + 1 FROM t) ORDER BY b", [][]interface{}{{"b"}, {"c"}}},
+		{"SELECT b FROM t x WHERE EXISTS (SELECT 1 FROM t y WHERE y.a > x.a) ORDER BY b", [][]interface{}{{"a"}, {"b"}}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, run(t, test.query), test.query)
+	}
+}
+
+func TestJoinStrategy(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"SELECT * FROM t x JOIN t y ON x.a = y.a", []string{
+			"Hash Join",
+			"  Hash Cond: (x.a = y.a)",
+			"  ->  Seq Scan on t x",
+			"  ->  Hash",
+			"        ->  Seq Scan on t y",
+		}},
+		{"SELECT * FROM t x, t y WHERE y.a = x.a AND x.a > 1 AND x.b <> y.b", []string{
+			"Hash Join",
+			"  Hash Cond: (x.a = y.a)",
+			"  Join Filter: (x.b <> y.b)",
+			"  ->  Seq Scan on t x",
+			"        Filter: (x.a > 1)",
+			"  ->  Hash",
+			"        ->  Seq Scan on t y",
+		}},
+		{"SELECT * FROM (SELECT a FROM t ORDER BY a) x LEFT JOIN (SELECT a FROM t ORDER BY 1) y ON x.a = y.a", []string{
+			"Merge Left Join",
+			"  Merge Cond: (x.a = y.a)",
+			"  ->  Sort",
+			"        Sort Key: a",
+			"        ->  Seq Scan on t",
+			"  ->  Sort",
+			"        Sort Key: a",
+			"        ->  Seq Scan on t",
+		}},
+		{"SELECT * FROM t x JOIN t y ON x.a < y.a", []string{
+			"Nested Loop",
+			"  Join Filter: (x.a < y.a)",
+			"  ->  Seq Scan on t x",
+			"  ->  Seq Scan on t y",
+		}},
+		{"SELECT * FROM t x, LATERAL (SELECT x.a) y", []string{
+			"Nested Loop",
+			"  ->  Seq Scan on t x",
+			"  ->  Result",
+		}},
+		{"SELECT * FROM t x WHERE NOT EXISTS (SELECT FROM t y WHERE y.a = x.a)", []string{
+			"Hash Anti Join",
+			"  Hash Cond: (x.a = y.a)",
+			"  ->  Seq Scan on t x",
+			"  ->  Hash",
+			"        ->  Seq Scan on t y",
+		}},
+	}
+	for _, test := range tests {
+		p, err := plan(t, test.query)
+		require.NoError(t, err, test.query)
+		assert.Equal(t, test.want, executor.Explain(p.Root), test.query)
+	}
+}
+
+func TestJoinErrors(t *testing.T) {
+	tests := []struct {
+		query    string
+		code     string
+		message  string
+		position int32
+	}{
+		{"SELECT * FROM t JOIN t AS u USING (c)", pgerror.CodeUndefinedColumn, `column "c" specified in USING clause does not exist in left table`, 17},
+		{"SELECT * FROM t JOIN (t AS u JOIN t AS v ON true) USING (a)", pgerror.CodeAmbiguousColumn, `common column name "a" appears more than once in right table`, 17},
+		{"SELECT t.a FROM (t JOIN t AS u USING (a)) AS j", pgerror.CodeUndefinedTable, `missing FROM-clause entry for table "t"`, 8},
+		{"SELECT * FROM t x JOIN t y ON x.a", pgerror.CodeDatatypeMismatch, "argument of JOIN/ON must be type boolean, not type integer", 31},
+		{"SELECT * FROM t x RIGHT JOIN LATERAL (SELECT x.a) y ON true", pgerror.CodeInvalidColumnReference, `invalid reference to FROM-clause entry for table "x"`, 30},
+		{"SELECT * FROM t x, (SELECT x.a) y", pgerror.CodeUndefinedTable, `missing FROM-clause entry for table "x"`, 28},
+		{"SELECT * FROM t x WHERE EXISTS (SELECT FROM t y WHERE y.c = x.a)", pgerror.CodeUndefinedColumn, "column y.c does not exist", 55},
+		{"SELECT * FROM t, generate_series(1, 5) g", pgerror.CodeFeatureNotSupported, "function calls are not supported in FROM", 18},
+	}
+	for _, test := range tests {
+		_, err := plan(t, test.query)
+		require.Error(t, err, test.query)
+		pgErr := err.(*pgerror.Error)
+		assert.Equal(t, test.code, pgErr.Code, test.query)
+		assert.Equal(t, test.message, pgErr.Message, test.query)
+		assert.Equal(t, test.position, pgErr.Position, test.query)
+	}
+}
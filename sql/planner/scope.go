@@ -0,0 +1,169 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package planner
+
+import (
+	"github.com/patrickglass/dsql/sql/catalog"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// Scope holds the columns of the rows expressions are evaluated against,
+// column references compile to positions in these rows.
+type Scope struct {
+	Columns []ScopeColumn
+	// Outer is the scope of the query enclosing a subquery, its columns
+	// are visible to the subquery
+	Outer *Scope
+	// correlated is set when expressions of the scope refer to columns of
+	// outer scopes
+	correlated bool
+	// track records the columns expressions refer to while it is set
+	track []bool
+	// agg is set for the scope of the groups of a query, its expressions
+	// may only refer to the columns of the input grouped by
+	agg *aggregation
+}
+
+// ScopeColumn is a column visible to expressions
+type ScopeColumn struct {
+	// Table is the name or alias of the table the column belongs to
+	Table string
+	Name  string
+	Type  types.Type
+	// TableOID and AttributeNumber identify the columns of tables in the
+	// description of a result, they are 0 for computed columns
+	TableOID        uint32
+	AttributeNumber uint16
+	// Hidden columns are only visible to qualified references, they are
+	// the columns of the tables joined by USING replaced by a single column
+	Hidden bool
+}
+
+// qualifiedName returns the name of the column qualified with its table
+func (c ScopeColumn) qualifiedName() string {
+	if c.Table == "" {
+		return c.Name
+	}
+	return c.Table + "." + c.Name
+}
+
+// TableScope returns the scope of the rows of a table, referred to by
+// alias when it is not empty
+func TableScope(t *catalog.Table, alias string) *Scope {
+	if alias == "" {
+		alias = t.Name
+	}
+	s := &Scope{Columns: make([]ScopeColumn, len(t.Columns))}
+	for i, c := range t.Columns {
+		s.Columns[i] = ScopeColumn{
+			Table:           alias,
+			Name:            c.Name,
+			Type:            c.Type,
+			TableOID:        t.OID,
+			AttributeNumber: uint16(i + 1),
+		}
+	}
+	return s
+}
+
+// Column returns the description of column i in a result
+func (s *Scope) Column(i int) pgtype.Column {
+	c := s.Columns[i]
+	col := c.Type.Column(c.Name)
+	col.TableOID = c.TableOID
+	col.AttributeNumber = c.AttributeNumber
+	return col
+}
+
+// track calls compile and returns the columns of each scope the
+// expressions it compiles refer to
+func track(scopes []*Scope, compile func() error) ([][]bool, error) {
+	used := make([][]bool, len(scopes))
+	for i, s := range scopes {
+		used[i] = make([]bool, len(s.Columns))
+		s.track = used[i]
+	}
+	defer func() {
+		for _, s := range scopes {
+			s.track = nil
+		}
+	}()
+	return used, compile()
+}
+
+// resolve returns the scope of the referenced column, how many scopes out
+// it is and its position. The scopes between are marked correlated.
+func (c *Compiler) resolve(scope *Scope, ref *parser.ColumnRef) (*Scope, int, int, error) {
+	depth := 0
+	for s := scope; s != nil; s, depth = s.Outer, depth+1 {
+		i, tableFound, err := c.lookup(s, ref)
+		if err != nil {
+			return nil, 0, -1, err
+		}
+		if i >= 0 {
+			for inner := scope; inner != s; inner = inner.Outer {
+				inner.correlated = true
+			}
+			if s.track != nil {
+				s.track[i] = true
+			}
+			return s, depth, i, nil
+		}
+		if s.agg != nil && depth > 0 {
+			if j, _, _ := c.lookup(s.agg.input, ref); j >= 0 {
+				return nil, 0, -1, c.ErrorAt(ref, pgerror.CodeGroupingError, "subquery uses ungrouped column \"%s\" from outer query", s.agg.input.Columns[j].qualifiedName())
+			}
+		}
+		if tableFound {
+			// qualified names refer to the nearest table with the name
+			return nil, 0, -1, c.ErrorAt(ref, pgerror.CodeUndefinedColumn, "column %s.%s does not exist", ref.Table, ref.Name)
+		}
+	}
+	if ref.Table != "" {
+		return nil, 0, -1, c.ErrorAt(ref, pgerror.CodeUndefinedTable, "missing FROM-clause entry for table \"%s\"", ref.Table)
+	}
+	return nil, 0, -1, c.ErrorAt(ref, pgerror.CodeUndefinedColumn, "column \"%s\" does not exist", ref.Name)
+}
+
+// lookup returns the position of the referenced column in the scope, -1
+// when it has no such column. tableFound reports whether it has a table
+// with the name the reference is qualified with.
+func (c *Compiler) lookup(scope *Scope, ref *parser.ColumnRef) (index int, tableFound bool, err error) {
+	index = -1
+	for i, col := range scope.Columns {
+		if ref.Table != "" {
+			if col.Table != ref.Table {
+				continue
+			}
+			tableFound = true
+		} else if col.Hidden {
+			continue
+		}
+		if col.Name != ref.Name {
+			continue
+		}
+		if index >= 0 {
+			return -1, false, c.ErrorAt(ref, pgerror.CodeAmbiguousColumn, "column reference \"%s\" is ambiguous", ref.Name)
+		}
+		index = i
+	}
+	return index, tableFound, nil
+}
@@ -0,0 +1,319 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package planner
+
+import (
+	"strconv"
+
+	"github.com/patrickglass/dsql/sql/executor"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/types"
+)
+
+// Plan is a planned query
+type Plan struct {
+	// Root returns the rows of the query
+	Root executor.Operator
+	// Columns describe the columns of the rows and Types are their types
+	Columns []pgtype.Column
+	Types   []types.Type
+	// Correlated is set when the query refers to columns of the queries
+	// enclosing it
+	Correlated bool
+	// Ordering holds the positions of the columns the rows are sorted by
+	// in ascending order with NULLs last
+	Ordering []int
+}
+
+// Select plans a query, outer is the scope of the enclosing query of a
+// subquery and nil otherwise
+func (c *Compiler) Select(stmt *parser.SelectStmt, outer *Scope) (*Plan, error) {
+	switch {
+	case stmt.With != nil:
+		return nil, c.ErrorAt(stmt.With, pgerror.CodeFeatureNotSupported, "WITH is not supported")
+	case stmt.Op != parser.SetOpNone:
+		return nil, c.ErrorAt(stmt, pgerror.CodeFeatureNotSupported, "%s is not supported", stmt.Op)
+	}
+	var q *query
+	var err error
+	if stmt.Values != nil {
+		q, err = c.values(stmt.Values, outer)
+	} else {
+		q, err = c.selectList(stmt, outer)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := c.order(q, stmt); err != nil {
+		return nil, err
+	}
+	if err := c.limit(q, stmt); err != nil {
+		return nil, err
+	}
+	if len(q.exprs) > len(q.columns) {
+		// drop the columns only sorted by
+		trimmed := make([]executor.Expr, len(q.columns))
+		for i := range trimmed {
+			trimmed[i] = executor.NewColumn(i, q.columns[i].Name, q.exprs[i].Type())
+		}
+		q.root = executor.NewProject(q.root, trimmed)
+	}
+	plan := &Plan{Root: q.root, Columns: q.columns, Correlated: q.scope.correlated, Ordering: q.ordering}
+	for i := range q.columns {
+		plan.Types = append(plan.Types, q.exprs[i].Type())
+	}
+	return plan, nil
+}
+
+// query is a query being planned. Until it is projected root returns the
+// rows of scope, then the rows of exprs whose leading expressions are the
+// result columns.
+type query struct {
+	root    executor.Operator
+	scope   *Scope
+	exprs   []executor.Expr
+	columns []pgtype.Column
+	// projected is set once root returns the values of exprs
+	projected bool
+	// ordering holds the positions of the result columns the rows are
+	// sorted by in ascending order with NULLs last
+	ordering []int
+}
+
+// project adds the projection of exprs to the plan
+func (q *query) project() {
+	if !q.projected {
+		q.root = executor.NewProject(q.root, q.exprs)
+		q.projected = true
+	}
+}
+
+// values plans a VALUES list, its columns are named column1, column2 and
+// so on
+func (c *Compiler) values(rows [][]parser.Expr, outer *Scope) (*query, error) {
+	scope := &Scope{Outer: outer}
+	exprs := make([][]executor.Expr, len(rows))
+	for i, row := range rows {
+		var err error
+		if exprs[i], err = c.exprs(row, scope); err != nil {
+			return nil, err
+		}
+	}
+	q := &query{scope: &Scope{Outer: outer}}
+	column := make([]parser.Expr, len(rows))
+	compiled := make([]executor.Expr, len(rows))
+	for j := range rows[0] {
+		for i := range rows {
+			column[i], compiled[i] = rows[i][j], exprs[i][j]
+		}
+		t, err := c.commonType(column, compiled, "VALUES")
+		if err != nil {
+			return nil, err
+		}
+		if err := c.convertAll(column, compiled, t); err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			exprs[i][j] = compiled[i]
+		}
+		name := "column" + strconv.Itoa(j+1)
+		q.scope.Columns = append(q.scope.Columns, ScopeColumn{Name: name, Type: t})
+		q.exprs = append(q.exprs, executor.NewColumn(j, name, t))
+		q.columns = append(q.columns, t.Column(name))
+	}
+	q.scope.correlated = scope.correlated
+	q.root = executor.NewValues(exprs)
+	return q, nil
+}
+
+// selectList plans the FROM, WHERE, GROUP BY, HAVING and select list of a
+// query
+func (c *Compiler) selectList(stmt *parser.SelectStmt, outer *Scope) (*query, error) {
+	in, err := c.from(stmt.From, stmt.Where, outer)
+	if err != nil {
+		return nil, err
+	}
+	q, err := c.aggregate(stmt, in)
+	if err != nil {
+		return nil, err
+	}
+	if q == nil {
+		q = &query{root: in.root, scope: in.scope}
+	}
+	p, err := c.Projection(stmt.Targets, q.scope)
+	if err != nil {
+		return nil, err
+	}
+	q.exprs, q.columns = p.Exprs, p.Columns
+	return q, nil
+}
+
+// aliasColumns renames the leading columns of the scope to the column
+// aliases
+func (c *Compiler) aliasColumns(item parser.TableExpr, alias *parser.Alias, scope *Scope) error {
+	if alias == nil {
+		return nil
+	}
+	if len(alias.Columns) > len(scope.Columns) {
+		return c.ErrorAt(item, pgerror.CodeInvalidColumnReference, "table \"%s\" has %d columns available but %d columns specified", alias.Name, len(scope.Columns), len(alias.Columns))
+	}
+	for i, name := range alias.Columns {
+		scope.Columns[i].Name = name
+	}
+	return nil
+}
+
+// order plans ORDER BY, DISTINCT and DISTINCT ON
+func (c *Compiler) order(q *query, stmt *parser.SelectStmt) error {
+	keys := make([]executor.SortKey, len(stmt.OrderBy))
+	indexes := make([]int, len(stmt.OrderBy))
+	for i, item := range stmt.OrderBy {
+		j, err := c.sortColumn(q, item.Expr, stmt.Distinct && stmt.DistinctOn == nil)
+		if err != nil {
+			return err
+		}
+		indexes[i] = j
+		keys[i] = executor.SortKey{
+			Expr:       executor.NewColumn(j, q.exprs[j].String(), q.exprs[j].Type()),
+			Desc:       item.Desc,
+			NullsFirst: item.Desc,
+		}
+		if item.NullsFirst != nil {
+			keys[i].NullsFirst = *item.NullsFirst
+		}
+	}
+
+	var distinct []executor.Expr
+	switch {
+	case stmt.DistinctOn != nil:
+		on := make(map[int]bool)
+		for _, e := range stmt.DistinctOn {
+			j, err := c.sortColumn(q, e, false)
+			if err != nil {
+				return err
+			}
+			on[j] = true
+			distinct = append(distinct, executor.NewColumn(j, q.exprs[j].String(), q.exprs[j].Type()))
+		}
+		for i, j := range indexes {
+			if i < len(stmt.DistinctOn) && !on[j] {
+				return c.ErrorAt(stmt.OrderBy[i].Expr, pgerror.CodeInvalidColumnReference, "SELECT DISTINCT ON expressions must match initial ORDER BY expressions")
+			}
+		}
+	case stmt.Distinct:
+		for i, col := range q.columns {
+			distinct = append(distinct, executor.NewColumn(i, col.Name, q.exprs[i].Type()))
+		}
+	}
+
+	q.project()
+	if len(keys) > 0 {
+		q.root = executor.NewSort(q.root, keys)
+		for i, k := range keys {
+			if k.Desc || k.NullsFirst || indexes[i] >= len(q.columns) {
+				break
+			}
+			q.ordering = append(q.ordering, indexes[i])
+		}
+	}
+	if distinct != nil {
+		q.root = executor.NewDistinct(q.root, distinct)
+	}
+	return nil
+}
+
+// sortColumn returns the position of the value an ORDER BY or DISTINCT ON
+// expression sorts by in the rows of the projection, adding the
+// expression to it unless it is a result column. Integer constants are
+// positions of result columns and names refer to result columns before
+// the columns of the input.
+func (c *Compiler) sortColumn(q *query, e parser.Expr, inSelectList bool) (int, error) {
+	if lit, ok := e.(*parser.Literal); ok && lit.Kind == parser.LiteralInteger {
+		n, err := strconv.ParseInt(lit.Value, 10, 64)
+		if err != nil || n < 1 || n > int64(len(q.columns)) {
+			return 0, c.ErrorAt(e, pgerror.CodeInvalidColumnReference, "ORDER BY position %s is not in select list", lit.Value)
+		}
+		return int(n - 1), nil
+	}
+	if ref, ok := e.(*parser.ColumnRef); ok && ref.Table == "" {
+		found := -1
+		for i, col := range q.columns {
+			if col.Name != ref.Name {
+				continue
+			}
+			if found >= 0 && q.exprs[found].String() != q.exprs[i].String() {
+				return 0, c.ErrorAt(e, pgerror.CodeAmbiguousColumn, "ORDER BY \"%s\" is ambiguous", ref.Name)
+			}
+			if found < 0 {
+				found = i
+			}
+		}
+		if found >= 0 {
+			return found, nil
+		}
+	}
+	compiled, err := c.Expr(e, q.scope)
+	if err != nil {
+		return 0, err
+	}
+	for i, existing := range q.exprs {
+		if existing.String() == compiled.String() && existing.Type() == compiled.Type() {
+			return i, nil
+		}
+	}
+	if inSelectList {
+		return 0, c.ErrorAt(e, pgerror.CodeInvalidColumnReference, "for SELECT DISTINCT, ORDER BY expressions must appear in select list")
+	}
+	q.exprs = append(q.exprs, compiled)
+	return len(q.exprs) - 1, nil
+}
+
+// limit plans LIMIT and OFFSET, their arguments cannot refer to columns
+func (c *Compiler) limit(q *query, stmt *parser.SelectStmt) error {
+	if stmt.Limit == nil && stmt.Offset == nil {
+		return nil
+	}
+	count, err := c.limitArg(stmt.Limit, "LIMIT")
+	if err != nil {
+		return err
+	}
+	offset, err := c.limitArg(stmt.Offset, "OFFSET")
+	if err != nil {
+		return err
+	}
+	q.project()
+	q.root = executor.NewLimit(q.root, count, offset)
+	return nil
+}
+
+func (c *Compiler) limitArg(e parser.Expr, clause string) (executor.Expr, error) {
+	if e == nil {
+		return nil, nil
+	}
+	compiled, err := c.Expr(e, &Scope{})
+	if err != nil {
+		return nil, err
+	}
+	t := compiled.Type()
+	if t.Category() != types.CategoryNumeric && t.OID != types.UnknownOID {
+		return nil, c.ErrorAt(e, pgerror.CodeDatatypeMismatch, "argument of %s must be type bigint, not type %s", clause, t)
+	}
+	return c.convert(e, compiled, types.Int8, types.CastAssignment)
+}
@@ -0,0 +1,160 @@
+package planner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/catalog"
+	"github.com/patrickglass/dsql/sql/executor"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/patrickglass/dsql/sql/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTables resolves table names in a catalog
+type testTables struct {
+	catalog *catalog.Catalog
+}
+
+func (tt testTables) Table(name *parser.ObjectName) (*catalog.Table, error) {
+	if t, ok := tt.catalog.Table(catalog.DefaultSchema, name.Name); ok {
+		return t, nil
+	}
+	return nil, pgerror.NewError(pgerror.CodeUndefinedTable, "relation \"%s\" does not exist", name.Name)
+}
+
+// newTestTables returns a table t with the columns a integer and b text
+func newTestTables(t *testing.T) testTables {
+	c := catalog.New()
+	table, err := c.CreateTable(catalog.DefaultSchema, "t", []*catalog.Column{
+		{Name: "a", Type: types.Int4},
+		{Name: "b", Type: types.Text},
+	}, nil)
+	require.NoError(t, err)
+	tx := table.Begin()
+	for _, row := range []catalog.Row{{int64(3), "c"}, {int64(1), "a"}, {nil, "n"}, {int64(2), "b"}} {
+		require.NoError(t, tx.Insert(row))
+	}
+	require.NoError(t, tx.Commit())
+	return testTables{catalog: c}
+}
+
+// plan plans the query against the test tables
+func plan(t *testing.T, query string) (*Plan, error) {
+	stmt, err := parser.ParseOne(query)
+	require.NoError(t, err, query)
+	c := &Compiler{Query: query, Args: []interface{}{}, Tables: newTestTables(t)}
+	return c.Select(stmt.(*parser.SelectStmt), nil)
+}
+
+// run plans the query and returns its rows
+func run(t *testing.T, query string) [][]interface{} {
+	p, err := plan(t, query)
+	require.NoError(t, err, query)
+	require.NoError(t, p.Root.Open(executor.NewEnv(context.Background())), query)
+	var rows [][]interface{}
+	for {
+		row, err := p.Root.Next()
+		require.NoError(t, err, query)
+		if row == nil {
+			break
+		}
+		assert.Len(t, row, len(p.Columns), query)
+		rows = append(rows, row)
+	}
+	require.NoError(t, p.Root.Close())
+	return rows
+}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		query string
+		want  [][]interface{}
+	}{
+		{"SELECT a FROM t WHERE a > 1", [][]interface{}{{int64(3)}, {int64(2)}}},
+		{"SELECT b FROM t ORDER BY a", [][]interface{}{{"a"}, {"b"}, {"c"}, {"n"}}},
+		{"SELECT b FROM t ORDER BY a DESC", [][]interface{}{{"n"}, {"c"}, {"b"}, {"a"}}},
+		{"SELECT b AS a FROM t ORDER BY a", [][]interface{}{{"a"}, {"b"}, {"c"}, {"n"}}},
+		{"SELECT b FROM t ORDER BY -a LIMIT 2", [][]interface{}{{"c"}, {"b"}}},
+		{"SELECT a, b FROM t ORDER BY 2 DESC OFFSET 3", [][]interface{}{{int64(1), "a"}}},
+		{"SELECT DISTINCT a > 1 FROM t ORDER BY 1", [][]interface{}{{false}, {true}, {nil}}},
+		{"SELECT x.y FROM t AS x (y) WHERE x.y = 2", [][]interface{}{{int64(2)}}},
+		{"SELECT s.b FROM (SELECT b FROM t WHERE a < 3) AS s ORDER BY b", [][]interface{}{{"a"}, {"b"}}},
+		{"SELECT 1", [][]interface{}{{int64(1)}}},
+		{"VALUES (1, 'x'), (2, 'y') ORDER BY column1 DESC LIMIT 1", [][]interface{}{{int64(2), "y"}}},
+		{"SELECT b FROM t WHERE a = (SELECT max FROM (VALUES (1)) AS v (max))", [][]interface{}{{"a"}}},
+		{"SELECT b FROM t AS o WHERE EXISTS (SELECT 1 FROM t WHERE t.a = o.a + 1) ORDER BY b", [][]interface{}{{"a"}, {"b"}}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, run(t, test.query), test.query)
+	}
+}
+
+func TestSelectColumns(t *testing.T) {
+	p, err := plan(t, "SELECT a, b AS name, a + 1, x.* FROM t AS x ORDER BY b")
+	require.NoError(t, err)
+	table, _ := newTestTables(t).Table(&parser.ObjectName{Name: "t"})
+	assert.Equal(t, []pgtype.Column{
+		{Name: "a", TypeOID: pgtype.Int4OID, TypeSize: 4, TypeModifier: -1, TableOID: table.OID, AttributeNumber: 1},
+		{Name: "name", TypeOID: pgtype.TextOID, TypeSize: -1, TypeModifier: -1, TableOID: table.OID, AttributeNumber: 2},
+		{Name: "?column?", TypeOID: pgtype.Int4OID, TypeSize: 4, TypeModifier: -1},
+		{Name: "a", TypeOID: pgtype.Int4OID, TypeSize: 4, TypeModifier: -1, TableOID: table.OID, AttributeNumber: 1},
+		{Name: "b", TypeOID: pgtype.TextOID, TypeSize: -1, TypeModifier: -1, TableOID: table.OID, AttributeNumber: 2},
+	}, p.Columns)
+	assert.Equal(t, []types.Type{types.Int4, types.Text, {OID: pgtype.Int4OID, Modifier: -1}, types.Int4, types.Text}, p.Types)
+	assert.False(t, p.Correlated)
+
+	p, err = plan(t, "VALUES (1, NULL), (2.5, 'x')")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"column1", "column2"}, []string{p.Columns[0].Name, p.Columns[1].Name})
+	assert.Equal(t, []types.Type{types.Numeric, types.Text}, p.Types)
+}
+
+func TestSelectCorrelated(t *testing.T) {
+	c := &Compiler{Query: "SELECT o.a", Tables: newTestTables(t)}
+	stmt, err := parser.ParseOne(c.Query)
+	require.NoError(t, err)
+	outer := &Scope{Columns: []ScopeColumn{{Table: "o", Name: "a", Type: types.Int4}}}
+	p, err := c.Select(stmt.(*parser.SelectStmt), outer)
+	require.NoError(t, err)
+	assert.True(t, p.Correlated)
+
+	p, err = c.Select(stmt.(*parser.SelectStmt), &Scope{Outer: outer})
+	require.NoError(t, err)
+	assert.True(t, p.Correlated)
+}
+
+func TestSelectErrors(t *testing.T) {
+	tests := []struct {
+		query    string
+		code     string
+		message  string
+		position int32
+	}{
+		{"SELECT * FROM nope", pgerror.CodeUndefinedTable, `relation "nope" does not exist`, 0},
+		{"SELECT c FROM t", pgerror.CodeUndefinedColumn, `column "c" does not exist`, 8},
+		{"SELECT a FROM t WHERE b", pgerror.CodeDatatypeMismatch, "argument of WHERE must be type boolean, not type text", 23},
+		{"SELECT a FROM t ORDER BY 0", pgerror.CodeInvalidColumnReference, "ORDER BY position 0 is not in select list", 26},
+		{"SELECT a AS x, b AS x FROM t ORDER BY x", pgerror.CodeAmbiguousColumn, `ORDER BY "x" is ambiguous`, 39},
+		{"SELECT DISTINCT a FROM t ORDER BY b", pgerror.CodeInvalidColumnReference, "for SELECT DISTINCT, ORDER BY expressions must appear in select list", 35},
+		{"SELECT a FROM t LIMIT a", pgerror.CodeUndefinedColumn, `column "a" does not exist`, 23},
+		{"SELECT a FROM t LIMIT true", pgerror.CodeDatatypeMismatch, "argument of LIMIT must be type bigint, not type boolean", 23},
+		{"SELECT a FROM t AS x (y, z, w)", pgerror.CodeInvalidColumnReference, `table "x" has 2 columns available but 3 columns specified`, 15},
+		{"SELECT a FROM t, t AS u", pgerror.CodeAmbiguousColumn, "column reference \"a\" is ambiguous", 8},
+		{"SELECT b FROM t GROUP BY a", pgerror.CodeGroupingError, `column "t.b" must appear in the GROUP BY clause or be used in an aggregate function`, 8},
+		{"WITH x AS (SELECT 1) SELECT 1", pgerror.CodeFeatureNotSupported, "WITH is not supported", 1},
+		{"SELECT 1 UNION SELECT 2", pgerror.CodeFeatureNotSupported, "UNION is not supported", 1},
+		{"VALUES (1), ('a' || 'b')", pgerror.CodeDatatypeMismatch, "VALUES types integer and text cannot be matched", 18},
+	}
+	for _, test := range tests {
+		_, err := plan(t, test.query)
+		require.Error(t, err, test.query)
+		pgErr := err.(*pgerror.Error)
+		assert.Equal(t, test.code, pgErr.Code, test.query)
+		assert.Equal(t, test.message, pgErr.Message, test.query)
+		assert.Equal(t, test.position, pgErr.Position, test.query)
+	}
+}
@@ -0,0 +1,129 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package planner
+
+import (
+	"strings"
+
+	"github.com/patrickglass/dsql/sql/executor"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+)
+
+// Projection is a compiled select or RETURNING list
+type Projection struct {
+	Exprs   []executor.Expr
+	Columns []pgtype.Column
+}
+
+// Projection compiles the targets against the scope, expanding * to the
+// columns of the scope
+func (c *Compiler) Projection(targets []*parser.ResultTarget, scope *Scope) (*Projection, error) {
+	p := &Projection{}
+	for _, target := range targets {
+		if star, ok := target.Expr.(*parser.Star); ok {
+			if err := c.expandStar(p, star, scope); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		e, err := c.Expr(target.Expr, scope)
+		if err != nil {
+			return nil, err
+		}
+		name := target.Alias
+		if name == "" {
+			name = TargetName(target.Expr)
+		}
+		var col pgtype.Column
+		if ref, ok := target.Expr.(*parser.ColumnRef); ok {
+			s, _, i, _ := c.resolve(scope, ref)
+			col = s.Column(i)
+			col.Name = name
+		} else {
+			col = e.Type().Column(name)
+		}
+		p.Exprs = append(p.Exprs, e)
+		p.Columns = append(p.Columns, col)
+	}
+	return p, nil
+}
+
+func (c *Compiler) expandStar(p *Projection, star *parser.Star, scope *Scope) error {
+	source := scope
+	if scope.agg != nil {
+		// the columns of the groups are the input columns grouped by
+		source = scope.agg.input
+	}
+	found := false
+	for i, col := range source.Columns {
+		if star.Table != "" && col.Table != star.Table || star.Table == "" && col.Hidden {
+			continue
+		}
+		found = true
+		e := executor.NewColumn(i, col.Name, col.Type)
+		if scope.agg != nil {
+			var err error
+			if e, err = c.groupedColumn(star, scope.agg, i); err != nil {
+				return err
+			}
+		}
+		p.Exprs = append(p.Exprs, e)
+		p.Columns = append(p.Columns, source.Column(i))
+	}
+	switch {
+	case star.Table != "" && !found:
+		return c.ErrorAt(star, pgerror.CodeUndefinedTable, "missing FROM-clause entry for table \"%s\"", star.Table)
+	case !found:
+		return c.ErrorAt(star, pgerror.CodeSyntaxError, "SELECT * with no tables specified is not valid")
+	}
+	return nil
+}
+
+// TargetName returns the name of the result column of an expression
+// without an alias, ?column? when it has none
+func TargetName(e parser.Expr) string {
+	switch e := e.(type) {
+	case *parser.ColumnRef:
+		return e.Name
+	case *parser.FuncCall:
+		return e.Name[strings.LastIndex(e.Name, ".")+1:]
+	case *parser.CaseExpr:
+		return "case"
+	case *parser.CastExpr:
+		if name := TargetName(e.Expr); name != "?column?" {
+			return name
+		}
+		return e.Type.Name
+	}
+	return "?column?"
+}
+
+// Eval returns the values of the projection for the row
+func (p *Projection) Eval(env *executor.Env, row executor.Row) ([]interface{}, error) {
+	values := make([]interface{}, len(p.Exprs))
+	for i, e := range p.Exprs {
+		v, err := e.Eval(env, row)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
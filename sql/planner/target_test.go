@@ -0,0 +1,55 @@
+package planner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/executor"
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// projection compiles the RETURNING list of a DELETE statement
+func projection(t *testing.T, targets string) (*Projection, error) {
+	query := "delete from t returning " + targets
+	stmt, err := parser.ParseOne(query)
+	require.NoError(t, err)
+	c := &Compiler{Query: query, Args: []interface{}{}}
+	return c.Projection(stmt.(*parser.DeleteStmt).Returning, testScope)
+}
+
+func TestProjection(t *testing.T) {
+	p, err := projection(t, "t.a, b AS name, c + 1, '1'::int4, b::varchar")
+	require.NoError(t, err)
+	var names []string
+	for _, col := range p.Columns {
+		names = append(names, col.Name)
+	}
+	assert.Equal(t, []string{"a", "name", "?column?", "int4", "b"}, names)
+	assert.Equal(t, uint32(1), p.Columns[0].TableOID, "columns of tables are described with their table")
+	assert.Equal(t, uint16(1), p.Columns[0].AttributeNumber)
+	assert.Equal(t, uint32(0), p.Columns[2].TableOID)
+
+	values, err := p.Eval(executor.NewEnv(context.Background()), testRow)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{int64(1), "one", "2.5", int64(1), "one"}, values)
+}
+
+func TestProjectionStar(t *testing.T) {
+	p, err := projection(t, "*")
+	require.NoError(t, err)
+	assert.Len(t, p.Exprs, 4)
+
+	p, err = projection(t, "u.*, t.b")
+	require.NoError(t, err)
+	require.Len(t, p.Columns, 2)
+	assert.Equal(t, pgtype.Column{Name: "a", TypeOID: pgtype.Int8OID, TypeSize: 8, TypeModifier: -1, TableOID: 2, AttributeNumber: 1}, p.Columns[0])
+	values, err := p.Eval(executor.NewEnv(context.Background()), testRow)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{int64(10), "one"}, values)
+
+	_, err = projection(t, "v.*")
+	assert.EqualError(t, err, `ERROR: missing FROM-clause entry for table "v" (SQLSTATE 42P01)`)
+}
@@ -0,0 +1,159 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package session defines what the server and the handlers executing
+// statements exchange: the result sets handlers return and the client
+// session a statement runs in. The server implements Session, handlers
+// reach it through the context of the statement.
+package session
+
+import (
+	"context"
+	"errors"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+)
+
+// Result is the outcome of executing a single statement
+type Result struct {
+	// Columns is nil for statements which do not return rows
+	Columns []pgtype.Column
+	// Rows streams the rows of the result set, it is closed by the server
+	// once all rows have been sent.
+	Rows Rows
+	// CommandTag is sent in CommandComplete, for example "INSERT 0 1". When
+	// empty it is derived from the statement, the rows sent and
+	// RowsAffected.
+	CommandTag string
+	// RowsAffected is the number of rows inserted, updated or deleted by a
+	// statement which does not return rows
+	RowsAffected int64
+}
+
+// Rows is an iterator over the rows of a result set, it follows the same
+// pattern as database/sql.Rows.
+type Rows interface {
+	// Next advances to the next row and returns false when there are no
+	// more rows or an error occurred.
+	Next() bool
+	// Values returns the values of the current row, one per column.
+	Values() []interface{}
+	// Err returns the error, if any, encountered during iteration.
+	Err() error
+	// Close releases any resources held by the iterator.
+	Close() error
+}
+
+// NewResult builds a Result from rows which are already held in memory
+func NewResult(columns []pgtype.Column, rows [][]interface{}) *Result {
+	return &Result{Columns: columns, Rows: NewRows(rows)}
+}
+
+// NewRows returns an iterator over rows which are already held in memory
+func NewRows(rows [][]interface{}) Rows {
+	return &sliceRows{rows: rows, pos: -1}
+}
+
+type sliceRows struct {
+	rows [][]interface{}
+	pos  int
+}
+
+func (r *sliceRows) Next() bool {
+	if r.pos+1 >= len(r.rows) {
+		return false
+	}
+	r.pos++
+	return true
+}
+
+func (r *sliceRows) Values() []interface{} { return r.rows[r.pos] }
+func (r *sliceRows) Err() error            { return nil }
+func (r *sliceRows) Close() error          { return nil }
+
+// Session is the client connection a statement is executed for
+type Session interface {
+	// SendNotice sends a NoticeResponse to the client right away. Notices
+	// without a severity are sent as NOTICE and without a code as 00000.
+	SendNotice(notice *pgerror.Error) error
+	// Parameter returns the current value of a setting
+	Parameter(name string) (string, bool)
+	// SetParameter changes a setting like the SET command, reported
+	// settings are sent to the client.
+	SetParameter(name, value string) error
+	// Notify sends a notification to the sessions listening on the
+	// channel, like pg_notify.
+	Notify(channel, payload string) error
+}
+
+// ErrNoSession is returned for contexts which do not belong to a client
+// connection
+var ErrNoSession = errors.New("context does not belong to a client connection")
+
+type sessionKey struct{}
+
+// NewContext returns a context carrying the session
+func NewContext(ctx context.Context, s Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// FromContext returns the session the context belongs to
+func FromContext(ctx context.Context) (Session, bool) {
+	s, ok := ctx.Value(sessionKey{}).(Session)
+	return s, ok
+}
+
+// SendNotice sends a notice to the client whose statement is executed with
+// ctx, see Session.SendNotice.
+func SendNotice(ctx context.Context, notice *pgerror.Error) error {
+	s, ok := FromContext(ctx)
+	if !ok {
+		return ErrNoSession
+	}
+	return s.SendNotice(notice)
+}
+
+// Parameter returns the current value of a setting of the session the
+// context belongs to.
+func Parameter(ctx context.Context, name string) (string, bool) {
+	s, ok := FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return s.Parameter(name)
+}
+
+// SetParameter changes a setting of the session the context belongs to,
+// see Session.SetParameter.
+func SetParameter(ctx context.Context, name, value string) error {
+	s, ok := FromContext(ctx)
+	if !ok {
+		return ErrNoSession
+	}
+	return s.SetParameter(name, value)
+}
+
+// Notify sends a notification from the session the context belongs to,
+// see Session.Notify.
+func Notify(ctx context.Context, channel, payload string) error {
+	s, ok := FromContext(ctx)
+	if !ok {
+		return ErrNoSession
+	}
+	return s.Notify(channel, payload)
+}
@@ -0,0 +1,378 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package types
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+)
+
+// CastContext is where a value is converted, it decides which conversions
+// happen without being asked for like in pg_cast
+type CastContext int
+
+const (
+	// CastImplicit converts the operands of expressions, such as an
+	// integer compared with a bigint
+	CastImplicit CastContext = iota
+	// CastAssignment converts values stored into a column
+	CastAssignment
+	// CastExplicit converts values with CAST or ::
+	CastExplicit
+)
+
+// CanCast reports whether values of type from convert to type to in the
+// context
+func CanCast(from, to Type, ctx CastContext) bool {
+	if from.OID == to.OID || from.OID == UnknownOID {
+		return true
+	}
+	cf, ct := from.Category(), to.Category()
+	switch {
+	case cf == CategoryArray && ct == CategoryArray:
+		return CanCast(from.Elem(), to.Elem(), ctx)
+	case ct == CategoryString:
+		// every type has a text representation
+		return cf == CategoryString || ctx != CastImplicit
+	case cf == CategoryString:
+		return ctx == CastExplicit
+	case cf != ct:
+		return ctx == CastExplicit &&
+			(cf == CategoryBool && to.OID == pgtype.Int4OID || ct == CategoryBool && from.OID == pgtype.Int4OID)
+	case cf == CategoryUnknown:
+		return false
+	case ctx == CastImplicit:
+		// only to types which hold every value of the other type
+		return infos[from.OID].rank < infos[to.OID].rank
+	}
+	return true
+}
+
+// Cast converts v of type from to type to like CAST(v AS to), strings
+// too long for the type are truncated.
+func Cast(v interface{}, from, to Type) (interface{}, error) {
+	return convert(v, from, to, true)
+}
+
+// Assign converts v of type from to be stored in a column of type to,
+// strings too long for the type are an error.
+func Assign(v interface{}, from, to Type) (interface{}, error) {
+	return convert(v, from, to, false)
+}
+
+func convert(v interface{}, from, to Type, explicit bool) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	var err error
+	switch {
+	case from.OID == to.OID:
+	case to.IsText():
+		v, err = toText(v, from)
+	case from.IsText():
+		s, ok := v.(string)
+		if !ok {
+			return nil, errCannotCast(from, to)
+		}
+		v, err = fromText(s, to)
+	case from.IsArray() && to.IsArray():
+		elems, ok := v.([]interface{})
+		if !ok {
+			return nil, errCannotCast(from, to)
+		}
+		converted := make([]interface{}, len(elems))
+		for i, e := range elems {
+			if converted[i], err = convert(e, from.Elem(), to.Elem(), explicit); err != nil {
+				return nil, err
+			}
+		}
+		return converted, nil
+	default:
+		v, err = convertValue(v, from, to)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return applyModifier(v, to, explicit)
+}
+
+// errCannotCast is returned for types which do not convert to each other
+func errCannotCast(from, to Type) error {
+	return pgerror.NewError(pgerror.CodeCannotCoerce, "cannot cast type %s to %s", from, to)
+}
+
+// toText returns the text representation of the value
+func toText(v interface{}, from Type) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		if from.OID == pgtype.BPCharOID {
+			// trailing spaces of character are insignificant
+			return strings.TrimRight(v, " "), nil
+		}
+		return v, nil
+	case bool:
+		if v {
+			return "true", nil
+		}
+		return "false", nil
+	}
+	text, err := pgtype.DefaultTypes.Encode(from.OID, v, 0)
+	if err != nil {
+		return nil, err
+	}
+	return string(text), nil
+}
+
+// fromText parses the text representation of a value of type to
+func fromText(s string, to Type) (interface{}, error) {
+	if _, ok := pgtype.DefaultTypes.Lookup(to.OID); !ok {
+		return nil, errCannotCast(Text, to)
+	}
+	v, err := pgtype.DefaultTypes.Decode(to.OID, []byte(s), 0)
+	if err != nil {
+		return nil, err
+	}
+	return Normalize(v), nil
+}
+
+// convertValue converts between types of the same category and between
+// booleans and integers
+func convertValue(v interface{}, from, to Type) (interface{}, error) {
+	switch to.OID {
+	case pgtype.Int2OID, pgtype.Int4OID, pgtype.Int8OID:
+		return toInt(v, to)
+	case pgtype.Float4OID, pgtype.Float8OID:
+		return toFloat(v, to)
+	case pgtype.NumericOID:
+		return toNumeric(v, from)
+	case pgtype.BoolOID:
+		if n, ok := v.(int64); ok {
+			return n != 0, nil
+		}
+	case pgtype.DateOID:
+		if t, ok := v.(time.Time); ok {
+			y, m, d := t.Date()
+			return time.Date(y, m, d, 0, 0, 0, 0, time.UTC), nil
+		}
+	case pgtype.TimestampOID, pgtype.TimestamptzOID:
+		// the session time zone is always UTC so the wall clock is kept
+		if t, ok := v.(time.Time); ok {
+			return t, nil
+		}
+	case pgtype.JSONOID, pgtype.JSONBOID:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	}
+	return nil, errCannotCast(from, to)
+}
+
+// errOutOfRange is returned for integers which do not fit the type
+func errOutOfRange(t Type) error {
+	return pgerror.NewError(pgerror.CodeNumericValueOutOfRange, "%s out of range", t)
+}
+
+// intRange returns the bounds of an integer type
+func intRange(t Type) (int64, int64) {
+	switch t.OID {
+	case pgtype.Int2OID:
+		return math.MinInt16, math.MaxInt16
+	case pgtype.Int4OID:
+		return math.MinInt32, math.MaxInt32
+	}
+	return math.MinInt64, math.MaxInt64
+}
+
+func toInt(v interface{}, to Type) (interface{}, error) {
+	var n int64
+	switch v := v.(type) {
+	case int64:
+		n = v
+	case bool:
+		if v {
+			n = 1
+		}
+	case float64:
+		f := math.RoundToEven(v)
+		// float64(MaxInt64) rounds up so it is already out of range
+		if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return nil, errOutOfRange(to)
+		}
+		n = int64(f)
+	case string:
+		r, ok := ParseNumeric(v)
+		if !ok {
+			return nil, pgerror.NewError(pgerror.CodeFeatureNotSupported, "cannot convert %s to %s", v, to)
+		}
+		i, ok := new(big.Int).SetString(FormatNumeric(r, 0), 10)
+		if !ok || !i.IsInt64() {
+			return nil, errOutOfRange(to)
+		}
+		n = i.Int64()
+	default:
+		return nil, errCannotCast(TypeOf(v), to)
+	}
+	min, max := intRange(to)
+	if n < min || n > max {
+		return nil, errOutOfRange(to)
+	}
+	return n, nil
+}
+
+func toFloat(v interface{}, to Type) (interface{}, error) {
+	var f float64
+	switch v := v.(type) {
+	case int64:
+		f = float64(v)
+	case float64:
+		f = v
+	case string:
+		var err error
+		switch v {
+		case "NaN":
+			f = math.NaN()
+		case "Infinity":
+			f = math.Inf(1)
+		case "-Infinity":
+			f = math.Inf(-1)
+		default:
+			if f, err = strconv.ParseFloat(v, 64); err != nil {
+				return nil, pgerror.NewError(pgerror.CodeNumericValueOutOfRange, "value out of range: overflow")
+			}
+		}
+	default:
+		return nil, errCannotCast(TypeOf(v), to)
+	}
+	if to.OID == pgtype.Float4OID {
+		f32 := float32(f)
+		if math.IsInf(float64(f32), 0) && !math.IsInf(f, 0) {
+			return nil, pgerror.NewError(pgerror.CodeNumericValueOutOfRange, "value out of range: overflow")
+		}
+		f = float64(f32)
+	}
+	return f, nil
+}
+
+func toNumeric(v interface{}, from Type) (interface{}, error) {
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN", nil
+		case math.IsInf(v, 1):
+			return "Infinity", nil
+		case math.IsInf(v, -1):
+			return "-Infinity", nil
+		}
+		bits := 64
+		if from.OID == pgtype.Float4OID {
+			bits = 32
+		}
+		return strconv.FormatFloat(v, 'f', -1, bits), nil
+	}
+	return nil, errCannotCast(from, Numeric)
+}
+
+// applyModifier fits the value to the modifier of the type, such as the
+// length of a varchar or the scale of a numeric
+func applyModifier(v interface{}, t Type, explicit bool) (interface{}, error) {
+	if t.Modifier < 0 {
+		return v, nil
+	}
+	if elems, ok := v.([]interface{}); ok && t.IsArray() {
+		fitted := make([]interface{}, len(elems))
+		for i, e := range elems {
+			var err error
+			if fitted[i], err = applyModifier(e, t.Elem(), explicit); err != nil {
+				return nil, err
+			}
+		}
+		return fitted, nil
+	}
+
+	switch t.OID {
+	case pgtype.VarcharOID, pgtype.BPCharOID:
+		s, ok := v.(string)
+		if !ok {
+			return v, nil
+		}
+		n := t.Length()
+		if utf8.RuneCountInString(s) > n {
+			end := 0
+			for i := 0; i < n; i++ {
+				_, size := utf8.DecodeRuneInString(s[end:])
+				end += size
+			}
+			// only spaces may be cut off unless the cast is explicit
+			if !explicit && strings.TrimRight(s[end:], " ") != "" {
+				return nil, pgerror.NewError(pgerror.CodeStringDataRightTruncation, "value too long for type %s", t)
+			}
+			s = s[:end]
+		}
+		if t.OID == pgtype.BPCharOID {
+			s += strings.Repeat(" ", n-utf8.RuneCountInString(s))
+		}
+		return s, nil
+	case pgtype.NumericOID:
+		s, ok := v.(string)
+		if !ok {
+			return v, nil
+		}
+		r, ok := ParseNumeric(s)
+		if !ok {
+			return v, nil
+		}
+		precision, scale := t.NumericPrecision()
+		s = FormatNumeric(r, scale)
+		digits := strings.TrimLeft(strings.TrimPrefix(s, "-"), "0")
+		if i := strings.IndexByte(digits, '.'); i >= 0 {
+			digits = digits[:i]
+		}
+		if len(digits) > precision-scale {
+			err := pgerror.NewError(pgerror.CodeNumericValueOutOfRange, "numeric field overflow")
+			if precision == scale {
+				err.Detail = fmt.Sprintf("A field with precision %d, scale %d must round to an absolute value less than 1.", precision, scale)
+			} else {
+				err.Detail = fmt.Sprintf("A field with precision %d, scale %d must round to an absolute value less than 10^%d.", precision, scale, precision-scale)
+			}
+			return nil, err
+		}
+		return s, nil
+	case pgtype.TimestampOID, pgtype.TimestamptzOID:
+		ts, ok := v.(time.Time)
+		if !ok {
+			return v, nil
+		}
+		unit := time.Microsecond
+		for i := t.Modifier; i < maxTimestampPrecision; i++ {
+			unit *= 10
+		}
+		return ts.Round(unit), nil
+	}
+	return v, nil
+}
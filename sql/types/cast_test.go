@@ -0,0 +1,123 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanCast(t *testing.T) {
+	intArray, _ := ArrayOf(Int4)
+	textArray, _ := ArrayOf(Text)
+	tests := []struct {
+		from, to Type
+		ctx      CastContext
+		want     bool
+	}{
+		{Int4, Int8, CastImplicit, true},
+		{Int8, Int4, CastImplicit, false},
+		{Int8, Int4, CastAssignment, true},
+		{Unknown, Date, CastImplicit, true},
+		{Int4, Text, CastImplicit, false},
+		{Int4, Text, CastAssignment, true},
+		{Text, Int4, CastAssignment, false},
+		{Text, Int4, CastExplicit, true},
+		{Bool, Int4, CastAssignment, false},
+		{Bool, Int4, CastExplicit, true},
+		{Bool, Int8, CastExplicit, false},
+		{Date, Timestamp, CastImplicit, true},
+		{Timestamptz, Date, CastAssignment, true},
+		{UUID, Bytea, CastExplicit, false},
+		{intArray, textArray, CastAssignment, true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, CanCast(tt.from, tt.to, tt.ctx), "%s to %s in %d", tt.from, tt.to, tt.ctx)
+	}
+}
+
+func TestCast(t *testing.T) {
+	varchar3, err := parseType(t, "varchar(3)")
+	require.NoError(t, err)
+	char3, err := parseType(t, "char(3)")
+	require.NoError(t, err)
+	numeric52, err := parseType(t, "numeric(5,2)")
+	require.NoError(t, err)
+	timestamp0, err := parseType(t, "timestamp(0)")
+	require.NoError(t, err)
+	intArray, _ := ArrayOf(Int4)
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 600000000, time.UTC)
+
+	tests := []struct {
+		v        interface{}
+		from, to Type
+		want     interface{}
+	}{
+		{nil, Int4, Text, nil},
+		{"42", Unknown, Int4, int64(42)},
+		{" 7 ", Text, Int8, int64(7)},
+		{"2021-03-04", Unknown, Date, time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{"{1,NULL,3}", Unknown, intArray, []interface{}{int64(1), nil, int64(3)}},
+		{int64(42), Int4, Text, "42"},
+		{true, Bool, Text, "true"},
+		{"ab ", char3, Text, "ab"},
+		{[]interface{}{int64(1), nil}, intArray, Text, "{1,NULL}"},
+		{int64(3), Int4, Float8, float64(3)},
+		{int64(3), Int4, Numeric, "3"},
+		{2.5, Float8, Int4, int64(2)},
+		{3.5, Float8, Int4, int64(4)},
+		{"2.5", Numeric, Int4, int64(3)},
+		{"-2.5", Numeric, Int4, int64(-3)},
+		{"1.25", Numeric, Float8, 1.25},
+		{0.1, Float8, Numeric, "0.1"},
+		{int64(1), Int4, Bool, true},
+		{"abcdef", Unknown, varchar3, "abc"},
+		{"a", Unknown, char3, "a  "},
+		{"1.005", Unknown, numeric52, "1.01"},
+		{int64(12), Int4, numeric52, "12.00"},
+		{ts, Timestamptz, Date, time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{ts, Timestamptz, timestamp0, ts.Round(time.Second)},
+		{[]interface{}{int64(1), nil}, intArray, Type{pgtype.TextArrayOID, -1}, []interface{}{"1", nil}},
+	}
+	for _, tt := range tests {
+		got, err := Cast(tt.v, tt.from, tt.to)
+		require.NoError(t, err, "%v to %s", tt.v, tt.to)
+		assert.Equal(t, tt.want, got, "%v to %s", tt.v, tt.to)
+	}
+}
+
+func TestCastErrors(t *testing.T) {
+	numeric52, err := parseType(t, "numeric(5,2)")
+	require.NoError(t, err)
+	tests := []struct {
+		v        interface{}
+		from, to Type
+		err      string
+	}{
+		{"abc", Unknown, Int4, `ERROR: invalid input syntax for type integer: "abc" (SQLSTATE 22P02)`},
+		{int64(70000), Int4, Int2, "ERROR: smallint out of range (SQLSTATE 22003)"},
+		{1e20, Float8, Int8, "ERROR: bigint out of range (SQLSTATE 22003)"},
+		{"NaN", Numeric, Int4, "ERROR: cannot convert NaN to integer (SQLSTATE 0A000)"},
+		{"1000", Unknown, numeric52, "ERROR: numeric field overflow (SQLSTATE 22003)"},
+		{1e300, Float8, Float4, "ERROR: value out of range: overflow (SQLSTATE 22003)"},
+		{pgtype.UUID{}, UUID, Int4, "ERROR: cannot cast type uuid to integer (SQLSTATE 42846)"},
+	}
+	for _, tt := range tests {
+		_, err := Cast(tt.v, tt.from, tt.to)
+		assert.EqualError(t, err, tt.err, "%v to %s", tt.v, tt.to)
+	}
+}
+
+func TestAssign(t *testing.T) {
+	varchar3, err := parseType(t, "varchar(3)")
+	require.NoError(t, err)
+
+	v, err := Assign("abc  ", Unknown, varchar3)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", v, "trailing spaces are cut off")
+
+	_, err = Assign("abcd", Unknown, varchar3)
+	assert.EqualError(t, err, "ERROR: value too long for type character varying(3) (SQLSTATE 22001)")
+}
@@ -0,0 +1,239 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package types
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/patrickglass/dsql/sql/pgtype"
+)
+
+// Compare returns -1, 0 or +1 when a is less than, equal to or greater
+// than b. Both values must be non-NULL values of type t.
+func Compare(t Type, a, b interface{}) int {
+	switch a := a.(type) {
+	case bool:
+		if b, ok := b.(bool); ok {
+			switch {
+			case a == b:
+				return 0
+			case b:
+				return -1
+			}
+			return 1
+		}
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return compareInt(a, b)
+		case float64:
+			return compareFloat(float64(a), b)
+		}
+	case float64:
+		switch b := b.(type) {
+		case float64:
+			return compareFloat(a, b)
+		case int64:
+			return compareFloat(a, float64(b))
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			switch t.OID {
+			case pgtype.NumericOID:
+				return compareNumeric(a, b)
+			case pgtype.BPCharOID:
+				return strings.Compare(strings.TrimRight(a, " "), strings.TrimRight(b, " "))
+			}
+			return strings.Compare(a, b)
+		}
+	case []byte:
+		if b, ok := b.([]byte); ok {
+			return bytes.Compare(a, b)
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			switch {
+			case a.Before(b):
+				return -1
+			case a.After(b):
+				return 1
+			}
+			return 0
+		}
+	case pgtype.Interval:
+		if b, ok := b.(pgtype.Interval); ok {
+			return compareInt(intervalMicros(a), intervalMicros(b))
+		}
+	case pgtype.UUID:
+		if b, ok := b.(pgtype.UUID); ok {
+			return bytes.Compare(a[:], b[:])
+		}
+	case []interface{}:
+		if b, ok := b.([]interface{}); ok {
+			return compareArrays(t.Elem(), a, b)
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareFloat orders NaN after every other value like postgres
+func compareFloat(a, b float64) int {
+	switch {
+	case math.IsNaN(a) && math.IsNaN(b):
+		return 0
+	case math.IsNaN(a):
+		return 1
+	case math.IsNaN(b):
+		return -1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareArrays compares the elements in order, NULL elements are greater
+// than any other
+func compareArrays(elem Type, a, b []interface{}) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		switch {
+		case a[i] == nil && b[i] == nil:
+			continue
+		case a[i] == nil:
+			return 1
+		case b[i] == nil:
+			return -1
+		}
+		if c := Compare(elem, a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInt(int64(len(a)), int64(len(b)))
+}
+
+const microsPerDay = 24 * 60 * 60 * 1000000
+
+// intervalMicros returns the length of the interval counting months as 30
+// days, intervals of the same length are equal like in postgres
+func intervalMicros(iv pgtype.Interval) int64 {
+	return (int64(iv.Months)*30+int64(iv.Days))*microsPerDay + iv.Microseconds
+}
+
+// key tags keep values of different kinds apart in AppendKey
+const (
+	keyNull byte = iota
+	keyBool
+	keyInt
+	keyFloat
+	keyString
+	keyTime
+	keyInterval
+	keyUUID
+	keyArray
+	keyOther
+)
+
+// AppendKey appends an encoding of the value to buf which is the same for
+// values that are equal according to Compare. It is used to look values up
+// in hash tables, the encoding does not preserve their order.
+func AppendKey(buf []byte, t Type, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, keyNull)
+	case bool:
+		if v {
+			return append(buf, keyBool, 1)
+		}
+		return append(buf, keyBool, 0)
+	case int64:
+		buf = append(buf, keyInt)
+		return appendUint64(buf, uint64(v))
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			v = math.NaN()
+		case v == 0:
+			// -0 equals 0
+			v = 0
+		}
+		buf = append(buf, keyFloat)
+		return appendUint64(buf, math.Float64bits(v))
+	case string:
+		switch t.OID {
+		case pgtype.NumericOID:
+			if r, ok := ParseNumeric(v); ok {
+				v = r.RatString()
+			}
+		case pgtype.BPCharOID:
+			v = strings.TrimRight(v, " ")
+		}
+		return appendKeyBytes(append(buf, keyString), []byte(v))
+	case []byte:
+		return appendKeyBytes(append(buf, keyString), v)
+	case time.Time:
+		buf = append(buf, keyTime)
+		buf = appendUint64(buf, uint64(v.Unix()))
+		return appendUint64(buf, uint64(v.Nanosecond()))
+	case pgtype.Interval:
+		buf = append(buf, keyInterval)
+		return appendUint64(buf, uint64(intervalMicros(v)))
+	case pgtype.UUID:
+		return append(append(buf, keyUUID), v[:]...)
+	case []interface{}:
+		buf = append(buf, keyArray)
+		buf = appendUvarint(buf, uint64(len(v)))
+		for _, e := range v {
+			buf = AppendKey(buf, t.Elem(), e)
+		}
+		return buf
+	}
+	return appendKeyBytes(append(buf, keyOther), []byte(fmt.Sprint(v)))
+}
+
+// appendKeyBytes appends b prefixed with its length
+func appendKeyBytes(buf, b []byte) []byte {
+	buf = appendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func appendUint64(buf []byte, n uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n)
+	return append(buf, b[:]...)
+}
+
+func appendUvarint(buf []byte, n uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], n)]...)
+}
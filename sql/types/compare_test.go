@@ -0,0 +1,54 @@
+package types
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	intArray, _ := ArrayOf(Int4)
+	tests := []struct {
+		t    Type
+		a, b interface{}
+		want int
+	}{
+		{Bool, false, true, -1},
+		{Int8, int64(2), int64(10), -1},
+		{Float8, math.NaN(), math.Inf(1), 1},
+		{Float8, math.NaN(), math.NaN(), 0},
+		{Text, "b", "ab", 1},
+		{Numeric, "10.0", "9.99", 1},
+		{Numeric, "1.0", "1", 0},
+		{Numeric, "NaN", "Infinity", 1},
+		{Numeric, "-Infinity", "-1e100", -1},
+		{BPChar, "a  ", "a", 0},
+		{Timestamptz, time.Unix(1, 0), time.Unix(2, 0), -1},
+		{Interval, pgtype.Interval{Days: 30}, pgtype.Interval{Months: 1}, 0},
+		{UUID, pgtype.UUID{1}, pgtype.UUID{2}, -1},
+		{intArray, []interface{}{int64(1), nil}, []interface{}{int64(1), int64(2)}, 1},
+		{intArray, []interface{}{int64(1)}, []interface{}{int64(1), int64(2)}, -1},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Compare(tt.t, tt.a, tt.b), "%v and %v", tt.a, tt.b)
+	}
+}
+
+func TestAppendKey(t *testing.T) {
+	key := func(typ Type, v interface{}) string { return string(AppendKey(nil, typ, v)) }
+
+	assert.Equal(t, key(Numeric, "1.50"), key(Numeric, "1.5"))
+	assert.Equal(t, key(Float8, 0.0), key(Float8, math.Copysign(0, -1)))
+	assert.Equal(t, key(BPChar, "a  "), key(BPChar, "a"))
+	assert.Equal(t, key(Interval, pgtype.Interval{Days: 1}), key(Interval, pgtype.Interval{Microseconds: microsPerDay}))
+	assert.NotEqual(t, key(Text, "1"), key(Int8, int64(1)))
+	assert.NotEqual(t, key(Text, ""), key(Text, nil))
+
+	// the length prefix keeps the values of composite keys apart
+	ab := AppendKey(AppendKey(nil, Text, "a"), Text, "bc")
+	abc := AppendKey(AppendKey(nil, Text, "ab"), Text, "c")
+	assert.NotEqual(t, ab, abc)
+}
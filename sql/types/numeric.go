@@ -0,0 +1,76 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package types
+
+import (
+	"math/big"
+	"strings"
+)
+
+// ParseNumeric returns the value of a numeric, ok is false for NaN and the
+// infinities which a big.Rat cannot hold.
+func ParseNumeric(s string) (*big.Rat, bool) {
+	return new(big.Rat).SetString(s)
+}
+
+// NumericScale returns the number of fractional digits of a numeric
+func NumericScale(s string) int {
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		return len(s) - i - 1
+	}
+	return 0
+}
+
+// FormatNumeric formats the value with scale fractional digits, rounding
+// half away from zero like postgres.
+func FormatNumeric(r *big.Rat, scale int) string {
+	s := r.FloatString(scale)
+	if strings.HasPrefix(s, "-") && strings.Trim(s, "-0.") == "" {
+		// negative values rounding to zero lose their sign
+		s = s[1:]
+	}
+	return s
+}
+
+// numericRank orders the special values of numeric around the finite ones
+func numericRank(s string) int {
+	switch s {
+	case "NaN":
+		return 2
+	case "Infinity":
+		return 1
+	case "-Infinity":
+		return -1
+	}
+	return 0
+}
+
+// compareNumeric compares two numerics, NaN is greater than any other
+// value and equal to itself like in postgres.
+func compareNumeric(a, b string) int {
+	ra, rb := numericRank(a), numericRank(b)
+	if ra != 0 || rb != 0 {
+		return compareInt(int64(ra), int64(rb))
+	}
+	x, okx := ParseNumeric(a)
+	y, oky := ParseNumeric(b)
+	if !okx || !oky {
+		return strings.Compare(a, b)
+	}
+	return x.Cmp(y)
+}
@@ -0,0 +1,410 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package types implements the SQL data types of the engine: resolving
+// type names, casting values between types and comparing them.
+//
+// Values are held in a single Go type per SQL type so that they can be
+// compared without conversions: bool, int64 for every integer type,
+// float64 for real and double precision, string for the character types,
+// numeric and json, []byte for bytea, time.Time for date and timestamps,
+// pgtype.Interval, pgtype.UUID and []interface{} for arrays. NULL is nil.
+package types
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+)
+
+// UnknownOID is the type of string literals until their type is inferred
+// from the context they are used in
+const UnknownOID = 705
+
+// Type is a SQL data type
+type Type struct {
+	OID uint32
+	// Modifier is the type modifier like postgres' typmod, such as the
+	// length of a varchar, -1 when the type has none
+	Modifier int32
+}
+
+// The built in types without modifier
+var (
+	Unknown     = Type{UnknownOID, -1}
+	Bool        = Type{pgtype.BoolOID, -1}
+	Bytea       = Type{pgtype.ByteaOID, -1}
+	Name        = Type{pgtype.NameOID, -1}
+	Int2        = Type{pgtype.Int2OID, -1}
+	Int4        = Type{pgtype.Int4OID, -1}
+	Int8        = Type{pgtype.Int8OID, -1}
+	Float4      = Type{pgtype.Float4OID, -1}
+	Float8      = Type{pgtype.Float8OID, -1}
+	Numeric     = Type{pgtype.NumericOID, -1}
+	Text        = Type{pgtype.TextOID, -1}
+	Varchar     = Type{pgtype.VarcharOID, -1}
+	BPChar      = Type{pgtype.BPCharOID, -1}
+	Date        = Type{pgtype.DateOID, -1}
+	Timestamp   = Type{pgtype.TimestampOID, -1}
+	Timestamptz = Type{pgtype.TimestamptzOID, -1}
+	Interval    = Type{pgtype.IntervalOID, -1}
+	UUID        = Type{pgtype.UUIDOID, -1}
+	JSON        = Type{pgtype.JSONOID, -1}
+	JSONB       = Type{pgtype.JSONBOID, -1}
+)
+
+// Category groups the types whose values can be converted into each other
+// without going through their text representation
+type Category int
+
+const (
+	CategoryUnknown Category = iota
+	CategoryBool
+	CategoryNumeric
+	CategoryString
+	CategoryDateTime
+	CategoryTimespan
+	CategoryUUID
+	CategoryJSON
+	CategoryBytea
+	CategoryArray
+)
+
+// info describes a built in type
+type info struct {
+	// sqlName is the name used in messages, like format_type
+	sqlName  string
+	category Category
+	// rank orders the types of a category, values are converted to the
+	// type of higher rank when types are mixed
+	rank    int
+	arrayOf uint32
+}
+
+var infos = map[uint32]*info{
+	UnknownOID:            {"unknown", CategoryUnknown, 0, 0},
+	pgtype.BoolOID:        {"boolean", CategoryBool, 0, pgtype.BoolArrayOID},
+	pgtype.ByteaOID:       {"bytea", CategoryBytea, 0, pgtype.ByteaArrayOID},
+	pgtype.NameOID:        {"name", CategoryString, 0, pgtype.NameArrayOID},
+	pgtype.Int2OID:        {"smallint", CategoryNumeric, 1, pgtype.Int2ArrayOID},
+	pgtype.Int4OID:        {"integer", CategoryNumeric, 2, pgtype.Int4ArrayOID},
+	pgtype.Int8OID:        {"bigint", CategoryNumeric, 3, pgtype.Int8ArrayOID},
+	pgtype.NumericOID:     {"numeric", CategoryNumeric, 4, pgtype.NumericArrayOID},
+	pgtype.Float4OID:      {"real", CategoryNumeric, 5, pgtype.Float4ArrayOID},
+	pgtype.Float8OID:      {"double precision", CategoryNumeric, 6, pgtype.Float8ArrayOID},
+	pgtype.BPCharOID:      {"character", CategoryString, 1, pgtype.BPCharArrayOID},
+	pgtype.VarcharOID:     {"character varying", CategoryString, 2, pgtype.VarcharArrayOID},
+	pgtype.TextOID:        {"text", CategoryString, 3, pgtype.TextArrayOID},
+	pgtype.DateOID:        {"date", CategoryDateTime, 1, pgtype.DateArrayOID},
+	pgtype.TimestampOID:   {"timestamp without time zone", CategoryDateTime, 2, pgtype.TimestampArrayOID},
+	pgtype.TimestamptzOID: {"timestamp with time zone", CategoryDateTime, 3, pgtype.TimestamptzArrayOID},
+	pgtype.IntervalOID:    {"interval", CategoryTimespan, 0, pgtype.IntervalArrayOID},
+	pgtype.UUIDOID:        {"uuid", CategoryUUID, 0, pgtype.UUIDArrayOID},
+	pgtype.JSONOID:        {"json", CategoryJSON, 1, pgtype.JSONArrayOID},
+	pgtype.JSONBOID:       {"jsonb", CategoryJSON, 2, pgtype.JSONBArrayOID},
+}
+
+// elemOf maps the OIDs of the array types to their element type
+var elemOf = map[uint32]uint32{}
+
+func init() {
+	for oid, i := range infos {
+		if i.arrayOf != 0 {
+			elemOf[i.arrayOf] = oid
+		}
+	}
+}
+
+// ArrayOf returns the type of arrays of t, ok is false for types which
+// have no array type.
+func ArrayOf(t Type) (Type, bool) {
+	i, ok := infos[t.OID]
+	if !ok || i.arrayOf == 0 {
+		return Type{}, false
+	}
+	return Type{i.arrayOf, t.Modifier}, true
+}
+
+// IsArray reports whether t is an array type
+func (t Type) IsArray() bool {
+	_, ok := elemOf[t.OID]
+	return ok
+}
+
+// Elem returns the element type of an array type
+func (t Type) Elem() Type {
+	return Type{elemOf[t.OID], t.Modifier}
+}
+
+// Category returns the category of the type
+func (t Type) Category() Category {
+	if t.IsArray() {
+		return CategoryArray
+	}
+	if i, ok := infos[t.OID]; ok {
+		return i.category
+	}
+	return CategoryUnknown
+}
+
+// Name returns the name of the type in pg_type, such as int4
+func (t Type) Name() string {
+	if typ, ok := pgtype.DefaultTypes.Lookup(t.OID); ok {
+		return typ.Name
+	}
+	return "unknown"
+}
+
+// String returns the name of the type with its modifier like postgres'
+// format_type, such as character varying(10)
+func (t Type) String() string {
+	if t.IsArray() {
+		return t.Elem().String() + "[]"
+	}
+	i, ok := infos[t.OID]
+	if !ok {
+		return fmt.Sprintf("oid %d", t.OID)
+	}
+	if t.Modifier < 0 {
+		return i.sqlName
+	}
+	switch t.OID {
+	case pgtype.VarcharOID, pgtype.BPCharOID:
+		return fmt.Sprintf("%s(%d)", i.sqlName, t.Modifier-varHeaderSize)
+	case pgtype.NumericOID:
+		precision, scale := t.NumericPrecision()
+		return fmt.Sprintf("numeric(%d,%d)", precision, scale)
+	case pgtype.TimestampOID:
+		return fmt.Sprintf("timestamp(%d) without time zone", t.Modifier)
+	case pgtype.TimestamptzOID:
+		return fmt.Sprintf("timestamp(%d) with time zone", t.Modifier)
+	}
+	return i.sqlName
+}
+
+// varHeaderSize is added to the length of character types and the
+// precision of numeric in their modifier, like VARHDRSZ of postgres
+const varHeaderSize = 4
+
+// Length returns the maximum number of characters of a varchar or bpchar,
+// -1 when unlimited
+func (t Type) Length() int {
+	if t.Modifier < 0 {
+		return -1
+	}
+	return int(t.Modifier - varHeaderSize)
+}
+
+// NumericPrecision returns the precision and scale of a numeric, -1 when
+// unconstrained
+func (t Type) NumericPrecision() (int, int) {
+	if t.Modifier < 0 {
+		return -1, -1
+	}
+	m := t.Modifier - varHeaderSize
+	return int(m >> 16), int(m & 0xffff)
+}
+
+// Column returns the description of a result column of the type
+func (t Type) Column(name string) pgtype.Column {
+	if t.OID == UnknownOID {
+		// unknown literals are returned as text like postgres does
+		t = Text
+	}
+	c := pgtype.NewColumn(name, t.OID)
+	c.TypeModifier = t.Modifier
+	return c
+}
+
+// maxTimestampPrecision is the number of fractional digits of timestamps
+const maxTimestampPrecision = 6
+
+// FromName resolves a type name of the parser, serial types resolve to
+// the integer type they are based on.
+func FromName(name *parser.TypeName) (Type, error) {
+	typeName := name.Name
+	switch typeName {
+	case "serial", "serial4":
+		typeName = "int4"
+	case "bigserial", "serial8":
+		typeName = "int8"
+	case "smallserial", "serial2":
+		typeName = "int2"
+	}
+	typ, ok := pgtype.DefaultTypes.LookupName(typeName)
+	if !ok || typ.Name[0] == '_' {
+		return Type{}, pgerror.NewError(pgerror.CodeUndefinedObject, "type \"%s\" does not exist", name.Name)
+	}
+	t := Type{OID: typ.OID, Modifier: -1}
+	if _, ok := infos[t.OID]; !ok {
+		return Type{}, pgerror.NewError(pgerror.CodeUndefinedObject, "type \"%s\" does not exist", name.Name)
+	}
+
+	mods := name.Modifiers
+	switch {
+	case len(mods) == 0:
+	case t.OID == pgtype.VarcharOID || t.OID == pgtype.BPCharOID:
+		if len(mods) != 1 {
+			return Type{}, pgerror.NewError(pgerror.CodeSyntaxError, "invalid type modifier")
+		}
+		if mods[0] < 1 {
+			return Type{}, pgerror.NewError(pgerror.CodeInvalidParameterValue, "length for type %s must be at least 1", typ.Name)
+		}
+		t.Modifier = int32(mods[0] + varHeaderSize)
+	case t.OID == pgtype.NumericOID:
+		if len(mods) > 2 {
+			return Type{}, pgerror.NewError(pgerror.CodeSyntaxError, "invalid NUMERIC type modifier")
+		}
+		precision, scale := mods[0], 0
+		if len(mods) == 2 {
+			scale = mods[1]
+		}
+		if precision < 1 || precision > 1000 {
+			return Type{}, pgerror.NewError(pgerror.CodeInvalidParameterValue, "NUMERIC precision %d must be between 1 and 1000", precision)
+		}
+		if scale < 0 || scale > precision {
+			return Type{}, pgerror.NewError(pgerror.CodeInvalidParameterValue, "NUMERIC scale %d must be between 0 and precision %d", scale, precision)
+		}
+		t.Modifier = int32(precision<<16|scale) + varHeaderSize
+	case t.OID == pgtype.TimestampOID || t.OID == pgtype.TimestamptzOID:
+		if len(mods) != 1 || mods[0] < 0 {
+			return Type{}, pgerror.NewError(pgerror.CodeInvalidParameterValue, "TIMESTAMP precision must not be negative")
+		}
+		t.Modifier = int32(mods[0])
+		if mods[0] > maxTimestampPrecision {
+			t.Modifier = maxTimestampPrecision
+		}
+	default:
+		return Type{}, pgerror.NewError(pgerror.CodeSyntaxError, "type modifier is not allowed for type \"%s\"", typ.Name)
+	}
+
+	if name.Array {
+		array, ok := ArrayOf(t)
+		if !ok {
+			return Type{}, pgerror.NewError(pgerror.CodeUndefinedObject, "type \"%s[]\" does not exist", name.Name)
+		}
+		return array, nil
+	}
+	return t, nil
+}
+
+// TypeOf returns the type of a Go value, such as a parameter decoded by the
+// server. Strings are of unknown type as they are converted to the type
+// they are compared with or assigned to.
+func TypeOf(v interface{}) Type {
+	switch v := v.(type) {
+	case bool:
+		return Bool
+	case int16:
+		return Int2
+	case int32:
+		return Int4
+	case int, int64:
+		return Int8
+	case float32:
+		return Float4
+	case float64:
+		return Float8
+	case []byte:
+		return Bytea
+	case time.Time:
+		return Timestamptz
+	case pgtype.Interval:
+		return Interval
+	case pgtype.UUID:
+		return UUID
+	case []interface{}:
+		for _, e := range v {
+			if e != nil {
+				if t, ok := ArrayOf(TypeOf(e)); ok {
+					return t
+				}
+			}
+		}
+		t, _ := ArrayOf(Text)
+		return t
+	}
+	return Unknown
+}
+
+// Normalize converts a Go value into the representation used for its type,
+// such as int32 to int64.
+func Normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case []interface{}:
+		elems := make([]interface{}, len(v))
+		for i, e := range v {
+			elems[i] = Normalize(e)
+		}
+		return elems
+	}
+	return v
+}
+
+// Common returns the type values of both types are converted to when they
+// are compared or combined, like the branches of a CASE. ok is false when
+// the types cannot be mixed.
+func Common(a, b Type) (Type, bool) {
+	switch {
+	case a.OID == b.OID:
+		if a.Modifier != b.Modifier {
+			a.Modifier = -1
+		}
+		return a, true
+	case a.OID == UnknownOID:
+		return b, true
+	case b.OID == UnknownOID:
+		return a, true
+	}
+	ca, cb := a.Category(), b.Category()
+	if ca != cb {
+		return Type{}, false
+	}
+	if ca == CategoryArray {
+		elem, ok := Common(a.Elem(), b.Elem())
+		if !ok {
+			return Type{}, false
+		}
+		return ArrayOf(elem)
+	}
+	ia, ib := infos[a.OID], infos[b.OID]
+	if ia.rank == ib.rank {
+		return Type{}, false
+	}
+	if ia.rank < ib.rank {
+		a = b
+	}
+	a.Modifier = -1
+	return a, true
+}
+
+// IsText reports whether t holds its values as string and compares them
+// as text
+func (t Type) IsText() bool {
+	return t.Category() == CategoryString || t.OID == UnknownOID
+}
@@ -0,0 +1,103 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/patrickglass/dsql/sql/parser"
+	"github.com/patrickglass/dsql/sql/pgerror"
+	"github.com/patrickglass/dsql/sql/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parseType resolves the type name of a cast
+func parseType(t *testing.T, name string) (Type, error) {
+	expr, err := parser.ParseExpr("NULL::" + name)
+	require.NoError(t, err, name)
+	return FromName(expr.(*parser.CastExpr).Type)
+}
+
+func TestFromName(t *testing.T) {
+	tests := map[string]string{
+		"int":                      "integer",
+		"serial":                   "integer",
+		"bigserial":                "bigint",
+		"double precision":         "double precision",
+		"float(10)":                "real",
+		"varchar(10)":              "character varying(10)",
+		"char(3)":                  "character(3)",
+		"numeric(10, 2)":           "numeric(10,2)",
+		"decimal(5)":               "numeric(5,0)",
+		"timestamp(3)":             "timestamp(3) without time zone",
+		"timestamp with time zone": "timestamp with time zone",
+		"text[]":                   "text[]",
+		"int array":                "integer[]",
+		"jsonb":                    "jsonb",
+	}
+	for name, want := range tests {
+		typ, err := parseType(t, name)
+		require.NoError(t, err, name)
+		assert.Equal(t, want, typ.String(), name)
+	}
+
+	_, err := parseType(t, "widget")
+	assert.Equal(t, &pgerror.Error{Severity: "ERROR", Code: pgerror.CodeUndefinedObject, Message: `type "widget" does not exist`}, err)
+	_, err = parseType(t, "varchar(0)")
+	assert.EqualError(t, err, "ERROR: length for type varchar must be at least 1 (SQLSTATE 22023)")
+	_, err = parseType(t, "numeric(2, 3)")
+	assert.EqualError(t, err, "ERROR: NUMERIC scale 3 must be between 0 and precision 2 (SQLSTATE 22023)")
+	_, err = parseType(t, "int(3)")
+	assert.Error(t, err)
+}
+
+func TestArrayOf(t *testing.T) {
+	array, ok := ArrayOf(Int4)
+	require.True(t, ok)
+	assert.Equal(t, Type{pgtype.Int4ArrayOID, -1}, array)
+	assert.True(t, array.IsArray())
+	assert.Equal(t, Int4, array.Elem())
+	assert.Equal(t, CategoryArray, array.Category())
+	assert.Equal(t, "_int4", array.Name())
+
+	_, ok = ArrayOf(Unknown)
+	assert.False(t, ok)
+}
+
+func TestColumn(t *testing.T) {
+	varchar, err := parseType(t, "varchar(20)")
+	require.NoError(t, err)
+	assert.Equal(t, pgtype.Column{Name: "a", TypeOID: pgtype.VarcharOID, TypeSize: -1, TypeModifier: 24}, varchar.Column("a"))
+	assert.Equal(t, pgtype.NewColumn("b", pgtype.TextOID), Unknown.Column("b"))
+}
+
+func TestTypeOf(t *testing.T) {
+	assert.Equal(t, Int4, TypeOf(int32(1)))
+	assert.Equal(t, Int8, TypeOf(int64(1)))
+	assert.Equal(t, Float8, TypeOf(1.5))
+	assert.Equal(t, Unknown, TypeOf("x"))
+	assert.Equal(t, Type{pgtype.Int2ArrayOID, -1}, TypeOf([]interface{}{nil, int16(1)}))
+	assert.Equal(t, []interface{}{int64(1), nil, 2.5}, Normalize([]interface{}{int32(1), nil, float32(2.5)}))
+}
+
+func TestCommon(t *testing.T) {
+	tests := []struct {
+		a, b Type
+		want Type
+		ok   bool
+	}{
+		{Int4, Int8, Int8, true},
+		{Int8, Numeric, Numeric, true},
+		{Numeric, Float4, Float4, true},
+		{Unknown, Date, Date, true},
+		{Text, Unknown, Text, true},
+		{Varchar, Text, Text, true},
+		{Date, Timestamptz, Timestamptz, true},
+		{Int4, Text, Type{}, false},
+		{Bool, Int4, Type{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := Common(tt.a, tt.b)
+		assert.Equal(t, tt.ok, ok, "%s and %s", tt.a, tt.b)
+		assert.Equal(t, tt.want, got, "%s and %s", tt.a, tt.b)
+	}
+}